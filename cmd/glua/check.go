@@ -0,0 +1,98 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/Azure/golua/lua/ast"
+	"github.com/Azure/golua/lua/check"
+)
+
+const checkUsage = `usage: glua check [path ...]
+
+Check type checks Lua source files using the annotations found in their
+comments (---@param, ---@return, ---@class, ---@field and ---@type). The
+files are checked together, so that classes and global functions
+declared in one can be used in the others. Without paths it checks the
+standard input; directories are processed recursively for files ending
+in .lua. The exit status is 1 if type errors were found.
+`
+
+// checkMain runs "glua check" with the given arguments, returning the exit
+// status.
+func checkMain(args []string) int {
+	flags := flag.NewFlagSet("check", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprint(os.Stderr, checkUsage)
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	var (
+		chunks []*ast.Chunk
+		status int
+	)
+	report := func(err error) {
+		fmt.Fprintln(os.Stderr, err)
+		status = 2
+	}
+	parse := func(chunkname string, src []byte) {
+		if len(src) > 0 && src[0] == '#' {
+			// Blank out the first line (as in "#!/usr/bin/env glua").
+			src = append([]byte(nil), src...)
+			for i := 0; i < len(src) && src[i] != '\n'; i++ {
+				src[i] = ' '
+			}
+		}
+		chunk, err := ast.Parse(chunkname, src)
+		if err != nil {
+			report(err)
+			return
+		}
+		chunks = append(chunks, chunk)
+	}
+	file := func(path string) {
+		if src, err := ioutil.ReadFile(path); err != nil {
+			report(err)
+		} else {
+			parse("@"+path, src)
+		}
+	}
+	if flags.NArg() == 0 {
+		if src, err := ioutil.ReadAll(os.Stdin); err != nil {
+			report(err)
+		} else {
+			parse("=stdin", src)
+		}
+	}
+	for _, path := range flags.Args() {
+		switch info, err := os.Stat(path); {
+		case err != nil:
+			report(err)
+		case info.IsDir():
+			err := filepath.Walk(path, func(path string, info os.FileInfo, err error) error {
+				if err == nil && !info.IsDir() && strings.HasSuffix(path, ".lua") {
+					file(path)
+				}
+				return err
+			})
+			if err != nil {
+				report(err)
+			}
+		default:
+			file(path)
+		}
+	}
+	diags := check.Check(chunks...)
+	for _, d := range diags {
+		fmt.Println(d)
+	}
+	if status == 0 && len(diags) > 0 {
+		return 1
+	}
+	return status
+}
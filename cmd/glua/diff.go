@@ -0,0 +1,200 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// diffContext is the number of unchanged lines shown around changes.
+const diffContext = 3
+
+// opKind is the kind of an edit operation of a diff.
+type opKind byte
+
+const (
+	opEqual  opKind = ' '
+	opDelete opKind = '-'
+	opInsert opKind = '+'
+)
+
+// edit is a line kept, deleted from the old text or inserted from the
+// new one.
+type edit struct {
+	kind opKind
+	text string
+}
+
+// diff returns the differences between old and new in unified format,
+// labelling the files name.orig and name, or nil if they are the same.
+func diff(name string, old, new []byte) []byte {
+	if bytes.Equal(old, new) {
+		return nil
+	}
+	edits := editScript(splitLines(old), splitLines(new))
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "diff -u %s.orig %s\n", name, name)
+	fmt.Fprintf(&buf, "--- %s.orig\n", name)
+	fmt.Fprintf(&buf, "+++ %s\n", name)
+	for i := 0; i < len(edits); {
+		if edits[i].kind == opEqual {
+			i++
+			continue
+		}
+		// Extend the hunk while changes are close enough to share context.
+		start, end := i-diffContext, i
+		for j := i; j < len(edits) && j <= end+2*diffContext; j++ {
+			if edits[j].kind != opEqual {
+				end = j
+			}
+		}
+		if start < 0 {
+			start = 0
+		}
+		stop := end + 1 + diffContext
+		if stop > len(edits) {
+			stop = len(edits)
+		}
+		writeHunk(&buf, edits, start, stop)
+		i = stop
+	}
+	return buf.Bytes()
+}
+
+// writeHunk writes the hunk of edits from start up to stop.
+func writeHunk(buf *bytes.Buffer, edits []edit, start, stop int) {
+	// Line numbers (starting at 1) of the hunk in the old and new text.
+	line1, line2 := 1, 1
+	for _, e := range edits[:start] {
+		if e.kind != opInsert {
+			line1++
+		}
+		if e.kind != opDelete {
+			line2++
+		}
+	}
+	var len1, len2 int
+	for _, e := range edits[start:stop] {
+		if e.kind != opInsert {
+			len1++
+		}
+		if e.kind != opDelete {
+			len2++
+		}
+	}
+	fmt.Fprintf(buf, "@@ -%s +%s @@\n", hunkRange(line1, len1), hunkRange(line2, len2))
+	for _, e := range edits[start:stop] {
+		buf.WriteByte(byte(e.kind))
+		buf.WriteString(e.text)
+		if !strings.HasSuffix(e.text, "\n") {
+			buf.WriteString("\n\\ No newline at end of file\n")
+		}
+	}
+}
+
+// hunkRange formats the range of a hunk of n lines starting at line.
+func hunkRange(line, n int) string {
+	switch n {
+	case 0:
+		return fmt.Sprintf("%d,0", line-1)
+	case 1:
+		return fmt.Sprintf("%d", line)
+	}
+	return fmt.Sprintf("%d,%d", line, n)
+}
+
+// splitLines splits text after each newline.
+func splitLines(text []byte) []string {
+	lines := strings.SplitAfter(string(text), "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// editScript returns the shortest sequence of edits turning the lines a
+// into the lines b, computed with the Myers algorithm.
+func editScript(a, b []string) []edit {
+	// Common prefix and suffix.
+	var head, tail []edit
+	for len(a) > 0 && len(b) > 0 && a[0] == b[0] {
+		head = append(head, edit{opEqual, a[0]})
+		a, b = a[1:], b[1:]
+	}
+	for len(a) > 0 && len(b) > 0 && a[len(a)-1] == b[len(b)-1] {
+		tail = append(tail, edit{opEqual, a[len(a)-1]})
+		a, b = a[:len(a)-1], b[:len(b)-1]
+	}
+
+	// trace[d] holds the furthest x reached on each diagonal k, for
+	// -d <= k <= d, after d edits.
+	var (
+		n, m  = len(a), len(b)
+		v     = map[int]int{1: 0}
+		trace []map[int]int
+	)
+search:
+	for d := 0; d <= n+m; d++ {
+		next := make(map[int]int, 2*d+1)
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || k != d && v[k-1] < v[k+1] {
+				x = v[k+1] // insertion
+			} else {
+				x = v[k-1] + 1 // deletion
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x, y = x+1, y+1
+			}
+			next[k] = x
+			if x >= n && y >= m {
+				trace = append(trace, next)
+				break search
+			}
+		}
+		trace = append(trace, next)
+		v = next
+	}
+
+	// Walk back from the end to recover the edits, in reverse order.
+	var edits []edit
+	x, y := n, m
+	for d := len(trace) - 1; d > 0; d-- {
+		var (
+			v    = trace[d-1]
+			k    = x - y
+			prev int
+		)
+		if k == -d || k != d && v[k-1] < v[k+1] {
+			prev = k + 1
+		} else {
+			prev = k - 1
+		}
+		px := v[prev]
+		py := px - prev
+		for x > px && y > py {
+			x, y = x-1, y-1
+			edits = append(edits, edit{opEqual, a[x]})
+		}
+		if x == px {
+			y--
+			edits = append(edits, edit{opInsert, b[y]})
+		} else {
+			x--
+			edits = append(edits, edit{opDelete, a[x]})
+		}
+	}
+	for x > 0 && y > 0 {
+		x, y = x-1, y-1
+		edits = append(edits, edit{opEqual, a[x]})
+	}
+	for i, j := 0, len(edits)-1; i < j; i, j = i+1, j-1 {
+		edits[i], edits[j] = edits[j], edits[i]
+	}
+	for i, j := 0, len(tail)-1; i < j; i, j = i+1, j-1 {
+		tail[i], tail[j] = tail[j], tail[i]
+	}
+	return append(append(head, edits...), tail...)
+}
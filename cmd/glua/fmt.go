@@ -0,0 +1,133 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/Azure/golua/lua/format"
+)
+
+const fmtUsage = `usage: glua fmt [-l] [-w] [-d] [path ...]
+
+Fmt formats Lua 5.3 source files in canonical style. Without paths it
+formats the standard input; directories are processed recursively for
+files ending in .lua. By default the formatted sources are printed on
+the standard output.
+`
+
+// fmtMain runs "glua fmt" with the given arguments, returning the exit
+// status.
+func fmtMain(args []string) int {
+	var (
+		flags = flag.NewFlagSet("fmt", flag.ExitOnError)
+		list  = flags.Bool("l", false, "list files whose formatting differs from glua fmt's")
+		write = flags.Bool("w", false, "write result to (source) file instead of stdout")
+		diffs = flags.Bool("d", false, "display diffs instead of rewriting files")
+	)
+	flags.Usage = func() {
+		fmt.Fprint(os.Stderr, fmtUsage)
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	f := &formatter{list: *list, write: *write, diff: *diffs}
+	if flags.NArg() == 0 {
+		if f.write {
+			fmt.Fprintln(os.Stderr, "glua fmt: cannot use -w with standard input")
+			return 2
+		}
+		src, err := ioutil.ReadAll(os.Stdin)
+		if err != nil {
+			f.report(err)
+		} else {
+			f.process("<standard input>", "=stdin", src, 0)
+		}
+		return f.status
+	}
+	for _, path := range flags.Args() {
+		switch info, err := os.Stat(path); {
+		case err != nil:
+			f.report(err)
+		case info.IsDir():
+			f.walk(path)
+		default:
+			f.file(path)
+		}
+	}
+	return f.status
+}
+
+// formatter formats files according to the "glua fmt" flags.
+type formatter struct {
+	list   bool // -l
+	write  bool // -w
+	diff   bool // -d
+	status int  // exit status
+}
+
+// report prints err and sets the exit status.
+func (f *formatter) report(err error) {
+	fmt.Fprintln(os.Stderr, err)
+	f.status = 2
+}
+
+// walk formats the Lua files in the directory tree rooted at dir.
+func (f *formatter) walk(dir string) {
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err == nil && !info.IsDir() && strings.HasSuffix(path, ".lua") {
+			f.file(path)
+		}
+		return err
+	})
+	if err != nil {
+		f.report(err)
+	}
+}
+
+// file formats the file at path.
+func (f *formatter) file(path string) {
+	info, err := os.Stat(path)
+	if err != nil {
+		f.report(err)
+		return
+	}
+	src, err := ioutil.ReadFile(path)
+	if err != nil {
+		f.report(err)
+		return
+	}
+	f.process(path, "@"+path, src, info.Mode().Perm())
+}
+
+// process formats the source src of the file named name.
+func (f *formatter) process(name, chunkname string, src []byte, perm os.FileMode) {
+	res, err := format.Source(chunkname, src)
+	if err != nil {
+		f.report(err)
+		return
+	}
+	if !f.list && !f.write && !f.diff {
+		os.Stdout.Write(res)
+		return
+	}
+	if bytes.Equal(src, res) {
+		return
+	}
+	if f.list {
+		fmt.Println(name)
+	}
+	if f.write {
+		if err := ioutil.WriteFile(name, res, perm); err != nil {
+			f.report(err)
+			return
+		}
+	}
+	if f.diff {
+		os.Stdout.Write(diff(name, src, res))
+	}
+}
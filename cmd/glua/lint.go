@@ -0,0 +1,144 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/Azure/golua/lua/lint"
+	"github.com/Azure/golua/std"
+)
+
+const lintUsage = `usage: glua lint [-json] [-globals names] [path ...]
+
+Lint reports accesses to undefined globals, unused variables and
+parameters, shadowed locals, unreachable code and calls to standard
+library functions with a wrong number of arguments. Without paths it
+checks the standard input; directories are processed recursively for
+files ending in .lua.
+
+The allowed globals are those installed by the standard libraries
+(including the Lua 5.1 ones with glua -compat51) and the names given
+with -globals. The exit status is 1 if problems were found.
+`
+
+// lintMain runs "glua lint" with the given arguments, returning the exit
+// status.
+func lintMain(args []string) int {
+	var (
+		flags   = flag.NewFlagSet("lint", flag.ExitOnError)
+		asJSON  = flags.Bool("json", false, "print problems as a JSON array")
+		globals = flags.String("globals", "", "comma-separated list of additional allowed globals")
+	)
+	flags.Usage = func() {
+		fmt.Fprint(os.Stderr, lintUsage)
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	l := &linter{cfg: lint.Default(std.WithCompat51(lua51)), problems: []problem{}}
+	for _, name := range strings.Split(*globals, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			l.cfg.Globals[name] = true
+		}
+	}
+	if flags.NArg() == 0 {
+		if src, err := ioutil.ReadAll(os.Stdin); err != nil {
+			l.report(err)
+		} else {
+			l.process("<standard input>", "=stdin", src)
+		}
+	}
+	for _, path := range flags.Args() {
+		switch info, err := os.Stat(path); {
+		case err != nil:
+			l.report(err)
+		case info.IsDir():
+			l.walk(path)
+		default:
+			l.file(path)
+		}
+	}
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetEscapeHTML(false)
+		enc.SetIndent("", "\t")
+		must(enc.Encode(l.problems))
+	} else {
+		for _, p := range l.problems {
+			fmt.Printf("%s:%d:%d: %s (%s)\n", p.File, p.Line, p.Column, p.Message, p.Check)
+		}
+	}
+	if l.status == 0 && len(l.problems) > 0 {
+		return 1
+	}
+	return l.status
+}
+
+// problem is a problem reported by "glua lint".
+type problem struct {
+	File    string `json:"file"`
+	Line    int    `json:"line"`
+	Column  int    `json:"column"`
+	Check   string `json:"check"`
+	Message string `json:"message"`
+}
+
+// linter checks files according to the "glua lint" flags.
+type linter struct {
+	cfg      *lint.Config
+	problems []problem
+	status   int // exit status
+}
+
+// report prints err and sets the exit status.
+func (l *linter) report(err error) {
+	fmt.Fprintln(os.Stderr, err)
+	l.status = 2
+}
+
+// walk checks the Lua files in the directory tree rooted at dir.
+func (l *linter) walk(dir string) {
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err == nil && !info.IsDir() && strings.HasSuffix(path, ".lua") {
+			l.file(path)
+		}
+		return err
+	})
+	if err != nil {
+		l.report(err)
+	}
+}
+
+// file checks the file at path.
+func (l *linter) file(path string) {
+	src, err := ioutil.ReadFile(path)
+	if err != nil {
+		l.report(err)
+		return
+	}
+	l.process(path, "@"+path, src)
+}
+
+// process checks the source src of the file named name.
+func (l *linter) process(name, chunkname string, src []byte) {
+	if len(src) > 0 && src[0] == '#' {
+		// Blank out the first line (as in "#!/usr/bin/env glua").
+		src = append([]byte(nil), src...)
+		for i := 0; i < len(src) && src[i] != '\n'; i++ {
+			src[i] = ' '
+		}
+	}
+	problems, err := lint.Source(chunkname, src, l.cfg)
+	if err != nil {
+		l.report(err)
+		return
+	}
+	for _, p := range problems {
+		l.problems = append(l.problems, problem{name, p.Pos.Line, p.Pos.Column, p.Check.String(), p.Message})
+	}
+}
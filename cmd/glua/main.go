@@ -13,6 +13,10 @@ var (
 	trace bool = false
 	debug bool = false
 	tests bool = false
+	lua54 bool = false
+	lua51 bool = false
+	optim bool = false
+	cache string
 )
 
 func must(err error) {
@@ -26,6 +30,10 @@ func init() {
 	flag.BoolVar(&debug, "debug", debug, "enable verbose logging")
 	flag.BoolVar(&trace, "trace", trace, "enable tracing")
 	flag.BoolVar(&tests, "tests", trace, "execute tests")
+	flag.BoolVar(&lua54, "54", lua54, "run scripts as Lua 5.4")
+	flag.BoolVar(&optim, "O", optim, "optimize bytecode")
+	flag.BoolVar(&lua51, "compat51", lua51, "enable Lua 5.1 compatibility functions")
+	flag.StringVar(&cache, "cache", cache, "cache compiled chunks in `dir`")
 	flag.Parse()
 }
 
@@ -33,11 +41,26 @@ func main() {
 	if flag.NArg() < 1 {
 		must(fmt.Errorf("missing arguments"))
 	}
+	if flag.Arg(0) == "fmt" {
+		os.Exit(fmtMain(flag.Args()[1:]))
+	}
+	if flag.Arg(0) == "lint" {
+		os.Exit(lintMain(flag.Args()[1:]))
+	}
+	if flag.Arg(0) == "check" {
+		os.Exit(checkMain(flag.Args()[1:]))
+	}
 
-	var opts = []lua.Option{lua.WithTrace(trace), lua.WithVerbose(debug)}
+	var opts = []lua.Option{lua.WithTrace(trace), lua.WithVerbose(debug), lua.WithOptimizer(optim)}
+	if lua54 {
+		opts = append(opts, lua.WithVersion(lua.V54))
+	}
+	if cache != "" {
+		opts = append(opts, lua.WithChunkCache(cache))
+	}
 	state := lua.NewState(opts...)
 	defer state.Close()
-	std.Open(state)
+	std.Open(state, std.WithCompat51(lua51))
 
 	if tests {
 		state.Push(true)
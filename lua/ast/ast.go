@@ -0,0 +1,449 @@
+// Package ast declares the types used to represent syntax trees for Lua
+// source text, together with a parser, a walker and a printer.
+//
+// Every node records the span of source text it was parsed from, and
+// statements carry the comments attached to them so that tools can
+// inspect and rewrite scripts without losing them.
+package ast
+
+import (
+	"fmt"
+
+	"github.com/Azure/golua/lua/syntax"
+)
+
+// Pos is a position in the source text.
+type Pos struct {
+	Offset int // byte offset, starting at 0
+	Line   int // line number, starting at 1
+	Column int // column number, starting at 1 (in bytes)
+}
+
+// IsValid reports whether the position is known.
+func (p Pos) IsValid() bool { return p.Line > 0 }
+
+// Span is the range of source text covered by a node: From is the
+// position of its first character and To the position just after
+// its last one.
+type Span struct {
+	From Pos
+	To   Pos
+}
+
+// Pos returns the position of the first character of the node.
+func (s Span) Pos() Pos { return s.From }
+
+// End returns the position just after the last character of the node.
+func (s Span) End() Pos { return s.To }
+
+// Node is implemented by all nodes of the syntax tree.
+type Node interface {
+	Pos() Pos
+	End() Pos
+}
+
+// Expr is implemented by all expression nodes.
+type Expr interface {
+	Node
+	exprNode()
+}
+
+// Stmt is implemented by all statement nodes.
+type Stmt interface {
+	Node
+	comments() *Comments
+}
+
+// Comment is a single "--" comment, short or long.
+type Comment struct {
+	Span
+	Text string // comment text, including the leading "--"
+}
+
+// Comments holds the comments attached to a statement.
+type Comments struct {
+	Lead  []*Comment // comments on the lines before the statement
+	Trail []*Comment // comments within the statement or after it on its last line
+}
+
+func (c *Comments) comments() *Comments { return c }
+
+// CommentsOf returns the comments attached to stmt.
+func CommentsOf(stmt Stmt) *Comments { return stmt.comments() }
+
+// Chunk is the syntax tree of a whole chunk of Lua source.
+type Chunk struct {
+	Span
+	Source string // chunk name
+	Block  *Block
+}
+
+// Position returns a printable form of pos within the chunk, in the
+// form "source:line:column".
+func (c *Chunk) Position(pos Pos) string {
+	return fmt.Sprintf("%s:%d:%d", syntax.ChunkID(c.Source), pos.Line, pos.Column)
+}
+
+// Block is a sequence of statements.
+type Block struct {
+	Span
+	Stmts    []Stmt
+	Comments []*Comment // comments after the last statement
+}
+
+// ----------------------------------------------------------------------------
+// Expressions
+
+type (
+	// NilExpr is the literal nil.
+	NilExpr struct{ Span }
+
+	// TrueExpr is the literal true.
+	TrueExpr struct{ Span }
+
+	// FalseExpr is the literal false.
+	FalseExpr struct{ Span }
+
+	// IntExpr is an integer numeral.
+	IntExpr struct {
+		Span
+		Raw   string // source text (may be empty)
+		Value int64
+	}
+
+	// FloatExpr is a float numeral.
+	FloatExpr struct {
+		Span
+		Raw   string // source text (may be empty)
+		Value float64
+	}
+
+	// StringExpr is a string literal.
+	StringExpr struct {
+		Span
+		Raw   string // source text, including quotes (may be empty)
+		Value string
+	}
+
+	// VarargExpr is the vararg expression "...".
+	VarargExpr struct{ Span }
+
+	// NameExpr is a name.
+	NameExpr struct {
+		Span
+		Name string
+	}
+
+	// IndexExpr is an index expression X[Key].
+	IndexExpr struct {
+		Span
+		X   Expr
+		Key Expr
+	}
+
+	// SelectorExpr is a field access X.Sel.
+	SelectorExpr struct {
+		Span
+		X   Expr
+		Sel *NameExpr
+	}
+
+	// CallExpr is a function call Fn(Args).
+	CallExpr struct {
+		Span
+		Fn   Expr
+		Args []Expr
+	}
+
+	// MethodCallExpr is a method call X:Method(Args).
+	MethodCallExpr struct {
+		Span
+		X      Expr
+		Method *NameExpr
+		Args   []Expr
+	}
+
+	// ParenExpr is a parenthesized expression (X), which truncates
+	// X to a single value.
+	ParenExpr struct {
+		Span
+		X Expr
+	}
+
+	// FuncExpr is a function body: a function literal, or the
+	// function of a function statement.
+	FuncExpr struct {
+		Span
+		Params []*NameExpr
+		Vararg bool
+		Body   *Block
+	}
+
+	// TableExpr is a table constructor.
+	TableExpr struct {
+		Span
+		Fields []*Field
+	}
+
+	// UnaryExpr is a unary operation.
+	UnaryExpr struct {
+		Span
+		Op UnaryOp
+		X  Expr
+	}
+
+	// BinaryExpr is a binary operation.
+	BinaryExpr struct {
+		Span
+		Op    BinaryOp
+		OpPos Pos // position of the operator
+		X     Expr
+		Y     Expr
+	}
+)
+
+// FieldKind distinguishes the forms of table constructor fields.
+type FieldKind int
+
+const (
+	ListField  FieldKind = iota // Value
+	NamedField                  // Name = Value; Key is a *StringExpr
+	KeyedField                  // [Key] = Value
+)
+
+// Field is a field of a table constructor.
+type Field struct {
+	Span
+	Kind  FieldKind
+	Key   Expr // nil for ListField
+	Value Expr
+}
+
+func (*NilExpr) exprNode()        {}
+func (*TrueExpr) exprNode()       {}
+func (*FalseExpr) exprNode()      {}
+func (*IntExpr) exprNode()        {}
+func (*FloatExpr) exprNode()      {}
+func (*StringExpr) exprNode()     {}
+func (*VarargExpr) exprNode()     {}
+func (*NameExpr) exprNode()       {}
+func (*IndexExpr) exprNode()      {}
+func (*SelectorExpr) exprNode()   {}
+func (*CallExpr) exprNode()       {}
+func (*MethodCallExpr) exprNode() {}
+func (*ParenExpr) exprNode()      {}
+func (*FuncExpr) exprNode()       {}
+func (*TableExpr) exprNode()      {}
+func (*UnaryExpr) exprNode()      {}
+func (*BinaryExpr) exprNode()     {}
+
+// ----------------------------------------------------------------------------
+// Statements
+
+type (
+	// LocalStmt declares local variables: local Names = Values.
+	LocalStmt struct {
+		Span
+		Comments
+		Names  []*LocalName
+		Values []Expr
+	}
+
+	// AssignStmt is an assignment: Targets = Values.
+	AssignStmt struct {
+		Span
+		Comments
+		Targets []Expr
+		Values  []Expr
+	}
+
+	// CallStmt is a function or method call used as a statement.
+	CallStmt struct {
+		Span
+		Comments
+		Call Expr // *CallExpr or *MethodCallExpr
+	}
+
+	// DoStmt is a do ... end block.
+	DoStmt struct {
+		Span
+		Comments
+		Body *Block
+	}
+
+	// WhileStmt is a while loop.
+	WhileStmt struct {
+		Span
+		Comments
+		Cond Expr
+		Body *Block
+	}
+
+	// RepeatStmt is a repeat ... until loop.
+	RepeatStmt struct {
+		Span
+		Comments
+		Body *Block
+		Cond Expr
+	}
+
+	// IfStmt is an if statement; the first clause is the "if"
+	// and the others are "elseif" clauses.
+	IfStmt struct {
+		Span
+		Comments
+		Clauses []*IfClause
+		Else    *Block // nil if there is no else part
+	}
+
+	// NumericForStmt is a numeric for loop.
+	NumericForStmt struct {
+		Span
+		Comments
+		Var   *NameExpr
+		Start Expr
+		Limit Expr
+		Step  Expr // nil if omitted
+		Body  *Block
+	}
+
+	// GenericForStmt is a generic for loop.
+	GenericForStmt struct {
+		Span
+		Comments
+		Names []*NameExpr
+		Exprs []Expr
+		Body  *Block
+	}
+
+	// FunctionStmt is a function statement: function Name[:Method] Func.
+	FunctionStmt struct {
+		Span
+		Comments
+		Name   Expr      // *NameExpr or *SelectorExpr
+		Method *NameExpr // nil unless declared with ':'
+		Func   *FuncExpr
+	}
+
+	// LocalFunctionStmt is a local function statement.
+	LocalFunctionStmt struct {
+		Span
+		Comments
+		Name *NameExpr
+		Func *FuncExpr
+	}
+
+	// ReturnStmt is a return statement.
+	ReturnStmt struct {
+		Span
+		Comments
+		Values []Expr
+	}
+
+	// BreakStmt is a break statement.
+	BreakStmt struct {
+		Span
+		Comments
+	}
+
+	// GotoStmt is a goto statement.
+	GotoStmt struct {
+		Span
+		Comments
+		Label *NameExpr
+	}
+
+	// LabelStmt is a label ::Name::.
+	LabelStmt struct {
+		Span
+		Comments
+		Name *NameExpr
+	}
+)
+
+// LocalName is a variable declared by a local statement, with
+// its optional attribute (e.g. "const" or "close").
+type LocalName struct {
+	Span
+	Name   string
+	Attrib string // empty if none
+}
+
+// IfClause is a condition and the block it guards.
+type IfClause struct {
+	Span
+	Cond Expr
+	Body *Block
+}
+
+// ----------------------------------------------------------------------------
+// Operators
+
+// UnaryOp is a unary operator.
+type UnaryOp int
+
+const (
+	OpNeg  UnaryOp = iota // -
+	OpBNot                // ~
+	OpNot                 // not
+	OpLen                 // #
+)
+
+var unaryOps = [...]string{"-", "~", "not", "#"}
+
+func (op UnaryOp) String() string { return unaryOps[op] }
+
+// BinaryOp is a binary operator.
+type BinaryOp int
+
+const (
+	OpAdd    BinaryOp = iota // +
+	OpSub                    // -
+	OpMul                    // *
+	OpMod                    // %
+	OpPow                    // ^
+	OpDiv                    // /
+	OpIDiv                   // //
+	OpBAnd                   // &
+	OpBOr                    // |
+	OpBXor                   // ~
+	OpShl                    // <<
+	OpShr                    // >>
+	OpConcat                 // ..
+	OpEq                     // ==
+	OpLT                     // <
+	OpLE                     // <=
+	OpNE                     // ~=
+	OpGT                     // >
+	OpGE                     // >=
+	OpAnd                    // and
+	OpOr                     // or
+)
+
+var binaryOps = [...]string{
+	"+", "-", "*", "%", "^", "/", "//",
+	"&", "|", "~", "<<", ">>", "..",
+	"==", "<", "<=", "~=", ">", ">=",
+	"and", "or",
+}
+
+func (op BinaryOp) String() string { return binaryOps[op] }
+
+// priority holds the left and right priorities of each binary
+// operator; the right priority of a right associative operator is
+// lower than its left one.
+var priority = [...]struct{ left, right int }{
+	{10, 10}, {10, 10}, // '+' '-'
+	{11, 11}, {11, 11}, // '*' '%'
+	{14, 13},           // '^' (right associative)
+	{11, 11}, {11, 11}, // '/' '//'
+	{6, 6}, {4, 4}, {5, 5}, // '&' '|' '~'
+	{7, 7}, {7, 7}, // '<<' '>>'
+	{9, 8},                 // '..' (right associative)
+	{3, 3}, {3, 3}, {3, 3}, // '==' '<' '<='
+	{3, 3}, {3, 3}, {3, 3}, // '~=' '>' '>='
+	{2, 2}, {1, 1}, // 'and' 'or'
+}
+
+// unaryPriority is the priority of unary operators.
+const unaryPriority = 12
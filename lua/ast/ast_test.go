@@ -0,0 +1,177 @@
+package ast
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/Azure/golua/lua/binary"
+	"github.com/Azure/golua/lua/syntax"
+)
+
+func TestRoundTrip(t *testing.T) {
+	files, err := filepath.Glob("../../test/lua-5.3.4/*.lua")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, file := range files {
+		src, err := ioutil.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		chunk, err := Parse("@"+file, src)
+		if err != nil {
+			t.Errorf("%s: %v", file, err)
+			continue
+		}
+		if bytes.HasPrefix(src, []byte("#")) { // skip Unix exec. file comment
+			src = src[bytes.IndexByte(src, '\n'):]
+		}
+		text := Sprint(chunk)
+		want, err := syntax.Compile("=source", src)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := syntax.Compile("=printed", []byte(text))
+		if err != nil {
+			t.Errorf("%s: printed source does not compile: %v", file, err)
+			continue
+		}
+		if !sameCode(got, want) {
+			t.Errorf("%s: printed source compiles to different code", file)
+		}
+		again, err := Parse("=printed", []byte(text))
+		if err != nil {
+			t.Errorf("%s: printed source does not parse: %v", file, err)
+			continue
+		}
+		if Sprint(again) != text {
+			t.Errorf("%s: printing is not stable", file)
+		}
+	}
+}
+
+// sameCode reports whether the prototypes have the same instructions.
+func sameCode(p1, p2 *binary.Prototype) bool {
+	if len(p1.Code) != len(p2.Code) || len(p1.Protos) != len(p2.Protos) {
+		return false
+	}
+	for pc := range p1.Code {
+		if p1.Code[pc] != p2.Code[pc] {
+			return false
+		}
+	}
+	for i := range p1.Protos {
+		if !sameCode(&p1.Protos[i], &p2.Protos[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+func TestParseErrors(t *testing.T) {
+	var tests = []string{
+		"x = = 1",
+		"local function f() return 1",
+		"f(",
+		"for i do end",
+		"a.b:c = 1",
+		"x = {1, 2",
+		"function f(a, 1) end",
+		"local a, = 1",
+		"return ...\nfunction g() return ... end",
+		"if x then\nelse\nelseif y then end",
+	}
+	for _, src := range tests {
+		_, want := syntax.Compile("=test", []byte(src))
+		_, err := Parse("=test", []byte(src))
+		switch {
+		case err == nil:
+			t.Errorf("parse %q: expected error", src)
+		case want != nil && err.Error() != want.Error():
+			t.Errorf("parse %q: got %q, want %q", src, err, want)
+		}
+	}
+}
+
+func TestSpansAndComments(t *testing.T) {
+	const src = `-- leading
+local x <const> = 1 -- trailing
+
+--[[ doc ]]
+function t.a:b(...)
+	return f(x + 2)
+end
+-- dangling
+`
+	chunk, err := Parse("=test", []byte(src))
+	if err != nil {
+		t.Fatal(err)
+	}
+	stmts := chunk.Block.Stmts
+	if len(stmts) != 2 {
+		t.Fatalf("got %d statements, want 2", len(stmts))
+	}
+	local := stmts[0].(*LocalStmt)
+	if got := chunk.Position(local.Pos()); got != "test:2:1" {
+		t.Errorf("local position: got %s", got)
+	}
+	if local.Names[0].Attrib != "const" {
+		t.Errorf("local attribute: got %q", local.Names[0].Attrib)
+	}
+	if c := CommentsOf(local); len(c.Lead) != 1 || c.Lead[0].Text != "-- leading" || len(c.Trail) != 1 || c.Trail[0].Text != "-- trailing" {
+		t.Errorf("local comments: got %+v", c)
+	}
+	fn := stmts[1].(*FunctionStmt)
+	if c := CommentsOf(fn); len(c.Lead) != 1 || c.Lead[0].Text != "--[[ doc ]]" {
+		t.Errorf("function comments: got %+v", c)
+	}
+	if got, want := fn.Span, (Span{Pos{56, 5, 1}, Pos{96, 7, 4}}); got != want {
+		t.Errorf("function span: got %v, want %v", got, want)
+	}
+	if len(chunk.Block.Comments) != 1 || chunk.Block.Comments[0].Text != "-- dangling" {
+		t.Errorf("dangling comments: got %v", chunk.Block.Comments)
+	}
+	var names []string
+	Inspect(chunk, func(n Node) bool {
+		if n, ok := n.(*NameExpr); ok {
+			names = append(names, n.Name)
+		}
+		return true
+	})
+	if got := strings.Join(names, " "); got != "t a b f x" {
+		t.Errorf("names: got %q", got)
+	}
+	if got := Sprint(chunk); got != src {
+		t.Errorf("print: got\n%s\nwant\n%s", got, src)
+	}
+}
+
+func TestPrintPrecedence(t *testing.T) {
+	var (
+		a = &NameExpr{Name: "a"}
+		b = &NameExpr{Name: "b"}
+		c = &NameExpr{Name: "c"}
+	)
+	var tests = []struct {
+		expr Expr
+		text string
+	}{
+		{&BinaryExpr{Op: OpMul, X: &BinaryExpr{Op: OpAdd, X: a, Y: b}, Y: c}, "(a + b) * c"},
+		{&BinaryExpr{Op: OpSub, X: a, Y: &BinaryExpr{Op: OpSub, X: b, Y: c}}, "a - (b - c)"},
+		{&BinaryExpr{Op: OpPow, X: a, Y: &BinaryExpr{Op: OpPow, X: b, Y: c}}, "a ^ b ^ c"},
+		{&BinaryExpr{Op: OpPow, X: &UnaryExpr{Op: OpNeg, X: a}, Y: b}, "(-a) ^ b"},
+		{&UnaryExpr{Op: OpNeg, X: &UnaryExpr{Op: OpNeg, X: a}}, "- -a"},
+		{&UnaryExpr{Op: OpNot, X: &BinaryExpr{Op: OpEq, X: a, Y: b}}, "not (a == b)"},
+		{&CallExpr{Fn: &StringExpr{Value: "x\n"}, Args: []Expr{&IntExpr{Value: -1}}}, `("x\n")(-1)`},
+		{&IndexExpr{X: a, Key: &StringExpr{Raw: "[[k]]"}}, "a[ [[k]]]"},
+		{&FloatExpr{Value: 1}, "1.0"},
+	}
+	for _, test := range tests {
+		if got := Sprint(test.expr); got != test.text {
+			t.Errorf("got %q, want %q", got, test.text)
+		}
+	}
+}
@@ -0,0 +1,706 @@
+package ast
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/Azure/golua/lua/syntax"
+)
+
+// maxCalls is the limit on nested syntactical constructs.
+const maxCalls = 200
+
+// parser builds a syntax tree following the grammar (and reporting
+// the errors) of the compiler in package syntax.
+type parser struct {
+	*syntax.Scanner
+	prev    Pos        // end of the last token consumed
+	pending []*Comment // comments not yet attached
+	vararg  bool       // whether the current function is vararg
+	line    int        // line where the current function is defined
+	nCalls  int        // number of nested syntactical constructs
+}
+
+// Parse parses the Lua source text src of the chunk named chunkname
+// and returns its syntax tree. A first line starting with '#' (as in
+// "#!/usr/bin/env glua") is skipped, as it is when the chunk is loaded.
+//
+// The errors reported are the same ones reported when compiling the
+// chunk with syntax.Compile.
+func Parse(chunkname string, src []byte) (_ *Chunk, err error) {
+	defer syntax.Catch(&err)
+	if bytes.HasPrefix(src, []byte("#")) {
+		if i := bytes.IndexByte(src, '\n'); i >= 0 {
+			src = src[i:] // keep the newline for line numbers
+		} else {
+			src = nil
+		}
+	}
+	p := &parser{Scanner: syntax.NewScanner(chunkname, src), vararg: true}
+	p.KeepComments()
+	p.next()
+	chunk := &Chunk{Source: chunkname}
+	chunk.Block = p.block()
+	p.check(syntax.TokenEOS)
+	chunk.Span = Span{From: Pos{Line: 1, Column: 1}, To: Pos(p.Pos())}
+	return chunk, nil
+}
+
+// next advances to the next token, collecting its comments.
+func (p *parser) next() {
+	p.prev = Pos(p.End())
+	p.Next()
+	for _, c := range p.Comments() {
+		p.pending = append(p.pending, &Comment{
+			Span: Span{From: Pos(c.Pos), To: Pos(c.End)},
+			Text: c.Text,
+		})
+	}
+}
+
+// pos returns the position of the current token.
+func (p *parser) pos() Pos { return Pos(p.Pos()) }
+
+// span returns the span from pos to the end of the last token consumed.
+func (p *parser) span(pos Pos) Span { return Span{From: pos, To: p.prev} }
+
+func (p *parser) syntaxError(msg string) { p.Errorf(p.Token(), "%s", msg) }
+
+func (p *parser) errorExpected(tok syntax.Token) {
+	p.syntaxError(fmt.Sprintf("%v expected", tok))
+}
+
+func (p *parser) check(tok syntax.Token) {
+	if p.Token() != tok {
+		p.errorExpected(tok)
+	}
+}
+
+func (p *parser) checkNext(tok syntax.Token) {
+	p.check(tok)
+	p.next()
+}
+
+func (p *parser) testNext(tok syntax.Token) bool {
+	if p.Token() == tok {
+		p.next()
+		return true
+	}
+	return false
+}
+
+// checkMatch checks that the current token is what, closing the
+// construct who opened at line where.
+func (p *parser) checkMatch(what, who syntax.Token, where int) {
+	if !p.testNext(what) {
+		if where == p.Line() {
+			p.errorExpected(what)
+		} else {
+			p.syntaxError(fmt.Sprintf("%v expected (to close %v at line %d)", what, who, where))
+		}
+	}
+}
+
+func (p *parser) checkName() *NameExpr {
+	p.check(syntax.TokenName)
+	pos, name := p.pos(), p.Value().(string)
+	p.next()
+	return &NameExpr{Span: p.span(pos), Name: name}
+}
+
+func (p *parser) enterLevel() {
+	if p.nCalls++; p.nCalls > maxCalls {
+		where := "main function"
+		if p.line != 0 {
+			where = fmt.Sprintf("function at line %d", p.line)
+		}
+		p.Errorf(0, "too many C levels (limit is %d) in %s", maxCalls, where)
+	}
+}
+
+func (p *parser) leaveLevel() { p.nCalls-- }
+
+// blockFollow reports whether the current token ends a block.
+func (p *parser) blockFollow(withUntil bool) bool {
+	switch p.Token() {
+	case syntax.TokenElse, syntax.TokenElseIf, syntax.TokenEnd, syntax.TokenEOS:
+		return true
+	case syntax.TokenUntil:
+		return withUntil
+	}
+	return false
+}
+
+// ----------------------------------------------------------------------------
+// Comments
+
+// takeTrail attaches to stmt the pending comments found within it
+// or starting on its last line.
+func (p *parser) takeTrail(stmt Stmt) {
+	if stmt == nil {
+		return
+	}
+	c := stmt.comments()
+	for len(p.pending) > 0 {
+		if cmt := p.pending[0]; cmt.From.Offset >= stmt.End().Offset && cmt.From.Line != stmt.End().Line {
+			break
+		}
+		c.Trail = append(c.Trail, p.pending[0])
+		p.pending = p.pending[1:]
+	}
+}
+
+// takePending returns the pending comments.
+func (p *parser) takePending() []*Comment {
+	list := p.pending
+	p.pending = nil
+	return list
+}
+
+// ----------------------------------------------------------------------------
+// Statements
+
+// block parses a list of statements.
+func (p *parser) block() *Block {
+	b := &Block{Span: Span{From: p.pos()}}
+	// Comments before the token opening the block, or after it on the
+	// same line, belong to the enclosing statement.
+	outer := p.pending
+	for len(p.pending) > 0 && (p.pending[0].From.Offset < p.prev.Offset || p.pending[0].From.Line == p.prev.Line) {
+		p.pending = p.pending[1:]
+	}
+	n := len(outer) - len(p.pending)
+	outer = outer[:n:n] // appending must not overwrite the comments left
+	var last Stmt
+	for !p.blockFollow(true) {
+		if p.testNext(';') {
+			continue
+		}
+		p.takeTrail(last)
+		lead := p.takePending()
+		last = p.statement()
+		last.comments().Lead = lead
+		b.Stmts = append(b.Stmts, last)
+		if _, ok := last.(*ReturnStmt); ok {
+			break // 'return' must be last statement
+		}
+	}
+	p.takeTrail(last)
+	b.Comments = p.takePending()
+	p.pending = outer
+	if len(b.Stmts) > 0 {
+		b.To = b.Stmts[len(b.Stmts)-1].End()
+	} else {
+		b.To = b.From
+	}
+	return b
+}
+
+func (p *parser) statement() Stmt {
+	p.enterLevel()
+	defer p.leaveLevel()
+	pos := p.pos()
+	switch p.Token() {
+	case syntax.TokenIf:
+		return p.ifStat(pos)
+	case syntax.TokenWhile:
+		line := p.Line()
+		p.next()
+		cond := p.expr()
+		p.checkNext(syntax.TokenDo)
+		body := p.block()
+		p.checkMatch(syntax.TokenEnd, syntax.TokenWhile, line)
+		return &WhileStmt{Span: p.span(pos), Cond: cond, Body: body}
+	case syntax.TokenDo:
+		line := p.Line()
+		p.next()
+		body := p.block()
+		p.checkMatch(syntax.TokenEnd, syntax.TokenDo, line)
+		return &DoStmt{Span: p.span(pos), Body: body}
+	case syntax.TokenFor:
+		return p.forStat(pos)
+	case syntax.TokenRepeat:
+		line := p.Line()
+		p.next()
+		body := p.block()
+		p.checkMatch(syntax.TokenUntil, syntax.TokenRepeat, line)
+		cond := p.expr()
+		return &RepeatStmt{Span: p.span(pos), Body: body, Cond: cond}
+	case syntax.TokenFunction:
+		return p.funcStat(pos)
+	case syntax.TokenLocal:
+		p.next()
+		if p.testNext(syntax.TokenFunction) {
+			name := p.checkName()
+			fn := p.body(p.pos(), pos.Line)
+			return &LocalFunctionStmt{Span: p.span(pos), Name: name, Func: fn}
+		}
+		return p.localStat(pos)
+	case syntax.TokenLabel:
+		p.next()
+		name := p.checkName()
+		p.checkNext(syntax.TokenLabel)
+		return &LabelStmt{Span: p.span(pos), Name: name}
+	case syntax.TokenReturn:
+		p.next()
+		var values []Expr
+		if !p.blockFollow(true) && p.Token() != ';' {
+			values = p.exprList()
+		}
+		p.testNext(';')
+		return &ReturnStmt{Span: p.span(pos), Values: values}
+	case syntax.TokenBreak:
+		p.next()
+		return &BreakStmt{Span: p.span(pos)}
+	case syntax.TokenGoto:
+		p.next()
+		label := p.checkName()
+		return &GotoStmt{Span: p.span(pos), Label: label}
+	default:
+		return p.exprStat(pos)
+	}
+}
+
+func (p *parser) ifStat(pos Pos) Stmt {
+	line := p.Line()
+	stmt := &IfStmt{}
+	for {
+		at := p.pos()
+		p.next() // skip 'if' or 'elseif'
+		cond := p.expr()
+		p.checkNext(syntax.TokenThen)
+		body := p.block()
+		stmt.Clauses = append(stmt.Clauses, &IfClause{Span: p.span(at), Cond: cond, Body: body})
+		if p.Token() != syntax.TokenElseIf {
+			break
+		}
+	}
+	if p.testNext(syntax.TokenElse) {
+		stmt.Else = p.block()
+	}
+	p.checkMatch(syntax.TokenEnd, syntax.TokenIf, line)
+	stmt.Span = p.span(pos)
+	return stmt
+}
+
+func (p *parser) forStat(pos Pos) Stmt {
+	line := p.Line()
+	p.next() // skip 'for'
+	name := p.checkName()
+	var stmt Stmt
+	switch p.Token() {
+	case '=':
+		p.next()
+		loop := &NumericForStmt{Var: name, Start: p.expr()}
+		p.checkNext(',')
+		loop.Limit = p.expr()
+		if p.testNext(',') {
+			loop.Step = p.expr()
+		}
+		p.checkNext(syntax.TokenDo)
+		loop.Body = p.block()
+		stmt = loop
+	case ',', syntax.TokenIn:
+		loop := &GenericForStmt{Names: []*NameExpr{name}}
+		for p.testNext(',') {
+			loop.Names = append(loop.Names, p.checkName())
+		}
+		p.checkNext(syntax.TokenIn)
+		loop.Exprs = p.exprList()
+		p.checkNext(syntax.TokenDo)
+		loop.Body = p.block()
+		stmt = loop
+	default:
+		p.syntaxError("'=' or 'in' expected")
+	}
+	p.checkMatch(syntax.TokenEnd, syntax.TokenFor, line)
+	switch loop := stmt.(type) {
+	case *NumericForStmt:
+		loop.Span = p.span(pos)
+	case *GenericForStmt:
+		loop.Span = p.span(pos)
+	}
+	return stmt
+}
+
+func (p *parser) funcStat(pos Pos) Stmt {
+	p.next() // skip 'function'
+	at := p.pos()
+	stmt := &FunctionStmt{Name: p.checkName()}
+	for p.testNext('.') {
+		sel := p.checkName()
+		stmt.Name = &SelectorExpr{Span: p.span(at), X: stmt.Name, Sel: sel}
+	}
+	if p.testNext(':') {
+		stmt.Method = p.checkName()
+	}
+	stmt.Func = p.body(p.pos(), pos.Line)
+	stmt.Span = p.span(pos)
+	return stmt
+}
+
+func (p *parser) localStat(pos Pos) Stmt {
+	stmt := &LocalStmt{}
+	for {
+		at := p.pos()
+		name := p.checkName()
+		local := &LocalName{Name: name.Name}
+		if p.testNext('<') {
+			attr := p.checkName()
+			if attr.Name != "const" && attr.Name != "close" {
+				p.Errorf(0, "unknown attribute '%s'", attr.Name)
+			}
+			p.checkNext('>')
+			local.Attrib = attr.Name
+		}
+		local.Span = p.span(at)
+		stmt.Names = append(stmt.Names, local)
+		if !p.testNext(',') {
+			break
+		}
+	}
+	if p.testNext('=') {
+		stmt.Values = p.exprList()
+	}
+	stmt.Span = p.span(pos)
+	return stmt
+}
+
+func (p *parser) exprStat(pos Pos) Stmt {
+	x := p.suffixedExpr()
+	if p.Token() == '=' || p.Token() == ',' {
+		stmt := &AssignStmt{Targets: []Expr{x}}
+		for {
+			if !isVar(stmt.Targets[len(stmt.Targets)-1]) {
+				p.syntaxError("syntax error")
+			}
+			if !p.testNext(',') {
+				break
+			}
+			p.enterLevel()
+			stmt.Targets = append(stmt.Targets, p.suffixedExpr())
+			p.leaveLevel()
+		}
+		p.checkNext('=')
+		stmt.Values = p.exprList()
+		stmt.Span = p.span(pos)
+		return stmt
+	}
+	switch x.(type) {
+	case *CallExpr, *MethodCallExpr:
+	default:
+		p.syntaxError("syntax error")
+	}
+	return &CallStmt{Span: p.span(pos), Call: x}
+}
+
+func isVar(x Expr) bool {
+	switch x.(type) {
+	case *NameExpr, *IndexExpr, *SelectorExpr:
+		return true
+	}
+	return false
+}
+
+// ----------------------------------------------------------------------------
+// Expressions
+
+func (p *parser) exprList() []Expr {
+	list := []Expr{p.expr()}
+	for p.testNext(',') {
+		list = append(list, p.expr())
+	}
+	return list
+}
+
+func (p *parser) expr() Expr { return p.subExpr(0) }
+
+// subExpr parses a subexpression whose binary operators have a
+// priority higher than limit.
+func (p *parser) subExpr(limit int) Expr {
+	p.enterLevel()
+	defer p.leaveLevel()
+	var (
+		pos = p.pos()
+		x   Expr
+	)
+	if op, ok := unaryOp(p.Token()); ok {
+		p.next()
+		operand := p.subExpr(unaryPriority)
+		x = &UnaryExpr{Span: p.span(pos), Op: op, X: operand}
+	} else {
+		x = p.simpleExpr()
+	}
+	for {
+		op, ok := binaryOp(p.Token())
+		if !ok || priority[op].left <= limit {
+			return x
+		}
+		opPos := p.pos()
+		p.next()
+		y := p.subExpr(priority[op].right)
+		x = &BinaryExpr{Span: p.span(pos), Op: op, OpPos: opPos, X: x, Y: y}
+	}
+}
+
+func unaryOp(tok syntax.Token) (UnaryOp, bool) {
+	switch tok {
+	case syntax.TokenNot:
+		return OpNot, true
+	case '-':
+		return OpNeg, true
+	case '~':
+		return OpBNot, true
+	case '#':
+		return OpLen, true
+	}
+	return 0, false
+}
+
+func binaryOp(tok syntax.Token) (BinaryOp, bool) {
+	switch tok {
+	case '+':
+		return OpAdd, true
+	case '-':
+		return OpSub, true
+	case '*':
+		return OpMul, true
+	case '%':
+		return OpMod, true
+	case '^':
+		return OpPow, true
+	case '/':
+		return OpDiv, true
+	case syntax.TokenIDiv:
+		return OpIDiv, true
+	case '&':
+		return OpBAnd, true
+	case '|':
+		return OpBOr, true
+	case '~':
+		return OpBXor, true
+	case syntax.TokenShl:
+		return OpShl, true
+	case syntax.TokenShr:
+		return OpShr, true
+	case syntax.TokenConcat:
+		return OpConcat, true
+	case syntax.TokenNE:
+		return OpNE, true
+	case syntax.TokenEq:
+		return OpEq, true
+	case '<':
+		return OpLT, true
+	case syntax.TokenLE:
+		return OpLE, true
+	case '>':
+		return OpGT, true
+	case syntax.TokenGE:
+		return OpGE, true
+	case syntax.TokenAnd:
+		return OpAnd, true
+	case syntax.TokenOr:
+		return OpOr, true
+	}
+	return 0, false
+}
+
+func (p *parser) simpleExpr() Expr {
+	var (
+		pos = p.pos()
+		x   Expr
+	)
+	switch p.Token() {
+	case syntax.TokenFloat:
+		x = &FloatExpr{Raw: p.Text(), Value: p.Value().(float64)}
+	case syntax.TokenInt:
+		x = &IntExpr{Raw: p.Text(), Value: p.Value().(int64)}
+	case syntax.TokenString:
+		x = &StringExpr{Raw: p.Text(), Value: p.Value().(string)}
+	case syntax.TokenNil:
+		x = &NilExpr{}
+	case syntax.TokenTrue:
+		x = &TrueExpr{}
+	case syntax.TokenFalse:
+		x = &FalseExpr{}
+	case syntax.TokenDots:
+		if !p.vararg {
+			p.syntaxError("cannot use '...' outside a vararg function")
+		}
+		x = &VarargExpr{}
+	case '{':
+		return p.constructor()
+	case syntax.TokenFunction:
+		p.next()
+		return p.body(pos, pos.Line)
+	default:
+		return p.suffixedExpr()
+	}
+	p.next()
+	setSpan(x, p.span(pos))
+	return x
+}
+
+// setSpan sets the span of a literal expression.
+func setSpan(x Expr, span Span) {
+	switch x := x.(type) {
+	case *FloatExpr:
+		x.Span = span
+	case *IntExpr:
+		x.Span = span
+	case *StringExpr:
+		x.Span = span
+	case *NilExpr:
+		x.Span = span
+	case *TrueExpr:
+		x.Span = span
+	case *FalseExpr:
+		x.Span = span
+	case *VarargExpr:
+		x.Span = span
+	}
+}
+
+func (p *parser) primaryExpr() Expr {
+	switch p.Token() {
+	case syntax.TokenName:
+		return p.checkName()
+	case '(':
+		pos, line := p.pos(), p.Line()
+		p.next()
+		x := p.expr()
+		p.checkMatch(')', '(', line)
+		return &ParenExpr{Span: p.span(pos), X: x}
+	default:
+		p.syntaxError("unexpected symbol")
+		panic("unreachable")
+	}
+}
+
+func (p *parser) suffixedExpr() Expr {
+	pos := p.pos()
+	x := p.primaryExpr()
+	for {
+		switch p.Token() {
+		case '.':
+			p.next()
+			x = &SelectorExpr{X: x, Sel: p.checkName()}
+		case '[':
+			p.next()
+			key := p.expr()
+			p.checkNext(']')
+			x = &IndexExpr{X: x, Key: key}
+		case ':':
+			p.next()
+			method := p.checkName()
+			x = &MethodCallExpr{X: x, Method: method, Args: p.funcArgs(pos.Line)}
+		case '(', syntax.TokenString, '{':
+			x = &CallExpr{Fn: x, Args: p.funcArgs(pos.Line)}
+		default:
+			return x
+		}
+		switch x := x.(type) {
+		case *SelectorExpr:
+			x.Span = p.span(pos)
+		case *IndexExpr:
+			x.Span = p.span(pos)
+		case *MethodCallExpr:
+			x.Span = p.span(pos)
+		case *CallExpr:
+			x.Span = p.span(pos)
+		}
+	}
+}
+
+func (p *parser) funcArgs(line int) []Expr {
+	switch p.Token() {
+	case '(':
+		p.next()
+		var args []Expr
+		if p.Token() != ')' {
+			args = p.exprList()
+		}
+		p.checkMatch(')', '(', line)
+		return args
+	case '{':
+		return []Expr{p.constructor()}
+	case syntax.TokenString:
+		pos := p.pos()
+		x := &StringExpr{Raw: p.Text(), Value: p.Value().(string)}
+		p.next()
+		x.Span = p.span(pos)
+		return []Expr{x}
+	default:
+		p.syntaxError("function arguments expected")
+		panic("unreachable")
+	}
+}
+
+func (p *parser) constructor() Expr {
+	pos, line := p.pos(), p.Line()
+	table := &TableExpr{}
+	p.checkNext('{')
+	for p.Token() != '}' {
+		table.Fields = append(table.Fields, p.field())
+		if !p.testNext(',') && !p.testNext(';') {
+			break
+		}
+	}
+	p.checkMatch('}', '{', line)
+	table.Span = p.span(pos)
+	return table
+}
+
+func (p *parser) field() *Field {
+	pos := p.pos()
+	f := &Field{}
+	switch {
+	case p.Token() == syntax.TokenName && p.Lookahead() == '=':
+		name := p.checkName()
+		f.Kind, f.Key = NamedField, &StringExpr{Span: name.Span, Value: name.Name}
+		p.next() // skip '='
+		f.Value = p.expr()
+	case p.Token() == '[':
+		p.next()
+		f.Kind, f.Key = KeyedField, p.expr()
+		p.checkNext(']')
+		p.checkNext('=')
+		f.Value = p.expr()
+	default:
+		f.Kind, f.Value = ListField, p.expr()
+	}
+	f.Span = p.span(pos)
+	return f
+}
+
+// body parses the parameters and body of a function defined at
+// line; the span of the function starts at pos.
+func (p *parser) body(pos Pos, line int) *FuncExpr {
+	vararg, outer := p.vararg, p.line
+	p.vararg, p.line = false, line
+	fn := &FuncExpr{}
+	p.checkNext('(')
+	if p.Token() != ')' {
+		for {
+			switch p.Token() {
+			case syntax.TokenName:
+				fn.Params = append(fn.Params, p.checkName())
+			case syntax.TokenDots:
+				p.next()
+				fn.Vararg = true
+			default:
+				p.syntaxError("<name> or '...' expected")
+			}
+			if fn.Vararg || !p.testNext(',') {
+				break
+			}
+		}
+	}
+	p.vararg = fn.Vararg
+	p.checkNext(')')
+	fn.Body = p.block()
+	p.checkMatch(syntax.TokenEnd, syntax.TokenFunction, line)
+	p.vararg, p.line = vararg, outer
+	fn.Span = p.span(pos)
+	return fn
+}
@@ -0,0 +1,630 @@
+package ast
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/Azure/golua/lua/syntax"
+)
+
+// Fprint writes the Lua source text of node to w.
+//
+// Statements are printed one per line, indented with tabs, together
+// with their comments; blank lines between statements are kept.
+// Parsing the output yields a tree equivalent to node.
+func Fprint(w io.Writer, node Node) error {
+	var p printer
+	p.node(node)
+	_, err := w.Write(p.buf.Bytes())
+	return err
+}
+
+// Sprint returns the Lua source text of node.
+func Sprint(node Node) string {
+	var p printer
+	p.node(node)
+	return p.buf.String()
+}
+
+// printer accumulates the source text of a syntax tree.
+type printer struct {
+	buf    bytes.Buffer
+	indent int
+	trail  []*Comment // comments of the current statement not yet printed
+}
+
+// print writes s, separating it from the previous text if the two
+// would otherwise run together into a different token.
+func (p *printer) print(s string) {
+	if s == "" {
+		return
+	}
+	if b := p.buf.Bytes(); len(b) > 0 {
+		if last := b[len(b)-1]; (last == '-' || last == '[') && s[0] == last {
+			p.buf.WriteByte(' ') // avoid "--" (comment) and "[[" (long string)
+		}
+	}
+	p.buf.WriteString(s)
+}
+
+func (p *printer) printf(format string, args ...interface{}) {
+	p.print(fmt.Sprintf(format, args...))
+}
+
+// line starts a new indented line.
+func (p *printer) line() {
+	for i := 0; i < p.indent; i++ {
+		p.buf.WriteByte('\t')
+	}
+}
+
+func (p *printer) node(node Node) {
+	switch n := node.(type) {
+	case *Chunk:
+		p.block(n.Block)
+	case *Block:
+		p.block(n)
+	case Stmt:
+		p.stmt(n)
+	case Expr:
+		p.expr(n)
+	case *Field:
+		p.field(n)
+	case *LocalName:
+		p.localName(n)
+	case *IfClause:
+		p.expr(n.Cond)
+		p.print(" then")
+		p.body(n.Body)
+	case *Comment:
+		p.print(n.Text)
+	default:
+		panic(fmt.Sprintf("ast.Fprint: unexpected node type %T", n))
+	}
+}
+
+// ----------------------------------------------------------------------------
+// Statements
+
+// block prints the statements of b, each on its own line.
+func (p *printer) block(b *Block) {
+	defer func(trail []*Comment) { p.trail = trail }(p.trail)
+	last := 0 // last line of the previous statement
+	for i, stmt := range b.Stmts {
+		c := stmt.comments()
+		for _, cmt := range c.Lead {
+			p.blankLine(last, cmt.From.Line)
+			p.comment(cmt)
+			last = cmt.To.Line
+		}
+		p.blankLine(last, stmt.Pos().Line)
+		p.line()
+		if i > 0 && startsWithParen(stmt) {
+			p.print(";") // not a call to the previous statement
+		}
+		p.trail = c.Trail
+		p.stmt(stmt)
+		last = stmt.End().Line
+		if n := len(c.Trail); n > 0 && c.Trail[n-1].To.Line > last {
+			last = c.Trail[n-1].To.Line
+		}
+		for _, cmt := range p.trail {
+			p.print(" ")
+			p.print(cmt.Text)
+		}
+		p.buf.WriteByte('\n')
+	}
+	for _, cmt := range b.Comments {
+		p.blankLine(last, cmt.From.Line)
+		p.comment(cmt)
+		last = cmt.To.Line
+	}
+}
+
+// blankLine writes a blank line if there was one in the source
+// between the lines last and next.
+func (p *printer) blankLine(last, next int) {
+	if last > 0 && next > last+1 {
+		p.buf.WriteByte('\n')
+	}
+}
+
+// comment prints cmt on its own line.
+func (p *printer) comment(cmt *Comment) {
+	p.line()
+	p.print(cmt.Text)
+	p.buf.WriteByte('\n')
+}
+
+// comments prints on their own lines the comments of the current
+// statement found before pos.
+func (p *printer) comments(pos Pos) {
+	for len(p.trail) > 0 && p.trail[0].From.Offset < pos.Offset {
+		p.comment(p.trail[0])
+		p.trail = p.trail[1:]
+	}
+}
+
+// inline prints in place the long comments of the current statement found
+// before pos, which unlike short comments do not end the line: followed by
+// a space, or preceded by one if they come after an operand.
+func (p *printer) inline(pos Pos, after bool) {
+	for i := 0; i < len(p.trail) && p.trail[i].From.Offset < pos.Offset; {
+		if !isLong(p.trail[i]) {
+			i++ // left to end the line
+			continue
+		}
+		if after {
+			p.print(" ")
+		}
+		p.print(p.trail[i].Text)
+		if !after {
+			p.print(" ")
+		}
+		p.trail = append(p.trail[:i:i], p.trail[i+1:]...) // copied: the statement's comments are kept
+	}
+}
+
+// isLong reports whether cmt is a long comment, as "--[[ ... ]]".
+func isLong(cmt *Comment) bool {
+	level := strings.TrimLeft(strings.TrimPrefix(cmt.Text, "--["), "=")
+	return strings.HasPrefix(cmt.Text, "--[") && strings.HasPrefix(level, "[")
+}
+
+// trailing prints the comments of the current statement found before
+// pos that start on the given line.
+func (p *printer) trailing(line int, pos Pos) {
+	for len(p.trail) > 0 && p.trail[0].From.Line == line && p.trail[0].From.Offset < pos.Offset {
+		p.print(" ")
+		p.print(p.trail[0].Text)
+		p.trail = p.trail[1:]
+	}
+}
+
+// body prints a nested block followed by the indentation for the
+// keyword closing it. Comments of the current statement found before
+// the block end the line opening it.
+func (p *printer) body(b *Block) {
+	if len(b.Stmts) == 0 && len(b.Comments) == 0 {
+		p.print(" ")
+		return
+	}
+	for len(p.trail) > 0 && p.trail[0].From.Offset < b.Pos().Offset {
+		p.print(" ")
+		p.print(p.trail[0].Text)
+		p.trail = p.trail[1:]
+	}
+	p.buf.WriteByte('\n')
+	p.indent++
+	p.block(b)
+	p.indent--
+	p.line()
+}
+
+func (p *printer) stmt(stmt Stmt) {
+	switch n := stmt.(type) {
+	case *LocalStmt:
+		p.print("local ")
+		for i, name := range n.Names {
+			if i > 0 {
+				p.print(", ")
+			}
+			p.localName(name)
+		}
+		if len(n.Values) > 0 {
+			p.print(" = ")
+			p.exprList(n.Values)
+		}
+	case *AssignStmt:
+		p.exprList(n.Targets)
+		p.print(" = ")
+		p.exprList(n.Values)
+	case *CallStmt:
+		p.expr(n.Call)
+	case *DoStmt:
+		p.print("do")
+		p.body(n.Body)
+		p.print("end")
+	case *WhileStmt:
+		p.print("while ")
+		p.expr(n.Cond)
+		p.print(" do")
+		p.body(n.Body)
+		p.print("end")
+	case *RepeatStmt:
+		p.print("repeat")
+		p.body(n.Body)
+		p.print("until ")
+		p.expr(n.Cond)
+	case *IfStmt:
+		for i, c := range n.Clauses {
+			if i == 0 {
+				p.print("if ")
+			} else {
+				p.print("elseif ")
+			}
+			p.node(c)
+		}
+		if n.Else != nil {
+			p.print("else")
+			p.body(n.Else)
+		}
+		p.print("end")
+	case *NumericForStmt:
+		p.printf("for %s = ", n.Var.Name)
+		p.expr(n.Start)
+		p.print(", ")
+		p.expr(n.Limit)
+		if n.Step != nil {
+			p.print(", ")
+			p.expr(n.Step)
+		}
+		p.print(" do")
+		p.body(n.Body)
+		p.print("end")
+	case *GenericForStmt:
+		p.print("for ")
+		for i, name := range n.Names {
+			if i > 0 {
+				p.print(", ")
+			}
+			p.print(name.Name)
+		}
+		p.print(" in ")
+		p.exprList(n.Exprs)
+		p.print(" do")
+		p.body(n.Body)
+		p.print("end")
+	case *FunctionStmt:
+		p.print("function ")
+		p.expr(n.Name)
+		if n.Method != nil {
+			p.printf(":%s", n.Method.Name)
+		}
+		p.funcBody(n.Func)
+	case *LocalFunctionStmt:
+		p.printf("local function %s", n.Name.Name)
+		p.funcBody(n.Func)
+	case *ReturnStmt:
+		p.print("return")
+		if len(n.Values) > 0 {
+			p.print(" ")
+			p.exprList(n.Values)
+		}
+	case *BreakStmt:
+		p.print("break")
+	case *GotoStmt:
+		p.printf("goto %s", n.Label.Name)
+	case *LabelStmt:
+		p.printf("::%s::", n.Name.Name)
+	default:
+		panic(fmt.Sprintf("ast.Fprint: unexpected statement type %T", n))
+	}
+}
+
+func (p *printer) localName(n *LocalName) {
+	p.print(n.Name)
+	if n.Attrib != "" {
+		p.printf(" <%s>", n.Attrib)
+	}
+}
+
+// startsWithParen reports whether the statement is printed starting
+// with '(', which would otherwise continue the previous statement.
+func startsWithParen(stmt Stmt) bool {
+	var x Expr
+	switch n := stmt.(type) {
+	case *CallStmt:
+		x = n.Call
+	case *AssignStmt:
+		x = n.Targets[0]
+	default:
+		return false
+	}
+	for {
+		switch n := x.(type) {
+		case *CallExpr:
+			x = n.Fn
+		case *MethodCallExpr:
+			x = n.X
+		case *IndexExpr:
+			x = n.X
+		case *SelectorExpr:
+			x = n.X
+		case *NameExpr:
+			return false
+		default:
+			return true // parenthesized
+		}
+	}
+}
+
+// ----------------------------------------------------------------------------
+// Expressions
+
+func (p *printer) exprList(list []Expr) {
+	for i, x := range list {
+		if i > 0 {
+			p.print(", ")
+		}
+		p.expr(x)
+	}
+}
+
+func (p *printer) expr(x Expr) {
+	p.inline(x.Pos(), false)
+	switch n := x.(type) {
+	case *NilExpr:
+		p.print("nil")
+	case *TrueExpr:
+		p.print("true")
+	case *FalseExpr:
+		p.print("false")
+	case *IntExpr:
+		if n.Raw != "" {
+			p.print(n.Raw)
+		} else if n.Value == math.MinInt64 {
+			p.print("0x8000000000000000") // hexadecimal numerals wrap around
+		} else {
+			p.print(strconv.FormatInt(n.Value, 10))
+		}
+	case *FloatExpr:
+		if n.Raw != "" {
+			p.print(n.Raw)
+		} else {
+			p.print(formatFloat(n.Value))
+		}
+	case *StringExpr:
+		if n.Raw != "" {
+			p.print(n.Raw)
+		} else {
+			p.print(quote(n.Value))
+		}
+	case *VarargExpr:
+		p.print("...")
+	case *NameExpr:
+		p.print(n.Name)
+	case *IndexExpr:
+		p.prefixExpr(n.X)
+		p.print("[")
+		p.expr(n.Key)
+		p.inline(n.End(), true)
+		p.print("]")
+	case *SelectorExpr:
+		p.prefixExpr(n.X)
+		p.printf(".%s", n.Sel.Name)
+	case *CallExpr:
+		p.prefixExpr(n.Fn)
+		p.args(n.Args, n.End())
+	case *MethodCallExpr:
+		p.prefixExpr(n.X)
+		p.printf(":%s", n.Method.Name)
+		p.args(n.Args, n.End())
+	case *ParenExpr:
+		p.print("(")
+		p.expr(n.X)
+		p.inline(n.End(), true)
+		p.print(")")
+	case *FuncExpr:
+		p.print("function")
+		p.funcBody(n)
+	case *TableExpr:
+		if isMultiline(n) {
+			p.table(n)
+			break
+		}
+		p.print("{")
+		for i, f := range n.Fields {
+			if i > 0 {
+				p.print(", ")
+			}
+			p.field(f)
+		}
+		p.inline(n.End(), true)
+		p.print("}")
+	case *UnaryExpr:
+		p.print(n.Op.String())
+		if n.Op == OpNot {
+			p.print(" ")
+		}
+		if y, ok := n.X.(*BinaryExpr); ok && priority[y.Op].left <= unaryPriority {
+			p.paren(n.X)
+		} else {
+			p.expr(n.X)
+		}
+	case *BinaryExpr:
+		op := priority[n.Op]
+		switch y := n.X.(type) {
+		case *BinaryExpr:
+			p.parenIf(priority[y.Op].right < op.left, y)
+		default:
+			p.parenIf(isUnary(y) && op.left > unaryPriority, y)
+		}
+		p.inline(n.OpPos, true)
+		p.printf(" %s ", n.Op)
+		if y, ok := n.Y.(*BinaryExpr); ok {
+			p.parenIf(priority[y.Op].left <= op.right, y)
+		} else {
+			p.expr(n.Y)
+		}
+	default:
+		panic(fmt.Sprintf("ast.Fprint: unexpected expression type %T", n))
+	}
+}
+
+func (p *printer) paren(x Expr) {
+	p.print("(")
+	p.expr(x)
+	p.print(")")
+}
+
+func (p *printer) parenIf(cond bool, x Expr) {
+	if cond {
+		p.paren(x)
+	} else {
+		p.expr(x)
+	}
+}
+
+// prefixExpr prints x as the prefix of a call or an index, which
+// must be parenthesized unless it is a variable or a call.
+func (p *printer) prefixExpr(x Expr) {
+	switch x.(type) {
+	case *NameExpr, *IndexExpr, *SelectorExpr, *CallExpr, *MethodCallExpr, *ParenExpr:
+		p.expr(x)
+	default:
+		p.paren(x)
+	}
+}
+
+// args prints the arguments of a call ending at end.
+func (p *printer) args(args []Expr, end Pos) {
+	p.print("(")
+	p.exprList(args)
+	p.inline(end, true)
+	p.print(")")
+}
+
+func (p *printer) funcBody(fn *FuncExpr) {
+	p.print("(")
+	for i, param := range fn.Params {
+		if i > 0 {
+			p.print(", ")
+		}
+		p.print(param.Name)
+	}
+	if fn.Vararg {
+		if len(fn.Params) > 0 {
+			p.print(", ")
+		}
+		p.print("...")
+	}
+	p.print(")")
+	p.body(fn.Body)
+	p.print("end")
+}
+
+// table prints the table constructor t with one field per line,
+// keeping the comments found between the fields in place.
+func (p *printer) table(t *TableExpr) {
+	p.print("{")
+	p.trailing(t.Pos().Line, t.Fields[0].Pos())
+	p.buf.WriteByte('\n')
+	p.indent++
+	for _, f := range t.Fields {
+		p.comments(f.Pos())
+		p.line()
+		p.field(f)
+		p.print(",")
+		p.trailing(f.End().Line, t.End())
+		p.buf.WriteByte('\n')
+	}
+	p.comments(t.End())
+	p.indent--
+	p.line()
+	p.print("}")
+}
+
+// isMultiline reports whether some field of t starts on a line after
+// the end of the previous field (or of the opening brace) in the source.
+func isMultiline(t *TableExpr) bool {
+	line := t.Pos().Line
+	for _, f := range t.Fields {
+		if f.Pos().Line > line {
+			return true
+		}
+		line = f.End().Line
+	}
+	return false
+}
+
+func (p *printer) field(f *Field) {
+	switch f.Kind {
+	case NamedField:
+		if key, ok := f.Key.(*StringExpr); ok && isName(key.Value) {
+			p.printf("%s = ", key.Value)
+			break
+		}
+		fallthrough
+	case KeyedField:
+		p.print("[")
+		p.expr(f.Key)
+		p.print("] = ")
+	}
+	p.expr(f.Value)
+}
+
+// isUnary reports whether x is printed starting with a unary operator.
+func isUnary(x Expr) bool {
+	switch x := x.(type) {
+	case *UnaryExpr:
+		return true
+	case *IntExpr:
+		return x.Raw == "" && x.Value < 0 && x.Value != math.MinInt64
+	case *FloatExpr:
+		return x.Raw == "" && math.Signbit(x.Value) && !math.IsNaN(x.Value)
+	}
+	return false
+}
+
+// isName reports whether s is a valid name (and not a reserved word).
+func isName(s string) bool {
+	if s == "" || syntax.IsReserved(s) {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if !(c == '_' || 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || i > 0 && '0' <= c && c <= '9') {
+			return false
+		}
+	}
+	return true
+}
+
+// formatFloat formats f as a Lua float numeral.
+func formatFloat(f float64) string {
+	switch {
+	case math.IsInf(f, 1):
+		return "1e9999"
+	case math.IsInf(f, -1):
+		return "-1e9999"
+	case math.IsNaN(f):
+		return "(0/0)"
+	}
+	s := strconv.FormatFloat(f, 'g', -1, 64)
+	if !strings.ContainsAny(s, ".e") {
+		s += ".0"
+	}
+	return s
+}
+
+// quote returns s as a double-quoted Lua string literal.
+func quote(s string) string {
+	var b strings.Builder
+	b.WriteByte('"')
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; c {
+		case '"', '\\':
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		case '\n':
+			b.WriteString(`\n`)
+		case '\r':
+			b.WriteString(`\r`)
+		case '\t':
+			b.WriteString(`\t`)
+		default:
+			if c < ' ' || c >= 0x7F {
+				fmt.Fprintf(&b, `\%03d`, c)
+			} else {
+				b.WriteByte(c)
+			}
+		}
+	}
+	b.WriteByte('"')
+	return b.String()
+}
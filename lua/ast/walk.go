@@ -0,0 +1,185 @@
+package ast
+
+import "fmt"
+
+// A Visitor's Visit method is invoked for each node encountered by Walk.
+// If the result visitor w is not nil, Walk visits each of the children
+// of node with the visitor w, followed by a call of w.Visit(nil).
+type Visitor interface {
+	Visit(node Node) (w Visitor)
+}
+
+// Walk traverses a syntax tree in depth-first order: it starts by
+// calling v.Visit(node); node must not be nil. If the visitor w returned
+// by v.Visit(node) is not nil, Walk is invoked recursively with visitor
+// w for each of the non-nil children of node, followed by a call of
+// w.Visit(nil).
+//
+// Comments are not visited.
+func Walk(v Visitor, node Node) {
+	if v = v.Visit(node); v == nil {
+		return
+	}
+	switch n := node.(type) {
+	// Expressions
+	case *NilExpr, *TrueExpr, *FalseExpr, *IntExpr, *FloatExpr,
+		*StringExpr, *VarargExpr, *NameExpr:
+		// nothing to do
+
+	case *IndexExpr:
+		Walk(v, n.X)
+		Walk(v, n.Key)
+
+	case *SelectorExpr:
+		Walk(v, n.X)
+		Walk(v, n.Sel)
+
+	case *CallExpr:
+		Walk(v, n.Fn)
+		walkExprs(v, n.Args)
+
+	case *MethodCallExpr:
+		Walk(v, n.X)
+		Walk(v, n.Method)
+		walkExprs(v, n.Args)
+
+	case *ParenExpr:
+		Walk(v, n.X)
+
+	case *FuncExpr:
+		for _, param := range n.Params {
+			Walk(v, param)
+		}
+		Walk(v, n.Body)
+
+	case *TableExpr:
+		for _, f := range n.Fields {
+			Walk(v, f)
+		}
+
+	case *Field:
+		if n.Key != nil {
+			Walk(v, n.Key)
+		}
+		Walk(v, n.Value)
+
+	case *UnaryExpr:
+		Walk(v, n.X)
+
+	case *BinaryExpr:
+		Walk(v, n.X)
+		Walk(v, n.Y)
+
+	// Statements
+	case *LocalStmt:
+		for _, name := range n.Names {
+			Walk(v, name)
+		}
+		walkExprs(v, n.Values)
+
+	case *LocalName, *BreakStmt:
+		// nothing to do
+
+	case *AssignStmt:
+		walkExprs(v, n.Targets)
+		walkExprs(v, n.Values)
+
+	case *CallStmt:
+		Walk(v, n.Call)
+
+	case *DoStmt:
+		Walk(v, n.Body)
+
+	case *WhileStmt:
+		Walk(v, n.Cond)
+		Walk(v, n.Body)
+
+	case *RepeatStmt:
+		Walk(v, n.Body)
+		Walk(v, n.Cond)
+
+	case *IfStmt:
+		for _, c := range n.Clauses {
+			Walk(v, c)
+		}
+		if n.Else != nil {
+			Walk(v, n.Else)
+		}
+
+	case *IfClause:
+		Walk(v, n.Cond)
+		Walk(v, n.Body)
+
+	case *NumericForStmt:
+		Walk(v, n.Var)
+		Walk(v, n.Start)
+		Walk(v, n.Limit)
+		if n.Step != nil {
+			Walk(v, n.Step)
+		}
+		Walk(v, n.Body)
+
+	case *GenericForStmt:
+		for _, name := range n.Names {
+			Walk(v, name)
+		}
+		walkExprs(v, n.Exprs)
+		Walk(v, n.Body)
+
+	case *FunctionStmt:
+		Walk(v, n.Name)
+		if n.Method != nil {
+			Walk(v, n.Method)
+		}
+		Walk(v, n.Func)
+
+	case *LocalFunctionStmt:
+		Walk(v, n.Name)
+		Walk(v, n.Func)
+
+	case *ReturnStmt:
+		walkExprs(v, n.Values)
+
+	case *GotoStmt:
+		Walk(v, n.Label)
+
+	case *LabelStmt:
+		Walk(v, n.Name)
+
+	// Blocks
+	case *Block:
+		for _, stmt := range n.Stmts {
+			Walk(v, stmt)
+		}
+
+	case *Chunk:
+		Walk(v, n.Block)
+
+	default:
+		panic(fmt.Sprintf("ast.Walk: unexpected node type %T", n))
+	}
+	v.Visit(nil)
+}
+
+func walkExprs(v Visitor, list []Expr) {
+	for _, x := range list {
+		Walk(v, x)
+	}
+}
+
+type inspector func(Node) bool
+
+func (f inspector) Visit(node Node) Visitor {
+	if f(node) {
+		return f
+	}
+	return nil
+}
+
+// Inspect traverses a syntax tree in depth-first order: it starts by
+// calling f(node); node must not be nil. If f returns true, Inspect
+// invokes f recursively for each of the non-nil children of node,
+// followed by a call of f(nil).
+func Inspect(node Node, f func(Node) bool) {
+	Walk(inspector(f), node)
+}
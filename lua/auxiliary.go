@@ -3,6 +3,7 @@ package lua
 import (
 	"fmt"
 	"io"
+	"strings"
 	"syscall"
 )
 
@@ -236,6 +237,82 @@ func (state *State) Where(level int) {
 	state.Push("")
 }
 
+// Traceback creates and pushes a traceback of the stack of thread. If msg is
+// not empty it is appended at the beginning of the traceback. The level
+// parameter tells at which level to start the traceback. Frames reused by
+// tail calls are marked with "(...tail calls...)".
+//
+// See https://www.lua.org/manual/5.3/manual.html#luaL_traceback
+func (state *State) Traceback(thread *State, msg string, level int) {
+	state.Push(thread.traceback(msg, level))
+}
+
+// traceback returns a traceback of the stack of the thread (see Traceback).
+func (thread *State) traceback(msg string, level int) string {
+	const (
+		levels1 = 10 // size of the first part of the stack
+		levels2 = 11 // size of the second part of the stack
+	)
+	var (
+		b     strings.Builder
+		debug Debug
+		last  = max(level, thread.lastLevel())
+	)
+	if msg != "" {
+		b.WriteString(msg)
+		b.WriteString("\n")
+	}
+	b.WriteString("stack traceback:")
+	for ; thread.GetStack(&debug, level) == nil; level++ {
+		if last-level > levels2 && level == levels1 {
+			n := last - level - levels2
+			fmt.Fprintf(&b, "\n\t...\t(skipping %d levels)", n)
+			level += n - 1 // and skip to last ones
+			continue
+		}
+		thread.GetInfo(&debug, "Slnt")
+		if debug.active <= 0 {
+			fmt.Fprintf(&b, "\n\t%s: in ", debug.short)
+		} else {
+			fmt.Fprintf(&b, "\n\t%s:%d: in ", debug.short, debug.active)
+		}
+		switch {
+		case debug.name != "":
+			fmt.Fprintf(&b, "function '%s'", debug.name)
+		case debug.what == "main":
+			b.WriteString("main chunk")
+		case debug.what == "Go":
+			b.WriteString("?")
+		default:
+			fmt.Fprintf(&b, "function <%s:%d>", debug.short, debug.span[0])
+		}
+		if debug.tailcall {
+			b.WriteString("\n\t(...tail calls...)")
+		}
+	}
+	return b.String()
+}
+
+// lastLevel returns the first level past the stack of the thread. The frames
+// are walked from the top to get a level, so it is searched for in log time.
+func (thread *State) lastLevel() int {
+	var (
+		debug  Debug
+		lo, hi = 0, 1
+	)
+	for thread.GetStack(&debug, hi) == nil {
+		lo, hi = hi, hi*2
+	}
+	for lo < hi {
+		if m := (lo + hi) / 2; thread.GetStack(&debug, m) == nil {
+			lo = m + 1
+		} else {
+			hi = m
+		}
+	}
+	return lo
+}
+
 // Errorf raises an error.
 //
 // The error message format is given by fmt plus any extra arguments, following
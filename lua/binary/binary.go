@@ -14,6 +14,7 @@ var (
 const (
 	LUA_SIGNATURE    = "\x1bLua"
 	LUAC_VERSION     = 0x53
+	LUAC_VERSION_54  = 0x54
 	LUAC_FORMAT      = 0
 	LUAC_DATA        = "\x19\x93\r\n\x1a\n"
 	CINT_SIZE        = 4
@@ -49,6 +50,17 @@ const (
 	LUA_GO_CLOSURE = LUA_TYPE_FUNC | (2 << 4)   // go closure
 )
 
+// lua-5.4/src/lobject.h
+const (
+	LUA_VNIL    = LUA_TYPE_NIL | (0 << 4)    // nil
+	LUA_VFALSE  = LUA_TYPE_BOOL | (0 << 4)   // false
+	LUA_VTRUE   = LUA_TYPE_BOOL | (1 << 4)   // true
+	LUA_VNUMINT = LUA_TYPE_NUMBER | (0 << 4) // integer numbers
+	LUA_VNUMFLT = LUA_TYPE_NUMBER | (1 << 4) // floating-point numbers
+	LUA_VSHRSTR = LUA_TYPE_STRING | (0 << 4) // short strings
+	LUA_VLNGSTR = LUA_TYPE_STRING | (1 << 4) // long strings
+)
+
 type (
 	Prototype struct {
 		Source   string
@@ -89,6 +101,7 @@ type (
 	UpValue struct {
 		InStack byte
 		Index   byte
+		Kind    byte // kind of the captured variable (5.4 only)
 	}
 
 	Chunk struct {
@@ -103,6 +116,14 @@ func (proto *Prototype) IsVararg() bool              { return int(proto.Vararg)
 func (proto *Prototype) Const(index int) interface{} { return proto.Consts[index] }
 func (proto *Prototype) Proto(index int) *Prototype  { return &proto.Protos[index] }
 
+// lua-5.4/src/lparser.h
+const (
+	VDKREG     = iota // regular variable
+	RDKCONST          // constant
+	RDKTOCLOSE        // to-be-closed
+	RDKCTC            // compile-time constant
+)
+
 func (upval *UpValue) IsLocal() bool { return upval.InStack == 1 }
 func (upval *UpValue) AtIndex() int  { return int(upval.Index) }
 
@@ -119,7 +140,11 @@ func Load(data []byte) (chunk Chunk, err error) {
 			}
 		}
 	}()
-	decode(bytes.NewBuffer(data), &chunk)
+	if len(data) > 4 && data[4] == LUAC_VERSION_54 {
+		decode54(bytes.NewBuffer(data), &chunk)
+	} else {
+		decode(bytes.NewBuffer(data), &chunk)
+	}
 	return chunk, err
 }
 
@@ -133,9 +158,20 @@ func Dump(proto *Prototype, strip bool) []byte {
 	return b.Bytes()
 }
 
+// Dump54 is like Dump but produces a binary chunk in the Lua 5.4
+// format; if strip is true the debug information is left out.
+func Dump54(proto *Prototype, strip bool) []byte {
+	var b bytes.Buffer
+	w := &writer{b: &b}
+	w.writeHeader54()
+	w.writeByte(byte(len(proto.UpValues)))
+	encodeProto54(w, proto, "", strip)
+	return b.Bytes()
+}
+
 func assert(cond bool, mesg string) {
 	if !cond {
-		panic(fmt.Errorf(mesg))
+		panic(fmt.Errorf("%s", mesg))
 	}
 }
 
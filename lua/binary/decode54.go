@@ -0,0 +1,196 @@
+package binary
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+)
+
+// absLineInfo marks an entry of the 5.4 line information whose line
+// is given absolutely rather than as a difference from the previous
+// instruction's.
+const absLineInfo = -0x80
+
+// header54 is the header of a 5.4 binary chunk, which no longer
+// records the sizes of int and size_t.
+type header54 struct {
+	Signature  [4]byte
+	Version    byte
+	Format     byte
+	LuacData   [6]byte
+	InstrSize  byte
+	LuaIntSize byte
+	LuaNumSize byte
+	LuacIntEnc int64
+	LuacNumEnc float64
+}
+
+func decode54(r *bytes.Buffer, c *Chunk) {
+	var h header54
+	must(binary.Read(r, order, &h))
+
+	assert(h.Signature == head, "not a precompiled chunk")
+	assert(h.Version == LUAC_VERSION_54, "version mismatch")
+	assert(h.Format == LUAC_FORMAT, "format mismatch")
+	assert(h.LuacData == tail, "corrupted")
+	assert(h.InstrSize == INSTRUCTION_SIZE, "instruction size mismatch")
+	assert(h.LuaIntSize == LUA_INTEGER_SIZE, "lua integer size mismatch")
+	assert(h.LuaNumSize == LUA_NUMBER_SIZE, "lua number size mismatch")
+	assert(h.LuacIntEnc == LUAC_INT, "endianess mismatch")
+	assert(h.LuacNumEnc == LUAC_NUM, "float format mismatch")
+	c.Header = Header{
+		Signature:  h.Signature,
+		Version:    h.Version,
+		Format:     h.Format,
+		LuacData:   h.LuacData,
+		InstrSize:  h.InstrSize,
+		LuaIntSize: h.LuaIntSize,
+		LuaNumSize: h.LuaNumSize,
+		LuacIntEnc: h.LuacIntEnc,
+		LuacNumEnc: h.LuacNumEnc,
+	}
+
+	// decode size_upvalues
+	_, err := r.ReadByte()
+	must(err)
+
+	// decode container closure prototype
+	decodePrototype54(r, &c.Entry, "")
+}
+
+func decodePrototype54(r *bytes.Buffer, proto *Prototype, psource string) {
+	// decode source name string; functions with the same
+	// source as their parent leave it out.
+	var ok bool
+	if proto.Source, ok = decodeString54(r); !ok {
+		proto.Source = psource
+	}
+	proto.SrcPos = uint32(decodeSize(r))
+	proto.EndPos = uint32(decodeSize(r))
+	proto.Params = decodeByte(r)
+	proto.Vararg = decodeByte(r)
+	proto.Stack = decodeByte(r)
+
+	// decode instruction bytecode
+	proto.Code = make([]uint32, decodeSize(r))
+	for i := range proto.Code {
+		must(binary.Read(r, order, &proto.Code[i]))
+	}
+
+	// decode constants
+	proto.Consts = make([]interface{}, decodeSize(r))
+	for i := range proto.Consts {
+		proto.Consts[i] = decodeConst54(r)
+	}
+
+	// decode upvalues
+	proto.UpValues = make([]UpValue, decodeSize(r))
+	for i := range proto.UpValues {
+		proto.UpValues[i] = UpValue{
+			InStack: decodeByte(r),
+			Index:   decodeByte(r),
+			Kind:    decodeByte(r),
+		}
+	}
+
+	// decode nested closure prototypes
+	proto.Protos = make([]Prototype, decodeSize(r))
+	for i := range proto.Protos {
+		decodePrototype54(r, &proto.Protos[i], proto.Source)
+	}
+
+	// decode line info; the relative and absolute line
+	// information is turned into one line per instruction.
+	lineinfo := r.Next(decodeSize(r))
+	abslineinfo := make([][2]int, decodeSize(r))
+	for i := range abslineinfo {
+		abslineinfo[i] = [2]int{decodeSize(r), decodeSize(r)}
+	}
+	if len(lineinfo) > 0 {
+		proto.PcLnTab = make([]uint32, len(lineinfo))
+		line := int(proto.SrcPos)
+		for pc, diff := range lineinfo {
+			if int8(diff) == absLineInfo {
+				assert(len(abslineinfo) > 0 && abslineinfo[0][0] == pc, "corrupted")
+				line, abslineinfo = abslineinfo[0][1], abslineinfo[1:]
+			} else {
+				line += int(int8(diff))
+			}
+			proto.PcLnTab[pc] = uint32(line)
+		}
+	}
+
+	// decode local variables
+	proto.Locals = make([]LocalVar, decodeSize(r))
+	for i := range proto.Locals {
+		name, _ := decodeString54(r)
+		proto.Locals[i] = LocalVar{
+			Name: name,
+			Live: uint32(decodeSize(r)),
+			Dead: uint32(decodeSize(r)),
+		}
+	}
+
+	// decode upvalue names
+	proto.UpNames = make([]string, decodeSize(r))
+	for i := range proto.UpNames {
+		proto.UpNames[i], _ = decodeString54(r)
+	}
+}
+
+func decodeByte(r *bytes.Buffer) byte {
+	b, err := r.ReadByte()
+	must(err)
+	return b
+}
+
+// decodeSize decodes an unsigned integer written in groups of
+// 7 bits, most significant first, with the last byte marked by
+// its high bit.
+func decodeSize(r *bytes.Buffer) int {
+	var x uint64
+	for {
+		b := decodeByte(r)
+		assert(x < 1<<57, "integer overflow")
+		x = x<<7 | uint64(b&0x7F)
+		if b&0x80 != 0 {
+			return int(x)
+		}
+	}
+}
+
+// decodeString54 decodes a string, reporting false for a
+// missing (NULL) one.
+func decodeString54(r *bytes.Buffer) (string, bool) {
+	size := decodeSize(r)
+	if size == 0 {
+		return "", false
+	}
+	b := r.Next(size - 1)
+	assert(len(b) == size-1, "truncated precompiled chunk")
+	return string(b), true
+}
+
+func decodeConst54(r *bytes.Buffer) interface{} {
+	switch t := decodeByte(r); t {
+	case LUA_VNIL:
+		return nil
+	case LUA_VFALSE:
+		return false
+	case LUA_VTRUE:
+		return true
+	case LUA_VNUMINT:
+		var i64 int64
+		must(binary.Read(r, order, &i64))
+		return i64
+	case LUA_VNUMFLT:
+		var f64 float64
+		must(binary.Read(r, order, &f64))
+		return f64
+	case LUA_VSHRSTR, LUA_VLNGSTR:
+		s, _ := decodeString54(r)
+		return s
+	default:
+		panic(fmt.Errorf("unexpected constant type: %d", t))
+	}
+}
@@ -0,0 +1,166 @@
+package binary
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+const (
+	// limLineDiff is the limit for differences between lines in the
+	// relative line information.
+	limLineDiff = 0x80
+
+	// maxIWthAbs is the maximum number of successive instructions
+	// without absolute line information.
+	maxIWthAbs = 128
+
+	// maxShortLen is the maximum length of a short string.
+	maxShortLen = 40
+)
+
+func (w *writer) writeHeader54() {
+	h := &header54{
+		Version:    LUAC_VERSION_54,
+		Format:     LUAC_FORMAT,
+		InstrSize:  INSTRUCTION_SIZE,
+		LuaIntSize: LUA_INTEGER_SIZE,
+		LuaNumSize: LUA_NUMBER_SIZE,
+		LuacIntEnc: LUAC_INT,
+		LuacNumEnc: LUAC_NUM,
+	}
+	copy(h.Signature[:], LUA_SIGNATURE)
+	copy(h.LuacData[:], LUAC_DATA)
+	binary.Write(w.b, order, h)
+}
+
+// writeSize writes x in groups of 7 bits, most significant first,
+// marking the last byte with its high bit.
+func (w *writer) writeSize(x int) {
+	var buf [10]byte
+	n := len(buf)
+	for {
+		n--
+		buf[n] = byte(x & 0x7F)
+		if x >>= 7; x == 0 {
+			break
+		}
+	}
+	buf[len(buf)-1] |= 0x80
+	w.b.Write(buf[n:])
+}
+
+// writeStr54 writes str; a nil str writes a missing (NULL) string.
+func (w *writer) writeStr54(str *string) {
+	if str == nil {
+		w.writeSize(0)
+		return
+	}
+	w.writeSize(len(*str) + 1)
+	w.b.WriteString(*str)
+}
+
+func encodeProto54(w *writer, p *Prototype, psource string, strip bool) {
+	if strip || p.Source == psource {
+		w.writeStr54(nil) // no debug info or same source as its parent
+	} else {
+		w.writeStr54(&p.Source)
+	}
+	w.writeSize(int(p.SrcPos))
+	w.writeSize(int(p.EndPos))
+	w.writeByte(p.Params)
+	w.writeByte(p.Vararg)
+	w.writeByte(p.Stack)
+
+	w.writeSize(len(p.Code))
+	for _, code := range p.Code {
+		w.writeU32(code)
+	}
+
+	w.writeSize(len(p.Consts))
+	for _, kst := range p.Consts {
+		switch kst := kst.(type) {
+		case nil:
+			w.writeByte(LUA_VNIL)
+		case bool:
+			if kst {
+				w.writeByte(LUA_VTRUE)
+			} else {
+				w.writeByte(LUA_VFALSE)
+			}
+		case int64:
+			w.writeByte(LUA_VNUMINT)
+			w.writeI64(kst)
+		case float64:
+			w.writeByte(LUA_VNUMFLT)
+			w.writeF64(kst)
+		case string:
+			if len(kst) > maxShortLen {
+				w.writeByte(LUA_VLNGSTR)
+			} else {
+				w.writeByte(LUA_VSHRSTR)
+			}
+			w.writeStr54(&kst)
+		default:
+			panic(fmt.Errorf("write: unknown constant type: %T", kst))
+		}
+	}
+
+	w.writeSize(len(p.UpValues))
+	for _, upvalue := range p.UpValues {
+		w.writeByte(upvalue.InStack)
+		w.writeByte(upvalue.Index)
+		w.writeByte(upvalue.Kind)
+	}
+
+	w.writeSize(len(p.Protos))
+	for i := range p.Protos {
+		encodeProto54(w, &p.Protos[i], p.Source, strip)
+	}
+
+	if strip {
+		w.writeSize(0) // lineinfo
+		w.writeSize(0) // abslineinfo
+		w.writeSize(0) // locvars
+		w.writeSize(0) // upvalue names
+		return
+	}
+	lineinfo, abslineinfo := encodeLineInfo(p)
+	w.writeSize(len(lineinfo))
+	w.b.Write(lineinfo)
+	w.writeSize(len(abslineinfo))
+	for _, abs := range abslineinfo {
+		w.writeSize(abs[0])
+		w.writeSize(abs[1])
+	}
+	w.writeSize(len(p.Locals))
+	for _, loc := range p.Locals {
+		w.writeStr54(&loc.Name)
+		w.writeSize(int(loc.Live))
+		w.writeSize(int(loc.Dead))
+	}
+	w.writeSize(len(p.UpNames))
+	for i := range p.UpNames {
+		w.writeStr54(&p.UpNames[i])
+	}
+}
+
+// encodeLineInfo turns the line of each instruction into the
+// differences from the previous one, falling back to absolute
+// (pc, line) entries for large differences and at regular
+// intervals, as the reference compiler does.
+func encodeLineInfo(p *Prototype) (lineinfo []byte, abslineinfo [][2]int) {
+	prev, iwthabs := int(p.SrcPos), 0
+	for pc, ln := range p.PcLnTab {
+		line := int(ln)
+		diff := line - prev
+		if diff <= -limLineDiff || diff >= limLineDiff || iwthabs >= maxIWthAbs {
+			abslineinfo = append(abslineinfo, [2]int{pc, line})
+			diff = absLineInfo
+			iwthabs = 0
+		}
+		iwthabs++
+		lineinfo = append(lineinfo, byte(int8(diff)))
+		prev = line
+	}
+	return lineinfo, abslineinfo
+}
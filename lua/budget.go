@@ -0,0 +1,87 @@
+package lua
+
+import (
+	"fmt"
+	"math"
+)
+
+// BudgetError is the error raised when a state runs out of its instruction
+// budget (see WithInstructionLimit and SetBudget); Limit is the number of
+// instructions it was allowed to use.
+//
+// A BudgetError stops the execution of the state: it is not caught by PCall
+// when called from a running function (and so by the pcall Lua function),
+// or by the resumer of a coroutine, but only returned by the PCall of the
+// host.
+type BudgetError struct {
+	Limit int64
+}
+
+func (e *BudgetError) Error() string {
+	return fmt.Sprintf("instruction budget exceeded (limit = %d)", e.Limit)
+}
+
+// SetBudget sets the number of instructions the state may still execute,
+// counting the costs charged by Go functions (see Charge); a negative n
+// removes the limit. The budget is shared by all the threads of the state.
+func (state *State) SetBudget(n int64) {
+	g := state.global
+	if n < 0 || n > math.MaxInt64-g.used {
+		g.limit = math.MaxInt64
+	} else {
+		g.limit = g.used + n
+	}
+	g.schedule()
+}
+
+// Used returns the number of instructions executed by the state, plus the
+// costs charged by Go functions.
+func (state *State) Used() int64 { return state.global.used }
+
+// Charge adds cost to the number of instructions used by the state, and
+// raises a *BudgetError if it exceeds the budget. Go functions use it to
+// account for expensive operations, in instructions; such as the string
+// and table functions of the standard library, which charge for the bytes
+// and elements they process.
+func (state *State) Charge(cost int64) {
+	g := state.global
+	if cost > math.MaxInt64-g.used { // the count saturates
+		cost = math.MaxInt64 - g.used
+	}
+	if g.used += cost; g.used > g.limit {
+		state.panic(&BudgetError{Limit: g.limit})
+	}
+}
+
+// bytesPerInstr is the number of bytes Go functions may produce or scan for
+// the cost of one instruction (see ChargeBytes).
+const bytesPerInstr = 16
+
+// ChargeBytes charges the state for producing or scanning n bytes, such as
+// the strings built or searched by the functions of the standard library:
+// an instruction every 16 bytes (see Charge).
+func (state *State) ChargeBytes(n int64) { state.Charge(n / bytesPerInstr) }
+
+// tick is called by the executors when the instruction counter reaches the
+// next check: it raises an error if the budget is exceeded or the context
+// is done, then calls the pending __gc metamethods on the main thread (see
+// callFinalizers) unless the collector is stopped.
+func (state *State) tick() {
+	g := state.global
+	if g.used > g.limit {
+		state.panic(&BudgetError{Limit: g.limit})
+	}
+	state.checkContext()
+	g.schedule()
+	if len(g.tobefnz) > 0 && !g.gcstop {
+		state.callFinalizers()
+	}
+}
+
+// schedule sets the value of the instruction counter at which the executors
+// check the budget and context next.
+func (g *global) schedule() {
+	if g.next = g.used + contextCheckInterval; g.limit < g.next {
+		g.next = g.limit + 1
+	}
+}
@@ -0,0 +1,92 @@
+package lua
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/Azure/golua/lua/binary"
+)
+
+// chunkCache is a directory of binary chunks compiled from source (see
+// WithChunkCache).
+//
+// An entry is named after the hash of the source, the chunk name, the
+// version byte of the chunk and compilerVersion; it holds the dumped chunk followed by its
+// SHA-256 sum, which is checked on lookup so that truncated or otherwise
+// corrupt entries are detected (binary.Load does not always notice).
+type chunkCache struct {
+	dir     string
+	version byte
+}
+
+// compilerVersion identifies the code generated by the compilers (see
+// syntax.Compile and syntax.Compile54) in the keys of the chunk cache: it
+// must be bumped whenever they generate different code for a source, so
+// that the entries they compiled before are not used. The optimizer runs
+// on the prototypes when they are loaded, after the cache.
+const compilerVersion = 1
+
+// path returns the path of the entry for the source src of chunkname.
+func (cache *chunkCache) path(chunkname string, src []byte) string {
+	h := sha256.New()
+	h.Write([]byte{cache.version, compilerVersion})
+	h.Write([]byte(chunkname))
+	h.Write([]byte{0})
+	h.Write(src)
+	return filepath.Join(cache.dir, hex.EncodeToString(h.Sum(nil))+".luac")
+}
+
+// get returns the prototype cached for the source src of chunkname, or
+// nil if there is no valid entry.
+func (cache *chunkCache) get(chunkname string, src []byte) *binary.Prototype {
+	data, err := ioutil.ReadFile(cache.path(chunkname, src))
+	if err != nil || len(data) < sha256.Size {
+		return nil
+	}
+	data, sum := data[:len(data)-sha256.Size], data[len(data)-sha256.Size:]
+	if check := sha256.Sum256(data); !bytes.Equal(check[:], sum) || !binary.IsChunk(data) {
+		return nil
+	}
+	chunk, err := binary.Load(data)
+	if err != nil || chunk.Header.Version != cache.version {
+		return nil
+	}
+	return &chunk.Entry
+}
+
+// put stores the prototype compiled from the source src of chunkname.
+// The entry is written to a temporary file that is then renamed, so that
+// concurrent readers never see a partial entry. Errors are ignored: the
+// cache is only an optimization.
+func (cache *chunkCache) put(chunkname string, src []byte, proto *binary.Prototype) {
+	var data []byte
+	if cache.version == binary.LUAC_VERSION_54 {
+		data = binary.Dump54(proto, false)
+	} else {
+		data = binary.Dump(proto, false)
+	}
+	sum := sha256.Sum256(data)
+	data = append(data, sum[:]...)
+
+	if err := os.MkdirAll(cache.dir, 0755); err != nil {
+		return
+	}
+	tmp, err := ioutil.TempFile(cache.dir, ".tmp-")
+	if err != nil {
+		return
+	}
+	_, err = tmp.Write(data)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), cache.path(chunkname, src))
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+}
@@ -0,0 +1,312 @@
+package check
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/Azure/golua/lua/ast"
+)
+
+// annotations holds the annotations found in the comments before a
+// statement.
+type annotations struct {
+	class      *class         // ---@class
+	params     map[string]typ // ---@param, by name ("..." for the extra arguments)
+	order      []string       // names of the params, in order
+	pos        map[string]ast.Pos
+	results    []typ // ---@return
+	varResults typ
+	returns    bool  // whether there was a ---@return
+	types      []typ // ---@type
+}
+
+// isFunc reports whether a describes a function.
+func (a *annotations) isFunc() bool { return len(a.params) > 0 || a.returns }
+
+// annotations parses the annotations found in comments.
+func (c *checker) annotations(comments []*ast.Comment) *annotations {
+	a := &annotations{params: make(map[string]typ), pos: make(map[string]ast.Pos)}
+	var cls *class // class the ---@field tags belong to
+	for _, cmt := range comments {
+		if !strings.HasPrefix(cmt.Text, "---@") {
+			continue
+		}
+		tag := strings.Fields(cmt.Text[4:] + " ")
+		if len(tag) == 0 {
+			continue
+		}
+		p := &typeParser{c: c, s: strings.TrimSpace(cmt.Text[4+len(tag[0]):])}
+		switch tag[0] {
+		case "class":
+			cls = c.classDecl(p)
+			if a.class == nil {
+				a.class = cls
+			}
+		case "field":
+			if cls == nil {
+				p.errorf("@field outside of a class")
+				break
+			}
+			p.word() // visibility
+			if name, ok := p.fieldName(); ok {
+				cls.fields[name] = p.optional(name, p.typ())
+			}
+		case "param":
+			name := p.paramName()
+			if name == "" {
+				p.errorf("missing parameter name")
+				break
+			}
+			t := p.optional(name, p.typ())
+			name = strings.TrimSuffix(name, "?")
+			if _, ok := a.params[name]; !ok {
+				a.order = append(a.order, name)
+			}
+			a.params[name], a.pos[name] = t, cmt.Pos()
+		case "return":
+			a.returns = true
+			for {
+				if p.skip("...") {
+					a.varResults = p.typ()
+					break
+				}
+				a.results = append(a.results, p.typ())
+				if !p.skip(",") {
+					break
+				}
+			}
+		case "type":
+			for {
+				a.types = append(a.types, p.typ())
+				if !p.skip(",") {
+					break
+				}
+			}
+		}
+		if p.err != "" {
+			c.errorf(cmt.Pos(), "invalid annotation: %s", p.err)
+		}
+	}
+	return a
+}
+
+// classDecl parses the name and parent of a class declaration and returns
+// the class, declaring it if needed.
+func (c *checker) classDecl(p *typeParser) *class {
+	name := p.name()
+	if name == "" {
+		p.errorf("missing class name")
+		return newClass("?", false)
+	}
+	cls, ok := c.classes[name]
+	if !ok {
+		cls = newClass(name, false)
+		c.classes[name] = cls
+	}
+	if p.skip(":") {
+		parent := p.name()
+		switch pc, ok := c.classes[parent]; {
+		case !ok:
+			p.errorf("unknown class %q", parent)
+		case pc == cls:
+			p.errorf("class %q cannot inherit from itself", name)
+		default:
+			cls.parent = pc
+		}
+	}
+	return cls
+}
+
+// typeParser parses the types of annotations, as in
+//
+//	integer|string?    fun(x: number, ...: any): boolean    Class[]
+type typeParser struct {
+	c   *checker
+	s   string
+	err string
+}
+
+func (p *typeParser) errorf(format string, args ...interface{}) {
+	if p.err == "" {
+		p.err = fmt.Sprintf(format, args...)
+	}
+}
+
+func (p *typeParser) skipSpace() { p.s = strings.TrimLeft(p.s, " \t") }
+
+// skip consumes tok if it comes next.
+func (p *typeParser) skip(tok string) bool {
+	p.skipSpace()
+	if strings.HasPrefix(p.s, tok) {
+		p.s = p.s[len(tok):]
+		return true
+	}
+	return false
+}
+
+// name consumes a name, possibly dotted (as in "mod.Class").
+func (p *typeParser) name() string {
+	p.skipSpace()
+	i := 0
+	for i < len(p.s) && (isLetter(p.s[i]) || i > 0 && (isDigit(p.s[i]) || p.s[i] == '.')) {
+		i++
+	}
+	name := p.s[:i]
+	p.s = p.s[i:]
+	return name
+}
+
+// word consumes a visibility keyword, if any.
+func (p *typeParser) word() {
+	for _, w := range []string{"public ", "protected ", "private "} {
+		if p.skip(w) {
+			return
+		}
+	}
+}
+
+// fieldName consumes the name of a field, followed by '?' if the field
+// is optional.
+func (p *typeParser) fieldName() (string, bool) {
+	name := p.name()
+	if name == "" {
+		p.errorf("missing field name")
+		return "", false
+	}
+	if p.skip("?") {
+		name += "?"
+	}
+	return name, true
+}
+
+// paramName consumes the name of a parameter: a name (followed by '?' if
+// the parameter is optional) or "...".
+func (p *typeParser) paramName() string {
+	if p.skip("...") {
+		return "..."
+	}
+	name := p.name()
+	if name != "" && p.skip("?") {
+		name += "?"
+	}
+	return name
+}
+
+// optional returns t, or t|nil if name ends with '?'.
+func (p *typeParser) optional(name string, t typ) typ {
+	if strings.HasSuffix(name, "?") {
+		return join(t, tNil)
+	}
+	return t
+}
+
+// typ parses a type: member {'|' member}.
+func (p *typeParser) typ() typ {
+	t := p.member()
+	for p.skip("|") {
+		t = join(t, p.member())
+	}
+	return t
+}
+
+// member parses a type with its suffixes: primary {'?' | '[]'}.
+func (p *typeParser) member() typ {
+	t := p.primary()
+	for {
+		switch {
+		case p.skip("?"):
+			t = join(t, tNil)
+		case p.skip("[]"):
+			t = &array{t}
+		default:
+			return t
+		}
+	}
+}
+
+// primary parses a named type, a function type or a parenthesized type.
+func (p *typeParser) primary() typ {
+	if p.skip("(") {
+		t := p.typ()
+		if !p.skip(")") {
+			p.errorf("missing ')'")
+		}
+		return t
+	}
+	name := p.name()
+	switch name {
+	case "":
+		p.errorf("missing type")
+		return tAny
+	case "fun":
+		return p.funcType()
+	case "unknown":
+		return tAny
+	}
+	for b, s := range basicNames {
+		if s == name {
+			if p.skip("<") { // table<K, V>
+				for p.typ(); p.skip(","); p.typ() {
+				}
+				if !p.skip(">") {
+					p.errorf("missing '>'")
+				}
+			}
+			return basic(b)
+		}
+	}
+	if cls, ok := p.c.classes[name]; ok {
+		return cls
+	}
+	p.errorf("unknown type %q", name)
+	return tAny
+}
+
+// funcType parses the signature of a function type, after "fun".
+func (p *typeParser) funcType() typ {
+	f := new(fn)
+	if !p.skip("(") {
+		f.variadic, f.unknown = tAny, true
+		return f
+	}
+	for !p.skip(")") {
+		if len(f.params) > 0 || f.variadic != nil {
+			if !p.skip(",") {
+				p.errorf("missing ')'")
+				return f
+			}
+		}
+		name := p.paramName()
+		if name == "" {
+			p.errorf("missing parameter name")
+			return f
+		}
+		t := typ(tAny)
+		if p.skip(":") {
+			t = p.typ()
+		}
+		if name == "..." {
+			f.variadic = t
+		} else {
+			t = p.optional(name, t)
+			f.params = append(f.params, param{strings.TrimSuffix(name, "?"), t})
+		}
+	}
+	if p.skip(":") {
+		for {
+			if p.skip("...") {
+				f.varResults = p.typ()
+				break
+			}
+			f.results = append(f.results, p.typ())
+			if !p.skip(",") {
+				break
+			}
+		}
+	}
+	return f
+}
+
+func isLetter(c byte) bool { return 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || c == '_' }
+
+func isDigit(c byte) bool { return '0' <= c && c <= '9' }
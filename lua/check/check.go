@@ -0,0 +1,698 @@
+// Package check implements the optional gradual type checking of Lua
+// source text done by "glua check".
+//
+// Types are declared with annotations in comments starting with "---@"
+// before statements:
+//
+//	---@class Name [: Parent]   declares a class (a table type)
+//	---@field name[?] type      declares a field of the class above
+//	---@param name[?] type      declares a parameter of the function below
+//	---@param ... type          declares the type of its extra arguments
+//	---@return type {, type}    declares its results ("...type" for extra ones)
+//	---@type type {, type}      declares the variables of the statement below
+//
+// Types are written as any, nil, boolean, number, integer, string, table,
+// function, userdata, thread or the name of a class, and combined as in
+// "T?" (T or nil), "T|U", "T[]" (array of T) or "fun(x: T, ...: U): R".
+//
+// The checks are gradual: values of unannotated variables, parameters
+// and functions have type any, which is compatible with every type, as
+// are tables built by constructors. The checker reports calls to
+// annotated functions with wrong arguments, accesses to fields not
+// declared in annotated classes, return statements not matching the
+// declared results and assignments to annotated variables of values of
+// a different type. The functions of the standard libraries are checked
+// against stubs generated from the ones registered by std.
+package check
+
+//go:generate go run mkstubs.go
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/Azure/golua/lua/ast"
+	"github.com/Azure/golua/lua/syntax"
+)
+
+// Diagnostic is a type error found in a chunk.
+type Diagnostic struct {
+	Source  string // chunk name
+	Pos     ast.Pos
+	Message string
+}
+
+func (d Diagnostic) String() string {
+	return fmt.Sprintf("%s:%d:%d: %s", syntax.ChunkID(d.Source), d.Pos.Line, d.Pos.Column, d.Message)
+}
+
+// Source parses the Lua source text src of the chunk named chunkname and
+// returns the type errors found in it, or the syntax error, if any.
+func Source(chunkname string, src []byte) ([]Diagnostic, error) {
+	chunk, err := ast.Parse(chunkname, src)
+	if err != nil {
+		return nil, err
+	}
+	return Check(chunk), nil
+}
+
+// Check type checks the chunks together, so that the classes and global
+// functions declared in one can be used in the others, and returns the
+// errors found, sorted by chunk and position.
+func Check(chunks ...*ast.Chunk) []Diagnostic {
+	c := &checker{classes: make(map[string]*class), globals: make(map[string]*variable)}
+	c.chunk(stdStubs())
+
+	// The first pass declares the classes and globals.
+	for _, report := range []bool{false, true} {
+		c.report = report
+		for _, chunk := range chunks {
+			c.chunk(chunk)
+		}
+	}
+	index := make(map[string]int)
+	for i, chunk := range chunks {
+		index[chunk.Source] = i
+	}
+	sort.SliceStable(c.diags, func(i, j int) bool {
+		if a, b := index[c.diags[i].Source], index[c.diags[j].Source]; a != b {
+			return a < b
+		}
+		return c.diags[i].Pos.Offset < c.diags[j].Pos.Offset
+	})
+	return c.diags
+}
+
+var stubs struct {
+	once  sync.Once
+	chunk *ast.Chunk
+}
+
+// stdStubs returns the parsed stubs of the standard library functions.
+func stdStubs() *ast.Chunk {
+	stubs.once.Do(func() {
+		chunk, err := ast.Parse("=stubs", []byte(stdlib))
+		if err != nil {
+			panic(err)
+		}
+		stubs.chunk = chunk
+	})
+	return stubs.chunk
+}
+
+// variable is a local or global variable.
+type variable struct {
+	typ      typ
+	declared bool   // declared with an annotation: assignments are checked
+	class    *class // class of the table held by the variable, if it declared one
+}
+
+// scope holds the local variables declared in a block.
+type scope struct {
+	outer *scope
+	vars  map[string]*variable
+}
+
+type checker struct {
+	classes map[string]*class
+	globals map[string]*variable
+	scope   *scope
+	fn      *fn    // function being checked
+	source  string // name of the chunk being checked
+	report  bool   // whether to report errors
+	diags   []Diagnostic
+}
+
+func (c *checker) errorf(pos ast.Pos, format string, args ...interface{}) {
+	if c.report {
+		c.diags = append(c.diags, Diagnostic{c.source, pos, fmt.Sprintf(format, args...)})
+	}
+}
+
+func (c *checker) chunk(chunk *ast.Chunk) {
+	c.source, c.scope = chunk.Source, nil
+	c.fn = &fn{variadic: tAny, unknown: true}
+	c.scoped(chunk.Block)
+}
+
+func (c *checker) open() { c.scope = &scope{outer: c.scope, vars: make(map[string]*variable)} }
+
+func (c *checker) close() { c.scope = c.scope.outer }
+
+func (c *checker) declare(name string, v *variable) { c.scope.vars[name] = v }
+
+// lookup returns the variable name, or nil if it is an undeclared global.
+func (c *checker) lookup(name string) *variable {
+	for s := c.scope; s != nil; s = s.outer {
+		if v, ok := s.vars[name]; ok {
+			return v
+		}
+	}
+	return c.globals[name]
+}
+
+// ----------------------------------------------------------------------------
+// Statements
+
+// block checks the statements of b in the current scope.
+func (c *checker) block(b *ast.Block) {
+	for _, stmt := range b.Stmts {
+		c.stmt(stmt)
+	}
+	c.annotations(b.Comments) // may declare classes
+}
+
+// scoped checks b in a scope of its own.
+func (c *checker) scoped(b *ast.Block) {
+	c.open()
+	c.block(b)
+	c.close()
+}
+
+func (c *checker) stmt(stmt ast.Stmt) {
+	a := c.annotations(ast.CommentsOf(stmt).Lead)
+	switch n := stmt.(type) {
+	case *ast.LocalStmt:
+		types := c.values(n.Values, len(n.Names), a)
+		for i, name := range n.Names {
+			v := &variable{typ: types[i]}
+			if v.typ == tNil {
+				v.typ = tAny // set later
+			}
+			if i < len(a.types) {
+				if len(n.Values) > 0 && !assignable(a.types[i], types[i]) {
+					c.errorf(name.Pos(), "cannot use %s as %s in assignment to %s", types[i], a.types[i], name.Name)
+				}
+				v.typ, v.declared = a.types[i], true
+			}
+			if i == 0 && a.class != nil {
+				c.defineClass(v, a.class, types[i])
+			}
+			c.declare(name.Name, v)
+		}
+
+	case *ast.AssignStmt:
+		types := c.values(n.Values, len(n.Targets), a)
+		for i, target := range n.Targets {
+			if x, ok := target.(*ast.NameExpr); ok && c.lookup(x.Name) == nil {
+				v := &variable{typ: types[i]}
+				if i < len(a.types) {
+					v.typ, v.declared = a.types[i], true
+				}
+				if i == 0 && a.class != nil {
+					c.defineClass(v, a.class, types[i])
+				}
+				c.globals[x.Name] = v
+			}
+			c.assign(target, types[i])
+		}
+
+	case *ast.CallStmt:
+		c.expr(n.Call)
+
+	case *ast.DoStmt:
+		c.scoped(n.Body)
+
+	case *ast.WhileStmt:
+		c.expr(n.Cond)
+		c.scoped(n.Body)
+
+	case *ast.RepeatStmt:
+		c.open()
+		c.block(n.Body)
+		c.expr(n.Cond) // sees the locals of the body
+		c.close()
+
+	case *ast.IfStmt:
+		for _, clause := range n.Clauses {
+			c.expr(clause.Cond)
+			c.scoped(clause.Body)
+		}
+		if n.Else != nil {
+			c.scoped(n.Else)
+		}
+
+	case *ast.NumericForStmt:
+		t := c.arith(ast.OpAdd, c.expr(n.Start), c.expr(n.Limit))
+		if n.Step != nil {
+			t = c.arith(ast.OpAdd, t, c.expr(n.Step))
+		}
+		c.open()
+		c.declare(n.Var.Name, &variable{typ: t})
+		c.block(n.Body)
+		c.close()
+
+	case *ast.GenericForStmt:
+		c.exprs(n.Exprs)
+		c.open()
+		for i, name := range n.Names {
+			v := &variable{typ: tAny}
+			if i < len(a.types) {
+				v.typ, v.declared = a.types[i], true
+			}
+			c.declare(name.Name, v)
+		}
+		c.block(n.Body)
+		c.close()
+
+	case *ast.FunctionStmt:
+		var self typ
+		if n.Method != nil {
+			self = c.expr(n.Name)
+		}
+		f := c.signature(n.Func, a, self)
+		switch name := n.Name.(type) {
+		case *ast.NameExpr:
+			if n.Method != nil {
+				c.setField(name, self, n.Method.Name, f, true)
+			} else if v := c.lookup(name.Name); v == nil {
+				c.globals[name.Name] = &variable{typ: f}
+			} else if v.declared {
+				c.assign(name, f)
+			} else {
+				v.typ = f
+			}
+		case *ast.SelectorExpr:
+			if n.Method != nil {
+				c.setField(name, self, n.Method.Name, f, true)
+			} else {
+				c.setField(name.X, c.expr(name.X), name.Sel.Name, f, true)
+			}
+		}
+		c.body(n.Func, f)
+
+	case *ast.LocalFunctionStmt:
+		f := c.signature(n.Func, a, nil)
+		c.declare(n.Name.Name, &variable{typ: f})
+		c.body(n.Func, f)
+
+	case *ast.ReturnStmt:
+		c.returns(n)
+
+	case *ast.BreakStmt, *ast.GotoStmt, *ast.LabelStmt:
+		// nothing to do
+	}
+}
+
+// values returns the types of the values assigned to n variables,
+// checking function values with the annotations a.
+func (c *checker) values(list []ast.Expr, n int, a *annotations) []typ {
+	var types []typ
+	if len(list) == 1 && a.isFunc() {
+		if x, ok := list[0].(*ast.FuncExpr); ok {
+			f := c.signature(x, a, nil)
+			c.body(x, f)
+			types = []typ{f}
+		}
+	}
+	if types == nil {
+		var exact bool
+		if types, exact = c.exprTypes(list); !exact {
+			for len(types) < n {
+				types = append(types, tAny)
+			}
+		}
+	}
+	for len(types) < n {
+		types = append(types, tNil)
+	}
+	return types
+}
+
+// defineClass makes v the variable defining the class cls, whose
+// initial value has type t.
+func (c *checker) defineClass(v *variable, cls *class, t typ) {
+	v.typ, v.class = cls, cls
+	if init, ok := t.(*class); ok && init.open {
+		for name, ft := range init.fields {
+			if _, ok := cls.fields[name]; !ok {
+				cls.fields[name] = ft
+			}
+		}
+	}
+}
+
+// assign checks the assignment of a value of type t to target.
+func (c *checker) assign(target ast.Expr, t typ) {
+	switch x := target.(type) {
+	case *ast.NameExpr:
+		v := c.lookup(x.Name)
+		switch {
+		case v.declared:
+			if !assignable(v.typ, t) {
+				c.errorf(x.Pos(), "cannot use %s as %s in assignment to %s", t, v.typ, x.Name)
+			}
+		case v.class == nil && v.typ.String() != t.String():
+			v.typ = tAny // no longer known
+		}
+	case *ast.SelectorExpr:
+		c.setField(x.X, c.expr(x.X), x.Sel.Name, t, false)
+	case *ast.IndexExpr:
+		xt := c.expr(x.X)
+		c.expr(x.Key)
+		if arr, ok := xt.(*array); ok && !assignable(arr.elem, t) {
+			c.errorf(x.Pos(), "cannot use %s as %s in assignment to %s", t, arr.elem, ast.Sprint(x))
+		}
+	default:
+		c.expr(target)
+	}
+}
+
+// setField checks the assignment of a value of type t to the field name
+// of the table x of type xt, declaring the field if it belongs to an open
+// class, or to the class defined by x, or if define is set.
+func (c *checker) setField(x ast.Expr, xt typ, name string, t typ, define bool) {
+	cls, ok := xt.(*class)
+	if !ok {
+		return
+	}
+	if ft, ok := cls.field(name); ok {
+		if cls.open {
+			cls.fields[name] = join(ft, t)
+		} else if !assignable(ft, t) {
+			c.errorf(x.Pos(), "cannot use %s as %s in assignment to field %s of %s", t, ft, name, cls)
+		}
+		return
+	}
+	if define || cls.open || c.defines(x, cls) {
+		cls.fields[name] = t
+		return
+	}
+	c.errorf(x.Pos(), "undefined field %s of %s", name, cls)
+}
+
+// defines reports whether x is a variable defining the class cls.
+func (c *checker) defines(x ast.Expr, cls *class) bool {
+	if n, ok := x.(*ast.NameExpr); ok {
+		v := c.lookup(n.Name)
+		return v != nil && v.class == cls
+	}
+	return false
+}
+
+// signature returns the type of the function x described by a, with an
+// initial self parameter of the given type for methods.
+func (c *checker) signature(x *ast.FuncExpr, a *annotations, self typ) *fn {
+	f := &fn{results: a.results, varResults: a.varResults, unknown: !a.returns}
+	if self != nil {
+		f.params = append(f.params, param{"self", self})
+	}
+	names := make(map[string]bool)
+	for _, p := range x.Params {
+		t, ok := a.params[p.Name]
+		if !ok {
+			t = tAny
+		}
+		f.params = append(f.params, param{p.Name, t})
+		names[p.Name] = true
+	}
+	if x.Vararg {
+		if f.variadic = a.params["..."]; f.variadic == nil {
+			f.variadic = tAny
+		}
+		names["..."] = true
+	} else if !a.isFunc() {
+		f.variadic = tAny // extra arguments are not checked
+	}
+	for _, name := range a.order {
+		if !names[name] {
+			c.errorf(a.pos[name], "no parameter %s in function", name)
+		}
+	}
+	return f
+}
+
+// body checks the body of the function x of type f.
+func (c *checker) body(x *ast.FuncExpr, f *fn) {
+	outer := c.fn
+	c.fn = f
+	c.open()
+	for _, p := range f.params {
+		c.declare(p.name, &variable{typ: p.typ, declared: true})
+	}
+	c.block(x.Body)
+	c.close()
+	c.fn = outer
+}
+
+// returns checks the values of a return statement against the results
+// of the current function.
+func (c *checker) returns(n *ast.ReturnStmt) {
+	types, exact := c.exprTypes(n.Values)
+	f := c.fn
+	if f.unknown {
+		return
+	}
+	for i, want := range f.results {
+		if i < len(types) {
+			if !assignable(want, types[i]) {
+				c.errorf(n.Values[min(i, len(n.Values)-1)].Pos(), "cannot use %s as %s in return value %d", types[i], want, i+1)
+			}
+		} else if exact && !assignable(want, tNil) {
+			c.errorf(n.Pos(), "missing return value %d (%s)", i+1, want)
+		}
+	}
+	for i := len(f.results); i < len(types); i++ {
+		if f.varResults == nil {
+			c.errorf(n.Pos(), "too many return values (%d, want %d)", len(types), len(f.results))
+			break
+		}
+		if !assignable(f.varResults, types[i]) {
+			c.errorf(n.Values[min(i, len(n.Values)-1)].Pos(), "cannot use %s as %s in return value %d", types[i], f.varResults, i+1)
+		}
+	}
+}
+
+func min(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// ----------------------------------------------------------------------------
+// Expressions
+
+func (c *checker) exprs(list []ast.Expr) {
+	for _, x := range list {
+		c.expr(x)
+	}
+}
+
+// exprTypes returns the types of the values of list: the first value of
+// each expression and all the values of the last one. If the number of
+// values of the last expression is not known, the result does not
+// include them and exact is false.
+func (c *checker) exprTypes(list []ast.Expr) (types []typ, exact bool) {
+	for i, x := range list {
+		if i < len(list)-1 {
+			types = append(types, c.expr(x))
+			continue
+		}
+		switch x.(type) {
+		case *ast.CallExpr, *ast.MethodCallExpr:
+			if f := c.call(x); f != nil && !f.unknown {
+				return append(types, f.results...), f.varResults == nil
+			}
+			return types, false
+		case *ast.VarargExpr:
+			return types, false
+		}
+		types = append(types, c.expr(x))
+	}
+	return types, true
+}
+
+// expr checks x and returns its type (the type of its first value).
+func (c *checker) expr(x ast.Expr) typ {
+	switch n := x.(type) {
+	case *ast.NilExpr:
+		return tNil
+	case *ast.TrueExpr, *ast.FalseExpr:
+		return tBoolean
+	case *ast.IntExpr:
+		return tInteger
+	case *ast.FloatExpr:
+		return tNumber
+	case *ast.StringExpr:
+		return tString
+
+	case *ast.NameExpr:
+		if v := c.lookup(n.Name); v != nil {
+			return v.typ
+		}
+
+	case *ast.IndexExpr:
+		xt := c.expr(n.X)
+		c.expr(n.Key)
+		if arr, ok := xt.(*array); ok {
+			return join(arr.elem, tNil)
+		}
+
+	case *ast.SelectorExpr:
+		return c.field(n.X, c.expr(n.X), n.Sel.Name)
+
+	case *ast.CallExpr, *ast.MethodCallExpr:
+		if f := c.call(n); f != nil && !f.unknown {
+			if len(f.results) > 0 {
+				return f.results[0]
+			}
+			if f.varResults != nil {
+				return join(f.varResults, tNil)
+			}
+			return tNil
+		}
+
+	case *ast.ParenExpr:
+		return c.expr(n.X)
+
+	case *ast.FuncExpr:
+		f := c.signature(n, &annotations{}, nil)
+		c.body(n, f)
+		return f
+
+	case *ast.TableExpr:
+		var cls *class
+		for _, f := range n.Fields {
+			if f.Kind == ast.KeyedField {
+				c.expr(f.Key)
+			}
+			t := c.expr(f.Value)
+			if f.Kind == ast.ListField {
+				cls = nil
+				continue
+			}
+			if cls == nil {
+				cls = newClass("", true)
+			}
+			if key, ok := f.Key.(*ast.StringExpr); ok && f.Kind == ast.NamedField {
+				cls.fields[key.Value] = t
+			}
+		}
+		if cls == nil && len(n.Fields) == 0 {
+			return newClass("", true)
+		}
+		if cls != nil {
+			return cls
+		}
+		return tTable
+
+	case *ast.UnaryExpr:
+		t := c.expr(n.X)
+		switch n.Op {
+		case ast.OpNot:
+			return tBoolean
+		case ast.OpLen, ast.OpBNot:
+			return tInteger
+		case ast.OpNeg:
+			if t == tInteger || t == tNumber {
+				return t
+			}
+		}
+
+	case *ast.BinaryExpr:
+		xt, yt := c.expr(n.X), c.expr(n.Y)
+		switch n.Op {
+		case ast.OpConcat:
+			return tString
+		case ast.OpEq, ast.OpNE, ast.OpLT, ast.OpLE, ast.OpGT, ast.OpGE:
+			return tBoolean
+		case ast.OpOr:
+			return join(nonNil(xt), yt)
+		case ast.OpAnd:
+			if xt != tBoolean && !assignable(xt, tNil) { // always true
+				return yt
+			}
+		default:
+			return c.arith(n.Op, xt, yt)
+		}
+	}
+	return tAny
+}
+
+// arith returns the type of the result of an arithmetic or bitwise
+// operation on values of type x and y.
+func (c *checker) arith(op ast.BinaryOp, x, y typ) typ {
+	switch op {
+	case ast.OpBAnd, ast.OpBOr, ast.OpBXor, ast.OpShl, ast.OpShr:
+		return tInteger
+	case ast.OpDiv, ast.OpPow:
+		if assignable(tNumber, x) && assignable(tNumber, y) && x != tAny && y != tAny {
+			return tNumber
+		}
+		return tAny
+	}
+	switch {
+	case x == tInteger && y == tInteger:
+		return tInteger
+	case (x == tInteger || x == tNumber) && (y == tInteger || y == tNumber):
+		return tNumber
+	}
+	return tAny
+}
+
+// field returns the type of the field name of the table x of type xt.
+func (c *checker) field(x ast.Expr, xt typ, name string) typ {
+	if xt == tString && c.classes["stringlib"] != nil {
+		xt = c.classes["stringlib"]
+	}
+	cls, ok := xt.(*class)
+	if !ok {
+		return tAny
+	}
+	if t, ok := cls.field(name); ok {
+		return t
+	}
+	if !cls.open {
+		c.errorf(x.Pos(), "undefined field %s of %s", name, cls)
+	}
+	return tAny
+}
+
+// call checks a function or method call and returns the type of the
+// function called, or nil if it is unknown.
+func (c *checker) call(x ast.Expr) *fn {
+	var (
+		ft   typ
+		args []ast.Expr
+		name string
+		skip int // parameters passed implicitly
+	)
+	switch n := x.(type) {
+	case *ast.CallExpr:
+		ft, args, name = c.expr(n.Fn), n.Args, ast.Sprint(n.Fn)
+	case *ast.MethodCallExpr:
+		ft = c.field(n.X, c.expr(n.X), n.Method.Name)
+		args, name, skip = n.Args, ast.Sprint(n.X)+":"+n.Method.Name, 1
+	}
+	f, ok := ft.(*fn)
+	if !ok {
+		c.exprs(args)
+		return nil
+	}
+	types, exact := c.exprTypes(args)
+	params := f.params
+	if skip <= len(params) {
+		params = params[skip:]
+	}
+	for i, p := range params {
+		if i < len(types) {
+			if !assignable(p.typ, types[i]) {
+				c.errorf(args[min(i, len(args)-1)].Pos(), "cannot use %s as %s in argument %s of %s", types[i], p.typ, p.name, name)
+			}
+		} else if exact && !assignable(p.typ, tNil) {
+			c.errorf(x.Pos(), "missing argument %s (%s) in call to %s", p.name, p.typ, name)
+		}
+	}
+	for i := len(params); i < len(types); i++ {
+		if f.variadic == nil {
+			c.errorf(args[min(i, len(args)-1)].Pos(), "too many arguments in call to %s (%d, want %d)", name, len(types), len(params))
+			break
+		}
+		if !assignable(f.variadic, types[i]) {
+			c.errorf(args[min(i, len(args)-1)].Pos(), "cannot use %s as %s in argument %d of %s", types[i], f.variadic, i+1, name)
+		}
+	}
+	return f
+}
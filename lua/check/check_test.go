@@ -0,0 +1,187 @@
+package check
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/Azure/golua/lua/ast"
+)
+
+func TestCheck(t *testing.T) {
+	var tests = []struct {
+		source string
+		want   []string
+	}{
+		// calls into annotated functions
+		{`---@param a integer
+		  ---@param b string?
+		  ---@return string
+		  local function f(a, b) return b or tostring(a) end
+		  f(1); f(1, "x"); f(1, nil)
+		  f("x")
+		  f()
+		  f(1, 2)
+		  f(1, "x", 3)
+		  local s = f(2) .. f(3)`, []string{
+			`6:7: cannot use string as integer in argument a of f`,
+			`7:5: missing argument a (integer) in call to f`,
+			`8:10: cannot use integer as string? in argument b of f`,
+			`9:15: too many arguments in call to f (3, want 2)`,
+		}},
+		{`---@param ... number
+		  function sum(...) end
+		  sum(1, 2.5, "3")
+		  local t = {}
+		  sum(1, table.unpack(t))`, []string{
+			`3:17: cannot use string as number in argument 3 of sum`,
+		}},
+		{`---@param x number
+		  local function g(x) end
+		  local n = 1
+		  n = n + 0.5
+		  g(n); g(n // 2); g(#"abc"); g(n .. "")
+		  g(math.floor(n)); g(math.sqrt(4)); g(string.len("x")); g(tonumber("1"))`, []string{
+			`5:35: cannot use string as number in argument x of g`,
+		}},
+
+		// standard library stubs
+		{`local s = string.rep("x", "3")
+		  string.format()
+		  local n = ("x"):rep(2):len()
+		  math.floor(n, 1)
+		  local u = string.foo`, []string{
+			`1:27: cannot use string as integer in argument n of string.rep`,
+			`2:5: missing argument format (string) in call to string.format`,
+			`4:19: too many arguments in call to math.floor (2, want 1)`,
+			`5:15: undefined field foo of stringlib`,
+		}},
+
+		// classes and fields
+		{`---@class Point
+		  ---@field x number
+		  ---@field y number
+		  local Point = {}
+		  Point.__index = Point
+
+		  ---@param x number
+		  ---@param y number
+		  ---@return Point
+		  function Point.new(x, y) return setmetatable({x = x, y = y}, Point) end
+
+		  ---@param other Point
+		  ---@return number
+		  function Point:dot(other) return self.x * other.x + self.y * other.z end
+
+		  local p = Point.new(1, 2)
+		  p.x = "1"
+		  p.w = 1
+		  print(p:dot(p), p:dot(1), p:norm())`, []string{
+			`14:66: undefined field z of Point`,
+			`17:5: cannot use string as number in assignment to field x of Point`,
+			`18:5: undefined field w of Point`,
+			`19:27: cannot use integer as Point in argument other of p:dot`,
+			`19:31: undefined field norm of Point`,
+		}},
+		{`---@class Animal
+		  ---@field name string
+		  ---@class Dog : Animal
+		  ---@field bark fun(): string
+
+		  ---@param a Animal
+		  local function pet(a) return a.name end
+		  ---@type Dog
+		  local d = {name = "rex"}
+		  pet(d); pet({})
+		  ---@type Animal
+		  local a = d
+		  ---@type Dog
+		  local e = a
+		  local b = d.bark() .. a.bark`, []string{
+			`14:11: cannot use Animal as Dog in assignment to e`,
+			`15:27: undefined field bark of Animal`,
+		}},
+
+		// return types
+		{`---@return integer, string?
+		  local function f(x)
+		    if x then return 1 end
+		    if x == 1 then return "a" end
+		    if x == 2 then return 1, 2 end
+		    if x == 3 then return end
+		    if x == 4 then return f() end
+		    return 1, "a", 3
+		  end
+		  ---@return number, ...string
+		  local function g() return 1, "a", "b", 2 end`, []string{
+			`4:29: cannot use string as integer in return value 1`,
+			`5:32: cannot use integer as string? in return value 2`,
+			`6:22: missing return value 1 (integer)`,
+			`8:7: too many return values (3, want 2)`,
+			`11:44: cannot use integer as string in return value 4`,
+		}},
+
+		// annotated variables
+		{`---@type integer[]
+		  local list = {}
+		  list[1] = 2; list[2] = "x"
+		  ---@type string, number
+		  local a, b = "a", "b"
+		  a = 1`, []string{
+			`3:18: cannot use string as integer in assignment to list[2]`,
+			`5:14: cannot use string as number in assignment to b`,
+			`6:5: cannot use integer as string in assignment to a`,
+		}},
+
+		// invalid annotations
+		{`---@param x Foo
+		  ---@param y
+		  ---@return fun(a: integer
+		  local function f(x) end`, []string{
+			`1:1: invalid annotation: unknown type "Foo"`,
+			`2:5: invalid annotation: missing type`,
+			`2:5: no parameter y in function`,
+			`3:5: invalid annotation: missing ')'`,
+		}},
+	}
+	for _, test := range tests {
+		diags, err := Source("=test", []byte(test.source))
+		if err != nil {
+			t.Errorf("check %q: %v", test.source, err)
+			continue
+		}
+		var got []string
+		for _, d := range diags {
+			got = append(got, fmt.Sprintf("%d:%d: %s", d.Pos.Line, d.Pos.Column, d.Message))
+		}
+		if strings.Join(got, "\n") != strings.Join(test.want, "\n") {
+			t.Errorf("check %q:\ngot:\n%s\nwant:\n%s", test.source, strings.Join(got, "\n"), strings.Join(test.want, "\n"))
+		}
+	}
+}
+
+func TestCheckChunks(t *testing.T) {
+	var chunks []*ast.Chunk
+	for _, src := range []string{
+		"print(area(1) + area({}))", // uses the function declared below
+		"---@class Shape\n---@field area fun(): number\n---@param s Shape\n---@return number\nfunction area(s) return s.area() end",
+	} {
+		chunk, err := ast.Parse(fmt.Sprintf("=%c", 'a'+len(chunks)), []byte(src))
+		if err != nil {
+			t.Fatal(err)
+		}
+		chunks = append(chunks, chunk)
+	}
+	diags := Check(chunks...)
+	if len(diags) != 1 || diags[0].String() != "a:1:12: cannot use integer as Shape in argument s of area" {
+		t.Errorf("got %v", diags)
+	}
+}
+
+func TestStubs(t *testing.T) {
+	c := &checker{classes: make(map[string]*class), globals: make(map[string]*variable), report: true}
+	c.chunk(stdStubs())
+	for _, d := range c.diags {
+		t.Errorf("stubs: %v", d)
+	}
+}
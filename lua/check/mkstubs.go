@@ -0,0 +1,337 @@
+//go:build ignore
+// +build ignore
+
+// Mkstubs generates stubs.go, which holds the annotated stubs of the
+// functions and fields registered by the standard libraries of std.
+//
+// The functions are listed by opening the libraries (with the Lua 5.1
+// compatibility ones, in 5.3 and 5.4 mode); their signatures are taken
+// from the table below, which must cover all of them.
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"io/ioutil"
+	"log"
+	"sort"
+	"strings"
+
+	"github.com/Azure/golua/lua"
+	"github.com/Azure/golua/std"
+)
+
+// signatures holds the signatures of the standard library functions, as
+// in "(name: type, ...: type): type, ...type".
+var signatures = map[string]string{
+	// base
+	"assert":         "(v: any, ...: any): ...any",
+	"collectgarbage": "(opt: string?, arg: any?): any",
+	"dofile":         "(filename: string?): ...any",
+	"error":          "(message: any?, level: integer?)",
+	"getmetatable":   "(object: any): any",
+	"ipairs":         "(t: any): function, any, integer",
+	"load":           "(chunk: string|function, chunkname: string?, mode: string?, env: table?): function?, string?",
+	"loadfile":       "(filename: string?, mode: string?, env: table?): function?, string?",
+	"next":           "(t: table, index: any?): any, any",
+	"pairs":          "(t: any): function, any, any",
+	"pcall":          "(f: any, ...: any): boolean, ...any",
+	"print":          "(...: any)",
+	"rawequal":       "(v1: any, v2: any): boolean",
+	"rawget":         "(t: table, index: any): any",
+	"rawlen":         "(v: table|string): integer",
+	"rawset":         "(t: table, index: any, value: any): table",
+	"require":        "(modname: string): any",
+	"select":         "(n: integer|string, ...: any): ...any",
+	"setmetatable":   "(t: table, metatable: table?): table",
+	"tonumber":       "(e: any, base: integer?): number?",
+	"tostring":       "(v: any): string",
+	"type":           "(v: any): string",
+	"warn":           "(message: string, ...: string)",
+	"xpcall":         "(f: any, msgh: any, ...: any): boolean, ...any",
+
+	// compat
+	"getfenv":    "(f: any?): table",
+	"loadstring": "(s: string, chunkname: string?, mode: string?, env: table?): function?, string?",
+	"module":     "(name: string, ...: any)",
+	"setfenv":    "(f: any, env: table): any",
+	"unpack":     "(list: table, i: integer?, j: integer?): ...any",
+
+	// bit
+	"bit.arshift": "(x: number, n: integer): integer",
+	"bit.band":    "(x: number, ...: number): integer",
+	"bit.bnot":    "(x: number): integer",
+	"bit.bor":     "(x: number, ...: number): integer",
+	"bit.bswap":   "(x: number): integer",
+	"bit.bxor":    "(x: number, ...: number): integer",
+	"bit.lshift":  "(x: number, n: integer): integer",
+	"bit.rol":     "(x: number, n: integer): integer",
+	"bit.ror":     "(x: number, n: integer): integer",
+	"bit.rshift":  "(x: number, n: integer): integer",
+	"bit.tobit":   "(x: number): integer",
+	"bit.tohex":   "(x: number, n: integer?): string",
+
+	// coroutine
+	"coroutine.close":       "(co: thread): boolean, any",
+	"coroutine.create":      "(f: function): thread",
+	"coroutine.isyieldable": "(): boolean",
+	"coroutine.resume":      "(co: thread, ...: any): boolean, ...any",
+	"coroutine.running":     "(): thread, boolean",
+	"coroutine.status":      "(co: thread): string",
+	"coroutine.wrap":        "(f: function): function",
+	"coroutine.yield":       "(...: any): ...any",
+
+	// debug
+	"debug.debug":        "()",
+	"debug.gethook":      "(co: thread?): any",
+	"debug.getinfo":      "(...: any): table?",
+	"debug.getlocal":     "(...: any): string?, any",
+	"debug.getmetatable": "(value: any): table?",
+	"debug.getregistry":  "(): table",
+	"debug.getupvalue":   "(f: function, up: integer): string?, any",
+	"debug.getuservalue": "(u: any): any",
+	"debug.sethook":      "(...: any)",
+	"debug.setlocal":     "(...: any): string?",
+	"debug.setmetatable": "(value: any, mt: table?): any",
+	"debug.setupvalue":   "(f: function, up: integer, value: any): string?",
+	"debug.setuservalue": "(udata: any, value: any): any",
+	"debug.traceback":    "(...: any): any",
+	"debug.upvalueid":    "(f: function, n: integer): any",
+	"debug.upvaluejoin":  "(f1: function, n1: integer, f2: function, n2: integer)",
+
+	// io
+	"io.close":   "(file: any?): boolean?, string?",
+	"io.flush":   "()",
+	"io.input":   "(file: any?): any",
+	"io.lines":   "(filename: string?, ...: any): function",
+	"io.open":    "(filename: string, mode: string?): any, string?",
+	"io.output":  "(file: any?): any",
+	"io.popen":   "(prog: string, mode: string?): any, string?",
+	"io.read":    "(...: any): ...any",
+	"io.tmpfile": "(): any",
+	"io.type":    "(obj: any): string?",
+	"io.write":   "(...: string|number): any",
+
+	// math
+	"math.abs":        "(x: number): any",
+	"math.acos":       "(x: number): number",
+	"math.asin":       "(x: number): number",
+	"math.atan":       "(y: number, x: number?): number",
+	"math.ceil":       "(x: number): integer",
+	"math.cos":        "(x: number): number",
+	"math.deg":        "(x: number): number",
+	"math.exp":        "(x: number): number",
+	"math.floor":      "(x: number): integer",
+	"math.fmod":       "(x: number, y: number): any",
+	"math.log":        "(x: number, base: number?): number",
+	"math.max":        "(x: number, ...: number): any",
+	"math.min":        "(x: number, ...: number): any",
+	"math.modf":       "(x: number): number, number",
+	"math.pow":        "(x: number, y: number): number",
+	"math.rad":        "(x: number): number",
+	"math.random":     "(m: integer?, n: integer?): any",
+	"math.randomseed": "(x: number?, y: number?)",
+	"math.sin":        "(x: number): number",
+	"math.sqrt":       "(x: number): number",
+	"math.tan":        "(x: number): number",
+	"math.tointeger":  "(x: any): integer?",
+	"math.type":       "(x: any): string?",
+	"math.ult":        "(m: integer, n: integer): boolean",
+
+	// os
+	"os.clock":     "(): number",
+	"os.date":      "(format: string?, time: integer?): any",
+	"os.difftime":  "(t2: integer, t1: integer?): number",
+	"os.execute":   "(command: string?): any, string?, integer?",
+	"os.exit":      "(code: any?, close: boolean?)",
+	"os.getenv":    "(varname: string): string?",
+	"os.remove":    "(filename: string): boolean?, string?",
+	"os.rename":    "(oldname: string, newname: string): boolean?, string?",
+	"os.setlocale": "(locale: string?, category: string?): string?",
+	"os.time":      "(t: table?): integer",
+	"os.tmpname":   "(): string",
+
+	// package
+	"package.loadlib":    "(libname: string, funcname: string): any",
+	"package.searchpath": "(name: string, path: string, sep: string?, rep: string?): string?, string?",
+	"package.seeall":     "(module: table)",
+
+	// string
+	"string.byte":     "(s: string|number, i: integer?, j: integer?): ...integer",
+	"string.char":     "(...: integer): string",
+	"string.dump":     "(f: function, strip: boolean?): string",
+	"string.find":     "(s: string|number, pattern: string, init: integer?, plain: boolean?): integer?, integer?, ...any",
+	"string.format":   "(format: string, ...: any): string",
+	"string.gmatch":   "(s: string|number, pattern: string): function",
+	"string.gsub":     "(s: string|number, pattern: string, repl: any, n: integer?): string, integer",
+	"string.len":      "(s: string|number): integer",
+	"string.lower":    "(s: string|number): string",
+	"string.match":    "(s: string|number, pattern: string, init: integer?): ...any",
+	"string.pack":     "(format: string, ...: any): string",
+	"string.packsize": "(format: string): integer",
+	"string.rep":      "(s: string|number, n: integer, sep: string?): string",
+	"string.reverse":  "(s: string|number): string",
+	"string.sub":      "(s: string|number, i: integer, j: integer?): string",
+	"string.unpack":   "(format: string, s: string, pos: integer?): ...any",
+	"string.upper":    "(s: string|number): string",
+
+	// table
+	"table.concat": "(list: table, sep: string?, i: integer?, j: integer?): string",
+	"table.getn":   "(list: table): integer",
+	"table.insert": "(list: table, pos: any, value: any?)",
+	"table.move":   "(a1: table, f: integer, e: integer, t: integer, a2: table?): table",
+	"table.pack":   "(...: any): table",
+	"table.remove": "(list: table, pos: integer?): any",
+	"table.sort":   "(list: table, comp: function?)",
+	"table.unpack": "(list: table, i: integer?, j: integer?): ...any",
+
+	// utf8
+	"utf8.char":      "(...: integer): string",
+	"utf8.codepoint": "(s: string, i: integer?, j: integer?): ...integer",
+	"utf8.codes":     "(s: string): function, string, integer",
+	"utf8.len":       "(s: string, i: integer?, j: integer?): integer?, integer?",
+	"utf8.offset":    "(s: string, n: integer, i: integer?): integer?",
+}
+
+// zero holds the values given to the globals in the stubs, by type.
+var zero = map[string]string{
+	"boolean": "false",
+	"integer": "0",
+	"number":  "0.0",
+	"string":  `""`,
+	"table":   "{}",
+}
+
+// library holds the functions and the other fields of a library.
+type library struct {
+	funcs  map[string]bool
+	fields map[string]string // types of the fields that are not functions
+}
+
+func main() {
+	globals := make(map[string]string) // types of the globals that are neither functions nor libraries
+	libs := map[string]*library{"_G": {make(map[string]bool), globals}}
+	for _, v := range []lua.LuaVersion{lua.V53, lua.V54} {
+		state := lua.NewState(lua.WithVersion(v))
+		std.Open(state, std.WithCompat51(true))
+		state.PushGlobals()
+		collect(state, "_G", libs)
+		state.Close()
+	}
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "// Code generated by mkstubs.go; DO NOT EDIT.\n\n")
+	fmt.Fprintf(&buf, "package check\n\n")
+	fmt.Fprintf(&buf, "// stdlib holds the annotated stubs of the standard library functions.\n")
+	fmt.Fprintf(&buf, "const stdlib = `")
+	for _, name := range sorted(globals) {
+		fmt.Fprintf(&buf, "---@type %s\n%s = %s\n\n", globals[name], name, zero[globals[name]])
+	}
+	names := make(map[string]bool)
+	for name := range libs {
+		names[name] = true
+	}
+	seen := make(map[string]bool)
+	for _, name := range sorted(names) {
+		lib, prefix := libs[name], name+"."
+		if name == "_G" {
+			prefix = ""
+		} else {
+			fmt.Fprintf(&buf, "---@class %slib\n", name)
+			for _, field := range sorted(lib.fields) {
+				fmt.Fprintf(&buf, "---@field %s %s\n", field, lib.fields[field])
+			}
+			fmt.Fprintf(&buf, "%s = {}\n\n", name)
+		}
+		for _, fn := range sorted(lib.funcs) {
+			sig, ok := signatures[prefix+fn]
+			if !ok {
+				log.Fatalf("missing signature of %s%s", prefix, fn)
+			}
+			seen[prefix+fn] = true
+			stub(&buf, prefix+fn, sig)
+		}
+	}
+	for name := range signatures {
+		if !seen[name] {
+			log.Fatalf("signature of unknown function %s", name)
+		}
+	}
+	buf.WriteString("`\n")
+
+	src, err := format.Source(buf.Bytes())
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := ioutil.WriteFile("stubs.go", src, 0666); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// collect records the entries of the table on top of the stack, the
+// library name, and pops it.
+func collect(state *lua.State, name string, libs map[string]*library) {
+	lib := libs[name]
+	state.Push(nil)
+	for state.Next(-2) {
+		key := state.ToString(-2)
+		switch state.TypeAt(-1) {
+		case lua.FuncType:
+			lib.funcs[key] = true
+		case lua.TableType:
+			if name == "_G" && key != "_G" {
+				if libs[key] == nil {
+					libs[key] = &library{make(map[string]bool), make(map[string]string)}
+				}
+				state.PushIndex(-1)
+				collect(state, key, libs)
+				break
+			}
+			lib.fields[key] = "table"
+		case lua.NumberType:
+			lib.fields[key] = "number"
+			if state.IsInt(-1) {
+				lib.fields[key] = "integer"
+			}
+		default:
+			lib.fields[key] = state.TypeAt(-1).String()
+		}
+		state.Pop()
+	}
+	state.Pop()
+}
+
+// stub writes the annotated stub of the function name with the given
+// signature.
+func stub(buf *bytes.Buffer, name, sig string) {
+	end := strings.Index(sig, ")")
+	var params []string
+	if list := sig[1:end]; list != "" {
+		for _, p := range strings.Split(list, ", ") {
+			i := strings.Index(p, ": ")
+			fmt.Fprintf(buf, "---@param %s %s\n", p[:i], p[i+2:])
+			params = append(params, p[:i])
+		}
+	}
+	if results := strings.TrimPrefix(sig[end+1:], ": "); results != "" {
+		fmt.Fprintf(buf, "---@return %s\n", results)
+	}
+	fmt.Fprintf(buf, "function %s(%s) end\n\n", name, strings.Join(params, ", "))
+}
+
+func sorted(set interface{}) []string {
+	var keys []string
+	switch m := set.(type) {
+	case map[string]bool:
+		for k := range m {
+			keys = append(keys, k)
+		}
+	case map[string]string:
+		for k := range m {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,717 @@
+// Code generated by mkstubs.go; DO NOT EDIT.
+
+package check
+
+// stdlib holds the annotated stubs of the standard library functions.
+const stdlib = `---@type table
+_G = {}
+
+---@type string
+_VERSION = ""
+
+---@param v any
+---@param ... any
+---@return ...any
+function assert(v, ...) end
+
+---@param opt string?
+---@param arg any?
+---@return any
+function collectgarbage(opt, arg) end
+
+---@param filename string?
+---@return ...any
+function dofile(filename) end
+
+---@param message any?
+---@param level integer?
+function error(message, level) end
+
+---@param f any?
+---@return table
+function getfenv(f) end
+
+---@param object any
+---@return any
+function getmetatable(object) end
+
+---@param t any
+---@return function, any, integer
+function ipairs(t) end
+
+---@param chunk string|function
+---@param chunkname string?
+---@param mode string?
+---@param env table?
+---@return function?, string?
+function load(chunk, chunkname, mode, env) end
+
+---@param filename string?
+---@param mode string?
+---@param env table?
+---@return function?, string?
+function loadfile(filename, mode, env) end
+
+---@param s string
+---@param chunkname string?
+---@param mode string?
+---@param env table?
+---@return function?, string?
+function loadstring(s, chunkname, mode, env) end
+
+---@param name string
+---@param ... any
+function module(name, ...) end
+
+---@param t table
+---@param index any?
+---@return any, any
+function next(t, index) end
+
+---@param t any
+---@return function, any, any
+function pairs(t) end
+
+---@param f any
+---@param ... any
+---@return boolean, ...any
+function pcall(f, ...) end
+
+---@param ... any
+function print(...) end
+
+---@param v1 any
+---@param v2 any
+---@return boolean
+function rawequal(v1, v2) end
+
+---@param t table
+---@param index any
+---@return any
+function rawget(t, index) end
+
+---@param v table|string
+---@return integer
+function rawlen(v) end
+
+---@param t table
+---@param index any
+---@param value any
+---@return table
+function rawset(t, index, value) end
+
+---@param modname string
+---@return any
+function require(modname) end
+
+---@param n integer|string
+---@param ... any
+---@return ...any
+function select(n, ...) end
+
+---@param f any
+---@param env table
+---@return any
+function setfenv(f, env) end
+
+---@param t table
+---@param metatable table?
+---@return table
+function setmetatable(t, metatable) end
+
+---@param e any
+---@param base integer?
+---@return number?
+function tonumber(e, base) end
+
+---@param v any
+---@return string
+function tostring(v) end
+
+---@param v any
+---@return string
+function type(v) end
+
+---@param list table
+---@param i integer?
+---@param j integer?
+---@return ...any
+function unpack(list, i, j) end
+
+---@param message string
+---@param ... string
+function warn(message, ...) end
+
+---@param f any
+---@param msgh any
+---@param ... any
+---@return boolean, ...any
+function xpcall(f, msgh, ...) end
+
+---@class bitlib
+bit = {}
+
+---@param x number
+---@param n integer
+---@return integer
+function bit.arshift(x, n) end
+
+---@param x number
+---@param ... number
+---@return integer
+function bit.band(x, ...) end
+
+---@param x number
+---@return integer
+function bit.bnot(x) end
+
+---@param x number
+---@param ... number
+---@return integer
+function bit.bor(x, ...) end
+
+---@param x number
+---@return integer
+function bit.bswap(x) end
+
+---@param x number
+---@param ... number
+---@return integer
+function bit.bxor(x, ...) end
+
+---@param x number
+---@param n integer
+---@return integer
+function bit.lshift(x, n) end
+
+---@param x number
+---@param n integer
+---@return integer
+function bit.rol(x, n) end
+
+---@param x number
+---@param n integer
+---@return integer
+function bit.ror(x, n) end
+
+---@param x number
+---@param n integer
+---@return integer
+function bit.rshift(x, n) end
+
+---@param x number
+---@return integer
+function bit.tobit(x) end
+
+---@param x number
+---@param n integer?
+---@return string
+function bit.tohex(x, n) end
+
+---@class coroutinelib
+coroutine = {}
+
+---@param co thread
+---@return boolean, any
+function coroutine.close(co) end
+
+---@param f function
+---@return thread
+function coroutine.create(f) end
+
+---@return boolean
+function coroutine.isyieldable() end
+
+---@param co thread
+---@param ... any
+---@return boolean, ...any
+function coroutine.resume(co, ...) end
+
+---@return thread, boolean
+function coroutine.running() end
+
+---@param co thread
+---@return string
+function coroutine.status(co) end
+
+---@param f function
+---@return function
+function coroutine.wrap(f) end
+
+---@param ... any
+---@return ...any
+function coroutine.yield(...) end
+
+---@class debuglib
+debug = {}
+
+function debug.debug() end
+
+---@param co thread?
+---@return any
+function debug.gethook(co) end
+
+---@param ... any
+---@return table?
+function debug.getinfo(...) end
+
+---@param ... any
+---@return string?, any
+function debug.getlocal(...) end
+
+---@param value any
+---@return table?
+function debug.getmetatable(value) end
+
+---@return table
+function debug.getregistry() end
+
+---@param f function
+---@param up integer
+---@return string?, any
+function debug.getupvalue(f, up) end
+
+---@param u any
+---@return any
+function debug.getuservalue(u) end
+
+---@param ... any
+function debug.sethook(...) end
+
+---@param ... any
+---@return string?
+function debug.setlocal(...) end
+
+---@param value any
+---@param mt table?
+---@return any
+function debug.setmetatable(value, mt) end
+
+---@param f function
+---@param up integer
+---@param value any
+---@return string?
+function debug.setupvalue(f, up, value) end
+
+---@param udata any
+---@param value any
+---@return any
+function debug.setuservalue(udata, value) end
+
+---@param ... any
+---@return any
+function debug.traceback(...) end
+
+---@param f function
+---@param n integer
+---@return any
+function debug.upvalueid(f, n) end
+
+---@param f1 function
+---@param n1 integer
+---@param f2 function
+---@param n2 integer
+function debug.upvaluejoin(f1, n1, f2, n2) end
+
+---@class iolib
+---@field stderr userdata
+---@field stdin userdata
+---@field stdout userdata
+io = {}
+
+---@param file any?
+---@return boolean?, string?
+function io.close(file) end
+
+function io.flush() end
+
+---@param file any?
+---@return any
+function io.input(file) end
+
+---@param filename string?
+---@param ... any
+---@return function
+function io.lines(filename, ...) end
+
+---@param filename string
+---@param mode string?
+---@return any, string?
+function io.open(filename, mode) end
+
+---@param file any?
+---@return any
+function io.output(file) end
+
+---@param prog string
+---@param mode string?
+---@return any, string?
+function io.popen(prog, mode) end
+
+---@param ... any
+---@return ...any
+function io.read(...) end
+
+---@return any
+function io.tmpfile() end
+
+---@param obj any
+---@return string?
+function io.type(obj) end
+
+---@param ... string|number
+---@return any
+function io.write(...) end
+
+---@class mathlib
+---@field huge number
+---@field maxinteger integer
+---@field mininteger integer
+---@field pi number
+math = {}
+
+---@param x number
+---@return any
+function math.abs(x) end
+
+---@param x number
+---@return number
+function math.acos(x) end
+
+---@param x number
+---@return number
+function math.asin(x) end
+
+---@param y number
+---@param x number?
+---@return number
+function math.atan(y, x) end
+
+---@param x number
+---@return integer
+function math.ceil(x) end
+
+---@param x number
+---@return number
+function math.cos(x) end
+
+---@param x number
+---@return number
+function math.deg(x) end
+
+---@param x number
+---@return number
+function math.exp(x) end
+
+---@param x number
+---@return integer
+function math.floor(x) end
+
+---@param x number
+---@param y number
+---@return any
+function math.fmod(x, y) end
+
+---@param x number
+---@param base number?
+---@return number
+function math.log(x, base) end
+
+---@param x number
+---@param ... number
+---@return any
+function math.max(x, ...) end
+
+---@param x number
+---@param ... number
+---@return any
+function math.min(x, ...) end
+
+---@param x number
+---@return number, number
+function math.modf(x) end
+
+---@param x number
+---@param y number
+---@return number
+function math.pow(x, y) end
+
+---@param x number
+---@return number
+function math.rad(x) end
+
+---@param m integer?
+---@param n integer?
+---@return any
+function math.random(m, n) end
+
+---@param x number?
+---@param y number?
+function math.randomseed(x, y) end
+
+---@param x number
+---@return number
+function math.sin(x) end
+
+---@param x number
+---@return number
+function math.sqrt(x) end
+
+---@param x number
+---@return number
+function math.tan(x) end
+
+---@param x any
+---@return integer?
+function math.tointeger(x) end
+
+---@param x any
+---@return string?
+function math.type(x) end
+
+---@param m integer
+---@param n integer
+---@return boolean
+function math.ult(m, n) end
+
+---@class oslib
+os = {}
+
+---@return number
+function os.clock() end
+
+---@param format string?
+---@param time integer?
+---@return any
+function os.date(format, time) end
+
+---@param t2 integer
+---@param t1 integer?
+---@return number
+function os.difftime(t2, t1) end
+
+---@param command string?
+---@return any, string?, integer?
+function os.execute(command) end
+
+---@param code any?
+---@param close boolean?
+function os.exit(code, close) end
+
+---@param varname string
+---@return string?
+function os.getenv(varname) end
+
+---@param filename string
+---@return boolean?, string?
+function os.remove(filename) end
+
+---@param oldname string
+---@param newname string
+---@return boolean?, string?
+function os.rename(oldname, newname) end
+
+---@param locale string?
+---@param category string?
+---@return string?
+function os.setlocale(locale, category) end
+
+---@param t table?
+---@return integer
+function os.time(t) end
+
+---@return string
+function os.tmpname() end
+
+---@class packagelib
+---@field config string
+---@field gopath string
+---@field loaded table
+---@field path string
+---@field preload table
+---@field searchers table
+package = {}
+
+---@param libname string
+---@param funcname string
+---@return any
+function package.loadlib(libname, funcname) end
+
+---@param name string
+---@param path string
+---@param sep string?
+---@param rep string?
+---@return string?, string?
+function package.searchpath(name, path, sep, rep) end
+
+---@param module table
+function package.seeall(module) end
+
+---@class stringlib
+string = {}
+
+---@param s string|number
+---@param i integer?
+---@param j integer?
+---@return ...integer
+function string.byte(s, i, j) end
+
+---@param ... integer
+---@return string
+function string.char(...) end
+
+---@param f function
+---@param strip boolean?
+---@return string
+function string.dump(f, strip) end
+
+---@param s string|number
+---@param pattern string
+---@param init integer?
+---@param plain boolean?
+---@return integer?, integer?, ...any
+function string.find(s, pattern, init, plain) end
+
+---@param format string
+---@param ... any
+---@return string
+function string.format(format, ...) end
+
+---@param s string|number
+---@param pattern string
+---@return function
+function string.gmatch(s, pattern) end
+
+---@param s string|number
+---@param pattern string
+---@param repl any
+---@param n integer?
+---@return string, integer
+function string.gsub(s, pattern, repl, n) end
+
+---@param s string|number
+---@return integer
+function string.len(s) end
+
+---@param s string|number
+---@return string
+function string.lower(s) end
+
+---@param s string|number
+---@param pattern string
+---@param init integer?
+---@return ...any
+function string.match(s, pattern, init) end
+
+---@param format string
+---@param ... any
+---@return string
+function string.pack(format, ...) end
+
+---@param format string
+---@return integer
+function string.packsize(format) end
+
+---@param s string|number
+---@param n integer
+---@param sep string?
+---@return string
+function string.rep(s, n, sep) end
+
+---@param s string|number
+---@return string
+function string.reverse(s) end
+
+---@param s string|number
+---@param i integer
+---@param j integer?
+---@return string
+function string.sub(s, i, j) end
+
+---@param format string
+---@param s string
+---@param pos integer?
+---@return ...any
+function string.unpack(format, s, pos) end
+
+---@param s string|number
+---@return string
+function string.upper(s) end
+
+---@class tablelib
+table = {}
+
+---@param list table
+---@param sep string?
+---@param i integer?
+---@param j integer?
+---@return string
+function table.concat(list, sep, i, j) end
+
+---@param list table
+---@return integer
+function table.getn(list) end
+
+---@param list table
+---@param pos any
+---@param value any?
+function table.insert(list, pos, value) end
+
+---@param a1 table
+---@param f integer
+---@param e integer
+---@param t integer
+---@param a2 table?
+---@return table
+function table.move(a1, f, e, t, a2) end
+
+---@param ... any
+---@return table
+function table.pack(...) end
+
+---@param list table
+---@param pos integer?
+---@return any
+function table.remove(list, pos) end
+
+---@param list table
+---@param comp function?
+function table.sort(list, comp) end
+
+---@param list table
+---@param i integer?
+---@param j integer?
+---@return ...any
+function table.unpack(list, i, j) end
+
+---@class utf8lib
+---@field charpattern string
+utf8 = {}
+
+---@param ... integer
+---@return string
+function utf8.char(...) end
+
+---@param s string
+---@param i integer?
+---@param j integer?
+---@return ...integer
+function utf8.codepoint(s, i, j) end
+
+---@param s string
+---@return function, string, integer
+function utf8.codes(s) end
+
+---@param s string
+---@param i integer?
+---@param j integer?
+---@return integer?, integer?
+function utf8.len(s, i, j) end
+
+---@param s string
+---@param n integer
+---@param i integer?
+---@return integer?
+function utf8.offset(s, n, i) end
+
+`
@@ -0,0 +1,263 @@
+package check
+
+import (
+	"sort"
+	"strings"
+)
+
+// typ is the static type of a Lua value.
+type typ interface {
+	String() string
+}
+
+// basic is a predefined type.
+type basic int
+
+const (
+	tAny basic = iota
+	tNil
+	tBoolean
+	tNumber
+	tInteger
+	tString
+	tTable
+	tFunction
+	tUserdata
+	tThread
+)
+
+var basicNames = [...]string{
+	"any", "nil", "boolean", "number", "integer",
+	"string", "table", "function", "userdata", "thread",
+}
+
+func (t basic) String() string { return basicNames[t] }
+
+// union is the type of the values of any of its members, which are
+// never unions themselves.
+type union []typ
+
+func (t union) String() string {
+	if len(t) == 2 && t[1] == tNil {
+		return parenUnion(t[0]) + "?"
+	}
+	names := make([]string, len(t))
+	for i, m := range t {
+		names[i] = m.String()
+	}
+	return strings.Join(names, "|")
+}
+
+// array is a table whose elements, from index 1, have type elem.
+type array struct {
+	elem typ
+}
+
+func (t *array) String() string { return parenUnion(t.elem) + "[]" }
+
+// class is a table type with named fields: a class declared with
+// ---@class, or the shape of a table constructor (an open class, whose
+// fields are not checked).
+type class struct {
+	name   string
+	parent *class
+	fields map[string]typ
+	open   bool
+}
+
+func newClass(name string, open bool) *class {
+	return &class{name: name, fields: make(map[string]typ), open: open}
+}
+
+func (t *class) String() string {
+	if t.open {
+		return "table"
+	}
+	return t.name
+}
+
+// field returns the type of the field name of t or of its parents.
+func (t *class) field(name string) (typ, bool) {
+	for c := t; c != nil; c = c.parent {
+		if f, ok := c.fields[name]; ok {
+			return f, true
+		}
+	}
+	return nil, false
+}
+
+// param is a parameter of a function type.
+type param struct {
+	name string
+	typ  typ
+}
+
+// fn is a function type.
+type fn struct {
+	params     []param
+	variadic   typ   // type of the extra arguments, or nil if there are none
+	results    []typ // declared results
+	varResults typ   // type of the extra results, or nil if there are none
+	unknown    bool  // results not declared
+}
+
+func (t *fn) String() string {
+	var b strings.Builder
+	b.WriteString("fun(")
+	for i, p := range t.params {
+		if i > 0 {
+			b.WriteString(", ")
+		}
+		b.WriteString(p.name + ": " + p.typ.String())
+	}
+	if t.variadic != nil {
+		if len(t.params) > 0 {
+			b.WriteString(", ")
+		}
+		b.WriteString("...: " + t.variadic.String())
+	}
+	b.WriteString(")")
+	if !t.unknown && (len(t.results) > 0 || t.varResults != nil) {
+		for i, r := range t.results {
+			if i == 0 {
+				b.WriteString(": ")
+			} else {
+				b.WriteString(", ")
+			}
+			b.WriteString(r.String())
+		}
+		if t.varResults != nil {
+			if len(t.results) == 0 {
+				b.WriteString(": ")
+			} else {
+				b.WriteString(", ")
+			}
+			b.WriteString("..." + t.varResults.String())
+		}
+	}
+	return b.String()
+}
+
+func parenUnion(t typ) string {
+	if _, ok := t.(union); ok {
+		return "(" + t.String() + ")"
+	}
+	return t.String()
+}
+
+// join returns the type of the values of either a or b.
+func join(a, b typ) typ {
+	if a == tAny || b == tAny {
+		return tAny
+	}
+	var members union
+	seen := make(map[string]bool)
+	add := func(t typ) {
+		if s := t.String(); !seen[s] {
+			seen[s] = true
+			members = append(members, t)
+		}
+	}
+	for _, t := range []typ{a, b} {
+		if u, ok := t.(union); ok {
+			for _, m := range u {
+				add(m)
+			}
+		} else {
+			add(t)
+		}
+	}
+	if len(members) == 1 {
+		return members[0]
+	}
+	// Keep nil last so that optional types print as "T?".
+	sort.SliceStable(members, func(i, j int) bool { return members[j] == tNil && members[i] != tNil })
+	return members
+}
+
+// nonNil returns t without nil.
+func nonNil(t typ) typ {
+	u, ok := t.(union)
+	if !ok {
+		return t
+	}
+	var members union
+	for _, m := range u {
+		if m != tNil {
+			members = append(members, m)
+		}
+	}
+	if len(members) == 1 {
+		return members[0]
+	}
+	return members
+}
+
+// assignable reports whether a value of type src may be used where a
+// value of type dst is expected. Values of type any, and tables built
+// by constructors, are assignable to and from any type; optional values
+// are assignable to the type without nil.
+func assignable(dst, src typ) bool {
+	if dst == tAny || src == tAny {
+		return true
+	}
+	if u, ok := src.(union); ok {
+		for _, m := range u {
+			if m != tNil && !assignable(dst, m) {
+				return false
+			}
+		}
+		return true
+	}
+	if c, ok := src.(*class); ok && c.open && isTable(dst) {
+		return true
+	}
+	switch d := dst.(type) {
+	case union:
+		for _, m := range d {
+			if assignable(m, src) {
+				return true
+			}
+		}
+		return false
+	case basic:
+		switch d {
+		case tNumber:
+			return src == tNumber || src == tInteger
+		case tTable:
+			return isTable(src)
+		case tFunction:
+			_, ok := src.(*fn)
+			return ok || src == tFunction
+		}
+		return src == d
+	case *class:
+		if s, ok := src.(*class); ok {
+			for c := s; c != nil; c = c.parent {
+				if c == d {
+					return true
+				}
+			}
+			return d.open
+		}
+		return src == tTable
+	case *array:
+		if s, ok := src.(*array); ok {
+			return assignable(d.elem, s.elem)
+		}
+		return src == tTable
+	case *fn:
+		_, ok := src.(*fn)
+		return ok || src == tFunction
+	}
+	return false
+}
+
+// isTable reports whether t is a table type.
+func isTable(t typ) bool {
+	switch t.(type) {
+	case *class, *array:
+		return true
+	}
+	return t == tTable
+}
@@ -10,27 +10,43 @@ type (
 	// closure represents a lua or go function closure.
 	Closure struct {
 		binary *binary.Prototype
+		proto  *prototype
 		native Func
 		upvals []*upValue
+		eph    ephemerons
+	}
+
+	// prototype holds the runtime data of a Lua function prototype, shared
+	// by its closures: its constants as values, the prototypes of its nested
+	// functions and the inline caches of its instructions, which are created
+	// on demand.
+	prototype struct {
+		binary *binary.Prototype
+		consts []value
+		protos []*prototype
+		caches []*fieldCache // by pc
 	}
 
 	// upValue holds external local variable state.
 	upValue struct {
 		frame *Frame // frame upValue was opened within.
-		index int    // index into stack or enclosing function.
-		value Value  // if closed.
+		index int    // register in frame (its stack slot may move), or -1 if closed.
+		value value  // if closed.
 	}
 )
 
-func newLuaClosure(proto *binary.Prototype) *Closure {
-	cls := &Closure{binary: proto}
-	if nups := len(proto.UpValues); nups > 0 {
+func newLuaClosure(state *State, proto *prototype) *Closure {
+	nups := len(proto.binary.UpValues)
+	state.alloc(sizeClosure + int64(nups)*sizeUpValue)
+	cls := &Closure{binary: proto.binary, proto: proto}
+	if nups > 0 {
 		cls.upvals = make([]*upValue, nups)
 	}
 	return cls
 }
 
-func newGoClosure(native Func, nups int) *Closure {
+func newGoClosure(state *State, native Func, nups int) *Closure {
+	state.alloc(sizeClosure + int64(nups)*sizeUpValue)
 	cls := &Closure{native: native}
 	if nups > 0 {
 		cls.upvals = make([]*upValue, nups)
@@ -38,6 +54,36 @@ func newGoClosure(native Func, nups int) *Closure {
 	return cls
 }
 
+func newPrototype(state *State, proto *binary.Prototype) *prototype {
+	p := &prototype{binary: proto, consts: make([]value, len(proto.Consts))}
+	for i, k := range proto.Consts {
+		p.consts[i] = unbox(valueOf(state, k))
+	}
+	return p
+}
+
+// proto returns the prototype of the nested function at index.
+func (p *prototype) proto(state *State, index int) *prototype {
+	if p.protos == nil {
+		p.protos = make([]*prototype, len(p.binary.Protos))
+	}
+	if p.protos[index] == nil {
+		p.protos[index] = newPrototype(state, &p.binary.Protos[index])
+	}
+	return p.protos[index]
+}
+
+// cache returns the inline cache of the instruction at pc.
+func (p *prototype) cache(pc int) *fieldCache {
+	if p.caches == nil {
+		p.caches = make([]*fieldCache, len(p.binary.Code))
+	}
+	if p.caches[pc] == nil {
+		p.caches[pc] = new(fieldCache)
+	}
+	return p.caches[pc]
+}
+
 func (x *Closure) Type() Type {
 	if x.isLua() {
 		return FuncType
@@ -67,9 +113,9 @@ func (cls *Closure) getUp(index int) *upValue {
 	return nil
 }
 
-func (cls *Closure) setUp(index int, value Value) {
+func (cls *Closure) setUp(index int, v value) {
 	if cls != nil && index < len(cls.upvals) {
-		cls.upvals[index].set(value)
+		cls.upvals[index].set(v)
 	}
 }
 
@@ -80,17 +126,17 @@ func (cls *Closure) upName(index int) (name string) {
 	return
 }
 
-func (up *upValue) set(value Value) {
+func (up *upValue) set(v value) {
 	if up.open() {
-		up.frame.set(up.index, value)
+		up.frame.setReg(up.index, v)
 		return
 	}
-	up.value = value
+	up.value = v
 }
 
-func (up *upValue) get() Value {
+func (up *upValue) get() value {
 	if up.open() {
-		return up.frame.get(up.index)
+		return up.frame.reg(up.index)
 	}
 	return up.value
 }
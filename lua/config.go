@@ -1,6 +1,7 @@
 package lua
 
 import (
+	"fmt"
 	"os"
 )
 
@@ -122,9 +123,114 @@ type Option func(*config)
 
 // config holds all configuration for a Lua state.
 type config struct {
-	check bool
-	trace bool
-	debug bool
+	version  LuaVersion
+	optimize bool
+	check    bool
+	trace    bool
+	debug    bool
+	cacheDir string
+	limit    int64
+	maxmem   int64
+	maxcalls int
+	maxstack int
+}
+
+// LuaVersion selects the Lua language version implemented by a state.
+type LuaVersion int
+
+const (
+	V53 LuaVersion = iota // Lua 5.3 (default)
+	V54                   // Lua 5.4
+)
+
+// String returns the canonical name of the version, e.g. "Lua 5.3".
+func (v LuaVersion) String() string {
+	switch v {
+	case V53:
+		return "Lua 5.3"
+	case V54:
+		return "Lua 5.4"
+	}
+	return fmt.Sprintf("unknown Lua version %d", int(v))
+}
+
+// WithVersion returns an Option that selects the Lua language version.
+//
+// V54 turns on the 5.4 grammar (<const> and <close> locals), executes
+// 5.4 bytecode and enables the 5.4 library additions; V53 is the default.
+func WithVersion(v LuaVersion) Option {
+	return func(cfg *config) {
+		cfg.version = v
+	}
+}
+
+// WithOptimizer returns an Option that toggles the bytecode optimizer.
+//
+// When enabled, the prototypes of the chunks loaded by the state, whether
+// compiled from source or loaded as binary chunks, are optimized before
+// being run (see syntax.Optimize). The optimizer only knows Lua 5.3
+// bytecode and is not run in V54 mode.
+func WithOptimizer(enable bool) Option {
+	return func(cfg *config) {
+		cfg.optimize = enable
+	}
+}
+
+// WithChunkCache returns an Option that keeps the binary chunks compiled
+// from source in the directory dir, keyed by a hash of the source, the
+// chunk name, the Lua version and the version of the compiler, so that
+// loading the same source again skips compilation. The directory is created if needed; entries that are
+// corrupt or were written for another version are ignored and replaced.
+func WithChunkCache(dir string) Option {
+	return func(cfg *config) {
+		cfg.cacheDir = dir
+	}
+}
+
+// WithInstructionLimit returns an Option that limits the number of instructions
+// the state may execute, counting the costs charged by Go functions, to n; a
+// *BudgetError is raised when it is exceeded. Zero or a negative n means no
+// limit (the default). See also SetBudget.
+func WithInstructionLimit(n int64) Option {
+	return func(cfg *config) {
+		cfg.limit = n
+	}
+}
+
+// WithMemoryLimit returns an Option that limits the memory used by the
+// objects of the state (see MemoryUsage) to n bytes; exceeding it raises a
+// "not enough memory" error (ErrMemory). Zero or a negative n means no limit
+// (the default).
+func WithMemoryLimit(n int64) Option {
+	return func(cfg *config) {
+		cfg.maxmem = n
+	}
+}
+
+// WithCallLimit returns an Option that limits the depth of the nested calls
+// of the state, Lua or Go, to n (MaxCalls by default), counting in a coroutine
+// those of the threads that resumed it; a "stack overflow" error is raised
+// beyond. The calls made by Go functions, including the resumes of coroutines,
+// are also limited to a depth of MaxGoCalls, whatever n.
+//
+// Each nested call takes room on the stack of the goroutine running it, and
+// the Go runtime aborts the process when that stack exceeds its maximum size
+// (see runtime/debug.SetMaxStack); n must be low enough to prevent it. The
+// depth of the Lua calls is also bounded by the size of the stack of values
+// (see WithStackLimit), which each call uses a part of.
+func WithCallLimit(n int) Option {
+	return func(cfg *config) {
+		cfg.maxcalls = n
+	}
+}
+
+// WithStackLimit returns an Option that limits the number of values on the
+// stack of each thread of the state to n (DefaultStackMax by default); a
+// "stack overflow" error is raised beyond.
+func WithStackLimit(n int) Option {
+	return func(cfg *config) {
+		cfg.maxstack = n
+	}
 }
 
 // WithChecks returns an Option that instruction a Lua state to perform API checks.
@@ -51,11 +51,14 @@ const (
 	// Limit for table tag-method chains (to avoid loops).
 	metaLoopMax = 10
 
-	// Maximum depth for nested Go calls and syntactical nested non-terminals
-	// in a program.
-	//
-	// Value must be < 255.
-	MaxCalls = 255
+	// Maximum depth for nested calls, Lua or Go (see WithCallLimit). Each
+	// takes less than a kilobyte of the stack of the goroutine running it.
+	MaxCalls = 200000
+
+	// Maximum depth for nested calls made by Go functions, counting the
+	// resumes of coroutines, which each run on a goroutine of their own
+	// (as LUAI_MAXCCALLS bounds the nested C calls).
+	MaxGoCalls = 200
 
 	// Number of list items to accumulate before a SETLIST instruction.
 	FieldsPerFlush = 50
@@ -0,0 +1,77 @@
+package lua
+
+import "context"
+
+// contextCheckInterval is the number of instructions between two checks
+// of the state's context by the executors (see tick), in addition to those
+// made on calls and backward jumps.
+const contextCheckInterval = 1024
+
+// ContextError is the error raised in a state run by PCallContext when its
+// context is done; Err is the context's error.
+//
+// A ContextError stops the execution of the state: it is not caught by PCall
+// when called from a running function (and so by the pcall Lua function), or
+// by the resumer of a coroutine, but returned by PCallContext.
+type ContextError struct {
+	Err error
+}
+
+func (e *ContextError) Error() string { return e.Err.Error() }
+
+func (e *ContextError) Unwrap() error { return e.Err }
+
+// PCallContext calls a function in protected mode, as PCall, while the state
+// checks ctx on calls, backward jumps and periodically: when ctx is done, the
+// execution is stopped with a *ContextError wrapping ctx.Err(), which is
+// returned.
+//
+// The context applies to all the threads of the state, and may be retrieved
+// by Go functions with Context.
+func (state *State) PCallContext(ctx context.Context, args, rets, msgh int) (err error) {
+	var (
+		g    = state.global
+		prev = g.ctx
+	)
+	g.ctx = ctx
+	defer func() {
+		g.ctx = prev
+		if r := recover(); r != nil {
+			if e, ok := r.(*ContextError); ok && e.Err == ctx.Err() {
+				err = e
+				return
+			}
+			panic(r)
+		}
+	}()
+	return state.PCall(args, rets, msgh)
+}
+
+// ExecContext loads a Lua chunk as ExecChunk does, and runs it with
+// PCallContext, returning any error.
+func (state *State) ExecContext(ctx context.Context, filename string, source interface{}, mode Mode) error {
+	if err := state.LoadChunk(filename, source, mode); err != nil {
+		return err
+	}
+	return state.PCallContext(ctx, 0, MultRets, 0)
+}
+
+// Context returns the context of the running PCallContext, or
+// context.Background() if there is none.
+func (state *State) Context() context.Context {
+	if ctx := state.global.ctx; ctx != nil {
+		return ctx
+	}
+	return context.Background()
+}
+
+// checkContext raises a *ContextError if the state's context is done.
+func (state *State) checkContext() {
+	if ctx := state.global.ctx; ctx != nil {
+		select {
+		case <-ctx.Done():
+			state.panic(&ContextError{Err: ctx.Err()})
+		default:
+		}
+	}
+}
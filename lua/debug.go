@@ -6,6 +6,8 @@ import (
 	"reflect"
 	"runtime"
 	"strings"
+
+	"github.com/Azure/golua/lua/syntax"
 )
 
 // debug is a structure used to carry different pieces of information about a function
@@ -25,6 +27,7 @@ type Debug struct {
 	params   int
 	vararg   bool
 	tailcall bool
+	frame    *Frame
 }
 
 func (debug *Debug) Source() string       { return debug.source }
@@ -51,7 +54,14 @@ func (debug *Debug) IsTailCall() bool     { return debug.tailcall }
 //
 // See https://www.lua.org/manual/5.3/manual.html#lua_getstack
 func (state *State) GetStack(debug *Debug, depth int) error {
-	fmt.Println("state.GetStack(): TODO")
+	fr := state.frame()
+	for ; fr != nil && depth > 0; depth-- {
+		fr = fr.caller()
+	}
+	if fr == nil || fr.closure == nil {
+		return fmt.Errorf("level out of range")
+	}
+	debug.frame = fr
 	return nil
 }
 
@@ -97,7 +107,10 @@ func (state *State) GetInfo(debug *Debug, options string) error {
 		}
 		return fmt.Errorf("function expected")
 	}
-	return fmt.Errorf("state.GetInfo(): TODO")
+	if debug.frame == nil {
+		return fmt.Errorf("invalid activation record")
+	}
+	return state.getInfo(debug.frame, debug, debug.frame.closure, options)
 }
 
 func (state *State) getInfo(frame *Frame, debug *Debug, closure *Closure, options string) error {
@@ -124,7 +137,9 @@ func (state *State) getInfo(frame *Frame, debug *Debug, closure *Closure, option
 			name, kind := funcname(frame, closure)
 			debug.name = name
 			debug.kind = kind
-		case 'L', 'f':
+		case 'f':
+			state.frame().push(closure)
+		case 'L':
 			// TODO
 		default:
 			return fmt.Errorf("invalid option: %c", b)
@@ -149,7 +164,7 @@ func (state *State) GetUpValue(function, index int) (name string) {
 	if cls, ok := state.get(function).(*Closure); ok {
 		if index <= len(cls.upvals) {
 			up := cls.getUp(index - 1)
-			state.Push(up.get())
+			state.frame().pushReg(up.get())
 			name = cls.upName(index - 1)
 		}
 	}
@@ -256,14 +271,14 @@ func DBG(fr *Frame, halt bool) {
 	fmt.Fprintf(&b, "            end\n\n")
 
 	fmt.Fprintf(&b, "            varargs\n")
-	for i, extra := range fr.vararg {
+	for i, extra := range fr.varargs(0) {
 		fmt.Fprintf(&b, "                [%d] %v\n", i, extra)
 	}
 	fmt.Fprintf(&b, "            end\n")
 	fmt.Fprintf(&b, "    end\n\n")
-	fmt.Fprintf(&b, "    locals (len=%d, cap=%d, top=%d)\n", len(fr.locals), cap(fr.locals), fr.gettop())
+	fmt.Fprintf(&b, "    locals (base=%d, top=%d, stack=%d)\n", fr.base, fr.top, len(fr.state.stack))
 	for i := fr.gettop() - 1; i >= 0; i-- {
-		fmt.Fprintf(&b, "        [%d] %v\n", i+base, fr.locals[i])
+		fmt.Fprintf(&b, "        [%d] %v\n", i+base, fr.locals()[i])
 	}
 
 	fmt.Fprintf(&b, "    end\n")
@@ -304,32 +319,6 @@ func funcinfo(frame *Frame, debug *Debug, closure *Closure) {
 		debug.span[1] = -1
 		debug.what = "Go"
 	}
-	// TODO: short
-	debug.short = chunkID(debug.source)
+	debug.short = syntax.ChunkID(debug.source)
 }
 
-func chunkID(source string) string {
-	const maxLen = 60
-
-	if len(source) > 0 {
-		switch source[0] {
-		case '=':
-			if source = source[1:]; len(source) > maxLen {
-				source = source[:maxLen-1]
-			}
-		case '@':
-			if source = source[1:]; len(source) > maxLen {
-				source = fmt.Sprintf("...%s", source[len(source)-maxLen+4:])
-			}
-		default:
-			if i := strings.IndexByte(source, '\n'); i != -1 {
-				source = source[0:i] + "..."
-			}
-			if max := maxLen - len(`[string " "]`); len(source) > max {
-				source = source[0:max-3] + "..."
-			}
-			source = fmt.Sprintf(`[string "%s"]`, source)
-		}
-	}
-	return source
-}
@@ -230,6 +230,7 @@ const (
 	metaNewIndex
 	metaCall
 	metaMode
+	metaGC
 )
 
 var event2name = [...]string{
@@ -256,6 +257,7 @@ var event2name = [...]string{
 	metaNewIndex: "newindex",
 	metaCall:     "call",
 	metaMode:     "mode",
+	metaGC:       "gc",
 }
 
 func (evt metaEvent) ID() string { return "__" + event2name[evt] }
@@ -279,7 +281,7 @@ func metaOf(state *State, v Value) *table {
 				}
 				return 0
 			})
-			events.setStr(metaNewIndex.ID(), newGoClosure(method, 0))
+			events.setStr(metaNewIndex.ID(), newGoClosure(state, method, 0))
 		}
 		if o, ok := u.(HasIndex); ok { // __index
 			method := Func(func(state *State) int {
@@ -290,7 +292,7 @@ func metaOf(state *State, v Value) *table {
 				state.Push(v)
 				return 1
 			})
-			events.setStr(metaIndex.ID(), newGoClosure(method, 0))
+			events.setStr(metaIndex.ID(), newGoClosure(state, method, 0))
 		}
 		if o, ok := u.(Callable); ok { // __call
 			method := Func(func(state *State) int {
@@ -307,7 +309,7 @@ func metaOf(state *State, v Value) *table {
 				}
 				return len(vs)
 			})
-			events.setStr(metaCall.ID(), newGoClosure(method, 0))
+			events.setStr(metaCall.ID(), newGoClosure(state, method, 0))
 		}
 		if o, ok := u.(HasConcat); ok { // __concat
 			method := Func(func(state *State) int {
@@ -318,7 +320,7 @@ func metaOf(state *State, v Value) *table {
 				state.Push(v)
 				return 1
 			})
-			events.setStr(metaConcat.ID(), newGoClosure(method, 0))
+			events.setStr(metaConcat.ID(), newGoClosure(state, method, 0))
 		}
 
 		if o, ok := u.(HasAdd); ok { // __add
@@ -330,7 +332,7 @@ func metaOf(state *State, v Value) *table {
 				state.Push(v)
 				return 1
 			})
-			events.setStr(metaAdd.ID(), newGoClosure(method, 0))
+			events.setStr(metaAdd.ID(), newGoClosure(state, method, 0))
 		}
 	}
 	return events
@@ -368,7 +370,7 @@ func tryMetaNewIndex(state *State, object, key, value Value) error {
 			}
 		}
 		if table, ok := object.(*table); ok {
-			table.set(key, value)
+			table.set(unbox(key), unbox(value))
 			return nil
 		}
 	}
@@ -389,8 +391,8 @@ func tryMetaIndex(state *State, object, key Value) (Value, error) {
 
 	for loop := 0; loop < metaLoopMax; loop++ {
 		if table, ok := object.(*table); ok {
-			if table.exists(key) {
-				return table.get(key), nil
+			if k := unbox(key); table.exists(k) {
+				return table.get(k).box(), nil
 			}
 		}
 		switch meta := state.metafield(object, event.ID()).(type) {
@@ -531,6 +533,22 @@ func tryMetaLength(state *State, obj Value) (Value, error) {
 	return nil, fmt.Errorf("attempt to get length of %v value", obj.Type())
 }
 
+// tryMetaClose calls the __close metamethod of a to-be-closed variable obj
+// when it goes out of scope, with the error object err that caused the exit
+// (or nil).
+func tryMetaClose(state *State, obj, err Value) {
+	if meta := state.metafield(obj, "__close"); !IsNone(meta) {
+		if cls, ok := meta.(*Closure); ok {
+			state.frame().push(cls)
+			state.frame().push(obj)
+			state.frame().push(err)
+			state.Call(2, 0)
+			return
+		}
+	}
+	state.errorf("attempt to call a %s value (metamethod 'close')", state.metafield(obj, "__close").Type())
+}
+
 // tryMetaCall performs the call operation func(args). This event happens when
 // Lua tries to call a non-function value (that is, func is not a function).
 // The metamethod is looked is looked up in func. If present, the metamethod
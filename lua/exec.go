@@ -3,29 +3,51 @@ package lua
 import (
 	"fmt"
 
-	"github.com/Azure/golua/lua/binary"
 	"github.com/Azure/golua/lua/vm"
 )
 
-// v53 is the Lua 5.3 engine.
-type v53 struct{ state *State }
+// v53 is the Lua 5.3 engine, executing the Lua function of a frame.
+type v53 struct {
+	state *State
+	fr    *Frame  // executing frame
+	k     []value // constants of the frame's function
+	base  int     // base of the frame's registers
+}
 
 // prototype pushes onto the stack a closure for the function prototype
 // at index of the binary chunk
-func (vm *v53) prototype(index int) *binary.Prototype {
-	cls := vm.thread().frame().function()
-	return &cls.binary.Protos[index]
+func (vm *v53) prototype(index int) *prototype {
+	return vm.fr.closure.proto.proto(vm.thread(), index)
 }
 
 // constant pushes onto the stack the value of constant at index.
-func (vm *v53) constant(index int) Value {
-	cls := vm.thread().frame().function()
-	return valueOf(vm.thread(), cls.binary.Consts[index])
-}
+func (vm *v53) constant(index int) value { return vm.k[index] }
 
 // thread returns the executing thread's state.
 func (vm *v53) thread() *State { return vm.state }
 
+// frame returns the executing frame.
+func (vm *v53) frame() *Frame { return vm.fr }
+
+// reg returns the value of the register at index of the executing frame,
+// or none above its top.
+func (vm *v53) reg(index int) value {
+	if i := vm.base + index; index >= 0 && i < vm.fr.top {
+		return vm.state.stack[i]
+	}
+	return none
+}
+
+// setReg sets the register at index of the executing frame to v, raising
+// the frame's top above it if needed.
+func (vm *v53) setReg(index int, v value) {
+	if i := vm.base + index; i < vm.fr.top {
+		vm.state.stack[i] = v
+		return
+	}
+	vm.fr.setReg(index, v)
+}
+
 // Try to convert a 'for' limit to an integer, preserving the semantics of the loop.
 //
 // The following explanation assumes a non-negative step; it is valid for negative
@@ -48,232 +70,173 @@ func (vm *v53) thread() *State { return vm.state }
 func (vm *v53) trace(instr vm.Instr) {
 	if vm.thread().global.config.debug {
 		fmt.Printf("vm @ ip=%02d fp=%02d: %v\n",
-			vm.thread().frame().pc,
-			vm.thread().frame().depth,
+			vm.frame().pc,
+			vm.frame().depth,
 			instr,
 		)
 	}
 }
 
-// fetch returns the next opcode function and instruction to execute
-// incrementing the frame's instruction pointer (pc).
-func (vm *v53) fetch() (cmd, vm.Instr) {
-	i := vm.thread().frame().step(1)
-	return ops[i.Code()], i
-}
-
 // rk returns the value of the index that is either a register local
 // value in the frame's locals stack or the n'th constant in the
 // function prototype.
-func (vm *v53) rk(index int) Value {
+func (vm *v53) rk(index int) value {
 	if index > 0xFF { // Constant value
 		return vm.constant(index & 0xFF)
 	}
 	// Registry value
-	return vm.thread().frame().get(index)
+	return vm.reg(index)
 }
 
-func execute(vm *v53) {
-	for cmd, instr := vm.fetch(); cmd != nil; cmd, instr = cmd(vm, instr) {
-		vm.trace(instr)
+// field returns the value of obj[RK(index)], through the inline cache of
+// the executing instruction if the key is a string constant (see fieldCache).
+func (vm *v53) field(obj value, index int) value {
+	key := vm.rk(index)
+	if _, ok := key.v.(String); ok && index > 0xFF {
+		fr := vm.frame()
+		return vm.thread().getfield(obj, key, fr.closure.proto.cache(fr.pc-1))
 	}
+	return vm.thread().gettable(obj, key, false)
 }
 
-// cmd is an executor for a lua opcode.
-type cmd func(*v53, vm.Instr) (cmd, vm.Instr)
-
-// ops is a table of lua v53 opcode commands.
-var ops []cmd
+// execute runs the Lua function of the current frame until it returns.
+//
+// The frame, its code, constants and base are kept in locals and in the engine,
+// reloaded when a tail call replaces the frame's function. The instructions
+// are dispatched by a switch; a comparison or test goes on with the jump
+// that follows it in place (see branch).
+func execute(e *v53) {
+	var (
+		g     = e.thread().global
+		debug = g.config.debug
+		fr    = e.thread().frame()
+		code  = fr.closure.binary.Code
+	)
+	e.fr, e.k, e.base = fr, fr.closure.proto.consts, fr.base
+	for {
+		instr := vm.Instr(code[fr.pc])
+		fr.pc++
+		if debug {
+			e.trace(instr)
+		}
+		if g.used++; g.used >= g.next {
+			e.thread().tick()
+		}
+		switch instr.Code() {
+		case vm.MOVE:
+			e.move(instr)
+		case vm.LOADK:
+			e.loadk(instr)
+		case vm.LOADKX:
+			e.loadkx(instr)
+		case vm.LOADBOOL:
+			e.loadbool(instr)
+		case vm.LOADNIL:
+			e.loadnil(instr)
+		case vm.GETUPVAL:
+			e.getupval(instr)
+		case vm.GETTABUP:
+			e.gettabup(instr)
+		case vm.GETTABLE:
+			e.gettable(instr)
+		case vm.SETTABUP:
+			e.settabup(instr)
+		case vm.SETUPVAL:
+			e.setupval(instr)
+		case vm.SETTABLE:
+			e.settable(instr)
+		case vm.NEWTABLE:
+			e.newtable(instr)
+		case vm.SELF:
+			e.self(instr)
+		case vm.ADD:
+			e.add(instr)
+		case vm.SUB:
+			e.sub(instr)
+		case vm.MUL:
+			e.mul(instr)
+		case vm.MOD:
+			e.mod(instr)
+		case vm.POW:
+			e.pow(instr)
+		case vm.DIV:
+			e.div(instr)
+		case vm.IDIV:
+			e.idiv(instr)
+		case vm.BAND:
+			e.band(instr)
+		case vm.BOR:
+			e.bor(instr)
+		case vm.BXOR:
+			e.bxor(instr)
+		case vm.SHL:
+			e.shl(instr)
+		case vm.SHR:
+			e.shr(instr)
+		case vm.UNM:
+			e.unm(instr)
+		case vm.BNOT:
+			e.bnot(instr)
+		case vm.NOT:
+			e.not(instr)
+		case vm.LEN:
+			e.length(instr)
+		case vm.CONCAT:
+			e.concat(instr)
+		case vm.JMP:
+			e.jmp(instr)
+		case vm.EQ:
+			branch(e, code, e.eq(instr))
+		case vm.LT:
+			branch(e, code, e.lt(instr))
+		case vm.LE:
+			branch(e, code, e.le(instr))
+		case vm.TEST:
+			branch(e, code, e.test(instr))
+		case vm.TESTSET:
+			branch(e, code, e.testset(instr))
+		case vm.CALL:
+			e.call(instr)
+		case vm.TAILCALL:
+			e.tailcall(instr)
+			code, e.k, e.base = fr.closure.binary.Code, fr.closure.proto.consts, fr.base
+		case vm.RETURN:
+			e.returns(instr)
+			return
+		case vm.FORLOOP:
+			e.forloop(instr)
+		case vm.FORPREP:
+			e.forprep(instr)
+		case vm.TFORCALL:
+			e.tforcall(instr)
+		case vm.TFORLOOP:
+			e.tforloop(instr)
+		case vm.SETLIST:
+			e.setlist(instr)
+		case vm.CLOSURE:
+			e.closure(instr)
+		case vm.VARARG:
+			e.vararg(instr)
+		case vm.EXTRAARG:
+			e.extraarg(instr)
+		}
+	}
+}
 
-func init() {
-	ops = []cmd{
-		vm.MOVE: func(vm *v53, instr vm.Instr) (cmd, vm.Instr) {
-			vm.move(instr)
-			return vm.fetch()
-		},
-		vm.LOADK: func(vm *v53, instr vm.Instr) (cmd, vm.Instr) {
-			vm.loadk(instr)
-			return vm.fetch()
-		},
-		vm.LOADKX: func(vm *v53, instr vm.Instr) (cmd, vm.Instr) {
-			vm.loadkx(instr)
-			return vm.fetch()
-		},
-		vm.LOADBOOL: func(vm *v53, instr vm.Instr) (cmd, vm.Instr) {
-			vm.loadbool(instr)
-			return vm.fetch()
-		},
-		vm.LOADNIL: func(vm *v53, instr vm.Instr) (cmd, vm.Instr) {
-			vm.loadnil(instr)
-			return vm.fetch()
-		},
-		vm.GETUPVAL: func(vm *v53, instr vm.Instr) (cmd, vm.Instr) {
-			vm.getupval(instr)
-			return vm.fetch()
-		},
-		vm.GETTABUP: func(vm *v53, instr vm.Instr) (cmd, vm.Instr) {
-			vm.gettabup(instr)
-			return vm.fetch()
-		},
-		vm.GETTABLE: func(vm *v53, instr vm.Instr) (cmd, vm.Instr) {
-			vm.gettable(instr)
-			return vm.fetch()
-		},
-		vm.SETTABUP: func(vm *v53, instr vm.Instr) (cmd, vm.Instr) {
-			vm.settabup(instr)
-			return vm.fetch()
-		},
-		vm.SETUPVAL: func(vm *v53, instr vm.Instr) (cmd, vm.Instr) {
-			vm.setupval(instr)
-			return vm.fetch()
-		},
-		vm.SETTABLE: func(vm *v53, instr vm.Instr) (cmd, vm.Instr) {
-			vm.settable(instr)
-			return vm.fetch()
-		},
-		vm.NEWTABLE: func(vm *v53, instr vm.Instr) (cmd, vm.Instr) {
-			vm.newtable(instr)
-			return vm.fetch()
-		},
-		vm.SELF: func(vm *v53, instr vm.Instr) (cmd, vm.Instr) {
-			vm.self(instr)
-			return vm.fetch()
-		},
-		vm.ADD: func(vm *v53, instr vm.Instr) (cmd, vm.Instr) {
-			vm.add(instr)
-			return vm.fetch()
-		},
-		vm.SUB: func(vm *v53, instr vm.Instr) (cmd, vm.Instr) {
-			vm.sub(instr)
-			return vm.fetch()
-		},
-		vm.MUL: func(vm *v53, instr vm.Instr) (cmd, vm.Instr) {
-			vm.mul(instr)
-			return vm.fetch()
-		},
-		vm.MOD: func(vm *v53, instr vm.Instr) (cmd, vm.Instr) {
-			vm.mod(instr)
-			return vm.fetch()
-		},
-		vm.POW: func(vm *v53, instr vm.Instr) (cmd, vm.Instr) {
-			vm.pow(instr)
-			return vm.fetch()
-		},
-		vm.DIV: func(vm *v53, instr vm.Instr) (cmd, vm.Instr) {
-			vm.div(instr)
-			return vm.fetch()
-		},
-		vm.IDIV: func(vm *v53, instr vm.Instr) (cmd, vm.Instr) {
-			vm.idiv(instr)
-			return vm.fetch()
-		},
-		vm.BAND: func(vm *v53, instr vm.Instr) (cmd, vm.Instr) {
-			vm.band(instr)
-			return vm.fetch()
-		},
-		vm.BOR: func(vm *v53, instr vm.Instr) (cmd, vm.Instr) {
-			vm.bor(instr)
-			return vm.fetch()
-		},
-		vm.BXOR: func(vm *v53, instr vm.Instr) (cmd, vm.Instr) {
-			vm.bxor(instr)
-			return vm.fetch()
-		},
-		vm.SHL: func(vm *v53, instr vm.Instr) (cmd, vm.Instr) {
-			vm.shl(instr)
-			return vm.fetch()
-		},
-		vm.SHR: func(vm *v53, instr vm.Instr) (cmd, vm.Instr) {
-			vm.shr(instr)
-			return vm.fetch()
-		},
-		vm.UNM: func(vm *v53, instr vm.Instr) (cmd, vm.Instr) {
-			vm.unm(instr)
-			return vm.fetch()
-		},
-		vm.BNOT: func(vm *v53, instr vm.Instr) (cmd, vm.Instr) {
-			vm.bnot(instr)
-			return vm.fetch()
-		},
-		vm.NOT: func(vm *v53, instr vm.Instr) (cmd, vm.Instr) {
-			vm.not(instr)
-			return vm.fetch()
-		},
-		vm.LEN: func(vm *v53, instr vm.Instr) (cmd, vm.Instr) {
-			vm.length(instr)
-			return vm.fetch()
-		},
-		vm.CONCAT: func(vm *v53, instr vm.Instr) (cmd, vm.Instr) {
-			vm.concat(instr)
-			return vm.fetch()
-		},
-		vm.JMP: func(vm *v53, instr vm.Instr) (cmd, vm.Instr) {
-			vm.jmp(instr)
-			return vm.fetch()
-		},
-		vm.EQ: func(vm *v53, instr vm.Instr) (cmd, vm.Instr) {
-			vm.eq(instr)
-			return vm.fetch()
-		},
-		vm.LT: func(vm *v53, instr vm.Instr) (cmd, vm.Instr) {
-			vm.lt(instr)
-			return vm.fetch()
-		},
-		vm.LE: func(vm *v53, instr vm.Instr) (cmd, vm.Instr) {
-			vm.le(instr)
-			return vm.fetch()
-		},
-		vm.TEST: func(vm *v53, instr vm.Instr) (cmd, vm.Instr) {
-			vm.test(instr)
-			return vm.fetch()
-		},
-		vm.TESTSET: func(vm *v53, instr vm.Instr) (cmd, vm.Instr) {
-			vm.testset(instr)
-			return vm.fetch()
-		},
-		vm.CALL: func(vm *v53, instr vm.Instr) (cmd, vm.Instr) {
-			vm.call(instr)
-			return vm.fetch()
-		},
-		vm.TAILCALL: func(vm *v53, instr vm.Instr) (cmd, vm.Instr) {
-			vm.tailcall(instr)
-			return vm.fetch()
-		},
-		vm.RETURN: func(vm *v53, instr vm.Instr) (cmd, vm.Instr) {
-			vm.returns(instr)
-			return nil, instr
-		},
-		vm.FORLOOP: func(vm *v53, instr vm.Instr) (cmd, vm.Instr) {
-			vm.forloop(instr)
-			return vm.fetch()
-		},
-		vm.FORPREP: func(vm *v53, instr vm.Instr) (cmd, vm.Instr) {
-			vm.forprep(instr)
-			return vm.fetch()
-		},
-		vm.TFORCALL: func(vm *v53, instr vm.Instr) (cmd, vm.Instr) {
-			vm.tforcall(instr)
-			return vm.fetch()
-		},
-		vm.TFORLOOP: func(vm *v53, instr vm.Instr) (cmd, vm.Instr) {
-			vm.tforloop(instr)
-			return vm.fetch()
-		},
-		vm.SETLIST: func(vm *v53, instr vm.Instr) (cmd, vm.Instr) {
-			vm.setlist(instr)
-			return vm.fetch()
-		},
-		vm.CLOSURE: func(vm *v53, instr vm.Instr) (cmd, vm.Instr) {
-			vm.closure(instr)
-			return vm.fetch()
-		},
-		vm.VARARG: func(vm *v53, instr vm.Instr) (cmd, vm.Instr) {
-			vm.vararg(instr)
-			return vm.fetch()
-		},
-		vm.EXTRAARG: func(vm *v53, instr vm.Instr) (cmd, vm.Instr) {
-			vm.extraarg(instr)
-			return vm.fetch()
-		},
+// branch goes on after a comparison or test with the JMP that follows it:
+// if jump is true, the JMP is executed in place, and counted and checked as
+// any executed instruction; otherwise it is skipped.
+func branch(e *v53, code []uint32, jump bool) {
+	fr := e.fr
+	switch next := vm.Instr(code[fr.pc]); {
+	case !jump:
+		fr.pc++
+	case next.Code() == vm.JMP:
+		fr.pc++
+		g := e.thread().global
+		if g.used++; g.used >= g.next {
+			e.thread().tick()
+		}
+		e.jmp(next)
 	}
 }
@@ -0,0 +1,295 @@
+package lua
+
+import (
+	"fmt"
+
+	"github.com/Azure/golua/lua/vm54"
+)
+
+// v54 is the Lua 5.4 engine, executing the Lua function of a frame.
+type v54 struct {
+	state *State
+	fr    *Frame  // executing frame
+	k     []value // constants of the frame's function
+	base  int     // base of the frame's registers
+}
+
+// prototype returns the function prototype at index of the
+// executing closure's binary chunk.
+func (vm *v54) prototype(index int) *prototype {
+	return vm.fr.closure.proto.proto(vm.thread(), index)
+}
+
+// constant returns the value of constant at index.
+func (vm *v54) constant(index int) value { return vm.k[index] }
+
+// thread returns the executing thread's state.
+func (vm *v54) thread() *State { return vm.state }
+
+// frame returns the executing frame.
+func (vm *v54) frame() *Frame { return vm.fr }
+
+// reg returns the value of the register at index of the executing frame,
+// or none above its top.
+func (vm *v54) reg(index int) value {
+	if i := vm.base + index; index >= 0 && i < vm.fr.top {
+		return vm.state.stack[i]
+	}
+	return none
+}
+
+// setReg sets the register at index of the executing frame to v, raising
+// the frame's top above it if needed.
+func (vm *v54) setReg(index int, v value) {
+	if i := vm.base + index; i < vm.fr.top {
+		vm.state.stack[i] = v
+		return
+	}
+	vm.fr.setReg(index, v)
+}
+
+func (vm *v54) trace(instr vm54.Instr) {
+	if vm.thread().global.config.debug {
+		fmt.Printf("vm @ ip=%02d fp=%02d: %v\n",
+			vm.frame().pc,
+			vm.frame().depth,
+			instr,
+		)
+	}
+}
+
+// field returns the value of obj[K(index)], through the inline cache of the
+// executing instruction if the key is a string (see fieldCache).
+func (vm *v54) field(obj value, index int) value {
+	key := vm.constant(index)
+	if _, ok := key.v.(String); ok {
+		fr := vm.frame()
+		return vm.thread().getfield(obj, key, fr.closure.proto.cache(fr.pc-1))
+	}
+	return vm.thread().gettable(obj, key, false)
+}
+
+// rk returns the value of operand C, which is either a register
+// local value in the frame's locals stack or, if the k flag of
+// the instruction is set, the C'th constant in the function
+// prototype.
+func (vm *v54) rk(instr vm54.Instr) value {
+	if instr.K() == 1 { // Constant value
+		return vm.constant(instr.C())
+	}
+	// Registry value
+	return vm.reg(instr.C())
+}
+
+// unwind closes the pending to-be-closed variables of the executing
+// frame when an error escapes it, passing them the error object (or
+// nil when the coroutine is being closed, but none when it is released),
+// and then propagates the error.
+func (vm *v54) unwind() {
+	if r := recover(); r != nil {
+		if err, ok := r.(error); ok && len(vm.frame().tbc) > 0 {
+			switch {
+			case err != errThreadClosed:
+				vm.closeVars(0, errorObject(err))
+			case !vm.thread().co.released:
+				vm.closeVars(0, Nil(1))
+			}
+		}
+		panic(r)
+	}
+}
+
+// execute54 runs the Lua function of the current frame until it returns,
+// as execute does.
+func execute54(vm *v54) {
+	var (
+		g     = vm.thread().global
+		debug = g.config.debug
+		fr    = vm.thread().frame()
+		code  = fr.closure.binary.Code
+	)
+	vm.fr, vm.k, vm.base = fr, fr.closure.proto.consts, fr.base
+	defer vm.unwind()
+	for {
+		instr := vm54.Instr(code[fr.pc])
+		fr.pc++
+		if debug {
+			vm.trace(instr)
+		}
+		if g.used++; g.used >= g.next {
+			vm.thread().tick()
+		}
+		switch instr.Code() {
+		case vm54.MOVE:
+			vm.move(instr)
+		case vm54.LOADI:
+			vm.loadi(instr)
+		case vm54.LOADF:
+			vm.loadf(instr)
+		case vm54.LOADK:
+			vm.loadk(instr)
+		case vm54.LOADKX:
+			vm.loadkx(instr)
+		case vm54.LOADFALSE:
+			vm.loadfalse(instr)
+		case vm54.LFALSESKIP:
+			vm.lfalseskip(instr)
+		case vm54.LOADTRUE:
+			vm.loadtrue(instr)
+		case vm54.LOADNIL:
+			vm.loadnil(instr)
+		case vm54.GETUPVAL:
+			vm.getupval(instr)
+		case vm54.SETUPVAL:
+			vm.setupval(instr)
+		case vm54.GETTABUP:
+			vm.gettabup(instr)
+		case vm54.GETTABLE:
+			vm.gettable(instr)
+		case vm54.GETI:
+			vm.geti(instr)
+		case vm54.GETFIELD:
+			vm.getfield(instr)
+		case vm54.SETTABUP:
+			vm.settabup(instr)
+		case vm54.SETTABLE:
+			vm.settable(instr)
+		case vm54.SETI:
+			vm.seti(instr)
+		case vm54.SETFIELD:
+			vm.setfield(instr)
+		case vm54.NEWTABLE:
+			vm.newtable(instr)
+		case vm54.SELF:
+			vm.self(instr)
+		case vm54.ADDI:
+			vm.addi(instr)
+		case vm54.ADDK:
+			vm.addk(instr)
+		case vm54.SUBK:
+			vm.subk(instr)
+		case vm54.MULK:
+			vm.mulk(instr)
+		case vm54.MODK:
+			vm.modk(instr)
+		case vm54.POWK:
+			vm.powk(instr)
+		case vm54.DIVK:
+			vm.divk(instr)
+		case vm54.IDIVK:
+			vm.idivk(instr)
+		case vm54.BANDK:
+			vm.bandk(instr)
+		case vm54.BORK:
+			vm.bork(instr)
+		case vm54.BXORK:
+			vm.bxork(instr)
+		case vm54.SHRI:
+			vm.shri(instr)
+		case vm54.SHLI:
+			vm.shli(instr)
+		case vm54.ADD:
+			vm.add(instr)
+		case vm54.SUB:
+			vm.sub(instr)
+		case vm54.MUL:
+			vm.mul(instr)
+		case vm54.MOD:
+			vm.mod(instr)
+		case vm54.POW:
+			vm.pow(instr)
+		case vm54.DIV:
+			vm.div(instr)
+		case vm54.IDIV:
+			vm.idiv(instr)
+		case vm54.BAND:
+			vm.band(instr)
+		case vm54.BOR:
+			vm.bor(instr)
+		case vm54.BXOR:
+			vm.bxor(instr)
+		case vm54.SHL:
+			vm.shl(instr)
+		case vm54.SHR:
+			vm.shr(instr)
+		case vm54.MMBIN:
+			vm.mmbin(instr)
+		case vm54.MMBINI:
+			vm.mmbini(instr)
+		case vm54.MMBINK:
+			vm.mmbink(instr)
+		case vm54.UNM:
+			vm.unm(instr)
+		case vm54.BNOT:
+			vm.bnot(instr)
+		case vm54.NOT:
+			vm.not(instr)
+		case vm54.LEN:
+			vm.length(instr)
+		case vm54.CONCAT:
+			vm.concat(instr)
+		case vm54.CLOSE:
+			vm.close(instr)
+		case vm54.TBC:
+			vm.tbc(instr)
+		case vm54.JMP:
+			vm.jmp(instr)
+		case vm54.EQ:
+			vm.eq(instr)
+		case vm54.LT:
+			vm.lt(instr)
+		case vm54.LE:
+			vm.le(instr)
+		case vm54.EQK:
+			vm.eqk(instr)
+		case vm54.EQI:
+			vm.eqi(instr)
+		case vm54.LTI:
+			vm.lti(instr)
+		case vm54.LEI:
+			vm.lei(instr)
+		case vm54.GTI:
+			vm.gti(instr)
+		case vm54.GEI:
+			vm.gei(instr)
+		case vm54.TEST:
+			vm.test(instr)
+		case vm54.TESTSET:
+			vm.testset(instr)
+		case vm54.CALL:
+			vm.call(instr)
+		case vm54.TAILCALL:
+			vm.tailcall(instr)
+			code, vm.k, vm.base = fr.closure.binary.Code, fr.closure.proto.consts, fr.base
+		case vm54.RETURN:
+			vm.returns(instr)
+			return
+		case vm54.RETURN0:
+			vm.return0(instr)
+			return
+		case vm54.RETURN1:
+			vm.return1(instr)
+			return
+		case vm54.FORLOOP:
+			vm.forloop(instr)
+		case vm54.FORPREP:
+			vm.forprep(instr)
+		case vm54.TFORPREP:
+			vm.tforprep(instr)
+		case vm54.TFORCALL:
+			vm.tforcall(instr)
+		case vm54.TFORLOOP:
+			vm.tforloop(instr)
+		case vm54.SETLIST:
+			vm.setlist(instr)
+		case vm54.CLOSURE:
+			vm.closure(instr)
+		case vm54.VARARG:
+			vm.vararg(instr)
+		case vm54.VARARGPREP:
+			vm.varargprep(instr)
+		case vm54.EXTRAARG:
+			vm.extraarg(instr)
+		}
+	}
+}
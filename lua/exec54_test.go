@@ -0,0 +1,66 @@
+package lua
+
+import "testing"
+
+func TestExecute54(t *testing.T) {
+	var tests = []struct {
+		source string
+		result string
+	}{
+		{"local s = 0; for i = 10, 1, -3 do s = s + i end; return s", "22"},
+		{"local n = 0; for i = math.maxinteger - 2, math.maxinteger do n = n + 1 end; return n", "3"},
+		{"local s = 0; for i = 1, 2, 0.5 do s = s + i end; return s", "4.5"},
+		{"local x <const> = 42; return x // 5 + (x & 3) + (x >> 1)", "31"},
+		{"local t = setmetatable({}, {__add = function(a, b) return 'add' end}); return t + 1", "add"},
+		{"local function f(...) local a, b = ... return b end; return f(1, 2, 3)", "2"},
+		{"local fs = {}; for i = 1, 3 do fs[i] = function() return i end end; return fs[1]() + fs[3]()", "4"},
+		{`local log = ""
+		  local mt = {__close = function(o, e) log = log .. o.name end}
+		  do
+		    local a <close> = setmetatable({name = "a"}, mt)
+		    local b <close> = setmetatable({name = "b"}, mt)
+		  end
+		  return log`, "ba"},
+		{`local s = 0
+		  for k = 1, 10 do if k % 2 == 0 then goto continue end s = s + k ::continue:: end
+		  return s`, "25"},
+	}
+	for _, test := range tests {
+		state := NewState(WithVersion(V54))
+		state.PushClosure(func(state *State) int {
+			state.SetTop(2)
+			state.SetMetaTableAt(1)
+			return 1
+		}, 0)
+		state.SetGlobal("setmetatable")
+		state.NewTableSize(0, 1)
+		state.Push(int64(9223372036854775807))
+		state.SetField(-2, "maxinteger")
+		state.SetGlobal("math")
+		if err := state.LoadText(test.source); err != nil {
+			t.Errorf("load %q: %v", test.source, err)
+			continue
+		}
+		if err := state.PCall(0, 1, 0); err != nil {
+			t.Errorf("exec %q: %v", test.source, err)
+			continue
+		}
+		if got := state.ToString(-1); got != test.result {
+			t.Errorf("exec %q: got %s, want %s", test.source, got, test.result)
+		}
+	}
+}
+
+func TestLoadVersionMismatch(t *testing.T) {
+	state := NewState(WithVersion(V54))
+	if err := state.LoadText("return 1"); err != nil {
+		t.Fatal(err)
+	}
+	chunk := state.Dump(false)
+	if err := NewState().LoadText(string(chunk)); err == nil {
+		t.Errorf("loaded a 5.4 binary chunk in a 5.3 state")
+	}
+	if err := NewState(WithVersion(V54)).LoadText(string(chunk)); err != nil {
+		t.Errorf("load 5.4 binary chunk: %v", err)
+	}
+}
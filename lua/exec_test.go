@@ -0,0 +1,515 @@
+package lua
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"math"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestOptimizer(t *testing.T) {
+	var tests = []string{
+		"local a; a = 1 + 2; local b, c; b = a * 2; return b",
+		"local s = 0; for i = 1, 10 do if i % 2 == 0 then s = s + i else s = s - 1 end end; return s",
+		"local n, s = 0, 0; while n < 10 do n = n + 1; if n > 5 then break end; s = s + n end; return s",
+		"local x = 0; repeat local y; x = x + 1; y = x until x >= 3; return x",
+		"local a, b = 1, nil; local c = a and b or 3; return c",
+		"local x = 1; local f = function() return x end; x = nil; return f()",
+		"local fs = {}; for i = 1, 3 do local v; v = i * 2; fs[i] = function() return v end end; return fs[1]() + fs[3]()",
+		"local s = 0; for k = 1, 10 do if k % 3 == 0 then goto continue end s = s + k ::continue:: end; return s",
+		"local function f(n) if n == 0 then return 0 end return n + f(n - 1) end; return f(10)",
+		"local t = {}; for i = 1, 4 do t[i] = i < 3 end; return tostring(t[2]) .. tostring(t[4])",
+	}
+	for _, source := range tests {
+		var results [2]string
+		for i, optimize := range []bool{false, true} {
+			state := newTestState(WithOptimizer(optimize))
+			if err := run(t, state, source, 1); err != nil {
+				t.Errorf("exec %q (optimize = %t): %v", source, optimize, err)
+				continue
+			}
+			results[i] = state.ToString(-1)
+		}
+		if results[0] != results[1] {
+			t.Errorf("exec %q: got %s optimized, want %s", source, results[1], results[0])
+		}
+	}
+}
+
+func TestTailCall(t *testing.T) {
+	var tests = []struct {
+		source string
+		result string
+	}{
+		{"local function f(n, acc) if n == 0 then return acc end return f(n - 1, acc + 1) end; return f(1000000, 0)", "1000000"},
+		{"local even, odd; function even(n) if n == 0 then return 'even' end return odd(n - 1) end; function odd(n) if n == 0 then return 'odd' end return even(n - 1) end; return odd(1000001)", "even"},
+		{"local function f(...) local n = select('#', ...); if n >= 100 then return n end return f(n, ...) end; return f()", "100"},
+		{"local t = setmetatable({}, {__call = function(self, n) if n == 0 then return 'done' end return self(n - 1) end}); return t(1000000)", "done"},
+		{"local function f(n) if n == 0 then return traceback() end return f(n - 1) end; local s = f(10); return s", "(...tail calls...)"},
+	}
+	for _, version := range []LuaVersion{V53, V54} {
+		for _, test := range tests {
+			state := newTestState(WithVersion(version))
+			if err := run(t, state, test.source, 1); err != nil {
+				t.Errorf("exec %q (%v): %v", test.source, version, err)
+				continue
+			}
+			if got := state.ToStringMeta(-1); !strings.Contains(got, test.result) {
+				t.Errorf("exec %q (%v): got %s, want %s", test.source, version, got, test.result)
+			}
+		}
+	}
+}
+
+func TestNumbers(t *testing.T) {
+	var tests = []string{
+		// integers and floats are compared exactly
+		"local i = 9007199254740993; return i > 2^53 and 2^53 < i and i ~= 2^53 and i - 1 == 2^53",
+		"local max = 9223372036854775807; return max < 2^63 and max + 0.0 == 2^63 and not (max == 2^63) and 2^63 > max",
+		"local min = -9223372036854775807 - 1; return min == -2^63 and min <= -2^63 and not (min < -2^63) and -2^63 - 2^11 < min",
+		"return 1 < 1/0 and -1/0 < -9223372036854775807 and 3 == 3.0 and 3 < 3.5 and -3 > -3.5 and not (3 <= 2.5)",
+		"local nan = 0/0; return not (1 < nan or 1 <= nan or nan < 1 or nan <= 1 or nan == nan or 1 == nan) and nan ~= nan",
+		// arithmetic keeps integers and floats apart
+		"local max = 9223372036854775807; return max + 1 == -max - 1 and max * 2 == -2 and 7 // 2 == 3 and 7 % -3 == -2",
+		"local s = 0; for i = 1, 3, 0.5 do s = s + i end; return s == 10 and 10 // 0.0 == 1/0",
+	}
+	for _, version := range []LuaVersion{V53, V54} {
+		for _, source := range tests {
+			state := newTestState(WithVersion(version))
+			if err := run(t, state, source, 1); err != nil {
+				t.Errorf("exec %q (%v): %v", source, version, err)
+				continue
+			}
+			if !state.ToBool(-1) {
+				t.Errorf("exec %q (%v): got false, want true", source, version)
+			}
+		}
+		// Numbers are not boxed: loops allocate no more than an empty call.
+		var allocs [2]float64
+		for i, source := range []string{
+			"local s = 0",
+			"local s = 0; for i = 1, 1000 do s = s + i * 2 - i // 3 end; local f = 0.5; for i = 1, 1000 do f = f * 1.5 + i end; return s < f",
+		} {
+			state := NewState(WithVersion(version))
+			if err := state.LoadText(source); err != nil {
+				t.Fatalf("load %q: %v", source, err)
+			}
+			allocs[i] = testing.AllocsPerRun(10, func() {
+				state.PushIndex(-1)
+				state.Call(0, 0)
+			})
+		}
+		if allocs[1] > allocs[0] {
+			t.Errorf("loops (%v): got %v allocations, want %v", version, allocs[1], allocs[0])
+		}
+	}
+}
+
+func TestContext(t *testing.T) {
+	var tests = []string{
+		"while true do end",
+		"local function f() return f() end; return f()",
+		"local function f() f() end; while true do pcall(f) end",
+		"while true do pcall(function() while true do end end) end",
+		"while true do sleep() end",
+	}
+	for _, version := range []LuaVersion{V53, V54} {
+		for _, source := range tests {
+			state := newTestState(WithVersion(version))
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+			err := state.ExecContext(ctx, "=test", source, TextMode)
+			cancel()
+			if _, ok := err.(*ContextError); !ok || !errors.Is(err, context.DeadlineExceeded) {
+				t.Errorf("exec %q (%v): got %v, want %v", source, version, err, context.DeadlineExceeded)
+			}
+			if state.Context() != context.Background() {
+				t.Errorf("exec %q (%v): context not reset", source, version)
+			}
+		}
+	}
+}
+
+func TestBudget(t *testing.T) {
+	var tests = []struct {
+		source string
+		limit  int64
+		err    bool
+	}{
+		{"local s = 0; for i = 1, 100 do s = s + i end; return s", 0, false},
+		{"local s = 0; for i = 1, 100 do s = s + i end; return s", 100, true},
+		{"while true do end", 1000, true},
+		{"while true do pcall(function() while true do end end) end", 1000, true},
+		{"local function f() return f() end; return f()", 1000, true},
+		{"charge(100); charge(100)", 150, true},
+		{"charge(100); charge(100)", 250, false},
+	}
+	for _, version := range []LuaVersion{V53, V54} {
+		for _, test := range tests {
+			state := newTestState(WithVersion(version), WithInstructionLimit(test.limit))
+			err := run(t, state, test.source, 0)
+			if _, ok := err.(*BudgetError); ok != test.err || (!ok && err != nil) {
+				t.Errorf("exec %q (%v, limit = %d): got %v", test.source, version, test.limit, err)
+			}
+			if test.err && state.Used() <= test.limit {
+				t.Errorf("exec %q (%v): used %d, want > %d", test.source, version, state.Used(), test.limit)
+			}
+		}
+	}
+
+	// The count is deterministic: a script runs within the exact budget it
+	// used, and not within one less; a negative budget removes the limit.
+	const source = "local t = {}; for i = 1, 50 do t[i] = i * 2 end; local function f(n) if n > 0 then return f(n - 1) end end; f(30)"
+	exec := func(state *State) (int64, error) {
+		used := state.Used()
+		err := run(t, state, source, 0)
+		return state.Used() - used, err
+	}
+	state := newTestState()
+	cost, err := exec(state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range []struct {
+		budget int64
+		err    bool
+	}{
+		{cost, false},
+		{cost - 1, true},
+		{-1, false},
+	} {
+		state.SetBudget(test.budget)
+		used, err := exec(state)
+		if _, ok := err.(*BudgetError); ok != test.err {
+			t.Errorf("budget %d: got %v", test.budget, err)
+		}
+		if !test.err && used != cost {
+			t.Errorf("budget %d: used %d, want %d", test.budget, used, cost)
+		}
+	}
+}
+
+func TestMemory(t *testing.T) {
+	var tests = []struct {
+		source string
+		err    bool
+	}{
+		{"local t = {}; for i = 1, 1e4 do t[i] = {} end", false},
+		{"local t = {}; for i = 1, 1e8 do t[i] = i end", true},
+		{"local t = {}; for i = 1, 1e8 do t[i] = function() return i end end", true},
+		{"local t = {}; for i = 1, 1e8 do t['k' .. i] = i end", true},
+		{"local s = 'x'; while true do s = s .. s end", true},
+		// the garbage is counted out before raising an error
+		{"for i = 1, 1e5 do local t = {1, 2, 3, i} end", false},
+		{"for i = 1, 100 do local s = ''; for j = 1, 1000 do s = s .. 'x' end end", false},
+	}
+	for _, version := range []LuaVersion{V53, V54} {
+		for _, test := range tests {
+			state := newTestState(WithVersion(version), WithMemoryLimit(4<<20))
+			if err := run(t, state, test.source, 0); (err == ErrMemory) != test.err || (err != nil && err != ErrMemory) {
+				t.Errorf("exec %q (%v): got %v", test.source, version, err)
+			}
+			if state.CollectGarbage(); state.MemoryUsage() > 1<<20 {
+				t.Errorf("exec %q (%v): %d bytes in use after collection", test.source, version, state.MemoryUsage())
+			}
+		}
+	}
+
+	// The usage grows with the objects reachable, and falls back when they
+	// are collected.
+	state := NewState()
+	usage := func(source string) int64 {
+		if err := state.ExecText(source); err != nil {
+			t.Fatalf("exec %q: %v", source, err)
+		}
+		state.CollectGarbage()
+		return state.MemoryUsage()
+	}
+	var (
+		base = usage("")
+		used = usage("t = {}; for i = 1, 1e4 do t[i] = {'x' .. i} end")
+		free = usage("t = nil")
+	)
+	if used < base+1e4*sizeTable || free > base+1024 {
+		t.Errorf("usage: got %d, %d after allocating, %d after freeing", base, used, free)
+	}
+}
+
+func TestChunkCache(t *testing.T) {
+	for _, version := range []LuaVersion{V53, V54} {
+		dir, err := ioutil.TempDir("", "golua-cache")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+
+		cache := &chunkCache{dir: dir, version: NewState(WithVersion(version)).luacVersion()}
+		exec := func(source string) string {
+			state := newTestState(WithVersion(version), WithChunkCache(dir))
+			if err := run(t, state, source, 1); err != nil {
+				t.Fatalf("%v: exec %q: %v", version, source, err)
+			}
+			return state.ToString(-1)
+		}
+		one, two := "return 'one'", "return 'two'"
+		if got := exec(one); got != "one" {
+			t.Errorf("%v: miss: got %s, want one", version, got)
+		}
+		exec(two)
+
+		// A hit must not recompile: swap the entries and check that
+		// the cached chunk is the one run.
+		data, err := ioutil.ReadFile(cache.path("?", []byte(two)))
+		if err != nil {
+			t.Fatalf("%v: no cache entry: %v", version, err)
+		}
+		if err := ioutil.WriteFile(cache.path("?", []byte(one)), data, 0644); err != nil {
+			t.Fatal(err)
+		}
+		if got := exec(one); got != "two" {
+			t.Errorf("%v: hit: got %s, want two", version, got)
+		}
+
+		// Corrupt entries are ignored and replaced.
+		for _, corrupt := range [][]byte{nil, data[:len(data)/2], append([]byte("junk"), data...)} {
+			if err := ioutil.WriteFile(cache.path("?", []byte(one)), corrupt, 0644); err != nil {
+				t.Fatal(err)
+			}
+			if got := exec(one); got != "one" {
+				t.Errorf("%v: corrupt entry %q: got %s, want one", version, corrupt, got)
+			}
+			if cache.get("?", []byte(one)) == nil {
+				t.Errorf("%v: corrupt entry %q not replaced", version, corrupt)
+			}
+		}
+	}
+}
+
+func TestThread(t *testing.T) {
+	state := NewState()
+	// produce yields its argument doubled; its continuation adds the value
+	// passed to the resume to the stack it had before the yield.
+	state.Register("produce", func(state *State) int {
+		state.Push(state.ToNumber(1) * 2)
+		return state.YieldK(1, "ctx", func(state *State, status ThreadStatus, ctx interface{}) int {
+			if status != ThreadYield || ctx != "ctx" {
+				t.Errorf("continuation: got %v, %v", status, ctx)
+			}
+			state.Push(state.ToNumber(1) + state.ToNumber(2))
+			return 1
+		})
+	})
+	state.Register("pcall", func(state *State) int {
+		return state.PCallK(state.Top()-1, MultRets, 0, nil, func(state *State, status ThreadStatus, _ interface{}) int {
+			state.Push(status != ThreadError)
+			state.Insert(1)
+			return state.Top()
+		})
+	})
+	if err := state.LoadText("local ok, v = pcall(produce, 10); return v + produce(1)"); err != nil {
+		t.Fatal(err)
+	}
+	co := state.NewThread()
+	state.Insert(-2)
+	state.XMove(co, 1)
+
+	for _, step := range []struct {
+		arg    float64
+		status ThreadStatus
+		result float64
+	}{
+		{0, ThreadYield, 20}, // produce(10) yields 20
+		{5, ThreadYield, 2},  // pcall returns 15, produce(1) yields 2
+		{100, ThreadOK, 116}, // produce returns 101
+	} {
+		nargs := 0
+		if step.arg != 0 {
+			co.Pop()
+			co.Push(step.arg)
+			nargs = 1
+		}
+		status, err := co.Resume(state, nargs)
+		if err != nil || status != step.status || co.Status() != step.status || co.Top() != 1 || co.ToNumber(1) != step.result {
+			t.Fatalf("resume %v: got %v, %v, %v, want %v, %v", step.arg, status, err, co.ToNumber(1), step.status, step.result)
+		}
+	}
+	if _, err := co.Resume(state, 0); err == nil {
+		t.Errorf("resumed a dead coroutine")
+	}
+
+	// Closing a suspended coroutine unwinds it.
+	co = state.NewThread()
+	co.Push(Func(func(state *State) int { return state.Yield(0) }))
+	if status, _ := co.Resume(state, 0); status != ThreadYield {
+		t.Fatalf("got %v, want %v", status, ThreadYield)
+	}
+	if err := co.CloseThread(); err != nil || co.Top() != 0 {
+		t.Errorf("close: %v (top = %d)", err, co.Top())
+	}
+	if _, err := co.Resume(state, 0); err == nil {
+		t.Errorf("resumed a closed coroutine")
+	}
+
+	// Errors keep their object, which need not be a string.
+	co = state.NewThread()
+	co.Push(Func(func(state *State) int {
+		state.NewTable()
+		return state.Error()
+	}))
+	status, err := co.Resume(state, 0)
+	if status != ThreadError || err == nil {
+		t.Fatalf("got %v, %v, want %v", status, err, ThreadError)
+	}
+	if co.PushError(err); co.TypeAt(-1) != TableType {
+		t.Errorf("error object: got %v, want a table", co.TypeAt(-1))
+	}
+}
+
+// testFuncs are the global functions of the test states, standing in for
+// those of the standard library (see newTestState).
+var testFuncs = map[string]Func{
+	"call": func(state *State) int {
+		state.Call(state.Top()-1, MultRets)
+		return state.Top()
+	},
+	"charge": func(state *State) int {
+		state.Charge(state.CheckInt(1))
+		return 0
+	},
+	"pcall": func(state *State) int {
+		if err := state.PCall(state.Top()-1, MultRets, 0); err != nil {
+			state.Push(false)
+			state.PushError(err)
+			return 2
+		}
+		state.Push(true)
+		state.Insert(1)
+		return state.Top()
+	},
+	"select": func(state *State) int {
+		n := state.Top()
+		if state.TypeAt(1) == StringType { // '#'
+			state.Push(n - 1)
+			return 1
+		}
+		return max(n-int(state.CheckInt(1)), 0)
+	},
+	"setmetatable": func(state *State) int {
+		state.SetTop(2)
+		state.SetMetaTableAt(1)
+		return 1
+	},
+	"sleep": func(state *State) int {
+		<-state.Context().Done()
+		return 0
+	},
+	"tostring": func(state *State) int {
+		state.Push(state.ToStringMeta(1))
+		return 1
+	},
+	"traceback": func(state *State) int {
+		state.Traceback(state, "", 1)
+		return 1
+	},
+	"unpack": func(state *State) int {
+		n := int(state.CheckInt(1))
+		if !state.CheckStack(n) {
+			state.Errorf("too many results")
+		}
+		for i := 0; i < n; i++ {
+			state.Push(i)
+		}
+		return n
+	},
+}
+
+// newTestState returns a new state with the test functions as globals (see
+// testFuncs).
+func newTestState(opts ...Option) *State {
+	state := NewState(opts...)
+	for name, fn := range testFuncs {
+		state.Register(name, fn)
+	}
+	return state
+}
+
+// run loads source in state, failing the test if it does not load, and calls
+// it in protected mode, keeping rets results.
+func run(t *testing.T, state *State, source string, rets int) error {
+	t.Helper()
+	if err := state.LoadText(source); err != nil {
+		t.Fatalf("load %q: %v", source, err)
+	}
+	return state.PCall(0, rets, 0)
+}
+
+func BenchmarkExec(b *testing.B) {
+	var benchmarks = []struct {
+		name   string
+		source string
+	}{
+		{"fib", "local function fib(n) if n < 2 then return n end return fib(n - 1) + fib(n - 2) end; fib(20)"},
+		{"nbody", `
+			local bodies = {
+				{x = 0, y = 0, z = 0, vx = 0, vy = 0, vz = 0, mass = 39.47},
+				{x = 4.84, y = -1.16, z = -0.10, vx = 0.60, vy = 2.81, vz = -0.02, mass = 0.037},
+				{x = 8.34, y = 4.12, z = -0.40, vx = -1.01, vy = 1.82, vz = 0.008, mass = 0.011},
+				{x = 12.89, y = -15.11, z = -0.22, vx = 1.08, vy = 0.86, vz = -0.01, mass = 0.0017},
+				{x = 15.37, y = -25.91, z = 0.17, vx = 0.97, vy = 0.59, vz = -0.03, mass = 0.002},
+			}
+			local function advance(dt)
+				local n = #bodies
+				for i = 1, n do
+					local bi = bodies[i]
+					for j = i + 1, n do
+						local bj = bodies[j]
+						local dx, dy, dz = bi.x - bj.x, bi.y - bj.y, bi.z - bj.z
+						local d2 = dx * dx + dy * dy + dz * dz
+						local mag = dt / (d2 * sqrt(d2))
+						bi.vx, bi.vy, bi.vz = bi.vx - dx * bj.mass * mag, bi.vy - dy * bj.mass * mag, bi.vz - dz * bj.mass * mag
+						bj.vx, bj.vy, bj.vz = bj.vx + dx * bi.mass * mag, bj.vy + dy * bi.mass * mag, bj.vz + dz * bi.mass * mag
+					end
+				end
+				for i = 1, n do
+					local bi = bodies[i]
+					bi.x, bi.y, bi.z = bi.x + dt * bi.vx, bi.y + dt * bi.vy, bi.z + dt * bi.vz
+				end
+			end
+			for i = 1, 100 do advance(0.01) end`},
+		{"table", `
+			local t = {}
+			for i = 1, 1000 do t[i] = {i, i * 2, key = i} end
+			local s = 0
+			for i = 1, #t do s = s + t[i][1] + t[i].key; t[i] = nil end`},
+		{"string", `
+			local parts = {}
+			for i = 1, 200 do
+				local s = ""
+				for j = 1, 5 do s = s .. j .. "," end
+				parts[#parts + 1] = s
+			end`},
+	}
+	for _, version := range []LuaVersion{V53, V54} {
+		for _, bench := range benchmarks {
+			b.Run(version.String()+"/"+bench.name, func(b *testing.B) {
+				state := NewState(WithVersion(version))
+				state.Register("sqrt", func(state *State) int {
+					state.Push(math.Sqrt(state.ToNumber(1)))
+					return 1
+				})
+				if err := state.LoadText(bench.source); err != nil {
+					b.Fatal(err)
+				}
+				b.ReportAllocs()
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					state.PushIndex(-1)
+					state.Call(0, 0)
+				}
+			})
+		}
+	}
+}
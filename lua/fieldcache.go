@@ -0,0 +1,130 @@
+package lua
+
+// maxCacheLinks is the maximum number of tables kept track of by an inline
+// cache: the metatables and __index tables of up to 3 levels of inheritance.
+const maxCacheLinks = 6
+
+// indexKey is the key of the __index metamethod in metatables.
+var indexKey Value = String(metaIndex.ID())
+
+// fieldCache is the inline cache of an instruction reading a field of a value
+// with a constant string key (e.g. GETTABUP, GETFIELD and SELF).
+//
+// It holds the value last read, with the tables it was found through and their
+// versions (see table.version): either the table holding the field, or the
+// metatable of the value read followed by the tables of its __index chain, up
+// to the one holding the field. The value is valid for as long as none of the
+// tables has changed, and the value read is the same table; or has the same
+// metatable and no such field of its own.
+type fieldCache struct {
+	links [maxCacheLinks]cacheLink
+	n     int  // # of links; 0 if the cache is empty
+	own   bool // field of the table read (links[0])
+	value value
+}
+
+// cacheLink is a table of an inline cache, with its version when the field
+// was read.
+type cacheLink struct {
+	table   *table
+	version uint64
+}
+
+// getfield returns the value of the field key, a constant string, of obj, as
+// gettable does, using the inline cache c of the instruction reading it.
+func (state *State) getfield(obj, key value, c *fieldCache) value {
+	if v, ok := c.get(state, obj, key); ok {
+		return v
+	}
+	if v, ok := c.fill(state, obj, key); ok {
+		return v
+	}
+	return state.gettable(obj, key, false)
+}
+
+// get returns the cached value of the field key of obj if it is valid, or the
+// value of its own field if obj is a table holding it.
+func (c *fieldCache) get(state *State, obj, key value) (value, bool) {
+	if c.n == 0 || obj.v == nil {
+		return none, false
+	}
+	if c.own {
+		if t, ok := obj.v.(*table); ok && t == c.links[0].table && t.version == c.links[0].version {
+			return c.value, true
+		}
+		return none, false
+	}
+	var meta *table
+	switch obj := obj.v.(type) {
+	case *table:
+		if obj.weak != nil {
+			return none, false
+		}
+		if v, ok := obj.field(key.v); ok {
+			return v, true
+		}
+		meta = obj.meta
+	case *Object:
+		meta = obj.meta
+	default:
+		meta = state.global.builtins[obj.Type()]
+	}
+	if meta != c.links[0].table {
+		return none, false
+	}
+	for _, link := range c.links[:c.n] {
+		if link.table.version != link.version {
+			return none, false
+		}
+	}
+	return c.value, true
+}
+
+// fill reads the field key of obj through tables only, and caches the value
+// found. It returns false, leaving the cache empty, if the field is not found
+// or an __index metamethod is not a table.
+func (c *fieldCache) fill(state *State, obj, key value) (value, bool) {
+	var meta *table
+	c.n, c.own = 0, false
+	switch obj := obj.v.(type) {
+	case nil:
+		return none, false
+	case *table:
+		if obj.weak != nil {
+			return none, false
+		}
+		if v, ok := obj.field(key.v); ok {
+			c.own = true
+			c.link(obj)
+			c.value = v
+			return v, true
+		}
+		meta = obj.meta
+	case *Object:
+		meta = obj.meta
+	default:
+		meta = state.global.builtins[obj.Type()]
+	}
+	for meta != nil && c.n+2 <= maxCacheLinks {
+		v, _ := meta.field(indexKey)
+		index, ok := v.v.(*table)
+		if !ok || meta.weak != nil || index.weak != nil {
+			break
+		}
+		c.link(meta)
+		c.link(index)
+		if v, ok := index.field(key.v); ok {
+			c.value = v
+			return v, true
+		}
+		meta = index.meta
+	}
+	c.n = 0
+	return none, false
+}
+
+// link appends a table to the cache's links.
+func (c *fieldCache) link(t *table) {
+	c.links[c.n] = cacheLink{table: t, version: t.version}
+	c.n++
+}
@@ -0,0 +1,128 @@
+package lua
+
+import "testing"
+
+func TestFieldCache(t *testing.T) {
+	var tests = []struct {
+		source string
+		result string
+	}{
+		// own fields
+		{"local t = {x = 1}; local s = 0; for i = 1, 3 do s = s + t.x; t.x = t.x * 10 end; return s", "111"},
+		{"local t = {x = 1}; local function f() return t.x end; local a = f(); t.x = nil; return a == 1 and f() == nil", "true"},
+		{"local t = {x = 1}; local function f() return t.x end; local a = f(); rawset(t, 'x', 2); return a + f()", "3"},
+		{"local ts = {{x = 1}, {x = 2}, {y = 3}}; local s = ''; for _, t in ipairs(ts) do s = s .. (t.x or '-') end; return s", "12-"},
+		// inherited fields
+		{"local B = {x = 1}; B.__index = B; local o = setmetatable({}, B); local function f() return o.x end; local a = f(); B.x = 2; return a + f()", "3"},
+		{"local B = {x = 1}; B.__index = B; local o = setmetatable({}, B); local function f() return o.x end; local a = f(); o.x = 5; return a + f()", "6"},
+		{"local B = {x = 1}; B.__index = B; local o = setmetatable({}, B); local function f() return o.x end; local a = f(); B.__index = {x = 7}; return a + f()", "8"},
+		{"local B = {x = 1}; B.__index = B; local o = setmetatable({}, B); local function f() return o.x end; local a = f(); setmetatable(o, {__index = {x = 9}}); return a + f()", "10"},
+		{"local B = {x = 1}; B.__index = B; local o = setmetatable({}, B); local function f() return o.x end; local a = f(); B.__index = function() return 3 end; return a + f()", "4"},
+		{"local A = {x = 1}; A.__index = A; local B = setmetatable({}, A); B.__index = B; local o = setmetatable({}, B); local function f() return o.x end; local a = f(); setmetatable(B, {__index = {x = 4}}); return a + f()", "5"},
+		{"local A = {x = 1}; A.__index = A; local B = setmetatable({}, A); B.__index = B; local o = setmetatable({}, B); local function f() return o.x end; local a = f(); B.x = 2; return a + f()", "3"},
+		{"local B = {x = 1}; B.__index = B; local C = {x = 2}; C.__index = C; local os = {setmetatable({}, B), setmetatable({}, C), {x = 3}, {}}; local s = ''; for _, o in ipairs(os) do s = s .. (o.x or '-') end; return s", "123-"},
+		// methods
+		{"local P = {}; P.__index = P; function P:get() return self.x end; local p, q = setmetatable({x = 1}, P), setmetatable({x = 2}, P); return p:get() + q:get()", "3"},
+		{"local P = {}; P.__index = P; function P:get() return 1 end; local p = setmetatable({}, P); local function f() return p:get() end; local a = f(); function P:get() return 2 end; return a + f()", "3"},
+		{"strings.up = function() return 'A' end; local function f(s) return s:up() end; local a = f('x'); strings.up = function() return 'B' end; return a .. f('y')", "AB"},
+		// missing fields
+		{"local B = {}; B.__index = B; local o = setmetatable({}, B); local function f() return o.x end; local a = f(); B.x = 1; return a == nil and f() == 1", "true"},
+		{"local function f() return g end; local a = f(); g = 1; return a == nil and f() == 1", "true"},
+	}
+	for _, version := range []LuaVersion{V53, V54} {
+		for _, test := range tests {
+			state := NewState(WithVersion(version))
+			state.Register("setmetatable", func(state *State) int {
+				state.SetMetaTableAt(1)
+				return 1
+			})
+			state.Register("rawset", func(state *State) int {
+				state.RawSet(1)
+				return 0
+			})
+			state.Register("ipairs", func(state *State) int {
+				state.Push(func(state *State) int {
+					i := state.ToInt(2) + 1
+					state.Push(i)
+					if t := state.RawGetIndex(1, int(i)); t == NilType || t == NoneType {
+						return 1
+					}
+					return 2
+				})
+				state.PushIndex(1)
+				state.Push(0)
+				return 3
+			})
+			state.NewTable()
+			state.PushIndex(-1)
+			state.SetGlobal("strings")
+			state.Push("")
+			state.NewTable()
+			state.PushIndex(-3)
+			state.SetField(-2, "__index")
+			state.SetMetaTableAt(-2)
+			state.PopN(2)
+			if err := state.LoadText(test.source); err != nil {
+				t.Fatalf("load %q: %v", test.source, err)
+			}
+			if err := state.PCall(0, 1, 0); err != nil {
+				t.Errorf("exec %q (%v): %v", test.source, version, err)
+				continue
+			}
+			if got := state.ToStringMeta(-1); got != test.result {
+				t.Errorf("exec %q (%v): got %s, want %s", test.source, version, got, test.result)
+			}
+		}
+	}
+}
+
+func BenchmarkField(b *testing.B) {
+	var benchmarks = []struct {
+		name   string
+		source string
+	}{
+		{"global", "local x; for i = 1, 1000 do x = print end"},
+		{"field", "local t = {x = 1, y = 2}; local s = 0; for i = 1, 1000 do s = s + t.x + t.y end"},
+		{"module", "local s = 0; for i = 1, 1000 do s = s + math.pi end"},
+		{"method", `
+			local Point = {}
+			Point.__index = Point
+			function Point:get() return self.x end
+			local p = setmetatable({x = 1}, Point)
+			for i = 1, 1000 do p:get() end`},
+		{"inherited", `
+			local Base = {}
+			Base.__index = Base
+			function Base:get() return self.x end
+			local Derived = setmetatable({}, Base)
+			Derived.__index = Derived
+			local objs = {}
+			for i = 1, 10 do objs[i] = setmetatable({x = i}, Derived) end
+			for i = 1, 1000 do objs[i % 10 + 1]:get() end`},
+	}
+	for _, version := range []LuaVersion{V53, V54} {
+		for _, bench := range benchmarks {
+			b.Run(version.String()+"/"+bench.name, func(b *testing.B) {
+				state := NewState(WithVersion(version))
+				state.Register("print", func(state *State) int { return 0 })
+				state.Register("setmetatable", func(state *State) int {
+					state.SetMetaTableAt(1)
+					return 1
+				})
+				state.NewTable()
+				state.Push(3.14)
+				state.SetField(-2, "pi")
+				state.SetGlobal("math")
+				if err := state.LoadText(bench.source); err != nil {
+					b.Fatal(err)
+				}
+				b.ReportAllocs()
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					state.PushIndex(-1)
+					state.Call(0, 0)
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,135 @@
+package lua
+
+import "fmt"
+
+// WarnFunc is the type of warning functions, called by the state to emit
+// warnings, such as the errors raised by __gc metamethods, and by the warn
+// function of the base library. A warning may be emitted in pieces: tocont
+// is true for every piece but the last one.
+type WarnFunc func(msg string, tocont bool)
+
+// SetWarnFunc sets the warning function of the state and returns the old one;
+// a nil fn discards the warnings.
+//
+// See https://www.lua.org/manual/5.4/manual.html#lua_setwarnf
+func (state *State) SetWarnFunc(fn WarnFunc) WarnFunc {
+	prevFn := state.global.warnFn
+	state.global.warnFn = fn
+	return prevFn
+}
+
+// Warn emits a warning with the message msg, through the warning function of
+// the state; tocont is true if the message is to be continued by the message
+// of the next call.
+//
+// See https://www.lua.org/manual/5.4/manual.html#lua_warning
+func (state *State) Warn(msg string, tocont bool) {
+	if fn := state.global.warnFn; fn != nil {
+		fn(msg, tocont)
+	}
+}
+
+// checkFinalizer marks the table or userdata obj for finalization if it is not
+// yet and its metatable, just set, has a __gc field; fin is its mark. As in Lua
+// 5.3, a __gc field added to the metatable afterwards does not mark it, and no
+// object is marked once the state is closed.
+func (state *State) checkFinalizer(obj Value, fin *bool, meta *table) {
+	g := state.global
+	if *fin || g.closed || meta == nil || meta.getStr(metaGC.ID()) == None {
+		return
+	}
+	*fin = true
+	g.finobj = append(g.finobj, obj)
+}
+
+// finMark returns the mark for finalization of a table or userdata.
+func finMark(obj Value) *bool {
+	switch obj := obj.(type) {
+	case *table:
+		return &obj.fin
+	case *Object:
+		return &obj.fin
+	}
+	return nil
+}
+
+// separate moves the objects marked for finalization which are not reachable,
+// i.e. not seen, to the objects to be finalized, and returns them. Their __gc
+// metamethods are called at the next check of the executors, or when garbage
+// is collected (see callFinalizers).
+func (g *global) separate(seen map[Value]bool) []Value {
+	var (
+		n      = len(g.tobefnz)
+		finobj = g.finobj[:0]
+	)
+	for _, obj := range g.finobj {
+		if seen[obj] {
+			finobj = append(finobj, obj)
+		} else {
+			g.tobefnz = append(g.tobefnz, obj)
+		}
+	}
+	clear(g.finobj[len(finobj):])
+	g.finobj = finobj
+	if len(g.tobefnz) > n {
+		g.next = g.used
+	}
+	return g.tobefnz[n:]
+}
+
+// callFinalizers calls the __gc metamethods of the objects to be finalized,
+// in the reverse order of their marking, unless they are already running,
+// and releases the coroutines among them (see release). The metamethods run
+// on the main thread only, and cannot yield; the errors they raise, such as
+// the attempts to yield, are emitted as warnings. On another thread, they are
+// left to run when the main thread resumes (see Resume).
+func (state *State) callFinalizers() {
+	g := state.global
+	if g.infin || state != g.thread0 {
+		return
+	}
+	g.infin = true
+	state.nny++
+	defer func() {
+		g.infin = false
+		state.nny--
+	}()
+
+	for n := len(g.tobefnz); n > 0; n = len(g.tobefnz) {
+		obj := g.tobefnz[n-1]
+		g.tobefnz[n-1] = nil
+		g.tobefnz = g.tobefnz[:n-1]
+		if th, ok := obj.(*thread); ok { // coroutine no longer reachable
+			th.release()
+			continue
+		}
+		*finMark(obj) = false
+
+		gc := state.metafield(obj, metaGC.ID())
+		if IsNone(gc) {
+			continue
+		}
+		state.frame().push(gc)
+		state.frame().push(obj)
+		if err := state.PCall(1, 0, 0); err != nil {
+			state.Warn(fmt.Sprintf("error in %s metamethod (%v)", metaGC.ID(), err), false)
+		}
+	}
+}
+
+// closeFinalizers calls the __gc metamethods of the objects to be finalized,
+// then of all the objects marked for finalization, as the state is closed;
+// then it releases the suspended coroutines.
+func (state *State) closeFinalizers() {
+	g := state.global
+	state.callFinalizers()
+	g.closed = true
+	g.tobefnz = append(g.tobefnz, g.finobj...)
+	g.finobj = nil
+	state.callFinalizers()
+	for _, ls := range append([]*State(nil), g.threads...) {
+		if ls.co.from == nil { // suspended
+			ls.release()
+		}
+	}
+}
@@ -0,0 +1,41 @@
+// Package format implements the canonical formatting of Lua source text,
+// as done by "glua fmt".
+package format
+
+import (
+	"bytes"
+
+	"github.com/Azure/golua/lua/ast"
+)
+
+// Source formats the Lua 5.3 source text src of the chunk named chunkname
+// in canonical style and returns the result or the syntax error, if any.
+//
+// Statements are printed one per line and indented with tabs, operators
+// and commas are surrounded by single spaces, table constructors written
+// over several lines get one field per line and comments are kept in
+// place. A first line starting with '#' (as in "#!/usr/bin/env glua") is
+// kept as is.
+func Source(chunkname string, src []byte) ([]byte, error) {
+	var head []byte
+	if bytes.HasPrefix(src, []byte("#")) {
+		end := bytes.IndexByte(src, '\n')
+		if end < 0 {
+			end = len(src)
+		}
+		head, src = src[:end], src[end:] // keep the newline for line numbers
+	}
+	chunk, err := ast.Parse(chunkname, src)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	if head != nil {
+		buf.Write(head)
+		buf.WriteByte('\n')
+	}
+	if err := ast.Fprint(&buf, chunk); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
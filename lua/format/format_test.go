@@ -0,0 +1,45 @@
+package format
+
+import "testing"
+
+func TestSource(t *testing.T) {
+	var tests = []struct {
+		src  string
+		want string
+	}{
+		{"local   x=1+2*y ; f( x,y )", "local x = 1 + 2 * y\nf(x, y)\n"},
+		{"if a then b() elseif c then else d=- -e end", "if a then\n\tb()\nelseif c then else\n\td = - -e\nend\n"},
+		{"local t = {1,2;3}", "local t = {1, 2, 3}\n"},
+		{
+			"local t = { -- numbers\n  one=1, -- first\n  -- second\n  two = {2},\n  f = function() return 3 end }",
+			"local t = { -- numbers\n\tone = 1, -- first\n\t-- second\n\ttwo = {2},\n\tf = function()\n\t\treturn 3\n\tend,\n}\n",
+		},
+		{
+			"#!/usr/bin/env glua\nfunction m.f(a, ...) -- m.f\n  -- body\n  return ...\nend\n\n\n-- end",
+			"#!/usr/bin/env glua\nfunction m.f(a, ...) -- m.f\n\t-- body\n\treturn ...\nend\n\n-- end\n",
+		},
+		// blank lines after the leading comments are kept
+		{"-- header\n-- comment\n\nlocal x = 1\n-- a\n\n-- b\nx = 2", "-- header\n-- comment\n\nlocal x = 1\n-- a\n\n-- b\nx = 2\n"},
+		// long comments are kept in place, short ones end the line
+		{"local x = f(a, --[[ inline ]] b)", "local x = f(a, --[[ inline ]] b)\n"},
+		{"y = 1 --[[ op ]] + --[==[ y ]==] 2 .. t[k --[[ k ]]] .. {--[[ 1 ]] 1}", "y = 1 --[[ op ]] + --[==[ y ]==] 2 .. t[k --[[ k ]]] .. {--[[ 1 ]] 1}\n"},
+		{"f(a, --[ short\n b --[[ b ]])", "f(a, b --[[ b ]]) --[ short\n"},
+	}
+	for _, test := range tests {
+		got, err := Source("=test", []byte(test.src))
+		if err != nil {
+			t.Errorf("format %q: %v", test.src, err)
+			continue
+		}
+		if string(got) != test.want {
+			t.Errorf("format %q:\ngot:\n%s\nwant:\n%s", test.src, got, test.want)
+			continue
+		}
+		if again, err := Source("=test", got); err != nil || string(again) != string(got) {
+			t.Errorf("format %q: formatting is not idempotent", test.src)
+		}
+	}
+	if _, err := Source("=test", []byte("x = = 1")); err == nil {
+		t.Error("expected syntax error")
+	}
+}
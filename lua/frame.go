@@ -16,48 +16,105 @@ const (
 	// callStatusFinalizer              // call is running a finalizer
 )
 
-type (
-	// CallInfo holds information about a call.
-	CallInfo struct {
-		// prev, next *CallInfo // dynamic call link to caller and callee
-		// savedpc    int       // saved pc; return address (lua only)
-		// status     int       // call status indicating success or failure
-		// funcID     int       // function index in the stack
-		// nrets      int       // expected number of results
-		// top        int       // stack top for this function
-		// frame      *Frame    // call frame
-	}
-
-	// Frame is the context to execute a function closure.
-	Frame struct {
-		prev, next *Frame           // dynamic link caller and callee frame
-		closure    *Closure         // frame closure
-		vararg     []Value          // variable arguments
-		locals     []Value          // frame stack locals
-		state      *State           // thread state
-		depth      int              // call frame ID
-		fnID       int              // function index
-		rets       int              // # expected returns
-		pc         int              // last executed instruction pc
-		up         map[int]*upValue // map of open upvalues
-		status     callStatus       // callinfo status
-	}
-)
+// Frame is the context to execute a function closure.
+//
+// The values of all the frames of a thread live in a single stack (see
+// State.stack): the locals of a frame are the window stack[base:top] of it,
+// its varargs, if any, lie below base. The function called and its arguments
+// are left in place by the caller, which becomes the frame's locals; on return
+// the results are moved down to the slot of the function.
+//
+// The locals are read and written as Values by get, set, push and pop, which
+// box and unbox them, and as values by the executors (see reg and setReg).
+type Frame struct {
+	prev, next *Frame           // dynamic link caller and callee frame
+	closure    *Closure         // frame closure
+	state      *State           // thread state
+	base       int              // stack index of the first local
+	top        int              // stack index of the first free slot
+	vabase     int              // stack index of the first vararg
+	nvararg    int              // # of varargs
+	depth      int              // call frame ID
+	fnID       int              // function index
+	rets       int              // # expected returns
+	pc         int              // last executed instruction pc
+	up         map[int]*upValue // map of open upvalues
+	tbc        []int            // to-be-closed variables (5.4)
+	status     callStatus       // callinfo status
+}
 
 // checkstack checks that there atleast needed slots available.
 func (fr *Frame) checkstack(needed int) bool {
-	if space := cap(fr.locals) - fr.gettop(); space < needed {
-		fr.extend(needed - space)
+	if len(fr.state.stack)-fr.top < needed {
+		fr.state.grow(fr.top + needed)
 	}
 	return true
 }
 
-// extend grows the frame's locals stack by grow.
-func (fr *Frame) extend(grow int) {
-	fr.locals = append(
-		fr.locals,
-		make([]Value, grow, grow)...,
-	)[:len(fr.locals)]
+// adjust prepares the frame to execute its Lua closure, with the arguments
+// on the locals stack: params is the # of fixed real parameters from the
+// prototype, and the missing ones are set to nil; the extra arguments are
+// kept as the frame varargs if the function is vararg, by moving the fixed
+// parameters (and so the frame's base) above them.
+func (fr *Frame) adjust() {
+	var (
+		proto  = fr.closure.binary
+		params = proto.NumParams()
+	)
+	// Ensure stack has space.
+	fr.checkstack(params + proto.StackSize())
+
+	fr.nvararg = 0
+	switch {
+	case fr.gettop() < params: // # arguments < # parameters
+		for fr.gettop() < params {
+			fr.pushReg(none) // nil to top
+		}
+	case fr.gettop() > params: // # arguments > # parameters
+		if !proto.IsVararg() {
+			fr.settop(params)
+			break
+		}
+		stack := fr.state.stack
+		fr.vabase = fr.base + params
+		fr.nvararg = fr.top - fr.vabase
+		copy(stack[fr.top:], stack[fr.base:fr.vabase])
+		fr.base, fr.top = fr.top, fr.top+params
+	}
+}
+
+// ret moves the values returned by the frame, in the stack slots from
+// first up to last, onto the caller's stack, adjusted to the number of
+// results it expects.
+func (fr *Frame) ret(first, last int) {
+	if fr.up != nil { // the results may overwrite captured locals
+		fr.closeUp(0)
+	}
+	if last > fr.top {
+		fr.settop(last - fr.base)
+	}
+	var (
+		caller = fr.prev
+		stack  = fr.state.stack
+		want   = fr.rets
+	)
+	switch {
+	case want == 0:
+		return
+	case want == MultRets:
+		want = last - first
+	case want < last-first:
+		last = first + want
+	}
+	if caller.top+want > len(stack) {
+		fr.state.grow(caller.top + want)
+		stack = fr.state.stack
+	}
+	n := copy(stack[caller.top:], stack[first:last])
+	for i := caller.top + n; i < caller.top+want; i++ {
+		stack[i] = none
+	}
+	caller.top += want
 }
 
 // absindex converts the acceptable index into an equivalent
@@ -65,7 +122,7 @@ func (fr *Frame) extend(grow int) {
 // stack top).
 func (fr *Frame) absindex(index int) int {
 	// zero, positive, or pseudo index
-	if index > 0 || isPseudoIndex(index) {
+	if index >= 0 || isPseudoIndex(index) {
 		return index
 	}
 	// negative
@@ -78,21 +135,25 @@ func (fr *Frame) settop(top int) {
 	// if top = fr.absindex(top); top < 0 {
 	//     panic(runtimeErr(fmt.Errorf("stack underflow!")))
 	// }
-	switch diff := fr.gettop() - top; {
-	case diff >= 0: // new top < old top
-		for i := 0; i < diff; i++ {
-			fr.pop()
-		}
-	case diff < 0: // new top > old top
-		for i := 0; i > diff; i-- {
-			fr.push(None)
+	switch top += fr.base; {
+	case top <= fr.top: // new top < old top
+		clear(fr.state.stack[top:fr.top])
+	case top > fr.top: // new top > old top
+		fr.checkstack(top - fr.top)
+		for i := fr.top; i < top; i++ {
+			fr.state.stack[i] = none
 		}
 	}
+	fr.top = top
 }
 
+// locals returns the frame's locals stack; the slice is only valid
+// until the stack grows.
+func (fr *Frame) locals() []value { return fr.state.stack[fr.base:fr.top] }
+
 // Reverse reverses the frame's locals stack starting from the src to dst indices.
 func (fr *Frame) reverse(src, dst int) {
-	for locals := fr.locals; src < dst; {
+	for locals := fr.locals(); src < dst; {
 		locals[src], locals[dst] = locals[dst], locals[src]
 		src++
 		dst--
@@ -187,16 +248,16 @@ func (fr *Frame) callee() *Frame {
 
 // varargs returns the values in vararg upto n; if n == 0, then
 // then varargs returns all values in the expression.
-func (fr *Frame) varargs(n int) []Value {
-	// n > len(fr.vararg)
-	// n < len(fr.vararg)
-	if n <= 0 {
-		return fr.vararg
-	}
-	va := make([]Value, n)
-	for i := 0; i < min(len(fr.vararg), n); i++ {
-		va[i] = fr.vararg[i]
+func (fr *Frame) varargs(n int) []value {
+	vararg := fr.state.stack[fr.vabase : fr.vabase+fr.nvararg]
+	switch {
+	case n <= 0:
+		return vararg
+	case n <= len(vararg):
+		return vararg[:n]
 	}
+	va := make([]value, n)
+	copy(va, vararg)
 	return va
 }
 
@@ -204,7 +265,7 @@ func (fr *Frame) varargs(n int) []Value {
 func (fr *Frame) getUp(index int) *upValue { return fr.closure.getUp(index) }
 
 // setupval set the upvalue at index to value
-func (fr *Frame) setUp(index int, value Value) { fr.closure.setUp(index, value) }
+func (fr *Frame) setUp(index int, v value) { fr.closure.setUp(index, v) }
 
 // openUp opens the upvalues for the closure.
 func (fr *Frame) openUp(cls *Closure) {
@@ -235,11 +296,13 @@ func (fr *Frame) findUp(index int) *upValue {
 	return up
 }
 
-// closeUp closes upvalues below the index upto.
+// closeUp closes the open upvalues at or above the index upto.
 func (fr *Frame) closeUp(upto int) {
 	for i, up := range fr.up {
-		delete(fr.up, i)
-		up.close()
+		if i >= upto {
+			delete(fr.up, i)
+			up.close()
+		}
 	}
 }
 
@@ -248,14 +311,14 @@ func (fr *Frame) closeUp(upto int) {
 // Because indices start at 1, this result is equal to the
 // number of elements in the stack; in particular, 0 means
 // an empty stack.
-func (fr *Frame) gettop() int { return len(fr.locals) }
+func (fr *Frame) gettop() int { return fr.top - fr.base }
 
 // local returns the n'th local in the frame's stack.
 //
 // TODO: bounds check
 func (fr *Frame) local(index int) Value {
 	if index = fr.absindex(index); fr.instack(index) {
-		return fr.locals[index-1]
+		return fr.state.stack[fr.base+index-1].box()
 	}
 	return None
 }
@@ -276,13 +339,20 @@ func (fr *Frame) pushN(vs []Value) {
 // Values at other positions are not affected.
 //
 // TODO: bounds check
-func (fr *Frame) copy(src, dst int) { fr.set(src, fr.get(dst)) }
+func (fr *Frame) copy(src, dst int) { fr.setReg(src, fr.reg(dst)) }
 
 // push pushes 1 values onto the frame's stack.
 //
 // TODO: ensure stack
-func (fr *Frame) push(v Value) {
-	fr.locals = append(fr.locals, v)
+func (fr *Frame) push(v Value) { fr.pushReg(unbox(v)) }
+
+// pushReg pushes the value v onto the frame's stack.
+func (fr *Frame) pushReg(v value) {
+	if fr.top == len(fr.state.stack) {
+		fr.state.grow(fr.top + 1)
+	}
+	fr.state.stack[fr.top] = v
+	fr.top++
 }
 
 // pop pops 1 value from the frame's stack.
@@ -292,10 +362,10 @@ func (fr *Frame) pop() Value {
 	if fr.gettop() == 0 {
 		return None
 	}
-	top := fr.gettop() - 1
-	val := fr.locals[top]
-	fr.locals = fr.locals[:top]
-	return val
+	fr.top--
+	val := fr.state.stack[fr.top]
+	fr.state.stack[fr.top] = value{}
+	return val.box()
 }
 
 // popN pops N values from the frame's stack.
@@ -304,18 +374,27 @@ func (fr *Frame) pop() Value {
 func (fr *Frame) popN(n int) (vs []Value) {
 	if n > 0 {
 		vs = make([]Value, n)
-		for i := n - 1; i >= 0; i-- {
-			vs[i] = fr.pop()
+		k := min(n, fr.gettop())
+		for i := range vs[:n-k] {
+			vs[i] = None
 		}
+		for i, v := range fr.state.stack[fr.top-k : fr.top] {
+			vs[n-k+i] = v.box()
+		}
+		fr.settop(fr.gettop() - k)
 	}
 	return vs
 }
 
 // step returns the next instruction for the frame's
-// current instruction pointer and increments by n.
+// current instruction pointer and increments by n;
+// backward jumps check the state's context.
 //
 // TODO: bounds check
 func (fr *Frame) step(n int) vm.Instr {
+	if n < 0 {
+		fr.state.checkContext()
+	}
 	i := vm.Instr(fr.closure.binary.Code[fr.pc])
 	fr.pc += n
 	return i
@@ -332,12 +411,17 @@ func (fr *Frame) code(pc int) vm.Instr {
 //
 // TODO: pseudo & upvalue indices.
 // TODO: bounds and stack check.
-func (fr *Frame) set(index int, value Value) {
-	if fr.gettop() == 0 || fr.gettop() == index {
-		fr.push(value)
+func (fr *Frame) set(index int, value Value) { fr.setReg(index, unbox(value)) }
+
+// setReg sets the frame local value at index, the register of the executing
+// function, to v.
+func (fr *Frame) setReg(index int, v value) {
+	if index >= fr.gettop() {
+		fr.settop(index)
+		fr.pushReg(v)
 		return
 	}
-	fr.locals[index] = value
+	fr.state.stack[fr.base+index] = v
 }
 
 // get returns the value located in the frame's locals
@@ -345,11 +429,15 @@ func (fr *Frame) set(index int, value Value) {
 //
 // TODO: pseudo & upvalue indices.
 // TODO: bounds and stack check.
-func (fr *Frame) get(index int) Value {
-	if index >= 0 && index < len(fr.locals) {
-		return fr.locals[index]
+func (fr *Frame) get(index int) Value { return fr.reg(index).box() }
+
+// reg returns the value of the frame local at index, the register of the
+// executing function, or nil.
+func (fr *Frame) reg(index int) value {
+	if index >= 0 && index < fr.gettop() {
+		return fr.state.stack[fr.base+index]
 	}
-	return None
+	return none
 }
 
 // instack reports whether index is in the frame's locals stack.
@@ -0,0 +1,442 @@
+// Package lint implements the static checks of Lua source text done by
+// "glua lint": accesses to unknown globals, unused and shadowed locals,
+// unreachable code and calls to standard library functions with a wrong
+// number of arguments.
+package lint
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/Azure/golua/lua/ast"
+	"github.com/Azure/golua/std"
+)
+
+// Check identifies the check reporting a problem.
+type Check int
+
+const (
+	UndefinedGlobal Check = iota // access to a global not in the allowlist
+	Unused                       // local variable, parameter or function never read
+	Shadowed                     // local hiding another one of the same name
+	Unreachable                  // statement that can never run
+	WrongArity                   // call with a wrong number of arguments
+)
+
+var checks = [...]string{"undefined-global", "unused", "shadowed", "unreachable", "arity"}
+
+func (c Check) String() string { return checks[c] }
+
+// Problem is a problem found in a chunk.
+type Problem struct {
+	Pos     ast.Pos
+	Check   Check
+	Message string
+}
+
+// Arity is the number of arguments accepted by a function; Max is -1
+// if the function is variadic.
+type Arity struct {
+	Min, Max int
+}
+
+func (a Arity) String() string {
+	plural := func(n int) string {
+		if n == 1 {
+			return "1 argument"
+		}
+		return fmt.Sprintf("%d arguments", n)
+	}
+	switch {
+	case a.Max < 0:
+		return "at least " + plural(a.Min)
+	case a.Min == a.Max:
+		return plural(a.Min)
+	}
+	return fmt.Sprintf("%d to %s", a.Min, plural(a.Max))
+}
+
+// Config is the configuration of the checks.
+type Config struct {
+	// Globals is the allowlist of global variables.
+	Globals map[string]bool
+
+	// Funcs holds the arity of known functions, by global name or
+	// qualified with the name of their library (as in "string.rep").
+	Funcs map[string]Arity
+}
+
+// Default returns the configuration for scripts run with the standard
+// libraries opened by std.Open with the given options.
+func Default(opts ...std.Option) *Config {
+	cfg := &Config{Globals: make(map[string]bool), Funcs: make(map[string]Arity)}
+	for _, name := range std.Globals(opts...) {
+		cfg.Globals[name] = true
+	}
+	for name, arity := range stdFuncs {
+		if lib := strings.SplitN(name, ".", 2)[0]; cfg.Globals[lib] {
+			cfg.Funcs[name] = arity
+		}
+	}
+	return cfg
+}
+
+// Source parses the Lua source text src of the chunk named chunkname and
+// returns the problems found in it, or the syntax error, if any.
+func Source(chunkname string, src []byte, cfg *Config) ([]Problem, error) {
+	chunk, err := ast.Parse(chunkname, src)
+	if err != nil {
+		return nil, err
+	}
+	return Chunk(chunk, cfg), nil
+}
+
+// Chunk returns the problems found in chunk, sorted by position.
+//
+// Globals are reported when they are set, or when they are read and never
+// set in the chunk. Local variables whose name starts with '_' are not
+// reported as unused or shadowing.
+func Chunk(chunk *ast.Chunk, cfg *Config) []Problem {
+	c := &checker{cfg: cfg, set: make(map[string]bool)}
+	c.open()
+	c.declare(&ast.NameExpr{Name: "_ENV"}, "", true)
+	c.block(chunk.Block)
+	c.close()
+	for _, x := range c.reads {
+		if !c.set[x.Name] {
+			c.report(x.Pos(), UndefinedGlobal, "undefined global %q", x.Name)
+		}
+	}
+	sort.SliceStable(c.problems, func(i, j int) bool {
+		return c.problems[i].Pos.Offset < c.problems[j].Pos.Offset
+	})
+	return c.problems
+}
+
+// variable is a local variable in scope.
+type variable struct {
+	name     string
+	pos      ast.Pos
+	kind     string // "variable", "parameter", "loop variable" or "function"
+	implicit bool   // declared by the language (self, _ENV)
+	used     bool
+}
+
+// scope holds the variables declared in a block.
+type scope struct {
+	outer *scope
+	vars  []*variable
+}
+
+type checker struct {
+	cfg      *Config
+	scope    *scope
+	set      map[string]bool // globals set in the chunk
+	reads    []*ast.NameExpr // reads of globals not in the allowlist
+	problems []Problem
+}
+
+func (c *checker) report(pos ast.Pos, check Check, format string, args ...interface{}) {
+	c.problems = append(c.problems, Problem{pos, check, fmt.Sprintf(format, args...)})
+}
+
+func (c *checker) open() { c.scope = &scope{outer: c.scope} }
+
+// close closes the current scope, reporting its unused variables.
+func (c *checker) close() {
+	for _, v := range c.scope.vars {
+		if !v.used && !v.implicit && !strings.HasPrefix(v.name, "_") {
+			c.report(v.pos, Unused, "unused %s %q", v.kind, v.name)
+		}
+	}
+	c.scope = c.scope.outer
+}
+
+// declare declares the local variable name in the current scope.
+func (c *checker) declare(name *ast.NameExpr, kind string, implicit bool) *variable {
+	if prev := c.lookup(name.Name); prev != nil && !prev.implicit && !implicit && !strings.HasPrefix(name.Name, "_") {
+		c.report(name.Pos(), Shadowed, "%q shadows the %s declared on line %d", name.Name, prev.kind, prev.pos.Line)
+	}
+	v := &variable{name: name.Name, pos: name.Pos(), kind: kind, implicit: implicit}
+	c.scope.vars = append(c.scope.vars, v)
+	return v
+}
+
+// lookup returns the local variable name in scope, or nil if name
+// refers to a global.
+func (c *checker) lookup(name string) *variable {
+	for s := c.scope; s != nil; s = s.outer {
+		for i := len(s.vars) - 1; i >= 0; i-- {
+			if s.vars[i].name == name {
+				return s.vars[i]
+			}
+		}
+	}
+	return nil
+}
+
+// use records a read of the variable x.
+func (c *checker) use(x *ast.NameExpr) {
+	if v := c.lookup(x.Name); v != nil {
+		v.used = true
+	} else if !c.cfg.Globals[x.Name] {
+		c.reads = append(c.reads, x)
+	}
+}
+
+// assign records an assignment to the variable x.
+func (c *checker) assign(x *ast.NameExpr) {
+	if c.lookup(x.Name) == nil {
+		if !c.cfg.Globals[x.Name] {
+			c.report(x.Pos(), UndefinedGlobal, "setting undefined global %q", x.Name)
+		}
+		c.set[x.Name] = true
+	}
+}
+
+// ----------------------------------------------------------------------------
+// Statements
+
+// block checks the statements of b in the current scope.
+func (c *checker) block(b *ast.Block) {
+	dead, reported := false, false
+	for _, stmt := range b.Stmts {
+		if _, ok := stmt.(*ast.LabelStmt); ok {
+			dead, reported = false, false // may be the target of a goto
+		} else if dead && !reported {
+			c.report(stmt.Pos(), Unreachable, "unreachable code")
+			reported = true
+		}
+		c.stmt(stmt)
+		if terminates(stmt) {
+			dead = true
+		}
+	}
+}
+
+// scoped checks b in a scope of its own.
+func (c *checker) scoped(b *ast.Block) {
+	c.open()
+	c.block(b)
+	c.close()
+}
+
+func (c *checker) stmt(stmt ast.Stmt) {
+	switch n := stmt.(type) {
+	case *ast.LocalStmt:
+		c.exprs(n.Values)
+		for _, name := range n.Names {
+			v := c.declare(&ast.NameExpr{Span: name.Span, Name: name.Name}, "variable", false)
+			v.used = name.Attrib == "close" // used when leaving the scope
+		}
+
+	case *ast.AssignStmt:
+		for _, target := range n.Targets {
+			if x, ok := target.(*ast.NameExpr); ok {
+				c.assign(x)
+			} else {
+				c.expr(target)
+			}
+		}
+		c.exprs(n.Values)
+
+	case *ast.CallStmt:
+		c.expr(n.Call)
+
+	case *ast.DoStmt:
+		c.scoped(n.Body)
+
+	case *ast.WhileStmt:
+		c.expr(n.Cond)
+		c.scoped(n.Body)
+
+	case *ast.RepeatStmt:
+		c.open()
+		c.block(n.Body)
+		c.expr(n.Cond) // sees the locals of the body
+		c.close()
+
+	case *ast.IfStmt:
+		for _, clause := range n.Clauses {
+			c.expr(clause.Cond)
+			c.scoped(clause.Body)
+		}
+		if n.Else != nil {
+			c.scoped(n.Else)
+		}
+
+	case *ast.NumericForStmt:
+		c.expr(n.Start)
+		c.expr(n.Limit)
+		if n.Step != nil {
+			c.expr(n.Step)
+		}
+		c.open()
+		c.declare(n.Var, "loop variable", false)
+		c.block(n.Body)
+		c.close()
+
+	case *ast.GenericForStmt:
+		c.exprs(n.Exprs)
+		c.open()
+		for _, name := range n.Names {
+			c.declare(name, "loop variable", false)
+		}
+		c.block(n.Body)
+		c.close()
+
+	case *ast.FunctionStmt:
+		if x, ok := n.Name.(*ast.NameExpr); ok {
+			c.assign(x)
+		} else {
+			c.expr(n.Name)
+		}
+		c.function(n.Func, n.Method != nil)
+
+	case *ast.LocalFunctionStmt:
+		c.declare(n.Name, "function", false)
+		c.function(n.Func, false)
+
+	case *ast.ReturnStmt:
+		c.exprs(n.Values)
+
+	case *ast.BreakStmt, *ast.GotoStmt, *ast.LabelStmt:
+		// nothing to do
+	}
+}
+
+// terminates reports whether control never flows past stmt.
+func terminates(stmt ast.Stmt) bool {
+	switch n := stmt.(type) {
+	case *ast.ReturnStmt, *ast.BreakStmt, *ast.GotoStmt:
+		return true
+	case *ast.DoStmt:
+		return blockTerminates(n.Body)
+	case *ast.IfStmt:
+		if n.Else == nil || !blockTerminates(n.Else) {
+			return false
+		}
+		for _, clause := range n.Clauses {
+			if !blockTerminates(clause.Body) {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+func blockTerminates(b *ast.Block) bool {
+	return len(b.Stmts) > 0 && terminates(b.Stmts[len(b.Stmts)-1])
+}
+
+// ----------------------------------------------------------------------------
+// Expressions
+
+func (c *checker) exprs(list []ast.Expr) {
+	for _, x := range list {
+		c.expr(x)
+	}
+}
+
+func (c *checker) expr(x ast.Expr) {
+	switch n := x.(type) {
+	case *ast.NameExpr:
+		c.use(n)
+
+	case *ast.IndexExpr:
+		c.expr(n.X)
+		c.expr(n.Key)
+
+	case *ast.SelectorExpr:
+		c.expr(n.X)
+
+	case *ast.CallExpr:
+		c.expr(n.Fn)
+		c.exprs(n.Args)
+		c.arity(n)
+
+	case *ast.MethodCallExpr:
+		c.expr(n.X)
+		c.exprs(n.Args)
+
+	case *ast.ParenExpr:
+		c.expr(n.X)
+
+	case *ast.FuncExpr:
+		c.function(n, false)
+
+	case *ast.TableExpr:
+		for _, f := range n.Fields {
+			if f.Kind == ast.KeyedField {
+				c.expr(f.Key)
+			}
+			c.expr(f.Value)
+		}
+
+	case *ast.UnaryExpr:
+		c.expr(n.X)
+
+	case *ast.BinaryExpr:
+		c.expr(n.X)
+		c.expr(n.Y)
+	}
+}
+
+// function checks the function fn, declared with an implicit self
+// parameter if method is set.
+func (c *checker) function(fn *ast.FuncExpr, method bool) {
+	c.open()
+	if method {
+		c.declare(&ast.NameExpr{Span: fn.Span, Name: "self"}, "parameter", true)
+	}
+	for _, param := range fn.Params {
+		c.declare(param, "parameter", false)
+	}
+	c.block(fn.Body)
+	c.close()
+}
+
+// arity checks the number of arguments of a call to a known function.
+func (c *checker) arity(call *ast.CallExpr) {
+	var name string
+	switch fn := call.Fn.(type) {
+	case *ast.NameExpr:
+		name = fn.Name
+		if c.lookup(name) != nil {
+			return
+		}
+	case *ast.SelectorExpr:
+		lib, ok := fn.X.(*ast.NameExpr)
+		if !ok || c.lookup(lib.Name) != nil {
+			return
+		}
+		name = lib.Name + "." + fn.Sel.Name
+	default:
+		return
+	}
+	arity, ok := c.cfg.Funcs[name]
+	if !ok {
+		return
+	}
+	n := len(call.Args)
+	if n > 0 && multiple(call.Args[n-1]) {
+		// The last argument may expand to any number of values.
+		if n--; arity.Max < 0 || n <= arity.Max {
+			return
+		}
+	} else if n >= arity.Min && (arity.Max < 0 || n <= arity.Max) {
+		return
+	}
+	c.report(call.Pos(), WrongArity, "%s expects %v, got %d", name, arity, len(call.Args))
+}
+
+// multiple reports whether x may evaluate to several values.
+func multiple(x ast.Expr) bool {
+	switch x.(type) {
+	case *ast.CallExpr, *ast.MethodCallExpr, *ast.VarargExpr:
+		return true
+	}
+	return false
+}
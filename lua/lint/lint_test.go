@@ -0,0 +1,93 @@
+package lint
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/Azure/golua/lua"
+	"github.com/Azure/golua/std"
+)
+
+func TestChunk(t *testing.T) {
+	var tests = []struct {
+		source string
+		want   []string
+	}{
+		{"local t = {}; t.x = print; return t", nil},
+		{"prnt('x')", []string{`1:1: undefined-global: undefined global "prnt"`}},
+		{"count = 1; return count", []string{`1:1: undefined-global: setting undefined global "count"`}},
+		{"local x = 1", []string{`1:7: unused: unused variable "x"`}},
+		{"local x; x = 1", []string{`1:7: unused: unused variable "x"`}},
+		{"local _x, _ = 1, 2", nil},
+		{"return function(a, b) return a end", []string{`1:20: unused: unused parameter "b"`}},
+		{"for i, v in pairs({}) do print(v) end", []string{`1:5: unused: unused loop variable "i"`}},
+		{"local function f() end", []string{`1:16: unused: unused function "f"`}},
+		{"local function f(n) return n > 0 and f(n - 1) end; f(1)", nil},
+		{"local t = {}; function t:m() return self end; return t", nil},
+		{"local x = 1; do local x = 2; print(x) end; print(x)", []string{`1:23: shadowed: "x" shadows the variable declared on line 1`}},
+		{"local x = 1\nlocal x = x + 1\nreturn x", []string{`2:7: shadowed: "x" shadows the variable declared on line 1`}},
+		{"local x = 1\nrepeat local y = x until y", nil},
+		{"while true do break; print(1); print(2) end", []string{`1:22: unreachable: unreachable code`}},
+		{"do goto l; print(1); ::l:: print(2) end", []string{`1:12: unreachable: unreachable code`}},
+		{"local function f(x) if x then return 1 else return 2 end; print(x) end; return f", []string{`1:59: unreachable: unreachable code`}},
+		{"print(string.rep('x'))", []string{`1:7: arity: string.rep expects 2 to 3 arguments, got 1`}},
+		{"return rawequal(1, 2, 3), type()", []string{
+			`1:8: arity: rawequal expects 2 arguments, got 3`,
+			`1:27: arity: type expects 1 argument, got 0`,
+		}},
+		{"return string.rep(...), string.rep('x', ...), math.floor(1, 2, ...)", []string{
+			`1:47: arity: math.floor expects 1 argument, got 3`,
+		}},
+		{"local string = {rep = print}; string.rep()", nil},
+		{"bit.band(1)", []string{`1:1: undefined-global: undefined global "bit"`}},
+	}
+	cfg := Default()
+	for _, test := range tests {
+		problems, err := Source("=test", []byte(test.source), cfg)
+		if err != nil {
+			t.Errorf("lint %q: %v", test.source, err)
+			continue
+		}
+		var got []string
+		for _, p := range problems {
+			got = append(got, fmt.Sprintf("%d:%d: %v: %s", p.Pos.Line, p.Pos.Column, p.Check, p.Message))
+		}
+		if strings.Join(got, "\n") != strings.Join(test.want, "\n") {
+			t.Errorf("lint %q:\ngot:\n%s\nwant:\n%s", test.source, strings.Join(got, "\n"), strings.Join(test.want, "\n"))
+		}
+	}
+}
+
+// TestStdFuncs checks that the arity of every function installed by the
+// standard libraries is known.
+func TestStdFuncs(t *testing.T) {
+	state := lua.NewState()
+	std.Open(state, std.WithCompat51(true))
+	state.PushGlobals()
+	state.Push(nil)
+	for state.Next(-2) {
+		name := state.ToString(-2)
+		switch state.TypeAt(-1) {
+		case lua.FuncType:
+			if _, ok := stdFuncs[name]; !ok {
+				t.Errorf("missing arity of %s", name)
+			}
+		case lua.TableType:
+			if name == "_G" {
+				break
+			}
+			state.Push(nil)
+			for state.Next(-2) {
+				if state.TypeAt(-1) == lua.FuncType {
+					field := name + "." + state.ToString(-2)
+					if _, ok := stdFuncs[field]; !ok {
+						t.Errorf("missing arity of %s", field)
+					}
+				}
+				state.Pop()
+			}
+		}
+		state.Pop()
+	}
+}
@@ -0,0 +1,172 @@
+package lint
+
+// stdFuncs holds the arity of the functions registered by the standard
+// libraries in std, including the Lua 5.1 compatibility ones.
+var stdFuncs = map[string]Arity{
+	// base
+	"assert":         {1, -1},
+	"collectgarbage": {0, 2},
+	"dofile":         {0, 1},
+	"error":          {0, 2},
+	"getmetatable":   {1, 1},
+	"ipairs":         {1, 1},
+	"load":           {1, 4},
+	"loadfile":       {0, 3},
+	"next":           {1, 2},
+	"pairs":          {1, 1},
+	"pcall":          {1, -1},
+	"print":          {0, -1},
+	"rawequal":       {2, 2},
+	"rawget":         {2, 2},
+	"rawlen":         {1, 1},
+	"rawset":         {3, 3},
+	"require":        {1, 1},
+	"select":         {1, -1},
+	"setmetatable":   {2, 2},
+	"tonumber":       {1, 2},
+	"tostring":       {1, 1},
+	"type":           {1, 1},
+	"xpcall":         {2, -1},
+
+	// compat
+	"getfenv":    {0, 1},
+	"loadstring": {1, 4},
+	"module":     {1, -1},
+	"setfenv":    {2, 2},
+	"unpack":     {1, 3},
+
+	// bit
+	"bit.arshift": {2, 2},
+	"bit.band":    {1, -1},
+	"bit.bnot":    {1, 1},
+	"bit.bor":     {1, -1},
+	"bit.bswap":   {1, 1},
+	"bit.bxor":    {1, -1},
+	"bit.lshift":  {2, 2},
+	"bit.rol":     {2, 2},
+	"bit.ror":     {2, 2},
+	"bit.rshift":  {2, 2},
+	"bit.tobit":   {1, 1},
+	"bit.tohex":   {1, 2},
+
+	// coroutine
+	"coroutine.close":       {1, 1},
+	"coroutine.create":      {1, 1},
+	"coroutine.isyieldable": {0, 0},
+	"coroutine.resume":      {1, -1},
+	"coroutine.running":     {0, 0},
+	"coroutine.status":      {1, 1},
+	"coroutine.wrap":        {1, 1},
+	"coroutine.yield":       {0, -1},
+
+	// debug
+	"debug.debug":        {0, 0},
+	"debug.gethook":      {0, 1},
+	"debug.getinfo":      {1, 3},
+	"debug.getlocal":     {2, 3},
+	"debug.getmetatable": {1, 1},
+	"debug.getregistry":  {0, 0},
+	"debug.getupvalue":   {2, 2},
+	"debug.getuservalue": {1, 1},
+	"debug.sethook":      {0, 4},
+	"debug.setlocal":     {3, 4},
+	"debug.setmetatable": {2, 2},
+	"debug.setupvalue":   {3, 3},
+	"debug.setuservalue": {2, 2},
+	"debug.traceback":    {0, 3},
+	"debug.upvalueid":    {2, 2},
+	"debug.upvaluejoin":  {4, 4},
+
+	// io
+	"io.close":   {0, 1},
+	"io.flush":   {0, 0},
+	"io.input":   {0, 1},
+	"io.lines":   {0, -1},
+	"io.open":    {1, 2},
+	"io.output":  {0, 1},
+	"io.popen":   {1, 2},
+	"io.read":    {0, -1},
+	"io.tmpfile": {0, 0},
+	"io.type":    {1, 1},
+	"io.write":   {0, -1},
+
+	// math
+	"math.abs":        {1, 1},
+	"math.acos":       {1, 1},
+	"math.asin":       {1, 1},
+	"math.atan":       {1, 2},
+	"math.ceil":       {1, 1},
+	"math.cos":        {1, 1},
+	"math.deg":        {1, 1},
+	"math.exp":        {1, 1},
+	"math.floor":      {1, 1},
+	"math.fmod":       {2, 2},
+	"math.log":        {1, 2},
+	"math.max":        {1, -1},
+	"math.min":        {1, -1},
+	"math.modf":       {1, 1},
+	"math.pow":        {2, 2},
+	"math.rad":        {1, 1},
+	"math.random":     {0, 2},
+	"math.randomseed": {0, 2},
+	"math.sin":        {1, 1},
+	"math.sqrt":       {1, 1},
+	"math.tan":        {1, 1},
+	"math.tointeger":  {1, 1},
+	"math.type":       {1, 1},
+	"math.ult":        {2, 2},
+
+	// os
+	"os.clock":     {0, 0},
+	"os.date":      {0, 2},
+	"os.difftime":  {1, 2},
+	"os.execute":   {0, 1},
+	"os.exit":      {0, 2},
+	"os.getenv":    {1, 1},
+	"os.remove":    {1, 1},
+	"os.rename":    {2, 2},
+	"os.setlocale": {0, 2},
+	"os.time":      {0, 1},
+	"os.tmpname":   {0, 0},
+
+	// package
+	"package.loadlib":    {2, 2},
+	"package.searchpath": {2, 4},
+	"package.seeall":     {1, 1},
+
+	// string
+	"string.byte":     {1, 3},
+	"string.char":     {0, -1},
+	"string.dump":     {1, 2},
+	"string.find":     {2, 4},
+	"string.format":   {1, -1},
+	"string.gmatch":   {2, 2},
+	"string.gsub":     {3, 4},
+	"string.len":      {1, 1},
+	"string.lower":    {1, 1},
+	"string.match":    {2, 3},
+	"string.pack":     {1, -1},
+	"string.packsize": {1, 1},
+	"string.rep":      {2, 3},
+	"string.reverse":  {1, 1},
+	"string.sub":      {2, 3},
+	"string.unpack":   {2, 3},
+	"string.upper":    {1, 1},
+
+	// table
+	"table.concat": {1, 4},
+	"table.getn":   {1, 1},
+	"table.insert": {2, 3},
+	"table.move":   {4, 5},
+	"table.pack":   {0, -1},
+	"table.remove": {1, 2},
+	"table.sort":   {1, 2},
+	"table.unpack": {1, 3},
+
+	// utf8
+	"utf8.char":      {0, -1},
+	"utf8.codepoint": {1, 3},
+	"utf8.codes":     {1, 1},
+	"utf8.len":       {1, 3},
+	"utf8.offset":    {2, 3},
+}
@@ -17,6 +17,9 @@ import (
 func (state *State) Dump(strip bool) []byte {
 	if cls, ok := state.get(-1).(*Closure); ok {
 		if cls.isLua() {
+			if state.global.config.version == V54 {
+				return binary.Dump54(cls.binary, strip)
+			}
 			return binary.Dump(cls.binary, strip)
 		}
 	}
@@ -35,7 +38,7 @@ func (state *State) SetUpValue(fnIndex, upIndex int) (name string) {
 	if cls, ok := state.get(fnIndex).(*Closure); ok {
 		if upAt := upIndex - 1; upAt < len(cls.upvals) {
 			upvalue := state.frame().pop()
-			cls.setUp(upAt, upvalue)
+			cls.setUp(upAt, unbox(upvalue))
 			name = cls.upName(upAt)
 		}
 	}
@@ -44,14 +47,21 @@ func (state *State) SetUpValue(fnIndex, upIndex int) (name string) {
 
 // Load loads a Lua chunk wihtout running it. If there are no errors, Load pushes
 // the compiled chunk as a Lua function on top of the stack, otherwise nothing is
-// pushed and the error is returned.
+// pushed and the error is returned. Syntax errors are returned as *syntax.Error
+// values, carrying the position of the error.
 //
 // If the resulting function has upvalues, its first upvalue is set to the value of
 // the global environment stored at index LUA_RIDX_GLOBALS in the registry (see §4.5).
 // When loading main chunks, this upvalue will be the _ENV variable (see §2.2). Other
 // upvalues are initialized with nil.
-func (state *State) LoadChunk(filename string, source interface{}, mode Mode) error {
-	cls, err := state.load(filename, source)
+//
+// As lua_load, Load runs in protected mode: it returns ErrMemory if the function does
+// not fit within the memory limit.
+func (state *State) LoadChunk(filename string, source interface{}, mode Mode) (err error) {
+	var cls *Closure
+	if perr := state.protect(func() { cls, err = state.load(filename, source, mode) }); perr != nil {
+		return perr
+	}
 	if err != nil {
 		return err
 	}
@@ -66,17 +76,19 @@ func (state *State) LoadChunk(filename string, source interface{}, mode Mode) er
 //
 // If source != nil, Do loads the source from source and the filename is only used
 // recording position information.
+//
+// As luaL_dostring and luaL_dofile, the chunk runs in protected mode: the errors it
+// raises, such as ErrMemory, are returned.
 func (state *State) ExecChunk(filename string, source interface{}, mode Mode) error {
 	if err := state.LoadChunk(filename, source, mode); err != nil {
 		return err
 	}
-	state.Call(0, -1)
-	return nil
+	return state.PCall(0, MultRets, 0)
 }
 
 // Register sets the Go function fn as the new value of global name.
 func (state *State) Register(name string, fn Func) {
-	state.Push(newGoClosure(fn, 0))
+	state.Push(newGoClosure(state, fn, 0))
 	state.SetGlobal(name)
 }
 
@@ -98,14 +110,29 @@ func (state *State) AtPanic(panicFn Func) Func {
 // status LUA_OK (to start a new coroutine) or LUA_YIELD (to resume a coroutine).
 //
 // See https://www.lua.org/manual/5.3/manual.html#lua_status
-func (state *State) Status() ThreadStatus { unimplemented("Status"); return ThreadError }
+func (state *State) Status() ThreadStatus { return state.status }
 
 // Generates a Lua error, using the value at the top of the stack as the error object.
 //
 // This function does a long jump, and therefore never returns (see luaL_error).
+// The error object is passed as is to the catchers of the error (see PushError),
+// which may be any value, such as a table.
 //
 // See https://www.lua.org/manual/5.3/manual.html#lua_error
-func (state *State) Error() int { return state.errorf("%v", state.frame().pop()) }
+func (state *State) Error() int {
+	switch v := state.frame().pop(); v.(type) {
+	case String:
+		return state.errorf("%v", v)
+	case Nil:
+		return state.panic(&luaError{value: Nil(1)}) // not none
+	default:
+		return state.panic(&luaError{value: v})
+	}
+}
+
+// PushError pushes onto the stack the error object of err, an error returned
+// by PCall or Resume: the value passed to Error, or the message of err.
+func (state *State) PushError(err error) { state.frame().push(errorObject(err)) }
 
 // Destroys all objects in the given Lua state (calling the corresponding garbage-collection metamethods, if any) and
 // frees all dynamic memory used by this state. In several platforms, you may not need to call this function, because
@@ -113,14 +140,26 @@ func (state *State) Error() int { return state.errorf("%v", state.frame().pop())
 // multiple states, such as daemons or web servers, will probably need to close states as soon as they are not needed.
 //
 // See https://www.lua.org/manual/5.3/manual.html#lua_close
-func (state *State) Close() { /* TODO */ }
+//
+// The __gc metamethods still pending are called first, then those of all the
+// objects marked for finalization, in the reverse order of their marking;
+// then the suspended coroutines are unwound, so that their goroutines exit.
+func (state *State) Close() {
+	if g := state.global; !g.closed {
+		g.thread0.closeFinalizers()
+	}
+}
 
 // Returns the address of the version number (a C static variable) stored in the Lua core. When called with a valid lua_State,
 // returns the address of the version used to create that state. When called with NULL, returns the address of the version
 // running the call.
 //
 // See https://www.lua.org/manual/5.3/manual.html#lua_version
-func (state *State) Version() *float64 { unimplemented("Version"); return nil }
+func (state *State) Version() *float64 { return state.global.version }
+
+// LuaVersion returns the Lua language version implemented by the state,
+// as selected by WithVersion.
+func (state *State) LuaVersion() LuaVersion { return state.global.config.version }
 
 // Performs an arithmetic or bitwise operation over the two values (or one, in the case of negations) at the top of the
 // stack, with the value at the top being the second operand, pops these values, and pushes the result of the operation.
@@ -146,7 +185,7 @@ func (state *State) Version() *float64 { unimplemented("Version"); return nil }
 func (state *State) Arith(op Op) {
 	y := state.frame().pop()
 	x := state.frame().pop()
-	state.frame().push(state.arith(op, x, y))
+	state.frame().pushReg(state.arith(op, unbox(x), unbox(y)))
 }
 
 // Concatenates the n values at the top of the stack, pops them, and leaves the result at the top. If n is 1, the result
@@ -179,22 +218,22 @@ func (state *State) Length(index int) int { unimplemented("Length"); return 0 }
 //      * LUA_OPLT: compares for less than (<)
 //      * LUA_OPLE: compares for less or equal (<=)
 func (state *State) Compare(op Op, i1, i2 int) bool {
-	return state.compare(op, state.get(i1), state.get(i2), false)
+	return state.compare(op, unbox(state.get(i1)), unbox(state.get(i2)), false)
 }
 
 // Pushes onto the stack the value of the global name.
 //
 // Returns the type of that value.
 func (state *State) GetGlobal(name string) Type {
-	val := state.gettable(state.globals(), String(name), false)
-	state.frame().push(val)
-	return val.Type()
+	val := state.gettable(value{v: state.globals()}, value{v: String(name)}, false)
+	state.frame().pushReg(val)
+	return val.typ()
 }
 
 // Pops a value from the stack and sets it as the new value of global name.
 //func (state *State) SetGlobal(name string, value Value) {
 func (state *State) SetGlobal(name string) {
-	state.settable(state.globals(), String(name), state.Pop(), false)
+	state.settable(value{v: state.globals()}, value{v: String(name)}, unbox(state.Pop()), false)
 }
 
 // Creates a new empty table and pushes it onto the stack. Parameter narr is a hint for how
@@ -267,9 +306,9 @@ func (state *State) GetTable(index int) Type {
 		key = state.frame().pop()
 		obj = state.get(index)
 	)
-	val := state.gettable(obj, key, false)
-	state.frame().push(val)
-	return val.Type()
+	val := state.gettable(unbox(obj), unbox(key), false)
+	state.frame().pushReg(val)
+	return val.typ()
 }
 
 // SetTable does the equivalent to t[k] = v, where t is the value at the given index, v is the
@@ -285,7 +324,7 @@ func (state *State) SetTable(index int) {
 		key = state.frame().pop()
 		obj = state.get(index)
 	)
-	state.settable(obj, key, val, false)
+	state.settable(unbox(obj), unbox(key), unbox(val), false)
 }
 
 // Pushes onto the stack the value t[k], where t is the value at the given index.
@@ -296,9 +335,9 @@ func (state *State) SetTable(index int) {
 //
 // See https://www.lua.org/manual/5.3/manual.html#lua_getfield
 func (state *State) GetField(index int, field string) Type {
-	v := state.gettable(state.get(index), String(field), false)
-	state.frame().push(v)
-	return v.Type()
+	v := state.gettable(unbox(state.get(index)), value{v: String(field)}, false)
+	state.frame().pushReg(v)
+	return v.typ()
 }
 
 // Does the equivalent to t[k] = v, where t is the value at the given index and v is the
@@ -313,7 +352,7 @@ func (state *State) SetField(index int, field string) {
 	obj := state.get(index)
 	key := String(field)
 	val := state.frame().pop()
-	state.settable(obj, key, val, false)
+	state.settable(unbox(obj), value{v: key}, unbox(val), false)
 }
 
 // Pushes onto the stack the value t[i], where t is the value at the given index.
@@ -325,10 +364,10 @@ func (state *State) SetField(index int, field string) {
 // See https://www.lua.org/manual/5.3/manual.html#lua_geti
 func (state *State) GetIndex(index int, entry int64) Type {
 	obj := state.get(index)
-	key := Int(entry)
-	val := state.gettable(obj, key, false)
-	state.frame().push(val)
-	return val.Type()
+	key := intValue(entry)
+	val := state.gettable(unbox(obj), key, false)
+	state.frame().pushReg(val)
+	return val.typ()
 }
 
 // Does the equivalent to t[n] = v, where t is the value at the given index and v is the
@@ -341,9 +380,9 @@ func (state *State) GetIndex(index int, entry int64) Type {
 // See https://www.lua.org/manual/5.3/manual.html#lua_seti
 func (state *State) SetIndex(index int, entry int64) {
 	tbl := state.get(index)
-	key := Int(entry)
+	key := intValue(entry)
 	val := state.frame().pop()
-	state.settable(tbl, key, val, false)
+	state.settable(unbox(tbl), key, unbox(val), false)
 }
 
 // Similar to lua_gettable, but does a raw access (i.e., without metamethods).
@@ -354,9 +393,9 @@ func (state *State) RawGet(index int) Type {
 		key = state.frame().pop()
 		obj = state.get(index)
 	)
-	val := state.gettable(obj, key, true)
-	state.frame().push(val)
-	return val.Type()
+	val := state.gettable(unbox(obj), unbox(key), true)
+	state.frame().pushReg(val)
+	return val.typ()
 }
 
 // Similar to lua_settable, but does a raw assignment (i.e., without metamethods).
@@ -368,7 +407,7 @@ func (state *State) RawSet(index int) {
 		key = state.frame().pop()
 		obj = state.get(index)
 	)
-	state.settable(obj, key, val, true)
+	state.settable(unbox(obj), unbox(key), unbox(val), true)
 }
 
 // RawLen returns the raw "length" of the value at the given index: for strings, this
@@ -382,7 +421,7 @@ func (state *State) RawLen(index int) int {
 	case String:
 		return len(v)
 	case *table:
-		return len(v.list)
+		return v.length()
 	}
 	return 0
 }
@@ -397,11 +436,11 @@ func (state *State) RawLen(index int) int {
 func (state *State) RawGetIndex(index, entry int) Type {
 	var (
 		obj = state.get(index)
-		key = Int(entry)
-		val = state.gettable(obj, key, true)
+		key = intValue(int64(entry))
+		val = state.gettable(unbox(obj), key, true)
 	)
-	state.frame().push(val)
-	return val.Type()
+	state.frame().pushReg(val)
+	return val.typ()
 }
 
 // Does the equivalent of t[i] = v, where t is the table at the given index and
@@ -456,7 +495,7 @@ func (state *State) RawEqual(i1, i2 int) bool {
 	if !state.isValid(i1) || !state.isValid(i2) {
 		return false
 	}
-	return state.compare(OpEq, state.get(i1), state.get(i2), true)
+	return state.compare(OpEq, unbox(state.get(i1)), unbox(state.get(i2)), true)
 }
 
 // PCall calls a function in protected mode.
@@ -487,17 +526,37 @@ func (state *State) RawEqual(i1, i2 int) bool {
 //
 // See https://www.lua.org/manual/5.3/manual.html#lua_pcall
 func (state *State) PCall(args, rets, msgh int) (err error) {
+	return state.protect(func() { state.Call(args, rets) })
+}
+
+// protect runs call in protected mode, returning the error it raises, if
+// the thread can catch it.
+func (state *State) protect(call func()) (err error) {
 	defer func(err *error) {
 		if r := recover(); r != nil {
+			if !state.catchable(r) {
+				panic(r)
+			}
 			if e, ok := r.(error); ok {
 				*err = e
 			}
 		}
 	}(&err)
-	state.Call(args, rets)
+	call()
 	return
 }
 
+// catchable reports whether the error r may be caught by a PCall of the
+// thread; the errors that stop the execution of the whole state are only
+// caught by the host, out of any running function.
+func (state *State) catchable(r interface{}) bool {
+	switch r.(type) {
+	case *ContextError, *BudgetError:
+		return state.frame().closure == nil
+	}
+	return r != errThreadClosed
+}
+
 // Call calls a function.
 //
 // To call a function you must use the following protocol: first, the function to be called is pushed onto the stack;
@@ -511,7 +570,19 @@ func (state *State) PCall(args, rets, msgh int) (err error) {
 //
 // Note that the code above is balanced: at its end, the stack is back to its original configuration.
 // This is considered good programming practice.
+//
+// The called function cannot yield if Call is called by a Go function, which cannot be resumed
+// (see CallK).
 func (state *State) Call(args, rets int) {
+	if !state.frame().function().isLua() {
+		state.nny++
+		defer func() { state.nny-- }()
+	}
+	state.callk(args, rets)
+}
+
+// callk calls a function as Call does, but allows it to yield.
+func (state *State) callk(args, rets int) {
 	//checkNumStack(state, argN + 1)
 	//checkResults(state, argN, retN)
 	var (
@@ -524,7 +595,7 @@ func (state *State) Call(args, rets int) {
 
 	if !ok {
 		if !tryMetaCall(state, value, funcID, args, rets) {
-			state.errorf("attempt to call a %s value @ %d (%T)\n%v\n", value.Type(), funcID, value, state.frame().locals)
+			state.errorf("attempt to call a %s value @ %d (%T)\n%v\n", value.Type(), funcID, value, state.frame().locals())
 		}
 	} else {
 		state.call(&Frame{closure: c, fnID: funcID, rets: rets})
@@ -605,4 +676,4 @@ func (state *State) Main(args ...string) error {
 //
 // TODO: remove me
 //
-func unimplemented(msg string) { panic(fmt.Errorf(msg)) }
+func unimplemented(msg string) { panic(fmt.Errorf("%s", msg)) }
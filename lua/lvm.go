@@ -12,8 +12,8 @@ import "github.com/Azure/golua/lua/vm"
 //
 // R(A) := R(B)
 func (vm *v53) move(instr vm.Instr) {
-	rb := vm.thread().frame().get(instr.B())
-	vm.thread().frame().set(instr.A(), rb)
+	rb := vm.reg(instr.B())
+	vm.setReg(instr.A(), rb)
 }
 
 // LOADK: Load a constant into a register.
@@ -23,7 +23,7 @@ func (vm *v53) move(instr vm.Instr) {
 // R(A) := Kst(Bx)
 func (vm *v53) loadk(instr vm.Instr) {
 	kst := vm.constant(instr.BX())
-	vm.thread().frame().set(instr.A(), kst)
+	vm.setReg(instr.A(), kst)
 }
 
 // LOADKX: Load a constant into a register. The next 'instruction'
@@ -33,9 +33,9 @@ func (vm *v53) loadk(instr vm.Instr) {
 //
 // R(A) := Kst(extra arg)
 func (vm *v53) loadkx(instr vm.Instr) {
-	extra := vm.thread().frame().step(1).AX()
+	extra := vm.frame().step(1).AX()
 	ra := vm.constant(extra)
-	vm.thread().frame().set(instr.A(), ra)
+	vm.setReg(instr.A(), ra)
 }
 
 // LOADBOOL: Load a boolean into a register.
@@ -44,9 +44,9 @@ func (vm *v53) loadkx(instr vm.Instr) {
 //
 // R(A) := (Bool)B; if (C) pc++
 func (vm *v53) loadbool(instr vm.Instr) {
-	vm.thread().frame().set(instr.A(), Bool(instr.B() == 1))
+	vm.setReg(instr.A(), boolValue(instr.B() == 1))
 	if instr.C() != 0 {
-		vm.thread().frame().step(1)
+		vm.frame().step(1)
 	}
 }
 
@@ -61,7 +61,7 @@ func (vm *v53) loadnil(instr vm.Instr) {
 		b = instr.B()
 	)
 	for i := a; i <= a+b; i++ {
-		vm.thread().frame().set(i, Nil(1))
+		vm.setReg(i, nilValue)
 	}
 }
 
@@ -75,8 +75,8 @@ func (vm *v53) getupval(instr vm.Instr) {
 		a = instr.A()
 		b = instr.B()
 	)
-	up := vm.thread().frame().getUp(b)
-	vm.thread().frame().set(a, up.get())
+	up := vm.frame().getUp(b)
+	vm.setReg(a, up.get())
 }
 
 // SETUPVAL: Write a register value into an upvalue.
@@ -89,8 +89,8 @@ func (vm *v53) setupval(instr vm.Instr) {
 		a = instr.A()
 		b = instr.B()
 	)
-	ra := vm.thread().frame().get(a)
-	vm.thread().frame().setUp(b, ra)
+	ra := vm.reg(a)
+	vm.frame().setUp(b, ra)
 }
 
 // GETTABLE: Read a table element into a register (locals).
@@ -104,10 +104,9 @@ func (vm *v53) gettable(instr vm.Instr) {
 		b = instr.B()
 		c = instr.C()
 	)
-	t := vm.thread().frame().get(b)
-	k := vm.rk(c)
-	v := vm.thread().gettable(t, k, false)
-	vm.thread().frame().set(a, v)
+	t := vm.reg(b)
+	v := vm.field(t, c)
+	vm.setReg(a, v)
 }
 
 // SETTABLE: Write a register value into a table element (locals).
@@ -116,7 +115,7 @@ func (vm *v53) gettable(instr vm.Instr) {
 //
 // R(A)[RK(B)] := RK(C)
 func (vm *v53) settable(instr vm.Instr) {
-	obj := vm.thread().frame().get(instr.A())
+	obj := vm.reg(instr.A())
 	key := vm.rk(instr.B())
 	val := vm.rk(instr.C())
 	vm.thread().settable(obj, key, val, false)
@@ -128,10 +127,9 @@ func (vm *v53) settable(instr vm.Instr) {
 //
 // R(A) := UpValue[B][RK(C)]
 func (vm *v53) gettabup(instr vm.Instr) {
-	up := vm.thread().frame().getUp(instr.B()).get()
-	rc := vm.rk(instr.C())
-	ra := vm.thread().gettable(up, rc, false)
-	vm.thread().frame().set(instr.A(), ra)
+	up := vm.frame().getUp(instr.B()).get()
+	ra := vm.field(up, instr.C())
+	vm.setReg(instr.A(), ra)
 }
 
 // SETTABUP: Write a register value into table in up-value (globals).
@@ -140,7 +138,7 @@ func (vm *v53) gettabup(instr vm.Instr) {
 //
 // UpValue[A][RK(B)] := RK(C)
 func (vm *v53) settabup(instr vm.Instr) {
-	up := vm.thread().frame().getUp(instr.A()).get()
+	up := vm.frame().getUp(instr.A()).get()
 	rb := vm.rk(instr.B())
 	rc := vm.rk(instr.C())
 	vm.thread().settable(up, rb, rc, false)
@@ -175,7 +173,7 @@ func (vm *v53) newtable(instr vm.Instr) {
 		c = instr.C()
 	)
 	t := newTable(vm.thread(), fb2i(b), fb2i(c))
-	vm.thread().frame().set(a, t)
+	vm.setReg(a, value{v: t})
 }
 
 // SELF: Prepare an object method for calling.
@@ -185,12 +183,11 @@ func (vm *v53) newtable(instr vm.Instr) {
 // R(A+1) := R(B); R(A) := R(B)[RK(C)]
 func (vm *v53) self(instr vm.Instr) {
 	var (
-		obj = vm.thread().frame().get(instr.B())
-		key = vm.rk(instr.C())
-		fn  = vm.thread().gettable(obj, key, false)
+		obj = vm.reg(instr.B())
+		fn  = vm.field(obj, instr.C())
 	)
-	vm.thread().frame().set(instr.A(), fn)
-	vm.thread().frame().set(instr.A()+1, obj)
+	vm.setReg(instr.A(), fn)
+	vm.setReg(instr.A()+1, obj)
 }
 
 // ADD: Addition operator.
@@ -204,7 +201,7 @@ func (vm *v53) add(instr vm.Instr) {
 		rc = vm.rk(instr.C())
 		ra = vm.thread().arith(OpAdd, rb, rc)
 	)
-	vm.thread().frame().set(instr.A(), ra)
+	vm.setReg(instr.A(), ra)
 }
 
 // SUB: Subtraction operator.
@@ -218,7 +215,7 @@ func (vm *v53) sub(instr vm.Instr) {
 		rc = vm.rk(instr.C())
 		ra = vm.thread().arith(OpSub, rb, rc)
 	)
-	vm.thread().frame().set(instr.A(), ra)
+	vm.setReg(instr.A(), ra)
 }
 
 // MUL: Multiplication operator.
@@ -232,7 +229,7 @@ func (vm *v53) mul(instr vm.Instr) {
 		rc = vm.rk(instr.C())
 		ra = vm.thread().arith(OpMul, rb, rc)
 	)
-	vm.thread().frame().set(instr.A(), ra)
+	vm.setReg(instr.A(), ra)
 }
 
 // MOD: Modulus (remainder) operator.
@@ -246,7 +243,7 @@ func (vm *v53) mod(instr vm.Instr) {
 		rc = vm.rk(instr.C())
 		ra = vm.thread().arith(OpMod, rb, rc)
 	)
-	vm.thread().frame().set(instr.A(), ra)
+	vm.setReg(instr.A(), ra)
 }
 
 // POW: Exponentation operator.
@@ -260,7 +257,7 @@ func (vm *v53) pow(instr vm.Instr) {
 		rc = vm.rk(instr.C())
 		ra = vm.thread().arith(OpPow, rb, rc)
 	)
-	vm.thread().frame().set(instr.A(), ra)
+	vm.setReg(instr.A(), ra)
 }
 
 // DIV: Division operator.
@@ -274,7 +271,7 @@ func (vm *v53) div(instr vm.Instr) {
 		rc = vm.rk(instr.C())
 		ra = vm.thread().arith(OpDiv, rb, rc)
 	)
-	vm.thread().frame().set(instr.A(), ra)
+	vm.setReg(instr.A(), ra)
 }
 
 // UNM: Unary minus.
@@ -285,9 +282,9 @@ func (vm *v53) div(instr vm.Instr) {
 func (vm *v53) unm(instr vm.Instr) {
 	var (
 		rb = vm.rk(instr.B())
-		ra = vm.thread().arith(OpMinus, rb, None)
+		ra = vm.thread().arith(OpMinus, rb, none)
 	)
-	vm.thread().frame().set(instr.A(), ra)
+	vm.setReg(instr.A(), ra)
 }
 
 // NOT: Logical NOT operator.
@@ -296,8 +293,8 @@ func (vm *v53) unm(instr vm.Instr) {
 //
 // R(A) := not R(B)
 func (vm *v53) not(instr vm.Instr) {
-	rb := vm.thread().frame().get(instr.B())
-	vm.thread().frame().set(instr.A(), !truth(rb))
+	rb := vm.reg(instr.B())
+	vm.setReg(instr.A(), boolValue(!rb.truth()))
 }
 
 // LEN: Length operator.
@@ -306,8 +303,8 @@ func (vm *v53) not(instr vm.Instr) {
 //
 // R(A) := length of R(B)
 func (vm *v53) length(instr vm.Instr) {
-	rb := vm.thread().frame().get(instr.B())
-	vm.thread().frame().set(instr.A(), vm.thread().length(rb))
+	rb := vm.reg(instr.B())
+	vm.setReg(instr.A(), vm.thread().length(rb))
 }
 
 // CONCAT: Concatenate a range of registers.
@@ -322,7 +319,7 @@ func (vm *v53) concat(instr vm.Instr) {
 		c = instr.C()
 	)
 	vm.thread().Concat(c - b + 1)
-	vm.thread().frame().replace(a)
+	vm.frame().replace(a)
 }
 
 // JMP: Unconditional jump.
@@ -331,90 +328,92 @@ func (vm *v53) concat(instr vm.Instr) {
 //
 // pc+=sBx; if (A) close all upvalues >= R(A-1)
 func (vm *v53) jmp(instr vm.Instr) {
-	vm.thread().frame().step(instr.SBX())
+	vm.frame().step(instr.SBX())
 	if a := instr.A(); a != 0 {
-		vm.thread().frame().closeUp(a - 1)
+		vm.frame().closeUp(a - 1)
 	}
 }
 
 // EQ: Equality test, with conditional jump.
 //
+// Reports whether the jump that follows is taken (see branch).
+//
 // @args A B C
 //
 // if ((RK(B) == RK(C)) ~= A) then pc++
-func (vm *v53) eq(instr vm.Instr) {
+func (vm *v53) eq(instr vm.Instr) bool {
 	var (
 		rb = vm.rk(instr.B())
 		rc = vm.rk(instr.C())
 		aa = (instr.A() != 0)
 	)
-	if vm.thread().compare(OpEq, rb, rc, false) != aa {
-		vm.thread().frame().step(1)
-	}
+	return vm.thread().compare(OpEq, rb, rc, false) == aa
 }
 
 // LT: Less than test, with conditional jump.
 //
+// Reports whether the jump that follows is taken (see branch).
+//
 // @args A B C
 //
 // if ((RK(B) <  RK(C)) ~= A) then pc++
-func (vm *v53) lt(instr vm.Instr) {
+func (vm *v53) lt(instr vm.Instr) bool {
 	var (
 		rb = vm.rk(instr.B())
 		rc = vm.rk(instr.C())
 		aa = (instr.A() == 1)
 	)
-	if vm.thread().compare(OpLt, rb, rc, false) != aa {
-		vm.thread().frame().step(1)
-	}
+	return vm.thread().compare(OpLt, rb, rc, false) == aa
 }
 
 // LE: Less than or equal to test, with conditional jump.
 //
+// Reports whether the jump that follows is taken (see branch).
+//
 // @args A B C
 //
 // if ((RK(B) <= RK(C)) ~= A) then pc++
-func (vm *v53) le(instr vm.Instr) {
+func (vm *v53) le(instr vm.Instr) bool {
 	var (
 		rb = vm.rk(instr.B())
 		rc = vm.rk(instr.C())
 		aa = (instr.A() == 1)
 	)
-	if vm.thread().compare(OpLe, rb, rc, false) != aa {
-		vm.thread().frame().step(1)
-	}
+	return vm.thread().compare(OpLe, rb, rc, false) == aa
 }
 
 // TEST: Boolean test, with conditional jump.
 //
+// Reports whether the jump that follows is taken (see branch).
+//
 // @args A C
 //
 // if not (R(A) <=> C) then pc++
-func (vm *v53) test(instr vm.Instr) {
+func (vm *v53) test(instr vm.Instr) bool {
 	var (
-		ra = vm.thread().frame().get(instr.A())
+		ra = vm.reg(instr.A())
 		cc = (instr.C() == 1)
 	)
-	if Truth(ra) != cc {
-		vm.thread().frame().step(1)
-	}
+	return ra.truth() == cc
 }
 
 // TESTSET: Boolean test, with conditional jump and assignment.
 //
+// Reports whether the jump that follows is taken (see branch).
+//
 // @args A B C
 //
 // if (R(B) <=> C) then R(A) := R(B) else pc++
-func (vm *v53) testset(instr vm.Instr) {
+func (vm *v53) testset(instr vm.Instr) bool {
 	var (
-		rb = vm.thread().frame().get(instr.B())
+		rb = vm.reg(instr.B())
 		cc = (instr.C() == 1)
 	)
-	if Truth(rb) == cc {
-		vm.thread().frame().set(instr.A(), rb)
-	} else {
-		vm.thread().frame().step(1)
+	if rb.truth() == cc {
+		vm.setReg(instr.A(), rb)
+		return true
 	}
+	return false
 }
 
 // CALL: Calls a function.
@@ -452,17 +451,12 @@ func (vm *v53) call(instr vm.Instr) {
 	)
 	// arguments
 	if b != 0 {
-		vm.thread().frame().settop(a + b)
+		vm.frame().settop(a + b)
 		vm.thread().Call(b-1, c-1)
 	} else {
-		vm.thread().Call(vm.thread().frame().gettop()-a-1, c-1)
-	}
-	// returns
-	if c--; c > 0 {
-		for i, v := range vm.thread().frame().popN(c) {
-			vm.thread().frame().set(a+i, v)
-		}
+		vm.thread().Call(vm.frame().gettop()-a-1, c-1)
 	}
+	// returns are in R(A), ... ,R(A+C-2)
 	// C=0 so return values indicated by 'top'
 }
 
@@ -485,26 +479,19 @@ func (vm *v53) call(instr vm.Instr) {
 //
 // return R(A)(R(A+1), ... ,R(A+B-1))
 func (vm *v53) tailcall(instr vm.Instr) {
-	// TODO: proper tail call elimination
 	var (
-		a = instr.A()
-		b = instr.B()
-		c = instr.C()
+		a    = instr.A()
+		b    = instr.B()
+		args = b - 1
 	)
-	// arguments
-	if b != 0 {
-		vm.thread().frame().settop(a + b)
-		vm.thread().Call(b-1, c-1)
-	} else {
-		vm.thread().Call(vm.thread().frame().gettop()-a-1, c-1)
+	if b == 0 {
+		args = vm.frame().gettop() - a - 1
 	}
-	// returns
-	if c--; c > 0 {
-		for i, v := range vm.thread().frame().popN(c) {
-			vm.thread().frame().set(a+i, v)
-		}
+	if !vm.thread().tailcall(a, args) {
+		// Go function: call it as usual, RETURN passes its results on.
+		vm.frame().settop(a + args + 1)
+		vm.thread().Call(args, MultRets)
 	}
-	// C=0 so return values indicated by 'top'
 }
 
 // RETURN: Returns from function call.
@@ -534,38 +521,14 @@ func (vm *v53) tailcall(instr vm.Instr) {
 // return R(A), ... ,R(A+B-2)
 func (vm *v53) returns(instr vm.Instr) {
 	var (
-		a = instr.A()
-		b = instr.B()
+		fr   = vm.frame()
+		a    = instr.A()
+		retc = instr.B() - 1
 	)
-	if want := vm.thread().frame().rets; want != 0 {
-		b--
-		var (
-			retc int = b
-			rets []Value
-		)
-		if b == -1 {
-			retc = vm.thread().frame().gettop() - a
-		}
-		switch {
-		case want > retc: // # wanted > # returned
-			for i := a; i < a+retc; i++ {
-				rets = append(rets, vm.thread().frame().get(i))
-			}
-			for retc < want {
-				rets = append(rets, None)
-				retc++
-			}
-
-		case want <= retc: // # wanted <= # returned
-			if want == MultRets {
-				want = retc
-			}
-			for i := a; i < a+want; i++ {
-				rets = append(rets, vm.thread().frame().get(i))
-			}
-		}
-		vm.thread().frame().caller().pushN(rets)
+	if retc == -1 {
+		retc = fr.gettop() - a
 	}
+	fr.ret(fr.base+a, fr.base+a+retc)
 }
 
 // FORLOOP: Iterate a numeric for loop.
@@ -575,29 +538,29 @@ func (vm *v53) returns(instr vm.Instr) {
 // R(A)+=R(A+2); if R(A) <?= R(A+1) then { pc+=sBx; R(A+3)=R(A) }
 func (vm *v53) forloop(instr vm.Instr) {
 	var (
-		item = vm.thread().frame().get(instr.A())
-		upto = vm.thread().frame().get(instr.A() + 1)
-		step = vm.thread().frame().get(instr.A() + 2)
+		item = vm.reg(instr.A())
+		upto = vm.reg(instr.A() + 1)
+		step = vm.reg(instr.A() + 2)
 	)
-	if isInteger(item) { // integer loop?
-		i1 := item.(Int)
-		i2 := upto.(Int)
-		i3 := step.(Int)
+	if item.isInt() { // integer loop?
+		i1 := item.asInt()
+		i2 := upto.asInt()
+		i3 := step.asInt()
 		i1 += i3 // increment index
 		if (i3 > 0 && (i1 <= i2)) || (i3 < 0 && (i1 > i2)) {
-			vm.thread().frame().set(instr.A(), i1)   // update internal index...
-			vm.thread().frame().set(instr.A()+3, i1) // ... and external index
-			vm.thread().frame().step(instr.SBX())    // jump back
+			vm.setReg(instr.A(), intValue(i1))   // update internal index...
+			vm.setReg(instr.A()+3, intValue(i1)) // ... and external index
+			vm.frame().step(instr.SBX())         // jump back
 		}
 	} else { // floating loop
-		f1 := item.(Float)
-		f2 := upto.(Float)
-		f3 := step.(Float)
+		f1 := item.asFloat()
+		f2 := upto.asFloat()
+		f3 := step.asFloat()
 		f1 += f3
 		if (f3 > 0 && (f1 <= f2)) || (f3 < 0 && (f1 > f2)) {
-			vm.thread().frame().set(instr.A(), f1)   // update internal index...
-			vm.thread().frame().set(instr.A()+3, f1) // ... and external index
-			vm.thread().frame().step(instr.SBX())    // jump back
+			vm.setReg(instr.A(), floatValue(f1))   // update internal index...
+			vm.setReg(instr.A()+3, floatValue(f1)) // ... and external index
+			vm.frame().step(instr.SBX())           // jump back
 		}
 	}
 }
@@ -609,40 +572,40 @@ func (vm *v53) forloop(instr vm.Instr) {
 // R(A)-=R(A+2); pc+=sBx
 func (vm *v53) forprep(instr vm.Instr) {
 	var (
-		init = vm.thread().frame().get(instr.A())
-		upto = vm.thread().frame().get(instr.A() + 1)
-		step = vm.thread().frame().get(instr.A() + 2)
+		init = vm.reg(instr.A())
+		upto = vm.reg(instr.A() + 1)
+		step = vm.reg(instr.A() + 2)
 	)
 	// Try for values as integers.
 	var (
-		i1, ok1 = toInteger(init)
-		i2, ok2 = toInteger(upto)
-		i3, ok3 = toInteger(step)
+		i1, ok1 = init.toInteger()
+		i2, ok2 = upto.toInteger()
+		i3, ok3 = step.toInteger()
 	)
 	if ok1 && ok2 && ok3 {
 		// TODO: Try converting forlimit to an integer rounding if possible.
-		vm.thread().frame().set(instr.A(), i1-i3)
-		vm.thread().frame().set(instr.A()+1, i2)
-		vm.thread().frame().set(instr.A()+2, i3)
-		vm.thread().frame().step(instr.SBX())
+		vm.setReg(instr.A(), intValue(i1-i3))
+		vm.setReg(instr.A()+1, intValue(i2))
+		vm.setReg(instr.A()+2, intValue(i3))
+		vm.frame().step(instr.SBX())
 		return
 	}
 	// Try for values as numbers.
-	var f1, f2, f3 Float
-	if f1, ok1 = toFloat(init); !ok1 {
+	var f1, f2, f3 float64
+	if f1, ok1 = init.toFloat(); !ok1 {
 		vm.thread().errorf("'for' init must be a number")
 	}
-	if f2, ok2 = toFloat(upto); !ok2 {
+	if f2, ok2 = upto.toFloat(); !ok2 {
 		vm.thread().errorf("'for' limit must be a number")
 	}
-	if f3, ok3 = toFloat(step); !ok3 {
+	if f3, ok3 = step.toFloat(); !ok3 {
 		vm.thread().errorf("'for' step must be a number")
 	}
 
-	vm.thread().frame().set(instr.A(), f1-f3)
-	vm.thread().frame().set(instr.A()+1, f2)
-	vm.thread().frame().set(instr.A()+2, f3)
-	vm.thread().frame().step(instr.SBX())
+	vm.setReg(instr.A(), floatValue(f1-f3))
+	vm.setReg(instr.A()+1, floatValue(f2))
+	vm.setReg(instr.A()+2, floatValue(f3))
+	vm.frame().step(instr.SBX())
 }
 
 // TFORCALL: Iterate a generic for loop.
@@ -672,22 +635,19 @@ func (vm *v53) tforcall(instr vm.Instr) {
 	)
 
 	var (
-		iter = vm.thread().frame().get(a)     // iterator function
-		data = vm.thread().frame().get(a + 1) // state
-		ctrl = vm.thread().frame().get(a + 2) // control variable / initial value
+		iter = vm.reg(a)     // iterator function
+		data = vm.reg(a + 1) // state
+		ctrl = vm.reg(a + 2) // control variable / initial value
 		base = instr.A() + 3
 	)
 
-	vm.thread().frame().push(iter)
-	vm.thread().frame().push(data)
-	vm.thread().frame().push(ctrl)
+	// The results are returned in the slot of the function.
+	vm.frame().settop(base)
+	vm.frame().pushReg(iter)
+	vm.frame().pushReg(data)
+	vm.frame().pushReg(ctrl)
 
 	vm.thread().Call(2, c)
-
-	rets := vm.thread().frame().popN(c)
-	for i, v := range rets {
-		vm.thread().frame().set(base+i, v)
-	}
 }
 
 // TFORLOOP: Initialization for a generic for loop.
@@ -696,13 +656,13 @@ func (vm *v53) tforcall(instr vm.Instr) {
 //
 // if R(A+1) ~= nil then { R(A)=R(A+1); pc += sBx }
 func (vm *v53) tforloop(instr vm.Instr) {
-	if ctrl := vm.thread().frame().get(instr.A() + 1); !IsNone(ctrl) {
-		vm.thread().frame().set(instr.A(), ctrl)
-		vm.thread().frame().step(instr.SBX())
+	if ctrl := vm.reg(instr.A() + 1); !ctrl.isNone() {
+		vm.setReg(instr.A(), ctrl)
+		vm.frame().step(instr.SBX())
 		return
 	}
 	// loop done, reset top
-	vm.thread().frame().settop(instr.A())
+	vm.frame().settop(instr.A())
 }
 
 // SETLIST: Set a range of array elements for a table.
@@ -717,14 +677,14 @@ func (vm *v53) setlist(instr vm.Instr) {
 		c = instr.C()
 	)
 	if b == 0 {
-		b = vm.thread().frame().gettop() - a - 1
+		b = vm.frame().gettop() - a - 1
 	}
 	o := (c - 1) * FieldsPerFlush
-	t := vm.thread().frame().get(a).(*table)
+	t := vm.reg(a).v.(*table)
 	for i := 1; i <= b; i++ {
-		t.setInt(int64(o+i), vm.thread().frame().get(a+i))
+		t.set(intValue(int64(o+i)), vm.reg(a+i))
 	}
-	vm.thread().frame().popN(b)
+	vm.frame().popN(b)
 }
 
 // CLOSURE: Create a closure of a function prototype.
@@ -733,10 +693,10 @@ func (vm *v53) setlist(instr vm.Instr) {
 //
 // R(A) := closure(KPROTO[Bx])
 func (vm *v53) closure(instr vm.Instr) {
-	cls := newLuaClosure(vm.prototype(instr.BX()))
-	vm.thread().frame().openUp(cls)
-	vm.thread().frame().push(cls)
-	vm.thread().frame().replace(instr.A())
+	cls := newLuaClosure(vm.thread(), vm.prototype(instr.BX()))
+	vm.frame().openUp(cls)
+	vm.frame().push(cls)
+	vm.frame().replace(instr.A())
 	// TODO: caching?
 }
 
@@ -753,11 +713,11 @@ func (vm *v53) vararg(instr vm.Instr) {
 		a = instr.A()
 		b = instr.B()
 	)
-	for i, v := range vm.thread().frame().varargs(b - 1) {
-		if v == nil {
-			v = None
+	for i, v := range vm.frame().varargs(b - 1) {
+		if v.v == nil {
+			v = none
 		}
-		vm.thread().frame().set(a+i, v)
+		vm.setReg(a+i, v)
 	}
 }
 
@@ -772,7 +732,7 @@ func (vm *v53) idiv(instr vm.Instr) {
 		rc = vm.rk(instr.C())
 		ra = vm.thread().arith(OpQuo, rb, rc)
 	)
-	vm.thread().frame().set(instr.A(), ra)
+	vm.setReg(instr.A(), ra)
 }
 
 // BAND: Bit-wise AND operator.
@@ -786,7 +746,7 @@ func (vm *v53) band(instr vm.Instr) {
 		rc = vm.rk(instr.C())
 		ra = vm.thread().arith(OpAnd, rb, rc)
 	)
-	vm.thread().frame().set(instr.A(), ra)
+	vm.setReg(instr.A(), ra)
 }
 
 // BOR: Bit-wise OR operator.
@@ -800,7 +760,7 @@ func (vm *v53) bor(instr vm.Instr) {
 		rc = vm.rk(instr.C())
 		ra = vm.thread().arith(OpOr, rb, rc)
 	)
-	vm.thread().frame().set(instr.A(), ra)
+	vm.setReg(instr.A(), ra)
 }
 
 // BXOR: Bit-wise Exclusive OR operator.
@@ -814,7 +774,7 @@ func (vm *v53) bxor(instr vm.Instr) {
 		rc = vm.rk(instr.C())
 		ra = vm.thread().arith(OpXor, rb, rc)
 	)
-	vm.thread().frame().set(instr.A(), ra)
+	vm.setReg(instr.A(), ra)
 }
 
 // SHL: Shift bits left.
@@ -828,7 +788,7 @@ func (vm *v53) shl(instr vm.Instr) {
 		rc = vm.rk(instr.C())
 		ra = vm.thread().arith(OpLsh, rb, rc)
 	)
-	vm.thread().frame().set(instr.A(), ra)
+	vm.setReg(instr.A(), ra)
 }
 
 // SHR: Shift bits right.
@@ -842,7 +802,7 @@ func (vm *v53) shr(instr vm.Instr) {
 		rc = vm.rk(instr.C())
 		ra = vm.thread().arith(OpRsh, rb, rc)
 	)
-	vm.thread().frame().set(instr.A(), ra)
+	vm.setReg(instr.A(), ra)
 }
 
 // BNOT: Bit-wise NOT operator.
@@ -853,9 +813,9 @@ func (vm *v53) shr(instr vm.Instr) {
 func (vm *v53) bnot(instr vm.Instr) {
 	var (
 		rb = vm.rk(instr.B())
-		ra = vm.thread().arith(OpNot, rb, None)
+		ra = vm.thread().arith(OpNot, rb, none)
 	)
-	vm.thread().frame().set(instr.A(), ra)
+	vm.setReg(instr.A(), ra)
 }
 
 // EXTRAARG: Extra (larger) argument for previous opcode.
@@ -0,0 +1,1212 @@
+package lua
+
+import (
+	"math"
+
+	"github.com/Azure/golua/lua/vm54"
+)
+
+//
+// Implementation of Lua v54 Opcodes
+//
+
+// tm2op maps the metamethod events encoded by the MMBIN instructions
+// to the corresponding arithmetic and bitwise operators.
+var tm2op = [...]Op{
+	6:  OpAdd,
+	7:  OpSub,
+	8:  OpMul,
+	9:  OpMod,
+	10: OpPow,
+	11: OpDiv,
+	12: OpQuo,
+	13: OpAnd,
+	14: OpOr,
+	15: OpXor,
+	16: OpLsh,
+	17: OpRsh,
+}
+
+// arith is the fast path of the arithmetic and bitwise opcodes: if both
+// operands are numbers, it stores the result in R(A) and skips the MMBIN
+// instruction that follows; otherwise the operation is left to it.
+func (vm *v54) arith(instr vm54.Instr, op Op, x, y value) {
+	if x.isNumber() && y.isNumber() {
+		vm.setReg(instr.A(), vm.thread().arith(op, x, y))
+		vm.frame().step(1)
+	}
+}
+
+// closeVars closes the open upvalues and calls the __close metamethod of
+// the to-be-closed variables (in reverse order) at or above register level,
+// passing err as the error object.
+func (vm *v54) closeVars(level int, err Value) {
+	fr := vm.frame()
+	fr.closeUp(level)
+	for n := len(fr.tbc); n > 0 && fr.tbc[n-1] >= level; n-- {
+		obj := vm.reg(fr.tbc[n-1])
+		fr.tbc = fr.tbc[:n-1]
+		tryMetaClose(vm.thread(), obj.box(), err)
+	}
+}
+
+// ret moves the n values returned by the executing function, from
+// register a, onto the caller's stack.
+func (vm *v54) ret(a, n int) {
+	fr := vm.frame()
+	fr.ret(fr.base+a, fr.base+a+n)
+}
+
+// localName returns the name of the active local variable in register
+// reg at the current instruction, or "?" if there is no debug info.
+func (vm *v54) localName(reg int) string {
+	var (
+		fr = vm.frame()
+		pc = fr.pc - 1
+	)
+	for _, local := range fr.closure.binary.Locals {
+		if int(local.Live) > pc {
+			break
+		}
+		if pc < int(local.Dead) {
+			if reg == 0 {
+				return local.Name
+			}
+			reg--
+		}
+	}
+	return "?"
+}
+
+// immediate returns the signed immediate operand sB of a comparison,
+// which is a float if C is set.
+func immediate(instr vm54.Instr) value {
+	if instr.C() != 0 {
+		return floatValue(float64(instr.SB()))
+	}
+	return intValue(int64(instr.SB()))
+}
+
+// MOVE: Copy a value between registers.
+//
+// @args A B
+//
+// R(A) := R(B)
+func (vm *v54) move(instr vm54.Instr) {
+	rb := vm.reg(instr.B())
+	vm.setReg(instr.A(), rb)
+}
+
+// LOADI: Load an integer into a register.
+//
+// @args A sBx
+//
+// R(A) := sBx
+func (vm *v54) loadi(instr vm54.Instr) {
+	vm.setReg(instr.A(), intValue(int64(instr.SBX())))
+}
+
+// LOADF: Load an integral float into a register.
+//
+// @args A sBx
+//
+// R(A) := (lua_Number)sBx
+func (vm *v54) loadf(instr vm54.Instr) {
+	vm.setReg(instr.A(), floatValue(float64(instr.SBX())))
+}
+
+// LOADK: Load a constant into a register.
+//
+// @args A Bx
+//
+// R(A) := K(Bx)
+func (vm *v54) loadk(instr vm54.Instr) {
+	kst := vm.constant(instr.BX())
+	vm.setReg(instr.A(), kst)
+}
+
+// LOADKX: Load a constant into a register. The next 'instruction'
+// is always EXTRAARG.
+//
+// @args A
+//
+// R(A) := K(extra arg)
+func (vm *v54) loadkx(instr vm54.Instr) {
+	extra := vm54.Instr(vm.frame().step(1)).AX()
+	vm.setReg(instr.A(), vm.constant(extra))
+}
+
+// LOADFALSE: Load false into a register.
+//
+// @args A
+//
+// R(A) := false
+func (vm *v54) loadfalse(instr vm54.Instr) {
+	vm.setReg(instr.A(), boolValue(false))
+}
+
+// LFALSESKIP: Load false into a register and skip the next instruction.
+//
+// @args A
+//
+// R(A) := false; pc++
+func (vm *v54) lfalseskip(instr vm54.Instr) {
+	vm.setReg(instr.A(), boolValue(false))
+	vm.frame().step(1)
+}
+
+// LOADTRUE: Load true into a register.
+//
+// @args A
+//
+// R(A) := true
+func (vm *v54) loadtrue(instr vm54.Instr) {
+	vm.setReg(instr.A(), boolValue(true))
+}
+
+// LOADNIL: Load nil values into a range of registers.
+//
+// @args A B
+//
+// R(A), R(A+1), ..., R(A+B) := nil
+func (vm *v54) loadnil(instr vm54.Instr) {
+	var (
+		a = instr.A()
+		b = instr.B()
+	)
+	for i := a; i <= a+b; i++ {
+		vm.setReg(i, nilValue)
+	}
+}
+
+// GETUPVAL: Read an upvalue into a register.
+//
+// @args A B
+//
+// R(A) := UpValue[B]
+func (vm *v54) getupval(instr vm54.Instr) {
+	up := vm.frame().getUp(instr.B())
+	vm.setReg(instr.A(), up.get())
+}
+
+// SETUPVAL: Write a register value into an upvalue.
+//
+// @args A B
+//
+// UpValue[B] := R(A)
+func (vm *v54) setupval(instr vm54.Instr) {
+	ra := vm.reg(instr.A())
+	vm.frame().setUp(instr.B(), ra)
+}
+
+// GETTABUP: Read a field of a table in an upvalue into a register (globals).
+//
+// @args A B C
+//
+// R(A) := UpValue[B][K(C):string]
+func (vm *v54) gettabup(instr vm54.Instr) {
+	up := vm.frame().getUp(instr.B()).get()
+	vm.setReg(instr.A(), vm.field(up, instr.C()))
+}
+
+// GETTABLE: Read a table element into a register.
+//
+// @args A B C
+//
+// R(A) := R(B)[R(C)]
+func (vm *v54) gettable(instr vm54.Instr) {
+	var (
+		rb = vm.reg(instr.B())
+		rc = vm.reg(instr.C())
+	)
+	vm.setReg(instr.A(), vm.thread().gettable(rb, rc, false))
+}
+
+// GETI: Read an integer indexed table element into a register.
+//
+// @args A B C
+//
+// R(A) := R(B)[C]
+func (vm *v54) geti(instr vm54.Instr) {
+	rb := vm.reg(instr.B())
+	vm.setReg(instr.A(), vm.thread().gettable(rb, intValue(int64(instr.C())), false))
+}
+
+// GETFIELD: Read a field of a table into a register.
+//
+// @args A B C
+//
+// R(A) := R(B)[K(C):string]
+func (vm *v54) getfield(instr vm54.Instr) {
+	rb := vm.reg(instr.B())
+	vm.setReg(instr.A(), vm.field(rb, instr.C()))
+}
+
+// SETTABUP: Write a value into a field of a table in an upvalue (globals).
+//
+// @args A B C k
+//
+// UpValue[A][K(B):string] := RK(C)
+func (vm *v54) settabup(instr vm54.Instr) {
+	up := vm.frame().getUp(instr.A()).get()
+	vm.thread().settable(up, vm.constant(instr.B()), vm.rk(instr), false)
+}
+
+// SETTABLE: Write a value into a table element.
+//
+// @args A B C k
+//
+// R(A)[R(B)] := RK(C)
+func (vm *v54) settable(instr vm54.Instr) {
+	var (
+		ra = vm.reg(instr.A())
+		rb = vm.reg(instr.B())
+	)
+	vm.thread().settable(ra, rb, vm.rk(instr), false)
+}
+
+// SETI: Write a value into an integer indexed table element.
+//
+// @args A B C k
+//
+// R(A)[B] := RK(C)
+func (vm *v54) seti(instr vm54.Instr) {
+	ra := vm.reg(instr.A())
+	vm.thread().settable(ra, intValue(int64(instr.B())), vm.rk(instr), false)
+}
+
+// SETFIELD: Write a value into a field of a table.
+//
+// @args A B C k
+//
+// R(A)[K(B):string] := RK(C)
+func (vm *v54) setfield(instr vm54.Instr) {
+	ra := vm.reg(instr.A())
+	vm.thread().settable(ra, vm.constant(instr.B()), vm.rk(instr), false)
+}
+
+// NEWTABLE: Create a new table.
+//
+// B is the size of the hash part, encoded as its base 2 logarithm plus
+// one (0 for an empty hash part); C is the size of the array part. If k
+// is set, the following EXTRAARG holds the higher bits of the array size.
+// The next instruction is always EXTRAARG.
+//
+// @args A B C k
+//
+// R(A) := {}
+func (vm *v54) newtable(instr vm54.Instr) {
+	var (
+		b = instr.B()
+		c = instr.C()
+	)
+	if b > 0 {
+		b = 1 << uint(b-1)
+	}
+	extra := vm54.Instr(vm.frame().step(1)).AX()
+	if instr.K() == 1 {
+		c += extra * (vm54.MaxArgC + 1)
+	}
+	vm.setReg(instr.A(), value{v: newTable(vm.thread(), c, b)})
+}
+
+// SELF: Prepare an object method for calling.
+//
+// @args A B C k
+//
+// R(A+1) := R(B); R(A) := R(B)[RK(C):string]
+func (vm *v54) self(instr vm54.Instr) {
+	var (
+		obj = vm.reg(instr.B())
+		fn  value
+	)
+	if instr.K() == 1 {
+		fn = vm.field(obj, instr.C())
+	} else {
+		fn = vm.thread().gettable(obj, vm.rk(instr), false)
+	}
+	vm.setReg(instr.A()+1, obj)
+	vm.setReg(instr.A(), fn)
+}
+
+// ADDI: Addition with an immediate operand.
+//
+// @args A B sC
+//
+// R(A) := R(B) + sC
+func (vm *v54) addi(instr vm54.Instr) {
+	rb := vm.reg(instr.B())
+	vm.arith(instr, OpAdd, rb, intValue(int64(instr.SC())))
+}
+
+// ADDK: Addition with a constant operand.
+//
+// @args A B C
+//
+// R(A) := R(B) + K(C):number
+func (vm *v54) addk(instr vm54.Instr) {
+	rb := vm.reg(instr.B())
+	vm.arith(instr, OpAdd, rb, vm.constant(instr.C()))
+}
+
+// SUBK: Subtraction with a constant operand.
+//
+// @args A B C
+//
+// R(A) := R(B) - K(C):number
+func (vm *v54) subk(instr vm54.Instr) {
+	rb := vm.reg(instr.B())
+	vm.arith(instr, OpSub, rb, vm.constant(instr.C()))
+}
+
+// MULK: Multiplication with a constant operand.
+//
+// @args A B C
+//
+// R(A) := R(B) * K(C):number
+func (vm *v54) mulk(instr vm54.Instr) {
+	rb := vm.reg(instr.B())
+	vm.arith(instr, OpMul, rb, vm.constant(instr.C()))
+}
+
+// MODK: Modulus with a constant operand.
+//
+// @args A B C
+//
+// R(A) := R(B) % K(C):number
+func (vm *v54) modk(instr vm54.Instr) {
+	rb := vm.reg(instr.B())
+	vm.arith(instr, OpMod, rb, vm.constant(instr.C()))
+}
+
+// POWK: Exponentiation with a constant operand.
+//
+// @args A B C
+//
+// R(A) := R(B) ^ K(C):number
+func (vm *v54) powk(instr vm54.Instr) {
+	rb := vm.reg(instr.B())
+	vm.arith(instr, OpPow, rb, vm.constant(instr.C()))
+}
+
+// DIVK: Division with a constant operand.
+//
+// @args A B C
+//
+// R(A) := R(B) / K(C):number
+func (vm *v54) divk(instr vm54.Instr) {
+	rb := vm.reg(instr.B())
+	vm.arith(instr, OpDiv, rb, vm.constant(instr.C()))
+}
+
+// IDIVK: Integer division with a constant operand.
+//
+// @args A B C
+//
+// R(A) := R(B) // K(C):number
+func (vm *v54) idivk(instr vm54.Instr) {
+	rb := vm.reg(instr.B())
+	vm.arith(instr, OpQuo, rb, vm.constant(instr.C()))
+}
+
+// BANDK: Bit-wise AND with a constant operand.
+//
+// @args A B C
+//
+// R(A) := R(B) & K(C):integer
+func (vm *v54) bandk(instr vm54.Instr) {
+	rb := vm.reg(instr.B())
+	vm.arith(instr, OpAnd, rb, vm.constant(instr.C()))
+}
+
+// BORK: Bit-wise OR with a constant operand.
+//
+// @args A B C
+//
+// R(A) := R(B) | K(C):integer
+func (vm *v54) bork(instr vm54.Instr) {
+	rb := vm.reg(instr.B())
+	vm.arith(instr, OpOr, rb, vm.constant(instr.C()))
+}
+
+// BXORK: Bit-wise exclusive OR with a constant operand.
+//
+// @args A B C
+//
+// R(A) := R(B) ~ K(C):integer
+func (vm *v54) bxork(instr vm54.Instr) {
+	rb := vm.reg(instr.B())
+	vm.arith(instr, OpXor, rb, vm.constant(instr.C()))
+}
+
+// SHRI: Shift bits right by an immediate operand.
+//
+// @args A B sC
+//
+// R(A) := R(B) >> sC
+func (vm *v54) shri(instr vm54.Instr) {
+	rb := vm.reg(instr.B())
+	vm.arith(instr, OpRsh, rb, intValue(int64(instr.SC())))
+}
+
+// SHLI: Shift an immediate operand left.
+//
+// @args A B sC
+//
+// R(A) := sC << R(B)
+func (vm *v54) shli(instr vm54.Instr) {
+	rb := vm.reg(instr.B())
+	vm.arith(instr, OpLsh, intValue(int64(instr.SC())), rb)
+}
+
+// binary performs the arithmetic operation op over registers R(B)
+// and R(C).
+func (vm *v54) binary(instr vm54.Instr, op Op) {
+	var (
+		rb = vm.reg(instr.B())
+		rc = vm.reg(instr.C())
+	)
+	vm.arith(instr, op, rb, rc)
+}
+
+// ADD: Addition operator.
+//
+// @args A B C
+//
+// R(A) := R(B) + R(C)
+func (vm *v54) add(instr vm54.Instr) { vm.binary(instr, OpAdd) }
+
+// SUB: Subtraction operator.
+//
+// @args A B C
+//
+// R(A) := R(B) - R(C)
+func (vm *v54) sub(instr vm54.Instr) { vm.binary(instr, OpSub) }
+
+// MUL: Multiplication operator.
+//
+// @args A B C
+//
+// R(A) := R(B) * R(C)
+func (vm *v54) mul(instr vm54.Instr) { vm.binary(instr, OpMul) }
+
+// MOD: Modulus (remainder) operator.
+//
+// @args A B C
+//
+// R(A) := R(B) % R(C)
+func (vm *v54) mod(instr vm54.Instr) { vm.binary(instr, OpMod) }
+
+// POW: Exponentation operator.
+//
+// @args A B C
+//
+// R(A) := R(B) ^ R(C)
+func (vm *v54) pow(instr vm54.Instr) { vm.binary(instr, OpPow) }
+
+// DIV: Division operator.
+//
+// @args A B C
+//
+// R(A) := R(B) / R(C)
+func (vm *v54) div(instr vm54.Instr) { vm.binary(instr, OpDiv) }
+
+// IDIV: Integer division operator.
+//
+// @args A B C
+//
+// R(A) := R(B) // R(C)
+func (vm *v54) idiv(instr vm54.Instr) { vm.binary(instr, OpQuo) }
+
+// BAND: Bit-wise AND operator.
+//
+// @args A B C
+//
+// R(A) := R(B) & R(C)
+func (vm *v54) band(instr vm54.Instr) { vm.binary(instr, OpAnd) }
+
+// BOR: Bit-wise OR operator.
+//
+// @args A B C
+//
+// R(A) := R(B) | R(C)
+func (vm *v54) bor(instr vm54.Instr) { vm.binary(instr, OpOr) }
+
+// BXOR: Bit-wise exclusive OR operator.
+//
+// @args A B C
+//
+// R(A) := R(B) ~ R(C)
+func (vm *v54) bxor(instr vm54.Instr) { vm.binary(instr, OpXor) }
+
+// SHL: Shift bits left.
+//
+// @args A B C
+//
+// R(A) := R(B) << R(C)
+func (vm *v54) shl(instr vm54.Instr) { vm.binary(instr, OpLsh) }
+
+// SHR: Shift bits right.
+//
+// @args A B C
+//
+// R(A) := R(B) >> R(C)
+func (vm *v54) shr(instr vm54.Instr) { vm.binary(instr, OpRsh) }
+
+// MMBIN: Call a metamethod over two registers.
+//
+// MMBIN follows an arithmetic or bitwise instruction whose operands
+// are not both numbers; C is the metamethod event and the result is
+// stored in the register A of that previous instruction.
+//
+// @args A B C
+//
+// call C metamethod over R(A) and R(B)
+func (vm *v54) mmbin(instr vm54.Instr) {
+	var (
+		fr = vm.frame()
+		pi = vm54.Instr(fr.code(fr.pc - 2))
+		ra = vm.reg(instr.A())
+		rb = vm.reg(instr.B())
+	)
+	vm.setReg(pi.A(), vm.thread().arith(tm2op[instr.C()], ra, rb))
+}
+
+// MMBINI: Call a metamethod over a register and an immediate operand.
+//
+// If k is set, the operands are flipped.
+//
+// @args A sB C k
+//
+// call C metamethod over R(A) and sB
+func (vm *v54) mmbini(instr vm54.Instr) {
+	var (
+		fr = vm.frame()
+		pi = vm54.Instr(fr.code(fr.pc - 2))
+		x  = vm.reg(instr.A())
+		y  = intValue(int64(instr.SB()))
+	)
+	if instr.K() == 1 {
+		x, y = y, x
+	}
+	vm.setReg(pi.A(), vm.thread().arith(tm2op[instr.C()], x, y))
+}
+
+// MMBINK: Call a metamethod over a register and a constant.
+//
+// If k is set, the operands are flipped.
+//
+// @args A B C k
+//
+// call C metamethod over R(A) and K(B)
+func (vm *v54) mmbink(instr vm54.Instr) {
+	var (
+		fr = vm.frame()
+		pi = vm54.Instr(fr.code(fr.pc - 2))
+		x  = vm.reg(instr.A())
+		y  = vm.constant(instr.B())
+	)
+	if instr.K() == 1 {
+		x, y = y, x
+	}
+	vm.setReg(pi.A(), vm.thread().arith(tm2op[instr.C()], x, y))
+}
+
+// UNM: Unary minus.
+//
+// @args A B
+//
+// R(A) := -R(B)
+func (vm *v54) unm(instr vm54.Instr) {
+	rb := vm.reg(instr.B())
+	vm.setReg(instr.A(), vm.thread().arith(OpMinus, rb, none))
+}
+
+// BNOT: Bit-wise NOT operator.
+//
+// @args A B
+//
+// R(A) := ~R(B)
+func (vm *v54) bnot(instr vm54.Instr) {
+	rb := vm.reg(instr.B())
+	vm.setReg(instr.A(), vm.thread().arith(OpNot, rb, none))
+}
+
+// NOT: Logical NOT operator.
+//
+// @args A B
+//
+// R(A) := not R(B)
+func (vm *v54) not(instr vm54.Instr) {
+	rb := vm.reg(instr.B())
+	vm.setReg(instr.A(), boolValue(!rb.truth()))
+}
+
+// LEN: Length operator.
+//
+// @args A B
+//
+// R(A) := #R(B) (length operator)
+func (vm *v54) length(instr vm54.Instr) {
+	rb := vm.reg(instr.B())
+	vm.setReg(instr.A(), vm.thread().length(rb))
+}
+
+// CONCAT: Concatenate a range of registers.
+//
+// @args A B
+//
+// R(A) := R(A).. ... ..R(A + B - 1)
+func (vm *v54) concat(instr vm54.Instr) {
+	var (
+		a = instr.A()
+		b = instr.B()
+	)
+	vm.frame().settop(a + b)
+	vm.thread().Concat(b)
+}
+
+// CLOSE: Close upvalues and to-be-closed variables.
+//
+// @args A
+//
+// close all upvalues >= R(A)
+func (vm *v54) close(instr vm54.Instr) {
+	vm.closeVars(instr.A(), Nil(1))
+}
+
+// TBC: Mark a variable as to-be-closed.
+//
+// The value must have a __close metamethod, or be nil or false.
+//
+// @args A
+//
+// mark variable A "to be closed"
+func (vm *v54) tbc(instr vm54.Instr) {
+	ra := vm.reg(instr.A())
+	if !ra.truth() { // nil and false are ignored
+		return
+	}
+	if IsNone(vm.thread().metafield(ra.box(), "__close")) {
+		vm.thread().errorf("variable '%s' got a non-closable value", vm.localName(instr.A()))
+	}
+	fr := vm.frame()
+	fr.tbc = append(fr.tbc, instr.A())
+}
+
+// JMP: Unconditional jump.
+//
+// @args sJ
+//
+// pc += sJ
+func (vm *v54) jmp(instr vm54.Instr) {
+	vm.frame().step(instr.SJ())
+}
+
+// cond goes on after a test with the jump that follows it: if cond matches
+// k, the jump is executed in place, and counted as executed; otherwise it is
+// skipped.
+func (vm *v54) cond(instr vm54.Instr, cond bool) {
+	fr := vm.frame()
+	switch next := fr.code(fr.pc); {
+	case cond != (instr.K() == 1):
+		fr.pc++
+	case vm54.Instr(next).Code() == vm54.JMP:
+		fr.pc++
+		vm.thread().global.used++
+		vm.jmp(vm54.Instr(next))
+	}
+}
+
+// EQ: Equality test, with conditional jump.
+//
+// @args A B k
+//
+// if ((R(A) == R(B)) ~= k) then pc++
+func (vm *v54) eq(instr vm54.Instr) {
+	var (
+		ra = vm.reg(instr.A())
+		rb = vm.reg(instr.B())
+	)
+	vm.cond(instr, vm.thread().compare(OpEq, ra, rb, false))
+}
+
+// LT: Less than test, with conditional jump.
+//
+// @args A B k
+//
+// if ((R(A) <  R(B)) ~= k) then pc++
+func (vm *v54) lt(instr vm54.Instr) {
+	var (
+		ra = vm.reg(instr.A())
+		rb = vm.reg(instr.B())
+	)
+	vm.cond(instr, vm.thread().compare(OpLt, ra, rb, false))
+}
+
+// LE: Less than or equal to test, with conditional jump.
+//
+// @args A B k
+//
+// if ((R(A) <= R(B)) ~= k) then pc++
+func (vm *v54) le(instr vm54.Instr) {
+	var (
+		ra = vm.reg(instr.A())
+		rb = vm.reg(instr.B())
+	)
+	vm.cond(instr, vm.thread().compare(OpLe, ra, rb, false))
+}
+
+// EQK: Equality test against a constant, with conditional jump.
+//
+// @args A B k
+//
+// if ((R(A) == K(B)) ~= k) then pc++
+func (vm *v54) eqk(instr vm54.Instr) {
+	var (
+		ra = vm.reg(instr.A())
+		kb = vm.constant(instr.B())
+	)
+	vm.cond(instr, vm.thread().compare(OpEq, ra, kb, true))
+}
+
+// EQI: Equality test against an immediate, with conditional jump.
+//
+// @args A sB C k
+//
+// if ((R(A) == sB) ~= k) then pc++
+func (vm *v54) eqi(instr vm54.Instr) {
+	ra := vm.reg(instr.A())
+	vm.cond(instr, vm.thread().compare(OpEq, ra, immediate(instr), true))
+}
+
+// LTI: Less than test against an immediate, with conditional jump.
+//
+// @args A sB C k
+//
+// if ((R(A) < sB) ~= k) then pc++
+func (vm *v54) lti(instr vm54.Instr) {
+	ra := vm.reg(instr.A())
+	vm.cond(instr, vm.thread().compare(OpLt, ra, immediate(instr), false))
+}
+
+// LEI: Less than or equal to test against an immediate, with conditional jump.
+//
+// @args A sB C k
+//
+// if ((R(A) <= sB) ~= k) then pc++
+func (vm *v54) lei(instr vm54.Instr) {
+	ra := vm.reg(instr.A())
+	vm.cond(instr, vm.thread().compare(OpLe, ra, immediate(instr), false))
+}
+
+// GTI: Greater than test against an immediate, with conditional jump.
+//
+// @args A sB C k
+//
+// if ((R(A) > sB) ~= k) then pc++
+func (vm *v54) gti(instr vm54.Instr) {
+	ra := vm.reg(instr.A())
+	vm.cond(instr, vm.thread().compare(OpLt, immediate(instr), ra, false))
+}
+
+// GEI: Greater than or equal to test against an immediate, with conditional jump.
+//
+// @args A sB C k
+//
+// if ((R(A) >= sB) ~= k) then pc++
+func (vm *v54) gei(instr vm54.Instr) {
+	ra := vm.reg(instr.A())
+	vm.cond(instr, vm.thread().compare(OpLe, immediate(instr), ra, false))
+}
+
+// TEST: Boolean test, with conditional jump.
+//
+// @args A k
+//
+// if (not R(A) == k) then pc++
+func (vm *v54) test(instr vm54.Instr) {
+	vm.cond(instr, vm.reg(instr.A()).truth())
+}
+
+// TESTSET: Boolean test, with conditional jump and assignment.
+//
+// @args A B k
+//
+// if (not R(B) == k) then pc++ else R(A) := R(B)
+func (vm *v54) testset(instr vm54.Instr) {
+	rb := vm.reg(instr.B())
+	if rb.truth() == (instr.K() == 1) {
+		vm.setReg(instr.A(), rb)
+	}
+	vm.cond(instr, rb.truth())
+}
+
+// CALL: Calls a function.
+//
+// Register R(A) holds the function to be called and its arguments
+// follow it. B and C encode the number of arguments and results as
+// in the 5.3 engine; B == 0 passes up to 'top' and C == 0 leaves the
+// results up to 'top'.
+//
+// @args A B C
+//
+// R(A), ... ,R(A+C-2) := R(A)(R(A+1), ... ,R(A+B-1))
+func (vm *v54) call(instr vm54.Instr) {
+	var (
+		a = instr.A()
+		b = instr.B()
+		c = instr.C()
+	)
+	// arguments
+	if b != 0 {
+		vm.frame().settop(a + b)
+		vm.thread().Call(b-1, c-1)
+	} else {
+		vm.thread().Call(vm.frame().gettop()-a-1, c-1)
+	}
+	// returns are in R(A), ... ,R(A+C-2)
+	// C=0 so return values indicated by 'top'
+}
+
+// TAILCALL: Perform a tail call.
+//
+// B encodes the number of arguments as in CALL; all results are kept
+// for the RETURN that follows. If k is set, the upvalues of the frame
+// are closed before the call. C is not used by this engine.
+//
+// @args A B C k
+//
+// return R(A)(R(A+1), ... ,R(A+B-1))
+func (vm *v54) tailcall(instr vm54.Instr) {
+	var (
+		a    = instr.A()
+		b    = instr.B()
+		args = b - 1
+	)
+	if b == 0 {
+		args = vm.frame().gettop() - a - 1
+	}
+	if instr.K() == 1 {
+		vm.frame().closeUp(0)
+	}
+	if !vm.thread().tailcall(a, args) {
+		// Go function: call it as usual, RETURN passes its results on.
+		vm.frame().settop(a + args + 1)
+		vm.thread().Call(args, MultRets)
+	}
+}
+
+// RETURN: Returns from function call.
+//
+// If B == 0, the values range from R(A) to the top of the stack; otherwise
+// there are B-1 values. If k is set, the upvalues and to-be-closed variables
+// of the frame are closed first. C is not used by this engine.
+//
+// @args A B C k
+//
+// return R(A), ... ,R(A+B-2)
+func (vm *v54) returns(instr vm54.Instr) {
+	var (
+		fr = vm.frame()
+		a  = instr.A()
+		n  = instr.B() - 1
+	)
+	if n < 0 {
+		n = fr.gettop() - a
+	}
+	if instr.K() == 1 {
+		// Protect the results from the __close metamethods.
+		if fr.gettop() < a+n {
+			fr.settop(a + n)
+		}
+		vm.closeVars(0, Nil(1))
+	}
+	vm.ret(a, n)
+}
+
+// RETURN0: Returns from function call with no values.
+//
+// @args
+//
+// return
+func (vm *v54) return0(instr vm54.Instr) { vm.ret(0, 0) }
+
+// RETURN1: Returns from function call with one value.
+//
+// @args A
+//
+// return R(A)
+func (vm *v54) return1(instr vm54.Instr) {
+	vm.ret(instr.A(), 1)
+}
+
+// forlimit converts the limit of an integer loop to an integer, preserving
+// the semantics of the loop, and reports whether the loop must be skipped.
+//
+// A float limit is rounded down (or up for negative steps); a limit out of
+// the integer range is clipped to it, unless it means the loop cannot run.
+func (vm *v54) forlimit(init int64, limit value, step int64) (int64, bool) {
+	lim := limit.asInt()
+	if !limit.isInt() {
+		f, ok := limit.toFloat()
+		if !ok {
+			vm.thread().errorf("'for' limit must be a number")
+		}
+		if step < 0 {
+			f = math.Ceil(f)
+		} else {
+			f = math.Floor(f)
+		}
+		switch {
+		case f >= -(1<<63) && f < (1<<63):
+			lim = int64(f)
+		case f > 0: // too large
+			if step < 0 {
+				return 0, true
+			}
+			lim = math.MaxInt64
+		default: // too small (or NaN)
+			if step > 0 {
+				return 0, true
+			}
+			lim = math.MinInt64
+		}
+	}
+	if step > 0 {
+		return lim, init > lim
+	}
+	return lim, init < lim
+}
+
+// FORPREP: Initialization for a numeric for loop.
+//
+// For an integer loop, the iteration count is precomputed into R(A+1)
+// so that the loop cannot overflow. If the loop must not run, FORPREP
+// jumps past the matching FORLOOP.
+//
+// @args A Bx
+//
+// <check values and prepare counters>; if not to run then pc+=Bx+1
+func (vm *v54) forprep(instr vm54.Instr) {
+	var (
+		fr   = vm.frame()
+		a    = instr.A()
+		init = vm.reg(a)
+		upto = vm.reg(a + 1)
+		step = vm.reg(a + 2)
+	)
+	if init.isInt() && step.isInt() { // integer loop?
+		i1, i3 := init.asInt(), step.asInt()
+		if i3 == 0 {
+			vm.thread().errorf("'for' step is zero")
+		}
+		vm.setReg(a+3, init) // control variable
+		limit, skip := vm.forlimit(i1, upto, i3)
+		if skip {
+			fr.step(instr.BX() + 1)
+			return
+		}
+		var count uint64
+		if i3 > 0 {
+			count = uint64(limit) - uint64(i1)
+			if i3 != 1 {
+				count /= uint64(i3)
+			}
+		} else {
+			count = uint64(i1) - uint64(limit)
+			count /= uint64(-(i3 + 1)) + 1
+		}
+		vm.setReg(a+1, intValue(int64(count)))
+		return
+	}
+	// Try for values as floats.
+	f2, ok := upto.toFloat()
+	if !ok {
+		vm.thread().errorf("'for' limit must be a number")
+	}
+	f3, ok := step.toFloat()
+	if !ok {
+		vm.thread().errorf("'for' step must be a number")
+	}
+	f1, ok := init.toFloat()
+	if !ok {
+		vm.thread().errorf("'for' initial value must be a number")
+	}
+	if f3 == 0 {
+		vm.thread().errorf("'for' step is zero")
+	}
+	if (f3 > 0 && f2 < f1) || (f3 < 0 && f1 < f2) {
+		fr.step(instr.BX() + 1)
+		return
+	}
+	vm.setReg(a, floatValue(f1))
+	vm.setReg(a+1, floatValue(f2))
+	vm.setReg(a+2, floatValue(f3))
+	vm.setReg(a+3, floatValue(f1))
+}
+
+// FORLOOP: Iterate a numeric for loop.
+//
+// @args A Bx
+//
+// update counters; if loop continues then pc-=Bx
+func (vm *v54) forloop(instr vm54.Instr) {
+	var (
+		fr = vm.frame()
+		a  = instr.A()
+	)
+	if step := vm.reg(a + 2); step.isInt() { // integer loop?
+		if count := vm.reg(a + 1).asInt(); count != 0 {
+			i := intValue(vm.reg(a).asInt() + step.asInt())
+			vm.setReg(a+1, intValue(count-1))
+			vm.setReg(a, i)   // update internal index...
+			vm.setReg(a+3, i) // ... and external index
+			fr.step(-instr.BX())
+		}
+		return
+	}
+	var (
+		f1 = vm.reg(a).asFloat()
+		f2 = vm.reg(a + 1).asFloat()
+		f3 = vm.reg(a + 2).asFloat()
+	)
+	if f1 += f3; (f3 > 0 && f1 <= f2) || (f3 <= 0 && f2 <= f1) {
+		vm.setReg(a, floatValue(f1))   // update internal index...
+		vm.setReg(a+3, floatValue(f1)) // ... and external index
+		fr.step(-instr.BX())
+	}
+}
+
+// TFORPREP: Initialization for a generic for loop.
+//
+// R(A+3) is the loop's closing value, which is marked to-be-closed.
+//
+// @args A Bx
+//
+// create upvalue for R(A + 3); pc+=Bx
+func (vm *v54) tforprep(instr vm54.Instr) {
+	vm.tbc(vm54.MakeABC(vm54.TBC, instr.A()+3, 0, 0))
+	vm.frame().step(instr.BX())
+}
+
+// TFORCALL: Call the iterator of a generic for loop.
+//
+// R(A) is the iterator function, R(A+1) is the state and R(A+2)
+// is the control variable; the results are stored in the loop
+// variables, from R(A+4) up to R(A+3+C).
+//
+// @args A C
+//
+// R(A+4), ... ,R(A+3+C) := R(A)(R(A+1), R(A+2))
+func (vm *v54) tforcall(instr vm54.Instr) {
+	var (
+		fr   = vm.frame()
+		a    = instr.A()
+		iter = vm.reg(a)     // iterator function
+		data = vm.reg(a + 1) // state
+		ctrl = vm.reg(a + 2) // control variable
+	)
+	fr.settop(a + 4)
+	fr.pushReg(iter)
+	fr.pushReg(data)
+	fr.pushReg(ctrl)
+	vm.thread().Call(2, instr.C())
+}
+
+// TFORLOOP: Iterate a generic for loop.
+//
+// @args A Bx
+//
+// if R(A+4) ~= nil then { R(A+2)=R(A+4); pc -= Bx }
+func (vm *v54) tforloop(instr vm54.Instr) {
+	if ctrl := vm.reg(instr.A() + 4); !ctrl.isNone() {
+		vm.setReg(instr.A()+2, ctrl)
+		vm.frame().step(-instr.BX())
+	}
+}
+
+// SETLIST: Set a range of array elements for a table.
+//
+// If B == 0, the values range from R(A+1) to the top of the stack.
+// If k is set, the following EXTRAARG holds the higher bits of C.
+//
+// @args A B C k
+//
+// R(A)[C+i] := R(A+i), 1 <= i <= B
+func (vm *v54) setlist(instr vm54.Instr) {
+	var (
+		fr = vm.frame()
+		a  = instr.A()
+		n  = instr.B()
+		c  = instr.C()
+	)
+	if n == 0 {
+		n = fr.gettop() - a - 1
+	}
+	if instr.K() == 1 {
+		c += vm54.Instr(fr.step(1)).AX() * (vm54.MaxArgC + 1)
+	}
+	t := vm.reg(a).v.(*table)
+	for i := 1; i <= n; i++ {
+		t.set(intValue(int64(c+i)), vm.reg(a+i))
+	}
+	fr.settop(a + 1)
+}
+
+// CLOSURE: Create a closure of a function prototype.
+//
+// @args A Bx
+//
+// R(A) := closure(KPROTO[Bx])
+func (vm *v54) closure(instr vm54.Instr) {
+	cls := newLuaClosure(vm.thread(), vm.prototype(instr.BX()))
+	vm.frame().openUp(cls)
+	vm.setReg(instr.A(), value{v: cls})
+}
+
+// VARARG: Assign vararg function arguments to registers.
+//
+// If C == 0, load all varargs up to 'top'.
+// If C >= 1, load C-1 varargs.
+//
+// @args A C
+//
+// R(A), R(A+1), ..., R(A+C-2) = vararg
+func (vm *v54) vararg(instr vm54.Instr) {
+	var (
+		fr = vm.frame()
+		a  = instr.A()
+		n  = instr.C() - 1
+	)
+	switch {
+	case n == 0:
+		return
+	case n < 0:
+		fr.settop(a)
+	}
+	for i, v := range fr.varargs(n) {
+		if v.v == nil {
+			v = none
+		}
+		vm.setReg(a+i, v)
+	}
+}
+
+// VARARGPREP: Adjust the varargs of a vararg function.
+//
+// The arguments are already split into fixed parameters and varargs
+// when the frame is entered, so this is a no-op.
+//
+// @args A
+//
+// (adjust vararg parameters)
+func (vm *v54) varargprep(instr vm54.Instr) {}
+
+// EXTRAARG: Extra (larger) argument for previous opcode.
+//
+// @args Ax
+func (vm *v54) extraarg(instr vm54.Instr) {
+	// This op func should never execute directly.
+	unimplemented(instr.String())
+}
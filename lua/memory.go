@@ -0,0 +1,300 @@
+package lua
+
+import (
+	"errors"
+	"runtime"
+)
+
+// ErrMemory is the error raised when the memory used by a state would exceed
+// its limit (see WithMemoryLimit). Unlike a *BudgetError, it is a regular Lua
+// error, caught by pcall with the message "not enough memory".
+var ErrMemory = errors.New("not enough memory")
+
+// Approximate sizes, in bytes, of the objects accounted for in the memory
+// usage of a state.
+const (
+	sizeValue   = 16  // a slot of a stack, an array part or an upvalue
+	sizeEntry   = 48  // a key/value pair of a hash part
+	sizeString  = 16  // a string header, plus its bytes
+	sizeTable   = 96  // an empty table, plus its array and hash parts
+	sizeClosure = 48  // a closure, plus its upvalues
+	sizeUpValue = 48  // an upvalue
+	sizeObject  = 32  // a userdata, not counting its Go value
+	sizeThread  = 256 // a thread, plus its stack
+)
+
+// memoryStep is the minimum number of bytes allocated between two counts of
+// the memory in use when the state has no memory limit, with the default step
+// multiplier (see SetGCStepMul).
+const memoryStep = 1 << 20
+
+// Default pause and step multiplier of the collector, in percents, as in Lua
+// 5.3: the memory is counted again when it doubles.
+const (
+	defaultPause   = 200
+	defaultStepMul = 200
+)
+
+// MemoryUsage returns an estimate of the number of bytes used by the tables,
+// strings, closures, userdata and threads of the state.
+//
+// The estimate adds up the objects created, and is brought back to the
+// objects still reachable from the registry, the metatables and the running
+// threads each time it doubles or reaches the memory limit; so, as in Lua,
+// it includes the garbage made since the last count.
+func (state *State) MemoryUsage() int64 { return state.global.mem }
+
+// CollectGarbage counts the memory in use (see MemoryUsage), leaving out the
+// objects that are no longer reachable, after running the Go garbage collector;
+// then it calls the __gc metamethods of those marked for finalization.
+func (state *State) CollectGarbage() {
+	runtime.GC()
+	state.collect(0, true)
+	state.callFinalizers()
+}
+
+// StopGC stops the automatic counts of the memory in use, which separate the
+// objects to be finalized and clear the weak values no longer reachable (see
+// measure), and the calls of the pending __gc metamethods, until RestartGC is
+// called. The memory is still counted when it reaches the limit, and by
+// CollectGarbage and StepGC.
+//
+// The Go garbage collector still removes from weak tables the objects which
+// are no longer referenced.
+func (state *State) StopGC() {
+	g := state.global
+	g.gcstop = true
+	g.threshold()
+}
+
+// RestartGC restarts the automatic counts of the memory in use (see StopGC).
+func (state *State) RestartGC() {
+	g := state.global
+	g.gcstop = false
+	g.threshold()
+}
+
+// IsGCRunning reports whether the automatic counts of the memory in use are
+// running, i.e. not stopped (see StopGC).
+func (state *State) IsGCRunning() bool { return !state.global.gcstop }
+
+// StepGC performs a step of the collector as if kb kilobytes were allocated,
+// and reports whether it counted the memory in use, as CollectGarbage does;
+// with 0, it always does.
+func (state *State) StepGC(kb int) bool {
+	g := state.global
+	if kb > 0 && int64(kb) < (g.nextmem-g.mem)>>10 {
+		g.nextmem -= int64(kb) << 10
+		return false
+	}
+	state.CollectGarbage()
+	return true
+}
+
+// SetGCPause sets the pause of the collector and returns the previous one: the
+// memory in use is counted again when it reaches pause percents of the last
+// count (200 by default, when it doubles).
+func (state *State) SetGCPause(pause int) int {
+	g := state.global
+	prev := int(g.pause)
+	g.pause = int64(max(pause, 0))
+	g.threshold()
+	return prev
+}
+
+// SetGCStepMul sets the step multiplier of the collector and returns the
+// previous one: the larger it is, the less memory is allocated between two
+// counts of the memory in use when it is small (200 by default, 40 at least).
+func (state *State) SetGCStepMul(stepmul int) int {
+	g := state.global
+	prev := int(g.stepmul)
+	g.stepmul = int64(max(stepmul, 40))
+	g.threshold()
+	return prev
+}
+
+// CheckMemory raises ErrMemory if allocating n more bytes would exceed the
+// memory limit of the state. Go functions call it before making large strings
+// or tables, which are accounted for when they are pushed or created.
+func (state *State) CheckMemory(n int64) {
+	if g := state.global; n > g.maxmem-g.mem {
+		state.collect(n, !g.gcstop)
+	}
+}
+
+// alloc adds n bytes to the estimate of the memory in use, counting it again
+// when it reaches the next count.
+func (state *State) alloc(n int64) {
+	g := state.global
+	if g.mem+n > g.nextmem {
+		state.collect(n, !g.gcstop)
+	}
+	g.mem += n
+}
+
+// collect counts the memory in use, fully or not (see measure), and raises
+// ErrMemory if n more bytes do not fit within the limit; then it sets the
+// estimate at which to count again.
+//
+// The memory is not counted while NewState sets up the state.
+func (state *State) collect(n int64, full bool) {
+	g := state.global
+	if g.mem = state.measure(full); n > g.maxmem-g.mem {
+		g.nextmem = g.mem // count again on the next allocation
+		state.panic(ErrMemory)
+	}
+	g.threshold()
+}
+
+// threshold sets the estimate of the memory in use at which to count it again,
+// from the pause and step multiplier; or at the limit if the counts are stopped.
+func (g *global) threshold() {
+	if g.gcstop {
+		g.nextmem = g.maxmem
+		return
+	}
+	step := memoryStep * defaultStepMul / g.stepmul
+	g.nextmem = min64(max(g.mem/100*g.pause, g.mem+step), g.maxmem)
+}
+
+// measure returns the memory used by the objects reachable from the registry,
+// the metatables of the basic types, the thread and its resumers, and the
+// objects to be finalized. A full count also clears the weak values, and
+// separates the objects to be finalized and the suspended coroutines which
+// are no longer reachable.
+func (state *State) measure(full bool) (size int64) {
+	var (
+		g    = state.global
+		seen = make(map[Value]bool)
+		gray []Value
+		weak []*weakTable // tables with weak values
+	)
+	mark := func(v Value) {
+		switch v := v.(type) {
+		case String:
+			size += sizeString + int64(len(v))
+		case *table:
+			if v != nil && !seen[v] {
+				seen[v] = true
+				gray = append(gray, v)
+			}
+		case *Closure, *Object, *thread:
+			if !seen[v] {
+				seen[v] = true
+				gray = append(gray, v)
+			}
+		}
+	}
+	mark(g.registry)
+	for _, meta := range g.builtins {
+		mark(meta)
+	}
+	for ls := state; ls != nil; ls = ls.resumer() {
+		mark(ls.thread)
+	}
+	for _, obj := range g.tobefnz {
+		mark(obj)
+	}
+	propagate := func() {
+		for len(gray) > 0 {
+			v := gray[len(gray)-1]
+			gray = gray[:len(gray)-1]
+			if eph := ephemeronsOf(v); eph != nil {
+				for _, e := range *eph {
+					mark(e.v)
+				}
+			}
+			switch v := v.(type) {
+			case *table:
+				size += sizeTable + int64(cap(v.list))*sizeValue + int64(len(v.nodes))*sizeEntry
+				mark(v.meta)
+				for _, e := range v.list {
+					mark(e.v)
+				}
+				for _, n := range v.nodes {
+					if !n.val.isNone() {
+						mark(n.key)
+						mark(n.val.v)
+					}
+				}
+				if w := v.weak; w != nil { // only the strong references
+					size += int64(len(w.slots)) * sizeEntry
+					if w.mode&weakValues != 0 {
+						weak = append(weak, w)
+					}
+					for _, n := range w.slots {
+						mark(n.key)
+						mark(n.val.v)
+					}
+				}
+			case *Closure:
+				size += sizeClosure + int64(len(v.upvals))*sizeUpValue
+				for _, up := range v.upvals {
+					if up != nil {
+						mark(up.get().v)
+					}
+				}
+			case *Object:
+				size += sizeObject
+				mark(v.meta)
+			case *thread:
+				ls := v.State
+				size += sizeThread + int64(len(ls.stack))*sizeValue
+				if fr := ls.frame(); fr != nil {
+					for _, e := range ls.stack[:fr.top] {
+						mark(e.v)
+					}
+				}
+				for fr := ls.base.next; fr != &ls.base; fr = fr.next {
+					if fr.closure != nil {
+						mark(fr.closure)
+					}
+				}
+			}
+		}
+	}
+	propagate()
+	if !full {
+		return size
+	}
+
+	// As in Lua, the weak values no longer reachable are cleared before the
+	// objects which refer to them are finalized.
+	for _, w := range weak {
+		w.clearValues(seen)
+	}
+
+	// The objects marked for finalization which are not reachable are
+	// separated to be finalized; they and the objects they refer to stay
+	// reachable until their __gc metamethods are called.
+	for _, obj := range g.separate(seen) {
+		mark(obj)
+	}
+	propagate()
+
+	// The suspended coroutines no longer reachable are released with the
+	// objects to be finalized.
+	for _, ls := range g.threads {
+		if !seen[ls.thread] && ls.co.from == nil {
+			g.tobefnz = append(g.tobefnz, ls.thread)
+			g.next = g.used
+		}
+	}
+	return size
+}
+
+// resumer returns the thread that resumed the coroutine of the state while it
+// runs, or nil.
+func (state *State) resumer() *State {
+	if co := state.co; co != nil {
+		return co.from
+	}
+	return nil
+}
+
+func min64(a, b int64) int64 {
+	if a < b {
+		return a
+	}
+	return b
+}
@@ -77,88 +77,56 @@ const (
 // See https://www.lua.org/manual/5.3/manual.html#3.4.5
 //
 // Returns true if "x op y"; otherwise false.
-func (state *State) compare(op Op, x, y Value, raw bool) bool {
+func (state *State) compare(op Op, x, y value, raw bool) bool {
+	if x.isNumber() && y.isNumber() {
+		return compareNumbers(op, x, y)
+	}
+
 	// metamethod event to call if type op type pairs are exhausted.
 	var event metaEvent
 
 	switch op {
 	case OpEq: // '=='
-		if x.Type() != y.Type() {
-			if IsNone(x) {
-				return IsNone(y)
+		if x.typ() != y.typ() {
+			if x.isNone() {
+				return y.isNone()
 			}
 			return false
 		}
-		switch x := x.(type) {
+		switch x := x.v.(type) {
 		case *Closure:
-			if y, ok := y.(*Closure); ok {
+			if y, ok := y.v.(*Closure); ok {
 				if x.isLua() && y.isLua() {
 					return x.binary == y.binary
 				}
 				return true
 			}
 		case *Object:
-			if y, ok := y.(*Object); ok {
+			if y, ok := y.v.(*Object); ok {
 				return x.data == y.data
 			}
 		case *table:
-			if y, ok := y.(*table); ok && (x == y) {
+			if y, ok := y.v.(*table); ok && (x == y) {
 				return true
 			}
+		case *thread:
+			if y, ok := y.v.(*thread); ok {
+				return x == y
+			}
 		case String:
 			// x (string) == y (string)
-			if y, ok := y.(String); ok {
+			if y, ok := y.v.(String); ok {
 				return x == y
 			}
 		case Bool:
 			// x (boolean) == y (boolean)
-			if y, ok := y.(Bool); ok {
+			if y, ok := y.v.(Bool); ok {
 				return x == y
 			}
-		case Number:
-			if x, ok := x.(Int); ok {
-				if y, ok := y.(Int); ok {
-					return x == y
-				}
-			}
-			if x, ok := x.(Float); ok {
-				if y, ok := y.(Float); ok {
-					return x == y
-				}
-			}
-			x, ok1 := toInteger(x)
-			y, ok2 := toInteger(y)
-			return ok1 && ok2 && (x == y)
-
-		// case Float:
-		// // x (float) == y (float)
-		// if y, ok := y.(Float); ok {
-		//     return x == y
-		// }
-		// // x (float) == y (int)
-		// if y, ok := y.(Int); ok {
-		//     i, ok := toInteger(x)
-		//     if ok {
-		//         return i == y
-		//     }
-		// }
-		// case Int:
-		//     // x (int) == y (float)
-		//     if y, ok := y.(Float); ok {
-		//         i, ok := toInteger(y)
-		//         if ok {
-		//             return x == i
-		//         }
-		//     }
-		//     // x (int) == y (int)
-		//     if y, ok := y.(Int); ok {
-		//         return x == y
-		//     }
-
 		case Nil:
 			// x (nil) == y (none)
 			// x (nil) == y (nil)
-			if IsNone(y) {
+			if y.isNone() {
 				return true
 			}
 		}
@@ -167,46 +135,9 @@ func (state *State) compare(op Op, x, y Value, raw bool) bool {
 		event = metaEq
 
 	case OpLe: // '<='
-		switch x := x.(type) {
-		case String:
-			// x (string) <= y (string)
-			if y, ok := y.(String); ok {
-				return x <= y
-			}
-		case Float:
-			// x (float) <= y (float)
-			if y, ok := y.(Float); ok {
-				return x <= y
-			}
-			// x (float) <= y (int)
-			if y, ok := y.(Int); ok {
-				var cmp int
-				switch {
-				case !math.IsInf(float64(x), 0): // x is finite
-					cmp = x.rational().Cmp(y.rational())
-				case x > 0: // x is +inf
-					cmp = -1
-				default: // x is -inf
-					cmp = +1
-				}
-				return threeway(op, cmp)
-			}
-		case Int:
-			// x (int) <= y (float)
-			if y, ok := y.(Float); ok {
-				var cmp int
-				switch {
-				case !math.IsInf(float64(y), 0): // y is finite
-					cmp = x.rational().Cmp(y.rational())
-				case y > 0: // y is +inf
-					cmp = -1
-				default: // y is -inf
-					cmp = +1
-				}
-				return threeway(op, cmp)
-			}
-			// x (int) <= y (int)
-			if y, ok := y.(Int); ok {
+		// x (string) <= y (string)
+		if x, ok := x.v.(String); ok {
+			if y, ok := y.v.(String); ok {
 				return x <= y
 			}
 		}
@@ -214,46 +145,9 @@ func (state *State) compare(op Op, x, y Value, raw bool) bool {
 		event = metaLe
 
 	case OpLt: // '<'
-		switch x := x.(type) {
-		case String:
-			// x (string) < y (string)
-			if y, ok := y.(String); ok {
-				return x < y
-			}
-		case Float:
-			// x (float) < y (float)
-			if y, ok := y.(Float); ok {
-				return x < y
-			}
-			// x (float) < y (int)
-			if y, ok := y.(Int); ok {
-				var cmp int
-				switch {
-				case !math.IsInf(float64(x), 0): // x is finite
-					cmp = x.rational().Cmp(y.rational())
-				case x > 0: // x is +inf
-					cmp = -1
-				default: // x is -inf
-					cmp = +1
-				}
-				return threeway(op, cmp)
-			}
-		case Int:
-			// x (int) < y (float)
-			if y, ok := y.(Float); ok {
-				var cmp int
-				switch {
-				case !math.IsInf(float64(y), 0): // y is finite
-					cmp = x.rational().Cmp(y.rational())
-				case y > 0: // y is +inf
-					cmp = -1
-				default: // y is -inf
-					cmp = +1
-				}
-				return threeway(op, cmp)
-			}
-			// x (int) < y (int)
-			if y, ok := y.(Int); ok {
+		// x (string) < y (string)
+		if x, ok := x.v.(String); ok {
+			if y, ok := y.v.(String); ok {
 				return x < y
 			}
 		}
@@ -262,7 +156,7 @@ func (state *State) compare(op Op, x, y Value, raw bool) bool {
 	}
 	if !raw {
 		// try metamethod event
-		val, err := tryMetaCompare(state, x, y, event)
+		val, err := tryMetaCompare(state, x.box(), y.box(), event)
 		if err != nil {
 			panic(runtimeErr(err))
 		}
@@ -271,6 +165,43 @@ func (state *State) compare(op Op, x, y Value, raw bool) bool {
 	return false
 }
 
+// compareNumbers applies the relational operator ==, < or <= to the numbers
+// x and y, according to their mathematical values: an integer and a float
+// are compared exactly, without converting one to the other.
+func compareNumbers(op Op, x, y value) bool {
+	switch {
+	case x.isInt() && y.isInt():
+		switch i, j := x.asInt(), y.asInt(); op {
+		case OpLt:
+			return i < j
+		case OpLe:
+			return i <= j
+		case OpEq:
+			return i == j
+		}
+	case x.isFloat() && y.isFloat():
+		switch f, g := x.asFloat(), y.asFloat(); op {
+		case OpLt:
+			return f < g
+		case OpLe:
+			return f <= g
+		case OpEq:
+			return f == g
+		}
+	case x.isInt():
+		// x (int) op y (float)
+		if f := y.asFloat(); !math.IsNaN(f) {
+			return threeway(op, cmpIntFloat(x.asInt(), f))
+		}
+	default:
+		// x (float) op y (int)
+		if f := x.asFloat(); !math.IsNaN(f) {
+			return threeway(op, -cmpIntFloat(y.asInt(), f))
+		}
+	}
+	return false
+}
+
 // arith performs an arithmetic or bitwise operation.
 //
 // # Arithmetic Operators (See https://www.lua.org/manual/5.3/manual.html#3.4.1)
@@ -306,7 +237,7 @@ func (state *State) compare(op Op, x, y Value, raw bool) bool {
 // in an integer result in zero (as all bits are shifted out).
 //
 // Returns the value and nil if successful; otherwise nil and the error.
-func (state *State) arith(op Op, x, y Value) Value {
+func (state *State) arith(op Op, x, y value) value {
 	// metamethod event to call if type op type pairs are exhausted.
 	var event metaEvent
 
@@ -315,116 +246,111 @@ func (state *State) arith(op Op, x, y Value) Value {
 	// Arithmetic Operators
 	//
 	case OpAdd: // '+'
-		if isInteger(x) && isInteger(y) {
-			return x.(Int) + y.(Int)
+		if x.isInt() && y.isInt() {
+			return intValue(x.asInt() + y.asInt())
 		}
-		if n1, ok := toFloat(x); ok {
-			if n2, ok := toFloat(y); ok {
-				return n1 + n2
+		if n1, ok := x.toFloat(); ok {
+			if n2, ok := y.toFloat(); ok {
+				return floatValue(n1 + n2)
 			}
 		}
 		// try __add
 		event = metaAdd
 
 	case OpSub: // '-'
-		if isInteger(x) && isInteger(y) {
-			return x.(Int) - y.(Int)
+		if x.isInt() && y.isInt() {
+			return intValue(x.asInt() - y.asInt())
 		}
-		if n1, ok := toFloat(x); ok {
-			if n2, ok := toFloat(y); ok {
-				return n1 - n2
+		if n1, ok := x.toFloat(); ok {
+			if n2, ok := y.toFloat(); ok {
+				return floatValue(n1 - n2)
 			}
 		}
 		// try __sub
 		event = metaSub
 
 	case OpMul: // '*'
-		if isInteger(x) && isInteger(y) {
-			return x.(Int) * y.(Int)
+		if x.isInt() && y.isInt() {
+			return intValue(x.asInt() * y.asInt())
 		}
-		if n1, ok := toFloat(x); ok {
-			if n2, ok := toFloat(y); ok {
-				return n1 * n2
+		if n1, ok := x.toFloat(); ok {
+			if n2, ok := y.toFloat(); ok {
+				return floatValue(n1 * n2)
 			}
 		}
 		// try __mul
 		event = metaMul
 
 	case OpMod: // '%'
-		if isInteger(x) && isInteger(y) {
-			m, _ := toInteger(x)
-			n, _ := toInteger(y)
+		if x.isInt() && y.isInt() {
+			m, n := x.asInt(), y.asInt()
 			if n == 0 {
 				panic(fmt.Errorf("attempt to perform n%%0"))
 			}
 			if n == -1 {
-				return Int(0)
+				return intValue(0)
 			}
 			r := m % n
 			if r != 0 && (m^n) < 0 {
 				r += n
 			}
-			return r
+			return intValue(r)
 		}
-		if n1, ok := toFloat(x); ok {
-			if n2, ok := toFloat(y); ok {
-				r := math.Mod(float64(n1), float64(n2))
-				return Float(r)
+		if n1, ok := x.toFloat(); ok {
+			if n2, ok := y.toFloat(); ok {
+				return floatValue(math.Mod(n1, n2))
 			}
 		}
 		// try __mod
 		event = metaMod
 
 	case OpQuo: // '/'
-		if isInteger(x) && isInteger(y) {
-			m, _ := toInteger(x)
-			n, _ := toInteger(y)
+		if x.isInt() && y.isInt() {
+			m, n := x.asInt(), y.asInt()
 			if n == 0 {
 				panic(fmt.Errorf("attempt to divide by zero"))
 			}
 			if n == -1 {
-				return m
+				return intValue(m)
 			}
 			q := m / n
 			if (m^n) < 0 && m%n != 0 {
 				q -= 1
 			}
-			return q
+			return intValue(q)
 		}
-		if n1, ok := toFloat(x); ok {
-			if n2, ok := toFloat(y); ok {
-				r := math.Floor(float64(n1 / n2))
-				return Float(r)
+		if n1, ok := x.toFloat(); ok {
+			if n2, ok := y.toFloat(); ok {
+				return floatValue(math.Floor(n1 / n2))
 			}
 		}
 		// try __idiv
 		event = metaIdiv
 
 	case OpDiv: // '//'
-		if n1, ok := toFloat(x); ok {
-			if n2, ok := toFloat(y); ok {
-				return n1 / n2
+		if n1, ok := x.toFloat(); ok {
+			if n2, ok := y.toFloat(); ok {
+				return floatValue(n1 / n2)
 			}
 		}
 		// try __div
 		event = metaDiv
 
 	case OpPow: // '^'
-		if n1, ok := toFloat(x); ok {
-			if n2, ok := toFloat(y); ok {
-				r := math.Pow(float64(n1), float64(n2))
-				return Float(r)
+		if n1, ok := x.toFloat(); ok {
+			if n2, ok := y.toFloat(); ok {
+				return floatValue(math.Pow(n1, n2))
 			}
 		}
 		// try __pow
 		event = metaPow
 
 	case OpMinus: // '-' (unary)
-		if isInteger(x) {
-			return -(x.(Int))
+		if x.isInt() {
+			return intValue(-x.asInt())
 		}
-		if n, ok := toFloat(x); ok {
-			return -n
+		if n, ok := x.toFloat(); ok {
+			return floatValue(-n)
 		}
 		// try __unm
 		event = metaUnm
@@ -433,63 +359,63 @@ func (state *State) arith(op Op, x, y Value) Value {
 	// Bitwise Operators (Integers only)
 	//
 	case OpOr: // '|'
-		if i1, ok := toInteger(x); ok {
-			if i2, ok := toInteger(y); ok {
-				return i1 | i2
+		if i1, ok := x.toInteger(); ok {
+			if i2, ok := y.toInteger(); ok {
+				return intValue(i1 | i2)
 			}
 		}
 		// try __bor
 		event = metaBor
 
 	case OpAnd: // '&'
-		if i1, ok := toInteger(x); ok {
-			if i2, ok := toInteger(y); ok {
-				return i1 & i2
+		if i1, ok := x.toInteger(); ok {
+			if i2, ok := y.toInteger(); ok {
+				return intValue(i1 & i2)
 			}
 		}
 		// try __band
 		event = metaBand
 
 	case OpXor: // '~'
-		if i1, ok := toInteger(x); ok {
-			if i2, ok := toInteger(y); ok {
-				return i1 ^ i2
+		if i1, ok := x.toInteger(); ok {
+			if i2, ok := y.toInteger(); ok {
+				return intValue(i1 ^ i2)
 			}
 		}
 		// try __bxor
 		event = metaBxor
 
 	case OpRsh: // '>>'
-		if i1, ok := toInteger(x); ok {
-			if i2, ok := toInteger(y); ok {
-				return shiftRight(i1, i2)
+		if i1, ok := x.toInteger(); ok {
+			if i2, ok := y.toInteger(); ok {
+				return intValue(int64(shiftRight(Int(i1), Int(i2))))
 			}
 		}
 		// try __shr
 		event = metaShr
 
 	case OpLsh: // '<<'
-		if i1, ok := toInteger(x); ok {
-			if i2, ok := toInteger(y); ok {
-				return shiftLeft(i1, i2)
+		if i1, ok := x.toInteger(); ok {
+			if i2, ok := y.toInteger(); ok {
+				return intValue(int64(shiftLeft(Int(i1), Int(i2))))
 			}
 		}
 		// try __shl
 		event = metaShl
 
 	case OpNot: // '~' (unary)
-		if i1, ok := toInteger(x); ok {
-			return ^i1
+		if i1, ok := x.toInteger(); ok {
+			return intValue(^i1)
 		}
 		// try __bnot
 		event = metaBnot
 	}
 	// try metamethod event
-	val, err := tryMetaBinary(state, x, y, event)
+	val, err := tryMetaBinary(state, x.box(), y.box(), event)
 	if err != nil {
 		panic(runtimeErr(err))
 	}
-	return val
+	return unbox(val)
 }
 
 // length returns the length of the object.
@@ -525,16 +451,16 @@ func (state *State) arith(op Op, x, y Value) Value {
 // metamethod (see §2.4).
 //
 // See https://www.lua.org/manual/5.3/manual.html#3.4.7
-func (state *State) length(obj Value) Value {
-	if str, ok := obj.(String); ok {
-		return Int(len(str))
+func (state *State) length(obj value) value {
+	if str, ok := obj.v.(String); ok {
+		return intValue(int64(len(str)))
 	}
-	val, err := tryMetaLength(state, obj)
+	val, err := tryMetaLength(state, obj.box())
 	if err == nil {
-		return val
+		return unbox(val)
 	}
-	if tbl, ok := obj.(*table); ok {
-		return Int(tbl.length())
+	if tbl, ok := obj.v.(*table); ok {
+		return intValue(int64(tbl.length()))
 	}
 	panic(runtimeErr(err))
 }
@@ -549,12 +475,14 @@ func (state *State) concat(values []Value) Value {
 			if _, ok := rhs.(String); ok {
 				s1, _ := toString(lhs)
 				s2, _ := toString(rhs)
+				state.alloc(sizeString + int64(len(s1)+len(s2)))
 				rhs = String(s1 + s2)
 				continue
 			}
 			if _, ok := rhs.(Number); ok {
 				s1, _ := toString(lhs)
 				s2, _ := toString(rhs)
+				state.alloc(sizeString + int64(len(s1)+len(s2)))
 				rhs = String(s1 + s2)
 				continue
 			}
@@ -13,7 +13,10 @@ import (
 // This function never shrinks the stack; if the stack already has space for the extra slots, it
 // is left unchanged.
 func (state *State) CheckStack(needed int) bool {
-	return state.frame().checkstack(needed)
+	if fr := state.frame(); fr.top+needed <= state.global.config.maxstack {
+		return fr.checkstack(needed)
+	}
+	return false
 }
 
 // AbsIndex converts the acceptable index idx into an equivalent absolute index;
@@ -26,7 +29,7 @@ func (state *State) AbsIndex(index int) int {
 func (state *State) DumpStack(w io.Writer) {
 	if fr := state.frame(); fr != nil {
 		for i := fr.gettop() - 1; i >= 0; i-- {
-			fmt.Fprintf(w, "[%d] %v\n", i+1, fr.locals[i])
+			fmt.Fprintf(w, "[%d] %v\n", i+1, fr.locals()[i])
 		}
 	}
 }
@@ -125,11 +128,11 @@ func (state *State) PushGlobals() {
 //
 // See https://www.lua.org/manual/5.3/manual.html#lua_pushcclosure
 func (state *State) PushClosure(fn Func, nups uint8) {
-	cls := newGoClosure(fn, int(nups))
+	cls := newGoClosure(state, fn, int(nups))
 	for nups > 0 {
 		cls.upvals[nups-1] = &upValue{
 			index: -1,
-			value: state.Pop(),
+			value: unbox(state.Pop()),
 		}
 		nups--
 	}
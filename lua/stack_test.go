@@ -0,0 +1,103 @@
+package lua
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestStack(t *testing.T) {
+	var tests = []struct {
+		source string
+		result string
+	}{
+		// upvalues of a returned closure outlive the frame's registers
+		{"local function mk(n) local x = n; return function() x = x + 1; return x end end; local f, g = mk(1), mk(10); f(); return f() + g()", "14"},
+		// extra results do not clobber the captured locals of the callee
+		{"local function gen(n) return function() n = n - 1; return n end end; local f, g, h = gen(3); return f()", "2"},
+		// locals above the results are closed before they are discarded
+		{"local function mk() local t = {}; local function g(x) return x * 2 end; function t.f(x) return g(x) end; return t end; return mk().f(21)", "42"},
+		// upvalues stay attached to their register while the stack grows
+		{"local x = 1; local function f() return x end; local function deep(n) if n == 0 then x = 2; return f() end; local a, b, c, d = 1, 2, 3, 4; return (deep(n - 1)) end; return deep(100)", "2"},
+		{"local function f(...) local a, b = ...; return select('#', ...), a, b end; local n, a, b = f(1, nil, 3); return n + a", "4"},
+		{"local function f(a, ...) local function g(...) return ... end; return g(...) end; return select('#', f(1, 2, 3, nil))", "3"},
+		{"local function f(a, b, c) return c end; return f(1, 2) == nil", "true"},
+		{"local function f() return 1, 2, 3 end; local t = {f(), f()}; return #t", "4"},
+	}
+	for _, version := range []LuaVersion{V53, V54} {
+		for _, test := range tests {
+			state := newTestState(WithVersion(version))
+			if err := run(t, state, test.source, 1); err != nil {
+				t.Errorf("exec %q (%v): %v", test.source, version, err)
+				continue
+			}
+			if got := state.ToStringMeta(-1); got != test.result {
+				t.Errorf("exec %q (%v): got %s, want %s", test.source, version, got, test.result)
+			}
+		}
+	}
+}
+
+func TestStackOverflow(t *testing.T) {
+	var tests = []struct {
+		source string
+		opts   []Option
+		result string
+	}{
+		{"local function f(n) if n > 0 then return 1 + f(n - 1) end return 0 end; return f(100)", nil, "100"},
+		{"local function f(n) if n > 0 then return 1 + f(n - 1) end return 0 end; return f(100000)", nil, "100000"},
+		{"local function f() return 1 + f() end; return select(2, pcall(f))", nil, "stack overflow"},
+		{"local function f(n) if n > 0 then return 1 + f(n - 1) end return 0 end; return f(5000)", []Option{WithCallLimit(10000)}, "5000"},
+		{"local function f(n) if n > 0 then return 1 + f(n - 1) end return 0 end; return select(2, pcall(f, 5000))", []Option{WithCallLimit(1000)}, "stack overflow"},
+		// through Go functions and metamethods
+		{"local function f() return call(f) end; return select(2, pcall(f))", nil, "stack overflow"},
+		{"local function f(n) if n > 0 then return call(f, n - 1) end return n end; return f(150)", nil, "0"},
+		{"local function f(n) if n > 0 then return call(f, n - 1) end return n end; return select(2, pcall(f, 1000))", []Option{WithCallLimit(1 << 20)}, "stack overflow"},
+		{"local t = setmetatable({}, {__index = function(t, k) return t[k] end}); return select(2, pcall(function() return t.x end))", nil, "stack overflow"},
+		{"local function f() return tostring(setmetatable({}, {__tostring = f})) end; return select(2, pcall(f))", nil, "stack overflow"},
+		// on the size of the stack
+		{"local function f(...) return f(1, ...) end; return select(2, pcall(f))", []Option{WithStackLimit(1000)}, "stack overflow"},
+		{"local function f(n, ...) if n > 0 then return f(n - 1, 1, ...) end return select('#', ...) end; return f(300)", []Option{WithStackLimit(1000)}, "300"},
+		{"return select(2, pcall(call, unpack, 1e6))", []Option{WithStackLimit(1000)}, "too many results"},
+	}
+	for _, version := range []LuaVersion{V53, V54} {
+		for _, test := range tests {
+			state := newTestState(append(test.opts, WithVersion(version))...)
+			if err := run(t, state, test.source, 1); err != nil {
+				t.Errorf("exec %q (%v): %v", test.source, version, err)
+				continue
+			}
+			if got := state.ToStringMeta(-1); !strings.HasPrefix(got, test.result) {
+				t.Errorf("exec %q (%v): got %s, want %s", test.source, version, got, test.result)
+			}
+		}
+	}
+}
+
+func BenchmarkCall(b *testing.B) {
+	var benchmarks = []struct {
+		name   string
+		source string
+	}{
+		{"lua", "local function f(a, b) return a end; for i = 1, 1000 do f(i, i) end"},
+		{"go", "for i = 1, 1000 do id(i, i) end"},
+		{"vararg", "local function f(...) return ... end; for i = 1, 1000 do f(i, i) end"},
+		{"fib", "local function fib(n) if n < 2 then return n end return fib(n - 1) + fib(n - 2) end; fib(15)"},
+	}
+	for _, version := range []LuaVersion{V53, V54} {
+		for _, bench := range benchmarks {
+			b.Run(version.String()+"/"+bench.name, func(b *testing.B) {
+				state := NewState(WithVersion(version))
+				state.Register("id", func(state *State) int { return 1 })
+				if err := state.LoadText(bench.source); err != nil {
+					b.Fatal(err)
+				}
+				b.ReportAllocs()
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					state.PushIndex(-1)
+					state.Call(0, 0)
+				}
+			})
+		}
+	}
+}
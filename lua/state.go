@@ -1,12 +1,11 @@
 package lua
 
 import (
+	"bytes"
+	"context"
 	"fmt"
-	"io/ioutil"
+	"math"
 	"os"
-	"os/exec"
-	"path/filepath"
-	"strings"
 
 	"github.com/Azure/golua/lua/binary"
 	"github.com/Azure/golua/lua/syntax"
@@ -14,8 +13,28 @@ import (
 
 type runtimeErr error
 
-// version number for this Lua implementation.
-var version = float64(503)
+// luaError is the error raised with an error object which is not a string
+// (see Error): the catchers of the error get the object itself.
+type luaError struct {
+	value Value
+}
+
+func (e *luaError) Error() string { return fmt.Sprintf("%v", e.value) }
+
+// errorObject returns the error object of err: the value it was raised with,
+// or its message.
+func errorObject(err error) Value {
+	if e, ok := err.(*luaError); ok {
+		return e.value
+	}
+	return String(err.Error())
+}
+
+// version numbers for this Lua implementation.
+var (
+	version   = float64(503)
+	version54 = float64(504)
+)
 
 // ThreadStatus is a Lua thread status.
 type ThreadStatus int
@@ -43,18 +62,27 @@ func (status ThreadStatus) String() string {
 // Lua execution thread.
 type thread struct {
 	*State
+	eph ephemerons
 }
 
-func (x *thread) String() string { return "thread" }
+func (x *thread) String() string { return fmt.Sprintf("thread: %p", x.State) }
 func (x *thread) Type() Type     { return ThreadType }
 
 type (
 	// 'per thread' state.
 	State struct {
 		// shared global state
-		global *global
-		base   Frame // base call frame
-		calls  int   // call count
+		global   *global
+		stack    []value      // values of all the call frames
+		base     Frame        // base call frame
+		calls    int          // call count
+		maxcalls int          // max call count, less the resumers' (see Resume)
+		gocalls  int          // # of calls made by Go functions running
+		maxgo    int          // max Go call count, less the resumers'
+		thread   *thread      // thread value
+		status   ThreadStatus // thread status
+		co       *coroutine   // coroutine context; nil for the main thread
+		nny      int          // # of non-yieldable calls running
 	}
 
 	// 'global state', shared by all threads of a main state.
@@ -65,6 +93,22 @@ type (
 		thread0  *State
 		config   *config
 		panicFn  Func
+		ctx      context.Context // context of the running PCallContext
+		used     int64           // # of instructions used
+		limit    int64           // instruction budget
+		next     int64           // # of instructions at next budget and context check
+		mem      int64           // estimate of the # of bytes in use (see MemoryUsage)
+		maxmem   int64           // memory limit
+		nextmem  int64           // estimate at next count of the memory in use
+		finobj   []Value         // objects marked for finalization, in order
+		tobefnz  []Value         // objects to be finalized (see callFinalizers)
+		threads  []*State        // coroutines holding a goroutine (see Resume)
+		infin    bool            // calling the __gc metamethods
+		closed   bool            // closed state (see Close)
+		warnFn   WarnFunc        // warning function (see SetWarnFunc)
+		gcstop   bool            // automatic counts of the memory in use stopped (see StopGC)
+		pause    int64           // pause of the collector (see SetGCPause)
+		stepmul  int64           // step multiplier of the collector (see SetGCStepMul)
 	}
 )
 
@@ -75,28 +119,51 @@ func NewState(opts ...Option) *State {
 	for _, opt := range opts {
 		opt(&cfg)
 	}
+	if cfg.maxcalls <= 0 {
+		cfg.maxcalls = MaxCalls
+	}
+	if cfg.maxstack <= 0 {
+		cfg.maxstack = DefaultStackMax
+	}
 
 	// Lua execution state.
 	state := new(State).reset()
+	state.maxcalls = cfg.maxcalls
+	state.maxgo = MaxGoCalls
+
+	// Initialize the global state.
+	state.enter(new(Frame))
+	state.init(&global{
+		version: &version,
+		thread0: state,
+		config:  &cfg,
+	})
+	g := state.global
+	if cfg.version == V54 {
+		g.version = &version54
+	}
+	if g.limit = cfg.limit; cfg.limit <= 0 {
+		g.limit = math.MaxInt64
+	}
+	g.schedule()
+	if g.maxmem = cfg.maxmem; cfg.maxmem <= 0 {
+		g.maxmem = math.MaxInt64
+	}
+	g.pause, g.stepmul = defaultPause, defaultStepMul
+	g.nextmem = math.MaxInt64 // no count until the registry is set up
 
 	// Set up registry & globals table.
 	var (
 		registry = newTable(state, 8, 0)
 		globals  = newTable(state, 0, 20)
-		thread   = &thread{state}
+		thread   = &thread{State: state}
 	)
 	// Initialize registry.
+	state.thread = thread
 	registry.setInt(MainThreadIndex, thread)
 	registry.setInt(GlobalsIndex, globals)
-
-	// Initialize the global state.
-	state.enter(new(Frame))
-	state.init(&global{
-		registry: registry,
-		version:  &version,
-		thread0:  state,
-		config:   &cfg,
-	})
+	g.registry = registry
+	g.threshold()
 
 	return state
 }
@@ -151,8 +218,15 @@ func (state *State) enter(fr *Frame) *Frame {
 	return fr
 }
 
-// leave leaves the current frame.
+// leave leaves the current frame, closing its open upvalues and clearing
+// its stack slots above the caller's top.
 func (state *State) leave(fr *Frame) *Frame {
+	if fr.up != nil {
+		fr.closeUp(0)
+	}
+	if top := fr.prev.top; top < fr.top {
+		clear(state.stack[top:fr.top])
+	}
 	fr.prev.next = fr.next
 	fr.next.prev = fr.prev
 	fr.next = nil // avoid memory leaks
@@ -183,6 +257,32 @@ func (state *State) frame() *Frame {
 	return state.base.prev
 }
 
+// grow reallocates the stack with room for at least size values. The slots
+// of the frames are indices in the stack, so they remain valid.
+//
+// Raises a "stack overflow" error if size exceeds the limit of the state.
+func (state *State) grow(size int) {
+	limit := DefaultStackMax
+	if state.global != nil {
+		limit = state.global.config.maxstack
+	}
+	if size > limit {
+		state.overflow()
+	}
+	size = min(max(2*len(state.stack), size, InitialStackNew), limit)
+	if state.global != nil {
+		state.alloc(int64(size-len(state.stack)) * sizeValue)
+	}
+	stack := make([]value, size)
+	copy(stack, state.stack)
+	state.stack = stack
+}
+
+// overflow raises a "stack overflow" error, with a traceback of the thread.
+func (state *State) overflow() {
+	state.errorf("%s", state.traceback("stack overflow", 0))
+}
+
 // ensure ensures the call frame stack is initialized.
 func (state *State) ensure() {
 	if state.base.next == nil {
@@ -224,140 +324,208 @@ func (state *State) isValid(index int) bool {
 // On return, all the results are on the stack, starting at the original function position.
 func (state *State) call(fr *Frame) {
 	// Check that we are below the recursion / call max.
-	if state.calls >= MaxCalls {
-		state.Errorf("go: call stack overflow")
+	if state.calls >= state.maxcalls {
+		state.overflow()
 	}
-
-	// Ensure stack space for new call frame.
-	fr.checkstack(InitialStackNew)
-
-	// Push arguments and pop function.
-	args := state.frame().popN(state.frame().gettop() - fr.fnID + 1)[1:]
+	state.checkContext()
+
+	// The arguments become the locals of the new frame, and the function
+	// is popped from the caller's.
+	caller := state.frame()
+	if !caller.function().isLua() { // called by Go (see MaxGoCalls)
+		if state.gocalls >= state.maxgo {
+			state.overflow()
+		}
+		state.gocalls++
+		defer func() { state.gocalls-- }()
+	}
+	fr.base = caller.base + fr.fnID
+	fr.top = caller.top
+	caller.top = fr.base - 1
 
 	// Enter and leave frame on return.
 	defer state.leave(state.enter(fr))
 
-	fr.pushN(args)
-
 	// Is it a Lua closure?
 	if fr.function().isLua() {
-		// Ensure stack has space.
-		fr.checkstack(fr.closure.binary.StackSize())
-
-		// Adjust the stack; params is the # of fixed real
-		// parameters from the prototype, and fr.top holds
-		// the # of passed arguments currently on the frame
-		// local stack.
-		switch params := fr.closure.binary.NumParams(); {
-		case fr.gettop() < params: // # arguments < # parameters
-			for fr.gettop() < params {
-				fr.push(None) // nil to top
-			}
-		case fr.gettop() > params: // # arguments > # parameters
-			extras := fr.popN(fr.gettop() - params)
-			if fr.closure.binary.IsVararg() {
-				fr.vararg = extras
-			}
-		}
+		fr.adjust()
 
 		// Execute the closure.
-		execute(&v53{state})
+		if state.global.config.version == V54 {
+			execute54(&v54{state: state})
+		} else {
+			execute(&v53{state: state})
+		}
 		return
 	} else if fr.function().isGo() {
+		// Ensure stack space for new call frame.
+		fr.checkstack(DefaultStackMin)
+
 		// Otherwise Go closure.
-		if rets := fr.popN(fr.function().native(state)); fr.rets != 0 {
-			switch retc := len(rets); {
-			case retc < fr.rets:
-				for retc < fr.rets {
-					rets = append(rets, None)
-					retc++
-				}
-			case retc > fr.rets:
-				if fr.rets != MultRets {
-					rets = rets[:fr.rets]
-				}
-			}
-			fr.caller().pushN(rets)
-		}
+		fr.ret(fr.top-fr.function().native(state), fr.top)
 		return
 	}
 }
 
+// tailcall calls the function in the register a of the current frame with
+// the args values above it, reusing the frame when the function is a Lua
+// function (possibly through a __call metamethod): the frame's open upvalues
+// are closed, its closure and locals replaced by the callee's, and the callee
+// returns to the caller of the frame; so that unbounded tail recursion runs
+// in constant space. The executor then goes on with the first instruction of
+// the callee.
+//
+// Returns false, leaving the stack untouched, for any other value; it must
+// then be called as usual.
+func (state *State) tailcall(a, args int) bool {
+	var (
+		fr      = state.frame()
+		fn      = fr.reg(a).v
+		cls, ok = fn.(*Closure)
+		first   = a + 1
+	)
+	if !ok {
+		if cls, ok = state.metafield(fn, metaCall.ID()).(*Closure); !ok {
+			return false
+		}
+		first = a // called object is the first argument
+	}
+	if !cls.isLua() {
+		return false
+	}
+	state.checkContext()
+	fr.settop(a + args + 1)
+	fr.closeUp(0)
+	// Move the arguments down to the slot of the first argument of the
+	// frame's call, discarding its locals and varargs.
+	var (
+		base = fr.prev.base + fr.fnID
+		n    = copy(state.stack[base:], state.stack[fr.base+first:fr.top])
+	)
+	clear(state.stack[base+n : fr.top])
+	fr.base, fr.top = base, base+n
+	fr.closure = cls
+	fr.tbc = nil
+	fr.pc = 0
+	fr.status |= callStatusTail
+	fr.adjust()
+	return true
+}
+
 func (state *State) init(global *global) {
-	state.frame().checkstack(InitialStackNew)
+	state.grow(InitialStackNew)
 	state.global = global
 }
 
-func (state *State) load(filename string, source interface{}) (*Closure, error) {
-	var (
-		src []byte
-		err error
-	)
-	if src, err = syntax.Source(filename, source); err != nil {
+func (state *State) load(filename string, source interface{}, mode Mode) (*Closure, error) {
+	src, err := syntax.Source(filename, source)
+	if err != nil {
 		return nil, err
 	}
-	if !binary.IsChunk(src) {
-		dir, err := ioutil.TempDir("", "glua")
+	chunkname := filename
+	if source == nil { // loading a file?
+		chunkname = "@" + filename
+		src = skipComment(src)
+	}
+	var proto *binary.Prototype
+	if binary.IsChunk(src) {
+		if mode == TextMode {
+			return nil, fmt.Errorf("attempt to load a binary chunk (mode is 't')")
+		}
+		chunk, err := binary.Load(src)
 		if err != nil {
 			return nil, err
 		}
-		tmp := filepath.Join(dir, "glua.bin")
-		cmd := exec.Command("luac", "-o", tmp, "-")
-		cmd.Stdin = strings.NewReader(string(src))
-
-		out, err := cmd.CombinedOutput()
-		if err != nil {
-			return nil, fmt.Errorf("%v: %s", err, string(out))
+		if chunk.Header.Version != state.luacVersion() {
+			return nil, fmt.Errorf("%s: bad binary format (version mismatch)", syntax.ChunkID(chunkname))
 		}
-
-		if src, err = ioutil.ReadFile(tmp); err != nil {
-			return nil, err
+		proto = &chunk.Entry
+	} else {
+		if mode == BinaryMode {
+			return nil, fmt.Errorf("attempt to load a text chunk (mode is 'b')")
+		}
+		var cache *chunkCache
+		if dir := state.global.config.cacheDir; dir != "" {
+			cache = &chunkCache{dir: dir, version: state.luacVersion()}
+			proto = cache.get(chunkname, src)
+		}
+		if proto == nil {
+			compile := syntax.Compile
+			if state.global.config.version == V54 {
+				compile = syntax.Compile54
+			}
+			if proto, err = compile(chunkname, src); err != nil {
+				return nil, err
+			}
+			if cache != nil {
+				cache.put(chunkname, src, proto)
+			}
 		}
 	}
-
-	chunk, err := binary.Load(src)
-	if err != nil {
-		return nil, err
+	if state.global.config.optimize && state.global.config.version == V53 {
+		syntax.Optimize(proto)
 	}
-
-	cls := newLuaClosure(&chunk.Entry)
+	cls := newLuaClosure(state, newPrototype(state, proto))
 	if len(cls.upvals) > 0 {
 		globals := state.global.registry.getInt(GlobalsIndex)
-		cls.upvals[0] = &upValue{index: -1, value: globals}
+		cls.upvals[0] = &upValue{index: -1, value: unbox(globals)}
 	}
 	return cls, nil
 }
 
-func (state *State) gettable(obj, key Value, raw bool) Value {
+// luacVersion returns the version byte of the binary chunks executed
+// by the state.
+func (state *State) luacVersion() byte {
+	if state.global.config.version == V54 {
+		return binary.LUAC_VERSION_54
+	}
+	return binary.LUAC_VERSION
+}
+
+// skipComment skips an optional BOM and a first line starting with '#'
+// (for Unix executable scripts) in the contents of a source file. The
+// newline ending the comment is kept so line numbers stay correct.
+func skipComment(src []byte) []byte {
+	src = bytes.TrimPrefix(src, []byte("\xEF\xBB\xBF"))
+	if len(src) > 0 && src[0] == '#' {
+		if i := bytes.IndexByte(src, '\n'); i >= 0 {
+			return src[i:]
+		}
+		return nil
+	}
+	return src
+}
+
+func (state *State) gettable(obj, key value, raw bool) value {
 	// fmt.Printf("%v[%v] (%t)\n", obj, key, raw)
-	if tbl, ok := obj.(*table); ok {
-		if val := tbl.get(key); !IsNone(val) || raw || IsNone(state.metafield(tbl, "__index")) {
+	if tbl, ok := obj.v.(*table); ok {
+		if val := tbl.get(key); !val.isNone() || raw || IsNone(state.metafield(tbl, "__index")) {
 			return val
 		}
-		val, err := tryMetaIndex(state, tbl, key)
+		val, err := tryMetaIndex(state, tbl, key.box())
 		if err != nil {
 			state.Errorf("%v", err)
 		}
-		return val
+		return unbox(val)
 	}
 	if !raw {
-		val, err := tryMetaIndex(state, obj, key)
+		val, err := tryMetaIndex(state, obj.box(), key.box())
 		if err != nil {
 			state.Errorf("%v", err)
 		}
-		return val
+		return unbox(val)
 	}
-	return None
+	return none
 }
 
-func (state *State) settable(obj, key, val Value, raw bool) {
+func (state *State) settable(obj, key, val value, raw bool) {
 	// fmt.Printf("%v[%v] = %v (%t)\n", obj, key, val, raw)
-	if tbl, ok := obj.(*table); ok && (tbl.exists(key) || raw) {
+	if tbl, ok := obj.v.(*table); ok && (tbl.exists(key) || raw) {
 		tbl.set(key, val)
 		return
 	}
 	if !raw {
-		if err := tryMetaNewIndex(state, obj, key, val); err != nil {
+		if err := tryMetaNewIndex(state, obj.box(), key.box(), val.box()); err != nil {
 			state.Errorf("%v", err)
 		}
 	}
@@ -380,8 +548,12 @@ func (state *State) setmetatable(value, meta Value) {
 	switch v := value.(type) {
 	case *Object:
 		v.meta = mt
+		state.checkFinalizer(v, &v.fin, mt)
 	case *table:
 		v.meta = mt
+		v.setMode(modeOf(mt))
+		v.version++
+		state.checkFinalizer(v, &v.fin, mt)
 	default:
 		state.global.builtins[v.Type()] = mt
 	}
@@ -449,7 +621,7 @@ func (state *State) get(index int) Value {
 	// Positive stack index
 	//
 	case index > 0:
-		if index > cap(frame.locals) {
+		if frame.base+index > len(state.stack) {
 			state.errorf("unacceptable index (%d)", index)
 		}
 		if index > frame.gettop() {
@@ -481,7 +653,7 @@ func (state *State) get(index int) Value {
 		if nups := len(frame.closure.upvals); nups == 0 || nups > index {
 			return None
 		}
-		return frame.getUp(index - 1).get()
+		return frame.getUp(index - 1).get().box()
 	}
 }
 
@@ -491,7 +663,7 @@ func (state *State) set(index int, value Value) {
 	// Positive stack index
 	//
 	case index > 0:
-		if index > cap(frame.locals) {
+		if frame.base+index > len(state.stack) {
 			state.errorf("unacceptable index (%d)", index)
 		}
 		if index > frame.gettop() {
@@ -525,7 +697,7 @@ func (state *State) set(index int, value Value) {
 		if nups := len(frame.closure.upvals); nups == 0 || nups > index {
 			return
 		}
-		frame.setUp(index-1, value)
+		frame.setUp(index-1, unbox(value))
 		return
 	}
 }
@@ -0,0 +1,167 @@
+package syntax
+
+import "math"
+
+// arithOp is an arithmetic or bitwise operation subject to constant
+// folding; the order matches binOpr followed by the unary operators.
+type arithOp int
+
+const (
+	arithAdd arithOp = iota
+	arithSub
+	arithMul
+	arithMod
+	arithPow
+	arithDiv
+	arithIDiv
+	arithBAnd
+	arithBOr
+	arithBXor
+	arithShl
+	arithShr
+	arithUnm
+	arithBNot
+)
+
+// floatToInt converts f to an integer if it has an exact
+// integral representation.
+func floatToInt(f float64) (int64, bool) {
+	if math.Floor(f) == f && f >= math.MinInt64 && f < -math.MinInt64 {
+		return int64(f), true
+	}
+	return 0, false
+}
+
+// toInteger converts the numeral v to an integer without loss.
+func toInteger(v interface{}) (int64, bool) {
+	switch v := v.(type) {
+	case int64:
+		return v, true
+	case float64:
+		return floatToInt(v)
+	}
+	return 0, false
+}
+
+// toFloat converts the numeral v to a float.
+func toFloat(v interface{}) float64 {
+	if i, ok := v.(int64); ok {
+		return float64(i)
+	}
+	return v.(float64)
+}
+
+// validOp reports whether op can be safely folded with operands
+// v1 and v2, that is, it will not raise an error at runtime.
+func validOp(op arithOp, v1, v2 interface{}) bool {
+	switch op {
+	case arithBAnd, arithBOr, arithBXor, arithShl, arithShr, arithBNot: // conversion errors
+		_, ok1 := toInteger(v1)
+		_, ok2 := toInteger(v2)
+		return ok1 && ok2
+	case arithDiv, arithIDiv, arithMod: // division by 0
+		return toFloat(v2) != 0
+	}
+	return true // everything else is valid
+}
+
+// arith performs op on the numerals v1 and v2.
+func arith(op arithOp, v1, v2 interface{}) interface{} {
+	switch op {
+	case arithBAnd, arithBOr, arithBXor, arithShl, arithShr, arithBNot: // operate only on integers
+		i1, _ := toInteger(v1)
+		i2, _ := toInteger(v2)
+		return intArith(op, i1, i2)
+	case arithDiv, arithPow: // operate only on floats
+		return numArith(op, toFloat(v1), toFloat(v2))
+	}
+	i1, ok1 := v1.(int64)
+	i2, ok2 := v2.(int64)
+	if ok1 && ok2 {
+		return intArith(op, i1, i2)
+	}
+	return numArith(op, toFloat(v1), toFloat(v2))
+}
+
+func intArith(op arithOp, x, y int64) int64 {
+	switch op {
+	case arithAdd:
+		return x + y
+	case arithSub:
+		return x - y
+	case arithMul:
+		return x * y
+	case arithMod:
+		if y == -1 {
+			return 0
+		}
+		m := x % y
+		if m != 0 && (m^y) < 0 { // different signs?
+			m += y // correct result for different rounding
+		}
+		return m
+	case arithIDiv:
+		if y == -1 {
+			return -x // avoid overflow with 0x80000...//-1
+		}
+		q := x / y
+		if (x^y) < 0 && x%y != 0 { // different signs and non-integer result?
+			q-- // correct result for different rounding
+		}
+		return q
+	case arithBAnd:
+		return x & y
+	case arithBOr:
+		return x | y
+	case arithBXor:
+		return x ^ y
+	case arithShl:
+		return shiftLeft(x, y)
+	case arithShr:
+		return shiftLeft(x, -y)
+	case arithUnm:
+		return -x
+	case arithBNot:
+		return ^x
+	}
+	return 0
+}
+
+func numArith(op arithOp, x, y float64) float64 {
+	switch op {
+	case arithAdd:
+		return x + y
+	case arithSub:
+		return x - y
+	case arithMul:
+		return x * y
+	case arithDiv:
+		return x / y
+	case arithPow:
+		return math.Pow(x, y)
+	case arithIDiv:
+		return math.Floor(x / y)
+	case arithUnm:
+		return -x
+	case arithMod:
+		m := math.Mod(x, y)
+		if m*y < 0 { // different signs?
+			m += y
+		}
+		return m
+	}
+	return 0
+}
+
+// shiftLeft shifts x left by y bits; negative y shifts right
+// (logically).
+func shiftLeft(x, y int64) int64 {
+	switch {
+	case y <= -64 || y >= 64:
+		return 0
+	case y < 0:
+		return int64(uint64(x) >> uint(-y))
+	default:
+		return int64(uint64(x) << uint(y))
+	}
+}
@@ -0,0 +1,826 @@
+package syntax
+
+import (
+	"math"
+
+	"github.com/Azure/golua/lua/vm"
+)
+
+const (
+	// noJump marks the end of a patch list.
+	noJump = -1
+
+	// noReg is an invalid register that fits in 8 bits.
+	noReg = vm.MaxArgA
+
+	// maxRegs is the maximum number of registers in a Lua function
+	// (must fit in 8 bits).
+	maxRegs = 255
+
+	// multRet is the option for multiple returns in calls and varargs.
+	multRet = -1
+
+	// fieldsPerFlush is the number of list items to accumulate before
+	// a SETLIST instruction.
+	fieldsPerFlush = 50
+)
+
+// nilKey is the cache key used for the nil constant.
+type nilKey struct{}
+
+// intKey is the cache key used for integer constants; it keeps
+// integers apart from floats with an integral value.
+type intKey int64
+
+func hasJumps(e *expDesc) bool { return e.t != e.f }
+
+// numeral returns the numeric value of e (int64 or float64)
+// if it is a numeral without jumps.
+func numeral(e *expDesc) (interface{}, bool) {
+	if hasJumps(e) {
+		return nil, false
+	}
+	switch e.k {
+	case vKInt:
+		return e.ival, true
+	case vKFlt:
+		return e.nval, true
+	}
+	return nil, false
+}
+
+// instr returns the instruction at pc.
+func (fs *funcState) instr(pc int) vm.Instr { return vm.Instr(fs.f.Code[pc]) }
+
+// setInstr replaces the instruction at pc.
+func (fs *funcState) setInstr(pc int, i vm.Instr) { fs.f.Code[pc] = uint32(i) }
+
+// loadNil emits a LOADNIL for n registers starting at from, merging
+// it with a previous LOADNIL when possible.
+func (fs *funcState) loadNil(from, n int) {
+	l := from + n - 1                       // last register to set nil
+	if fs.pc > fs.lastTarget && fs.pc > 0 { // no jumps to current position?
+		if prev := fs.instr(fs.pc - 1); prev.Code() == vm.LOADNIL {
+			pfrom := prev.A() // get previous range
+			pl := pfrom + prev.B()
+			if (pfrom <= from && from <= pl+1) || (from <= pfrom && pfrom <= l+1) { // can connect both?
+				if pfrom < from {
+					from = pfrom
+				}
+				if pl > l {
+					l = pl
+				}
+				fs.setInstr(fs.pc-1, prev.WithA(from).WithB(l-from))
+				return
+			}
+		}
+	}
+	fs.codeABC(vm.LOADNIL, from, n-1, 0) // else no optimization
+}
+
+// getJump returns the destination of the jump at pc, or noJump
+// if it is the end of a list.
+func (fs *funcState) getJump(pc int) int {
+	if offset := fs.instr(pc).SBX(); offset != noJump {
+		return pc + 1 + offset // turn offset into absolute position
+	}
+	return noJump // end of list
+}
+
+// fixJump makes the jump at pc jump to dest.
+func (fs *funcState) fixJump(pc, dest int) {
+	offset := dest - (pc + 1)
+	if offset > vm.MaxArgSBX || -offset > vm.MaxArgSBX {
+		fs.ls.syntaxError("control structure too long")
+	}
+	fs.setInstr(pc, fs.instr(pc).WithSBX(offset))
+}
+
+// concat appends the jump list l2 to the jump list l1.
+func (fs *funcState) concat(l1 *int, l2 int) {
+	switch {
+	case l2 == noJump: // nothing to concatenate?
+	case *l1 == noJump: // no original list?
+		*l1 = l2
+	default:
+		list := *l1
+		for next := fs.getJump(list); next != noJump; next = fs.getJump(list) {
+			list = next // find last element
+		}
+		fs.fixJump(list, l2) // last element links to l2
+	}
+}
+
+// jump emits a jump instruction and returns its position, so that
+// its destination can be fixed later. Pending jumps to the current
+// position are kept on hold by chaining them to the new jump.
+func (fs *funcState) jump() int {
+	jpc := fs.jpc // save list of jumps to here
+	fs.jpc = noJump
+	j := fs.codeAsBx(vm.JMP, 0, noJump)
+	fs.concat(&j, jpc) // keep them on hold
+	return j
+}
+
+// jumpTo emits a jump to target.
+func (fs *funcState) jumpTo(target int) { fs.patchList(fs.jump(), target) }
+
+// ret emits a return instruction.
+func (fs *funcState) ret(first, nret int) { fs.codeABC(vm.RETURN, first, nret+1, 0) }
+
+// condJump emits a test instruction followed by a jump,
+// returning the position of the jump.
+func (fs *funcState) condJump(op vm.Code, a, b, c int) int {
+	fs.codeABC(op, a, b, c)
+	return fs.jump()
+}
+
+// getLabel marks the current pc as a jump target and returns it.
+func (fs *funcState) getLabel() int {
+	fs.lastTarget = fs.pc
+	return fs.pc
+}
+
+// jumpControl returns the position of the instruction controlling
+// the jump at pc (its condition), or pc itself if unconditional.
+func (fs *funcState) jumpControl(pc int) int {
+	if pc >= 1 && fs.instr(pc-1).Code().Mask().Test() {
+		return pc - 1
+	}
+	return pc
+}
+
+// patchTestReg patches the destination register of a TESTSET
+// controlling node; if there is no register to put the value (or it
+// already has it) the TESTSET becomes a simple TEST. It reports
+// whether node was a TESTSET.
+func (fs *funcState) patchTestReg(node, reg int) bool {
+	pc := fs.jumpControl(node)
+	i := fs.instr(pc)
+	if i.Code() != vm.TESTSET {
+		return false // cannot patch other instructions
+	}
+	if reg != noReg && reg != i.B() {
+		fs.setInstr(pc, i.WithA(reg))
+	} else {
+		// no register to put value or register already has
+		// the value; change instruction to simple test.
+		fs.setInstr(pc, vm.MakeABC(vm.TEST, i.B(), 0, i.C()))
+	}
+	return true
+}
+
+// removeValues traverses a list of tests ensuring no one produces a value.
+func (fs *funcState) removeValues(list int) {
+	for ; list != noJump; list = fs.getJump(list) {
+		fs.patchTestReg(list, noReg)
+	}
+}
+
+// patchListAux traverses a list of tests, patching their destination
+// address and registers: tests producing values jump to vtarget (and
+// put their values in reg), other tests jump to dtarget.
+func (fs *funcState) patchListAux(list, vtarget, reg, dtarget int) {
+	for list != noJump {
+		next := fs.getJump(list)
+		if fs.patchTestReg(list, reg) {
+			fs.fixJump(list, vtarget)
+		} else {
+			fs.fixJump(list, dtarget) // jump to default target
+		}
+		list = next
+	}
+}
+
+// dischargeJpc patches all pending jumps to the current position.
+func (fs *funcState) dischargeJpc() {
+	fs.patchListAux(fs.jpc, fs.pc, noReg, fs.pc)
+	fs.jpc = noJump
+}
+
+// patchToHere adds list to the jumps pending on the current position.
+func (fs *funcState) patchToHere(list int) {
+	fs.getLabel() // mark "here" as a jump target
+	fs.concat(&fs.jpc, list)
+}
+
+// patchList makes all jumps in list jump to target.
+func (fs *funcState) patchList(list, target int) {
+	if target == fs.pc { // target is current position?
+		fs.patchToHere(list) // add list to pending jumps
+		return
+	}
+	fs.patchListAux(list, target, noReg, target)
+}
+
+// patchClose makes all jumps in list close upvalues up to level.
+func (fs *funcState) patchClose(list, level int) {
+	level++ // argument is +1 to reserve 0 as non-op
+	for ; list != noJump; list = fs.getJump(list) {
+		fs.setInstr(list, fs.instr(list).WithA(level))
+	}
+}
+
+// code emits instruction i, returning its position.
+func (fs *funcState) code(i vm.Instr) int {
+	fs.dischargeJpc() // pc will change
+	fs.f.Code = append(fs.f.Code[:fs.pc], uint32(i))
+	fs.f.PcLnTab = append(fs.f.PcLnTab[:fs.pc], uint32(fs.ls.LastLine()))
+	fs.pc++
+	return fs.pc - 1
+}
+
+func (fs *funcState) codeABC(op vm.Code, a, b, c int) int {
+	return fs.code(vm.MakeABC(op, a, b, c))
+}
+
+func (fs *funcState) codeABx(op vm.Code, a, bx int) int {
+	return fs.code(vm.MakeABx(op, a, bx))
+}
+
+func (fs *funcState) codeAsBx(op vm.Code, a, sbx int) int {
+	return fs.code(vm.MakeAsBx(op, a, sbx))
+}
+
+func (fs *funcState) codeExtraArg(a int) int {
+	return fs.code(vm.MakeAx(vm.EXTRAARG, a))
+}
+
+// codeK emits a "load constant" instruction, using either LOADK or
+// LOADKX (with an extra argument) depending on the index.
+func (fs *funcState) codeK(reg, k int) int {
+	if k <= vm.MaxArgBX {
+		return fs.codeABx(vm.LOADK, reg, k)
+	}
+	p := fs.codeABx(vm.LOADKX, reg, 0)
+	fs.codeExtraArg(k)
+	return p
+}
+
+// checkStack ensures there are n registers free above freeReg.
+func (fs *funcState) checkStack(n int) {
+	if size := fs.freeReg + n; size > int(fs.f.Stack) {
+		if size >= maxRegs {
+			fs.ls.syntaxError("function or expression needs too many registers")
+		}
+		fs.f.Stack = byte(size)
+	}
+}
+
+// reserveRegs reserves n registers.
+func (fs *funcState) reserveRegs(n int) {
+	fs.checkStack(n)
+	fs.freeReg += n
+}
+
+// freeReg frees register reg if it is neither a constant index
+// nor a local variable.
+func (fs *funcState) freeRegister(reg int) {
+	if reg >= 0 && !vm.IsK(reg) && reg >= fs.nActVar {
+		fs.freeReg--
+	}
+}
+
+// freeExp frees the register used by e (if any).
+func (fs *funcState) freeExp(e *expDesc) {
+	if e.k == vNonReloc {
+		fs.freeRegister(e.info)
+	}
+}
+
+// freeExps frees the registers used by e1 and e2 in proper order.
+func (fs *funcState) freeExps(e1, e2 *expDesc) {
+	r1, r2 := -1, -1
+	if e1.k == vNonReloc {
+		r1 = e1.info
+	}
+	if e2.k == vNonReloc {
+		r2 = e2.info
+	}
+	if r1 > r2 {
+		fs.freeRegister(r1)
+		fs.freeRegister(r2)
+	} else {
+		fs.freeRegister(r2)
+		fs.freeRegister(r1)
+	}
+}
+
+// addK adds constant v to the function's constants, reusing an
+// existing entry found through key.
+func (fs *funcState) addK(key, v interface{}) int {
+	if k, ok := fs.ls.cache[key]; ok {
+		// correct value? (must distinguish floats from integers!)
+		if k < len(fs.f.Consts) && fs.f.Consts[k] == v {
+			return k // reuse index
+		}
+	}
+	k := len(fs.f.Consts)
+	fs.ls.cache[key] = k
+	fs.f.Consts = append(fs.f.Consts, v)
+	return k
+}
+
+func (fs *funcState) stringK(s string) int { return fs.addK(s, s) }
+
+func (fs *funcState) intK(n int64) int { return fs.addK(intKey(n), n) }
+
+func (fs *funcState) numberK(r float64) int {
+	// floats are keyed by value, normalizing integral ones
+	// the way table keys are.
+	if i, ok := floatToInt(r); ok {
+		return fs.addK(i, r)
+	}
+	return fs.addK(r, r)
+}
+
+func (fs *funcState) boolK(b bool) int { return fs.addK(b, b) }
+
+func (fs *funcState) nilK() int { return fs.addK(nilKey{}, nil) }
+
+// setReturns fixes an expression to return the given number of results.
+func (fs *funcState) setReturns(e *expDesc, nresults int) {
+	switch e.k {
+	case vCall: // expression is an open function call?
+		fs.setInstr(e.info, fs.instr(e.info).WithC(nresults+1))
+	case vVararg:
+		fs.setInstr(e.info, fs.instr(e.info).WithB(nresults+1).WithA(fs.freeReg))
+		fs.reserveRegs(1)
+	}
+}
+
+func (fs *funcState) setMultRet(e *expDesc) { fs.setReturns(e, multRet) }
+
+// setOneRet fixes an expression to return one result.
+func (fs *funcState) setOneRet(e *expDesc) {
+	switch e.k {
+	case vCall: // expression is an open function call?
+		// already returns 1 value
+		e.k = vNonReloc // result has fixed position
+		e.info = fs.instr(e.info).A()
+	case vVararg:
+		fs.setInstr(e.info, fs.instr(e.info).WithB(2))
+		e.k = vRelocable // can relocate its simple result
+	}
+}
+
+// dischargeVars ensures e is not a variable.
+func (fs *funcState) dischargeVars(e *expDesc) {
+	switch e.k {
+	case vLocal: // already in a register
+		e.k = vNonReloc // becomes a non-relocatable value
+	case vUpval: // move value to some (pending) register
+		e.info = fs.codeABC(vm.GETUPVAL, 0, e.info, 0)
+		e.k = vRelocable
+	case vIndexed:
+		op := vm.GETTABUP // t is in an upvalue
+		fs.freeRegister(e.ind.idx)
+		if e.ind.vt == vLocal { // is t in a register?
+			fs.freeRegister(e.ind.t)
+			op = vm.GETTABLE
+		}
+		e.info = fs.codeABC(op, 0, e.ind.t, e.ind.idx)
+		e.k = vRelocable
+	case vVararg, vCall:
+		fs.setOneRet(e)
+	}
+}
+
+// discharge2Reg ensures the value of e (if it is not a jump)
+// is in register reg.
+func (fs *funcState) discharge2Reg(e *expDesc, reg int) {
+	fs.dischargeVars(e)
+	switch e.k {
+	case vNil:
+		fs.loadNil(reg, 1)
+	case vFalse:
+		fs.codeABC(vm.LOADBOOL, reg, 0, 0)
+	case vTrue:
+		fs.codeABC(vm.LOADBOOL, reg, 1, 0)
+	case vK:
+		fs.codeK(reg, e.info)
+	case vKFlt:
+		fs.codeK(reg, fs.numberK(e.nval))
+	case vKInt:
+		fs.codeK(reg, fs.intK(e.ival))
+	case vRelocable:
+		fs.setInstr(e.info, fs.instr(e.info).WithA(reg)) // instruction will put result in reg
+	case vNonReloc:
+		if reg != e.info {
+			fs.codeABC(vm.MOVE, reg, e.info, 0)
+		}
+	default: // vJmp; nothing to do...
+		return
+	}
+	e.info = reg
+	e.k = vNonReloc
+}
+
+// discharge2AnyReg ensures e is in some register.
+func (fs *funcState) discharge2AnyReg(e *expDesc) {
+	if e.k != vNonReloc { // no fixed register yet?
+		fs.reserveRegs(1)                 // get a register
+		fs.discharge2Reg(e, fs.freeReg-1) // put value there
+	}
+}
+
+func (fs *funcState) codeLoadBool(a, b, jump int) int {
+	fs.getLabel() // those instructions may be jump targets
+	return fs.codeABC(vm.LOADBOOL, a, b, jump)
+}
+
+// needValue reports whether list has any jump that does not
+// produce a value (or produces an inverted value).
+func (fs *funcState) needValue(list int) bool {
+	for ; list != noJump; list = fs.getJump(list) {
+		if fs.instr(fs.jumpControl(list)).Code() != vm.TESTSET {
+			return true
+		}
+	}
+	return false // not found
+}
+
+// exp2Reg ensures the final value of e (including the results of
+// its jump lists) is in register reg.
+func (fs *funcState) exp2Reg(e *expDesc, reg int) {
+	fs.discharge2Reg(e, reg)
+	if e.k == vJmp { // expression itself is a test?
+		fs.concat(&e.t, e.info) // put this jump in t list
+	}
+	if hasJumps(e) {
+		pf := noJump // position of an eventual LOAD false
+		pt := noJump // position of an eventual LOAD true
+		if fs.needValue(e.t) || fs.needValue(e.f) {
+			fj := noJump
+			if e.k != vJmp {
+				fj = fs.jump()
+			}
+			pf = fs.codeLoadBool(reg, 0, 1)
+			pt = fs.codeLoadBool(reg, 1, 0)
+			fs.patchToHere(fj)
+		}
+		final := fs.getLabel() // position after whole expression
+		fs.patchListAux(e.f, final, reg, pf)
+		fs.patchListAux(e.t, final, reg, pt)
+	}
+	e.f, e.t = noJump, noJump
+	e.info = reg
+	e.k = vNonReloc
+}
+
+// exp2NextReg ensures the final value of e is in the next
+// available register.
+func (fs *funcState) exp2NextReg(e *expDesc) {
+	fs.dischargeVars(e)
+	fs.freeExp(e)
+	fs.reserveRegs(1)
+	fs.exp2Reg(e, fs.freeReg-1)
+}
+
+// exp2AnyReg ensures the final value of e is in some register
+// and returns that register.
+func (fs *funcState) exp2AnyReg(e *expDesc) int {
+	fs.dischargeVars(e)
+	if e.k == vNonReloc { // expression already has a register?
+		if !hasJumps(e) { // no jumps?
+			return e.info // result is already in a register
+		}
+		if e.info >= fs.nActVar { // reg. is not a local?
+			fs.exp2Reg(e, e.info) // put final result in it
+			return e.info
+		}
+	}
+	fs.exp2NextReg(e) // otherwise, use next available register
+	return e.info
+}
+
+// exp2AnyRegUp ensures the final value of e is in a register
+// or in an upvalue.
+func (fs *funcState) exp2AnyRegUp(e *expDesc) {
+	if e.k != vUpval || hasJumps(e) {
+		fs.exp2AnyReg(e)
+	}
+}
+
+// exp2Val ensures e is either in a register or is a constant.
+func (fs *funcState) exp2Val(e *expDesc) {
+	if hasJumps(e) {
+		fs.exp2AnyReg(e)
+	} else {
+		fs.dischargeVars(e)
+	}
+}
+
+// exp2RK ensures the final value of e is in a register or in
+// a constant index that fits in an RK argument.
+func (fs *funcState) exp2RK(e *expDesc) int {
+	fs.exp2Val(e)
+	switch e.k { // move constants to k
+	case vTrue:
+		e.info = fs.boolK(true)
+	case vFalse:
+		e.info = fs.boolK(false)
+	case vNil:
+		e.info = fs.nilK()
+	case vKInt:
+		e.info = fs.intK(e.ival)
+	case vKFlt:
+		e.info = fs.numberK(e.nval)
+	case vK:
+	default:
+		// not a constant in the right range: put it in a register
+		return fs.exp2AnyReg(e)
+	}
+	if e.k = vK; e.info <= vm.MaxIndexRK { // constant fits in argC?
+		return vm.RKAsK(e.info)
+	}
+	return fs.exp2AnyReg(e)
+}
+
+// storeVar generates code to store the result of ex into var.
+func (fs *funcState) storeVar(v, ex *expDesc) {
+	switch v.k {
+	case vLocal:
+		fs.freeExp(ex)
+		fs.exp2Reg(ex, v.info) // compute ex into proper place
+		return
+	case vUpval:
+		e := fs.exp2AnyReg(ex)
+		fs.codeABC(vm.SETUPVAL, e, v.info, 0)
+	case vIndexed:
+		op := vm.SETTABUP
+		if v.ind.vt == vLocal {
+			op = vm.SETTABLE
+		}
+		e := fs.exp2RK(ex)
+		fs.codeABC(op, v.ind.t, v.ind.idx, e)
+	}
+	fs.freeExp(ex)
+}
+
+// self emits SELF instruction (convert expression e into e:key(e,).
+func (fs *funcState) self(e, key *expDesc) {
+	fs.exp2AnyReg(e)
+	ereg := e.info // register where e was placed
+	fs.freeExp(e)
+	e.info = fs.freeReg // base register for op_self
+	e.k = vNonReloc     // self expression has a fixed register
+	fs.reserveRegs(2)   // function and self produced by op_self
+	fs.codeABC(vm.SELF, e.info, ereg, fs.exp2RK(key))
+	fs.freeExp(key)
+}
+
+// negateCondition negates the condition e (which must be a jump).
+func (fs *funcState) negateCondition(e *expDesc) {
+	pc := fs.jumpControl(e.info)
+	i := fs.instr(pc)
+	fs.setInstr(pc, i.WithA(i.A()^1))
+}
+
+// jumpOnCond emits an instruction to jump if e is cond (that is, if
+// cond is true, code will jump if e is true) and returns the jump
+// position.
+func (fs *funcState) jumpOnCond(e *expDesc, cond int) int {
+	if e.k == vRelocable {
+		if ie := fs.instr(e.info); ie.Code() == vm.NOT {
+			fs.pc-- // remove previous OP_NOT
+			fs.f.Code = fs.f.Code[:fs.pc]
+			fs.f.PcLnTab = fs.f.PcLnTab[:fs.pc]
+			return fs.condJump(vm.TEST, ie.B(), 0, cond^1)
+		}
+		// else go through
+	}
+	fs.discharge2AnyReg(e)
+	fs.freeExp(e)
+	return fs.condJump(vm.TESTSET, noReg, e.info, cond)
+}
+
+// goIfTrue emits code to go through if e is true, jump otherwise.
+func (fs *funcState) goIfTrue(e *expDesc) {
+	var pc int // pc of new jump
+	fs.dischargeVars(e)
+	switch e.k {
+	case vJmp: // condition?
+		fs.negateCondition(e) // jump when it is false
+		pc = e.info           // save jump position
+	case vK, vKFlt, vKInt, vTrue:
+		pc = noJump // always true; do nothing
+	default:
+		pc = fs.jumpOnCond(e, 0) // jump when false
+	}
+	fs.concat(&e.f, pc) // insert new jump in false list
+	fs.patchToHere(e.t) // true list jumps to here (to go through)
+	e.t = noJump
+}
+
+// goIfFalse emits code to go through if e is false, jump otherwise.
+func (fs *funcState) goIfFalse(e *expDesc) {
+	var pc int // pc of new jump
+	fs.dischargeVars(e)
+	switch e.k {
+	case vJmp:
+		pc = e.info // already jump if true
+	case vNil, vFalse:
+		pc = noJump // always false; do nothing
+	default:
+		pc = fs.jumpOnCond(e, 1) // jump if true
+	}
+	fs.concat(&e.t, pc) // insert new jump in t list
+	fs.patchToHere(e.f) // false list jumps to here (to go through)
+	e.f = noJump
+}
+
+// codeNot emits code for 'not e', doing constant folding.
+func (fs *funcState) codeNot(e *expDesc) {
+	fs.dischargeVars(e)
+	switch e.k {
+	case vNil, vFalse:
+		e.k = vTrue // true == not nil == not false
+	case vK, vKFlt, vKInt, vTrue:
+		e.k = vFalse // false == not "x" == not 0.5 == not 1 == not true
+	case vJmp:
+		fs.negateCondition(e)
+	case vRelocable, vNonReloc:
+		fs.discharge2AnyReg(e)
+		fs.freeExp(e)
+		e.info = fs.codeABC(vm.NOT, 0, e.info, 0)
+		e.k = vRelocable
+	}
+	// interchange true and false lists
+	e.f, e.t = e.t, e.f
+	fs.removeValues(e.f) // values are useless when negated
+	fs.removeValues(e.t)
+}
+
+// indexed creates the expression t[k]; t must have its final result
+// already in a register or upvalue.
+func (fs *funcState) indexed(t, k *expDesc) {
+	t.ind.t = t.info         // register or upvalue index
+	t.ind.idx = fs.exp2RK(k) // R/K index for key
+	if t.k == vUpval {
+		t.ind.vt = vUpval
+	} else {
+		t.ind.vt = vLocal
+	}
+	t.k = vIndexed
+}
+
+// constFolding tries to fold the numeric operation op on e1 and e2
+// into e1, reporting whether it succeeded.
+func (fs *funcState) constFolding(op arithOp, e1, e2 *expDesc) bool {
+	v1, ok1 := numeral(e1)
+	v2, ok2 := numeral(e2)
+	if !ok1 || !ok2 || !validOp(op, v1, v2) {
+		return false // non-numeric operands or not safe to fold
+	}
+	switch res := arith(op, v1, v2).(type) {
+	case int64:
+		e1.k, e1.ival = vKInt, res
+	case float64:
+		// folds neither NaN nor 0.0 (to avoid problems with -0.0)
+		if math.IsNaN(res) || res == 0 {
+			return false
+		}
+		e1.k, e1.nval = vKFlt, res
+	}
+	return true
+}
+
+// codeUnExpVal emits code for unary expressions that "produce values"
+// (everything but 'not'). Expression to produce final result will be
+// encoded in e.
+func (fs *funcState) codeUnExpVal(op vm.Code, e *expDesc, line int) {
+	r := fs.exp2AnyReg(e) // opcodes operate only on registers
+	fs.freeExp(e)
+	e.info = fs.codeABC(op, 0, r, 0) // generate opcode
+	e.k = vRelocable                 // all those operations are relocatable
+	fs.fixLine(line)
+}
+
+// codeBinExpVal emits code for binary expressions that "produce values"
+// (everything but logical operators 'and'/'or' and comparison operators).
+func (fs *funcState) codeBinExpVal(op vm.Code, e1, e2 *expDesc, line int) {
+	rk2 := fs.exp2RK(e2) // both operands are "RK"
+	rk1 := fs.exp2RK(e1)
+	fs.freeExps(e1, e2)
+	e1.info = fs.codeABC(op, 0, rk1, rk2) // generate opcode
+	e1.k = vRelocable                     // all those operations are relocatable
+	fs.fixLine(line)
+}
+
+// codeComp emits code for comparisons.
+func (fs *funcState) codeComp(opr binOpr, e1, e2 *expDesc) {
+	var rk1 int
+	if e1.k == vK {
+		rk1 = vm.RKAsK(e1.info)
+	} else {
+		rk1 = e1.info // e1.k == vNonReloc
+	}
+	rk2 := fs.exp2RK(e2)
+	fs.freeExps(e1, e2)
+	switch opr {
+	case oprNE: // '(a ~= b)' ==> 'not (a == b)'
+		e1.info = fs.condJump(vm.EQ, 0, rk1, rk2)
+	case oprGT, oprGE:
+		// '(a > b)' ==> '(b < a)';  '(a >= b)' ==> '(b <= a)'
+		op := vm.Code(opr-oprNE) + vm.EQ
+		e1.info = fs.condJump(op, 1, rk2, rk1) // invert operands
+	default: // '==', '<', '<=' use their own opcodes
+		op := vm.Code(opr-oprEq) + vm.EQ
+		e1.info = fs.condJump(op, 1, rk1, rk2)
+	}
+	e1.k = vJmp
+}
+
+// prefix applies the unary operator op to expression e.
+func (fs *funcState) prefix(op unOpr, e *expDesc, line int) {
+	ef := &expDesc{k: vKInt, t: noJump, f: noJump} // fake 2nd operand
+	switch op {
+	case oprMinus, oprBNot:
+		if fs.constFolding(arithOp(op)+arithUnm, e, ef) {
+			break
+		}
+		fs.codeUnExpVal(vm.Code(op)+vm.UNM, e, line)
+	case oprLen:
+		fs.codeUnExpVal(vm.LEN, e, line)
+	case oprNot:
+		fs.codeNot(e)
+	}
+}
+
+// infix processes the first operand v of binary operation op before
+// reading the second operand.
+func (fs *funcState) infix(op binOpr, v *expDesc) {
+	switch op {
+	case oprAnd:
+		fs.goIfTrue(v) // go ahead only if v is true
+	case oprOr:
+		fs.goIfFalse(v) // go ahead only if v is false
+	case oprConcat:
+		fs.exp2NextReg(v) // operand must be on the 'stack'
+	case oprAdd, oprSub, oprMul, oprDiv, oprIDiv, oprMod, oprPow,
+		oprBAnd, oprBOr, oprBXor, oprShl, oprShr:
+		if _, ok := numeral(v); !ok {
+			fs.exp2RK(v)
+		}
+		// else keep numeral, which may be folded with 2nd operand
+	default:
+		fs.exp2RK(v)
+	}
+}
+
+// posfix finalizes code for binary operation op after reading the
+// second operand.
+func (fs *funcState) posfix(op binOpr, e1, e2 *expDesc, line int) {
+	switch op {
+	case oprAnd:
+		fs.dischargeVars(e2)
+		fs.concat(&e2.f, e1.f)
+		*e1 = *e2
+	case oprOr:
+		fs.dischargeVars(e2)
+		fs.concat(&e2.t, e1.t)
+		*e1 = *e2
+	case oprConcat:
+		fs.exp2Val(e2)
+		if e2.k == vRelocable && fs.instr(e2.info).Code() == vm.CONCAT {
+			fs.freeExp(e1)
+			fs.setInstr(e2.info, fs.instr(e2.info).WithB(e1.info))
+			e1.k, e1.info = vRelocable, e2.info
+		} else {
+			fs.exp2NextReg(e2) // operand must be on the 'stack'
+			fs.codeBinExpVal(vm.CONCAT, e1, e2, line)
+		}
+	case oprAdd, oprSub, oprMul, oprDiv, oprIDiv, oprMod, oprPow,
+		oprBAnd, oprBOr, oprBXor, oprShl, oprShr:
+		if !fs.constFolding(arithOp(op), e1, e2) {
+			fs.codeBinExpVal(vm.Code(op)+vm.ADD, e1, e2, line)
+		}
+	case oprEq, oprLt, oprLe, oprNE, oprGT, oprGE:
+		fs.codeComp(op, e1, e2)
+	}
+}
+
+// fixLine changes the line information of the last instruction.
+func (fs *funcState) fixLine(line int) { fs.f.PcLnTab[fs.pc-1] = uint32(line) }
+
+// setList emits a SETLIST instruction storing tostore values
+// (or all values up to the top if tostore is multRet) into the
+// table in register base.
+func (fs *funcState) setList(base, nelems, tostore int) {
+	c := (nelems-1)/fieldsPerFlush + 1
+	b := tostore
+	if tostore == multRet {
+		b = 0
+	}
+	switch {
+	case c <= vm.MaxArgC:
+		fs.codeABC(vm.SETLIST, base, b, c)
+	case c <= vm.MaxArgAx:
+		fs.codeABC(vm.SETLIST, base, b, 0)
+		fs.codeExtraArg(c)
+	default:
+		fs.ls.syntaxError("constructor too long")
+	}
+	fs.freeReg = base + 1 // free registers with list values
+}
@@ -0,0 +1,1254 @@
+package syntax
+
+import (
+	"math"
+	"math/bits"
+
+	"github.com/Azure/golua/lua/vm54"
+)
+
+const (
+	// noReg54 is an invalid register that fits in 8 bits.
+	noReg54 = vm54.MaxArgA
+
+	// maxIndexRK54 is the largest constant index usable as an
+	// RK argument.
+	maxIndexRK54 = vm54.MaxArgB
+
+	// tmAdd is the number of the '__add' metamethod event; the events
+	// of the other arithmetic and bitwise operators follow it in the
+	// order of binOpr.
+	tmAdd = 6
+)
+
+func hasJumps54(e *expDesc54) bool { return e.t != e.f }
+
+// numeral54 returns the numeric value of e (int64 or float64)
+// if it is a numeral without jumps.
+func numeral54(e *expDesc54) (interface{}, bool) {
+	if hasJumps54(e) {
+		return nil, false
+	}
+	switch e.k {
+	case vKInt:
+		return e.ival, true
+	case vKFlt:
+		return e.nval, true
+	}
+	return nil, false
+}
+
+// exp2Const stores in *v the compile-time constant value of e,
+// reporting whether e has one.
+func (fs *funcState54) exp2Const(e *expDesc54, v *interface{}) bool {
+	if hasJumps54(e) {
+		return false // not a constant
+	}
+	switch e.k {
+	case vFalse:
+		*v = false
+	case vTrue:
+		*v = true
+	case vNil:
+		*v = nil
+	case vKStr:
+		*v = e.strval
+	case vConst:
+		*v = fs.ls.actVar[e.info].k
+	default:
+		n, ok := numeral54(e)
+		if ok {
+			*v = n
+		}
+		return ok
+	}
+	return true
+}
+
+// instr returns the instruction at pc.
+func (fs *funcState54) instr(pc int) vm54.Instr { return vm54.Instr(fs.f.Code[pc]) }
+
+// setInstr replaces the instruction at pc.
+func (fs *funcState54) setInstr(pc int, i vm54.Instr) { fs.f.Code[pc] = uint32(i) }
+
+// previousInstr returns the position of the previous instruction,
+// or -1 if there may be jumps to the current position (so that the
+// previous instruction must not be changed).
+func (fs *funcState54) previousInstr() int {
+	if fs.pc > fs.lastTarget {
+		return fs.pc - 1
+	}
+	return -1
+}
+
+// loadNil emits a LOADNIL for n registers starting at from, merging
+// it with a previous LOADNIL when possible.
+func (fs *funcState54) loadNil(from, n int) {
+	l := from + n - 1 // last register to set nil
+	if pc := fs.previousInstr(); pc >= 0 && fs.instr(pc).Code() == vm54.LOADNIL {
+		prev := fs.instr(pc)
+		pfrom := prev.A() // get previous range
+		pl := pfrom + prev.B()
+		if (pfrom <= from && from <= pl+1) || (from <= pfrom && pfrom <= l+1) { // can connect both?
+			if pfrom < from {
+				from = pfrom
+			}
+			if pl > l {
+				l = pl
+			}
+			fs.setInstr(pc, prev.WithA(from).WithB(l-from))
+			return
+		}
+	}
+	fs.codeABC(vm54.LOADNIL, from, n-1, 0) // else no optimization
+}
+
+// getJump returns the destination of the jump at pc, or noJump
+// if it is the end of a list.
+func (fs *funcState54) getJump(pc int) int {
+	if offset := fs.instr(pc).SJ(); offset != noJump {
+		return pc + 1 + offset // turn offset into absolute position
+	}
+	return noJump // end of list
+}
+
+// fixJump makes the jump at pc jump to dest.
+func (fs *funcState54) fixJump(pc, dest int) {
+	offset := dest - (pc + 1)
+	if !(-vm54.OffsetSJ <= offset && offset <= vm54.MaxArgSJ-vm54.OffsetSJ) {
+		fs.ls.syntaxError("control structure too long")
+	}
+	fs.setInstr(pc, fs.instr(pc).WithSJ(offset))
+}
+
+// concat appends the jump list l2 to the jump list l1.
+func (fs *funcState54) concat(l1 *int, l2 int) {
+	switch {
+	case l2 == noJump: // nothing to concatenate?
+	case *l1 == noJump: // no original list?
+		*l1 = l2
+	default:
+		list := *l1
+		for next := fs.getJump(list); next != noJump; next = fs.getJump(list) {
+			list = next // find last element
+		}
+		fs.fixJump(list, l2) // last element links to l2
+	}
+}
+
+// jump emits a jump instruction and returns its position, so that
+// its destination can be fixed later.
+func (fs *funcState54) jump() int { return fs.codesJ(vm54.JMP, noJump) }
+
+// jumpTo emits a jump to target.
+func (fs *funcState54) jumpTo(target int) { fs.patchList(fs.jump(), target) }
+
+// ret emits a return instruction.
+func (fs *funcState54) ret(first, nret int) {
+	op := vm54.RETURN
+	switch nret {
+	case 0:
+		op = vm54.RETURN0
+	case 1:
+		op = vm54.RETURN1
+	}
+	fs.codeABC(op, first, nret+1, 0)
+}
+
+// condJump emits a test instruction followed by a jump,
+// returning the position of the jump.
+func (fs *funcState54) condJump(op vm54.Code, a, b, c, k int) int {
+	fs.codeABCk(op, a, b, c, k)
+	return fs.jump()
+}
+
+// getLabel marks the current pc as a jump target and returns it.
+func (fs *funcState54) getLabel() int {
+	fs.lastTarget = fs.pc
+	return fs.pc
+}
+
+// jumpControl returns the position of the instruction controlling
+// the jump at pc (its condition), or pc itself if unconditional.
+func (fs *funcState54) jumpControl(pc int) int {
+	if pc >= 1 && fs.instr(pc-1).Code().Mask().Test() {
+		return pc - 1
+	}
+	return pc
+}
+
+// patchTestReg patches the destination register of a TESTSET
+// controlling node; if there is no register to put the value (or it
+// already has it) the TESTSET becomes a simple TEST. It reports
+// whether node was a TESTSET.
+func (fs *funcState54) patchTestReg(node, reg int) bool {
+	pc := fs.jumpControl(node)
+	i := fs.instr(pc)
+	if i.Code() != vm54.TESTSET {
+		return false // cannot patch other instructions
+	}
+	if reg != noReg54 && reg != i.B() {
+		fs.setInstr(pc, i.WithA(reg))
+	} else {
+		// no register to put value or register already has
+		// the value; change instruction to simple test.
+		fs.setInstr(pc, vm54.MakeABCk(vm54.TEST, i.B(), 0, 0, i.K()))
+	}
+	return true
+}
+
+// removeValues traverses a list of tests ensuring no one produces a value.
+func (fs *funcState54) removeValues(list int) {
+	for ; list != noJump; list = fs.getJump(list) {
+		fs.patchTestReg(list, noReg54)
+	}
+}
+
+// patchListAux traverses a list of tests, patching their destination
+// address and registers: tests producing values jump to vtarget (and
+// put their values in reg), other tests jump to dtarget.
+func (fs *funcState54) patchListAux(list, vtarget, reg, dtarget int) {
+	for list != noJump {
+		next := fs.getJump(list)
+		if fs.patchTestReg(list, reg) {
+			fs.fixJump(list, vtarget)
+		} else {
+			fs.fixJump(list, dtarget) // jump to default target
+		}
+		list = next
+	}
+}
+
+// patchList makes all jumps in list jump to target.
+func (fs *funcState54) patchList(list, target int) {
+	fs.patchListAux(list, target, noReg54, target)
+}
+
+// patchToHere makes all jumps in list jump to the current position.
+func (fs *funcState54) patchToHere(list int) {
+	hr := fs.getLabel() // mark "here" as a jump target
+	fs.patchList(list, hr)
+}
+
+// code emits instruction i, returning its position.
+func (fs *funcState54) code(i vm54.Instr) int {
+	fs.f.Code = append(fs.f.Code[:fs.pc], uint32(i))
+	fs.f.PcLnTab = append(fs.f.PcLnTab[:fs.pc], uint32(fs.ls.LastLine()))
+	fs.pc++
+	return fs.pc - 1
+}
+
+// removeLastInstruction removes the last instruction emitted.
+func (fs *funcState54) removeLastInstruction() {
+	fs.pc--
+	fs.f.Code = fs.f.Code[:fs.pc]
+	fs.f.PcLnTab = fs.f.PcLnTab[:fs.pc]
+}
+
+func (fs *funcState54) codeABCk(op vm54.Code, a, b, c, k int) int {
+	return fs.code(vm54.MakeABCk(op, a, b, c, k))
+}
+
+func (fs *funcState54) codeABC(op vm54.Code, a, b, c int) int {
+	return fs.codeABCk(op, a, b, c, 0)
+}
+
+func (fs *funcState54) codeABx(op vm54.Code, a, bx int) int {
+	return fs.code(vm54.MakeABx(op, a, bx))
+}
+
+func (fs *funcState54) codeAsBx(op vm54.Code, a, sbx int) int {
+	return fs.code(vm54.MakeAsBx(op, a, sbx))
+}
+
+func (fs *funcState54) codesJ(op vm54.Code, sj int) int {
+	return fs.code(vm54.MakesJ(op, sj))
+}
+
+func (fs *funcState54) codeExtraArg(a int) int {
+	return fs.code(vm54.MakeAx(vm54.EXTRAARG, a))
+}
+
+// codeK emits a "load constant" instruction, using either LOADK or
+// LOADKX (with an extra argument) depending on the index.
+func (fs *funcState54) codeK(reg, k int) int {
+	if k <= vm54.MaxArgBX {
+		return fs.codeABx(vm54.LOADK, reg, k)
+	}
+	p := fs.codeABx(vm54.LOADKX, reg, 0)
+	fs.codeExtraArg(k)
+	return p
+}
+
+// checkStack ensures there are n registers free above freeReg.
+func (fs *funcState54) checkStack(n int) {
+	if size := fs.freeReg + n; size > int(fs.f.Stack) {
+		if size >= maxRegs {
+			fs.ls.syntaxError("function or expression needs too many registers")
+		}
+		fs.f.Stack = byte(size)
+	}
+}
+
+// reserveRegs reserves n registers.
+func (fs *funcState54) reserveRegs(n int) {
+	fs.checkStack(n)
+	fs.freeReg += n
+}
+
+// freeRegister frees register reg if it is not a local variable.
+func (fs *funcState54) freeRegister(reg int) {
+	if reg >= fs.nVarStack() {
+		fs.freeReg--
+	}
+}
+
+// freeRegs frees registers r1 and r2 in proper order.
+func (fs *funcState54) freeRegs(r1, r2 int) {
+	if r1 > r2 {
+		fs.freeRegister(r1)
+		fs.freeRegister(r2)
+	} else {
+		fs.freeRegister(r2)
+		fs.freeRegister(r1)
+	}
+}
+
+// freeExp frees the register used by e (if any).
+func (fs *funcState54) freeExp(e *expDesc54) {
+	if e.k == vNonReloc {
+		fs.freeRegister(e.info)
+	}
+}
+
+// freeExps frees the registers used by e1 and e2 in proper order.
+func (fs *funcState54) freeExps(e1, e2 *expDesc54) {
+	r1, r2 := -1, -1
+	if e1.k == vNonReloc {
+		r1 = e1.info
+	}
+	if e2.k == vNonReloc {
+		r2 = e2.info
+	}
+	fs.freeRegs(r1, r2)
+}
+
+// addK adds constant v to the function's constants, reusing an
+// existing entry found through key.
+func (fs *funcState54) addK(key, v interface{}) int {
+	if k, ok := fs.ls.cache[key]; ok {
+		// correct value? (must distinguish floats from integers!)
+		if k < len(fs.f.Consts) && fs.f.Consts[k] == v {
+			return k // reuse index
+		}
+	}
+	k := len(fs.f.Consts)
+	fs.ls.cache[key] = k
+	fs.f.Consts = append(fs.f.Consts, v)
+	return k
+}
+
+func (fs *funcState54) stringK(s string) int { return fs.addK(s, s) }
+
+func (fs *funcState54) intK(n int64) int { return fs.addK(intKey(n), n) }
+
+func (fs *funcState54) numberK(r float64) int {
+	// floats are keyed by value, normalizing integral ones
+	// the way table keys are.
+	if i, ok := floatToInt(r); ok {
+		return fs.addK(i, r)
+	}
+	return fs.addK(r, r)
+}
+
+func (fs *funcState54) boolK(b bool) int { return fs.addK(b, b) }
+
+func (fs *funcState54) nilK() int { return fs.addK(nilKey{}, nil) }
+
+// fitsC reports whether i fits in an sC argument.
+func fitsC(i int64) bool { return uint64(i)+vm54.OffsetSC <= vm54.MaxArgC }
+
+// fitsBx reports whether i fits in an sBx argument.
+func fitsBx(i int64) bool { return -vm54.OffsetSBX <= i && i <= vm54.MaxArgBX-vm54.OffsetSBX }
+
+// loadInt emits code to load the integer i into reg.
+func (fs *funcState54) loadInt(reg int, i int64) {
+	if fitsBx(i) {
+		fs.codeAsBx(vm54.LOADI, reg, int(i))
+	} else {
+		fs.codeK(reg, fs.intK(i))
+	}
+}
+
+// loadFloat emits code to load the float f into reg.
+func (fs *funcState54) loadFloat(reg int, f float64) {
+	if fi, ok := floatToInt(f); ok && fitsBx(fi) {
+		fs.codeAsBx(vm54.LOADF, reg, int(fi))
+	} else {
+		fs.codeK(reg, fs.numberK(f))
+	}
+}
+
+// constToExp converts the constant value v into the expression e.
+func constToExp(v interface{}, e *expDesc54) {
+	switch v := v.(type) {
+	case int64:
+		e.k, e.ival = vKInt, v
+	case float64:
+		e.k, e.nval = vKFlt, v
+	case bool:
+		if v {
+			e.k = vTrue
+		} else {
+			e.k = vFalse
+		}
+	case string:
+		e.k, e.strval = vKStr, v
+	default:
+		e.k = vNil
+	}
+}
+
+// setReturns fixes an expression to return the given number of results.
+func (fs *funcState54) setReturns(e *expDesc54, nresults int) {
+	i := fs.instr(e.info).WithC(nresults + 1)
+	if e.k == vCall { // expression is an open function call?
+		fs.setInstr(e.info, i)
+	} else { // e.k == vVararg
+		fs.setInstr(e.info, i.WithA(fs.freeReg))
+		fs.reserveRegs(1)
+	}
+}
+
+func (fs *funcState54) setMultRet(e *expDesc54) { fs.setReturns(e, multRet) }
+
+// str2K converts a vKStr expression into a vK one.
+func (fs *funcState54) str2K(e *expDesc54) {
+	e.info = fs.stringK(e.strval)
+	e.k = vK
+}
+
+// setOneRet fixes an expression to return one result.
+func (fs *funcState54) setOneRet(e *expDesc54) {
+	switch e.k {
+	case vCall: // expression is an open function call?
+		// already returns 1 value
+		e.k = vNonReloc // result has fixed position
+		e.info = fs.instr(e.info).A()
+	case vVararg:
+		fs.setInstr(e.info, fs.instr(e.info).WithC(2))
+		e.k = vRelocable // can relocate its simple result
+	}
+}
+
+// dischargeVars ensures e is not a variable (nor a constant
+// variable).
+func (fs *funcState54) dischargeVars(e *expDesc54) {
+	switch e.k {
+	case vConst:
+		constToExp(fs.ls.actVar[e.info].k, e)
+	case vLocal: // already in a register
+		e.info = e.ridx
+		e.k = vNonReloc // becomes a non-relocatable value
+	case vUpval: // move value to some (pending) register
+		e.info = fs.codeABC(vm54.GETUPVAL, 0, e.info, 0)
+		e.k = vRelocable
+	case vIndexUp:
+		e.info = fs.codeABC(vm54.GETTABUP, 0, e.ind.t, e.ind.idx)
+		e.k = vRelocable
+	case vIndexInt:
+		fs.freeRegister(e.ind.t)
+		e.info = fs.codeABC(vm54.GETI, 0, e.ind.t, e.ind.idx)
+		e.k = vRelocable
+	case vIndexStr:
+		fs.freeRegister(e.ind.t)
+		e.info = fs.codeABC(vm54.GETFIELD, 0, e.ind.t, e.ind.idx)
+		e.k = vRelocable
+	case vIndexed:
+		fs.freeRegs(e.ind.t, e.ind.idx)
+		e.info = fs.codeABC(vm54.GETTABLE, 0, e.ind.t, e.ind.idx)
+		e.k = vRelocable
+	case vVararg, vCall:
+		fs.setOneRet(e)
+	}
+}
+
+// discharge2Reg ensures the value of e (if it is not a jump)
+// is in register reg.
+func (fs *funcState54) discharge2Reg(e *expDesc54, reg int) {
+	fs.dischargeVars(e)
+	switch e.k {
+	case vNil:
+		fs.loadNil(reg, 1)
+	case vFalse:
+		fs.codeABC(vm54.LOADFALSE, reg, 0, 0)
+	case vTrue:
+		fs.codeABC(vm54.LOADTRUE, reg, 0, 0)
+	case vKStr:
+		fs.str2K(e)
+		fs.codeK(reg, e.info)
+	case vK:
+		fs.codeK(reg, e.info)
+	case vKFlt:
+		fs.loadFloat(reg, e.nval)
+	case vKInt:
+		fs.loadInt(reg, e.ival)
+	case vRelocable:
+		fs.setInstr(e.info, fs.instr(e.info).WithA(reg)) // instruction will put result in reg
+	case vNonReloc:
+		if reg != e.info {
+			fs.codeABC(vm54.MOVE, reg, e.info, 0)
+		}
+	default: // vJmp; nothing to do...
+		return
+	}
+	e.info = reg
+	e.k = vNonReloc
+}
+
+// discharge2AnyReg ensures e is in some register.
+func (fs *funcState54) discharge2AnyReg(e *expDesc54) {
+	if e.k != vNonReloc { // no fixed register yet?
+		fs.reserveRegs(1)                 // get a register
+		fs.discharge2Reg(e, fs.freeReg-1) // put value there
+	}
+}
+
+func (fs *funcState54) codeLoadBool(a int, op vm54.Code) int {
+	fs.getLabel() // those instructions may be jump targets
+	return fs.codeABC(op, a, 0, 0)
+}
+
+// needValue reports whether list has any jump that does not
+// produce a value (or produces an inverted value).
+func (fs *funcState54) needValue(list int) bool {
+	for ; list != noJump; list = fs.getJump(list) {
+		if fs.instr(fs.jumpControl(list)).Code() != vm54.TESTSET {
+			return true
+		}
+	}
+	return false // not found
+}
+
+// exp2Reg ensures the final value of e (including the results of
+// its jump lists) is in register reg.
+func (fs *funcState54) exp2Reg(e *expDesc54, reg int) {
+	fs.discharge2Reg(e, reg)
+	if e.k == vJmp { // expression itself is a test?
+		fs.concat(&e.t, e.info) // put this jump in t list
+	}
+	if hasJumps54(e) {
+		pf := noJump // position of an eventual LOAD false
+		pt := noJump // position of an eventual LOAD true
+		if fs.needValue(e.t) || fs.needValue(e.f) {
+			fj := noJump
+			if e.k != vJmp {
+				fj = fs.jump()
+			}
+			pf = fs.codeLoadBool(reg, vm54.LFALSESKIP) // skip next inst.
+			pt = fs.codeLoadBool(reg, vm54.LOADTRUE)
+			// jump around these booleans if e is not a test
+			fs.patchToHere(fj)
+		}
+		final := fs.getLabel() // position after whole expression
+		fs.patchListAux(e.f, final, reg, pf)
+		fs.patchListAux(e.t, final, reg, pt)
+	}
+	e.f, e.t = noJump, noJump
+	e.info = reg
+	e.k = vNonReloc
+}
+
+// exp2NextReg ensures the final value of e is in the next
+// available register.
+func (fs *funcState54) exp2NextReg(e *expDesc54) {
+	fs.dischargeVars(e)
+	fs.freeExp(e)
+	fs.reserveRegs(1)
+	fs.exp2Reg(e, fs.freeReg-1)
+}
+
+// exp2AnyReg ensures the final value of e is in some register
+// and returns that register.
+func (fs *funcState54) exp2AnyReg(e *expDesc54) int {
+	fs.dischargeVars(e)
+	if e.k == vNonReloc { // expression already has a register?
+		if !hasJumps54(e) { // no jumps?
+			return e.info // result is already in a register
+		}
+		if e.info >= fs.nVarStack() { // reg. is not a local?
+			fs.exp2Reg(e, e.info) // put final result in it
+			return e.info
+		}
+		// else expression has jumps and cannot change its register
+		// to hold the jump values, because it is a local variable.
+	}
+	fs.exp2NextReg(e) // default: use next available register
+	return e.info
+}
+
+// exp2AnyRegUp ensures the final value of e is in a register
+// or in an upvalue.
+func (fs *funcState54) exp2AnyRegUp(e *expDesc54) {
+	if e.k != vUpval || hasJumps54(e) {
+		fs.exp2AnyReg(e)
+	}
+}
+
+// exp2Val ensures e is either in a register or is a constant.
+func (fs *funcState54) exp2Val(e *expDesc54) {
+	if hasJumps54(e) {
+		fs.exp2AnyReg(e)
+	} else {
+		fs.dischargeVars(e)
+	}
+}
+
+// exp2K tries to make e a vK expression with a constant index that
+// fits in an RK argument, reporting whether it succeeded.
+func (fs *funcState54) exp2K(e *expDesc54) bool {
+	if hasJumps54(e) {
+		return false
+	}
+	var info int
+	switch e.k { // move constants to k
+	case vTrue:
+		info = fs.boolK(true)
+	case vFalse:
+		info = fs.boolK(false)
+	case vNil:
+		info = fs.nilK()
+	case vKInt:
+		info = fs.intK(e.ival)
+	case vKFlt:
+		info = fs.numberK(e.nval)
+	case vKStr:
+		info = fs.stringK(e.strval)
+	case vK:
+		info = e.info
+	default:
+		return false // not a constant
+	}
+	if info <= maxIndexRK54 { // does constant fit in argC?
+		e.k = vK // make expression a K expression
+		e.info = info
+		return true
+	}
+	// else, expression doesn't fit; leave it unchanged
+	return false
+}
+
+// exp2RK ensures the final value of e is in a register or in a
+// constant index that fits in an RK argument, reporting whether
+// it is a constant.
+func (fs *funcState54) exp2RK(e *expDesc54) bool {
+	if fs.exp2K(e) {
+		return true
+	}
+	// not a constant in the right range: put it in a register
+	fs.exp2AnyReg(e)
+	return false
+}
+
+func (fs *funcState54) codeABRK(op vm54.Code, a, b int, ec *expDesc54) {
+	var k int
+	if fs.exp2RK(ec) {
+		k = 1
+	}
+	fs.codeABCk(op, a, b, ec.info, k)
+}
+
+// storeVar generates code to store the result of ex into var.
+func (fs *funcState54) storeVar(v, ex *expDesc54) {
+	switch v.k {
+	case vLocal:
+		fs.freeExp(ex)
+		fs.exp2Reg(ex, v.ridx) // compute ex into proper place
+		return
+	case vUpval:
+		e := fs.exp2AnyReg(ex)
+		fs.codeABC(vm54.SETUPVAL, e, v.info, 0)
+	case vIndexUp:
+		fs.codeABRK(vm54.SETTABUP, v.ind.t, v.ind.idx, ex)
+	case vIndexInt:
+		fs.codeABRK(vm54.SETI, v.ind.t, v.ind.idx, ex)
+	case vIndexStr:
+		fs.codeABRK(vm54.SETFIELD, v.ind.t, v.ind.idx, ex)
+	case vIndexed:
+		fs.codeABRK(vm54.SETTABLE, v.ind.t, v.ind.idx, ex)
+	}
+	fs.freeExp(ex)
+}
+
+// self emits SELF instruction (convert expression e into e:key(e,).
+func (fs *funcState54) self(e, key *expDesc54) {
+	fs.exp2AnyReg(e)
+	ereg := e.info // register where e was placed
+	fs.freeExp(e)
+	e.info = fs.freeReg // base register for op_self
+	e.k = vNonReloc     // self expression has a fixed register
+	fs.reserveRegs(2)   // function and self produced by op_self
+	fs.codeABRK(vm54.SELF, e.info, ereg, key)
+	fs.freeExp(key)
+}
+
+// negateCondition negates the condition e (which must be a jump).
+func (fs *funcState54) negateCondition(e *expDesc54) {
+	pc := fs.jumpControl(e.info)
+	i := fs.instr(pc)
+	fs.setInstr(pc, i.WithK(i.K()^1))
+}
+
+// jumpOnCond emits an instruction to jump if e is cond (that is, if
+// cond is true, code will jump if e is true) and returns the jump
+// position.
+func (fs *funcState54) jumpOnCond(e *expDesc54, cond int) int {
+	if e.k == vRelocable {
+		if ie := fs.instr(e.info); ie.Code() == vm54.NOT {
+			fs.removeLastInstruction() // remove previous OP_NOT
+			return fs.condJump(vm54.TEST, ie.B(), 0, 0, cond^1)
+		}
+		// else go through
+	}
+	fs.discharge2AnyReg(e)
+	fs.freeExp(e)
+	return fs.condJump(vm54.TESTSET, noReg54, e.info, 0, cond)
+}
+
+// goIfTrue emits code to go through if e is true, jump otherwise.
+func (fs *funcState54) goIfTrue(e *expDesc54) {
+	var pc int // pc of new jump
+	fs.dischargeVars(e)
+	switch e.k {
+	case vJmp: // condition?
+		fs.negateCondition(e) // jump when it is false
+		pc = e.info           // save jump position
+	case vK, vKFlt, vKInt, vKStr, vTrue:
+		pc = noJump // always true; do nothing
+	default:
+		pc = fs.jumpOnCond(e, 0) // jump when false
+	}
+	fs.concat(&e.f, pc) // insert new jump in false list
+	fs.patchToHere(e.t) // true list jumps to here (to go through)
+	e.t = noJump
+}
+
+// goIfFalse emits code to go through if e is false, jump otherwise.
+func (fs *funcState54) goIfFalse(e *expDesc54) {
+	var pc int // pc of new jump
+	fs.dischargeVars(e)
+	switch e.k {
+	case vJmp:
+		pc = e.info // already jump if true
+	case vNil, vFalse:
+		pc = noJump // always false; do nothing
+	default:
+		pc = fs.jumpOnCond(e, 1) // jump if true
+	}
+	fs.concat(&e.t, pc) // insert new jump in t list
+	fs.patchToHere(e.f) // false list jumps to here (to go through)
+	e.f = noJump
+}
+
+// codeNot emits code for 'not e', doing constant folding.
+func (fs *funcState54) codeNot(e *expDesc54) {
+	switch e.k {
+	case vNil, vFalse:
+		e.k = vTrue // true == not nil == not false
+	case vK, vKFlt, vKInt, vKStr, vTrue:
+		e.k = vFalse // false == not "x" == not 0.5 == not 1 == not true
+	case vJmp:
+		fs.negateCondition(e)
+	case vRelocable, vNonReloc:
+		fs.discharge2AnyReg(e)
+		fs.freeExp(e)
+		e.info = fs.codeABC(vm54.NOT, 0, e.info, 0)
+		e.k = vRelocable
+	}
+	// interchange true and false lists
+	e.f, e.t = e.t, e.f
+	fs.removeValues(e.f) // values are useless when negated
+	fs.removeValues(e.t)
+}
+
+// isKstr reports whether e is a short literal string in the
+// constants, with an index that fits in argument B.
+func (fs *funcState54) isKstr(e *expDesc54) bool {
+	if e.k != vK || hasJumps54(e) || e.info > vm54.MaxArgB {
+		return false
+	}
+	s, ok := fs.f.Consts[e.info].(string)
+	return ok && len(s) <= maxShortLen
+}
+
+// isKint reports whether e is a literal integer.
+func isKint(e *expDesc54) bool { return e.k == vKInt && !hasJumps54(e) }
+
+// isCint reports whether e is a literal integer in the range of
+// argument C.
+func isCint(e *expDesc54) bool { return isKint(e) && uint64(e.ival) <= vm54.MaxArgC }
+
+// isSCint reports whether e is a literal integer in the range of
+// argument sC.
+func isSCint(e *expDesc54) bool { return isKint(e) && fitsC(e.ival) }
+
+// isSCnumber reports whether e is a literal number (integer or float
+// with an integral value) in the range of argument sC, returning it
+// encoded as such and whether it is a float.
+func isSCnumber(e *expDesc54) (im int, isFloat, ok bool) {
+	var i int64
+	switch {
+	case e.k == vKInt:
+		i = e.ival
+	case e.k == vKFlt:
+		if i, ok = floatToInt(e.nval); !ok {
+			return 0, false, false
+		}
+		isFloat = true
+	default:
+		return 0, false, false // not a number
+	}
+	if !hasJumps54(e) && fitsC(i) {
+		return vm54.IntToSC(int(i)), isFloat, true
+	}
+	return 0, false, false
+}
+
+// indexed creates the expression t[k]; t must have its final result
+// already in a register or upvalue. Upvalues can only be indexed by
+// literal strings; keys can be literal strings in the constants or
+// literal integers in the range of argument C.
+func (fs *funcState54) indexed(t, k *expDesc54) {
+	if k.k == vKStr {
+		fs.str2K(k)
+	}
+	if t.k == vUpval && !fs.isKstr(k) { // upvalue indexed by non 'Kstr'?
+		fs.exp2AnyReg(t) // put it in a register
+	}
+	if t.k == vUpval {
+		t.ind.t = t.info   // upvalue index
+		t.ind.idx = k.info // literal short string
+		t.k = vIndexUp
+		return
+	}
+	// register index of the table
+	if t.k == vLocal {
+		t.ind.t = t.ridx
+	} else {
+		t.ind.t = t.info
+	}
+	switch {
+	case fs.isKstr(k):
+		t.ind.idx = k.info // literal short string
+		t.k = vIndexStr
+	case isCint(k):
+		t.ind.idx = int(k.ival) // int. constant in proper range
+		t.k = vIndexInt
+	default:
+		t.ind.idx = fs.exp2AnyReg(k) // register
+		t.k = vIndexed
+	}
+}
+
+// constFolding tries to fold the numeric operation op on e1 and e2
+// into e1, reporting whether it succeeded.
+func (fs *funcState54) constFolding(op arithOp, e1, e2 *expDesc54) bool {
+	v1, ok1 := numeral54(e1)
+	v2, ok2 := numeral54(e2)
+	if !ok1 || !ok2 || !validOp(op, v1, v2) {
+		return false // non-numeric operands or not safe to fold
+	}
+	switch res := arith(op, v1, v2).(type) {
+	case int64:
+		e1.k, e1.ival = vKInt, res
+	case float64:
+		// folds neither NaN nor 0.0 (to avoid problems with -0.0)
+		if math.IsNaN(res) || res == 0 {
+			return false
+		}
+		e1.k, e1.nval = vKFlt, res
+	}
+	return true
+}
+
+// codeUnExpVal emits code for unary expressions that "produce values"
+// (everything but 'not'). Expression to produce final result will be
+// encoded in e.
+func (fs *funcState54) codeUnExpVal(op vm54.Code, e *expDesc54, line int) {
+	r := fs.exp2AnyReg(e) // opcodes operate only on registers
+	fs.freeExp(e)
+	e.info = fs.codeABC(op, 0, r, 0) // generate opcode
+	e.k = vRelocable                 // all those operations are relocatable
+	fs.fixLine(line)
+}
+
+// finishBinExpVal emits code for binary expressions that "produce
+// values" over two registers, followed by the instruction that calls
+// the metamethod event (with operands swapped if flip is set) when
+// the fast path fails.
+func (fs *funcState54) finishBinExpVal(e1, e2 *expDesc54, op vm54.Code, v2, flip, line int, mmop vm54.Code, event int) {
+	v1 := fs.exp2AnyReg(e1)
+	pc := fs.codeABC(op, 0, v1, v2)
+	fs.freeExps(e1, e2)
+	e1.info = pc
+	e1.k = vRelocable // all those operations are relocatable
+	fs.fixLine(line)
+	fs.codeABCk(mmop, v1, v2, event, flip) // to call metamethod
+	fs.fixLine(line)
+}
+
+// codeBinExpVal emits code for binary expressions over two registers.
+func (fs *funcState54) codeBinExpVal(opr binOpr, e1, e2 *expDesc54, line int) {
+	op := vm54.Code(opr-oprAdd) + vm54.ADD
+	v2 := fs.exp2AnyReg(e2) // make sure e2 is in a register
+	fs.finishBinExpVal(e1, e2, op, v2, 0, line, vm54.MMBIN, int(opr-oprAdd)+tmAdd)
+}
+
+// codeBinI emits code for binary operators with an immediate operand.
+func (fs *funcState54) codeBinI(op vm54.Code, e1, e2 *expDesc54, flip, line, event int) {
+	v2 := vm54.IntToSC(int(e2.ival)) // immediate operand
+	fs.finishBinExpVal(e1, e2, op, v2, flip, line, vm54.MMBINI, event)
+}
+
+// codeBinK emits code for binary operators with a constant operand.
+func (fs *funcState54) codeBinK(opr binOpr, e1, e2 *expDesc54, flip, line int) {
+	event := int(opr-oprAdd) + tmAdd
+	v2 := e2.info // K index
+	op := vm54.Code(opr-oprAdd) + vm54.ADDK
+	fs.finishBinExpVal(e1, e2, op, v2, flip, line, vm54.MMBINK, event)
+}
+
+// finishBinExpNeg tries to code a binary operator negating its second
+// operand, e.g. 'a - 1' as 'a + -1'; it reports whether it succeeded.
+func (fs *funcState54) finishBinExpNeg(e1, e2 *expDesc54, op vm54.Code, line, event int) bool {
+	if !isKint(e2) {
+		return false // not an integer constant
+	}
+	i2 := e2.ival
+	if !(fitsC(i2) && fitsC(-i2)) {
+		return false // not in the proper range
+	}
+	// operating a small integer constant
+	v2 := int(i2)
+	fs.finishBinExpVal(e1, e2, op, vm54.IntToSC(-v2), 0, line, vm54.MMBINI, event)
+	// correct metamethod argument
+	fs.setInstr(fs.pc-1, fs.instr(fs.pc-1).WithB(vm54.IntToSC(v2)))
+	return true // successfully coded
+}
+
+func swapExps(e1, e2 *expDesc54) { *e1, *e2 = *e2, *e1 }
+
+// codeBinNoK emits code for binary operators with no constant operand.
+func (fs *funcState54) codeBinNoK(opr binOpr, e1, e2 *expDesc54, flip, line int) {
+	if flip != 0 {
+		swapExps(e1, e2) // back to original order
+	}
+	fs.codeBinExpVal(opr, e1, e2, line) // use standard operators
+}
+
+// codeArith emits code for arithmetic operators ('+', '-', ...).
+// If the second operand is a constant in the proper range, use
+// variant opcodes with K operands.
+func (fs *funcState54) codeArith(opr binOpr, e1, e2 *expDesc54, flip, line int) {
+	if _, ok := numeral54(e2); ok && fs.exp2K(e2) { // K operand?
+		fs.codeBinK(opr, e1, e2, flip, line)
+	} else { // e2 is neither an immediate nor a K operand
+		fs.codeBinNoK(opr, e1, e2, flip, line)
+	}
+}
+
+// codeCommutative emits code for commutative operators ('+', '*'). If
+// the first operand is a numeric constant, change the order of the
+// operands to try to use an immediate or K operator.
+func (fs *funcState54) codeCommutative(op binOpr, e1, e2 *expDesc54, line int) {
+	flip := 0
+	if _, ok := numeral54(e1); ok { // is first operand a numeric constant?
+		swapExps(e1, e2) // change order
+		flip = 1
+	}
+	if op == oprAdd && isSCint(e2) { // immediate operand?
+		fs.codeBinI(vm54.ADDI, e1, e2, flip, line, tmAdd)
+	} else {
+		fs.codeArith(op, e1, e2, flip, line)
+	}
+}
+
+// codeBitwise emits code for bitwise operations; they are all
+// commutative, so the function tries to put an integer constant as
+// the 2nd operand (a K operand).
+func (fs *funcState54) codeBitwise(opr binOpr, e1, e2 *expDesc54, line int) {
+	flip := 0
+	if e1.k == vKInt {
+		swapExps(e1, e2) // e2 will be the constant operand
+		flip = 1
+	}
+	if e2.k == vKInt && fs.exp2K(e2) { // K operand?
+		fs.codeBinK(opr, e1, e2, flip, line)
+	} else { // no constants
+		fs.codeBinNoK(opr, e1, e2, flip, line)
+	}
+}
+
+// codeOrder emits code for order comparisons. When using an immediate
+// operand, isFloat tells whether the original value was a float.
+func (fs *funcState54) codeOrder(opr binOpr, e1, e2 *expDesc54) {
+	var (
+		r1, r2  int
+		isFloat bool
+		op      vm54.Code
+	)
+	if im, isf, ok := isSCnumber(e2); ok {
+		// use immediate operand
+		r1 = fs.exp2AnyReg(e1)
+		r2, isFloat = im, isf
+		op = vm54.Code(opr-oprLt) + vm54.LTI
+	} else if im, isf, ok := isSCnumber(e1); ok {
+		// transform (A < B) to (B > A) and (A <= B) to (B >= A)
+		r1 = fs.exp2AnyReg(e2)
+		r2, isFloat = im, isf
+		op = vm54.Code(opr-oprLt) + vm54.GTI
+	} else { // regular case, compare two registers
+		r1 = fs.exp2AnyReg(e1)
+		r2 = fs.exp2AnyReg(e2)
+		op = vm54.Code(opr-oprLt) + vm54.LT
+	}
+	fs.freeExps(e1, e2)
+	c := 0
+	if isFloat {
+		c = 1
+	}
+	e1.info = fs.condJump(op, r1, r2, c, 1)
+	e1.k = vJmp
+}
+
+// codeEq emits code for equality comparisons ('==', '~=').
+// e1 was already put as RK by infix.
+func (fs *funcState54) codeEq(opr binOpr, e1, e2 *expDesc54) {
+	var (
+		r1, r2  int
+		isFloat bool
+		op      vm54.Code
+	)
+	if e1.k != vNonReloc {
+		// e1 is vK, vKInt or vKFlt
+		swapExps(e1, e2)
+	}
+	r1 = fs.exp2AnyReg(e1) // 1st expression must be in register
+	if im, isf, ok := isSCnumber(e2); ok {
+		op = vm54.EQI
+		r2, isFloat = im, isf // immediate operand
+	} else if fs.exp2RK(e2) { // 2nd expression is a constant?
+		op = vm54.EQK
+		r2 = e2.info // constant index
+	} else {
+		op = vm54.EQ // will compare two registers
+		r2 = fs.exp2AnyReg(e2)
+	}
+	fs.freeExps(e1, e2)
+	c, k := 0, 0
+	if isFloat {
+		c = 1
+	}
+	if opr == oprEq {
+		k = 1
+	}
+	e1.info = fs.condJump(op, r1, r2, c, k)
+	e1.k = vJmp
+}
+
+// prefix applies the unary operator op to expression e.
+func (fs *funcState54) prefix(op unOpr, e *expDesc54, line int) {
+	ef := &expDesc54{k: vKInt, t: noJump, f: noJump} // fake 2nd operand
+	fs.dischargeVars(e)
+	switch op {
+	case oprMinus, oprBNot:
+		if fs.constFolding(arithOp(op)+arithUnm, e, ef) {
+			break
+		}
+		fs.codeUnExpVal(vm54.Code(op)+vm54.UNM, e, line)
+	case oprLen:
+		fs.codeUnExpVal(vm54.LEN, e, line)
+	case oprNot:
+		fs.codeNot(e)
+	}
+}
+
+// infix processes the first operand v of binary operation op before
+// reading the second operand.
+func (fs *funcState54) infix(op binOpr, v *expDesc54) {
+	fs.dischargeVars(v)
+	switch op {
+	case oprAnd:
+		fs.goIfTrue(v) // go ahead only if v is true
+	case oprOr:
+		fs.goIfFalse(v) // go ahead only if v is false
+	case oprConcat:
+		fs.exp2NextReg(v) // operand must be on the stack
+	case oprAdd, oprSub, oprMul, oprDiv, oprIDiv, oprMod, oprPow,
+		oprBAnd, oprBOr, oprBXor, oprShl, oprShr:
+		if _, ok := numeral54(v); !ok {
+			fs.exp2AnyReg(v)
+		}
+		// else keep numeral, which may be folded or used as an
+		// immediate operand
+	case oprEq, oprNE:
+		if _, ok := numeral54(v); !ok {
+			fs.exp2RK(v)
+		}
+		// else keep numeral, which may be an immediate operand
+	case oprLt, oprLe, oprGT, oprGE:
+		if _, _, ok := isSCnumber(v); !ok {
+			fs.exp2AnyReg(v)
+		}
+		// else keep numeral, which may be an immediate operand
+	}
+}
+
+// codeConcat creates code for '(e1 .. e2)'. For '(e1 .. e2.1 .. e2.2)'
+// (which is '(e1 .. (e2.1 .. e2.2))', because concatenation is right
+// associative), merge both CONCATs.
+func (fs *funcState54) codeConcat(e1, e2 *expDesc54, line int) {
+	if pc := fs.previousInstr(); pc >= 0 && fs.instr(pc).Code() == vm54.CONCAT { // is e2 a concatenation?
+		ie2 := fs.instr(pc)
+		n := ie2.B() // # of concatenated expressions
+		fs.freeExp(e2)
+		fs.setInstr(pc, ie2.WithA(e1.info).WithB(n+1)) // will concatenate one more element
+	} else { // e2 is not a concatenation
+		fs.codeABC(vm54.CONCAT, e1.info, 2, 0) // new instruction
+		fs.freeExp(e2)
+		fs.fixLine(line)
+	}
+}
+
+// posfix finalizes code for binary operation op after reading the
+// second operand.
+func (fs *funcState54) posfix(opr binOpr, e1, e2 *expDesc54, line int) {
+	fs.dischargeVars(e2)
+	if opr <= oprShr && fs.constFolding(arithOp(opr), e1, e2) {
+		return // done by folding
+	}
+	switch opr {
+	case oprAnd:
+		fs.concat(&e2.f, e1.f)
+		*e1 = *e2
+	case oprOr:
+		fs.concat(&e2.t, e1.t)
+		*e1 = *e2
+	case oprConcat: // e1 .. e2
+		fs.exp2NextReg(e2)
+		fs.codeConcat(e1, e2, line)
+	case oprAdd, oprMul:
+		fs.codeCommutative(opr, e1, e2, line)
+	case oprSub:
+		if fs.finishBinExpNeg(e1, e2, vm54.ADDI, line, tmAdd+int(oprSub)) {
+			break // coded as (r1 + -I)
+		}
+		fs.codeArith(opr, e1, e2, 0, line)
+	case oprDiv, oprIDiv, oprMod, oprPow:
+		fs.codeArith(opr, e1, e2, 0, line)
+	case oprBAnd, oprBOr, oprBXor:
+		fs.codeBitwise(opr, e1, e2, line)
+	case oprShl:
+		if isSCint(e1) {
+			swapExps(e1, e2)
+			fs.codeBinI(vm54.SHLI, e1, e2, 1, line, tmAdd+int(oprShl)) // I << r2
+		} else if fs.finishBinExpNeg(e1, e2, vm54.SHRI, line, tmAdd+int(oprShl)) {
+			// coded as (r1 >> -I)
+		} else { // regular case (two registers)
+			fs.codeBinExpVal(opr, e1, e2, line)
+		}
+	case oprShr:
+		if isSCint(e2) {
+			fs.codeBinI(vm54.SHRI, e1, e2, 0, line, tmAdd+int(oprShr)) // r1 >> I
+		} else { // regular case (two registers)
+			fs.codeBinExpVal(opr, e1, e2, line)
+		}
+	case oprEq, oprNE:
+		fs.codeEq(opr, e1, e2)
+	case oprGT, oprGE:
+		// '(a > b)' <=> '(b < a)';  '(a >= b)' <=> '(b <= a)'
+		swapExps(e1, e2)
+		fs.codeOrder(opr-oprGT+oprLt, e1, e2)
+	case oprLt, oprLe:
+		fs.codeOrder(opr, e1, e2)
+	}
+}
+
+// fixLine changes the line information of the last instruction.
+func (fs *funcState54) fixLine(line int) { fs.f.PcLnTab[fs.pc-1] = uint32(line) }
+
+// setTableSize sets the array and hash sizes of the NEWTABLE
+// instruction at pc (followed by its extra argument).
+func (fs *funcState54) setTableSize(pc, ra, asize, hsize int) {
+	rb := 0
+	if hsize != 0 {
+		rb = bits.Len(uint(hsize-1)) + 1 // hash size
+	}
+	extra := asize / (vm54.MaxArgC + 1) // higher bits of array size
+	rc := asize % (vm54.MaxArgC + 1)    // lower bits of array size
+	k := 0
+	if extra > 0 {
+		k = 1 // needs extra argument
+	}
+	fs.setInstr(pc, vm54.MakeABCk(vm54.NEWTABLE, ra, rb, rc, k))
+	fs.setInstr(pc+1, vm54.MakeAx(vm54.EXTRAARG, extra))
+}
+
+// setList emits a SETLIST instruction storing tostore values
+// (or all values up to the top if tostore is multRet) into the
+// table in register base, after its first nelems elements.
+func (fs *funcState54) setList(base, nelems, tostore int) {
+	if tostore == multRet {
+		tostore = 0
+	}
+	if nelems <= vm54.MaxArgC {
+		fs.codeABC(vm54.SETLIST, base, tostore, nelems)
+	} else {
+		extra := nelems / (vm54.MaxArgC + 1)
+		nelems %= vm54.MaxArgC + 1
+		fs.codeABCk(vm54.SETLIST, base, tostore, nelems, 1)
+		fs.codeExtraArg(extra)
+	}
+	fs.freeReg = base + 1 // free registers with list values
+}
+
+// finalTarget returns the final target of a jump (skipping jumps
+// to jumps).
+func (fs *funcState54) finalTarget(i int) int {
+	for count := 0; count < 100; count++ { // avoid infinite loops
+		pc := fs.instr(i)
+		if pc.Code() != vm54.JMP {
+			break
+		}
+		i += pc.SJ() + 1
+	}
+	return i
+}
+
+// finish does a final pass over the code of a function, doing small
+// peephole optimizations and adjustments.
+func (fs *funcState54) finish() {
+	p := fs.f
+	for i := 0; i < fs.pc; i++ {
+		pc := fs.instr(i)
+		switch pc.Code() {
+		case vm54.RETURN0, vm54.RETURN1:
+			if !fs.needClose && p.Vararg == 0 {
+				break // no extra work
+			}
+			// else use RETURN with extra arguments
+			pc = pc.WithCode(vm54.RETURN)
+			fallthrough
+		case vm54.RETURN, vm54.TAILCALL:
+			if fs.needClose {
+				pc = pc.WithK(1) // signal that it needs to close
+			}
+			if p.Vararg != 0 {
+				pc = pc.WithC(int(p.Params) + 1) // signal that it is vararg
+			}
+			fs.setInstr(i, pc)
+		case vm54.JMP:
+			fs.fixJump(i, fs.finalTarget(i))
+		}
+	}
+}
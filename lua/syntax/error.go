@@ -0,0 +1,45 @@
+package syntax
+
+import "fmt"
+
+// Error is a syntax error in Lua source text.
+//
+// The message returned by Error is the one the reference
+// implementation reports, e.g.:
+//
+//	[string "x = = 1"]:1: unexpected symbol near '='
+type Error struct {
+	Chunk   string // chunk name
+	Line    int    // line where the error was detected
+	Column  int    // column of the offending token (0 if unknown)
+	Token   string // offending token as quoted in the message (may be empty)
+	Message string // description of the error
+}
+
+// Error returns the error message, prefixed by the chunk name and
+// line and followed by the offending token, if any.
+func (e *Error) Error() string {
+	msg := fmt.Sprintf("%s:%d: %s", ChunkID(e.Chunk), e.Line, e.Message)
+	if e.Token != "" {
+		msg = fmt.Sprintf("%s near %s", msg, e.Token)
+	}
+	return msg
+}
+
+// bailout carries a syntax error raised while scanning or parsing.
+type bailout struct{ err *Error }
+
+// Catch recovers a syntax error raised while scanning or parsing
+// and stores it in *err; any other panic is propagated. Catch must
+// be deferred directly:
+//
+//	defer syntax.Catch(&err)
+func Catch(err *error) {
+	if r := recover(); r != nil {
+		if b, ok := r.(bailout); ok {
+			*err = b.err
+			return
+		}
+		panic(r)
+	}
+}
@@ -0,0 +1,376 @@
+package syntax
+
+import (
+	"math"
+
+	"github.com/Azure/golua/lua/binary"
+	"github.com/Azure/golua/lua/vm"
+)
+
+// Optimize rewrites the Lua 5.3 bytecode of proto and of all its nested
+// prototypes in place. It folds arithmetic on constant operands, threads
+// jumps to unconditional jumps, removes unused LOADNILs and removes code
+// that can never be reached, keeping the line information and the ranges
+// of the local variables in sync with the new code.
+//
+// The optimized code behaves as the original one, except that values the
+// debug library could observe between instructions may differ.
+func Optimize(proto *binary.Prototype) {
+	for i := range proto.Protos {
+		Optimize(&proto.Protos[i])
+	}
+	opt := &optimizer{f: proto, code: make([]vm.Instr, len(proto.Code))}
+	for pc, code := range proto.Code {
+		opt.code[pc] = vm.Instr(code)
+	}
+	opt.foldConstants()
+	opt.threadJumps()
+	opt.removeLoadNils()
+	opt.removeDeadCode()
+}
+
+// optimizer holds the state of the optimization of a prototype.
+type optimizer struct {
+	f    *binary.Prototype
+	code []vm.Instr
+	dead []bool // instructions to remove
+}
+
+// target returns the destination of the jump at pc.
+func (opt *optimizer) target(pc int) int { return pc + 1 + opt.code[pc].SBX() }
+
+// isTest reports whether instr is a test, i.e. is followed by a jump
+// that it may skip.
+func isTest(instr vm.Instr) bool {
+	switch instr.Code() {
+	case vm.EQ, vm.LT, vm.LE, vm.TEST, vm.TESTSET:
+		return true
+	}
+	return false
+}
+
+// pinned reports whether the instruction at pc is tied to the previous
+// one, which either skips it or reads it as an argument, so that it must
+// not be removed.
+func (opt *optimizer) pinned(pc int) bool {
+	if pc == 0 {
+		return false
+	}
+	switch prev := opt.code[pc-1]; prev.Code() {
+	case vm.LOADBOOL:
+		return prev.C() != 0
+	case vm.LOADKX:
+		return true
+	case vm.SETLIST:
+		return prev.C() == 0
+	}
+	return isTest(opt.code[pc-1])
+}
+
+// foldConstants replaces arithmetic and bitwise operations on two numeric
+// constants by a LOADK of their result. Operations that could raise an
+// error at runtime are left alone.
+func (opt *optimizer) foldConstants() {
+	for pc, instr := range opt.code {
+		op := instr.Code()
+		if op < vm.ADD || op > vm.SHR {
+			continue
+		}
+		a, b, c := instr.ABC()
+		if !vm.IsK(b) || !vm.IsK(c) {
+			continue
+		}
+		v1, ok1 := numericK(opt.f.Const(b &^ vm.BitRK))
+		v2, ok2 := numericK(opt.f.Const(c &^ vm.BitRK))
+		if !ok1 || !ok2 || !validOp(arithOp(op-vm.ADD), v1, v2) {
+			continue
+		}
+		res := arith(arithOp(op-vm.ADD), v1, v2)
+		if f, ok := res.(float64); ok && (math.IsNaN(f) || f == 0) {
+			continue // see constFolding
+		}
+		if k := opt.constant(res); k <= vm.MaxArgBX {
+			opt.code[pc] = vm.MakeABx(vm.LOADK, a, k)
+		}
+	}
+}
+
+// numericK returns the constant v if it is a number.
+func numericK(v interface{}) (interface{}, bool) {
+	switch v.(type) {
+	case int64, float64:
+		return v, true
+	}
+	return nil, false
+}
+
+// constant returns the index of constant v, adding it if needed.
+func (opt *optimizer) constant(v interface{}) int {
+	for k, c := range opt.f.Consts {
+		if c == v { // must distinguish floats from integers!
+			return k
+		}
+	}
+	opt.f.Consts = append(opt.f.Consts, v)
+	return len(opt.f.Consts) - 1
+}
+
+// threadJumps makes jumps whose destination is an unconditional jump go
+// directly to the final destination. Since closing upvalues is
+// idempotent, the jumps along the chain may close upvalues: the threaded
+// jump closes from the lowest level any of them closes.
+func (opt *optimizer) threadJumps() {
+	for pc, instr := range opt.code {
+		if instr.Code() != vm.JMP {
+			continue
+		}
+		var (
+			level = instr.A()
+			dest  = opt.target(pc)
+		)
+		for n := 0; n < len(opt.code) && dest != pc && opt.code[dest].Code() == vm.JMP; n++ {
+			if a := opt.code[dest].A(); a != 0 && (level == 0 || a < level) {
+				level = a
+			}
+			dest = opt.target(dest)
+		}
+		opt.code[pc] = vm.MakeAsBx(vm.JMP, level, dest-pc-1)
+	}
+}
+
+// removeLoadNils removes the registers set by a LOADNIL that are written
+// again before being read; the instruction is removed if none is left.
+//
+// The analysis follows the code from the LOADNIL up to the first
+// instruction that may transfer control or whose effect on the registers
+// is not known, and never touches registers captured by closures, since
+// their values could be read through upvalues by metamethods.
+func (opt *optimizer) removeLoadNils() {
+	captured := make(map[int]bool)
+	for _, proto := range opt.f.Protos {
+		for _, up := range proto.UpValues {
+			if up.IsLocal() {
+				captured[up.AtIndex()] = true
+			}
+		}
+	}
+	for pc, instr := range opt.code {
+		if instr.Code() != vm.LOADNIL || opt.pinned(pc) {
+			continue
+		}
+		first, last := instr.A(), instr.A()+instr.B()
+		unused := opt.unusedRegs(pc, first, last)
+		for r := first; r <= last; r++ {
+			if captured[r] {
+				unused[r-first] = false
+			}
+		}
+		for first <= last && unused[0] {
+			first, unused = first+1, unused[1:]
+		}
+		for last >= first && unused[last-first] {
+			last--
+		}
+		if first > last {
+			opt.remove(pc)
+		} else {
+			opt.code[pc] = vm.MakeABC(vm.LOADNIL, first, last-first, 0)
+		}
+	}
+}
+
+// unusedRegs reports for each register from first to last whether it is
+// written before being read by the code following pc.
+func (opt *optimizer) unusedRegs(pc, first, last int) []bool {
+	var (
+		unused = make([]bool, last-first+1)
+		known  = make([]bool, last-first+1)
+	)
+	mark := func(lo, hi int, written bool) {
+		for r := lo; r <= hi; r++ {
+			if r >= first && r <= last && !known[r-first] {
+				known[r-first], unused[r-first] = true, written
+			}
+		}
+	}
+	read := func(x int) {
+		if !vm.IsK(x) {
+			mark(x, x, false)
+		}
+	}
+	for pc++; pc < len(opt.code); pc++ {
+		if opt.dead != nil && opt.dead[pc] {
+			continue // removed LOADNIL
+		}
+		instr := opt.code[pc]
+		a, b, c := instr.ABC()
+		switch instr.Code() {
+		case vm.MOVE, vm.UNM, vm.BNOT, vm.NOT, vm.LEN:
+			read(b)
+			mark(a, a, true)
+		case vm.LOADK, vm.GETUPVAL, vm.NEWTABLE:
+			mark(a, a, true)
+		case vm.LOADBOOL:
+			if c != 0 {
+				return unused
+			}
+			mark(a, a, true)
+		case vm.LOADNIL:
+			mark(a, a+b, true)
+		case vm.GETTABUP:
+			read(c)
+			mark(a, a, true)
+		case vm.GETTABLE:
+			read(b)
+			read(c)
+			mark(a, a, true)
+		case vm.SETTABUP:
+			read(b)
+			read(c)
+		case vm.SETUPVAL:
+			read(a)
+		case vm.SETTABLE:
+			read(a)
+			read(b)
+			read(c)
+		case vm.SELF:
+			read(b)
+			read(c)
+			mark(a, a+1, true)
+		case vm.ADD, vm.SUB, vm.MUL, vm.MOD, vm.POW, vm.DIV, vm.IDIV,
+			vm.BAND, vm.BOR, vm.BXOR, vm.SHL, vm.SHR:
+			read(b)
+			read(c)
+			mark(a, a, true)
+		case vm.CONCAT:
+			mark(b, c, false)
+			mark(a, a, true)
+		case vm.RETURN:
+			if b == 0 {
+				mark(a, last, false) // up to the top
+			} else {
+				mark(a, a+b-2, false)
+			}
+			mark(first, last, true) // the rest dies with the frame
+			return unused
+		default:
+			return unused
+		}
+	}
+	return unused
+}
+
+// remove marks the instruction at pc for removal.
+func (opt *optimizer) remove(pc int) {
+	if opt.dead == nil {
+		opt.dead = make([]bool, len(opt.code))
+	}
+	opt.dead[pc] = true
+}
+
+// removed reports whether the instructions from pc up to (but not
+// including) end are all marked for removal, which is false if end
+// precedes pc.
+func (opt *optimizer) removed(pc, end int) bool {
+	if end < pc {
+		return false
+	}
+	for ; pc < end; pc++ {
+		if !opt.dead[pc] {
+			return false
+		}
+	}
+	return true
+}
+
+// removeDeadCode removes the instructions that cannot be reached from the
+// entry of the function, the jumps to the next instruction left and the
+// instructions marked for removal, then fixes the jump offsets, the line
+// information and the ranges of the local variables.
+func (opt *optimizer) removeDeadCode() {
+	var (
+		reached = make([]bool, len(opt.code))
+		stack   = []int{0}
+	)
+	for len(stack) > 0 {
+		pc := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if pc >= len(opt.code) || reached[pc] {
+			continue
+		}
+		reached[pc] = true
+		switch instr := opt.code[pc]; instr.Code() {
+		case vm.JMP, vm.FORPREP:
+			stack = append(stack, opt.target(pc))
+		case vm.FORLOOP, vm.TFORLOOP:
+			stack = append(stack, pc+1, opt.target(pc))
+		case vm.LOADBOOL:
+			if instr.C() != 0 {
+				stack = append(stack, pc+2)
+			} else {
+				stack = append(stack, pc+1)
+			}
+		case vm.EQ, vm.LT, vm.LE, vm.TEST, vm.TESTSET:
+			stack = append(stack, pc+1, pc+2)
+		case vm.RETURN:
+		default:
+			stack = append(stack, pc+1)
+		}
+	}
+	if opt.dead == nil {
+		opt.dead = make([]bool, len(opt.code))
+	}
+	for pc := range opt.code {
+		if opt.pinned(pc) && reached[pc-1] {
+			opt.dead[pc] = false
+		} else if !reached[pc] {
+			opt.dead[pc] = true
+		}
+	}
+	for pc := len(opt.code) - 1; pc >= 0; pc-- {
+		if instr := opt.code[pc]; instr.Code() == vm.JMP && instr.A() == 0 && !opt.pinned(pc) && opt.removed(pc+1, opt.target(pc)) {
+			opt.dead[pc] = true // jump to the next instruction left
+		}
+	}
+
+	// newpc maps old pcs to new ones; removed instructions map to the
+	// next instruction left.
+	newpc := make([]int, len(opt.code)+1)
+	for pc, n := len(opt.code)-1, 0; pc >= 0; pc-- {
+		if !opt.dead[pc] {
+			n++
+		}
+		newpc[pc] = n // number of instructions left from pc on
+	}
+	size := newpc[0]
+	for pc := range newpc {
+		newpc[pc] = size - newpc[pc]
+	}
+
+	var (
+		code   = make([]uint32, 0, size)
+		lineno = make([]uint32, 0, size)
+	)
+	for pc, instr := range opt.code {
+		if opt.dead[pc] {
+			continue
+		}
+		switch instr.Code() {
+		case vm.JMP, vm.FORPREP, vm.FORLOOP, vm.TFORLOOP:
+			instr = instr.WithSBX(newpc[opt.target(pc)] - newpc[pc] - 1)
+		}
+		code = append(code, uint32(instr))
+		if pc < len(opt.f.PcLnTab) {
+			lineno = append(lineno, opt.f.PcLnTab[pc])
+		}
+	}
+	opt.f.Code = code
+	if len(opt.f.PcLnTab) > 0 {
+		opt.f.PcLnTab = lineno
+	}
+	for i := range opt.f.Locals {
+		local := &opt.f.Locals[i]
+		local.Live = uint32(newpc[local.Live])
+		local.Dead = uint32(newpc[local.Dead])
+	}
+}
@@ -0,0 +1,1485 @@
+package syntax
+
+import (
+	"fmt"
+
+	"github.com/Azure/golua/lua/binary"
+	"github.com/Azure/golua/lua/vm"
+)
+
+const (
+	// maxVars is the maximum number of local variables per function
+	// (must be smaller than 250, due to the bytecode format).
+	maxVars = 200
+
+	// maxUpVals is the maximum number of upvalues in a closure.
+	maxUpVals = 255
+
+	// maxCCalls is the maximum depth for nested syntactical constructs.
+	maxCCalls = 200
+
+	// unaryPriority is the priority for unary operators.
+	unaryPriority = 12
+)
+
+// expKind describes the kinds of variables and expressions.
+type expKind int
+
+const (
+	vVoid      expKind = iota // when 'expdesc' describes the last expression a list, this kind means an empty list (so, no expression)
+	vNil                      // constant nil
+	vTrue                     // constant true
+	vFalse                    // constant false
+	vK                        // constant in 'k'; info = index of constant in 'k'
+	vKFlt                     // floating constant; nval = numerical float value
+	vKInt                     // integer constant; ival = numerical integer value
+	vNonReloc                 // expression has its value in a fixed register; info = result register
+	vLocal                    // local variable; info = local register
+	vUpval                    // upvalue variable; info = index of upvalue in 'upvalues'
+	vIndexed                  // indexed variable; ind.vt = whether t is register or upvalue; ind.t = t register or upvalue index; ind.idx = key's R/K index
+	vJmp                      // expression is a test/comparison; info = pc of corresponding jump instruction
+	vRelocable                // expression can put result in any register; info = instruction pc
+	vCall                     // expression is a function call; info = instruction pc
+	vVararg                   // vararg expression; info = instruction pc
+)
+
+func isVar(k expKind) bool      { return vLocal <= k && k <= vIndexed }
+func hasMultRet(k expKind) bool { return k == vCall || k == vVararg }
+
+// expDesc describes a potentially-delayed expression.
+type expDesc struct {
+	k    expKind
+	info int     // generic use
+	ival int64   // for vKInt
+	nval float64 // for vKFlt
+	ind  struct {
+		idx int     // index (R/K)
+		t   int     // table (register or upvalue)
+		vt  expKind // whether t is register (vLocal) or upvalue (vUpval)
+	}
+	t int // patch list of 'exit when true'
+	f int // patch list of 'exit when false'
+}
+
+func (e *expDesc) init(k expKind, info int) {
+	e.f, e.t = noJump, noJump
+	e.k, e.info = k, info
+}
+
+// binOpr is a binary operator; the order of the arithmetic and
+// bitwise operators matches their opcodes.
+type binOpr int
+
+const (
+	oprAdd binOpr = iota
+	oprSub
+	oprMul
+	oprMod
+	oprPow
+	oprDiv
+	oprIDiv
+	oprBAnd
+	oprBOr
+	oprBXor
+	oprShl
+	oprShr
+	oprConcat
+	oprEq
+	oprLt
+	oprLe
+	oprNE
+	oprGT
+	oprGE
+	oprAnd
+	oprOr
+	oprNoBinOpr
+)
+
+// unOpr is a unary operator.
+type unOpr int
+
+const (
+	oprMinus unOpr = iota
+	oprBNot
+	oprNot
+	oprLen
+	oprNoUnOpr
+)
+
+// priority holds the left and right priority for each binary operator.
+var priority = [...]struct{ left, right int }{
+	{10, 10}, {10, 10}, // '+' '-'
+	{11, 11}, {11, 11}, // '*' '%'
+	{14, 13},           // '^' (right associative)
+	{11, 11}, {11, 11}, // '/' '//'
+	{6, 6}, {4, 4}, {5, 5}, // '&' '|' '~'
+	{7, 7}, {7, 7}, // '<<' '>>'
+	{9, 8},                 // '..' (right associative)
+	{3, 3}, {3, 3}, {3, 3}, // ==, <, <=
+	{3, 3}, {3, 3}, {3, 3}, // ~=, >, >=
+	{2, 2}, {1, 1}, // and, or
+}
+
+// labelDesc describes a pending goto statement or label.
+type labelDesc struct {
+	name    string // label identifier
+	pc      int    // position in code
+	line    int    // line where it appeared
+	nActVar int    // local level where it appears in current block
+}
+
+// blockCnt is a node in the list of active blocks.
+type blockCnt struct {
+	previous   *blockCnt // chain
+	firstLabel int       // index of first label in this block
+	firstGoto  int       // index of first pending goto in this block
+	nActVar    int       // # active locals outside the block
+	upval      bool      // true if some variable in the block is an upvalue
+	isLoop     bool      // true if block is a loop
+}
+
+// funcState holds the state needed to generate code for a function.
+type funcState struct {
+	f          *binary.Prototype // current function header
+	prev       *funcState        // enclosing function
+	ls         *parser           // lexical state
+	bl         *blockCnt         // chain of current blocks
+	pc         int               // next position to code (equivalent to 'ncode')
+	lastTarget int               // 'label' of last 'jump label'
+	jpc        int               // list of pending jumps to 'pc'
+	np         int               // number of elements in 'p'
+	firstLocal int               // index of first local var (in dyd.actVar)
+	nActVar    int               // number of active local variables
+	freeReg    int               // first free register
+}
+
+// parser holds the lexical state and the dynamic structures
+// used by the parser.
+type parser struct {
+	lexer
+	fs      *funcState          // current function (parser)
+	actVar  []int               // list of active local variables
+	gotos   []labelDesc         // list of pending gotos
+	labels  []labelDesc         // list of active labels
+	cache   map[interface{}]int // constants cache (to reuse constants)
+	nCcalls int                 // number of nested syntactical constructs
+	envn    string              // environment variable name
+}
+
+// Compile compiles the Lua source text src of the chunk named
+// chunkname into its main function prototype.
+//
+// The resulting prototype contains the same instructions and
+// debug information generated by the reference compiler (luac).
+func Compile(chunkname string, src []byte) (_ *binary.Prototype, err error) {
+	defer Catch(&err)
+	ls := &parser{
+		lexer: lexer{NewScanner(chunkname, src)},
+		cache: make(map[interface{}]int),
+		envn:  "_ENV",
+	}
+	proto := &binary.Prototype{Source: chunkname}
+	ls.mainFunc(&funcState{f: proto})
+	return proto, nil
+}
+
+// lexer adds to the scanner the helpers shared by the parsers.
+type lexer struct{ *Scanner }
+
+// syntaxError raises a syntax error near the current token.
+func (ls lexer) syntaxError(msg string) { ls.Errorf(ls.Token(), "%s", msg) }
+
+// semError raises a semantic error (without the "near" part).
+func (ls lexer) semError(msg string) { ls.Errorf(0, "%s", msg) }
+
+func (ls lexer) errorExpected(tok Token) {
+	ls.syntaxError(fmt.Sprintf("%v expected", tok))
+}
+
+func (fs *funcState) errorLimit(limit int, what string) {
+	where := "main function"
+	if line := fs.f.SrcPos; line != 0 {
+		where = fmt.Sprintf("function at line %d", line)
+	}
+	fs.ls.syntaxError(fmt.Sprintf("too many %s (limit is %d) in %s", what, limit, where))
+}
+
+func (fs *funcState) checkLimit(v, l int, what string) {
+	if v > l {
+		fs.errorLimit(l, what)
+	}
+}
+
+func (ls lexer) testNext(tok Token) bool {
+	if ls.Token() == tok {
+		ls.Next()
+		return true
+	}
+	return false
+}
+
+func (ls lexer) check(tok Token) {
+	if ls.Token() != tok {
+		ls.errorExpected(tok)
+	}
+}
+
+func (ls lexer) checkNext(tok Token) {
+	ls.check(tok)
+	ls.Next()
+}
+
+func (ls lexer) checkCondition(cond bool, msg string) {
+	if !cond {
+		ls.syntaxError(msg)
+	}
+}
+
+func (ls lexer) checkMatch(what, who Token, where int) {
+	if !ls.testNext(what) {
+		if where == ls.Line() {
+			ls.errorExpected(what)
+		}
+		ls.syntaxError(fmt.Sprintf("%v expected (to close %v at line %d)", what, who, where))
+	}
+}
+
+func (ls lexer) strCheckName() string {
+	ls.check(TokenName)
+	name := ls.tok.sval
+	ls.Next()
+	return name
+}
+
+func (ls *parser) codeString(e *expDesc, s string) {
+	e.init(vK, ls.fs.stringK(s))
+}
+
+func (ls *parser) checkName(e *expDesc) {
+	ls.codeString(e, ls.strCheckName())
+}
+
+func (ls *parser) registerLocalVar(name string) int {
+	f := ls.fs.f
+	f.Locals = append(f.Locals, binary.LocalVar{Name: name})
+	return len(f.Locals) - 1
+}
+
+func (ls *parser) newLocalVar(name string) {
+	fs := ls.fs
+	reg := ls.registerLocalVar(name)
+	fs.checkLimit(len(ls.actVar)+1-fs.firstLocal, maxVars, "local variables")
+	ls.actVar = append(ls.actVar, reg)
+}
+
+func (fs *funcState) getLocVar(i int) *binary.LocalVar {
+	return &fs.f.Locals[fs.ls.actVar[fs.firstLocal+i]]
+}
+
+func (ls *parser) adjustLocalVars(nvars int) {
+	fs := ls.fs
+	fs.nActVar += nvars
+	for ; nvars > 0; nvars-- {
+		fs.getLocVar(fs.nActVar - nvars).Live = uint32(fs.pc)
+	}
+}
+
+func (fs *funcState) removeVars(toLevel int) {
+	n := len(fs.ls.actVar) - (fs.nActVar - toLevel)
+	for fs.nActVar > toLevel {
+		fs.nActVar--
+		fs.getLocVar(fs.nActVar).Dead = uint32(fs.pc)
+	}
+	fs.ls.actVar = fs.ls.actVar[:n]
+}
+
+func (fs *funcState) searchUpvalue(name string) int {
+	for i, up := range fs.f.UpNames {
+		if up == name {
+			return i
+		}
+	}
+	return -1 // not found
+}
+
+func (fs *funcState) newUpvalue(name string, v *expDesc) int {
+	fs.checkLimit(len(fs.f.UpValues)+1, maxUpVals, "upvalues")
+	var inStack byte
+	if v.k == vLocal {
+		inStack = 1
+	}
+	fs.f.UpValues = append(fs.f.UpValues, binary.UpValue{InStack: inStack, Index: byte(v.info)})
+	fs.f.UpNames = append(fs.f.UpNames, name)
+	return len(fs.f.UpValues) - 1
+}
+
+func (fs *funcState) searchVar(name string) int {
+	for i := fs.nActVar - 1; i >= 0; i-- {
+		if name == fs.getLocVar(i).Name {
+			return i
+		}
+	}
+	return -1 // not found
+}
+
+// markUpval marks the block where variable at given level was defined
+// (to emit close instructions later).
+func (fs *funcState) markUpval(level int) {
+	bl := fs.bl
+	for bl.nActVar > level {
+		bl = bl.previous
+	}
+	bl.upval = true
+}
+
+// singleVarAux finds the variable with the given name. If it is an
+// upvalue, add this upvalue into all intermediate functions.
+func singleVarAux(fs *funcState, name string, v *expDesc, base bool) {
+	if fs == nil { // no more levels?
+		v.init(vVoid, 0) // default is global
+		return
+	}
+	if i := fs.searchVar(name); i >= 0 { // look up locals at current level
+		v.init(vLocal, i) // variable is local
+		if !base {
+			fs.markUpval(i) // local will be used as an upval
+		}
+		return
+	}
+	// not found as local at current level; try upvalues
+	idx := fs.searchUpvalue(name) // try existing upvalues
+	if idx < 0 {                  // not found?
+		singleVarAux(fs.prev, name, v, false) // try upper levels
+		if v.k == vVoid {                     // not found?
+			return // it is a global
+		}
+		// else was LOCAL or UPVAL
+		idx = fs.newUpvalue(name, v) // will be a new upvalue
+	}
+	v.init(vUpval, idx) // new or old upvalue
+}
+
+func (ls *parser) singleVar(v *expDesc) {
+	name := ls.strCheckName()
+	fs := ls.fs
+	if singleVarAux(fs, name, v, true); v.k == vVoid { // global name?
+		var key expDesc
+		singleVarAux(fs, ls.envn, v, true) // get environment variable
+		ls.codeString(&key, name)          // key is variable name
+		fs.indexed(v, &key)                // env[varname]
+	}
+}
+
+func (ls *parser) adjustAssign(nvars, nexps int, e *expDesc) {
+	fs := ls.fs
+	extra := nvars - nexps
+	if hasMultRet(e.k) {
+		if extra++; extra < 0 { // includes call itself
+			extra = 0
+		}
+		fs.setReturns(e, extra) // last exp. provides the difference
+		if extra > 1 {
+			fs.reserveRegs(extra - 1)
+		}
+	} else {
+		if e.k != vVoid { // at least one expression?
+			fs.exp2NextReg(e) // close last expression
+		}
+		if extra > 0 {
+			reg := fs.freeReg
+			fs.reserveRegs(extra)
+			fs.loadNil(reg, extra)
+		}
+	}
+	if nexps > nvars {
+		fs.freeReg -= nexps - nvars // remove extra values
+	}
+}
+
+func (ls *parser) enterLevel() {
+	ls.nCcalls++
+	ls.fs.checkLimit(ls.nCcalls, maxCCalls, "C levels")
+}
+
+func (ls *parser) leaveLevel() { ls.nCcalls-- }
+
+// closeGoto solves the pending goto at index g to given label and
+// removes it from the list of pending gotos.
+func (ls *parser) closeGoto(g int, label *labelDesc) {
+	fs := ls.fs
+	gt := &ls.gotos[g]
+	if gt.nActVar < label.nActVar {
+		name := fs.getLocVar(gt.nActVar).Name
+		ls.semError(fmt.Sprintf("<goto %s> at line %d jumps into the scope of local '%s'", gt.name, gt.line, name))
+	}
+	fs.patchList(gt.pc, label.pc)
+	// remove goto from pending list
+	ls.gotos = append(ls.gotos[:g], ls.gotos[g+1:]...)
+}
+
+// findLabel tries to close the goto at index g with a label in
+// the current block.
+func (ls *parser) findLabel(g int) bool {
+	bl := ls.fs.bl
+	gt := &ls.gotos[g]
+	// check labels in current block for a match
+	for i := bl.firstLabel; i < len(ls.labels); i++ {
+		lb := &ls.labels[i]
+		if lb.name == gt.name { // correct label?
+			if gt.nActVar > lb.nActVar && (bl.upval || len(ls.labels) > bl.firstLabel) {
+				ls.fs.patchClose(gt.pc, lb.nActVar)
+			}
+			ls.closeGoto(g, lb) // close it
+			return true
+		}
+	}
+	return false // label not found; cannot close goto
+}
+
+func (ls *parser) newLabelEntry(l *[]labelDesc, name string, line, pc int) int {
+	*l = append(*l, labelDesc{name: name, line: line, nActVar: ls.fs.nActVar, pc: pc})
+	return len(*l) - 1
+}
+
+// findGotos checks whether new label lb matches any pending gotos
+// in the current block; solves them.
+func (ls *parser) findGotos(lb *labelDesc) {
+	for i := ls.fs.bl.firstGoto; i < len(ls.gotos); {
+		if ls.gotos[i].name == lb.name {
+			ls.closeGoto(i, lb)
+		} else {
+			i++
+		}
+	}
+}
+
+// moveGotosOut exports pending gotos to outer level, to check them
+// against outer labels; if the block being exited has upvalues, and
+// the goto exits the scope of any variable (which can be the upvalue),
+// close those variables being exited.
+func (fs *funcState) moveGotosOut(bl *blockCnt) {
+	ls := fs.ls
+	// correct pending gotos to current block and try to close it
+	// with visible labels
+	for i := bl.firstGoto; i < len(ls.gotos); {
+		if gt := &ls.gotos[i]; gt.nActVar > bl.nActVar {
+			if bl.upval {
+				fs.patchClose(gt.pc, bl.nActVar)
+			}
+			gt.nActVar = bl.nActVar
+		}
+		if !ls.findLabel(i) {
+			i++ // move to next one
+		}
+	}
+}
+
+func (fs *funcState) enterBlock(bl *blockCnt, isLoop bool) {
+	bl.isLoop = isLoop
+	bl.nActVar = fs.nActVar
+	bl.firstLabel = len(fs.ls.labels)
+	bl.firstGoto = len(fs.ls.gotos)
+	bl.upval = false
+	bl.previous = fs.bl
+	fs.bl = bl
+}
+
+// breakLabel creates a label named 'break' to resolve break statements.
+func (ls *parser) breakLabel() {
+	l := ls.newLabelEntry(&ls.labels, "break", 0, ls.fs.pc)
+	ls.findGotos(&ls.labels[l])
+}
+
+// undefGoto generates an error for an undefined 'goto'; choose
+// appropriate message when label name is a reserved word (which
+// can only be 'break').
+func (ls *parser) undefGoto(gt *labelDesc) {
+	if IsReserved(gt.name) {
+		ls.semError(fmt.Sprintf("<%s> at line %d not inside a loop", gt.name, gt.line))
+	}
+	ls.semError(fmt.Sprintf("no visible label '%s' for <goto> at line %d", gt.name, gt.line))
+}
+
+func (fs *funcState) leaveBlock() {
+	bl := fs.bl
+	ls := fs.ls
+	if bl.previous != nil && bl.upval {
+		// create a 'jump to here' to close upvalues
+		j := fs.jump()
+		fs.patchClose(j, bl.nActVar)
+		fs.patchToHere(j)
+	}
+	if bl.isLoop {
+		ls.breakLabel() // close pending breaks
+	}
+	fs.bl = bl.previous
+	fs.removeVars(bl.nActVar)
+	fs.freeReg = fs.nActVar               // free registers
+	ls.labels = ls.labels[:bl.firstLabel] // remove local labels
+	if bl.previous != nil {               // inner block?
+		fs.moveGotosOut(bl) // update pending gotos to outer block
+	} else if bl.firstGoto < len(ls.gotos) { // pending gotos in outer block?
+		ls.undefGoto(&ls.gotos[bl.firstGoto]) // error
+	}
+}
+
+// addPrototype adds a new prototype into the list of prototypes.
+func (ls *parser) addPrototype() *binary.Prototype {
+	ls.fs.np++
+	return &binary.Prototype{}
+}
+
+// codeClosure codes instruction to create new closure in parent
+// function.
+func (ls *parser) codeClosure(v *expDesc) {
+	fs := ls.fs.prev
+	v.init(vRelocable, fs.codeABx(vm.CLOSURE, 0, fs.np-1))
+	fs.exp2NextReg(v) // fix it at the last register
+}
+
+func (ls *parser) openFunc(fs *funcState, bl *blockCnt) {
+	fs.prev = ls.fs // linked list of funcstates
+	fs.ls = ls
+	ls.fs = fs
+	fs.pc = 0
+	fs.lastTarget = 0
+	fs.jpc = noJump
+	fs.freeReg = 0
+	fs.np = 0
+	fs.nActVar = 0
+	fs.firstLocal = len(ls.actVar)
+	fs.bl = nil
+	fs.f.Source = ls.Source()
+	fs.f.Stack = 2 // registers 0/1 are always valid
+	fs.enterBlock(bl, false)
+}
+
+func (ls *parser) closeFunc() {
+	fs := ls.fs
+	fs.ret(0, 0) // final return
+	fs.leaveBlock()
+	ls.fs = fs.prev
+	if prev := ls.fs; prev != nil {
+		prev.f.Protos = append(prev.f.Protos, *fs.f)
+	}
+}
+
+// blockFollow checks whether current token is in the follow set of
+// a block. 'until' closes syntactical blocks, but do not close scope,
+// so it is handled in separate.
+func (ls lexer) blockFollow(withUntil bool) bool {
+	switch ls.Token() {
+	case TokenElse, TokenElseIf, TokenEnd, TokenEOS:
+		return true
+	case TokenUntil:
+		return withUntil
+	}
+	return false
+}
+
+func (ls *parser) statList() {
+	// statlist -> { stat [';'] }
+	for !ls.blockFollow(true) {
+		if ls.Token() == TokenReturn {
+			ls.statement()
+			return // 'return' must be last statement
+		}
+		ls.statement()
+	}
+}
+
+func (ls *parser) fieldSel(v *expDesc) {
+	// fieldsel -> ['.' | ':'] NAME
+	fs := ls.fs
+	var key expDesc
+	fs.exp2AnyRegUp(v)
+	ls.Next() // skip the dot or colon
+	ls.checkName(&key)
+	fs.indexed(v, &key)
+}
+
+func (ls *parser) yIndex(v *expDesc) {
+	// index -> '[' expr ']'
+	ls.Next() // skip the '['
+	ls.expr(v)
+	ls.fs.exp2Val(v)
+	ls.checkNext(']')
+}
+
+// consControl holds the state of a table constructor.
+type consControl struct {
+	v       expDesc  // last list item read
+	t       *expDesc // table descriptor
+	nh      int      // total number of 'record' elements
+	na      int      // total number of array elements
+	toStore int      // number of array elements pending to be stored
+}
+
+func (ls *parser) recField(cc *consControl) {
+	// recfield -> (NAME | '['exp1']') = exp1
+	fs := ls.fs
+	reg := fs.freeReg
+	var key, val expDesc
+	if ls.Token() == TokenName {
+		fs.checkLimit(cc.nh, maxInt, "items in a constructor")
+		ls.checkName(&key)
+	} else { // ls.Token() == '['
+		ls.yIndex(&key)
+	}
+	cc.nh++
+	ls.checkNext('=')
+	rkkey := fs.exp2RK(&key)
+	ls.expr(&val)
+	fs.codeABC(vm.SETTABLE, cc.t.info, rkkey, fs.exp2RK(&val))
+	fs.freeReg = reg // free registers
+}
+
+func (fs *funcState) closeListField(cc *consControl) {
+	if cc.v.k == vVoid {
+		return // there is no list item
+	}
+	fs.exp2NextReg(&cc.v)
+	cc.v.k = vVoid
+	if cc.toStore == fieldsPerFlush {
+		fs.setList(cc.t.info, cc.na, cc.toStore) // flush
+		cc.toStore = 0                           // no more items pending
+	}
+}
+
+func (fs *funcState) lastListField(cc *consControl) {
+	if cc.toStore == 0 {
+		return
+	}
+	if hasMultRet(cc.v.k) {
+		fs.setMultRet(&cc.v)
+		fs.setList(cc.t.info, cc.na, multRet)
+		cc.na-- // do not count last expression (unknown number of elements)
+	} else {
+		if cc.v.k != vVoid {
+			fs.exp2NextReg(&cc.v)
+		}
+		fs.setList(cc.t.info, cc.na, cc.toStore)
+	}
+}
+
+func (ls *parser) listField(cc *consControl) {
+	// listfield -> exp
+	ls.expr(&cc.v)
+	ls.fs.checkLimit(cc.na, maxInt, "items in a constructor")
+	cc.na++
+	cc.toStore++
+}
+
+func (ls *parser) field(cc *consControl) {
+	// field -> listfield | recfield
+	switch ls.Token() {
+	case TokenName: // may be 'listfield' or 'recfield'
+		if ls.Lookahead() != '=' { // expression?
+			ls.listField(cc)
+		} else {
+			ls.recField(cc)
+		}
+	case '[':
+		ls.recField(cc)
+	default:
+		ls.listField(cc)
+	}
+}
+
+func (ls *parser) constructor(t *expDesc) {
+	// constructor -> '{' [ field { sep field } [sep] ] '}'
+	// sep -> ',' | ';'
+	fs := ls.fs
+	line := ls.Line()
+	pc := fs.codeABC(vm.NEWTABLE, 0, 0, 0)
+	cc := consControl{t: t}
+	t.init(vRelocable, pc)
+	cc.v.init(vVoid, 0) // no value (yet)
+	fs.exp2NextReg(t)   // fix it at stack top
+	ls.checkNext('{')
+	for {
+		if ls.Token() == '}' {
+			break
+		}
+		fs.closeListField(&cc)
+		ls.field(&cc)
+		if !ls.testNext(',') && !ls.testNext(';') {
+			break
+		}
+	}
+	ls.checkMatch('}', '{', line)
+	fs.lastListField(&cc)
+	// set initial array and table sizes
+	fs.setInstr(pc, fs.instr(pc).WithB(int2fb(cc.na)).WithC(int2fb(cc.nh)))
+}
+
+func (ls *parser) parList() {
+	// parlist -> [ param { ',' param } ]
+	fs := ls.fs
+	f := fs.f
+	nparams := 0
+	f.Vararg = 0
+	if ls.Token() != ')' { // is 'parlist' not empty?
+		for {
+			switch ls.Token() {
+			case TokenName: // param -> NAME
+				ls.newLocalVar(ls.strCheckName())
+				nparams++
+			case TokenDots: // param -> '...'
+				ls.Next()
+				f.Vararg = 1
+			default:
+				ls.syntaxError("<name> or '...' expected")
+			}
+			if f.Vararg != 0 || !ls.testNext(',') {
+				break
+			}
+		}
+	}
+	ls.adjustLocalVars(nparams)
+	f.Params = byte(fs.nActVar)
+	fs.reserveRegs(fs.nActVar) // reserve register for parameters
+}
+
+func (ls *parser) body(e *expDesc, isMethod bool, line int) {
+	// body ->  '(' parlist ')' block END
+	var bl blockCnt
+	fs := &funcState{f: ls.addPrototype()}
+	fs.f.SrcPos = uint32(line)
+	ls.openFunc(fs, &bl)
+	ls.checkNext('(')
+	if isMethod {
+		ls.newLocalVar("self") // create 'self' parameter
+		ls.adjustLocalVars(1)
+	}
+	ls.parList()
+	ls.checkNext(')')
+	ls.statList()
+	fs.f.EndPos = uint32(ls.Line())
+	ls.checkMatch(TokenEnd, TokenFunction, line)
+	ls.codeClosure(e)
+	ls.closeFunc()
+}
+
+func (ls *parser) expList(v *expDesc) int {
+	// explist -> expr { ',' expr }
+	n := 1 // at least one expression
+	ls.expr(v)
+	for ls.testNext(',') {
+		ls.fs.exp2NextReg(v)
+		ls.expr(v)
+		n++
+	}
+	return n
+}
+
+func (ls *parser) funcArgs(f *expDesc, line int) {
+	fs := ls.fs
+	var args expDesc
+	switch ls.Token() {
+	case '(': // funcargs -> '(' [ explist ] ')'
+		ls.Next()
+		if ls.Token() == ')' { // arg list is empty?
+			args.k = vVoid
+		} else {
+			ls.expList(&args)
+			fs.setMultRet(&args)
+		}
+		ls.checkMatch(')', '(', line)
+	case '{': // funcargs -> constructor
+		ls.constructor(&args)
+	case TokenString: // funcargs -> STRING
+		ls.codeString(&args, ls.tok.sval)
+		ls.Next() // must use 'seminfo' before 'next'
+	default:
+		ls.syntaxError("function arguments expected")
+	}
+	base := f.info // base register for call
+	var nparams int
+	if hasMultRet(args.k) {
+		nparams = multRet // open call
+	} else {
+		if args.k != vVoid {
+			fs.exp2NextReg(&args) // close last argument
+		}
+		nparams = fs.freeReg - (base + 1)
+	}
+	f.init(vCall, fs.codeABC(vm.CALL, base, nparams+1, 2))
+	fs.fixLine(line)
+	// call remove function and arguments and leaves
+	// (unless changed) one result
+	fs.freeReg = base + 1
+}
+
+func (ls *parser) primaryExp(v *expDesc) {
+	// primaryexp -> NAME | '(' expr ')'
+	switch ls.Token() {
+	case '(':
+		line := ls.Line()
+		ls.Next()
+		ls.expr(v)
+		ls.checkMatch(')', '(', line)
+		ls.fs.dischargeVars(v)
+	case TokenName:
+		ls.singleVar(v)
+	default:
+		ls.syntaxError("unexpected symbol")
+	}
+}
+
+func (ls *parser) suffixedExp(v *expDesc) {
+	// suffixedexp ->
+	//   primaryexp { '.' NAME | '[' exp ']' | ':' NAME funcargs | funcargs }
+	fs := ls.fs
+	line := ls.Line()
+	ls.primaryExp(v)
+	for {
+		switch ls.Token() {
+		case '.': // fieldsel
+			ls.fieldSel(v)
+		case '[': // '[' exp1 ']'
+			var key expDesc
+			fs.exp2AnyRegUp(v)
+			ls.yIndex(&key)
+			fs.indexed(v, &key)
+		case ':': // ':' NAME funcargs
+			var key expDesc
+			ls.Next()
+			ls.checkName(&key)
+			fs.self(v, &key)
+			ls.funcArgs(v, line)
+		case '(', TokenString, '{': // funcargs
+			fs.exp2NextReg(v)
+			ls.funcArgs(v, line)
+		default:
+			return
+		}
+	}
+}
+
+func (ls *parser) simpleExp(v *expDesc) {
+	// simpleexp -> FLT | INT | STRING | NIL | TRUE | FALSE | ... |
+	//              constructor | FUNCTION body | suffixedexp
+	switch ls.Token() {
+	case TokenFloat:
+		v.init(vKFlt, 0)
+		v.nval = ls.tok.fval
+	case TokenInt:
+		v.init(vKInt, 0)
+		v.ival = ls.tok.ival
+	case TokenString:
+		ls.codeString(v, ls.tok.sval)
+	case TokenNil:
+		v.init(vNil, 0)
+	case TokenTrue:
+		v.init(vTrue, 0)
+	case TokenFalse:
+		v.init(vFalse, 0)
+	case TokenDots: // vararg
+		fs := ls.fs
+		ls.checkCondition(fs.f.Vararg != 0, "cannot use '...' outside a vararg function")
+		v.init(vVararg, fs.codeABC(vm.VARARG, 0, 1, 0))
+	case '{': // constructor
+		ls.constructor(v)
+		return
+	case TokenFunction:
+		ls.Next()
+		ls.body(v, false, ls.Line())
+		return
+	default:
+		ls.suffixedExp(v)
+		return
+	}
+	ls.Next()
+}
+
+func unaryOp(tok Token) unOpr {
+	switch tok {
+	case TokenNot:
+		return oprNot
+	case '-':
+		return oprMinus
+	case '~':
+		return oprBNot
+	case '#':
+		return oprLen
+	}
+	return oprNoUnOpr
+}
+
+func binaryOp(tok Token) binOpr {
+	switch tok {
+	case '+':
+		return oprAdd
+	case '-':
+		return oprSub
+	case '*':
+		return oprMul
+	case '%':
+		return oprMod
+	case '^':
+		return oprPow
+	case '/':
+		return oprDiv
+	case TokenIDiv:
+		return oprIDiv
+	case '&':
+		return oprBAnd
+	case '|':
+		return oprBOr
+	case '~':
+		return oprBXor
+	case TokenShl:
+		return oprShl
+	case TokenShr:
+		return oprShr
+	case TokenConcat:
+		return oprConcat
+	case TokenNE:
+		return oprNE
+	case TokenEq:
+		return oprEq
+	case '<':
+		return oprLt
+	case TokenLE:
+		return oprLe
+	case '>':
+		return oprGT
+	case TokenGE:
+		return oprGE
+	case TokenAnd:
+		return oprAnd
+	case TokenOr:
+		return oprOr
+	}
+	return oprNoBinOpr
+}
+
+// subExpr parses subexpr -> (simpleexp | unop subexpr) { binop subexpr }
+// where 'binop' is any binary operator with a priority higher than
+// 'limit'.
+func (ls *parser) subExpr(v *expDesc, limit int) binOpr {
+	ls.enterLevel()
+	if uop := unaryOp(ls.Token()); uop != oprNoUnOpr {
+		line := ls.Line()
+		ls.Next()
+		ls.subExpr(v, unaryPriority)
+		ls.fs.prefix(uop, v, line)
+	} else {
+		ls.simpleExp(v)
+	}
+	// expand while operators have priorities higher than 'limit'
+	op := binaryOp(ls.Token())
+	for op != oprNoBinOpr && priority[op].left > limit {
+		var v2 expDesc
+		line := ls.Line()
+		ls.Next()
+		ls.fs.infix(op, v)
+		// read sub-expression with higher priority
+		next := ls.subExpr(&v2, priority[op].right)
+		ls.fs.posfix(op, v, &v2, line)
+		op = next
+	}
+	ls.leaveLevel()
+	return op // return first untreated operator
+}
+
+func (ls *parser) expr(v *expDesc) { ls.subExpr(v, 0) }
+
+func (ls *parser) block() {
+	// block -> statlist
+	var bl blockCnt
+	fs := ls.fs
+	fs.enterBlock(&bl, false)
+	ls.statList()
+	fs.leaveBlock()
+}
+
+// lhsAssign is a structure to chain all variables in the left-hand
+// side of an assignment.
+type lhsAssign struct {
+	prev *lhsAssign
+	v    expDesc // variable (global, local, upvalue, or indexed)
+}
+
+// checkConflict checks whether, in an assignment to an upvalue/local
+// variable, the upvalue/local variable is begin used in a previous
+// assignment to a table. If so, save original upvalue/local value
+// in a safe place and use this safe copy in the previous assignment.
+func (ls *parser) checkConflict(lh *lhsAssign, v *expDesc) {
+	fs := ls.fs
+	extra := fs.freeReg // eventual position to save local variable
+	conflict := false
+	for ; lh != nil; lh = lh.prev { // check all previous assignments
+		if lh.v.k == vIndexed { // assigning to a table?
+			// table is the upvalue/local being assigned now?
+			if lh.v.ind.vt == v.k && lh.v.ind.t == v.info {
+				conflict = true
+				lh.v.ind.vt = vLocal
+				lh.v.ind.t = extra // previous assignment will use safe copy
+			}
+			// index is the local being assigned? (index cannot be upvalue)
+			if v.k == vLocal && lh.v.ind.idx == v.info {
+				conflict = true
+				lh.v.ind.idx = extra // previous assignment will use safe copy
+			}
+		}
+	}
+	if conflict {
+		// copy upvalue/local value to a temporary (in position 'extra')
+		op := vm.GETUPVAL
+		if v.k == vLocal {
+			op = vm.MOVE
+		}
+		fs.codeABC(op, extra, v.info, 0)
+		fs.reserveRegs(1)
+	}
+}
+
+func (ls *parser) assignment(lh *lhsAssign, nvars int) {
+	var e expDesc
+	ls.checkCondition(isVar(lh.v.k), "syntax error")
+	if ls.testNext(',') { // assignment -> ',' suffixedexp assignment
+		nv := &lhsAssign{prev: lh}
+		ls.suffixedExp(&nv.v)
+		if nv.v.k != vIndexed {
+			ls.checkConflict(lh, &nv.v)
+		}
+		ls.fs.checkLimit(nvars+ls.nCcalls, maxCCalls, "C levels")
+		ls.assignment(nv, nvars+1)
+	} else { // assignment -> '=' explist
+		ls.checkNext('=')
+		if nexps := ls.expList(&e); nexps != nvars {
+			ls.adjustAssign(nvars, nexps, &e)
+		} else {
+			ls.fs.setOneRet(&e) // close last expression
+			ls.fs.storeVar(&lh.v, &e)
+			return // avoid default
+		}
+	}
+	e.init(vNonReloc, ls.fs.freeReg-1) // default assignment
+	ls.fs.storeVar(&lh.v, &e)
+}
+
+func (ls *parser) cond() int {
+	// cond -> exp
+	var v expDesc
+	ls.expr(&v) // read condition
+	if v.k == vNil {
+		v.k = vFalse // 'falses' are all equal here
+	}
+	ls.fs.goIfTrue(&v)
+	return v.f
+}
+
+func (ls *parser) gotoStat(pc int) {
+	var (
+		line  = ls.Line()
+		label string
+	)
+	if ls.testNext(TokenGoto) {
+		label = ls.strCheckName()
+	} else {
+		ls.Next() // skip break
+		label = "break"
+	}
+	g := ls.newLabelEntry(&ls.gotos, label, line, pc)
+	ls.findLabel(g) // close it if label already defined
+}
+
+// checkRepeated checks for repeated labels on the same block.
+func (ls *parser) checkRepeated(label string) {
+	for i := ls.fs.bl.firstLabel; i < len(ls.labels); i++ {
+		if label == ls.labels[i].name {
+			ls.semError(fmt.Sprintf("label '%s' already defined on line %d", label, ls.labels[i].line))
+		}
+	}
+}
+
+// skipNoOpStat skips no-op statements.
+func (ls *parser) skipNoOpStat() {
+	for ls.Token() == ';' || ls.Token() == TokenLabel {
+		ls.statement()
+	}
+}
+
+func (ls *parser) labelStat(label string, line int) {
+	// label -> '::' NAME '::'
+	fs := ls.fs
+	ls.checkRepeated(label)  // check for repeated labels
+	ls.checkNext(TokenLabel) // skip double colon
+	// create new entry for this label
+	l := ls.newLabelEntry(&ls.labels, label, line, fs.getLabel())
+	ls.skipNoOpStat()          // skip other no-op statements
+	if ls.blockFollow(false) { // label is last no-op statement in the block?
+		// assume that locals are already out of scope
+		ls.labels[l].nActVar = fs.bl.nActVar
+	}
+	ls.findGotos(&ls.labels[l])
+}
+
+func (ls *parser) whileStat(line int) {
+	// whilestat -> WHILE cond DO block END
+	var bl blockCnt
+	fs := ls.fs
+	ls.Next() // skip WHILE
+	whileInit := fs.getLabel()
+	condExit := ls.cond()
+	fs.enterBlock(&bl, true)
+	ls.checkNext(TokenDo)
+	ls.block()
+	fs.jumpTo(whileInit)
+	ls.checkMatch(TokenEnd, TokenWhile, line)
+	fs.leaveBlock()
+	fs.patchToHere(condExit) // false conditions finish the loop
+}
+
+func (ls *parser) repeatStat(line int) {
+	// repeatstat -> REPEAT block UNTIL cond
+	var bl1, bl2 blockCnt
+	fs := ls.fs
+	repeatInit := fs.getLabel()
+	fs.enterBlock(&bl1, true)  // loop block
+	fs.enterBlock(&bl2, false) // scope block
+	ls.Next()                  // skip REPEAT
+	ls.statList()
+	ls.checkMatch(TokenUntil, TokenRepeat, line)
+	condExit := ls.cond() // read condition (inside scope block)
+	if bl2.upval {        // upvalues?
+		fs.patchClose(condExit, bl2.nActVar)
+	}
+	fs.leaveBlock()                    // finish scope
+	fs.patchList(condExit, repeatInit) // close the loop
+	fs.leaveBlock()                    // finish loop
+}
+
+func (ls *parser) exp1() int {
+	var e expDesc
+	ls.expr(&e)
+	ls.fs.exp2NextReg(&e)
+	return e.info
+}
+
+func (ls *parser) forBody(base, line, nvars int, isNum bool) {
+	// forbody -> DO block
+	var (
+		bl           blockCnt
+		fs           = ls.fs
+		prep, endFor int
+	)
+	ls.adjustLocalVars(3) // control variables
+	ls.checkNext(TokenDo)
+	if isNum {
+		prep = fs.codeAsBx(vm.FORPREP, base, noJump)
+	} else {
+		prep = fs.jump()
+	}
+	fs.enterBlock(&bl, false) // scope for declared variables
+	ls.adjustLocalVars(nvars)
+	fs.reserveRegs(nvars)
+	ls.block()
+	fs.leaveBlock() // end of scope for declared variables
+	fs.patchToHere(prep)
+	if isNum { // numeric for?
+		endFor = fs.codeAsBx(vm.FORLOOP, base, noJump)
+	} else { // generic for
+		fs.codeABC(vm.TFORCALL, base, 0, nvars)
+		fs.fixLine(line)
+		endFor = fs.codeAsBx(vm.TFORLOOP, base+2, noJump)
+	}
+	fs.patchList(endFor, prep+1)
+	fs.fixLine(line)
+}
+
+func (ls *parser) forNum(varName string, line int) {
+	// fornum -> NAME = exp1,exp1[,exp1] forbody
+	fs := ls.fs
+	base := fs.freeReg
+	ls.newLocalVar("(for index)")
+	ls.newLocalVar("(for limit)")
+	ls.newLocalVar("(for step)")
+	ls.newLocalVar(varName)
+	ls.checkNext('=')
+	ls.exp1() // initial value
+	ls.checkNext(',')
+	ls.exp1() // limit
+	if ls.testNext(',') {
+		ls.exp1() // optional step
+	} else { // default step = 1
+		fs.codeK(fs.freeReg, fs.intK(1))
+		fs.reserveRegs(1)
+	}
+	ls.forBody(base, line, 1, true)
+}
+
+func (ls *parser) forList(indexName string) {
+	// forlist -> NAME {,NAME} IN explist forbody
+	var (
+		fs    = ls.fs
+		e     expDesc
+		nvars = 4 // gen, state, control, plus at least one declared var
+		base  = fs.freeReg
+	)
+	// create control variables
+	ls.newLocalVar("(for generator)")
+	ls.newLocalVar("(for state)")
+	ls.newLocalVar("(for control)")
+	// create declared variables
+	ls.newLocalVar(indexName)
+	for ls.testNext(',') {
+		ls.newLocalVar(ls.strCheckName())
+		nvars++
+	}
+	ls.checkNext(TokenIn)
+	line := ls.Line()
+	ls.adjustAssign(3, ls.expList(&e), &e)
+	fs.checkStack(3) // extra space to call generator
+	ls.forBody(base, line, nvars-3, false)
+}
+
+func (ls *parser) forStat(line int) {
+	// forstat -> FOR (fornum | forlist) END
+	var bl blockCnt
+	fs := ls.fs
+	fs.enterBlock(&bl, true)     // scope for loop and control variables
+	ls.Next()                    // skip 'for'
+	varName := ls.strCheckName() // first variable name
+	switch ls.Token() {
+	case '=':
+		ls.forNum(varName, line)
+	case ',', TokenIn:
+		ls.forList(varName)
+	default:
+		ls.syntaxError("'=' or 'in' expected")
+	}
+	ls.checkMatch(TokenEnd, TokenFor, line)
+	fs.leaveBlock() // loop scope ('break' jumps to this point)
+}
+
+func (ls *parser) testThenBlock(escapeList *int) {
+	// test_then_block -> [IF | ELSEIF] cond THEN block
+	var (
+		bl blockCnt
+		fs = ls.fs
+		v  expDesc
+		jf int // instruction to skip 'then' code (if condition is false)
+	)
+	ls.Next()   // skip IF or ELSEIF
+	ls.expr(&v) // read condition
+	ls.checkNext(TokenThen)
+	if ls.Token() == TokenGoto || ls.Token() == TokenBreak {
+		fs.goIfFalse(&v)          // will jump to label if condition is true
+		fs.enterBlock(&bl, false) // must enter block before 'goto'
+		ls.gotoStat(v.t)          // handle goto/break
+		for ls.testNext(';') {    // skip colons
+		}
+		if ls.blockFollow(false) { // 'goto' is the entire block?
+			fs.leaveBlock()
+			return // and that is it
+		}
+		// must skip over 'then' part if condition is false
+		jf = fs.jump()
+	} else { // regular case (not goto/break)
+		fs.goIfTrue(&v) // skip over block if condition is false
+		fs.enterBlock(&bl, false)
+		jf = v.f
+	}
+	ls.statList() // 'then' part
+	fs.leaveBlock()
+	if ls.Token() == TokenElse || ls.Token() == TokenElseIf { // followed by 'else'/'elseif'?
+		fs.concat(escapeList, fs.jump()) // must jump over it
+	}
+	fs.patchToHere(jf)
+}
+
+func (ls *parser) ifStat(line int) {
+	// ifstat -> IF cond THEN block {ELSEIF cond THEN block} [ELSE block] END
+	escapeList := noJump          // exit list for finished parts
+	ls.testThenBlock(&escapeList) // IF cond THEN block
+	for ls.Token() == TokenElseIf {
+		ls.testThenBlock(&escapeList) // ELSEIF cond THEN block
+	}
+	if ls.testNext(TokenElse) {
+		ls.block() // 'else' part
+	}
+	ls.checkMatch(TokenEnd, TokenIf, line)
+	ls.fs.patchToHere(escapeList) // patch escape list to 'if' end
+}
+
+func (ls *parser) localFunc() {
+	var b expDesc
+	fs := ls.fs
+	ls.newLocalVar(ls.strCheckName()) // new local variable
+	ls.adjustLocalVars(1)             // enter its scope
+	ls.body(&b, false, ls.Line())     // function created in next register
+	// debug information will only see the variable after this point!
+	fs.getLocVar(b.info).Live = uint32(fs.pc)
+}
+
+func (ls *parser) localStat() {
+	// stat -> LOCAL NAME {',' NAME} ['=' explist]
+	var (
+		nvars, nexps int
+		e            expDesc
+	)
+	for {
+		ls.newLocalVar(ls.strCheckName())
+		nvars++
+		if !ls.testNext(',') {
+			break
+		}
+	}
+	if ls.testNext('=') {
+		nexps = ls.expList(&e)
+	} else {
+		e.k = vVoid
+		nexps = 0
+	}
+	ls.adjustAssign(nvars, nexps, &e)
+	ls.adjustLocalVars(nvars)
+}
+
+func (ls *parser) funcName(v *expDesc) (isMethod bool) {
+	// funcname -> NAME {fieldsel} [':' NAME]
+	ls.singleVar(v)
+	for ls.Token() == '.' {
+		ls.fieldSel(v)
+	}
+	if ls.Token() == ':' {
+		isMethod = true
+		ls.fieldSel(v)
+	}
+	return isMethod
+}
+
+func (ls *parser) funcStat(line int) {
+	// funcstat -> FUNCTION funcname body
+	var v, b expDesc
+	ls.Next() // skip FUNCTION
+	isMethod := ls.funcName(&v)
+	ls.body(&b, isMethod, line)
+	ls.fs.storeVar(&v, &b)
+	ls.fs.fixLine(line) // definition "happens" in the first line
+}
+
+func (ls *parser) exprStat() {
+	// stat -> func | assignment
+	fs := ls.fs
+	v := &lhsAssign{}
+	ls.suffixedExp(&v.v)
+	if ls.Token() == '=' || ls.Token() == ',' { // stat -> assignment ?
+		ls.assignment(v, 1)
+	} else { // stat -> func
+		ls.checkCondition(v.v.k == vCall, "syntax error")
+		fs.setInstr(v.v.info, fs.instr(v.v.info).WithC(1)) // call statement uses no results
+	}
+}
+
+func (ls *parser) retStat() {
+	// stat -> RETURN [explist] [';']
+	var (
+		fs          = ls.fs
+		e           expDesc
+		first, nret int // registers with returned values
+	)
+	if ls.blockFollow(true) || ls.Token() == ';' {
+		first, nret = 0, 0 // return no values
+	} else {
+		nret = ls.expList(&e) // optional return values
+		if hasMultRet(e.k) {
+			fs.setMultRet(&e)
+			if e.k == vCall && nret == 1 { // tail call?
+				fs.setInstr(e.info, fs.instr(e.info).WithCode(vm.TAILCALL))
+			}
+			first = fs.nActVar
+			nret = multRet // return all values
+		} else {
+			if nret == 1 { // only one single value?
+				first = fs.exp2AnyReg(&e)
+			} else {
+				fs.exp2NextReg(&e) // values must go to the stack
+				first = fs.nActVar // return all active values
+			}
+		}
+	}
+	fs.ret(first, nret)
+	ls.testNext(';') // skip optional semicolon
+}
+
+func (ls *parser) statement() {
+	line := ls.Line() // may be needed for error messages
+	ls.enterLevel()
+	switch ls.Token() {
+	case ';': // stat -> ';' (empty statement)
+		ls.Next() // skip ';'
+	case TokenIf: // stat -> ifstat
+		ls.ifStat(line)
+	case TokenWhile: // stat -> whilestat
+		ls.whileStat(line)
+	case TokenDo: // stat -> DO block END
+		ls.Next() // skip DO
+		ls.block()
+		ls.checkMatch(TokenEnd, TokenDo, line)
+	case TokenFor: // stat -> forstat
+		ls.forStat(line)
+	case TokenRepeat: // stat -> repeatstat
+		ls.repeatStat(line)
+	case TokenFunction: // stat -> funcstat
+		ls.funcStat(line)
+	case TokenLocal: // stat -> localstat
+		ls.Next()                       // skip LOCAL
+		if ls.testNext(TokenFunction) { // local function?
+			ls.localFunc()
+		} else {
+			ls.localStat()
+		}
+	case TokenLabel: // stat -> label
+		ls.Next() // skip double colon
+		ls.labelStat(ls.strCheckName(), line)
+	case TokenReturn: // stat -> retstat
+		ls.Next() // skip RETURN
+		ls.retStat()
+	case TokenBreak, TokenGoto: // stat -> breakstat | 'goto' NAME
+		ls.gotoStat(ls.fs.jump())
+	default: // stat -> func | assignment
+		ls.exprStat()
+	}
+	ls.fs.freeReg = ls.fs.nActVar // free registers
+	ls.leaveLevel()
+}
+
+// mainFunc compiles the main function, which is a regular vararg
+// function with an upvalue named LUA_ENV.
+func (ls *parser) mainFunc(fs *funcState) {
+	var (
+		bl blockCnt
+		v  expDesc
+	)
+	ls.openFunc(fs, &bl)
+	fs.f.Vararg = 1            // main function is always declared vararg
+	v.init(vLocal, 0)          // create and...
+	fs.newUpvalue(ls.envn, &v) // ...set environment upvalue
+	ls.Next()                  // read first token
+	ls.statList()              // parse main body
+	ls.check(TokenEOS)
+	ls.closeFunc()
+}
+
+// int2fb converts an integer to a "floating point byte", represented
+// as (eeeeexxx), where the real value is (1xxx) * 2^(eeeee - 1) if
+// eeeee != 0 and (xxx) otherwise.
+func int2fb(x int) int {
+	e := 0 // exponent
+	if x < 8 {
+		return x
+	}
+	for x >= (8 << 4) { // coarse steps
+		x = (x + 0xf) >> 4 // x = ceil(x / 16)
+		e += 4
+	}
+	for x >= (8 << 1) { // fine steps
+		x = (x + 1) >> 1 // x = ceil(x / 2)
+		e++
+	}
+	return ((e + 1) << 3) | (x - 8)
+}
@@ -0,0 +1,1482 @@
+package syntax
+
+import (
+	"fmt"
+
+	"github.com/Azure/golua/lua/binary"
+	"github.com/Azure/golua/lua/vm54"
+)
+
+// maxShortLen is the maximum length of a short string; only short
+// strings can be used as constant keys by the 5.4 instructions.
+const maxShortLen = 40
+
+// Additional kinds of variables and expressions of the 5.4 compiler.
+const (
+	vKStr     expKind = iota + vVararg + 1 // string constant; strval = string value
+	vConst                                 // compile-time constant variable; info = absolute index in 'actVar'
+	vIndexUp                               // indexed upvalue; ind.t = table upvalue; ind.idx = key's K index
+	vIndexInt                              // indexed variable with constant integer; ind.t = table register; ind.idx = key's value
+	vIndexStr                              // indexed variable with literal string; ind.t = table register; ind.idx = key's K index
+)
+
+func isVar54(k expKind) bool {
+	return k == vLocal || k == vUpval || k == vConst || isIndexed54(k)
+}
+
+func isIndexed54(k expKind) bool {
+	return k == vIndexed || k == vIndexUp || k == vIndexInt || k == vIndexStr
+}
+
+// expDesc54 describes a potentially-delayed expression of the 5.4
+// compiler. Local variables (vLocal) are described by the register
+// holding them (ridx) and their index among the active variables
+// of their function (vidx).
+type expDesc54 struct {
+	k      expKind
+	info   int     // generic use
+	ival   int64   // for vKInt
+	nval   float64 // for vKFlt
+	strval string  // for vKStr
+	ind    struct {
+		idx int // index (R or "long" K)
+		t   int // table (register or upvalue)
+	}
+	ridx int // register holding the variable (for vLocal)
+	vidx int // compiler index (in 'actVar') (for vLocal)
+	t    int // patch list of 'exit when true'
+	f    int // patch list of 'exit when false'
+}
+
+func (e *expDesc54) init(k expKind, info int) {
+	e.f, e.t = noJump, noJump
+	e.k, e.info = k, info
+}
+
+// varDesc describes an active local variable.
+type varDesc struct {
+	kind byte        // binary.VDKREG, RDKCONST, RDKTOCLOSE or RDKCTC
+	ridx int         // register holding the variable
+	pidx int         // index of the variable in the prototype's Locals
+	name string      // variable name
+	k    interface{} // constant value (if it is a compile-time constant)
+}
+
+// labelDesc54 describes a pending goto statement or label.
+type labelDesc54 struct {
+	name    string // label identifier
+	pc      int    // position in code
+	line    int    // line where it appeared
+	nActVar int    // number of active variables in that position
+	close   bool   // goto that escapes upvalues
+}
+
+// blockCnt54 is a node in the list of active blocks.
+type blockCnt54 struct {
+	previous   *blockCnt54 // chain
+	firstLabel int         // index of first label in this block
+	firstGoto  int         // index of first pending goto in this block
+	nActVar    int         // # active locals outside the block
+	upval      bool        // true if some variable in the block is an upvalue
+	isLoop     bool        // true if block is a loop
+	insideTBC  bool        // true if inside the scope of a to-be-closed var.
+}
+
+// funcState54 holds the state needed to generate code for a function.
+type funcState54 struct {
+	f          *binary.Prototype // current function header
+	prev       *funcState54      // enclosing function
+	ls         *parser54         // lexical state
+	bl         *blockCnt54       // chain of current blocks
+	pc         int               // next position to code (equivalent to 'ncode')
+	lastTarget int               // 'label' of last 'jump label'
+	np         int               // number of elements in 'p'
+	firstLocal int               // index of first local var (in actVar)
+	firstLabel int               // index of first label (in labels)
+	nActVar    int               // number of active local variables
+	freeReg    int               // first free register
+	needClose  bool              // function needs to close upvalues when returning
+}
+
+// parser54 holds the lexical state and the dynamic structures
+// used by the 5.4 parser.
+type parser54 struct {
+	lexer
+	fs      *funcState54        // current function (parser)
+	actVar  []varDesc           // list of all active local variables
+	gotos   []labelDesc54       // list of pending gotos
+	labels  []labelDesc54       // list of active labels
+	cache   map[interface{}]int // constants cache (to reuse constants)
+	nCcalls int                 // number of nested syntactical constructs
+	envn    string              // environment variable name
+}
+
+// Compile54 is like Compile but accepts the Lua 5.4 grammar and
+// generates code for the 5.4 virtual machine (see package vm54).
+//
+// The resulting prototype contains the same instructions and
+// debug information generated by the reference compiler (luac 5.4).
+func Compile54(chunkname string, src []byte) (_ *binary.Prototype, err error) {
+	defer Catch(&err)
+	ls := &parser54{
+		lexer: lexer{NewScanner(chunkname, src)},
+		cache: make(map[interface{}]int),
+		envn:  "_ENV",
+	}
+	proto := &binary.Prototype{Source: chunkname}
+	ls.mainFunc(&funcState54{f: proto})
+	return proto, nil
+}
+
+func (fs *funcState54) errorLimit(limit int, what string) {
+	where := "main function"
+	if line := fs.f.SrcPos; line != 0 {
+		where = fmt.Sprintf("function at line %d", line)
+	}
+	fs.ls.syntaxError(fmt.Sprintf("too many %s (limit is %d) in %s", what, limit, where))
+}
+
+func (fs *funcState54) checkLimit(v, l int, what string) {
+	if v > l {
+		fs.errorLimit(l, what)
+	}
+}
+
+func (ls *parser54) codeString(e *expDesc54, s string) {
+	e.init(vKStr, 0)
+	e.strval = s
+}
+
+func (ls *parser54) checkName(e *expDesc54) {
+	ls.codeString(e, ls.strCheckName())
+}
+
+// registerLocalVar registers a new local variable in the active
+// function's debug information, returning its index.
+func (ls *parser54) registerLocalVar(name string) int {
+	f := ls.fs.f
+	f.Locals = append(f.Locals, binary.LocalVar{Name: name, Live: uint32(ls.fs.pc)})
+	return len(f.Locals) - 1
+}
+
+// newLocalVar creates a new local variable with the given name,
+// returning its index in the function.
+func (ls *parser54) newLocalVar(name string) int {
+	fs := ls.fs
+	fs.checkLimit(len(ls.actVar)+1-fs.firstLocal, maxVars, "local variables")
+	ls.actVar = append(ls.actVar, varDesc{kind: binary.VDKREG, name: name})
+	return len(ls.actVar) - 1 - fs.firstLocal
+}
+
+// localVarDesc returns the description of the local variable at
+// index vidx; like the reference implementation, it may be asked
+// about the variables of a block just left, whose descriptions are
+// still in the underlying array.
+func (fs *funcState54) localVarDesc(vidx int) *varDesc {
+	return &fs.ls.actVar[:cap(fs.ls.actVar)][fs.firstLocal+vidx]
+}
+
+// regLevel converts a compiler index level to its corresponding
+// register, searching for the highest variable below that level
+// that is in a register and using its register index + 1.
+func (fs *funcState54) regLevel(nvar int) int {
+	for nvar > 0 {
+		nvar--
+		if vd := fs.localVarDesc(nvar); vd.kind != binary.RDKCTC { // is in a register?
+			return vd.ridx + 1
+		}
+	}
+	return 0 // no variables in registers
+}
+
+// nVarStack returns the number of variables in the register stack
+// for the current function.
+func (fs *funcState54) nVarStack() int { return fs.regLevel(fs.nActVar) }
+
+// localDebugInfo returns the debug information for the variable
+// vidx, or nil for compile-time constants.
+func (fs *funcState54) localDebugInfo(vidx int) *binary.LocalVar {
+	vd := fs.localVarDesc(vidx)
+	if vd.kind == binary.RDKCTC {
+		return nil // no debug info. for constants
+	}
+	return &fs.f.Locals[vd.pidx]
+}
+
+// initVar creates an expression representing the variable vidx.
+func (fs *funcState54) initVar(e *expDesc54, vidx int) {
+	e.f, e.t = noJump, noJump
+	e.k = vLocal
+	e.vidx = vidx
+	e.ridx = fs.localVarDesc(vidx).ridx
+}
+
+// checkReadonly raises an error if variable described by e is
+// read only.
+func (ls *parser54) checkReadonly(e *expDesc54) {
+	fs := ls.fs
+	var name string // not read-only by default
+	switch e.k {
+	case vConst:
+		name = ls.actVar[e.info].name
+	case vLocal:
+		if vd := fs.localVarDesc(e.vidx); vd.kind != binary.VDKREG { // not a regular variable?
+			name = vd.name
+		}
+	case vUpval:
+		if up := fs.f.UpValues[e.info]; up.Kind != binary.VDKREG {
+			name = fs.f.UpNames[e.info]
+		}
+	default:
+		return // other cases cannot be read-only
+	}
+	if name != "" {
+		ls.semError(fmt.Sprintf("attempt to assign to const variable '%s'", name))
+	}
+}
+
+// adjustLocalVars starts the scope for the last nvars created
+// variables.
+func (ls *parser54) adjustLocalVars(nvars int) {
+	fs := ls.fs
+	regLevel := fs.nVarStack()
+	for i := 0; i < nvars; i++ {
+		vidx := fs.nActVar
+		fs.nActVar++
+		vd := fs.localVarDesc(vidx)
+		vd.ridx = regLevel
+		regLevel++
+		vd.pidx = ls.registerLocalVar(vd.name)
+	}
+}
+
+// removeVars closes the scope for all variables up to level toLevel.
+func (fs *funcState54) removeVars(toLevel int) {
+	n := len(fs.ls.actVar) - (fs.nActVar - toLevel)
+	for fs.nActVar > toLevel {
+		fs.nActVar--
+		if v := fs.localDebugInfo(fs.nActVar); v != nil { // does it have debug information?
+			v.Dead = uint32(fs.pc)
+		}
+	}
+	fs.ls.actVar = fs.ls.actVar[:n]
+}
+
+// searchUpvalue searches the upvalues of the function for one
+// with the given name.
+func (fs *funcState54) searchUpvalue(name string) int {
+	for i, up := range fs.f.UpNames {
+		if up == name {
+			return i
+		}
+	}
+	return -1 // not found
+}
+
+func (fs *funcState54) newUpvalue(name string, v *expDesc54) int {
+	fs.checkLimit(len(fs.f.UpValues)+1, maxUpVals, "upvalues")
+	var up binary.UpValue
+	if prev := fs.prev; v.k == vLocal {
+		up.InStack = 1
+		up.Index = byte(v.ridx)
+		up.Kind = prev.localVarDesc(v.vidx).kind
+	} else {
+		up.Index = byte(v.info)
+		up.Kind = prev.f.UpValues[v.info].Kind
+	}
+	fs.f.UpValues = append(fs.f.UpValues, up)
+	fs.f.UpNames = append(fs.f.UpNames, name)
+	return len(fs.f.UpValues) - 1
+}
+
+// searchVar looks for an active local variable with the given name;
+// if found, it initializes v with it and reports whether it did.
+func (fs *funcState54) searchVar(name string, v *expDesc54) bool {
+	for i := fs.nActVar - 1; i >= 0; i-- {
+		if vd := fs.localVarDesc(i); name == vd.name { // found?
+			if vd.kind == binary.RDKCTC { // compile-time constant?
+				v.init(vConst, fs.firstLocal+i)
+			} else { // real variable
+				fs.initVar(v, i)
+			}
+			return true
+		}
+	}
+	return false // not found
+}
+
+// markUpval marks the block where variable at given level was defined
+// (to emit close instructions later).
+func (fs *funcState54) markUpval(level int) {
+	bl := fs.bl
+	for bl.nActVar > level {
+		bl = bl.previous
+	}
+	bl.upval = true
+	fs.needClose = true
+}
+
+// markToBeClosed marks that the current block has a to-be-closed
+// variable.
+func (fs *funcState54) markToBeClosed() {
+	bl := fs.bl
+	bl.upval = true
+	bl.insideTBC = true
+	fs.needClose = true
+}
+
+// singleVarAux54 finds the variable with the given name. If it is an
+// upvalue, add this upvalue into all intermediate functions. If it is
+// a global, set v as void as a flag.
+func singleVarAux54(fs *funcState54, name string, v *expDesc54, base bool) {
+	if fs == nil { // no more levels?
+		v.init(vVoid, 0) // default is global
+		return
+	}
+	if fs.searchVar(name, v) { // look up locals at current level
+		if v.k == vLocal && !base {
+			fs.markUpval(v.vidx) // local will be used as an upval
+		}
+		return
+	}
+	// not found as local at current level; try upvalues
+	idx := fs.searchUpvalue(name) // try existing upvalues
+	if idx < 0 {                  // not found?
+		singleVarAux54(fs.prev, name, v, false) // try upper levels
+		if v.k != vLocal && v.k != vUpval {     // not found?
+			return // it is a global or a constant
+		}
+		// else was LOCAL or UPVAL
+		idx = fs.newUpvalue(name, v) // will be a new upvalue
+	}
+	v.init(vUpval, idx) // new or old upvalue
+}
+
+// singleVar finds a variable with the given name. If it is a global,
+// it is transformed into an index of the environment.
+func (ls *parser54) singleVar(v *expDesc54) {
+	name := ls.strCheckName()
+	fs := ls.fs
+	if singleVarAux54(fs, name, v, true); v.k == vVoid { // global name?
+		var key expDesc54
+		singleVarAux54(fs, ls.envn, v, true) // get environment variable
+		fs.exp2AnyRegUp(v)                   // but could be a constant
+		ls.codeString(&key, name)            // key is variable name
+		fs.indexed(v, &key)                  // env[varname]
+	}
+}
+
+// adjustAssign adjusts the number of results from an expression list
+// e with nexps expressions to nvars values.
+func (ls *parser54) adjustAssign(nvars, nexps int, e *expDesc54) {
+	fs := ls.fs
+	needed := nvars - nexps // extra values needed
+	if hasMultRet(e.k) {    // last expression has multiple returns?
+		extra := needed + 1 // discount last expression itself
+		if extra < 0 {
+			extra = 0
+		}
+		fs.setReturns(e, extra) // last exp. provides the difference
+	} else {
+		if e.k != vVoid { // at least one expression?
+			fs.exp2NextReg(e) // close last expression
+		}
+		if needed > 0 { // missing values?
+			fs.loadNil(fs.freeReg, needed) // complete with nils
+		}
+	}
+	if needed > 0 {
+		fs.reserveRegs(needed) // registers for extra values
+	} else { // adding 'needed' is actually a subtraction
+		fs.freeReg += needed // remove extra values
+	}
+}
+
+func (ls *parser54) enterLevel() {
+	ls.nCcalls++
+	ls.fs.checkLimit(ls.nCcalls, maxCCalls, "C levels")
+}
+
+func (ls *parser54) leaveLevel() { ls.nCcalls-- }
+
+// jumpScopeError generates an error for a goto that jumps into the
+// scope of some local variable.
+func (ls *parser54) jumpScopeError(gt *labelDesc54) {
+	name := ls.fs.localVarDesc(gt.nActVar).name
+	ls.semError(fmt.Sprintf("<goto %s> at line %d jumps into the scope of local '%s'", gt.name, gt.line, name))
+}
+
+// solveGoto solves the pending goto at index g to given label and
+// removes it from the list of pending gotos.
+func (ls *parser54) solveGoto(g int, label *labelDesc54) {
+	gt := &ls.gotos[g]
+	if gt.nActVar < label.nActVar { // enter some scope?
+		ls.jumpScopeError(gt)
+	}
+	ls.fs.patchList(gt.pc, label.pc)
+	// remove goto from pending list
+	ls.gotos = append(ls.gotos[:g], ls.gotos[g+1:]...)
+}
+
+// findLabel searches for an active label with the given name.
+func (ls *parser54) findLabel(name string) *labelDesc54 {
+	// check labels in current function for a match
+	for i := ls.fs.firstLabel; i < len(ls.labels); i++ {
+		if lb := &ls.labels[i]; lb.name == name { // correct label?
+			return lb
+		}
+	}
+	return nil // label not found
+}
+
+// newLabelEntry adds a new label/goto to the list l.
+func (ls *parser54) newLabelEntry(l *[]labelDesc54, name string, line, pc int) int {
+	*l = append(*l, labelDesc54{name: name, line: line, nActVar: ls.fs.nActVar, pc: pc})
+	return len(*l) - 1
+}
+
+func (ls *parser54) newGotoEntry(name string, line, pc int) int {
+	return ls.newLabelEntry(&ls.gotos, name, line, pc)
+}
+
+// solveGotos solves forward jumps: checks whether new label lb
+// matches any pending gotos in current block and solves them.
+// It reports whether any of the gotos needs to close upvalues.
+func (ls *parser54) solveGotos(lb *labelDesc54) bool {
+	needsClose := false
+	for i := ls.fs.bl.firstGoto; i < len(ls.gotos); {
+		if ls.gotos[i].name == lb.name {
+			needsClose = needsClose || ls.gotos[i].close
+			ls.solveGoto(i, lb) // will remove i from the list
+		} else {
+			i++
+		}
+	}
+	return needsClose
+}
+
+// createLabel creates a new label with the given name at the given
+// line; last tells whether the label is the last non-op statement in
+// its block. It solves all pending gotos to this new label and adds a
+// close instruction if necessary, reporting whether it added one.
+func (ls *parser54) createLabel(name string, line int, last bool) bool {
+	fs := ls.fs
+	l := ls.newLabelEntry(&ls.labels, name, line, fs.getLabel())
+	if last { // label is last no-op statement in the block?
+		// assume that locals are already out of scope
+		ls.labels[l].nActVar = fs.bl.nActVar
+	}
+	if ls.solveGotos(&ls.labels[l]) { // need close?
+		fs.codeABC(vm54.CLOSE, fs.nVarStack(), 0, 0)
+		return true
+	}
+	return false
+}
+
+// moveGotosOut adjusts pending gotos to outer level.
+func (fs *funcState54) moveGotosOut(bl *blockCnt54) {
+	ls := fs.ls
+	// correct pending gotos to current block
+	for i := bl.firstGoto; i < len(ls.gotos); i++ { // for each pending goto
+		gt := &ls.gotos[i]
+		// leaving a variable scope?
+		if fs.regLevel(gt.nActVar) > fs.regLevel(bl.nActVar) {
+			gt.close = gt.close || bl.upval // jump may need a close
+		}
+		gt.nActVar = bl.nActVar // update goto level
+	}
+}
+
+func (fs *funcState54) enterBlock(bl *blockCnt54, isLoop bool) {
+	bl.isLoop = isLoop
+	bl.nActVar = fs.nActVar
+	bl.firstLabel = len(fs.ls.labels)
+	bl.firstGoto = len(fs.ls.gotos)
+	bl.upval = false
+	bl.insideTBC = fs.bl != nil && fs.bl.insideTBC
+	bl.previous = fs.bl
+	fs.bl = bl
+}
+
+// undefGoto generates an error for an undefined 'goto'.
+func (ls *parser54) undefGoto(gt *labelDesc54) {
+	if gt.name == "break" {
+		ls.semError(fmt.Sprintf("break outside a loop at line %d", gt.line))
+	}
+	ls.semError(fmt.Sprintf("no visible label '%s' for <goto> at line %d", gt.name, gt.line))
+}
+
+func (fs *funcState54) leaveBlock() {
+	bl := fs.bl
+	ls := fs.ls
+	hasClose := false
+	stkLevel := fs.regLevel(bl.nActVar) // level outside the block
+	fs.removeVars(bl.nActVar)           // remove block locals
+	if bl.isLoop {                      // has to fix pending breaks?
+		hasClose = ls.createLabel("break", 0, false)
+	}
+	if !hasClose && bl.previous != nil && bl.upval { // still need a 'close'?
+		fs.codeABC(vm54.CLOSE, stkLevel, 0, 0)
+	}
+	fs.freeReg = stkLevel                 // free registers
+	ls.labels = ls.labels[:bl.firstLabel] // remove local labels
+	fs.bl = bl.previous                   // current block now is previous one
+	if bl.previous != nil {               // was it a nested block?
+		fs.moveGotosOut(bl) // update pending gotos to enclosing block
+	} else if bl.firstGoto < len(ls.gotos) { // still pending gotos?
+		ls.undefGoto(&ls.gotos[bl.firstGoto]) // error
+	}
+}
+
+// addPrototype adds a new prototype into the list of prototypes.
+func (ls *parser54) addPrototype() *binary.Prototype {
+	ls.fs.np++
+	return &binary.Prototype{}
+}
+
+// codeClosure codes instruction to create new closure in parent
+// function.
+func (ls *parser54) codeClosure(v *expDesc54) {
+	fs := ls.fs.prev
+	v.init(vRelocable, fs.codeABx(vm54.CLOSURE, 0, fs.np-1))
+	fs.exp2NextReg(v) // fix it at the last register
+}
+
+func (ls *parser54) openFunc(fs *funcState54, bl *blockCnt54) {
+	fs.prev = ls.fs // linked list of funcstates
+	fs.ls = ls
+	ls.fs = fs
+	fs.pc = 0
+	fs.lastTarget = 0
+	fs.freeReg = 0
+	fs.np = 0
+	fs.nActVar = 0
+	fs.needClose = false
+	fs.firstLocal = len(ls.actVar)
+	fs.firstLabel = len(ls.labels)
+	fs.bl = nil
+	fs.f.Source = ls.Source()
+	fs.f.Stack = 2 // registers 0/1 are always valid
+	fs.enterBlock(bl, false)
+}
+
+func (ls *parser54) closeFunc() {
+	fs := ls.fs
+	fs.ret(fs.nVarStack(), 0) // final return
+	fs.leaveBlock()
+	fs.finish()
+	ls.fs = fs.prev
+	if prev := ls.fs; prev != nil {
+		prev.f.Protos = append(prev.f.Protos, *fs.f)
+	}
+}
+
+func (ls *parser54) statList() {
+	// statlist -> { stat [';'] }
+	for !ls.blockFollow(true) {
+		if ls.Token() == TokenReturn {
+			ls.statement()
+			return // 'return' must be last statement
+		}
+		ls.statement()
+	}
+}
+
+func (ls *parser54) fieldSel(v *expDesc54) {
+	// fieldsel -> ['.' | ':'] NAME
+	fs := ls.fs
+	var key expDesc54
+	fs.exp2AnyRegUp(v)
+	ls.Next() // skip the dot or colon
+	ls.checkName(&key)
+	fs.indexed(v, &key)
+}
+
+func (ls *parser54) yIndex(v *expDesc54) {
+	// index -> '[' expr ']'
+	ls.Next() // skip the '['
+	ls.expr(v)
+	ls.fs.exp2Val(v)
+	ls.checkNext(']')
+}
+
+// consControl54 holds the state of a table constructor.
+type consControl54 struct {
+	v       expDesc54  // last list item read
+	t       *expDesc54 // table descriptor
+	nh      int        // total number of 'record' elements
+	na      int        // number of array elements already stored
+	toStore int        // number of array elements pending to be stored
+}
+
+func (ls *parser54) recField(cc *consControl54) {
+	// recfield -> (NAME | '['exp']') = exp
+	fs := ls.fs
+	reg := fs.freeReg
+	var key, val expDesc54
+	if ls.Token() == TokenName {
+		fs.checkLimit(cc.nh, maxInt, "items in a constructor")
+		ls.checkName(&key)
+	} else { // ls.Token() == '['
+		ls.yIndex(&key)
+	}
+	cc.nh++
+	ls.checkNext('=')
+	tab := *cc.t
+	fs.indexed(&tab, &key)
+	ls.expr(&val)
+	fs.storeVar(&tab, &val)
+	fs.freeReg = reg // free registers
+}
+
+func (fs *funcState54) closeListField(cc *consControl54) {
+	if cc.v.k == vVoid {
+		return // there is no list item
+	}
+	fs.exp2NextReg(&cc.v)
+	cc.v.k = vVoid
+	if cc.toStore == fieldsPerFlush {
+		fs.setList(cc.t.info, cc.na, cc.toStore) // flush
+		cc.na += cc.toStore
+		cc.toStore = 0 // no more items pending
+	}
+}
+
+func (fs *funcState54) lastListField(cc *consControl54) {
+	if cc.toStore == 0 {
+		return
+	}
+	if hasMultRet(cc.v.k) {
+		fs.setMultRet(&cc.v)
+		fs.setList(cc.t.info, cc.na, multRet)
+		cc.na-- // do not count last expression (unknown number of elements)
+	} else {
+		if cc.v.k != vVoid {
+			fs.exp2NextReg(&cc.v)
+		}
+		fs.setList(cc.t.info, cc.na, cc.toStore)
+	}
+	cc.na += cc.toStore
+}
+
+func (ls *parser54) listField(cc *consControl54) {
+	// listfield -> exp
+	ls.expr(&cc.v)
+	cc.toStore++
+}
+
+func (ls *parser54) field(cc *consControl54) {
+	// field -> listfield | recfield
+	switch ls.Token() {
+	case TokenName: // may be 'listfield' or 'recfield'
+		if ls.Lookahead() != '=' { // expression?
+			ls.listField(cc)
+		} else {
+			ls.recField(cc)
+		}
+	case '[':
+		ls.recField(cc)
+	default:
+		ls.listField(cc)
+	}
+}
+
+func (ls *parser54) constructor(t *expDesc54) {
+	// constructor -> '{' [ field { sep field } [sep] ] '}'
+	// sep -> ',' | ';'
+	fs := ls.fs
+	line := ls.Line()
+	pc := fs.codeABC(vm54.NEWTABLE, 0, 0, 0)
+	fs.code(0) // space for extra arg.
+	cc := consControl54{t: t}
+	t.init(vNonReloc, fs.freeReg) // table will be at stack top
+	fs.reserveRegs(1)
+	cc.v.init(vVoid, 0) // no value (yet)
+	ls.checkNext('{')
+	for {
+		if ls.Token() == '}' {
+			break
+		}
+		fs.closeListField(&cc)
+		ls.field(&cc)
+		if !ls.testNext(',') && !ls.testNext(';') {
+			break
+		}
+	}
+	ls.checkMatch('}', '{', line)
+	fs.lastListField(&cc)
+	fs.setTableSize(pc, t.info, cc.na, cc.nh)
+}
+
+// setVararg marks the function as vararg, emitting the instruction
+// that adjusts its parameters.
+func (fs *funcState54) setVararg(nparams int) {
+	fs.f.Vararg = 1
+	fs.codeABC(vm54.VARARGPREP, nparams, 0, 0)
+}
+
+func (ls *parser54) parList() {
+	// parlist -> [ {NAME ','} (NAME | '...') ]
+	fs := ls.fs
+	f := fs.f
+	nparams := 0
+	isVararg := false
+	if ls.Token() != ')' { // is 'parlist' not empty?
+		for {
+			switch ls.Token() {
+			case TokenName:
+				ls.newLocalVar(ls.strCheckName())
+				nparams++
+			case TokenDots:
+				ls.Next()
+				isVararg = true
+			default:
+				ls.syntaxError("<name> or '...' expected")
+			}
+			if isVararg || !ls.testNext(',') {
+				break
+			}
+		}
+	}
+	ls.adjustLocalVars(nparams)
+	f.Params = byte(fs.nActVar)
+	if isVararg {
+		fs.setVararg(int(f.Params)) // declared vararg
+	}
+	fs.reserveRegs(fs.nActVar) // reserve registers for parameters
+}
+
+func (ls *parser54) body(e *expDesc54, isMethod bool, line int) {
+	// body ->  '(' parlist ')' block END
+	var bl blockCnt54
+	fs := &funcState54{f: ls.addPrototype()}
+	fs.f.SrcPos = uint32(line)
+	ls.openFunc(fs, &bl)
+	ls.checkNext('(')
+	if isMethod {
+		ls.newLocalVar("self") // create 'self' parameter
+		ls.adjustLocalVars(1)
+	}
+	ls.parList()
+	ls.checkNext(')')
+	ls.statList()
+	fs.f.EndPos = uint32(ls.Line())
+	ls.checkMatch(TokenEnd, TokenFunction, line)
+	ls.codeClosure(e)
+	ls.closeFunc()
+}
+
+func (ls *parser54) expList(v *expDesc54) int {
+	// explist -> expr { ',' expr }
+	n := 1 // at least one expression
+	ls.expr(v)
+	for ls.testNext(',') {
+		ls.fs.exp2NextReg(v)
+		ls.expr(v)
+		n++
+	}
+	return n
+}
+
+func (ls *parser54) funcArgs(f *expDesc54) {
+	fs := ls.fs
+	line := ls.Line()
+	var args expDesc54
+	switch ls.Token() {
+	case '(': // funcargs -> '(' [ explist ] ')'
+		ls.Next()
+		if ls.Token() == ')' { // arg list is empty?
+			args.k = vVoid
+		} else {
+			ls.expList(&args)
+			if hasMultRet(args.k) {
+				fs.setMultRet(&args)
+			}
+		}
+		ls.checkMatch(')', '(', line)
+	case '{': // funcargs -> constructor
+		ls.constructor(&args)
+	case TokenString: // funcargs -> STRING
+		ls.codeString(&args, ls.tok.sval)
+		ls.Next() // must use 'seminfo' before 'next'
+	default:
+		ls.syntaxError("function arguments expected")
+	}
+	base := f.info // base register for call
+	var nparams int
+	if hasMultRet(args.k) {
+		nparams = multRet // open call
+	} else {
+		if args.k != vVoid {
+			fs.exp2NextReg(&args) // close last argument
+		}
+		nparams = fs.freeReg - (base + 1)
+	}
+	f.init(vCall, fs.codeABC(vm54.CALL, base, nparams+1, 2))
+	fs.fixLine(line)
+	// call removes function and arguments and leaves one result
+	// (unless changed later)
+	fs.freeReg = base + 1
+}
+
+func (ls *parser54) primaryExp(v *expDesc54) {
+	// primaryexp -> NAME | '(' expr ')'
+	switch ls.Token() {
+	case '(':
+		line := ls.Line()
+		ls.Next()
+		ls.expr(v)
+		ls.checkMatch(')', '(', line)
+		ls.fs.dischargeVars(v)
+	case TokenName:
+		ls.singleVar(v)
+	default:
+		ls.syntaxError("unexpected symbol")
+	}
+}
+
+func (ls *parser54) suffixedExp(v *expDesc54) {
+	// suffixedexp ->
+	//   primaryexp { '.' NAME | '[' exp ']' | ':' NAME funcargs | funcargs }
+	fs := ls.fs
+	ls.primaryExp(v)
+	for {
+		switch ls.Token() {
+		case '.': // fieldsel
+			ls.fieldSel(v)
+		case '[': // '[' exp ']'
+			var key expDesc54
+			fs.exp2AnyRegUp(v)
+			ls.yIndex(&key)
+			fs.indexed(v, &key)
+		case ':': // ':' NAME funcargs
+			var key expDesc54
+			ls.Next()
+			ls.checkName(&key)
+			fs.self(v, &key)
+			ls.funcArgs(v)
+		case '(', TokenString, '{': // funcargs
+			fs.exp2NextReg(v)
+			ls.funcArgs(v)
+		default:
+			return
+		}
+	}
+}
+
+func (ls *parser54) simpleExp(v *expDesc54) {
+	// simpleexp -> FLT | INT | STRING | NIL | TRUE | FALSE | ... |
+	//              constructor | FUNCTION body | suffixedexp
+	switch ls.Token() {
+	case TokenFloat:
+		v.init(vKFlt, 0)
+		v.nval = ls.tok.fval
+	case TokenInt:
+		v.init(vKInt, 0)
+		v.ival = ls.tok.ival
+	case TokenString:
+		ls.codeString(v, ls.tok.sval)
+	case TokenNil:
+		v.init(vNil, 0)
+	case TokenTrue:
+		v.init(vTrue, 0)
+	case TokenFalse:
+		v.init(vFalse, 0)
+	case TokenDots: // vararg
+		fs := ls.fs
+		ls.checkCondition(fs.f.Vararg != 0, "cannot use '...' outside a vararg function")
+		v.init(vVararg, fs.codeABC(vm54.VARARG, 0, 0, 1))
+	case '{': // constructor
+		ls.constructor(v)
+		return
+	case TokenFunction:
+		ls.Next()
+		ls.body(v, false, ls.Line())
+		return
+	default:
+		ls.suffixedExp(v)
+		return
+	}
+	ls.Next()
+}
+
+// subExpr parses subexpr -> (simpleexp | unop subexpr) { binop subexpr }
+// where 'binop' is any binary operator with a priority higher than
+// 'limit'.
+func (ls *parser54) subExpr(v *expDesc54, limit int) binOpr {
+	ls.enterLevel()
+	if uop := unaryOp(ls.Token()); uop != oprNoUnOpr {
+		line := ls.Line()
+		ls.Next() // skip operator
+		ls.subExpr(v, unaryPriority)
+		ls.fs.prefix(uop, v, line)
+	} else {
+		ls.simpleExp(v)
+	}
+	// expand while operators have priorities higher than 'limit'
+	op := binaryOp(ls.Token())
+	for op != oprNoBinOpr && priority[op].left > limit {
+		var v2 expDesc54
+		line := ls.Line()
+		ls.Next() // skip operator
+		ls.fs.infix(op, v)
+		// read sub-expression with higher priority
+		next := ls.subExpr(&v2, priority[op].right)
+		ls.fs.posfix(op, v, &v2, line)
+		op = next
+	}
+	ls.leaveLevel()
+	return op // return first untreated operator
+}
+
+func (ls *parser54) expr(v *expDesc54) { ls.subExpr(v, 0) }
+
+func (ls *parser54) block() {
+	// block -> statlist
+	var bl blockCnt54
+	fs := ls.fs
+	fs.enterBlock(&bl, false)
+	ls.statList()
+	fs.leaveBlock()
+}
+
+// lhsAssign54 is a structure to chain all variables in the left-hand
+// side of an assignment.
+type lhsAssign54 struct {
+	prev *lhsAssign54
+	v    expDesc54 // variable (global, local, upvalue, or indexed)
+}
+
+// checkConflict checks whether, in an assignment to an upvalue/local
+// variable, the upvalue/local variable is begin used in a previous
+// assignment to a table. If so, save original upvalue/local value
+// in a safe place and use this safe copy in the previous assignment.
+func (ls *parser54) checkConflict(lh *lhsAssign54, v *expDesc54) {
+	fs := ls.fs
+	extra := fs.freeReg // eventual position to save local variable
+	conflict := false
+	for ; lh != nil; lh = lh.prev { // check all previous assignments
+		if !isIndexed54(lh.v.k) { // assignment to table field?
+			continue
+		}
+		if lh.v.k == vIndexUp { // is table an upvalue?
+			if v.k == vUpval && lh.v.ind.t == v.info {
+				conflict = true // table is the upvalue being assigned now
+				lh.v.k = vIndexStr
+				lh.v.ind.t = extra // assignment will use safe copy
+			}
+		} else { // table is a register
+			if v.k == vLocal && lh.v.ind.t == v.ridx {
+				conflict = true    // table is the local being assigned now
+				lh.v.ind.t = extra // assignment will use safe copy
+			}
+			// is index the local being assigned?
+			if lh.v.k == vIndexed && v.k == vLocal && lh.v.ind.idx == v.ridx {
+				conflict = true
+				lh.v.ind.idx = extra // previous assignment will use safe copy
+			}
+		}
+	}
+	if conflict {
+		// copy upvalue/local value to a temporary (in position 'extra')
+		if v.k == vLocal {
+			fs.codeABC(vm54.MOVE, extra, v.ridx, 0)
+		} else {
+			fs.codeABC(vm54.GETUPVAL, extra, v.info, 0)
+		}
+		fs.reserveRegs(1)
+	}
+}
+
+func (ls *parser54) assignment(lh *lhsAssign54, nvars int) {
+	var e expDesc54
+	ls.checkCondition(isVar54(lh.v.k), "syntax error")
+	ls.checkReadonly(&lh.v)
+	if ls.testNext(',') { // restassign -> ',' suffixedexp restassign
+		nv := &lhsAssign54{prev: lh}
+		ls.suffixedExp(&nv.v)
+		if !isIndexed54(nv.v.k) {
+			ls.checkConflict(lh, &nv.v)
+		}
+		ls.enterLevel() // control recursion depth
+		ls.assignment(nv, nvars+1)
+		ls.leaveLevel()
+	} else { // restassign -> '=' explist
+		ls.checkNext('=')
+		if nexps := ls.expList(&e); nexps != nvars {
+			ls.adjustAssign(nvars, nexps, &e)
+		} else {
+			ls.fs.setOneRet(&e) // close last expression
+			ls.fs.storeVar(&lh.v, &e)
+			return // avoid default
+		}
+	}
+	e.init(vNonReloc, ls.fs.freeReg-1) // default assignment
+	ls.fs.storeVar(&lh.v, &e)
+}
+
+func (ls *parser54) cond() int {
+	// cond -> exp
+	var v expDesc54
+	ls.expr(&v) // read condition
+	if v.k == vNil {
+		v.k = vFalse // 'falses' are all equal here
+	}
+	ls.fs.goIfTrue(&v)
+	return v.f
+}
+
+func (ls *parser54) gotoStat() {
+	fs := ls.fs
+	line := ls.Line()
+	name := ls.strCheckName()                // label's name
+	if lb := ls.findLabel(name); lb == nil { // no label?
+		// forward jump; will be resolved when the label is declared
+		ls.newGotoEntry(name, line, fs.jump())
+	} else { // found a label
+		// backward jump; will be resolved here
+		lbLevel := fs.regLevel(lb.nActVar) // label level
+		if fs.nVarStack() > lbLevel {      // leaving the scope of a variable?
+			fs.codeABC(vm54.CLOSE, lbLevel, 0, 0)
+		}
+		// create jump and link it to the label
+		fs.patchList(fs.jump(), lb.pc)
+	}
+}
+
+// breakStat handles break statements as gotos to a "break" label.
+func (ls *parser54) breakStat() {
+	line := ls.Line()
+	ls.Next() // skip break
+	ls.newGotoEntry("break", line, ls.fs.jump())
+}
+
+// checkRepeated checks whether there is already a label with the
+// given name.
+func (ls *parser54) checkRepeated(name string) {
+	if lb := ls.findLabel(name); lb != nil { // already defined?
+		ls.semError(fmt.Sprintf("label '%s' already defined on line %d", name, lb.line))
+	}
+}
+
+func (ls *parser54) labelStat(name string, line int) {
+	// label -> '::' NAME '::'
+	ls.checkNext(TokenLabel) // skip double colon
+	for ls.Token() == ';' || ls.Token() == TokenLabel {
+		ls.statement() // skip other no-op statements
+	}
+	ls.checkRepeated(name) // check for repeated labels
+	ls.createLabel(name, line, ls.blockFollow(false))
+}
+
+func (ls *parser54) whileStat(line int) {
+	// whilestat -> WHILE cond DO block END
+	var bl blockCnt54
+	fs := ls.fs
+	ls.Next() // skip WHILE
+	whileInit := fs.getLabel()
+	condExit := ls.cond()
+	fs.enterBlock(&bl, true)
+	ls.checkNext(TokenDo)
+	ls.block()
+	fs.jumpTo(whileInit)
+	ls.checkMatch(TokenEnd, TokenWhile, line)
+	fs.leaveBlock()
+	fs.patchToHere(condExit) // false conditions finish the loop
+}
+
+func (ls *parser54) repeatStat(line int) {
+	// repeatstat -> REPEAT block UNTIL cond
+	var bl1, bl2 blockCnt54
+	fs := ls.fs
+	repeatInit := fs.getLabel()
+	fs.enterBlock(&bl1, true)  // loop block
+	fs.enterBlock(&bl2, false) // scope block
+	ls.Next()                  // skip REPEAT
+	ls.statList()
+	ls.checkMatch(TokenUntil, TokenRepeat, line)
+	condExit := ls.cond() // read condition (inside scope block)
+	fs.leaveBlock()       // finish scope
+	if bl2.upval {        // upvalues?
+		exit := fs.jump()        // normal exit must jump over fix
+		fs.patchToHere(condExit) // repetition must close upvalues
+		fs.codeABC(vm54.CLOSE, fs.regLevel(bl2.nActVar), 0, 0)
+		condExit = fs.jump() // repeat after closing upvalues
+		fs.patchToHere(exit) // normal exit comes to here
+	}
+	fs.patchList(condExit, repeatInit) // close the loop
+	fs.leaveBlock()                    // finish loop
+}
+
+// exp1 reads an expression and generates code to put its results
+// in next stack slot.
+func (ls *parser54) exp1() {
+	var e expDesc54
+	ls.expr(&e)
+	ls.fs.exp2NextReg(&e)
+}
+
+// fixForJump fixes the for instruction at pc to jump to dest
+// (jump addresses are relative in Lua); back means a back jump.
+func (fs *funcState54) fixForJump(pc, dest int, back bool) {
+	offset := dest - (pc + 1)
+	if back {
+		offset = -offset
+	}
+	if offset > vm54.MaxArgBX {
+		fs.ls.syntaxError("control structure too long")
+	}
+	fs.setInstr(pc, fs.instr(pc).WithBX(offset))
+}
+
+func (ls *parser54) forBody(base, line, nvars int, isGen bool) {
+	// forbody -> DO block
+	var bl blockCnt54
+	fs := ls.fs
+	ls.checkNext(TokenDo)
+	forPrep, forLoop := vm54.FORPREP, vm54.FORLOOP
+	if isGen {
+		forPrep, forLoop = vm54.TFORPREP, vm54.TFORLOOP
+	}
+	prep := fs.codeABx(forPrep, base, 0)
+	fs.enterBlock(&bl, false) // scope for declared variables
+	ls.adjustLocalVars(nvars)
+	fs.reserveRegs(nvars)
+	ls.block()
+	fs.leaveBlock() // end of scope for declared variables
+	fs.fixForJump(prep, fs.getLabel(), false)
+	if isGen { // generic for?
+		fs.codeABC(vm54.TFORCALL, base, 0, nvars)
+		fs.fixLine(line)
+	}
+	endFor := fs.codeABx(forLoop, base, 0)
+	fs.fixForJump(endFor, prep+1, true)
+	fs.fixLine(line)
+}
+
+func (ls *parser54) forNum(varName string, line int) {
+	// fornum -> NAME = exp,exp[,exp] forbody
+	fs := ls.fs
+	base := fs.freeReg
+	ls.newLocalVar("(for state)")
+	ls.newLocalVar("(for state)")
+	ls.newLocalVar("(for state)")
+	ls.newLocalVar(varName)
+	ls.checkNext('=')
+	ls.exp1() // initial value
+	ls.checkNext(',')
+	ls.exp1() // limit
+	if ls.testNext(',') {
+		ls.exp1() // optional step
+	} else { // default step = 1
+		fs.loadInt(fs.freeReg, 1)
+		fs.reserveRegs(1)
+	}
+	ls.adjustLocalVars(3) // control variables
+	ls.forBody(base, line, 1, false)
+}
+
+func (ls *parser54) forList(indexName string) {
+	// forlist -> NAME {,NAME} IN explist forbody
+	var (
+		fs    = ls.fs
+		e     expDesc54
+		nvars = 5 // gen, state, control, toclose, 'indexname'
+		base  = fs.freeReg
+	)
+	// create control variables
+	ls.newLocalVar("(for state)")
+	ls.newLocalVar("(for state)")
+	ls.newLocalVar("(for state)")
+	ls.newLocalVar("(for state)")
+	// create declared variables
+	ls.newLocalVar(indexName)
+	for ls.testNext(',') {
+		ls.newLocalVar(ls.strCheckName())
+		nvars++
+	}
+	ls.checkNext(TokenIn)
+	line := ls.Line()
+	ls.adjustAssign(4, ls.expList(&e), &e)
+	ls.adjustLocalVars(4) // control variables
+	fs.markToBeClosed()   // last control var. must be closed
+	fs.checkStack(3)      // extra space to call generator
+	ls.forBody(base, line, nvars-4, true)
+}
+
+func (ls *parser54) forStat(line int) {
+	// forstat -> FOR (fornum | forlist) END
+	var bl blockCnt54
+	fs := ls.fs
+	fs.enterBlock(&bl, true)     // scope for loop and control variables
+	ls.Next()                    // skip 'for'
+	varName := ls.strCheckName() // first variable name
+	switch ls.Token() {
+	case '=':
+		ls.forNum(varName, line)
+	case ',', TokenIn:
+		ls.forList(varName)
+	default:
+		ls.syntaxError("'=' or 'in' expected")
+	}
+	ls.checkMatch(TokenEnd, TokenFor, line)
+	fs.leaveBlock() // loop scope ('break' jumps to this point)
+}
+
+func (ls *parser54) testThenBlock(escapeList *int) {
+	// test_then_block -> [IF | ELSEIF] cond THEN block
+	var (
+		bl blockCnt54
+		fs = ls.fs
+		v  expDesc54
+		jf int // instruction to skip 'then' code (if condition is false)
+	)
+	ls.Next()   // skip IF or ELSEIF
+	ls.expr(&v) // read condition
+	ls.checkNext(TokenThen)
+	if ls.Token() == TokenBreak { // 'if x then break' ?
+		line := ls.Line()
+		fs.goIfFalse(&v)          // will jump if condition is true
+		ls.Next()                 // skip 'break'
+		fs.enterBlock(&bl, false) // must enter block before 'goto'
+		ls.newGotoEntry("break", line, v.t)
+		for ls.testNext(';') { // skip semicolons
+		}
+		if ls.blockFollow(false) { // jump is the entire block?
+			fs.leaveBlock()
+			return // and that is it
+		}
+		// must skip over 'then' part if condition is false
+		jf = fs.jump()
+	} else { // regular case (not a break)
+		fs.goIfTrue(&v) // skip over block if condition is false
+		fs.enterBlock(&bl, false)
+		jf = v.f
+	}
+	ls.statList() // 'then' part
+	fs.leaveBlock()
+	if ls.Token() == TokenElse || ls.Token() == TokenElseIf { // followed by 'else'/'elseif'?
+		fs.concat(escapeList, fs.jump()) // must jump over it
+	}
+	fs.patchToHere(jf)
+}
+
+func (ls *parser54) ifStat(line int) {
+	// ifstat -> IF cond THEN block {ELSEIF cond THEN block} [ELSE block] END
+	escapeList := noJump          // exit list for finished parts
+	ls.testThenBlock(&escapeList) // IF cond THEN block
+	for ls.Token() == TokenElseIf {
+		ls.testThenBlock(&escapeList) // ELSEIF cond THEN block
+	}
+	if ls.testNext(TokenElse) {
+		ls.block() // 'else' part
+	}
+	ls.checkMatch(TokenEnd, TokenIf, line)
+	ls.fs.patchToHere(escapeList) // patch escape list to 'if' end
+}
+
+func (ls *parser54) localFunc() {
+	var b expDesc54
+	fs := ls.fs
+	fvar := fs.nActVar                // function's variable index
+	ls.newLocalVar(ls.strCheckName()) // new local variable
+	ls.adjustLocalVars(1)             // enter its scope
+	ls.body(&b, false, ls.Line())     // function created in next register
+	// debug information will only see the variable after this point!
+	fs.localDebugInfo(fvar).Live = uint32(fs.pc)
+}
+
+// localAttribute reads the optional attribute of a local variable
+// ('<const>' or '<close>'), returning the kind of the variable.
+func (ls *parser54) localAttribute() byte {
+	if ls.testNext('<') {
+		attr := ls.strCheckName()
+		ls.checkNext('>')
+		switch attr {
+		case "const":
+			return binary.RDKCONST // read-only variable
+		case "close":
+			return binary.RDKTOCLOSE // to-be-closed variable
+		}
+		ls.semError(fmt.Sprintf("unknown attribute '%s'", attr))
+	}
+	return binary.VDKREG // regular variable
+}
+
+// checkToClose marks the variable at the given level (if any) as
+// to-be-closed.
+func (fs *funcState54) checkToClose(level int) {
+	if level != -1 { // is there a to-be-closed variable?
+		fs.markToBeClosed()
+		fs.codeABC(vm54.TBC, fs.regLevel(level), 0, 0)
+	}
+}
+
+func (ls *parser54) localStat() {
+	// stat -> LOCAL NAME ATTRIB { ',' NAME ATTRIB } ['=' explist]
+	var (
+		fs           = ls.fs
+		toClose      = -1 // index of to-be-closed variable (if any)
+		vidx         int
+		nvars, nexps int
+		e            expDesc54
+	)
+	for {
+		vidx = ls.newLocalVar(ls.strCheckName())
+		kind := ls.localAttribute()
+		fs.localVarDesc(vidx).kind = kind
+		if kind == binary.RDKTOCLOSE { // to-be-closed?
+			if toClose != -1 { // one already present?
+				ls.semError("multiple to-be-closed variables in local list")
+			}
+			toClose = fs.nActVar + nvars
+		}
+		nvars++
+		if !ls.testNext(',') {
+			break
+		}
+	}
+	if ls.testNext('=') {
+		nexps = ls.expList(&e)
+	} else {
+		e.k = vVoid
+		nexps = 0
+	}
+	// get last variable
+	if v := fs.localVarDesc(vidx); nvars == nexps && v.kind == binary.RDKCONST && fs.exp2Const(&e, &v.k) { // compile-time constant?
+		v.kind = binary.RDKCTC        // variable is a compile-time constant
+		ls.adjustLocalVars(nvars - 1) // exclude last variable
+		fs.nActVar++                  // but count it
+	} else {
+		ls.adjustAssign(nvars, nexps, &e)
+		ls.adjustLocalVars(nvars)
+	}
+	fs.checkToClose(toClose)
+}
+
+func (ls *parser54) funcName(v *expDesc54) (isMethod bool) {
+	// funcname -> NAME {fieldsel} [':' NAME]
+	ls.singleVar(v)
+	for ls.Token() == '.' {
+		ls.fieldSel(v)
+	}
+	if ls.Token() == ':' {
+		isMethod = true
+		ls.fieldSel(v)
+	}
+	return isMethod
+}
+
+func (ls *parser54) funcStat(line int) {
+	// funcstat -> FUNCTION funcname body
+	var v, b expDesc54
+	ls.Next() // skip FUNCTION
+	isMethod := ls.funcName(&v)
+	ls.body(&b, isMethod, line)
+	ls.checkReadonly(&v)
+	ls.fs.storeVar(&v, &b)
+	ls.fs.fixLine(line) // definition "happens" in the first line
+}
+
+func (ls *parser54) exprStat() {
+	// stat -> func | assignment
+	fs := ls.fs
+	v := &lhsAssign54{}
+	ls.suffixedExp(&v.v)
+	if ls.Token() == '=' || ls.Token() == ',' { // stat -> assignment ?
+		ls.assignment(v, 1)
+	} else { // stat -> func
+		ls.checkCondition(v.v.k == vCall, "syntax error")
+		fs.setInstr(v.v.info, fs.instr(v.v.info).WithC(1)) // call statement uses no results
+	}
+}
+
+func (ls *parser54) retStat() {
+	// stat -> RETURN [explist] [';']
+	var (
+		fs    = ls.fs
+		e     expDesc54
+		nret  int              // number of values being returned
+		first = fs.nVarStack() // first slot to be returned
+	)
+	if ls.blockFollow(true) || ls.Token() == ';' {
+		nret = 0 // return no values
+	} else {
+		nret = ls.expList(&e) // optional return values
+		if hasMultRet(e.k) {
+			fs.setMultRet(&e)
+			if e.k == vCall && nret == 1 && !fs.bl.insideTBC { // tail call?
+				fs.setInstr(e.info, fs.instr(e.info).WithCode(vm54.TAILCALL))
+			}
+			nret = multRet // return all values
+		} else {
+			if nret == 1 { // only one single value?
+				first = fs.exp2AnyReg(&e) // can use original slot
+			} else { // values must go to the stack
+				fs.exp2NextReg(&e) // values must go to the stack
+			}
+		}
+	}
+	fs.ret(first, nret)
+	ls.testNext(';') // skip optional semicolon
+}
+
+func (ls *parser54) statement() {
+	line := ls.Line() // may be needed for error messages
+	ls.enterLevel()
+	switch ls.Token() {
+	case ';': // stat -> ';' (empty statement)
+		ls.Next() // skip ';'
+	case TokenIf: // stat -> ifstat
+		ls.ifStat(line)
+	case TokenWhile: // stat -> whilestat
+		ls.whileStat(line)
+	case TokenDo: // stat -> DO block END
+		ls.Next() // skip DO
+		ls.block()
+		ls.checkMatch(TokenEnd, TokenDo, line)
+	case TokenFor: // stat -> forstat
+		ls.forStat(line)
+	case TokenRepeat: // stat -> repeatstat
+		ls.repeatStat(line)
+	case TokenFunction: // stat -> funcstat
+		ls.funcStat(line)
+	case TokenLocal: // stat -> localstat
+		ls.Next()                       // skip LOCAL
+		if ls.testNext(TokenFunction) { // local function?
+			ls.localFunc()
+		} else {
+			ls.localStat()
+		}
+	case TokenLabel: // stat -> label
+		ls.Next() // skip double colon
+		ls.labelStat(ls.strCheckName(), line)
+	case TokenReturn: // stat -> retstat
+		ls.Next() // skip RETURN
+		ls.retStat()
+	case TokenBreak: // stat -> breakstat
+		ls.breakStat()
+	case TokenGoto: // stat -> 'goto' NAME
+		ls.Next() // skip 'goto'
+		ls.gotoStat()
+	default: // stat -> func | assignment
+		ls.exprStat()
+	}
+	ls.fs.freeReg = ls.fs.nVarStack() // free registers
+	ls.leaveLevel()
+}
+
+// mainFunc compiles the main function, which is a regular vararg
+// function with an upvalue named LUA_ENV.
+func (ls *parser54) mainFunc(fs *funcState54) {
+	var bl blockCnt54
+	ls.openFunc(fs, &bl)
+	fs.setVararg(0) // main function is always declared vararg
+	// create and set environment upvalue
+	fs.f.UpValues = append(fs.f.UpValues, binary.UpValue{InStack: 1, Index: 0, Kind: binary.VDKREG})
+	fs.f.UpNames = append(fs.f.UpNames, ls.envn)
+	ls.Next()     // read first token
+	ls.statList() // parse main body
+	ls.check(TokenEOS)
+	ls.closeFunc()
+}
@@ -0,0 +1,616 @@
+package syntax
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"unicode/utf8"
+)
+
+// eoz marks the end of the input stream.
+const eoz = -1
+
+// maxInt is the limit on the number of lines in a chunk.
+const maxInt = math.MaxInt32
+
+// Position describes a location in the source text.
+type Position struct {
+	Offset int // byte offset, starting at 0
+	Line   int // line number, starting at 1
+	Column int // column number, starting at 1 (in bytes)
+}
+
+// Comment is a comment found between two tokens.
+type Comment struct {
+	Text string   // comment text, including the leading "--"
+	Pos  Position // position of the first character
+	End  Position // position just after the last character
+}
+
+// token holds a scanned token and its semantic value.
+type token struct {
+	tok      Token     // token kind
+	text     string    // raw source text for names, strings and numerals
+	sval     string    // value of names and strings
+	ival     int64     // value of integer numerals
+	fval     float64   // value of float numerals
+	pos      Position  // position of the first character
+	end      Position  // position just after the last character
+	comments []Comment // comments preceding the token (if kept)
+}
+
+// Scanner tokenizes Lua source text.
+//
+// Scanner follows the behavior of the reference implementation's lexer
+// (llex.c), including its error messages.
+type Scanner struct {
+	source    string    // chunk name
+	src       []byte    // source text
+	off       int       // offset of the character after current
+	coff      int       // offset of the current character
+	lineStart int       // offset of the first character of the line
+	current   int       // current character (or eoz)
+	line      int       // input line counter
+	lastLine  int       // line of last token 'consumed'
+	buf       []byte    // buffer for tokens
+	tok       token     // current token
+	ahead     token     // lookahead token (valid if peeked)
+	peeked    bool      // whether ahead holds a token
+	start     Position  // position of the token being scanned
+	keep      bool      // whether comments are kept
+	comments  []Comment // comments preceding the token being scanned
+	scanning  bool      // whether a token is being scanned
+}
+
+// NewScanner returns a Scanner reading the source text src of the chunk
+// named source. The scanner is positioned before the first token.
+func NewScanner(source string, src []byte) *Scanner {
+	s := &Scanner{source: source, src: src, line: 1, lastLine: 1}
+	s.advance()
+	return s
+}
+
+// Next advances to the next token.
+func (s *Scanner) Next() {
+	s.lastLine = s.line
+	if s.peeked {
+		s.tok, s.peeked = s.ahead, false
+		return
+	}
+	s.tok = s.scan()
+}
+
+// Lookahead scans and returns the token after the current one
+// without consuming it.
+func (s *Scanner) Lookahead() Token {
+	if !s.peeked {
+		s.ahead, s.peeked = s.scan(), true
+	}
+	return s.ahead.tok
+}
+
+// KeepComments instructs the scanner to record comments; they are
+// reported by Comments for the token that follows them.
+func (s *Scanner) KeepComments() { s.keep = true }
+
+// Token returns the current token.
+func (s *Scanner) Token() Token { return s.tok.tok }
+
+// Value returns the semantic value of the current token: a string
+// for names and strings, an int64 for integers and a float64 for
+// floats; it returns nil for any other token.
+func (s *Scanner) Value() interface{} {
+	switch s.tok.tok {
+	case TokenName, TokenString:
+		return s.tok.sval
+	case TokenInt:
+		return s.tok.ival
+	case TokenFloat:
+		return s.tok.fval
+	}
+	return nil
+}
+
+// Pos returns the position of the first character of the current token.
+func (s *Scanner) Pos() Position { return s.tok.pos }
+
+// End returns the position just after the current token.
+func (s *Scanner) End() Position { return s.tok.end }
+
+// Comments returns the comments preceding the current token. It
+// returns nil unless comments are kept.
+func (s *Scanner) Comments() []Comment { return s.tok.comments }
+
+// Text returns the source text of the current token.
+func (s *Scanner) Text() string {
+	switch s.tok.tok {
+	case TokenName, TokenString, TokenFloat, TokenInt:
+		return s.tok.text
+	}
+	if s.tok.tok < firstReserved {
+		return string(rune(s.tok.tok))
+	}
+	return tokens[s.tok.tok-firstReserved]
+}
+
+// Line returns the current input line.
+func (s *Scanner) Line() int { return s.line }
+
+// LastLine returns the line of the last token consumed.
+func (s *Scanner) LastLine() int { return s.lastLine }
+
+// Source returns the chunk name being scanned.
+func (s *Scanner) Source() string { return s.source }
+
+// Errorf raises a syntax error at the current line.
+//
+// If tok is non-zero, the error is suffixed with "near" and
+// the text of the token. The error is recovered by Catch.
+func (s *Scanner) Errorf(tok Token, format string, args ...interface{}) {
+	err := &Error{
+		Chunk:   s.source,
+		Line:    s.line,
+		Message: fmt.Sprintf(format, args...),
+	}
+	if pos := s.tok.pos; s.scanning {
+		err.Column = s.start.Column
+	} else if pos.Line == s.line {
+		err.Column = pos.Column
+	}
+	if tok != 0 {
+		err.Token = s.txtToken(tok)
+	}
+	panic(bailout{err})
+}
+
+// txtToken returns the text of tok for error messages.
+func (s *Scanner) txtToken(tok Token) string {
+	switch tok {
+	case TokenName, TokenString, TokenFloat, TokenInt:
+		return fmt.Sprintf("'%s'", s.buf)
+	default:
+		return tok.String()
+	}
+}
+
+// position returns the position of the current character.
+func (s *Scanner) position() Position {
+	return Position{Offset: s.coff, Line: s.line, Column: s.coff - s.lineStart + 1}
+}
+
+// advance reads the next character into current.
+func (s *Scanner) advance() {
+	s.coff = s.off
+	if s.off < len(s.src) {
+		s.current = int(s.src[s.off])
+		s.off++
+		return
+	}
+	s.current = eoz
+}
+
+// save appends c to the token buffer.
+func (s *Scanner) save(c int) { s.buf = append(s.buf, byte(c)) }
+
+// saveAndNext saves the current character and advances.
+func (s *Scanner) saveAndNext() {
+	s.save(s.current)
+	s.advance()
+}
+
+// checkNext advances if the current character is c.
+func (s *Scanner) checkNext(c int) bool {
+	if s.current == c {
+		s.advance()
+		return true
+	}
+	return false
+}
+
+// checkNext2 saves and advances if the current character is
+// either of the two characters in set.
+func (s *Scanner) checkNext2(set string) bool {
+	if s.current == int(set[0]) || s.current == int(set[1]) {
+		s.saveAndNext()
+		return true
+	}
+	return false
+}
+
+// isNewline reports whether the current character is a line break.
+func (s *Scanner) isNewline() bool { return s.current == '\n' || s.current == '\r' }
+
+// incLine skips a line break ('\n', '\r', "\n\r" or "\r\n").
+func (s *Scanner) incLine() {
+	old := s.current
+	s.advance() // skip '\n' or '\r'
+	if s.isNewline() && s.current != old {
+		s.advance() // skip '\n\r' or '\r\n'
+	}
+	if s.line++; s.line >= maxInt {
+		s.Errorf(0, "chunk has too many lines")
+	}
+	s.lineStart = s.coff
+}
+
+// scan returns the next token from the input.
+func (s *Scanner) scan() token {
+	s.comments, s.scanning = nil, true
+	t := s.lex()
+	s.scanning = false
+	t.pos, t.end, t.comments = s.start, s.position(), s.comments
+	if t.tok == TokenString { // keep the source text, not the buffer
+		t.text = string(s.src[t.pos.Offset:t.end.Offset])
+	}
+	return t
+}
+
+// lex reads the next token, skipping spaces and comments.
+func (s *Scanner) lex() token {
+	s.buf = s.buf[:0]
+	for {
+		s.start = s.position()
+		switch c := s.current; c {
+		case '\n', '\r':
+			s.incLine()
+		case ' ', '\f', '\t', '\v':
+			s.advance()
+		case '-':
+			if s.advance(); s.current != '-' {
+				return token{tok: '-'}
+			}
+			// else is a comment
+			s.advance()
+			if s.current == '[' {
+				if sep := s.skipSep(); sep >= 0 {
+					s.readLongString(sep, false) // skip long comment
+					s.buf = s.buf[:0]
+					s.comment()
+					break
+				}
+			}
+			// else short comment
+			for !s.isNewline() && s.current != eoz {
+				s.advance()
+			}
+			s.buf = s.buf[:0]
+			s.comment()
+		case '[':
+			sep := s.skipSep()
+			if sep >= 0 {
+				str := s.readLongString(sep, true)
+				return token{tok: TokenString, text: string(s.buf), sval: str}
+			} else if sep != -1 {
+				s.Errorf(TokenString, "invalid long string delimiter")
+			}
+			s.buf = s.buf[:0]
+			return token{tok: '['}
+		case '=':
+			if s.advance(); s.checkNext('=') {
+				return token{tok: TokenEq}
+			}
+			return token{tok: '='}
+		case '<':
+			if s.advance(); s.checkNext('=') {
+				return token{tok: TokenLE}
+			} else if s.checkNext('<') {
+				return token{tok: TokenShl}
+			}
+			return token{tok: '<'}
+		case '>':
+			if s.advance(); s.checkNext('=') {
+				return token{tok: TokenGE}
+			} else if s.checkNext('>') {
+				return token{tok: TokenShr}
+			}
+			return token{tok: '>'}
+		case '/':
+			if s.advance(); s.checkNext('/') {
+				return token{tok: TokenIDiv}
+			}
+			return token{tok: '/'}
+		case '~':
+			if s.advance(); s.checkNext('=') {
+				return token{tok: TokenNE}
+			}
+			return token{tok: '~'}
+		case ':':
+			if s.advance(); s.checkNext(':') {
+				return token{tok: TokenLabel}
+			}
+			return token{tok: ':'}
+		case '"', '\'':
+			str := s.readString(c)
+			return token{tok: TokenString, text: string(s.buf), sval: str}
+		case '.':
+			if s.saveAndNext(); s.checkNext('.') {
+				if s.checkNext('.') {
+					return token{tok: TokenDots}
+				}
+				return token{tok: TokenConcat}
+			} else if !isDigit(s.current) {
+				return token{tok: '.'}
+			}
+			return s.readNumeral()
+		case '0', '1', '2', '3', '4', '5', '6', '7', '8', '9':
+			return s.readNumeral()
+		case eoz:
+			return token{tok: TokenEOS}
+		default:
+			if isAlpha(c) { // identifier or reserved word?
+				for isAlnum(s.current) {
+					s.saveAndNext()
+				}
+				name := string(s.buf)
+				if tok, ok := reserved[name]; ok {
+					return token{tok: tok, text: name}
+				}
+				return token{tok: TokenName, text: name, sval: name}
+			}
+			// single-char tokens (+ - / ...)
+			s.advance()
+			return token{tok: Token(c)}
+		}
+	}
+}
+
+// comment records the comment just skipped if comments are kept.
+func (s *Scanner) comment() {
+	if s.keep {
+		s.comments = append(s.comments, Comment{
+			Text: string(s.src[s.start.Offset:s.coff]),
+			Pos:  s.start,
+			End:  s.position(),
+		})
+	}
+}
+
+// readNumeral reads a numeral; the reference implementation is
+// deliberately loose here and leaves validation to the number
+// conversion functions.
+func (s *Scanner) readNumeral() token {
+	var (
+		expo  = "Ee"
+		first = s.current
+	)
+	s.saveAndNext()
+	if first == '0' && s.checkNext2("xX") { // hexadecimal?
+		expo = "Pp"
+	}
+	for {
+		if s.checkNext2(expo) { // exponent part?
+			s.checkNext2("-+") // optional exponent sign
+		}
+		if isHexDigit(s.current) || s.current == '.' {
+			s.saveAndNext()
+		} else {
+			break
+		}
+	}
+	text := string(s.buf)
+	if i64, ok := StrToI64(text); ok {
+		return token{tok: TokenInt, text: text, ival: i64}
+	}
+	if f64, ok := StrToF64(text); ok {
+		return token{tok: TokenFloat, text: text, fval: f64}
+	}
+	s.Errorf(TokenFloat, "malformed number")
+	panic("unreachable")
+}
+
+// skipSep reads a sequence '[=*[' or ']=*]', leaving the last bracket.
+// If sequence is well formed, it returns its number of '='s; otherwise
+// it returns a negative number (-1 iff there are no '='s after the
+// initial bracket).
+func (s *Scanner) skipSep() int {
+	count, c := 0, s.current
+	s.saveAndNext()
+	for s.current == '=' {
+		s.saveAndNext()
+		count++
+	}
+	if s.current == c {
+		return count
+	}
+	return -count - 1
+}
+
+// readLongString reads a long string or comment with the given
+// separator level.
+func (s *Scanner) readLongString(sep int, isString bool) string {
+	line := s.line     // initial line (for error message)
+	s.saveAndNext()    // skip 2nd '['
+	if s.isNewline() { // string starts with a newline?
+		s.incLine() // skip it
+	}
+	for {
+		switch s.current {
+		case eoz: // error
+			what := "comment"
+			if isString {
+				what = "string"
+			}
+			s.Errorf(TokenEOS, "unfinished long %s (starting at line %d)", what, line)
+		case ']':
+			if s.skipSep() == sep {
+				s.saveAndNext() // skip 2nd ']'
+				if !isString {
+					return ""
+				}
+				return string(s.buf[2+sep : len(s.buf)-(2+sep)])
+			}
+		case '\n', '\r':
+			s.save('\n')
+			s.incLine()
+			if !isString {
+				s.buf = s.buf[:0] // avoid wasting space
+			}
+		default:
+			if isString {
+				s.saveAndNext()
+			} else {
+				s.advance()
+			}
+		}
+	}
+}
+
+// escCheck raises an escape sequence error unless cond holds.
+func (s *Scanner) escCheck(cond bool, msg string) {
+	if !cond {
+		if s.current != eoz {
+			s.saveAndNext() // add current to buffer for error message
+		}
+		s.Errorf(TokenString, "%s", msg)
+	}
+}
+
+// hexValue reads a hexadecimal digit of an escape sequence.
+func (s *Scanner) hexValue() int {
+	s.saveAndNext()
+	s.escCheck(isHexDigit(s.current), "hexadecimal digit expected")
+	return hexDigit(s.current)
+}
+
+// readHexEsc reads an escape sequence '\xXX'.
+func (s *Scanner) readHexEsc() int {
+	r := s.hexValue()
+	r = r<<4 + s.hexValue()
+	s.buf = s.buf[:len(s.buf)-2] // remove saved chars from buffer
+	return r
+}
+
+// readUTF8Esc reads an escape sequence '\u{XXX}'.
+func (s *Scanner) readUTF8Esc() uint64 {
+	i := 4          // chars to be removed: '\', 'u', '{', and first digit
+	s.saveAndNext() // skip 'u'
+	s.escCheck(s.current == '{', "missing '{'")
+	r := uint64(s.hexValue()) // must have at least one digit
+	for s.saveAndNext(); isHexDigit(s.current); s.saveAndNext() {
+		i++
+		r = r<<4 + uint64(hexDigit(s.current))
+		s.escCheck(r <= 0x7FFFFFFF, "UTF-8 value too large")
+	}
+	s.escCheck(s.current == '}', "missing '}'")
+	s.advance()                  // skip '}'
+	s.buf = s.buf[:len(s.buf)-i] // remove saved chars from buffer
+	return r
+}
+
+// readDecEsc reads an escape sequence '\ddd'.
+func (s *Scanner) readDecEsc() int {
+	r, i := 0, 0
+	for ; i < 3 && isDigit(s.current); i++ { // read up to 3 digits
+		r = 10*r + s.current - '0'
+		s.saveAndNext()
+	}
+	s.escCheck(r <= math.MaxUint8, "decimal escape too large")
+	s.buf = s.buf[:len(s.buf)-i] // remove read digits from buffer
+	return r
+}
+
+// readString reads a quoted string delimited by del.
+func (s *Scanner) readString(del int) string {
+	s.saveAndNext() // keep delimiters (for error messages)
+	for s.current != del {
+		switch s.current {
+		case eoz:
+			s.Errorf(TokenEOS, "unfinished string")
+		case '\n', '\r':
+			s.Errorf(TokenString, "unfinished string")
+		case '\\': // escape sequences
+			var c int
+			s.saveAndNext() // keep '\\' for error messages
+			switch s.current {
+			case 'a':
+				c = '\a'
+			case 'b':
+				c = '\b'
+			case 'f':
+				c = '\f'
+			case 'n':
+				c = '\n'
+			case 'r':
+				c = '\r'
+			case 't':
+				c = '\t'
+			case 'v':
+				c = '\v'
+			case 'x':
+				c = s.readHexEsc()
+			case 'u':
+				r := s.readUTF8Esc() // also removes the '\\'
+				s.buf = utf8Esc(s.buf, r)
+				continue
+			case '\n', '\r':
+				s.incLine()
+				s.buf[len(s.buf)-1] = '\n'
+				continue
+			case '\\', '"', '\'':
+				c = s.current
+			case eoz:
+				continue // will raise an error next loop
+			case 'z': // zap following span of spaces
+				s.buf = s.buf[:len(s.buf)-1] // remove '\\'
+				s.advance()                  // skip the 'z'
+				for isSpace(s.current) {
+					if s.isNewline() {
+						s.incLine()
+					} else {
+						s.advance()
+					}
+				}
+				continue
+			default:
+				s.escCheck(isDigit(s.current), "invalid escape sequence")
+				c = s.readDecEsc() // digital escape '\ddd'
+				s.buf[len(s.buf)-1] = byte(c)
+				continue
+			}
+			s.advance()
+			s.buf[len(s.buf)-1] = byte(c) // replace '\\' with the escaped char
+		default:
+			s.saveAndNext()
+		}
+	}
+	s.saveAndNext() // skip delimiter
+	return string(s.buf[1 : len(s.buf)-1])
+}
+
+// utf8Esc appends the (extended) UTF-8 encoding of x to buf; values
+// up to 0x7FFFFFFF are encoded using the original 6-byte scheme.
+func utf8Esc(buf []byte, x uint64) []byte {
+	if x < 0x80 || (x <= utf8.MaxRune && (x < 0xD800 || x > 0xDFFF)) {
+		return utf8.AppendRune(buf, rune(x))
+	}
+	var (
+		tmp [8]byte
+		n   = 1
+		mfb = uint64(0x3f) // maximum that fits in first byte
+	)
+	for x > mfb { // need continuation bytes?
+		tmp[8-n] = byte(0x80 | (x & 0x3f)) // add continuation byte
+		n++
+		x >>= 6   // remove added bits
+		mfb >>= 1 // now there is one less bit available in first byte
+	}
+	tmp[8-n] = byte((^mfb << 1) | x) // add first byte
+	return append(buf, tmp[8-n:]...)
+}
+
+func isDigit(c int) bool { return '0' <= c && c <= '9' }
+
+func isAlpha(c int) bool { return c == '_' || 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' }
+
+func isAlnum(c int) bool { return isAlpha(c) || isDigit(c) }
+
+func isSpace(c int) bool { return c != eoz && strings.IndexByte(" \t\n\v\f\r", byte(c)) >= 0 }
+
+func isHexDigit(c int) bool { return isDigit(c) || 'a' <= c && c <= 'f' || 'A' <= c && c <= 'F' }
+
+func hexDigit(c int) int {
+	if isDigit(c) {
+		return c - '0'
+	}
+	return (c | 0x20) - 'a' + 10
+}
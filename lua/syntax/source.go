@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"io"
 	"io/ioutil"
+	"strings"
 )
 
 // Source opens and reads the token source from source.
@@ -28,3 +29,42 @@ func Source(filename string, source interface{}) (data []byte, err error) {
 	}
 	return data, nil
 }
+
+// ChunkID returns a printable version of the chunk name source
+// for use in messages.
+//
+// Sources starting with '=' are used verbatim, sources starting
+// with '@' are file names, and everything else is treated as the
+// chunk's source text and formatted as [string "source"].
+func ChunkID(source string) string {
+	const (
+		idSize = 60 // LUA_IDSIZE
+		pre    = `[string "`
+		rets   = "..."
+		pos    = `"]`
+	)
+	if len(source) > 0 {
+		switch source[0] {
+		case '=': // 'literal' source
+			if source = source[1:]; len(source) >= idSize {
+				source = source[:idSize-1]
+			}
+			return source
+		case '@': // file name
+			if source = source[1:]; len(source) >= idSize {
+				source = rets + source[len(source)-(idSize-len(rets)-1):]
+			}
+			return source
+		}
+	}
+	max := idSize - len(pre+rets+pos) - 1
+	if nl := strings.IndexByte(source, '\n'); len(source) < max && nl == -1 {
+		return pre + source + pos
+	} else if nl != -1 {
+		source = source[:nl]
+	}
+	if len(source) > max {
+		source = source[:max]
+	}
+	return pre + source + rets + pos
+}
@@ -66,6 +66,9 @@ func parseFloat(str string) (float64, bool) {
 		return -f, ok
 	}
 	f, err := strconv.ParseFloat(str, 64)
+	if err, ok := err.(*strconv.NumError); ok && err.Err == strconv.ErrRange {
+		return f, true // overflow yields ±HUGE_VAL, as strtod does
+	}
 	return f, err == nil
 }
 
@@ -0,0 +1,293 @@
+package syntax
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/Azure/golua/lua/binary"
+	"github.com/Azure/golua/lua/vm"
+	"github.com/Azure/golua/lua/vm54"
+)
+
+func TestCompile(t *testing.T) {
+	var tests = []struct {
+		source string
+		code   []string
+	}{
+		{
+			source: "local a = 1 + 2",
+			code: []string{
+				"LOADK A=0 BX=0",
+				"RETURN A=0 B=1 C=0",
+			},
+		},
+		{
+			source: "local a, b; a = not b",
+			code: []string{
+				"LOADNIL A=0 B=1 C=0",
+				"NOT A=0 B=1 C=0",
+				"RETURN A=0 B=1 C=0",
+			},
+		},
+		{
+			source: "return x.y",
+			code: []string{
+				"GETTABUP A=0 B=0 C=256",
+				"GETTABLE A=0 B=0 C=257",
+				"RETURN A=0 B=2 C=0",
+				"RETURN A=0 B=1 C=0",
+			},
+		},
+	}
+	for _, test := range tests {
+		proto, err := Compile("=test", []byte(test.source))
+		if err != nil {
+			t.Errorf("compile %q: %v", test.source, err)
+			continue
+		}
+		if len(proto.Code) != len(test.code) {
+			t.Errorf("compile %q: got %d instructions, want %d", test.source, len(proto.Code), len(test.code))
+			continue
+		}
+		for pc, code := range proto.Code {
+			if got := vm.Instr(code).String(); got != test.code[pc] {
+				t.Errorf("compile %q: pc %d: got %q, want %q", test.source, pc, got, test.code[pc])
+			}
+		}
+	}
+}
+
+func TestOptimize(t *testing.T) {
+	var (
+		abc  = vm.MakeABC
+		asbx = vm.MakeAsBx
+		k    = vm.RKAsK
+	)
+	var tests = []struct {
+		name   string
+		code   []vm.Instr
+		consts []interface{}
+		want   []string
+	}{
+		{
+			name:   "fold",
+			code:   []vm.Instr{abc(vm.ADD, 0, k(0), k(1)), abc(vm.IDIV, 1, k(0), k(2)), abc(vm.RETURN, 0, 3, 0)},
+			consts: []interface{}{int64(1), int64(2), int64(0)},
+			want:   []string{"LOADK A=0 BX=3", "IDIV A=1 B=256 C=258", "RETURN A=0 B=3 C=0"},
+		},
+		{
+			name: "thread",
+			code: []vm.Instr{
+				abc(vm.TEST, 0, 0, 0),
+				asbx(vm.JMP, 0, 1),
+				abc(vm.LOADK, 1, 0, 0),
+				asbx(vm.JMP, 0, 1),
+				abc(vm.LOADK, 1, 0, 0),
+				asbx(vm.JMP, 2, 0),
+				abc(vm.RETURN, 1, 2, 0),
+			},
+			want: []string{"TEST A=0 B=0 C=0", "JMP A=2 SBX=2", "LOADK A=1 BX=0", "JMP A=2 SBX=0", "RETURN A=1 B=2 C=0"},
+		},
+		{
+			name: "loadnil",
+			code: []vm.Instr{
+				abc(vm.LOADNIL, 0, 2, 0),
+				abc(vm.LOADK, 0, 0, 0),
+				abc(vm.MOVE, 2, 1, 0),
+				abc(vm.LOADNIL, 3, 0, 0),
+				abc(vm.RETURN, 0, 3, 0),
+				abc(vm.RETURN, 0, 1, 0),
+			},
+			want: []string{"LOADNIL A=1 B=0 C=0", "LOADK A=0 BX=0", "MOVE A=2 B=1 C=0", "RETURN A=0 B=3 C=0"},
+		},
+	}
+	for _, test := range tests {
+		proto := &binary.Prototype{Consts: test.consts}
+		for pc, instr := range test.code {
+			proto.Code = append(proto.Code, uint32(instr))
+			proto.PcLnTab = append(proto.PcLnTab, uint32(pc+1))
+		}
+		Optimize(proto)
+		var got []string
+		for _, code := range proto.Code {
+			got = append(got, vm.Instr(code).String())
+		}
+		if strings.Join(got, "; ") != strings.Join(test.want, "; ") {
+			t.Errorf("%s: got %q, want %q", test.name, got, test.want)
+		}
+		if len(proto.PcLnTab) != len(proto.Code) {
+			t.Errorf("%s: got %d lines for %d instructions", test.name, len(proto.PcLnTab), len(proto.Code))
+		}
+	}
+
+	// Line information and local variables follow the code.
+	proto, err := Compile("=test", []byte("local a\na = 1\nlocal b = a\nreturn b"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	Optimize(proto)
+	var lines []uint32
+	for _, line := range proto.PcLnTab {
+		lines = append(lines, line)
+	}
+	if got := fmt.Sprint(lines); got != "[2 3 4]" {
+		t.Errorf("lines: got %s, want [2 3 4]", got)
+	}
+	if got := fmt.Sprint(proto.Locals); got != "[{a 0 3} {b 2 3}]" {
+		t.Errorf("locals: got %s, want [{a 0 3} {b 2 3}]", got)
+	}
+}
+
+func TestCompile54(t *testing.T) {
+	var tests = []struct {
+		source string
+		code   []string
+	}{
+		{
+			source: "local a <const> = 10; return a + 1",
+			code: []string{
+				"VARARGPREP A=0 B=0 C=0 K=0",
+				"LOADI A=0 SBX=11",
+				"RETURN A=0 B=2 C=1 K=0",
+				"RETURN A=0 B=1 C=1 K=0",
+			},
+		},
+		{
+			source: "local a; return a + 1",
+			code: []string{
+				"VARARGPREP A=0 B=0 C=0 K=0",
+				"LOADNIL A=0 B=0 C=0 K=0",
+				"ADDI A=1 B=0 C=128 K=0",
+				"MMBINI A=0 B=128 C=6 K=0",
+				"RETURN A=1 B=2 C=1 K=0",
+				"RETURN A=1 B=1 C=1 K=0",
+			},
+		},
+		{
+			source: "for i = 1, 3 do end",
+			code: []string{
+				"VARARGPREP A=0 B=0 C=0 K=0",
+				"LOADI A=0 SBX=1",
+				"LOADI A=1 SBX=3",
+				"LOADI A=2 SBX=1",
+				"FORPREP A=0 BX=0",
+				"FORLOOP A=0 BX=1",
+				"RETURN A=0 B=1 C=1 K=0",
+			},
+		},
+		{
+			source: "local x <close> = f()",
+			code: []string{
+				"VARARGPREP A=0 B=0 C=0 K=0",
+				"GETTABUP A=0 B=0 C=0 K=0",
+				"CALL A=0 B=1 C=2 K=0",
+				"TBC A=0 B=0 C=0 K=0",
+				"RETURN A=1 B=1 C=1 K=1",
+			},
+		},
+	}
+	for _, test := range tests {
+		proto, err := Compile54("=test", []byte(test.source))
+		if err != nil {
+			t.Errorf("compile %q: %v", test.source, err)
+			continue
+		}
+		if len(proto.Code) != len(test.code) {
+			t.Errorf("compile %q: got %d instructions, want %d", test.source, len(proto.Code), len(test.code))
+			continue
+		}
+		for pc, code := range proto.Code {
+			if got := vm54.Instr(code).String(); got != test.code[pc] {
+				t.Errorf("compile %q: pc %d: got %q, want %q", test.source, pc, got, test.code[pc])
+			}
+		}
+	}
+}
+
+func TestCompileErrors(t *testing.T) {
+	var tests = []struct {
+		source string
+		errmsg string
+	}{
+		{"x = = 1", "test:1: unexpected symbol near '='"},
+		{"local a = 'abc", "test:1: unfinished string near <eof>"},
+		{"\n\nfor i = 1 do end", "test:3: ',' expected near 'do'"},
+		{"goto done", "test:1: no visible label 'done' for <goto> at line 1"},
+		{"break", "test:1: <break> at line 1 not inside a loop"},
+		{"return 0x", "test:1: malformed number near '0x'"},
+	}
+	for _, test := range tests {
+		_, err := Compile("=test", []byte(test.source))
+		if err == nil {
+			t.Errorf("compile %q: expected error", test.source)
+			continue
+		}
+		if err.Error() != test.errmsg {
+			t.Errorf("compile %q: got %q, want %q", test.source, err, test.errmsg)
+		}
+	}
+}
+
+func TestChunkID(t *testing.T) {
+	var tests = []struct {
+		source string
+		chunk  string
+	}{
+		{"=stdin", "stdin"},
+		{"@main.lua", "main.lua"},
+		{"return 1", `[string "return 1"]`},
+		{"x = 1\ny = 2", `[string "x = 1..."]`},
+	}
+	for _, test := range tests {
+		if got := ChunkID(test.source); got != test.chunk {
+			t.Errorf("ChunkID(%q): got %q, want %q", test.source, got, test.chunk)
+		}
+	}
+}
+
+func TestErrorPosition(t *testing.T) {
+	var tests = []struct {
+		source string
+		err    Error
+	}{
+		{"x = = 1", Error{Chunk: "x = = 1", Line: 1, Column: 5, Token: "'='", Message: "unexpected symbol"}},
+		{"local t = {}\n  t.x y = 1", Error{Chunk: "local t = {}\n  t.x y = 1", Line: 2, Column: 7, Token: "'y'", Message: "syntax error"}},
+		{"s = 'abc\\q'", Error{Chunk: "s = 'abc\\q'", Line: 1, Column: 5, Token: "''abc\\q'", Message: "invalid escape sequence"}},
+		{"goto l", Error{Chunk: "goto l", Line: 1, Column: 7, Message: "no visible label 'l' for <goto> at line 1"}},
+	}
+	for _, test := range tests {
+		_, err := Compile(test.source, []byte(test.source))
+		var e *Error
+		if !errors.As(err, &e) {
+			t.Errorf("compile %q: expected *Error, got %v", test.source, err)
+			continue
+		}
+		if *e != test.err {
+			t.Errorf("compile %q: got %+v, want %+v", test.source, *e, test.err)
+		}
+	}
+}
+
+func TestCompile54Errors(t *testing.T) {
+	var tests = []struct {
+		source string
+		errmsg string
+	}{
+		{"local a <const> = 1; a = 2", "test:1: attempt to assign to const variable 'a'"},
+		{"local a <foo> = 1", "test:1: unknown attribute 'foo'"},
+		{"local a <close>, b <close> = 1, 2", "test:1: multiple to-be-closed variables in local list"},
+		{"break", "test:1: break outside a loop at line 1"},
+	}
+	for _, test := range tests {
+		_, err := Compile54("=test", []byte(test.source))
+		if err == nil {
+			t.Errorf("compile %q: expected error", test.source)
+			continue
+		}
+		if err.Error() != test.errmsg {
+			t.Errorf("compile %q: got %q, want %q", test.source, err, test.errmsg)
+		}
+	}
+}
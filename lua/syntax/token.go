@@ -0,0 +1,100 @@
+package syntax
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// Token is a lexical token of the Lua language.
+//
+// Single-character tokens are represented by their own character
+// code; all other tokens start at firstReserved.
+type Token int
+
+// firstReserved is the first token code that is not a single character.
+const firstReserved = 257
+
+const (
+	// reserved words
+	TokenAnd Token = iota + firstReserved
+	TokenBreak
+	TokenDo
+	TokenElse
+	TokenElseIf
+	TokenEnd
+	TokenFalse
+	TokenFor
+	TokenFunction
+	TokenGoto
+	TokenIf
+	TokenIn
+	TokenLocal
+	TokenNil
+	TokenNot
+	TokenOr
+	TokenRepeat
+	TokenReturn
+	TokenThen
+	TokenTrue
+	TokenUntil
+	TokenWhile
+
+	// other terminal symbols
+	TokenIDiv   // '//'
+	TokenConcat // '..'
+	TokenDots   // '...'
+	TokenEq     // '=='
+	TokenGE     // '>='
+	TokenLE     // '<='
+	TokenNE     // '~='
+	TokenShl    // '<<'
+	TokenShr    // '>>'
+	TokenLabel  // '::'
+	TokenEOS    // <eof>
+	TokenFloat  // <number>
+	TokenInt    // <integer>
+	TokenName   // <name>
+	TokenString // <string>
+)
+
+// numReserved is the number of reserved words.
+const numReserved = int(TokenWhile-firstReserved) + 1
+
+var tokens = [...]string{
+	"and", "break", "do", "else", "elseif",
+	"end", "false", "for", "function", "goto", "if",
+	"in", "local", "nil", "not", "or", "repeat",
+	"return", "then", "true", "until", "while",
+	"//", "..", "...", "==", ">=", "<=", "~=",
+	"<<", ">>", "::", "<eof>",
+	"<number>", "<integer>", "<name>", "<string>",
+}
+
+// reserved maps each reserved word to its token.
+var reserved = func() map[string]Token {
+	words := make(map[string]Token, numReserved)
+	for i := 0; i < numReserved; i++ {
+		words[tokens[i]] = Token(i + firstReserved)
+	}
+	return words
+}()
+
+// IsReserved reports whether name is a reserved word.
+func IsReserved(name string) bool {
+	_, ok := reserved[name]
+	return ok
+}
+
+// String returns the token as it appears in error messages.
+func (tok Token) String() string {
+	if tok < firstReserved {
+		if strconv.IsPrint(rune(tok)) && tok < 0x80 {
+			return fmt.Sprintf("'%c'", rune(tok))
+		}
+		return fmt.Sprintf("'<\\%d>'", int(tok))
+	}
+	if tok < TokenEOS {
+		return fmt.Sprintf("'%s'", tokens[tok-firstReserved])
+	}
+	return tokens[tok-firstReserved]
+}
@@ -16,6 +16,7 @@ package lua
 import (
 	"fmt"
 	"math"
+	"math/bits"
 )
 
 // Implementation of tables (aka arrays, objects, or hash tables). Tables keep
@@ -32,144 +33,362 @@ type table struct {
 	state *State
 
 	// table state
-	hash map[Value]Value
-	list []Value
-	meta *table
+	hash  map[Value]int // index of the node of each key
+	nodes []node
+	list  []value
+	meta  *table
 
-	// iterator state
-	iter []Value
-	keys map[Value]int
+	// hsize is the size of the hash part: it is rehashed, and the keys moved
+	// between the parts, when a new key is added to it while it is full.
+	hsize int
+
+	// version is incremented on each change of the hash part or of the
+	// metatable, which invalidates the inline caches (see fieldCache).
+	version uint64
+
+	// weak holds the entries of the table instead of its array and hash
+	// parts if its metatable makes it weak (see setMode); the values of
+	// the weak-keyed tables it is a key of are held in eph.
+	weak *weakTable
+	eph  ephemerons
+
+	// fin is set while the table is marked for finalization, by a __gc
+	// field of its metatable (see checkFinalizer).
+	fin bool
+}
+
+// node is a key/value pair of the hash part. Nodes are kept in the order their
+// keys were added, which is the order of traversals (see next); the node of a
+// key set to nil stays, dead, until the next rehash, so that a traversal may
+// clear fields and resume from them.
+type node struct {
+	key Value
+	val value
 }
 
 func (x *table) String() string { return fmt.Sprintf("table: %p", x) }
-func (x *table) Length() int    { return len(x.list) }
+func (x *table) Length() int    { return x.length() }
 func (x *table) Type() Type     { return TableType }
 
 func (x *table) ForEach(fn func(Value, Value)) {
-	if x.list != nil {
-		for i, v := range x.list {
-			fn(Int(i), v)
+	if w := x.weak; w != nil {
+		for i := range w.slots {
+			if k, v := w.load(i); k != nil {
+				fn(k, v.box())
+			}
+		}
+		return
+	}
+	for i, v := range x.list {
+		if !v.isNone() {
+			fn(Int(i+1), v.box())
 		}
 	}
-	if x.hash != nil {
-		for k, v := range x.hash {
-			fn(k, v)
+	for _, n := range x.nodes {
+		if !n.val.isNone() {
+			fn(n.key, n.val.box())
 		}
 	}
 }
 
 func (x *table) Index(index Value) Value {
-	return x.get(index)
+	return x.get(unbox(index)).box()
 }
 
 // newtable returns a new table initialized using the provided sizes
 // arrayN and hashN to create the underlying hash and array part.
 func newTable(state *State, arrayN, hashN int) *table {
-	t := table{state: state}
+	state.alloc(sizeTable + int64(arrayN)*sizeValue + int64(hashN)*sizeEntry)
+	t := table{state: state, hsize: hashN}
 	if arrayN > 0 {
-		t.list = make([]Value, arrayN)
+		t.list = makeList(arrayN)
 	}
 	if hashN > 0 {
-		t.hash = make(map[Value]Value, hashN)
+		t.hash = make(map[Value]int, hashN)
+		t.nodes = make([]node, 0, hashN)
 	} else {
-		t.hash = make(map[Value]Value)
+		t.hash = make(map[Value]int)
 	}
 	return &t
 }
 
-func (t *table) set(k, v Value) {
-	if IsNone(k) {
+// set assigns v to the key k, raising an error if k is nil or NaN. Integer
+// keys (and floats with an integral value, which are normalized to integers)
+// up to the size of the array part are kept there.
+func (t *table) set(k, v value) {
+	switch {
+	case k.isNone():
+		t.state.errorf("table index is nil")
+	case k.isFloat() && math.IsNaN(k.asFloat()):
+		t.state.errorf("table index is NaN")
+	}
+	if t.weak != nil {
+		t.version++
+		if t.weak.set(hashKey(k), v) {
+			t.state.alloc(sizeEntry)
+		}
 		return
 	}
-	if n, ok := k.(Number); ok {
-		i := arrayIndex(n) - 1
-		if i >= 0 && i < len(t.list) {
-			t.list[i] = v
+	if i := arrayIndex(k); i > 0 {
+		if i <= len(t.list) {
+			t.list[i-1] = v
 			return
 		}
-		if i == len(t.list) {
-			t.list = append(t.list, v)
+		if i == len(t.list)+1 && !v.isNone() {
+			// The key may have a node, left by a rehash which sized
+			// the array part to its predecessor: assigning to it must
+			// not move it, or a traversal would skip or revisit keys.
+			if j, ok := t.hash[Int(i)]; ok && !t.nodes[j].val.isNone() {
+				t.nodes[j].val = v
+				t.version++
+				return
+			}
+			t.append(v)
 			return
 		}
-		// TODO: resize & rehash
 	}
-	if IsNone(v) {
-		delete(t.hash, k)
+	key := hashKey(k)
+	if i, ok := t.hash[key]; ok {
+		t.nodes[i].val = v
+		t.version++
+		return
+	}
+	if v.isNone() {
 		return
 	}
-	t.hash[k] = v
+	t.version++
+	if len(t.nodes) >= t.hsize {
+		t.rehash(key)
+		if i := arrayIndex(k); i > 0 && i <= len(t.list) {
+			t.list[i-1] = v
+			return
+		}
+	}
+	t.hash[key] = len(t.nodes)
+	t.nodes = append(t.nodes, node{key: key, val: v})
+	t.state.alloc(sizeEntry)
+}
+
+func (t *table) get(k value) value {
+	if i := arrayIndex(k); i > 0 && i <= len(t.list) {
+		return t.list[i-1]
+	}
+	if k.isNone() {
+		return none
+	}
+	if t.weak != nil {
+		return t.weak.get(hashKey(k))
+	}
+	if len(t.nodes) == 0 {
+		return none
+	}
+	if i, ok := t.hash[hashKey(k)]; ok {
+		return t.nodes[i].val
+	}
+	return none
 }
 
-func (t *table) get(k Value) Value {
-	if IsNone(k) {
-		return None
+// field returns the value of the key k of the hash part, and whether it is
+// there and not nil.
+func (t *table) field(k Value) (value, bool) {
+	if i, ok := t.hash[k]; ok && !t.nodes[i].val.isNone() {
+		return t.nodes[i].val, true
 	}
-	if n, ok := k.(Number); ok {
-		i := arrayIndex(n) - 1
-		// fmt.Printf("table[%v] (%T) @ %d\n", k, k, i)
-		if i >= 0 && i < len(t.list) {
-			return t.list[i]
+	return none, false
+}
+
+// append adds v at the end of the array part, followed by the keys after it
+// which are in the hash part (whose nodes are left dead).
+func (t *table) append(v value) {
+	for {
+		if n := cap(t.list); n == len(t.list) {
+			t.state.alloc(int64(max(n, 4)) * sizeValue)
+		}
+		t.list = append(t.list, v)
+		if len(t.nodes) == 0 {
+			return
+		}
+		i, ok := t.hash[Int(len(t.list)+1)]
+		if !ok || t.nodes[i].val.isNone() {
+			return
 		}
+		v, t.nodes[i].val = t.nodes[i].val, none
+		t.version++
 	}
-	if v, ok := t.hash[k]; ok {
-		return v
+}
+
+// maxArrayBits is the base-2 logarithm of the maximum size of an array part.
+const maxArrayBits = 30
+
+// rehash resizes the parts of the table to make room for the new key of the
+// hash part: the array part gets the largest size n, a power of 2, such that
+// more than half of the integer keys from 1 to n are in use (including key),
+// and the hash part the size of a power of 2 that fits the other keys. The
+// dead nodes are dropped.
+func (t *table) rehash(key Value) {
+	// nums[i] is the number of integer keys k such that 2^(i-1) < k <= 2^i.
+	var nums [maxArrayBits + 1]int
+	count := func(k Value) int {
+		if k, ok := k.(Int); ok && k > 0 && k <= 1<<maxArrayBits {
+			nums[bits.Len64(uint64(k-1))]++
+			return 1
+		}
+		return 0
+	}
+	total, ints := 1, count(key)
+	for i, v := range t.list {
+		if !v.isNone() && i < 1<<maxArrayBits {
+			total++
+			ints++
+			nums[bits.Len(uint(i))]++
+		}
+	}
+	for _, n := range t.nodes {
+		if !n.val.isNone() {
+			total++
+			ints += count(n.key)
+		}
+	}
+	// size is the size of the array part, and na the number of keys in it.
+	size, na, a := 0, 0, 0
+	for i, twotoi := 0, 1; i <= maxArrayBits && ints > twotoi/2; i, twotoi = i+1, twotoi*2 {
+		if a += nums[i]; nums[i] > 0 && a > twotoi/2 {
+			size, na = twotoi, a
+		}
+	}
+	if t.hsize = 0; total > na {
+		t.hsize = 1 << bits.Len(uint(total-na-1))
+	}
+	t.resize(size)
+}
+
+// resize sets the size of the array part, and rebuilds the hash part with the
+// keys that do not fit in it.
+func (t *table) resize(size int) {
+	list, nodes := t.list, t.nodes
+	if size > cap(list) {
+		t.state.alloc(int64(size-cap(list)) * sizeValue)
+	}
+	t.list = makeList(size)
+	copy(t.list, list)
+	t.hash = make(map[Value]int, t.hsize)
+	t.nodes = make([]node, 0, t.hsize)
+	for i := size; i < len(list); i++ {
+		if v := list[i]; !v.isNone() {
+			t.hash[Int(i+1)] = len(t.nodes)
+			t.nodes = append(t.nodes, node{key: Int(i + 1), val: v})
+		}
+	}
+	for _, n := range nodes {
+		if n.val.isNone() {
+			continue
+		}
+		if i := arrayIndex(unbox(n.key)); i > 0 && i <= size {
+			t.list[i-1] = n.val
+			continue
+		}
+		t.hash[n.key] = len(t.nodes)
+		t.nodes = append(t.nodes, n)
 	}
-	return None
+}
+
+// makeList returns an array part of n empty slots.
+func makeList(n int) []value {
+	list := make([]value, n)
+	for i := range list {
+		list[i] = none
+	}
+	return list
 }
 
 func (t *table) getStr(key string) Value {
-	return t.get(String(key))
+	return t.get(value{v: String(key)}).box()
 }
 
 func (t *table) getInt(key int64) Value {
-	return t.get(Int(key))
+	return t.get(intValue(key)).box()
 }
 
-func (t *table) setStr(key string, value Value) {
-	t.set(String(key), value)
+func (t *table) setStr(key string, v Value) {
+	t.set(value{v: String(key)}, unbox(v))
 }
 
-func (t *table) setInt(key int64, value Value) {
-	t.set(Int(key), value)
+func (t *table) setInt(key int64, v Value) {
+	t.set(intValue(key), unbox(v))
 }
 
-func (t *table) exists(key Value) bool {
-	return !IsNone(t.get(key))
+func (t *table) exists(key value) bool {
+	return !t.get(key).isNone()
 }
 
+// length returns a border of the table, as the length operator does: an index
+// n such that t[n] is not nil and t[n+1] is nil, or 0 if t[1] is nil.
 func (t *table) length() int {
-	return len(t.list)
+	if t.weak != nil {
+		return t.unboundSearch(0)
+	}
+	j := len(t.list)
+	if j > 0 && t.list[j-1].isNone() {
+		// there is a border in the array part: binary search for it.
+		i := 0
+		for j-i > 1 {
+			if m := (i + j) / 2; t.list[m-1].isNone() {
+				j = m
+			} else {
+				i = m
+			}
+		}
+		return i
+	}
+	if len(t.nodes) == 0 {
+		return j
+	}
+	return t.unboundSearch(j)
 }
 
-func (t *table) next(key Value) (k, v Value, more bool) {
-	if IsNone(key) || t.keys == nil { // first iteration?
-		t.keys = make(map[Value]int, len(t.hash))
-		t.iter = make([]Value, 0, len(t.hash))
-		for k := range t.hash {
-			t.iter = append(t.iter, k)
-			t.keys[k] = len(t.iter) - 1
-		}
-	}
-	if index := t.iterKey(key); index < len(t.list) {
-		for index++; index <= len(t.list); index++ {
-			if !IsNone(t.list[index-1]) {
-				k = Int(index)
-				v = t.list[index-1]
-				return k, v, true
+// unboundSearch returns a border after j, where j is 0 or t[j] is not nil: it
+// doubles j until t[j] is nil, then binary searches between the last two.
+func (t *table) unboundSearch(j int) int {
+	has := func(i int) bool { return !t.get(intValue(int64(i))).isNone() }
+	i := j
+	for j++; has(j); j *= 2 {
+		i = j
+		if j > maxInt/2 { // overflow: linear search from the start
+			for i = 1; has(i); i++ {
 			}
+			return i - 1
 		}
-	} else {
-		if index = index - len(t.list); index < len(t.iter) {
-			k := t.iter[index]
-			v := t.hash[k]
-			return k, v, true
+	}
+	for j-i > 1 {
+		if m := (i + j) / 2; has(m) {
+			i = m
+		} else {
+			j = m
 		}
 	}
+	return i
+}
 
-	// Key did not exist or iteration ended.
-	t.iter = nil
-	t.keys = nil
-
+// next returns the key and value that follow key in a traversal of the table,
+// or the first ones if key is nil; more is false at the end of the traversal.
+// The array part is traversed first, then the nodes of the hash part, in
+// order. Each call takes constant time, amortized over a traversal, which may
+// assign to or clear the fields already traversed, but not add new ones.
+func (t *table) next(key Value) (k, v Value, more bool) {
+	if t.weak != nil {
+		return t.weak.next(t.state, key)
+	}
+	index := t.iterKey(key)
+	for ; index < len(t.list); index++ {
+		if v := t.list[index]; !v.isNone() {
+			return Int(index + 1), v.box(), true
+		}
+	}
+	for index -= len(t.list); index < len(t.nodes); index++ {
+		if n := t.nodes[index]; !n.val.isNone() {
+			return n.key, n.val.box(), true
+		}
+	}
 	return None, None, false
 }
 
@@ -180,14 +399,14 @@ func (t *table) iterKey(key Value) (index int) {
 	if IsNone(key) {
 		return 0
 	} // first iteration?
-	index = arrayIndex(key)
-	if index != 0 && index <= len(t.list) { // key in array?
+	k := unbox(key)
+	if index = arrayIndex(k); index != 0 && index <= len(t.list) { // key in array?
 		return index // found index
 	}
 	// otherwise key is in hash part.
 	var found bool
-	if index, found = t.keys[key]; !found {
-		panic(runtimeErr(fmt.Errorf("invalid key (%v) to 'next'", key)))
+	if index, found = t.hash[hashKey(k)]; !found {
+		t.state.errorf("invalid key to 'next'")
 	}
 	// hash elements are numbered after array ones.
 	return index + 1 + len(t.list)
@@ -195,27 +414,24 @@ func (t *table) iterKey(key Value) (index int) {
 
 const maxInt = int(^uint(0) >> 1)
 
-func arrayIndex(val Value) int {
-	switch val := val.(type) {
-	case Float:
-		if x, ok := float2int(float64(val)); ok {
-			return x
-		}
-	case Int:
-		if x := int(val); x > 0 && x < maxInt {
-			return x
+// arrayIndex returns the positive integer of an integer key, or of a float key
+// with an integral value; otherwise it returns 0.
+func arrayIndex(val value) int {
+	if val.isNumber() {
+		if i, ok := val.toInteger(); ok && i > 0 && i < int64(maxInt) {
+			return int(i)
 		}
 	}
 	return 0
 }
 
-func float2int(f64 float64) (int, bool) {
-	if math.IsInf(f64, 0) || math.IsNaN(f64) {
-		return 0, false
-	} else {
-		if i64 := int64(f64); float64(i64) == f64 {
-			return int(i64), true
+// hashKey returns the key of val in the hash part: floats with an integral
+// value are normalized to integers, so that t[2.0] is t[2].
+func hashKey(val value) Value {
+	if val.isFloat() {
+		if i, ok := val.toInteger(); ok {
+			return Int(i)
 		}
-		return 0, false
 	}
+	return val.box()
 }
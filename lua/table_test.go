@@ -0,0 +1,224 @@
+package lua
+
+import (
+	"math/rand"
+	"strconv"
+	"testing"
+)
+
+func TestTable(t *testing.T) {
+	var tests = []struct {
+		source string
+		result string
+	}{
+		// length is a border
+		{"local t = {1, 2, 3}; return #t", "3"},
+		{"local t = {1, 2, nil, 4}; t[4] = nil; return #t", "2"},
+		{"local t = {}; for i = 1, 100 do t[i] = i end; for i = 51, 100 do t[i] = nil end; return #t", "50"},
+		{"local t = {n = 1}; for i = 1, 10 do t[i] = i end; return #t", "10"},
+		{"local t = {}; t[1] = 1; t[3] = 3; local n = #t; return n == 1 or n == 3", "true"},
+		{"local t = {nil, nil}; return #t", "0"},
+		// keys are normalized
+		{"local t = {}; t[2.0] = 'a'; t[1] = 'b'; return t[2] .. t[1.0] .. #t", "ab2"},
+		{"local t = {}; t[2^53] = 1; t[-0.0] = 2; return t[math.tointeger(2^53)] + t[0]", "3"},
+		{"local t = {}; t[1.5] = 1; t[1] = 2; return t[1.5] + t[1]", "3"},
+		{"local t = {}; for i = 1, 10 do t[i + 0.0] = i end; return #t", "10"},
+		// keys move between the parts
+		{"local t = {}; for i = 1, 100 do t[101 - i] = i end; local s = 0; for i = 1, #t do s = s + t[i] end; return s", "5050"},
+		{"local t = {}; for i = 1, 100 do t[i] = i end; for i = 1, 100 do t[i] = nil end; for i = 1, 3 do t['k' .. i] = i end; return #t + t.k1 + t.k3", "4"},
+		{"local t = {}; for i = 1, 10 do t[i * 3] = i end; for i = 1, 30 do t[i] = t[i] or 0 end; return #t .. t[30]", "3010"},
+		{"local t = {}; for i = 1, 20 do t[i] = i end; local n = 0; for k, v in next, t do n = n + k end; return n", "210"},
+		// holes of the array part are nil
+		{"local t, e = {}, {}; t[1] = 1; t[2] = 2; t[3] = 3; t[5] = 5; return t[4] == e.x and t[4] == nil", "true"},
+		{"local t = {nil, nil, 3}; local u = {}; return t[1] == t[2] and t[2] == u.x", "true"},
+		// traversals
+		{"local t = {}; for i = 1, 100 do t['k' .. i] = i end; local n, s = 0, 0; for k, v in next, t do t[k] = nil; n, s = n + 1, s + v end; return n == 100 and s == 5050 and next(t) == nil", "true"},
+		{"local t = {1, 2, 3, x = 4, y = 5}; for k, v in next, t do t[k] = v * 2 end; local s = 0; for k, v in next, t do s = s + v end; return s", "30"},
+		{"local t = {1, 2, x = 3, y = 4, z = 5}; local n = 0; for k in next, t do for k in next, t do n = n + 1 end end; return n", "25"},
+		{"local t = {x = 1, y = 2, z = 3}; local n = 0; for k, v in next, t do n = n + v; t.x, t.y, t.z = nil end; return n <= 3 and next(t) == nil", "true"},
+		{"local t = {x = 1, y = 2, z = 3}; t.y = nil; local ks = ''; for k in next, t do ks = ks .. k end; t.y = 2; t.w = 4; return ks", "xz"},
+		// assign existing fields during traversals
+		{"local t, ks = {}, {9, 16, 13, 12, 18, 7, 2, 19, 14, 11, 6, 17, 's2', 's4', 's10', 's8', 's3'}; for i = 1, #ks do t[ks[i]] = i end; local seen, n = {}, 0; for k, v in next, t do if seen[k] then return 'twice: ' .. k end; seen[k], n = true, n + 1; t[k] = v end; return n", "17"},
+		// invalid keys
+		{"return next({}, 'x')", "invalid key to 'next'"},
+		{"local t = {1, a = 1}; return next(t, 2)", "invalid key to 'next'"},
+		{"local t = {}; t[nil] = 1", "table index is nil"},
+		{"local t = {}; t[0/0] = 1", "table index is NaN"},
+		{"local t = {}; t[nil] = nil; return 1", "table index is nil"},
+	}
+	for _, version := range []LuaVersion{V53, V54} {
+		for _, test := range tests {
+			state := NewState(WithVersion(version))
+			state.Register("next", func(state *State) int {
+				state.SetTop(2)
+				if state.Next(1) {
+					return 2
+				}
+				state.Push(nil)
+				return 1
+			})
+			state.NewTable()
+			state.Push(func(state *State) int {
+				state.Push(state.ToInt(1))
+				return 1
+			})
+			state.SetField(-2, "tointeger")
+			state.SetGlobal("math")
+			if err := state.LoadText(test.source); err != nil {
+				t.Fatalf("load %q: %v", test.source, err)
+			}
+			if err := state.PCall(0, 1, 0); err != nil {
+				if err.Error() != test.result {
+					t.Errorf("exec %q (%v): %v", test.source, version, err)
+				}
+				continue
+			}
+			if got := state.ToStringMeta(-1); got != test.result {
+				t.Errorf("exec %q (%v): got %s, want %s", test.source, version, got, test.result)
+			}
+		}
+	}
+}
+
+func TestTableParts(t *testing.T) {
+	var tests = []struct {
+		source      string
+		array, hash int
+	}{
+		{"local t = {}; for i = 1, 100 do t[i] = i end; return t", 100, 0},
+		{"local t = {}; for i = 1, 100 do t[101 - i] = i end; return t", 100, 0},
+		{"local t = {}; for i = 1, 100, 2 do t[i] = i end; for i = 2, 100, 2 do t[i] = i end; return t", 100, 0},
+		{"local t = {}; for i = 1, 10 do t[i * 1000] = i end; return t", 0, 10},
+		{"local t = {x = 1, y = 2}; for i = 1, 3 do t[4 - i] = i end; return t", 3, 2},
+		{"local t = {}; for i = 1, 64 do t[i] = i end; for i = 1, 60 do t[i] = nil end; for i = 1, 64 do t['k' .. i] = i end; return t", 0, 68},
+	}
+	for _, test := range tests {
+		state := NewState()
+		if err := state.LoadText(test.source); err != nil {
+			t.Fatalf("load %q: %v", test.source, err)
+		}
+		if err := state.PCall(0, 1, 0); err != nil {
+			t.Fatalf("exec %q: %v", test.source, err)
+		}
+		tbl := state.get(-1).(*table)
+		var array, hash int
+		for _, v := range tbl.list {
+			if !v.isNone() {
+				array++
+			}
+		}
+		for _, n := range tbl.nodes {
+			if !n.val.isNone() {
+				hash++
+			}
+		}
+		if array != test.array || hash != test.hash {
+			t.Errorf("exec %q: got %d array and %d hash keys, want %d and %d", test.source, array, hash, test.array, test.hash)
+		}
+	}
+}
+
+// TestTableRandom sets and clears random keys of tables, checking their values,
+// lengths and traversals against a map.
+func TestTableRandom(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	for round := 0; round < 50; round++ {
+		state := NewState()
+		state.NewTable()
+		shadow := make(map[int]int)
+		for op := 0; op < 500; op++ {
+			k := rng.Intn(64) + 1
+			if rng.Intn(3) == 0 {
+				delete(shadow, k)
+				state.Push(nil)
+			} else {
+				shadow[k] = rng.Intn(1000)
+				state.Push(shadow[k])
+			}
+			state.RawSetIndex(-2, k)
+
+			for k := 1; k <= 64; k++ {
+				state.RawGetIndex(-1, k)
+				if v, ok := shadow[k]; ok == state.IsNoneOrNil(-1) || ok && state.ToInt(-1) != int64(v) {
+					t.Fatalf("round %d, op %d: t[%d] = %v, want %d (%t)", round, op, k, state.get(-1), v, ok)
+				}
+				state.Pop()
+			}
+			n := state.RawLen(-1)
+			_, last := shadow[n]
+			if _, next := shadow[n+1]; n > 0 && !last || next {
+				t.Fatalf("round %d, op %d: #t = %d is not a border", round, op, n)
+			}
+			seen := make(map[int]bool)
+			state.Push(nil)
+			for state.Next(-2) {
+				k := int(state.ToInt(-2))
+				v, ok := shadow[k]
+				if !ok || seen[k] || state.ToInt(-1) != int64(v) {
+					t.Fatalf("round %d, op %d: next visits %d = %v", round, op, k, state.get(-1))
+				}
+				seen[k] = true
+				state.Pop()
+				if rng.Intn(2) == 0 { // assign an existing field during the traversal
+					state.Push(v)
+					state.RawSetIndex(-3, k)
+				}
+			}
+			if len(seen) != len(shadow) {
+				t.Fatalf("round %d, op %d: next visits %d keys, want %d", round, op, len(seen), len(shadow))
+			}
+		}
+	}
+}
+
+func BenchmarkTable(b *testing.B) {
+	var benchmarks = []struct {
+		name   string
+		source string
+	}{
+		{"append", "local t = {}; for i = 1, 1000 do t[#t + 1] = i end"},
+		{"fill", "local t = {}; for i = 1, 1000 do t[i] = i end"},
+		{"reverse", "local t = {}; for i = 1, 1000 do t[1001 - i] = i end"},
+		{"float", "local t = {}; for i = 1, 1000 do t[i + 0.0] = i end"},
+		{"read", "local s = 0; for i = 1, 1000 do s = s + t[i] end"},
+		{"length", "local n = 0; for i = 1, 1000 do n = n + #t end"},
+		{"next", "local n = 0; for k, v in next, t do n = n + v end; for k, v in next, h do n = n + v end"},
+		{"holes", "local t = {}; for i = 1, 1000 do t[i] = i end; for i = 1, 1000, 2 do t[i] = nil end; for i = 1, 1000, 2 do t[i] = i end"},
+	}
+	for _, version := range []LuaVersion{V53, V54} {
+		for _, bench := range benchmarks {
+			b.Run(version.String()+"/"+bench.name, func(b *testing.B) {
+				state := NewState(WithVersion(version))
+				state.NewTable()
+				for i := 1; i <= 1000; i++ {
+					state.Push(i)
+					state.RawSetIndex(-2, i)
+				}
+				state.SetGlobal("t")
+				state.NewTable()
+				for i := 1; i <= 1000; i++ {
+					state.Push(i)
+					state.SetField(-2, "k"+strconv.Itoa(i))
+				}
+				state.SetGlobal("h")
+				state.Register("next", func(state *State) int {
+					state.SetTop(2)
+					if state.Next(1) {
+						return 2
+					}
+					state.Push(nil)
+					return 1
+				})
+				if err := state.LoadText(bench.source); err != nil {
+					b.Fatal(err)
+				}
+				b.ReportAllocs()
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					state.PushIndex(-1)
+					state.Call(0, 0)
+				}
+			})
+		}
+	}
+}
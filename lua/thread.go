@@ -0,0 +1,321 @@
+package lua
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Continuation is a function that continues the execution of a Go function
+// after a call or a yield (see CallK, PCallK and YieldK). It receives the
+// status of the thread (ThreadOK, ThreadYield or ThreadError) and the context
+// value given to the function that took it.
+//
+// See https://www.lua.org/manual/5.3/manual.html#lua_KFunction
+type Continuation func(state *State, status ThreadStatus, ctx interface{}) int
+
+// coroutine holds the execution context of a thread created by NewThread.
+//
+// Each coroutine runs on its own goroutine, which keeps the Go stack of the
+// coroutine (Lua frames and the Go functions between them) while it is
+// suspended. As in Lua, yields cross the Lua frames and the metamethods they
+// call, but a Go function must call with a continuation (see CallK and
+// PCallK) to let the functions it calls yield. The thread that resumes a
+// coroutine blocks until it yields, returns or fails, so only one goroutine
+// ever runs a given global state at a time.
+type coroutine struct {
+	resume   chan transfer // resumer to coroutine
+	yield    chan transfer // coroutine to resumer
+	from     *State        // thread that resumed the coroutine, while running
+	started  bool          // body function called
+	done     bool          // body function returned or failed
+	released bool          // unwound as garbage or by Close (see release)
+	yields   int           // number of yields so far
+	err      error         // error that stopped the coroutine
+}
+
+// transfer is the message exchanged by a coroutine and its resumer: the
+// number of values passed on top of the stack, or an error.
+type transfer struct {
+	n   int
+	err error
+}
+
+// errThreadClosed is raised in a suspended coroutine to unwind it when it
+// is closed (see CloseThread). It cannot be caught by PCall.
+var errThreadClosed = errors.New("coroutine closed")
+
+// NewThread creates a new thread, pushes it on the stack, and returns a State
+// that represents this new thread. The new thread shares with the original
+// thread its global environment, but has an independent execution stack.
+//
+// To run a function in the new thread, push the function and its arguments
+// onto the stack of the thread and call Resume.
+//
+// A thread suspended in a yield holds a goroutine until it is resumed to
+// completion, closed with CloseThread, or released: when the collector finds
+// it no longer reachable from Lua, or when the state is closed (see Close).
+//
+// See https://www.lua.org/manual/5.3/manual.html#lua_newthread
+func (state *State) NewThread() *State {
+	co := new(State).reset()
+	co.enter(new(Frame))
+	co.init(state.global)
+	co.thread = &thread{State: co}
+	co.maxcalls = state.global.config.maxcalls
+	co.maxgo = MaxGoCalls
+	state.alloc(sizeThread + int64(len(co.stack))*sizeValue)
+	co.co = &coroutine{
+		resume: make(chan transfer),
+		yield:  make(chan transfer),
+	}
+	state.Push(co.thread)
+	return co
+}
+
+// PushThread pushes the thread represented by state onto its stack. Returns
+// true if this thread is the main thread of its state.
+//
+// See https://www.lua.org/manual/5.3/manual.html#lua_pushthread
+func (state *State) PushThread() bool {
+	state.Push(state.thread)
+	return state.co == nil
+}
+
+// Resume starts and resumes a coroutine in the given thread.
+//
+// To start a coroutine, push onto the thread's stack the main function plus
+// any arguments; then call Resume, with nargs being the number of arguments.
+// This call returns when the coroutine suspends or finishes its execution.
+// When it returns, the stack of the thread contains all values passed to
+// Yield, or all values returned by the body function. Resume returns
+// ThreadYield if the coroutine yields, ThreadOK if the coroutine finishes
+// its execution without errors, or ThreadError and the error.
+//
+// To resume a coroutine, remove the yielded values from its stack, push the
+// values to be passed as results from the yield, and then call Resume.
+//
+// The parameter from is the thread that is resuming the coroutine (or nil).
+//
+// The errors that a PCall of the resumer cannot catch, such as a *ContextError
+// or a *BudgetError, are raised again in it instead of being returned.
+//
+// See https://www.lua.org/manual/5.3/manual.html#lua_resume
+func (state *State) Resume(from *State, nargs int) (ThreadStatus, error) {
+	co := state.co
+	switch {
+	case co == nil || co.from != nil:
+		return ThreadError, fmt.Errorf("cannot resume non-suspended coroutine")
+	case co.done || (!co.started && state.Top() <= nargs):
+		return ThreadError, fmt.Errorf("cannot resume dead coroutine")
+	}
+	if from == nil {
+		from = state.global.thread0
+	}
+	co.from = from
+	state.maxcalls = from.maxcalls - from.calls
+	state.maxgo = from.maxgo - from.gocalls
+	if co.started {
+		co.resume <- transfer{n: nargs}
+	} else {
+		co.started = true
+		g := state.global
+		g.threads = append(g.threads, state)
+		go state.run(nargs)
+	}
+	t := <-co.yield
+	co.from = nil
+	if g := state.global; len(g.tobefnz) > 0 {
+		g.next = g.used // finalize at the next check (see callFinalizers)
+	}
+	if t.err != nil && !from.catchable(t.err) {
+		panic(t.err)
+	}
+	return state.status, t.err
+}
+
+// run calls the body function of a coroutine, with the nargs arguments on
+// top of its stack, and reports the outcome to the resumer.
+func (state *State) run(nargs int) {
+	var t transfer
+	defer func() {
+		if r := recover(); r != nil && r != errThreadClosed {
+			if err, ok := r.(error); ok {
+				t.err = err
+			} else {
+				t.err = fmt.Errorf("%v", r)
+			}
+			state.status = ThreadError
+			state.co.err = t.err
+		}
+		state.co.done = true
+		state.global.dropThread(state)
+		state.co.yield <- t
+	}()
+	state.callk(nargs, MultRets)
+	t.n = state.Top()
+}
+
+// Yield yields a coroutine (thread); it is YieldK without a continuation.
+//
+// Go functions usually end with "return state.Yield(n)": the call returns
+// the number of values passed to Resume, which the function then returns.
+//
+// See https://www.lua.org/manual/5.3/manual.html#lua_yield
+func (state *State) Yield(nres int) int { return state.YieldK(nres, nil, nil) }
+
+// YieldK yields a coroutine (thread).
+//
+// When a Go function calls YieldK, the running coroutine suspends its
+// execution, and the call to Resume that started this coroutine returns. The
+// parameter nres is the number of values from the stack that will be passed
+// as results to Resume.
+//
+// When the coroutine is resumed again, the stack of the function is the one
+// it had before the yield, with the nres results removed and replaced by the
+// arguments passed to Resume. YieldK then returns the result of calling the
+// continuation k with the status ThreadYield and ctx, or the number of these
+// arguments if k is nil.
+//
+// See https://www.lua.org/manual/5.3/manual.html#lua_yieldk
+func (state *State) YieldK(nres int, ctx interface{}, k Continuation) int {
+	co := state.co
+	switch {
+	case co == nil:
+		state.errorf("attempt to yield from outside a coroutine")
+	case state.nny > 0:
+		state.errorf("attempt to yield across a C-call boundary")
+	}
+	// Protect the stack below the results, so that the resumer only
+	// sees (and replaces) the yielded values.
+	var (
+		fr   = state.frame()
+		base = fr.base
+		top  = fr.top - nres
+	)
+	fr.base = top
+
+	co.yields++
+	state.status = ThreadYield
+	co.yield <- transfer{n: nres}
+	t := <-co.resume
+	state.status = ThreadOK
+	fr.base = base
+	if t.err != nil {
+		fr.settop(top - base)
+		panic(t.err)
+	}
+	// Move the arguments down onto the stack below the results.
+	n := copy(state.stack[top:], state.stack[fr.top-t.n:fr.top])
+	fr.settop(top - base + n)
+	if k != nil {
+		return k(state, ThreadYield, ctx)
+	}
+	return t.n
+}
+
+// IsYieldable returns true if the given thread can yield, and false otherwise.
+//
+// See https://www.lua.org/manual/5.3/manual.html#lua_isyieldable
+func (state *State) IsYieldable() bool { return state.co != nil && state.nny == 0 }
+
+// CloseThread closes a suspended or dead coroutine: a suspended coroutine is
+// unwound, closing its pending to-be-closed variables (Lua 5.4), and its
+// goroutine released. The thread is dead afterwards. Returns the error raised
+// while closing, or the error that stopped the coroutine, if any.
+//
+// See https://www.lua.org/manual/5.4/manual.html#lua_resetthread
+func (state *State) CloseThread() error {
+	co := state.co
+	if co == nil || co.from != nil {
+		return fmt.Errorf("cannot close a running coroutine")
+	}
+	if co.started && !co.done {
+		co.from = state.global.thread0
+		co.resume <- transfer{err: errThreadClosed}
+		t := <-co.yield
+		co.from = nil
+		if t.err != nil {
+			return t.err
+		}
+	}
+	co.done = true
+	state.status = ThreadOK
+	state.SetTop(0)
+	return co.err
+}
+
+// release unwinds a suspended coroutine, no longer reachable or whose state
+// is closed, so that its goroutine exits. As in Lua, its pending to-be-closed
+// variables are not closed.
+func (state *State) release() {
+	state.co.released = true
+	state.CloseThread()
+}
+
+// dropThread removes the coroutine of ls, whose goroutine exits, from the
+// coroutines holding one.
+func (g *global) dropThread(ls *State) {
+	for i, x := range g.threads {
+		if x == ls {
+			n := len(g.threads) - 1
+			g.threads[i], g.threads[n] = g.threads[n], nil
+			g.threads = g.threads[:n]
+			return
+		}
+	}
+}
+
+// CallK behaves exactly like Call, but allows the called function to yield
+// (see YieldK) if k is not nil. Since a coroutine keeps its Go stack while it
+// is suspended, the Go function resumes right where the call returns; CallK
+// then returns the result of the continuation k, called with ThreadYield if
+// the coroutine yielded during the call or ThreadOK otherwise, and ctx. With
+// a nil k, CallK is Call and returns 0.
+//
+// See https://www.lua.org/manual/5.3/manual.html#lua_callk
+func (state *State) CallK(args, rets int, ctx interface{}, k Continuation) int {
+	if k == nil {
+		state.Call(args, rets)
+		return 0
+	}
+	yields := state.yields()
+	state.callk(args, rets)
+	if state.yields() != yields {
+		return k(state, ThreadYield, ctx)
+	}
+	return k(state, ThreadOK, ctx)
+}
+
+// PCallK behaves exactly like PCall, but allows the called function to yield
+// (see YieldK) if k is not nil. As in CallK, PCallK returns the result of
+// the continuation k; in case of errors, the error object is pushed onto the
+// stack (see PushError) and k is called with ThreadError. With a nil k, PCallK returns the
+// status of the call, ThreadOK or ThreadError.
+//
+// See https://www.lua.org/manual/5.3/manual.html#lua_pcallk
+func (state *State) PCallK(args, rets, msgh int, ctx interface{}, k Continuation) int {
+	if k == nil {
+		if err := state.PCall(args, rets, msgh); err != nil {
+			state.PushError(err)
+			return int(ThreadError)
+		}
+		return int(ThreadOK)
+	}
+	yields := state.yields()
+	if err := state.protect(func() { state.callk(args, rets) }); err != nil {
+		state.PushError(err)
+		return k(state, ThreadError, ctx)
+	}
+	if state.yields() != yields {
+		return k(state, ThreadYield, ctx)
+	}
+	return k(state, ThreadOK, ctx)
+}
+
+// yields returns the number of times the thread yielded.
+func (state *State) yields() int {
+	if state.co != nil {
+		return state.co.yields
+	}
+	return 0
+}
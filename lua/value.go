@@ -2,7 +2,7 @@ package lua
 
 import (
 	"fmt"
-	"math/big"
+	"math"
 	"reflect"
 	"runtime"
 )
@@ -64,6 +64,8 @@ type (
 type Object struct {
 	meta *table
 	data interface{}
+	eph  ephemerons
+	fin  bool // marked for finalization (see checkFinalizer)
 }
 
 func UserData(data interface{}) *Object {
@@ -155,14 +157,15 @@ func ValueOf(state *State, value interface{}) Value {
 func valueOf(state *State, value interface{}) Value {
 	switch value := value.(type) {
 	case func(*State) int:
-		return newGoClosure(Func(value), 0)
+		return newGoClosure(state, Func(value), 0)
 	case Func:
-		return newGoClosure(value, 0)
+		return newGoClosure(state, value, 0)
 	case float64:
 		return Float(value)
 	case float32:
 		return Float(float64(value))
 	case string:
+		state.alloc(sizeString + int64(len(value)))
 		return String(value)
 	case int64:
 		return Int(value)
@@ -177,6 +180,7 @@ func valueOf(state *State, value interface{}) Value {
 	case nil:
 		return Nil(1)
 	}
+	state.alloc(sizeObject)
 	udata := &Object{data: value}
 	udata.meta = metaOf(state, udata)
 	return udata
@@ -186,9 +190,8 @@ func IsNumber(value Value) bool { return IsFloat(value) || IsInt(value) }
 func IsFloat(value Value) bool  { _, ok := value.(Float); return ok }
 func IsInt(value Value) bool    { _, ok := value.(Int); return ok }
 func IsNone(value Value) bool {
-	return value == nil || value == None || !isNil(value) || value.Type() == NilType
+	return value == nil || value == None || value.Type() == NilType
 }
-func isNil(value Value) bool { return reflect.ValueOf(value).IsValid() } //IsNil() }
 
 func Truth(value Value) bool { return bool(truth(value)) }
 
@@ -211,8 +214,28 @@ func toString(value Value) (string, bool) {
 	return "", false
 }
 
-func (x Float) rational() *big.Rat { return new(big.Rat).SetFloat64(float64(x)) }
-func (x Int) rational() *big.Rat   { return new(big.Rat).SetInt64(int64(x)) }
+// cmpIntFloat returns -1, 0 or +1 as i is less than, equal to or greater
+// than f, exactly; f must not be NaN.
+func cmpIntFloat(i int64, f float64) int {
+	switch {
+	case f >= 0x1p63: // above any integer, or +inf
+		return -1
+	case f < -0x1p63: // below any integer, or -inf
+		return +1
+	}
+	// f truncated is an integer, and the rest its fraction.
+	switch t := int64(f); {
+	case i < t:
+		return -1
+	case i > t:
+		return +1
+	case f > float64(t):
+		return -1
+	case f < float64(t):
+		return +1
+	}
+	return 0
+}
 
 // truth returns true for any Lua value different from false
 // and None (or nil), otherwise returns false.
@@ -223,6 +246,139 @@ func truth(value Value) Bool {
 	return Bool(!IsNone(value))
 }
 
+// value is the representation of a Lua value in the stacks, upvalues,
+// constants and tables of a state, which keeps numbers unboxed: v holds
+// intTag or floatTag and n the bits of the number, so that arithmetic does
+// not allocate; any other value is held in v. The zero value is no value,
+// as a nil Value.
+//
+// The Value of the API is converted at the boundary, with unbox and box.
+type value struct {
+	v Value
+	n uint64
+}
+
+type (
+	intTag   struct{}
+	floatTag struct{}
+)
+
+func (intTag) String() string   { return "int" }
+func (intTag) Type() Type       { return NumberType }
+func (floatTag) String() string { return "float" }
+func (floatTag) Type() Type     { return NumberType }
+
+// none and nilValue are no value and nil, as None and Nil(1).
+var (
+	none     = value{v: None}
+	nilValue = value{v: Nil(1)}
+)
+
+func intValue(i int64) value     { return value{v: intTag{}, n: uint64(i)} }
+func floatValue(f float64) value { return value{v: floatTag{}, n: math.Float64bits(f)} }
+func boolValue(b bool) value     { return value{v: Bool(b)} }
+
+// unbox returns the representation of x.
+func unbox(x Value) value {
+	switch x := x.(type) {
+	case Int:
+		return intValue(int64(x))
+	case Float:
+		return floatValue(float64(x))
+	}
+	return value{v: x}
+}
+
+// box returns v as a Value; boxing a number allocates it.
+func (v value) box() Value {
+	switch v.v.(type) {
+	case intTag:
+		return Int(v.asInt())
+	case floatTag:
+		return Float(v.asFloat())
+	}
+	return v.v
+}
+
+func (v value) isInt() bool   { _, ok := v.v.(intTag); return ok }
+func (v value) isFloat() bool { _, ok := v.v.(floatTag); return ok }
+
+func (v value) isNumber() bool {
+	switch v.v.(type) {
+	case intTag, floatTag:
+		return true
+	}
+	return false
+}
+
+// asInt and asFloat return the number of an integer and a float value.
+func (v value) asInt() int64     { return int64(v.n) }
+func (v value) asFloat() float64 { return math.Float64frombits(v.n) }
+
+// toInteger and toFloat convert v as the functions of the same names do,
+// without boxing numbers.
+func (v value) toInteger() (int64, bool) {
+	switch v.v.(type) {
+	case intTag:
+		return v.asInt(), true
+	case floatTag:
+		if f := v.asFloat(); float64(int64(f)) == f {
+			return int64(f), true
+		}
+		return 0, false
+	}
+	i, ok := toInteger(v.v)
+	return int64(i), ok
+}
+
+func (v value) toFloat() (float64, bool) {
+	switch v.v.(type) {
+	case intTag:
+		return float64(v.asInt()), true
+	case floatTag:
+		return v.asFloat(), true
+	}
+	f, ok := toFloat(v.v)
+	return float64(f), ok
+}
+
+// isNone reports whether v is nil, or no value.
+func (v value) isNone() bool {
+	switch v.v.(type) {
+	case intTag, floatTag:
+		return false
+	}
+	return IsNone(v.v)
+}
+
+// truth reports whether v is different from false and nil.
+func (v value) truth() bool {
+	switch x := v.v.(type) {
+	case intTag, floatTag:
+		return true
+	case Bool:
+		return bool(x)
+	}
+	return !IsNone(v.v)
+}
+
+// typ returns the type of v. (A value is not a Value: it must be boxed to be
+// held as one.)
+func (v value) typ() Type {
+	if v.v == nil {
+		return NoneType
+	}
+	return v.v.Type()
+}
+
+// String returns the canonical string of v.
+func (v value) String() string {
+	if v.v == nil {
+		return None.String()
+	}
+	return v.box().String()
+}
+
 func min(a, b int) int {
 	if a < b {
 		return a
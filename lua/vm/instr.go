@@ -5,6 +5,10 @@ import (
 )
 
 const (
+	MaxArgA   = 1<<8 - 1
+	MaxArgB   = 1<<9 - 1
+	MaxArgC   = 1<<9 - 1
+	MaxArgAx  = 1<<26 - 1
 	MaxArgBX  = 1<<18 - 1
 	MaxArgSBX = MaxArgBX >> 1
 )
@@ -13,8 +17,9 @@ const (
 // bits 0-1: op mode
 // bits 2-3: C arg mode
 // bits 4-5: B arg mode
-//    bit 6: instruction set register A
-//    bit 7: operator is a test (next instruction must be a jump)
+//
+//	bit 6: instruction set register A
+//	bit 7: operator is a test (next instruction must be a jump)
 type OpArgMask uint8
 
 const (
@@ -59,3 +64,50 @@ func args(instr Instr) string {
 	}
 	panic(fmt.Sprintf("ir: unknown op mode: %d", instr.Code().Mode()))
 }
+
+const (
+	// BitRK is set in B or C arguments that index a constant
+	// rather than a register.
+	BitRK = 1 << 8
+
+	// MaxIndexRK is the largest constant index encodable as RK.
+	MaxIndexRK = BitRK - 1
+)
+
+// IsK reports whether the RK argument x refers to a constant.
+func IsK(x int) bool { return x&BitRK != 0 }
+
+// RKAsK encodes the constant index x as an RK argument.
+func RKAsK(x int) int { return x | BitRK }
+
+// MakeABC returns an instruction for code with arguments A, B and C.
+func MakeABC(code Code, a, b, c int) Instr {
+	return Instr(uint32(code) | uint32(a)<<6 | uint32(b)<<23 | uint32(c)<<14)
+}
+
+// MakeABx returns an instruction for code with arguments A and Bx.
+func MakeABx(code Code, a, bx int) Instr {
+	return Instr(uint32(code) | uint32(a)<<6 | uint32(bx)<<14)
+}
+
+// MakeAsBx returns an instruction for code with arguments A and sBx.
+func MakeAsBx(code Code, a, sbx int) Instr {
+	return MakeABx(code, a, sbx+MaxArgSBX)
+}
+
+// MakeAx returns an instruction for code with argument Ax.
+func MakeAx(code Code, ax int) Instr {
+	return Instr(uint32(code) | uint32(ax)<<6)
+}
+
+func (instr Instr) WithCode(code Code) Instr { return instr&^0x3F | Instr(code) }
+
+func (instr Instr) WithA(a int) Instr { return instr&^(0xFF<<6) | Instr(a)<<6 }
+
+func (instr Instr) WithB(b int) Instr { return instr&^(0x1FF<<23) | Instr(b)<<23 }
+
+func (instr Instr) WithC(c int) Instr { return instr&^(0x1FF<<14) | Instr(c)<<14 }
+
+func (instr Instr) WithBX(bx int) Instr { return instr&^(0x3FFFF<<14) | Instr(bx)<<14 }
+
+func (instr Instr) WithSBX(sbx int) Instr { return instr.WithBX(sbx + MaxArgSBX) }
@@ -0,0 +1,184 @@
+// Package vm54 defines the instruction set of the Lua 5.4 virtual machine.
+//
+// See lua-5.4/src/lopcodes.h
+package vm54
+
+type Code uint8
+
+const (
+	MOVE Code = iota
+	LOADI
+	LOADF
+	LOADK
+	LOADKX
+	LOADFALSE
+	LFALSESKIP
+	LOADTRUE
+	LOADNIL
+	GETUPVAL
+	SETUPVAL
+	GETTABUP
+	GETTABLE
+	GETI
+	GETFIELD
+	SETTABUP
+	SETTABLE
+	SETI
+	SETFIELD
+	NEWTABLE
+	SELF
+	ADDI
+	ADDK
+	SUBK
+	MULK
+	MODK
+	POWK
+	DIVK
+	IDIVK
+	BANDK
+	BORK
+	BXORK
+	SHRI
+	SHLI
+	ADD
+	SUB
+	MUL
+	MOD
+	POW
+	DIV
+	IDIV
+	BAND
+	BOR
+	BXOR
+	SHL
+	SHR
+	MMBIN
+	MMBINI
+	MMBINK
+	UNM
+	BNOT
+	NOT
+	LEN
+	CONCAT
+	CLOSE
+	TBC
+	JMP
+	EQ
+	LT
+	LE
+	EQK
+	EQI
+	LTI
+	LEI
+	GTI
+	GEI
+	TEST
+	TESTSET
+	CALL
+	TAILCALL
+	RETURN
+	RETURN0
+	RETURN1
+	FORLOOP
+	FORPREP
+	TFORPREP
+	TFORCALL
+	TFORLOOP
+	SETLIST
+	CLOSURE
+	VARARG
+	VARARGPREP
+	EXTRAARG
+)
+
+var names = [...]string{
+	MOVE:       "MOVE",
+	LOADI:      "LOADI",
+	LOADF:      "LOADF",
+	LOADK:      "LOADK",
+	LOADKX:     "LOADKX",
+	LOADFALSE:  "LOADFALSE",
+	LFALSESKIP: "LFALSESKIP",
+	LOADTRUE:   "LOADTRUE",
+	LOADNIL:    "LOADNIL",
+	GETUPVAL:   "GETUPVAL",
+	SETUPVAL:   "SETUPVAL",
+	GETTABUP:   "GETTABUP",
+	GETTABLE:   "GETTABLE",
+	GETI:       "GETI",
+	GETFIELD:   "GETFIELD",
+	SETTABUP:   "SETTABUP",
+	SETTABLE:   "SETTABLE",
+	SETI:       "SETI",
+	SETFIELD:   "SETFIELD",
+	NEWTABLE:   "NEWTABLE",
+	SELF:       "SELF",
+	ADDI:       "ADDI",
+	ADDK:       "ADDK",
+	SUBK:       "SUBK",
+	MULK:       "MULK",
+	MODK:       "MODK",
+	POWK:       "POWK",
+	DIVK:       "DIVK",
+	IDIVK:      "IDIVK",
+	BANDK:      "BANDK",
+	BORK:       "BORK",
+	BXORK:      "BXORK",
+	SHRI:       "SHRI",
+	SHLI:       "SHLI",
+	ADD:        "ADD",
+	SUB:        "SUB",
+	MUL:        "MUL",
+	MOD:        "MOD",
+	POW:        "POW",
+	DIV:        "DIV",
+	IDIV:       "IDIV",
+	BAND:       "BAND",
+	BOR:        "BOR",
+	BXOR:       "BXOR",
+	SHL:        "SHL",
+	SHR:        "SHR",
+	MMBIN:      "MMBIN",
+	MMBINI:     "MMBINI",
+	MMBINK:     "MMBINK",
+	UNM:        "UNM",
+	BNOT:       "BNOT",
+	NOT:        "NOT",
+	LEN:        "LEN",
+	CONCAT:     "CONCAT",
+	CLOSE:      "CLOSE",
+	TBC:        "TBC",
+	JMP:        "JMP",
+	EQ:         "EQ",
+	LT:         "LT",
+	LE:         "LE",
+	EQK:        "EQK",
+	EQI:        "EQI",
+	LTI:        "LTI",
+	LEI:        "LEI",
+	GTI:        "GTI",
+	GEI:        "GEI",
+	TEST:       "TEST",
+	TESTSET:    "TESTSET",
+	CALL:       "CALL",
+	TAILCALL:   "TAILCALL",
+	RETURN:     "RETURN",
+	RETURN0:    "RETURN0",
+	RETURN1:    "RETURN1",
+	FORLOOP:    "FORLOOP",
+	FORPREP:    "FORPREP",
+	TFORPREP:   "TFORPREP",
+	TFORCALL:   "TFORCALL",
+	TFORLOOP:   "TFORLOOP",
+	SETLIST:    "SETLIST",
+	CLOSURE:    "CLOSURE",
+	VARARG:     "VARARG",
+	VARARGPREP: "VARARGPREP",
+	EXTRAARG:   "EXTRAARG",
+}
+
+func (op Code) Mask() Mask { return masks[op] }
+
+func (op Code) Mode() Mode { return masks[op].Mode() }
+
+func (op Code) String() string { return names[op] }
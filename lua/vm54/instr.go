@@ -0,0 +1,123 @@
+package vm54
+
+import (
+	"fmt"
+)
+
+// Instructions are unsigned 32-bit integers. All instructions have
+// an opcode in the first 7 bits; the arguments are laid out as:
+//
+//	iABC    C(8)  |  B(8)  |k|  A(8)  |  Op(7)
+//	iABx          Bx(17)     |  A(8)  |  Op(7)
+//	iAsBx        sBx(17)     |  A(8)  |  Op(7)
+//	iAx              Ax(25)           |  Op(7)
+//	isJ              sJ(25)           |  Op(7)
+const (
+	MaxArgA  = 1<<8 - 1
+	MaxArgB  = 1<<8 - 1
+	MaxArgC  = 1<<8 - 1
+	MaxArgAx = 1<<25 - 1
+	MaxArgBX = 1<<17 - 1
+	MaxArgSJ = 1<<25 - 1
+
+	// OffsetSBX, OffsetSJ and OffsetSC are the excess-K biases of the
+	// signed arguments sBx, sJ and sC.
+	OffsetSBX = MaxArgBX >> 1
+	OffsetSJ  = MaxArgSJ >> 1
+	OffsetSC  = MaxArgC >> 1
+)
+
+type Instr uint32
+
+func (instr Instr) Code() Code { return Code(int(instr & 0x7F)) }
+
+func (instr Instr) ABC() (a, b, c int) { return instr.A(), instr.B(), instr.C() }
+
+func (instr Instr) A() (a int) { return int(instr >> 7 & 0xFF) }
+
+func (instr Instr) B() (b int) { return int(instr >> 16 & 0xFF) }
+
+func (instr Instr) C() (c int) { return int(instr >> 24 & 0xFF) }
+
+func (instr Instr) K() (k int) { return int(instr >> 15 & 1) }
+
+func (instr Instr) SB() (sb int) { return instr.B() - OffsetSC }
+
+func (instr Instr) SC() (sc int) { return instr.C() - OffsetSC }
+
+func (instr Instr) AX() (ax int) { return int(instr >> 7) }
+
+func (instr Instr) BX() (bx int) { return int(instr >> 15) }
+
+func (instr Instr) SBX() (sbx int) { return instr.BX() - OffsetSBX }
+
+func (instr Instr) SJ() (sj int) { return int(instr>>7) - OffsetSJ }
+
+func (instr Instr) String() string {
+	return fmt.Sprintf("%s %s", instr.Code(), args(instr))
+}
+
+func args(instr Instr) string {
+	switch code := instr.Code(); code.Mode() {
+	case ModeABC:
+		return fmt.Sprintf("A=%d B=%d C=%d K=%d", instr.A(), instr.B(), instr.C(), instr.K())
+	case ModeABx:
+		return fmt.Sprintf("A=%d BX=%d", instr.A(), instr.BX())
+	case ModeAsBx:
+		return fmt.Sprintf("A=%d SBX=%d", instr.A(), instr.SBX())
+	case ModeAx:
+		return fmt.Sprintf("AX=%d", instr.AX())
+	case ModesJ:
+		return fmt.Sprintf("SJ=%d", instr.SJ())
+	}
+	panic(fmt.Sprintf("ir: unknown op mode: %d", instr.Code().Mode()))
+}
+
+// IntToSC encodes the signed integer i as an sC (or sB) argument.
+func IntToSC(i int) int { return i + OffsetSC }
+
+// MakeABCk returns an instruction for code with arguments A, B, C and k.
+func MakeABCk(code Code, a, b, c, k int) Instr {
+	return Instr(uint32(code) | uint32(a)<<7 | uint32(k)<<15 | uint32(b)<<16 | uint32(c)<<24)
+}
+
+// MakeABC returns an instruction for code with arguments A, B and C.
+func MakeABC(code Code, a, b, c int) Instr { return MakeABCk(code, a, b, c, 0) }
+
+// MakeABx returns an instruction for code with arguments A and Bx.
+func MakeABx(code Code, a, bx int) Instr {
+	return Instr(uint32(code) | uint32(a)<<7 | uint32(bx)<<15)
+}
+
+// MakeAsBx returns an instruction for code with arguments A and sBx.
+func MakeAsBx(code Code, a, sbx int) Instr {
+	return MakeABx(code, a, sbx+OffsetSBX)
+}
+
+// MakeAx returns an instruction for code with argument Ax.
+func MakeAx(code Code, ax int) Instr {
+	return Instr(uint32(code) | uint32(ax)<<7)
+}
+
+// MakesJ returns an instruction for code with argument sJ.
+func MakesJ(code Code, sj int) Instr {
+	return Instr(uint32(code) | uint32(sj+OffsetSJ)<<7)
+}
+
+func (instr Instr) WithCode(code Code) Instr { return instr&^0x7F | Instr(code) }
+
+func (instr Instr) WithA(a int) Instr { return instr&^(0xFF<<7) | Instr(a)<<7 }
+
+func (instr Instr) WithB(b int) Instr { return instr&^(0xFF<<16) | Instr(b)<<16 }
+
+func (instr Instr) WithC(c int) Instr { return instr&^(0xFF<<24) | Instr(c)<<24 }
+
+func (instr Instr) WithK(k int) Instr { return instr&^(1<<15) | Instr(k)<<15 }
+
+func (instr Instr) WithBX(bx int) Instr { return instr&^(0x1FFFF<<15) | Instr(bx)<<15 }
+
+func (instr Instr) WithSBX(sbx int) Instr { return instr.WithBX(sbx + OffsetSBX) }
+
+func (instr Instr) WithSJ(sj int) Instr {
+	return instr&^(0x1FFFFFF<<7) | Instr(sj+OffsetSJ)<<7
+}
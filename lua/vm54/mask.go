@@ -0,0 +1,114 @@
+package vm54
+
+// Mask represents masks for instruction properties.
+//
+// The format is:
+// bits 0-2: op mode
+// bit 3: instruction set register A
+// bit 4: operator is a test (next instruction must be a jump)
+// bit 5: instruction uses 'L->top' set by previous instruction (when B == 0)
+// bit 6: instruction sets 'L->top' for next instruction (when C == 0)
+// bit 7: instruction is an MM instruction (call a metamethod)
+type Mask uint8
+
+var masks = [...]Mask{
+	MOVE:       mask(0, 0, 0, 0, 1, ModeABC),  // MOVE
+	LOADI:      mask(0, 0, 0, 0, 1, ModeAsBx), // LOADI
+	LOADF:      mask(0, 0, 0, 0, 1, ModeAsBx), // LOADF
+	LOADK:      mask(0, 0, 0, 0, 1, ModeABx),  // LOADK
+	LOADKX:     mask(0, 0, 0, 0, 1, ModeABx),  // LOADKX
+	LOADFALSE:  mask(0, 0, 0, 0, 1, ModeABC),  // LOADFALSE
+	LFALSESKIP: mask(0, 0, 0, 0, 1, ModeABC),  // LFALSESKIP
+	LOADTRUE:   mask(0, 0, 0, 0, 1, ModeABC),  // LOADTRUE
+	LOADNIL:    mask(0, 0, 0, 0, 1, ModeABC),  // LOADNIL
+	GETUPVAL:   mask(0, 0, 0, 0, 1, ModeABC),  // GETUPVAL
+	SETUPVAL:   mask(0, 0, 0, 0, 0, ModeABC),  // SETUPVAL
+	GETTABUP:   mask(0, 0, 0, 0, 1, ModeABC),  // GETTABUP
+	GETTABLE:   mask(0, 0, 0, 0, 1, ModeABC),  // GETTABLE
+	GETI:       mask(0, 0, 0, 0, 1, ModeABC),  // GETI
+	GETFIELD:   mask(0, 0, 0, 0, 1, ModeABC),  // GETFIELD
+	SETTABUP:   mask(0, 0, 0, 0, 0, ModeABC),  // SETTABUP
+	SETTABLE:   mask(0, 0, 0, 0, 0, ModeABC),  // SETTABLE
+	SETI:       mask(0, 0, 0, 0, 0, ModeABC),  // SETI
+	SETFIELD:   mask(0, 0, 0, 0, 0, ModeABC),  // SETFIELD
+	NEWTABLE:   mask(0, 0, 0, 0, 1, ModeABC),  // NEWTABLE
+	SELF:       mask(0, 0, 0, 0, 1, ModeABC),  // SELF
+	ADDI:       mask(0, 0, 0, 0, 1, ModeABC),  // ADDI
+	ADDK:       mask(0, 0, 0, 0, 1, ModeABC),  // ADDK
+	SUBK:       mask(0, 0, 0, 0, 1, ModeABC),  // SUBK
+	MULK:       mask(0, 0, 0, 0, 1, ModeABC),  // MULK
+	MODK:       mask(0, 0, 0, 0, 1, ModeABC),  // MODK
+	POWK:       mask(0, 0, 0, 0, 1, ModeABC),  // POWK
+	DIVK:       mask(0, 0, 0, 0, 1, ModeABC),  // DIVK
+	IDIVK:      mask(0, 0, 0, 0, 1, ModeABC),  // IDIVK
+	BANDK:      mask(0, 0, 0, 0, 1, ModeABC),  // BANDK
+	BORK:       mask(0, 0, 0, 0, 1, ModeABC),  // BORK
+	BXORK:      mask(0, 0, 0, 0, 1, ModeABC),  // BXORK
+	SHRI:       mask(0, 0, 0, 0, 1, ModeABC),  // SHRI
+	SHLI:       mask(0, 0, 0, 0, 1, ModeABC),  // SHLI
+	ADD:        mask(0, 0, 0, 0, 1, ModeABC),  // ADD
+	SUB:        mask(0, 0, 0, 0, 1, ModeABC),  // SUB
+	MUL:        mask(0, 0, 0, 0, 1, ModeABC),  // MUL
+	MOD:        mask(0, 0, 0, 0, 1, ModeABC),  // MOD
+	POW:        mask(0, 0, 0, 0, 1, ModeABC),  // POW
+	DIV:        mask(0, 0, 0, 0, 1, ModeABC),  // DIV
+	IDIV:       mask(0, 0, 0, 0, 1, ModeABC),  // IDIV
+	BAND:       mask(0, 0, 0, 0, 1, ModeABC),  // BAND
+	BOR:        mask(0, 0, 0, 0, 1, ModeABC),  // BOR
+	BXOR:       mask(0, 0, 0, 0, 1, ModeABC),  // BXOR
+	SHL:        mask(0, 0, 0, 0, 1, ModeABC),  // SHL
+	SHR:        mask(0, 0, 0, 0, 1, ModeABC),  // SHR
+	MMBIN:      mask(1, 0, 0, 0, 0, ModeABC),  // MMBIN
+	MMBINI:     mask(1, 0, 0, 0, 0, ModeABC),  // MMBINI
+	MMBINK:     mask(1, 0, 0, 0, 0, ModeABC),  // MMBINK
+	UNM:        mask(0, 0, 0, 0, 1, ModeABC),  // UNM
+	BNOT:       mask(0, 0, 0, 0, 1, ModeABC),  // BNOT
+	NOT:        mask(0, 0, 0, 0, 1, ModeABC),  // NOT
+	LEN:        mask(0, 0, 0, 0, 1, ModeABC),  // LEN
+	CONCAT:     mask(0, 0, 0, 0, 1, ModeABC),  // CONCAT
+	CLOSE:      mask(0, 0, 0, 0, 0, ModeABC),  // CLOSE
+	TBC:        mask(0, 0, 0, 0, 0, ModeABC),  // TBC
+	JMP:        mask(0, 0, 0, 0, 0, ModesJ),   // JMP
+	EQ:         mask(0, 0, 0, 1, 0, ModeABC),  // EQ
+	LT:         mask(0, 0, 0, 1, 0, ModeABC),  // LT
+	LE:         mask(0, 0, 0, 1, 0, ModeABC),  // LE
+	EQK:        mask(0, 0, 0, 1, 0, ModeABC),  // EQK
+	EQI:        mask(0, 0, 0, 1, 0, ModeABC),  // EQI
+	LTI:        mask(0, 0, 0, 1, 0, ModeABC),  // LTI
+	LEI:        mask(0, 0, 0, 1, 0, ModeABC),  // LEI
+	GTI:        mask(0, 0, 0, 1, 0, ModeABC),  // GTI
+	GEI:        mask(0, 0, 0, 1, 0, ModeABC),  // GEI
+	TEST:       mask(0, 0, 0, 1, 0, ModeABC),  // TEST
+	TESTSET:    mask(0, 0, 0, 1, 1, ModeABC),  // TESTSET
+	CALL:       mask(0, 1, 1, 0, 1, ModeABC),  // CALL
+	TAILCALL:   mask(0, 1, 1, 0, 1, ModeABC),  // TAILCALL
+	RETURN:     mask(0, 0, 1, 0, 0, ModeABC),  // RETURN
+	RETURN0:    mask(0, 0, 0, 0, 0, ModeABC),  // RETURN0
+	RETURN1:    mask(0, 0, 0, 0, 0, ModeABC),  // RETURN1
+	FORLOOP:    mask(0, 0, 0, 0, 1, ModeABx),  // FORLOOP
+	FORPREP:    mask(0, 0, 0, 0, 1, ModeABx),  // FORPREP
+	TFORPREP:   mask(0, 0, 0, 0, 0, ModeABx),  // TFORPREP
+	TFORCALL:   mask(0, 0, 0, 0, 0, ModeABC),  // TFORCALL
+	TFORLOOP:   mask(0, 0, 0, 0, 1, ModeABx),  // TFORLOOP
+	SETLIST:    mask(0, 0, 1, 0, 0, ModeABC),  // SETLIST
+	CLOSURE:    mask(0, 0, 0, 0, 1, ModeABx),  // CLOSURE
+	VARARG:     mask(0, 1, 0, 0, 1, ModeABC),  // VARARG
+	VARARGPREP: mask(0, 0, 1, 0, 1, ModeABC),  // VARARGPREP
+	EXTRAARG:   mask(0, 0, 0, 0, 0, ModeAx),   // EXTRAARG
+}
+
+func (mask Mask) Mode() Mode { return Mode(mask & 7) }
+
+func (mask Mask) SetA() bool { return mask&(1<<3) != 0 }
+
+func (mask Mask) Test() bool { return mask&(1<<4) != 0 }
+
+func (mask Mask) InTop() bool { return mask&(1<<5) != 0 }
+
+func (mask Mask) OutTop() bool { return mask&(1<<6) != 0 }
+
+func (mask Mask) MetaMethod() bool { return mask&(1<<7) != 0 }
+
+func mask(mm, ot, it, t, a uint8, m Mode) Mask {
+	return Mask(mm<<7 | ot<<6 | it<<5 | t<<4 | a<<3 | uint8(m))
+}
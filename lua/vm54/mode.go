@@ -0,0 +1,21 @@
+package vm54
+
+type Mode uint8
+
+const (
+	ModeABC Mode = iota
+	ModeABx
+	ModeAsBx
+	ModeAx
+	ModesJ
+)
+
+var modes = [...]string{
+	ModeABC:  "ABC",
+	ModeABx:  "ABx",
+	ModeAsBx: "AsBx",
+	ModeAx:   "Ax",
+	ModesJ:   "sJ",
+}
+
+func (mode Mode) String() string { return modes[mode] }
@@ -0,0 +1,286 @@
+package lua
+
+import (
+	"fmt"
+	"strings"
+	"weak"
+)
+
+// weakMode is the weakness of a table, given by the letters 'k' (weak keys)
+// and 'v' (weak values) of the __mode field of its metatable when it is set.
+type weakMode uint8
+
+const (
+	weakKeys weakMode = 1 << iota
+	weakValues
+)
+
+// modeOf returns the weakness given by the metatable meta.
+func modeOf(meta *table) (mode weakMode) {
+	if meta == nil {
+		return 0
+	}
+	if s, ok := meta.getStr(metaMode.ID()).(String); ok {
+		if strings.ContainsRune(string(s), 'k') {
+			mode |= weakKeys
+		}
+		if strings.ContainsRune(string(s), 'v') {
+			mode |= weakValues
+		}
+	}
+	return mode
+}
+
+// weakRef is a weak reference to a table, closure, userdata or thread, held
+// by a weak table in place of the object: p is a weak.Pointer to it. Two
+// references to the same object are equal, so they can be used as keys.
+type weakRef struct{ p interface{} }
+
+func (r weakRef) String() string { return fmt.Sprintf("weak: %v", r.get()) }
+func (r weakRef) Type() Type     { return NoneType }
+
+// makeWeak returns a weak reference to v, or false if v is not collectable:
+// as in Lua, strings, numbers and booleans are never removed from weak tables.
+func makeWeak(v Value) (weakRef, bool) {
+	switch v := v.(type) {
+	case *table:
+		return weakRef{weak.Make(v)}, true
+	case *Closure:
+		return weakRef{weak.Make(v)}, true
+	case *Object:
+		return weakRef{weak.Make(v)}, true
+	case *thread:
+		return weakRef{weak.Make(v)}, true
+	}
+	return weakRef{}, false
+}
+
+// get returns the object referenced by r, or nil if it was collected.
+func (r weakRef) get() Value {
+	switch p := r.p.(type) {
+	case weak.Pointer[table]:
+		if v := p.Value(); v != nil {
+			return v
+		}
+	case weak.Pointer[Closure]:
+		if v := p.Value(); v != nil {
+			return v
+		}
+	case weak.Pointer[Object]:
+		if v := p.Value(); v != nil {
+			return v
+		}
+	case weak.Pointer[thread]:
+		if v := p.Value(); v != nil {
+			return v
+		}
+	}
+	return nil
+}
+
+// ephemerons holds the values of an object for the tables with weak keys in
+// which it is a key, so that each value is reachable only through its key: a
+// value referring to its own key does not keep the entry alive.
+type ephemerons map[weak.Pointer[table]]value
+
+// ephemeronsOf returns the ephemerons of a collectable object, or nil.
+func ephemeronsOf(obj Value) *ephemerons {
+	switch obj := obj.(type) {
+	case *table:
+		return &obj.eph
+	case *Closure:
+		return &obj.eph
+	case *Object:
+		return &obj.eph
+	case *thread:
+		return &obj.eph
+	}
+	return nil
+}
+
+// set sets the value of the object for the table t, dropping the values of
+// tables which were collected each time the ephemerons double.
+func (eph *ephemerons) set(t weak.Pointer[table], v value) {
+	if *eph == nil {
+		*eph = make(ephemerons)
+	}
+	if n := len(*eph); n >= 8 && n&(n-1) == 0 {
+		for t := range *eph {
+			if t.Value() == nil {
+				delete(*eph, t)
+			}
+		}
+	}
+	(*eph)[t] = v
+}
+
+// weakTable holds the entries of a table with weak keys or values (see
+// table.weak), which has no array part. Its slots are kept in the order the
+// keys were added, for traversals, as the nodes of the hash part are; the
+// entries collected are skipped, and their slots dropped when the slots are
+// full.
+type weakTable struct {
+	mode  weakMode
+	self  weak.Pointer[table]
+	index map[Value]int // index of the slot of each key
+	slots []node
+}
+
+// key returns the key of the slot of k: a weak reference if k is a collectable
+// key of a table with weak keys.
+func (w *weakTable) key(k Value) Value {
+	if w.mode&weakKeys != 0 {
+		if r, ok := makeWeak(k); ok {
+			return r
+		}
+	}
+	return k
+}
+
+// load returns the key and value of slot i, or nil and none if its entry was
+// removed or collected.
+func (w *weakTable) load(i int) (Value, value) {
+	key, val := w.slots[i].key, w.slots[i].val
+	if r, ok := key.(weakRef); ok {
+		if key = r.get(); key == nil {
+			return nil, none
+		}
+		val = (*ephemeronsOf(key))[w.self]
+	}
+	if r, ok := val.v.(weakRef); ok {
+		if val = (value{v: r.get()}); val.v == nil {
+			return nil, none
+		}
+	}
+	if val.isNone() {
+		return nil, none
+	}
+	return key, val
+}
+
+// store sets the value of slot i, of key k, to v; through a weak reference
+// if the table has weak values.
+func (w *weakTable) store(i int, k Value, v value) {
+	if w.mode&weakValues != 0 {
+		if r, ok := makeWeak(v.v); ok {
+			v = value{v: r}
+		}
+	}
+	if _, ok := w.slots[i].key.(weakRef); ok {
+		eph := ephemeronsOf(k)
+		if v.isNone() {
+			delete(*eph, w.self)
+		} else {
+			eph.set(w.self, v)
+		}
+		return
+	}
+	w.slots[i].val = v
+}
+
+func (w *weakTable) get(k Value) value {
+	if i, ok := w.index[w.key(k)]; ok {
+		_, v := w.load(i)
+		return v
+	}
+	return none
+}
+
+// set sets the value of the key k, a hash key (see hashKey), to v, and reports
+// whether a slot was added.
+func (w *weakTable) set(k Value, v value) bool {
+	key := w.key(k)
+	if i, ok := w.index[key]; ok {
+		w.store(i, k, v)
+		return false
+	}
+	if v.isNone() {
+		return false
+	}
+	if len(w.slots) == cap(w.slots) {
+		w.sweep()
+	}
+	w.index[key] = len(w.slots)
+	w.slots = append(w.slots, node{key: key})
+	w.store(len(w.slots)-1, k, v)
+	return true
+}
+
+// sweep drops the slots of the entries removed or collected.
+func (w *weakTable) sweep() {
+	slots := w.slots[:0]
+	for i, n := range w.slots {
+		if key, _ := w.load(i); key == nil {
+			delete(w.index, n.key)
+			continue
+		}
+		w.index[n.key] = len(slots)
+		slots = append(slots, n)
+	}
+	clear(w.slots[len(slots):])
+	w.slots = slots
+}
+
+// clearValues removes the entries whose values are objects not seen by the
+// count of the memory in use (see measure), as they are no longer reachable.
+func (w *weakTable) clearValues(seen map[Value]bool) {
+	for i := range w.slots {
+		if k, v := w.load(i); k != nil {
+			if _, ok := makeWeak(v.v); ok && !seen[v.v] {
+				w.store(i, k, none)
+			}
+		}
+	}
+}
+
+// next returns the entry that follows key in a traversal, as table.next does.
+func (w *weakTable) next(state *State, key Value) (Value, Value, bool) {
+	i := 0
+	if !IsNone(key) {
+		var ok bool
+		if i, ok = w.index[w.key(key)]; !ok {
+			state.errorf("invalid key to 'next'")
+		}
+		i++
+	}
+	for ; i < len(w.slots); i++ {
+		if k, v := w.load(i); k != nil {
+			return k, v.box(), true
+		}
+	}
+	return None, None, false
+}
+
+// setMode sets the weakness of the table t, moving its entries between its
+// array and hash parts, and its weak table.
+func (t *table) setMode(mode weakMode) {
+	if t.weak == nil && mode == 0 || t.weak != nil && t.weak.mode == mode {
+		return
+	}
+	type entry struct {
+		k Value
+		v value
+	}
+	var entries []entry
+	t.ForEach(func(k, v Value) {
+		entries = append(entries, entry{k, unbox(v)})
+	})
+	if w := t.weak; w != nil {
+		for _, e := range entries {
+			w.set(e.k, none)
+		}
+	}
+	t.list, t.nodes, t.hsize, t.weak = nil, nil, 0, nil
+	t.hash = make(map[Value]int)
+	if mode != 0 {
+		t.weak = &weakTable{
+			mode:  mode,
+			self:  weak.Make(t),
+			index: make(map[Value]int),
+		}
+	}
+	for _, e := range entries {
+		t.set(unbox(e.k), e.v)
+	}
+	t.version++
+}
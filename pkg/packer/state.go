@@ -21,7 +21,7 @@ L:
 		switch opt := s.nextOpt(); opt.typ {
 		case optErr:
 			s.drain()
-			return nil, fmt.Errorf(opt.value)
+			return nil, fmt.Errorf("%s", opt.value)
 		case optEnd:
 			break L
 		default:
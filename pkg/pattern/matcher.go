@@ -15,8 +15,14 @@ type pattern struct {
 	inst []instr
 	head bool
 	tail bool
+	step func()
 }
 
+// OnStep sets a function called at each step of the matcher, including the
+// steps taken again when it backtracks; it may panic to stop a match which
+// takes too long.
+func (patt *Pattern) OnStep(step func()) { patt.step = step }
+
 func (patt *pattern) MatchIndexAll(src string, limit int) (captures [][]int) {
 	for start, count := 0, 0; start <= len(src); start++ {
 		if end, match := patt.match(src, start, 0); match {
@@ -78,6 +84,9 @@ func (patt *pattern) Match(src string) (captures []string) {
 
 func (patt *pattern) match(src string, sp, ip int) (pos int, ok bool) {
 	for {
+		if patt.step != nil {
+			patt.step()
+		}
 		switch inst := patt.inst[ip]; inst.code {
 		case opClass:
 			traceVM(src, sp, ip, inst)
@@ -110,5 +119,4 @@ func (patt *pattern) match(src string, sp, ip int) (pos int, ok bool) {
 			ip = inst.x
 		}
 	}
-	panic("unreachable")
 }
@@ -109,11 +109,16 @@ func GsubStr(text, expr, replace string) (repl string, count int) {
 // %d, with 1 <= d <= 9, stands for the value of the d-th capture substring. The sequence
 // %0 stands for the whole match. The sequence %% stands for a single escaped %.
 func GsubStrAll(text, expr, replace string, limit int) (repl string, count int) {
+	return GsubStrPattern(text, pattern.MustCompile(expr), replace, limit)
+}
+
+// GsubStrPattern is like GsubStrAll, except that the pattern is already compiled.
+func GsubStrPattern(text string, patt *pattern.Pattern, replace string, limit int) (repl string, count int) {
 	var (
 		b strings.Builder
 		i = 0
 	)
-	for _, caps := range pattern.MatchIndexAll(text, expr, limit) {
+	for _, caps := range patt.MatchIndexAll(text, limit) {
 		gsub := capRE.ReplaceAllStringFunc(replace, func(k string) string {
 			if i := k[1] - '0'; 0 <= i && i <= 9 {
 				// TODO: check that i is valid capture index
@@ -3,7 +3,6 @@ package base
 import (
 	"fmt"
 	"os"
-	"runtime"
 	"strconv"
 	"strings"
 
@@ -43,6 +42,16 @@ func Open(state *lua.State) int {
 		"xpcall":         lua.Func(baseXpcall),
 		"collectgarbage": lua.Func(baseGC),
 	}
+	if state.LuaVersion() == lua.V54 {
+		baseFuncs["warn"] = lua.Func(baseWarn)
+	}
+
+	// Install the default warning function, unless the host set one.
+	if warnFn := state.SetWarnFunc(nil); warnFn != nil {
+		state.SetWarnFunc(warnFn)
+	} else {
+		state.SetWarnFunc(newWarnFunc())
+	}
 
 	// Open base library into globals table.
 	state.PushGlobals()
@@ -53,7 +62,7 @@ func Open(state *lua.State) int {
 	state.SetField(-2, "_G")
 
 	// Set global _VERSION.
-	state.Push(lua.Version)
+	state.Push(state.LuaVersion().String())
 	state.SetField(-2, "_VERSION")
 
 	return 1
@@ -93,13 +102,18 @@ func baseDoFile(state *lua.State) int {
 	)
 	state.SetTop(1)
 	if file == "" {
-		file = "stdin"
+		file = "=stdin"
 		src = os.Stdin
 	}
 	if err := state.LoadChunk(file, src, 0); err != nil {
 		panic(err)
 	}
-	state.Call(0, lua.MultRets)
+	return state.CallK(0, lua.MultRets, nil, finishDoFile)
+}
+
+// finishDoFile returns the results of the chunk run by dofile, which may
+// have yielded.
+func finishDoFile(state *lua.State, status lua.ThreadStatus, ctx interface{}) int {
 	return state.Top() - 1
 }
 
@@ -214,7 +228,7 @@ func baseLoad(state *lua.State) int {
 	}
 	chunk, ok := state.TryString(1)
 	if ok && chunk != "" { // loading a string?
-		name = state.OptString(2, "")
+		name = state.OptString(2, chunk)
 	} else {
 		// otherwise loading from a reader
 		name = state.OptString(3, "=(load)")
@@ -291,9 +305,15 @@ func basePairs(state *lua.State) int {
 //
 // See https://www.lua.org/manual/5.3/manual.html#pdf-pcall
 func basePCall(state *lua.State) int {
-	if err := state.PCall(state.Top()-1, -1, 0); err != nil {
+	return state.PCallK(state.Top()-1, -1, 0, nil, finishPCall)
+}
+
+// finishPCall returns the results of the function called by pcall, which
+// may have yielded, after its status.
+func finishPCall(state *lua.State, status lua.ThreadStatus, ctx interface{}) int {
+	if status == lua.ThreadError { // error message on top
 		state.Push(false)
-		state.Push(err.Error())
+		state.Insert(-2)
 		return 2
 	}
 	state.Push(true)
@@ -539,32 +559,105 @@ func baseType(state *lua.State) int {
 //
 //  "isrunning": returns a boolean that tells whether the collector is running (i.e., not stopped).
 //
+// In Lua 5.4, "incremental" sets the pause and step multiplier given by the
+// next arguments, when not zero, and "generational" is accepted; both return
+// "incremental", the only mode of the collector.
+//
 // See https://www.lua.org/manual/5.3/manual.html#pdf-collectgarbage
 func baseGC(state *lua.State) int {
-	// TODO: finish him
 	switch opt := state.OptString(1, "collect"); opt {
-	// case "count":
-	// case "stop":
-	// case "restart":
-	// case "setpause":
-	// case "setstepmul":
-	// case "isrunning":
 	case "collect":
-		runtime.GC()
+		state.CollectGarbage()
+		state.Push(0)
+	case "stop":
+		state.StopGC()
+		state.Push(0)
+	case "restart":
+		state.RestartGC()
 		state.Push(0)
+	case "count":
+		n := state.MemoryUsage()
+		state.Push(float64(n>>10) + float64(n&0x3ff)/1024)
 	case "step":
-		runtime.GC()
-		state.Push(true)
+		state.Push(state.StepGC(int(state.OptInt(2, 0))))
+	case "setpause":
+		state.Push(state.SetGCPause(int(state.OptInt(2, 0))))
+	case "setstepmul":
+		state.Push(state.SetGCStepMul(int(state.OptInt(2, 0))))
+	case "isrunning":
+		state.Push(state.IsGCRunning())
+	case "incremental", "generational":
+		if state.LuaVersion() != lua.V54 {
+			return state.ArgError(1, fmt.Sprintf("invalid option '%s'", opt))
+		}
+		if opt == "incremental" {
+			if pause := state.OptInt(2, 0); pause != 0 {
+				state.SetGCPause(int(pause))
+			}
+			if stepmul := state.OptInt(3, 0); stepmul != 0 {
+				state.SetGCStepMul(int(stepmul))
+			}
+		}
+		state.Push("incremental")
 	default:
-		state.Push(-1)
+		return state.ArgError(1, fmt.Sprintf("invalid option '%s'", opt))
 	}
 	return 1
 }
 
+// warn(msg1, ...)
+//
+// Emits a warning with a message composed by the concatenation of all its
+// arguments (which should be strings), through the warning function of the
+// state (see lua.State.SetWarnFunc), one piece per argument.
+//
+// See https://www.lua.org/manual/5.4/manual.html#pdf-warn
+func baseWarn(state *lua.State) int {
+	n := state.Top()
+	state.CheckString(1) // at least one argument
+	for i := 2; i <= n; i++ {
+		state.CheckString(i)
+	}
+	for i := 1; i <= n; i++ {
+		state.Warn(state.ToString(i), i < n)
+	}
+	return 0
+}
+
+// newWarnFunc returns the default warning function, with its own on/off
+// switch. By convention, a message in one piece starting with '@' is a
+// control message: "@off" stops the emission of warnings and "@on" (re)starts
+// it; other control messages are ignored. Warnings are off by default and are
+// written to the standard error.
+func newWarnFunc() lua.WarnFunc {
+	var on, cont bool // cont: the previous piece is continued
+	return func(msg string, tocont bool) {
+		if !cont && !tocont && strings.HasPrefix(msg, "@") {
+			switch msg {
+			case "@on":
+				on = true
+			case "@off":
+				on = false
+			}
+			return
+		}
+		if on {
+			if !cont {
+				fmt.Fprint(os.Stderr, "Lua warning: ")
+			}
+			fmt.Fprint(os.Stderr, msg)
+			if !tocont {
+				fmt.Fprintln(os.Stderr)
+			}
+		}
+		cont = tocont
+	}
+}
+
 // See https://www.lua.org/manual/5.3/manual.html#pdf-xpcall
 func baseXpcall(state *lua.State) int {
 	unimplemented("base: xpcall")
 	return 0
 }
 
-func unimplemented(msg string) { panic(fmt.Errorf(msg)) }
+func unimplemented(msg string) { panic(fmt.Errorf("%s", msg)) }
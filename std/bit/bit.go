@@ -0,0 +1,194 @@
+package bit
+
+import (
+	"math"
+	"math/bits"
+
+	"github.com/Azure/golua/lua"
+)
+
+//
+// Lua BitOp -- bit
+//
+
+// Open opens the LuaJIT compatible bit library. This library provides
+// bitwise operations on 32-bit integers; numbers passed as arguments
+// are normalized to the range of a signed 32-bit integer, and so are
+// the results.
+//
+// See http://bitop.luajit.org/api.html
+func Open(state *lua.State) int {
+	// Create 'bit' table
+	var bitFuncs = map[string]lua.Func{
+		"tobit":   lua.Func(bitToBit),
+		"tohex":   lua.Func(bitToHex),
+		"bnot":    lua.Func(bitNot),
+		"band":    lua.Func(bitAnd),
+		"bor":     lua.Func(bitOr),
+		"bxor":    lua.Func(bitXor),
+		"lshift":  lua.Func(bitLShift),
+		"rshift":  lua.Func(bitRShift),
+		"arshift": lua.Func(bitARShift),
+		"rol":     lua.Func(bitRol),
+		"ror":     lua.Func(bitRor),
+		"bswap":   lua.Func(bitSwap),
+	}
+	state.NewTableSize(0, len(bitFuncs))
+	state.SetFuncs(bitFuncs, 0)
+
+	// Return 'bit' table
+	return 1
+}
+
+// bit.tobit (x)
+//
+// Normalizes a number to the numeric range for bit operations and
+// returns it.
+func bitToBit(state *lua.State) int {
+	state.Push(int64(checkBit(state, 1)))
+	return 1
+}
+
+// bit.tohex (x [,n])
+//
+// Converts its first argument to a hex string. The number of hex digits
+// is given by the absolute value of the optional second argument.
+// Positive numbers between 1 and 8 generate lowercase hex digits.
+// Negative numbers generate uppercase hex digits. Only the least
+// significant 4*|n| bits are used. The default is to generate 8
+// lowercase hex digits.
+func bitToHex(state *lua.State) int {
+	var (
+		x      = uint32(checkBit(state, 1))
+		n      = int32(8)
+		digits = "0123456789abcdef"
+	)
+	if !state.IsNoneOrNil(2) {
+		n = checkBit(state, 2)
+	}
+	if n < 0 {
+		n, digits = -n, "0123456789ABCDEF"
+	}
+	if n > 8 {
+		n = 8
+	}
+	buf := make([]byte, n)
+	for i := n - 1; i >= 0; i-- {
+		buf[i] = digits[x&15]
+		x >>= 4
+	}
+	state.Push(string(buf))
+	return 1
+}
+
+// bit.bnot (x)
+//
+// Returns the bitwise not of its argument.
+func bitNot(state *lua.State) int {
+	state.Push(int64(^checkBit(state, 1)))
+	return 1
+}
+
+// bit.band (x1 [,x2...])
+//
+// Returns the bitwise and of all of its arguments.
+func bitAnd(state *lua.State) int {
+	return fold(state, func(x, y int32) int32 { return x & y })
+}
+
+// bit.bor (x1 [,x2...])
+//
+// Returns the bitwise or of all of its arguments.
+func bitOr(state *lua.State) int {
+	return fold(state, func(x, y int32) int32 { return x | y })
+}
+
+// bit.bxor (x1 [,x2...])
+//
+// Returns the bitwise xor of all of its arguments.
+func bitXor(state *lua.State) int {
+	return fold(state, func(x, y int32) int32 { return x ^ y })
+}
+
+// bit.lshift (x, n)
+//
+// Returns the bitwise logical left-shift of its first argument by the
+// number of bits given by the second argument. Only the lower 5 bits of
+// the shift count are used.
+func bitLShift(state *lua.State) int {
+	return shift(state, func(x uint32, n uint) uint32 { return x << n })
+}
+
+// bit.rshift (x, n)
+//
+// Returns the bitwise logical right-shift of its first argument by the
+// number of bits given by the second argument.
+func bitRShift(state *lua.State) int {
+	return shift(state, func(x uint32, n uint) uint32 { return x >> n })
+}
+
+// bit.arshift (x, n)
+//
+// Returns the bitwise arithmetic right-shift of its first argument by
+// the number of bits given by the second argument.
+func bitARShift(state *lua.State) int {
+	return shift(state, func(x uint32, n uint) uint32 { return uint32(int32(x) >> n) })
+}
+
+// bit.rol (x, n)
+//
+// Returns the bitwise left rotation of its first argument by the number
+// of bits given by the second argument.
+func bitRol(state *lua.State) int {
+	return shift(state, func(x uint32, n uint) uint32 { return bits.RotateLeft32(x, int(n)) })
+}
+
+// bit.ror (x, n)
+//
+// Returns the bitwise right rotation of its first argument by the number
+// of bits given by the second argument.
+func bitRor(state *lua.State) int {
+	return shift(state, func(x uint32, n uint) uint32 { return bits.RotateLeft32(x, -int(n)) })
+}
+
+// bit.bswap (x)
+//
+// Swaps the bytes of its argument and returns it. This can be used to
+// convert little-endian 32-bit numbers to big-endian 32-bit numbers or
+// vice versa.
+func bitSwap(state *lua.State) int {
+	state.Push(int64(int32(bits.ReverseBytes32(uint32(checkBit(state, 1))))))
+	return 1
+}
+
+// fold applies op to all arguments from left to right and pushes the result.
+func fold(state *lua.State, op func(x, y int32) int32) int {
+	x := checkBit(state, 1)
+	for i := 2; i <= state.Top(); i++ {
+		x = op(x, checkBit(state, i))
+	}
+	state.Push(int64(x))
+	return 1
+}
+
+// shift applies op to the first argument and the lower 5 bits of the second
+// argument and pushes the result.
+func shift(state *lua.State, op func(x uint32, n uint) uint32) int {
+	var (
+		x = uint32(checkBit(state, 1))
+		n = uint(checkBit(state, 2) & 31)
+	)
+	state.Push(int64(int32(op(x, n))))
+	return 1
+}
+
+// checkBit checks whether the argument at index is a number and returns it
+// normalized to a signed 32-bit integer, i.e. modulo 2^32. Floats are first
+// rounded to the nearest integer.
+func checkBit(state *lua.State, index int) int32 {
+	if i, ok := state.TryInt(index); ok {
+		return int32(uint32(i))
+	}
+	f := math.RoundToEven(state.CheckNumber(index))
+	return int32(uint32(int64(math.Mod(f, 1<<32))))
+}
@@ -0,0 +1,291 @@
+package compat
+
+import (
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/Azure/golua/lua"
+)
+
+//
+// Lua 5.1 / LuaJIT compatibility
+//
+
+// Open installs the Lua 5.1 compatibility functions into the standard
+// libraries, which must already be open: setfenv, getfenv, module,
+// unpack and loadstring into the globals table, package.seeall,
+// table.getn and math.pow.
+//
+// Function environments are emulated with the _ENV upvalue of Lua
+// functions, so functions that do not access any global have no
+// environment.
+//
+// See https://www.lua.org/manual/5.1/manual.html#5
+func Open(state *lua.State) int {
+	var baseFuncs = map[string]lua.Func{
+		"getfenv": lua.Func(baseGetFenv),
+		"setfenv": lua.Func(baseSetFenv),
+		"module":  lua.Func(baseModule),
+	}
+	state.PushGlobals()
+	state.SetFuncs(baseFuncs, 0)
+
+	// unpack and loadstring are the 5.3 table.unpack and load.
+	alias(state, "table", "unpack", "unpack")
+	alias(state, "_G", "load", "loadstring")
+	state.Pop()
+
+	install(state, "package", "seeall", pkgSeeAll)
+	install(state, "table", "getn", tableGetN)
+	install(state, "math", "pow", mathPow)
+	return 0
+}
+
+// alias sets field name of the table on top of the stack to the
+// field of the global library lib.
+func alias(state *lua.State, lib, field, name string) {
+	state.GetGlobal(lib)
+	state.GetField(-1, field)
+	state.SetField(-3, name)
+	state.Pop()
+}
+
+// install sets fn as field name of the global library lib.
+func install(state *lua.State, lib, name string, fn lua.Func) {
+	if state.GetGlobal(lib) != lua.TableType {
+		panic(fmt.Errorf("compat: library %q is not open", lib))
+	}
+	state.Push(fn)
+	state.SetField(-2, name)
+	state.Pop()
+}
+
+// getfenv ([f])
+//
+// Returns the current environment in use by the function. f can be a Lua
+// function or a number that specifies the function at that stack level:
+// Level 1 is the function calling getfenv. If the given function is not
+// a Lua function, or if f is 0, getfenv returns the global environment.
+// The default for f is 1.
+//
+// See https://www.lua.org/manual/5.1/manual.html#pdf-getfenv
+func baseGetFenv(state *lua.State) int {
+	pushFunc(state, 1)
+	if up := envIndex(state, -1); up != 0 {
+		state.GetUpValue(-1, up)
+	} else {
+		state.PushGlobals()
+	}
+	return 1
+}
+
+// setfenv (f, table)
+//
+// Sets the environment to be used by the given function. f can be a Lua
+// function or a number that specifies the function at that stack level:
+// Level 1 is the function calling setfenv. setfenv returns the given
+// function.
+//
+// As a special case, when f is 0 setfenv changes the global environment
+// of the running state. In this case, setfenv returns no values.
+//
+// See https://www.lua.org/manual/5.1/manual.html#pdf-setfenv
+func baseSetFenv(state *lua.State) int {
+	state.CheckType(2, lua.TableType)
+	if state.IsNumber(1) && state.ToInt(1) == 0 {
+		state.PushIndex(2)
+		state.RawSetIndex(lua.RegistryIndex, lua.GlobalsIndex)
+		return 0
+	}
+	pushFunc(state, 0)
+	if !setEnv(state, state.AbsIndex(-1), 2) {
+		return state.Errorf("'setfenv' cannot change environment of given object")
+	}
+	return 1
+}
+
+// module (name [, ...])
+//
+// Creates a module. If there is a table in package.loaded[name], this
+// table is the module. Otherwise, if there is a global table t with the
+// given name, this table is the module. Otherwise creates a new table t
+// and sets it as the value of the global name and the value of
+// package.loaded[name]. This function also initializes t._NAME with the
+// given name, t._M with the module (t itself), and t._PACKAGE with the
+// package name (the full module name minus last component). Finally,
+// module sets t as the new environment of the current function and the
+// new value of package.loaded[name], so that require returns t.
+//
+// This function can receive optional options after the module name,
+// where each option is a function to be applied over the module.
+//
+// See https://www.lua.org/manual/5.1/manual.html#pdf-module
+func baseModule(state *lua.State) int {
+	var (
+		name = state.CheckString(1)
+		last = state.Top()
+	)
+	pushModule(state, name)
+	if state.GetField(-1, "_NAME"); state.IsNoneOrNil(-1) {
+		state.Pop()
+		state.PushIndex(-1)
+		state.SetField(-2, "_M") // module._M = module
+		state.Push(name)
+		state.SetField(-2, "_NAME")
+		state.Push(name[:strings.LastIndexByte(name, '.')+1])
+		state.SetField(-2, "_PACKAGE")
+	} else {
+		state.Pop()
+	}
+	module := state.AbsIndex(-1)
+
+	// Set the module as the environment of the caller.
+	var debug lua.Debug
+	if state.GetStack(&debug, 1) != nil || state.GetInfo(&debug, "f") != nil || !setEnv(state, state.AbsIndex(-1), module) {
+		return state.Errorf("'module' not called from a Lua function")
+	}
+	state.Pop()
+
+	// Apply the options.
+	for i := 2; i <= last; i++ {
+		state.PushIndex(i)
+		state.PushIndex(module)
+		state.Call(1, 0)
+	}
+	return 1
+}
+
+// package.seeall (module)
+//
+// Sets a metatable for module with its __index field referring to the
+// global environment, so that this module inherits values from the
+// global environment. To be used as an option to function module.
+//
+// See https://www.lua.org/manual/5.1/manual.html#pdf-package.seeall
+func pkgSeeAll(state *lua.State) int {
+	state.CheckType(1, lua.TableType)
+	if !state.GetMetaTableAt(1) {
+		state.NewTableSize(0, 1)
+		state.PushIndex(-1)
+		state.SetMetaTableAt(1)
+	}
+	state.PushGlobals()
+	state.SetField(-2, "__index") // mt.__index = _G
+	return 0
+}
+
+// table.getn (table)
+//
+// Returns the size of a table, i.e. its border without invoking the
+// __len metamethod.
+//
+// See https://www.lua.org/manual/5.1/manual.html#pdf-table.getn
+func tableGetN(state *lua.State) int {
+	state.CheckType(1, lua.TableType)
+	state.Push(state.RawLen(1))
+	return 1
+}
+
+// math.pow (x, y)
+//
+// Returns x^y. (You can also use the expression x^y to compute this value.)
+//
+// See https://www.lua.org/manual/5.1/manual.html#pdf-math.pow
+func mathPow(state *lua.State) int {
+	state.Push(math.Pow(state.CheckNumber(1), state.CheckNumber(2)))
+	return 1
+}
+
+// pushFunc pushes the function argument of getfenv or setfenv: either the
+// function at index 1 itself or the function running at the stack level
+// given by it; if opt > 0 the level is optional and defaults to opt.
+func pushFunc(state *lua.State, opt int64) {
+	if state.IsFunc(1) {
+		state.PushIndex(1)
+		return
+	}
+	var level int64
+	if opt > 0 {
+		level = state.OptInt(1, opt)
+	} else {
+		level = state.CheckInt(1)
+	}
+	state.ArgCheck(level >= 0, 1, "level must be non-negative")
+	var debug lua.Debug
+	if state.GetStack(&debug, int(level)) != nil {
+		state.ArgError(1, "invalid level")
+	}
+	if err := state.GetInfo(&debug, "f"); err != nil {
+		panic(err)
+	}
+}
+
+// pushModule pushes the table of the module name, creating it as the
+// global name (which may be a dotted path) if it does not exist yet, and
+// records it as package.loaded[name].
+func pushModule(state *lua.State, name string) {
+	state.GetSubTable(lua.RegistryIndex, lua.LoadedKey)
+	if state.GetField(-1, name) != lua.TableType {
+		state.Pop()
+		state.PushGlobals()
+		for _, field := range strings.Split(name, ".") {
+			switch state.GetField(-1, field) {
+			case lua.NilType, lua.NoneType:
+				state.Pop()
+				state.NewTable()
+				state.PushIndex(-1)
+				state.SetField(-3, field)
+			case lua.TableType:
+			default:
+				state.Errorf("name conflict for module '%s'", name)
+			}
+			state.Remove(-2)
+		}
+		state.PushIndex(-1)
+		state.SetField(-3, name) // LOADED[name] = module
+	}
+	state.Remove(-2) // remove LOADED table
+}
+
+// envIndex returns the index of the _ENV upvalue of the function at
+// index fn, or 0 if it has none.
+func envIndex(state *lua.State, fn int) int {
+	if state.IsGoFunc(fn) {
+		return 0
+	}
+	fn = state.AbsIndex(fn)
+	for up := 1; ; up++ {
+		top := state.Top()
+		name := state.GetUpValue(fn, up)
+		if state.Top() == top {
+			return 0
+		}
+		state.SetTop(top)
+		if name == "_ENV" {
+			return up
+		}
+	}
+}
+
+// setEnv makes the table at index env the environment of the function at
+// index fn, reporting whether the function has an environment.
+//
+// The _ENV upvalue of a function is usually shared with the chunk that
+// defines it, so rather than assigning to it setEnv joins it with a fresh
+// upvalue holding env; the other functions of the chunk are unaffected.
+func setEnv(state *lua.State, fn, env int) bool {
+	up := envIndex(state, fn)
+	if up == 0 {
+		return false
+	}
+	// An empty main chunk has a single, unshared _ENV upvalue.
+	if err := state.LoadChunk("=setfenv", "", lua.TextMode); err != nil {
+		panic(err)
+	}
+	state.PushIndex(env)
+	state.SetUpValue(-2, 1)
+	state.UpValueJoin(fn, up, -1, 1)
+	state.Pop()
+	return true
+}
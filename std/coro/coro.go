@@ -10,6 +10,12 @@ import (
 // Lua Standard Library -- coroutine
 //
 
+// Open opens the Lua standard coroutine library.
+//
+// This library comprises the operations to manipulate coroutines, which
+// come inside the table coroutine.
+//
+// See https://www.lua.org/manual/5.3/manual.html#6.2
 func Open(state *lua.State) int {
 	// Create 'coroutine' table.
 	var coroutineFuncs = map[string]lua.Func{
@@ -21,46 +27,204 @@ func Open(state *lua.State) int {
 		"yield":       lua.Func(coroutineYield),
 		"isyieldable": lua.Func(coroutineIsYieldable),
 	}
-	state.NewTableSize(0, 7)
+	if state.LuaVersion() == lua.V54 {
+		coroutineFuncs["close"] = lua.Func(coroutineClose)
+	}
+	state.NewTableSize(0, len(coroutineFuncs))
 	state.SetFuncs(coroutineFuncs, 0)
 
 	// Return 'coroutine' table.
 	return 1
 }
 
+// coroutine.create (f)
+//
+// Creates a new coroutine, with body f. f must be a function. Returns this
+// new coroutine, an object with type "thread".
+//
+// See https://www.lua.org/manual/5.3/manual.html#pdf-coroutine.create
 func coroutineCreate(state *lua.State) int {
-	unimplemented("coroutine")
-	return 0
+	state.CheckType(1, lua.FuncType)
+	co := state.NewThread()
+	state.PushIndex(1) // move function to top
+	state.XMove(co, 1) // move function from state to co
+	return 1
 }
 
+// coroutine.resume (co [, val1, ···])
+//
+// Starts or continues the execution of coroutine co. The first time you
+// resume a coroutine, it starts running its body. The values val1, ... are
+// passed as the arguments to the body function. If the coroutine has yielded,
+// resume restarts it; the values val1, ... are passed as the results from the
+// yield.
+//
+// If the coroutine runs without any errors, resume returns true plus any
+// values passed to yield (when the coroutine yields) or any values returned
+// by the body function (when the coroutine terminates). If there is any
+// error, resume returns false plus the error object.
+//
+// See https://www.lua.org/manual/5.3/manual.html#pdf-coroutine.resume
 func coroutineResume(state *lua.State) int {
-	unimplemented("coroutine")
-	return 0
+	co := getco(state)
+	n, err := auxResume(state, co, state.Top()-1)
+	if err != nil {
+		state.Push(false)
+		state.PushError(err)
+		return 2 // return false + error object
+	}
+	state.Push(true)
+	state.Insert(-(n + 1))
+	return n + 1 // return true + 'resume' returns
 }
 
+// coroutine.running ()
+//
+// Returns the running coroutine plus a boolean, true when the running
+// coroutine is the main one.
+//
+// See https://www.lua.org/manual/5.3/manual.html#pdf-coroutine.running
 func coroutineRunning(state *lua.State) int {
-	unimplemented("coroutine")
-	return 0
+	ismain := state.PushThread()
+	state.Push(ismain)
+	return 2
 }
 
+// coroutine.status (co)
+//
+// Returns the status of coroutine co, as a string: "running", if the
+// coroutine is running (that is, it called status); "suspended", if the
+// coroutine is suspended in a call to yield, or if it has not started
+// running yet; "normal" if the coroutine is active but not running (that
+// is, it has resumed another coroutine); and "dead" if the coroutine has
+// finished its body function, or if it has stopped with an error.
+//
+// See https://www.lua.org/manual/5.3/manual.html#pdf-coroutine.status
 func coroutineStatus(state *lua.State) int {
-	unimplemented("coroutine")
-	return 0
+	state.Push(auxStatus(state, getco(state)))
+	return 1
 }
 
+// coroutine.wrap (f)
+//
+// Creates a new coroutine, with body f. f must be a function. Returns a
+// function that resumes the coroutine each time it is called. Any arguments
+// passed to the function behave as the extra arguments to resume. Returns
+// the same values returned by resume, except the first boolean. In case of
+// error, propagates the error.
+//
+// See https://www.lua.org/manual/5.3/manual.html#pdf-coroutine.wrap
 func coroutineWrap(state *lua.State) int {
-	unimplemented("coroutine")
-	return 0
+	coroutineCreate(state)
+	state.PushClosure(auxWrap, 1) // the coroutine is its upvalue
+	return 1
+}
+
+// auxWrap resumes the coroutine of a function returned by coroutine.wrap.
+func auxWrap(state *lua.State) int {
+	co := state.ToThread(lua.UpValueIndex(1))
+	n, err := auxResume(state, co, state.Top())
+	if err != nil {
+		if state.LuaVersion() == lua.V54 && co.Status() == lua.ThreadError {
+			co.CloseThread() // close its tbc variables
+		}
+		panic(err) // propagate error
+	}
+	return n
 }
 
+// coroutine.yield (···)
+//
+// Suspends the execution of the calling coroutine. Any arguments to yield
+// are passed as extra results to resume.
+//
+// See https://www.lua.org/manual/5.3/manual.html#pdf-coroutine.yield
 func coroutineYield(state *lua.State) int {
-	unimplemented("coroutine")
-	return 0
+	return state.Yield(state.Top())
 }
 
+// coroutine.isyieldable ()
+//
+// Returns true when the running coroutine can yield. A running coroutine
+// is yieldable if it is not the main thread and it is not inside a
+// non-yieldable C function. In Lua 5.4 the coroutine to check may be
+// given, and defaults to the running one.
+//
+// See https://www.lua.org/manual/5.3/manual.html#pdf-coroutine.isyieldable
 func coroutineIsYieldable(state *lua.State) int {
-	unimplemented("coroutine")
-	return 0
+	co := state
+	if state.LuaVersion() == lua.V54 && !state.IsNone(1) {
+		co = getco(state)
+	}
+	state.Push(co.IsYieldable())
+	return 1
+}
+
+// coroutine.close (co)
+//
+// Closes coroutine co, that is, closes all its pending to-be-closed
+// variables and puts the coroutine in a dead state (Lua 5.4).
+//
+// See https://www.lua.org/manual/5.4/manual.html#pdf-coroutine.close
+func coroutineClose(state *lua.State) int {
+	co := getco(state)
+	switch status := auxStatus(state, co); status {
+	case "dead", "suspended":
+		if err := co.CloseThread(); err != nil {
+			state.Push(false)
+			state.PushError(err)
+			return 2
+		}
+		state.Push(true)
+		return 1
+	default:
+		return state.Errorf("cannot close a %s coroutine", status)
+	}
+}
+
+// getco returns the coroutine given as first argument.
+func getco(state *lua.State) *lua.State {
+	co := state.ToThread(1)
+	state.ArgCheck(co != nil, 1, "coroutine expected")
+	return co
+}
+
+// auxResume resumes the coroutine co with the top nargs values of the
+// stack, and moves the values it yields or returns onto the stack,
+// returning their number.
+func auxResume(state, co *lua.State, nargs int) (int, error) {
+	if co.Status() == lua.ThreadOK && co.Top() == 0 || co.Status() == lua.ThreadError {
+		state.PopN(nargs)
+		return 0, fmt.Errorf("cannot resume dead coroutine")
+	}
+	state.XMove(co, nargs)
+	if _, err := co.Resume(state, nargs); err != nil {
+		return 0, err
+	}
+	nres := co.Top()
+	co.XMove(state, nres) // move yielded values
+	return nres, nil
 }
 
-func unimplemented(msg string) { panic(fmt.Errorf(msg)) }
+// auxStatus returns the status of the coroutine co as seen from the thread
+// state.
+func auxStatus(state, co *lua.State) string {
+	if state == co {
+		return "running"
+	}
+	switch co.Status() {
+	case lua.ThreadYield:
+		return "suspended"
+	case lua.ThreadOK:
+		var debug lua.Debug
+		if co.GetStack(&debug, 0) == nil { // does it have frames?
+			return "normal" // it is running
+		}
+		if co.Top() == 0 {
+			return "dead"
+		}
+		return "suspended" // initial state
+	default: // some error occurred
+		return "dead"
+	}
+}
@@ -101,14 +101,17 @@ func dbgGetInfo(state *lua.State) int {
 		state.PushIndex(index + 1)
 		state.XMove(thread, 1)
 	} else {
-		if err := state.GetStack(&dbg, int(state.CheckInt(index+1))); err != nil {
+		if err := thread.GetStack(&dbg, int(state.CheckInt(index+1))); err != nil {
 			state.Push(nil)
 			return 1
 		}
 	}
-	if err := state.GetInfo(&dbg, options); err != nil {
+	if err := thread.GetInfo(&dbg, options); err != nil {
 		panic(fmt.Errorf("bad argument #2 to 'getinfo' %v", err))
 	}
+	if thread != state && contains(options, 'f') {
+		thread.XMove(state, 1) // function pushed by GetInfo
+	}
 	state.NewTable()
 	if contains(options, 'S') {
 		setFieldStr(state, "source", dbg.Source())
@@ -129,14 +132,15 @@ func dbgGetInfo(state *lua.State) int {
 		setFieldStr(state, "name", dbg.Name())
 		setFieldStr(state, "namewhat", dbg.NameWhat())
 	}
-	if contains(options, 'T') {
+	if contains(options, 't') {
 		setFieldBool(state, "istailcall", dbg.IsTailCall())
 	}
 	if contains(options, 'L') {
 		fmt.Println("debug.getinfo: option 'L': TODO")
 	}
 	if contains(options, 'f') {
-		fmt.Println("debug.getinfo: option 'f': TODO")
+		state.PushIndex(-2) // function pushed by GetInfo
+		state.SetField(-2, "func")
 	}
 	fmt.Println()
 	return 1
@@ -334,8 +338,18 @@ func dbgSetUserValue(state *lua.State) int {
 //
 // See https://www.lua.org/manual/5.3/manual.html#pdf-debug.traceback
 func dbgTraceback(state *lua.State) int {
-	unimplemented("debug: traceback")
-	return 0
+	thread, arg := getThread(state)
+	if !state.IsString(arg+1) && !state.IsNoneOrNil(arg+1) {
+		state.PushIndex(arg + 1) // return message untouched
+		return 1
+	}
+	level := 0
+	if thread == state {
+		level = 1
+	}
+	msg := state.OptString(arg+1, "")
+	state.Traceback(thread, msg, int(state.OptInt(arg+2, int64(level))))
+	return 1
 }
 
 // debug.upvalueid (f, n)
@@ -375,7 +389,7 @@ func dbgUpValueJoin(state *lua.State) int {
 	return 0
 }
 
-func unimplemented(msg string) { panic(fmt.Errorf(msg)) }
+func unimplemented(msg string) { panic(fmt.Errorf("%s", msg)) }
 
 func contains(options string, option byte) bool {
 	return strings.IndexByte(options, option) != -1
@@ -169,9 +169,16 @@ func fileWrite(state *lua.State) int {
 	return 0
 }
 
+// file:__gc ()
+//
+// Closes the file of a handle that is collected while still open; the
+// standard files are left open, and errors are ignored.
+//
 // See https://www.lua.org/manual/5.3/manual.html#pdf-file:__gc
 func fileGC(state *lua.State) int {
-	unimplemented("file:__gc")
+	if stream := toStream(state); stream.close != nil && stream.file != nil {
+		closer(state)
+	}
 	return 0
 }
 
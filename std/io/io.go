@@ -257,4 +257,4 @@ func ioWrite(state *lua.State) int {
 	return 0
 }
 
-func unimplemented(msg string) { panic(fmt.Errorf(msg)) }
+func unimplemented(msg string) { panic(fmt.Errorf("%s", msg)) }
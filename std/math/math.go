@@ -46,6 +46,9 @@ func Open(state *lua.State) int {
 		"type":       lua.Func(mathType),
 		"ult":        lua.Func(mathUlt),
 	}
+	if state.LuaVersion() == lua.V54 {
+		mathFuncs["random"], mathFuncs["randomseed"] = newRandom()
+	}
 	state.NewTableSize(0, len(mathFuncs))
 	state.SetFuncs(mathFuncs, 0)
 
@@ -0,0 +1,128 @@
+package math
+
+import (
+	"fmt"
+	"math/rand"
+	"time"
+
+	"github.com/Azure/golua/lua"
+)
+
+// xoshiro is the state of the xoshiro256** pseudo-random generator used by
+// math.random in Lua 5.4.
+//
+// See lua-5.4/src/lmathlib.c
+type xoshiro [4]uint64
+
+func rotl(x uint64, n uint) uint64 { return (x << n) | (x >> (64 - n)) }
+
+// next returns the next pseudo-random 64-bit value.
+func (s *xoshiro) next() uint64 {
+	var (
+		s0 = s[0]
+		s1 = s[1]
+		s2 = s[2] ^ s0
+		s3 = s[3] ^ s1
+	)
+	res := rotl(s1*5, 7) * 9
+	s[0] = s0 ^ s3
+	s[1] = s1 ^ s2
+	s[2] = s2 ^ (s1 << 17)
+	s[3] = rotl(s3, 45)
+	return res
+}
+
+// seed seeds the generator with n1 and n2, discarding the first values
+// to spread the seed.
+func (s *xoshiro) seed(n1, n2 uint64) {
+	*s = xoshiro{n1, 0xff, n2, 0} // avoid a zero state
+	for i := 0; i < 16; i++ {
+		s.next()
+	}
+}
+
+// project projects a random value ran into the interval [0, n], drawing
+// new values as needed so that the result has no bias.
+func (s *xoshiro) project(ran, n uint64) uint64 {
+	if n&(n+1) == 0 { // is 'n + 1' a power of 2?
+		return ran & n
+	}
+	// compute the smallest (2^b - 1) not smaller than n
+	lim := n
+	lim |= lim >> 1
+	lim |= lim >> 2
+	lim |= lim >> 4
+	lim |= lim >> 8
+	lim |= lim >> 16
+	lim |= lim >> 32
+	for ran &= lim; ran > n; ran &= lim {
+		ran = s.next()
+	}
+	return ran
+}
+
+// newRandom returns the Lua 5.4 math.random and math.randomseed functions,
+// sharing a xoshiro256** generator seeded randomly.
+func newRandom() (random, randomseed lua.Func) {
+	var gen xoshiro
+	gen.seed(uint64(time.Now().UnixNano()), rand.Uint64())
+
+	// math.random ([m [, n]])
+	//
+	// When called without arguments, returns a pseudo-random float with uniform
+	// distribution in the range [0,1). When called with two integers m and n,
+	// returns a pseudo-random integer with uniform distribution in the range
+	// [m, n]. The call math.random(n), for a positive n, is equivalent to
+	// math.random(1,n). The call math.random(0) produces an integer with all
+	// bits (pseudo)random.
+	//
+	// See https://www.lua.org/manual/5.4/manual.html#pdf-math.random
+	random = func(state *lua.State) int {
+		var (
+			rv     = gen.next()
+			lo, hi int64
+		)
+		switch state.Top() {
+		case 0:
+			state.Push(float64(rv>>11) * (1.0 / (1 << 53)))
+			return 1
+		case 1:
+			lo, hi = 1, state.CheckInt(1)
+			if hi == 0 { // single 0 as argument?
+				state.Push(int64(rv))
+				return 1
+			}
+		case 2:
+			lo = state.CheckInt(1)
+			hi = state.CheckInt(2)
+		default:
+			panic(fmt.Errorf("wrong number of arguments"))
+		}
+		state.ArgCheck(lo <= hi, 1, "interval is empty")
+		state.Push(int64(gen.project(rv, uint64(hi)-uint64(lo)) + uint64(lo)))
+		return 1
+	}
+
+	// math.randomseed ([x [, y]])
+	//
+	// When called with at least one argument, the integer parameters x and y
+	// are joined into a 128-bit seed; equal seeds produce equal sequences of
+	// numbers. When called with no arguments, generates a seed with a weak
+	// attempt for randomness. Returns the two seed components.
+	//
+	// See https://www.lua.org/manual/5.4/manual.html#pdf-math.randomseed
+	randomseed = func(state *lua.State) int {
+		var n1, n2 int64
+		if state.IsNone(1) {
+			n1, n2 = time.Now().UnixNano(), int64(rand.Uint64())
+		} else {
+			n1 = state.CheckInt(1)
+			n2 = state.OptInt(2, 0)
+		}
+		gen.seed(uint64(n1), uint64(n2))
+		state.Push(n1)
+		state.Push(n2)
+		return 2
+	}
+	return random, randomseed
+}
@@ -264,4 +264,4 @@ func osTmpName(state *lua.State) int {
 var epoch time.Time // start time.
 func init()         { epoch = time.Now() }
 
-func unimplemented(msg string) { panic(fmt.Errorf(msg)) }
+func unimplemented(msg string) { panic(fmt.Errorf("%s", msg)) }
@@ -342,4 +342,4 @@ func searchPath(state *lua.State, name, path, sep string) string {
 //		stdin:1: in main chunk
 //		[C]: in ?
 
-func unimplemented(msg string) { panic(fmt.Errorf(msg)) }
+func unimplemented(msg string) { panic(fmt.Errorf("%s", msg)) }
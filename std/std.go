@@ -1,8 +1,12 @@
 package std
 
 import (
+	"sort"
+
 	"github.com/Azure/golua/lua"
 	"github.com/Azure/golua/std/base"
+	"github.com/Azure/golua/std/bit"
+	"github.com/Azure/golua/std/compat"
 	"github.com/Azure/golua/std/coro"
 	"github.com/Azure/golua/std/debug"
 	"github.com/Azure/golua/std/io"
@@ -14,14 +18,41 @@ import (
 	"github.com/Azure/golua/std/utf8"
 )
 
+// library is a standard library and the function that opens it.
+type library struct {
+	Name string
+	Open lua.Func
+}
+
+// Option is an optional configuration for the standard libraries.
+type Option func(*config)
+
+// config holds the configuration for the standard libraries.
+type config struct {
+	compat51 bool
+}
+
+// WithCompat51 returns an Option that toggles the Lua 5.1 / LuaJIT
+// compatibility profile for legacy scripts.
+//
+// When enabled, Open also installs setfenv, getfenv, module, unpack,
+// loadstring, package.seeall, table.getn and math.pow, and opens the
+// LuaJIT bit library.
+func WithCompat51(enable bool) Option {
+	return func(cfg *config) {
+		cfg.compat51 = enable
+	}
+}
+
 // Open opens all standard Lua libraries into the given state.
 //
 // See https://www.lua.org/manual/5.3/manual.html#luaL_openlibs
-func Open(state *lua.State) {
-	var libs = []struct {
-		Name string
-		Open lua.Func
-	}{
+func Open(state *lua.State, opts ...Option) {
+	var cfg config
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	var libs = []library{
 		{"_G", lua.Func(base.Open)},
 		{"package", lua.Func(pkg.Open)},
 		{"coroutine", lua.Func(coro.Open)},
@@ -33,9 +64,38 @@ func Open(state *lua.State) {
 		{"utf8", lua.Func(utf8.Open)},
 		{"debug", lua.Func(debug.Open)},
 	}
+	if cfg.compat51 {
+		libs = append(libs, library{"bit", lua.Func(bit.Open)})
+	}
 	for _, lib := range libs {
 		state.Logf("opening stdlib mode %q", lib.Name)
 		state.Require(lib.Name, lib.Open, true)
 		state.Pop()
 	}
+	if cfg.compat51 {
+		state.Logf("opening Lua 5.1 compatibility")
+		state.PushClosure(compat.Open, 0)
+		state.Call(0, 0)
+	}
+}
+
+// Globals returns the sorted names of the global variables set by Open
+// with the given options.
+func Globals(opts ...Option) []string {
+	state := lua.NewState()
+	defer state.Close()
+	Open(state, opts...)
+
+	var names []string
+	state.PushGlobals()
+	state.Push(nil)
+	for state.Next(-2) {
+		if state.TypeAt(-2) == lua.StringType {
+			names = append(names, state.ToString(-2))
+		}
+		state.Pop()
+	}
+	state.Pop()
+	sort.Strings(names)
+	return names
 }
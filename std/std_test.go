@@ -0,0 +1,422 @@
+package std
+
+import (
+	"context"
+	"errors"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Azure/golua/lua"
+)
+
+func TestCompat51(t *testing.T) {
+	var tests = []struct {
+		source string
+		result string
+	}{
+		{"return select('#', unpack({1, 2, 3}))", "3"},
+		{"return table.getn({1, 2, 3, 4}) + math.pow(2, 10)", "1028"},
+		{"return loadstring('return 1 + 1')()", "2"},
+		{"return bit.tohex(bit.bor(bit.band(0xff, 0x0f), bit.lshift(1, 31)))", "8000000f"},
+		{"return bit.tobit(0xffffffff) + bit.arshift(-256, 4) + bit.rshift(-1, 28)", "-2"},
+		{"return bit.tohex(bit.bswap(0x12345678), -8) .. bit.tohex(bit.rol(0x12345678, 8), 4)", "78563412" + "7812"},
+		{`x = 1
+		  local function f() return x end
+		  local function g() return x end
+		  assert(setfenv(f, {x = 2}) == f)
+		  return f() + g() * 10`, "12"},
+		{`local function f() setfenv(1, {x = 3}) return x end
+		  return f()`, "3"},
+		{`local env, getfenv = {}, getfenv
+		  local function f() return getfenv(1), x end
+		  setfenv(f, env)
+		  return f() == env and getfenv(print) == _G and getfenv(0) == _G`, "true"},
+		{`local f = loadstring("module('a.b', package.seeall); function f() return _NAME .. ' ' .. _PACKAGE end")
+		  f()
+		  return a.b.f() .. tostring(package.loaded['a.b'] == a.b)`, "a.b a.true"},
+		{"return (pcall(setfenv, print, {}))", "false"},
+	}
+	for _, test := range tests {
+		state := lua.NewState()
+		Open(state, WithCompat51(true))
+		if err := run(t, state, test.source, 1); err != nil {
+			t.Errorf("exec %q: %v", test.source, err)
+			continue
+		}
+		if got := state.ToString(-1); got != test.result {
+			t.Errorf("exec %q: got %s, want %s", test.source, got, test.result)
+		}
+	}
+
+	state := newTestState()
+	for _, name := range []string{"setfenv", "getfenv", "module", "unpack", "loadstring", "bit"} {
+		if state.GetGlobal(name); !state.IsNoneOrNil(-1) {
+			t.Errorf("%s is defined without the compatibility profile", name)
+		}
+		state.Pop()
+	}
+}
+
+func TestCoroutines(t *testing.T) {
+	var tests = []struct {
+		source string
+		result string
+	}{
+		{`local function gen(n)
+		    return coroutine.wrap(function() for i = 1, n do coroutine.yield(i) end end)
+		  end
+		  local s = 0
+		  for i in gen(10) do s = s + i end
+		  return s`, "55"},
+		{`local co = coroutine.create(function(a, b)
+		    local c = coroutine.yield(a + b)
+		    local d, e = coroutine.yield(c * 2)
+		    return d .. e
+		  end)
+		  local _, x = coroutine.resume(co, 1, 2)
+		  local _, y = coroutine.resume(co, 10)
+		  local _, z = coroutine.resume(co, "a", "b")
+		  return x .. y .. z .. coroutine.status(co)`, "320abdead"},
+		// yields across pcall and metamethods
+		{`local co = coroutine.wrap(function()
+		    local ok, v = pcall(function() return coroutine.yield(1) + 1 end)
+		    local t = setmetatable({}, {__index = function(_, k) return coroutine.yield(k) end})
+		    return tostring(ok) .. v .. t.key
+		  end)
+		  return co() .. co(41) .. co("!")`, "1keytrue42!"},
+		{`local co = coroutine.create(function() error("boom") end)
+		  local ok, err = coroutine.resume(co)
+		  return tostring(ok) .. " " .. err:match("boom") .. " " .. coroutine.status(co) .. " " .. select(2, coroutine.resume(co))`,
+			"false boom dead cannot resume dead coroutine"},
+		{`local main, ismain = coroutine.running()
+		  local co
+		  co = coroutine.create(function()
+		    local inner = coroutine.create(function() return coroutine.status(co) end)
+		    local self, ismain = coroutine.running()
+		    return select(2, coroutine.resume(inner)), self == co, ismain, coroutine.isyieldable()
+		  end)
+		  local _, st, same, im, y = coroutine.resume(co)
+		  return table.concat({coroutine.status(co), st, tostring(same), tostring(im), tostring(y), tostring(ismain), tostring(coroutine.isyieldable())}, " ")`,
+			"dead normal true false true true false"},
+		{`return select(2, pcall(coroutine.yield, 1))`, "attempt to yield from outside a coroutine"},
+		// no yields across Go functions calling Lua without a continuation
+		{`local co = coroutine.wrap(function()
+		    local _, a = pcall(pairs, setmetatable({}, {__pairs = coroutine.yield}))
+		    local _, b = pcall(tostring, setmetatable({}, {__tostring = coroutine.yield}))
+		    local y
+		    tostring(setmetatable({}, {__tostring = function() y = coroutine.isyieldable(); return "" end}))
+		    return table.concat({a, b, tostring(y), tostring(coroutine.isyieldable())}, " ")
+		  end)
+		  return co()`, "attempt to yield across a C-call boundary attempt to yield across a C-call boundary false true"},
+		{`local co = coroutine.wrap(function() error("oops") end)
+		  return (select(2, pcall(co)):match("oops"))`, "oops"},
+		// error objects are not converted to strings
+		{`local co = coroutine.create(function() error({code = 1}) end)
+		  local ok, err = coroutine.resume(co)
+		  return tostring(ok) .. " " .. err.code`, "false 1"},
+		{`local co = coroutine.wrap(function()
+		    local _, err = pcall(function() coroutine.yield(); error({code = 2}) end)
+		    return err.code
+		  end)
+		  co()
+		  return co()`, "2"},
+		{`local _, err = pcall(coroutine.wrap(function() error({code = 3}) end))
+		  return err.code .. select(2, pcall(error, 4)) + 1 .. tostring(select(2, pcall(error)))`, "35nil"},
+	}
+	for _, test := range tests {
+		state := newTestState()
+		if err := run(t, state, test.source, 1); err != nil {
+			t.Errorf("exec %q: %v", test.source, err)
+			continue
+		}
+		if got := state.ToString(-1); got != test.result {
+			t.Errorf("exec %q: got %s, want %s", test.source, got, test.result)
+		}
+	}
+}
+
+func TestCoroutineRelease(t *testing.T) {
+	var tests = []struct {
+		source string
+		closed bool // released only by Close
+	}{
+		{"for i = 1, 1000 do local f = coroutine.wrap(function() coroutine.yield(i) end); f() end; collectgarbage()", false},
+		{"gens = {}; for i = 1, 1000 do gens[i] = coroutine.wrap(function() coroutine.yield(i) end); gens[i]() end; collectgarbage()", true},
+		{"for i = 1, 1000 do coroutine.resume(coroutine.create(coroutine.yield)) end; collectgarbage()", false},
+	}
+	for _, test := range tests {
+		n := runtime.NumGoroutine()
+		state := newTestState()
+		if err := run(t, state, test.source, 0); err != nil {
+			t.Errorf("exec %q: %v", test.source, err)
+			continue
+		}
+		if test.closed {
+			if got := runtime.NumGoroutine(); got < n+1000 {
+				t.Errorf("exec %q: %d goroutines left, want 1000", test.source, got-n)
+			}
+		} else if got := waitGoroutines(n); got > n {
+			t.Errorf("exec %q: %d goroutines left", test.source, got-n)
+		}
+		state.Close()
+		if got := waitGoroutines(n); got > n {
+			t.Errorf("close %q: %d goroutines left", test.source, got-n)
+		}
+	}
+
+	// as in Lua, the to-be-closed variables of a released coroutine are not closed
+	state := newTestState(lua.WithVersion(lua.V54))
+	const source = `log = ""
+	  local f = coroutine.wrap(function() local x <close> = setmetatable({}, {__close = function() log = "closed" end}); coroutine.yield() end)
+	  f(); f = nil; collectgarbage()
+	  return log`
+	if err := run(t, state, source, 1); err != nil {
+		t.Fatalf("exec %q: %v", source, err)
+	}
+	if got := state.ToString(-1); got != "" {
+		t.Errorf("exec %q: got %s, want nothing", source, got)
+	}
+}
+
+// waitGoroutines waits for the number of goroutines to drop to n, for up to a
+// second, and returns it.
+func waitGoroutines(n int) int {
+	for i := 0; i < 100 && runtime.NumGoroutine() > n; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	return runtime.NumGoroutine()
+}
+
+func TestContext(t *testing.T) {
+	var tests = []string{
+		"local co = coroutine.wrap(function() while true do end end); while true do pcall(co) end",
+		"while true do coroutine.resume(coroutine.create(function() while true do end end)) end",
+	}
+	for _, source := range tests {
+		state := newTestState()
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		err := state.ExecContext(ctx, "=test", source, lua.TextMode)
+		cancel()
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("exec %q: got %v, want %v", source, err, context.DeadlineExceeded)
+		}
+	}
+}
+
+func TestBudget(t *testing.T) {
+	var tests = []string{
+		"local s = string.rep('x', 1 << 40)",
+		"local s = string.rep('x', 1 << 20); while true do pcall(string.find, s, 'y') end",
+		"pcall(table.concat, {}, '', 1, 1 << 40)",
+		"pcall(table.concat, {}, '', math.mininteger, math.maxinteger)",
+		"coroutine.resume(coroutine.create(function() while true do end end))",
+		"coroutine.wrap(function() local s = string.rep('x', 1 << 20); s:gsub('x', 'y') end)()",
+		// backtracking patterns
+		"string.find(string.rep('a', 3000), '.-.-.-b')",
+		"pcall(string.match, string.rep('a', 3000), '(.-)(.-)(.-)b')",
+		"string.gsub(string.rep('a', 3000), 'a-a-a-b', '')",
+	}
+	for _, source := range tests {
+		state := newTestState(lua.WithInstructionLimit(1000))
+		err := run(t, state, source, 0)
+		if _, ok := err.(*lua.BudgetError); !ok {
+			t.Errorf("exec %q: got %v, want *lua.BudgetError", source, err)
+		}
+	}
+}
+
+func TestMemory(t *testing.T) {
+	var tests = []struct {
+		source string
+		result string
+	}{
+		{"return select(2, pcall(string.rep, 'x', 1 << 40))", "not enough memory"},
+		{"local t = {}; for i = 1, 100 do t[i] = string.rep('x', 1 << 16) end; return select(2, pcall(table.concat, t))", "not enough memory"},
+		{"return collectgarbage('count') > 0", "true"},
+		{"local n = collectgarbage('count'); t = {}; for i = 1, 1e4 do t[i] = {} end; return collectgarbage('count') > n + 1e3", "true"},
+		{"t = {}; for i = 1, 1e4 do t[i] = {} end; local n = collectgarbage('count'); t = nil; collectgarbage(); return collectgarbage('count') < n - 1e3", "true"},
+		{"local n = collectgarbage('count'); return n * 1024 == math.floor(n * 1024)", "true"},
+		// controls
+		{"collectgarbage('stop'); local r = collectgarbage('isrunning'); collectgarbage('restart'); return tostring(r) .. ' ' .. tostring(collectgarbage('isrunning'))", "false true"},
+		{"collectgarbage('setpause', 100); return collectgarbage('setpause', 200)", "100"},
+		{"collectgarbage('setstepmul', 10); return collectgarbage('setstepmul', 200)", "40"},
+		{"collectgarbage(); return tostring(collectgarbage('step', 1)) .. ' ' .. tostring(collectgarbage('step', 1 << 20)) .. ' ' .. tostring(collectgarbage('step'))", "false true true"},
+		{"collectgarbage('stop'); local n = 0; local mt = {__gc = function() n = n + 1 end}; for i = 1, 1e4 do setmetatable({}, mt) end; for i = 1, 1e5 do local t = {} end; local m = n; collectgarbage(); return m .. ' ' .. n", "0 10000"},
+		{"return select(2, pcall(collectgarbage, 'generational'))", "bad argument #1 (invalid option 'generational')"},
+	}
+	for _, test := range tests {
+		state := newTestState(lua.WithMemoryLimit(8 << 20))
+		if err := run(t, state, test.source, 1); err != nil {
+			t.Errorf("exec %q: %v", test.source, err)
+			continue
+		}
+		if got := state.ToStringMeta(-1); got != test.result {
+			t.Errorf("exec %q: got %s, want %s", test.source, got, test.result)
+		}
+	}
+}
+
+func TestMemoryLimit(t *testing.T) {
+	// The limit holds out of protected calls too: the chunks run by the host
+	// fail with ErrMemory, even if the state barely fits.
+	const source = "local t = {}; for i = 1, 2e6 do t[i] = {i} end"
+	for _, state := range []*lua.State{
+		lua.NewState(lua.WithMemoryLimit(100)),
+		newTestState(lua.WithMemoryLimit(1 << 20)),
+	} {
+		if err := state.ExecText(source); err != lua.ErrMemory {
+			t.Errorf("exec %q: got %v, want %v", source, err, lua.ErrMemory)
+		}
+		if usage := state.MemoryUsage(); usage > 2<<20 {
+			t.Errorf("exec %q: %d bytes in use", source, usage)
+		}
+	}
+}
+
+func TestWeakTables(t *testing.T) {
+	var tests = []struct {
+		source string
+		result string
+	}{
+		// weak keys
+		{"local t = setmetatable({}, {__mode = 'k'}); for i = 1, 100 do t[{}] = i end; collectgarbage('collect'); return next(t) == nil", "true"},
+		{"local t, k = setmetatable({}, {__mode = 'k'}), {}; t[k], t.s, t[1] = 1, 2, 3; t[{}] = 4; collectgarbage(); local n = 0; for _ in pairs(t) do n = n + 1 end; return n .. t[k]", "31"},
+		// weak values
+		{"local t = setmetatable({}, {__mode = 'v'}); for i = 1, 100 do t[i] = {} end; t[101] = 's'; collectgarbage(); return #t .. t[101]", "0s"},
+		{"local t, v = setmetatable({}, {__mode = 'v'}), {}; t.x, t.y = v, {}; local function f() return t.y end; f(); collectgarbage(); return tostring(t.x == v and f() == nil)", "true"},
+		// ephemerons
+		{"local t = setmetatable({}, {__mode = 'k'}); for i = 1, 10 do local k = {}; t[k] = {k} end; collectgarbage(); return next(t) == nil", "true"},
+		{"local t, k = setmetatable({}, {__mode = 'k'}), {}; t[k] = {k}; collectgarbage(); return tostring(t[k][1] == k)", "true"},
+		{"local t, k = setmetatable({}, {__mode = 'kv'}), {}; t[k] = {}; t[{}] = k; collectgarbage(); return next(t) == nil", "true"},
+		// changing the mode
+		{"local t = setmetatable({}, {__mode = 'v'}); t[1] = {}; setmetatable(t, nil); collectgarbage(); return #t", "1"},
+		{"local t = {{}, {}}; setmetatable(t, {__mode = 'v'}); collectgarbage(); return #t", "0"},
+	}
+	for _, test := range tests {
+		state := newTestState()
+		if err := run(t, state, test.source, 1); err != nil {
+			t.Errorf("exec %q: %v", test.source, err)
+			continue
+		}
+		if got := state.ToStringMeta(-1); got != test.result {
+			t.Errorf("exec %q: got %s, want %s", test.source, got, test.result)
+		}
+	}
+}
+
+func TestFinalizers(t *testing.T) {
+	var tests = []struct {
+		source  string
+		result  string // value of log, returned by the source
+		closed  string // value of log after Close
+		warning string
+	}{
+		// unreachable objects are finalized in the reverse order of their marking
+		{"local mt = {__gc = function(o) log = log .. o[1] end}; for i = 1, 3 do setmetatable({i}, mt) end; collectgarbage(); return log", "321", "321", ""},
+		{"local mt = {__gc = function(o) log = log .. 'u' end}; local u = setmetatable({}, mt); collectgarbage(); return log", "", "u", ""},
+		{"local mt = {__gc = function(o) saved = o end}; setmetatable({1}, mt); collectgarbage(); return saved[1]", "1", "", ""},
+		{"local mt = {__gc = function(o) log = log .. 'x'; setmetatable(o, getmetatable(o)) end}; setmetatable({}, mt); collectgarbage(); return log", "x", "xx", ""},
+		// weak values are cleared before the objects referring to them are finalized
+		{"local w = setmetatable({}, {__mode = 'v'}); do local t = {}; w[1] = t; setmetatable({t}, {__gc = function(o) log = tostring(w[1] == nil and o[1] ~= nil) end}) end; collectgarbage(); return log", "true", "true", ""},
+		// a __gc field added afterwards does not mark the object
+		{"local mt = {}; x = setmetatable({}, mt); mt.__gc = function() log = log .. 'x' end; collectgarbage(); return log", "", "", ""},
+		{"local mt = {__gc = false}; x = setmetatable({}, mt); mt.__gc = function() log = log .. 'x' end; return log", "", "x", ""},
+		// errors are emitted as warnings
+		{"setmetatable({}, {__gc = function() error('boom', 0) end}); collectgarbage(); return log", "", "", "error in __gc metamethod (boom)"},
+		{"x = setmetatable({}, {__gc = function() error('boom', 0) end}); return log", "", "", "error in __gc metamethod (boom)"},
+		// finalizers run on the main thread, and cannot yield
+		{"coroutine.wrap(function() setmetatable({}, {__gc = function() log = log .. 'g' end}); collectgarbage(); log = log .. 'c' end)(); return log", "cg", "cg", ""},
+		{"log = coroutine.wrap(function() setmetatable({}, {__gc = function() coroutine.yield('from gc') end}); collectgarbage(); return 'done' end)(); return log", "done", "done", "error in __gc metamethod (attempt to yield from outside a coroutine)"},
+	}
+	for _, test := range tests {
+		var warnings []string
+		state := newTestState()
+		state.SetWarnFunc(func(msg string, tocont bool) { warnings = append(warnings, msg) })
+		state.Push("")
+		state.SetGlobal("log")
+		if err := run(t, state, test.source, 1); err != nil {
+			t.Errorf("exec %q: %v", test.source, err)
+			continue
+		}
+		if got := state.ToStringMeta(-1); got != test.result {
+			t.Errorf("exec %q: got %s, want %s", test.source, got, test.result)
+		}
+		state.Close()
+		state.GetGlobal("log")
+		if got := state.ToString(-1); got != test.closed {
+			t.Errorf("close %q: got %s, want %s", test.source, got, test.closed)
+		}
+		if got := strings.Join(warnings, "\n"); got != test.warning {
+			t.Errorf("exec %q: got warning %q, want %q", test.source, got, test.warning)
+		}
+	}
+}
+
+func TestWarn(t *testing.T) {
+	var tests = []struct {
+		source  string
+		warning string // pieces to be continued end with '|'
+	}{
+		{`warn("@on")`, "@on"},
+		{`warn("@o", "n")`, "@o|n"},
+		{`warn("@on", "x")`, "@on|x"},
+		{`warn("a", "b", "c"); warn("d")`, "a|b|c\nd"},
+	}
+	for _, test := range tests {
+		var warning strings.Builder
+		state := newTestState(lua.WithVersion(lua.V54))
+		state.SetWarnFunc(func(msg string, tocont bool) {
+			if warning.WriteString(msg); tocont {
+				warning.WriteString("|")
+			} else {
+				warning.WriteString("\n")
+			}
+		})
+		if err := run(t, state, test.source, 0); err != nil {
+			t.Errorf("exec %q: %v", test.source, err)
+			continue
+		}
+		if got := strings.TrimSuffix(warning.String(), "\n"); got != test.warning {
+			t.Errorf("exec %q: got warning %q, want %q", test.source, got, test.warning)
+		}
+		state.Close()
+	}
+}
+
+func TestStackOverflow(t *testing.T) {
+	var tests = []string{
+		"local function f() return coroutine.wrap(f)() end; return f()",
+		"local function f() return select(2, coroutine.resume(coroutine.create(f))) end; return f()",
+	}
+	for _, source := range tests {
+		state := newTestState()
+		var msg string
+		if err := run(t, state, source, 1); err != nil {
+			msg = err.Error()
+		} else {
+			msg = state.ToStringMeta(-1) // error returned by resume
+		}
+		if !strings.HasPrefix(msg, "stack overflow") {
+			t.Errorf("exec %q: got %q, want stack overflow", source, msg)
+		}
+	}
+}
+
+// newTestState returns a new state with the standard libraries.
+func newTestState(opts ...lua.Option) *lua.State {
+	state := lua.NewState(opts...)
+	Open(state)
+	return state
+}
+
+// run loads source in state, failing the test if it does not load, and calls
+// it in protected mode, keeping rets results.
+func run(t *testing.T, state *lua.State, source string, rets int) error {
+	t.Helper()
+	if err := state.LoadText(source); err != nil {
+		t.Fatalf("load %q: %v", source, err)
+	}
+	return state.PCall(0, rets, 0)
+}
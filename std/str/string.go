@@ -228,7 +228,7 @@ func strMatch(state *lua.State) int {
 		init = 1
 	}
 	init--
-	caps := strutil.Match(s[init:], p)
+	caps := compile(state, p).Match(s[init:])
 	for caps == nil {
 		state.Push(nil)
 		return 1
@@ -270,6 +270,7 @@ func strFind(state *lua.State) int {
 	}
 	init--
 	if state.ToBool(4) {
+		state.ChargeBytes(int64(len(s) - init))
 		pos := strings.Index(s[init:], p)
 		if pos < 0 {
 			state.Push(nil)
@@ -279,7 +280,7 @@ func strFind(state *lua.State) int {
 		state.Push(init + pos + len(p))
 		return 2
 	}
-	start, end, caps, ok := find(s, p, init)
+	start, end, caps, ok := find(s, compile(state, p), init)
 	if !ok {
 		state.Push(nil)
 		return 1
@@ -343,6 +344,7 @@ func strGsub(state *lua.State) int {
 	subj := state.CheckString(1)
 	patt := state.CheckString(2)
 	upto := int(state.OptInt(4, int64(len(subj))))
+	state.ChargeBytes(int64(len(subj)))
 	var (
 		s string
 		n int
@@ -350,9 +352,9 @@ func strGsub(state *lua.State) int {
 	switch state.TypeAt(3) {
 	case lua.StringType:
 		repl := state.CheckString(3)
-		s, n = strutil.GsubStrAll(
+		s, n = strutil.GsubStrPattern(
 			subj,
-			patt,
+			compile(state, patt),
 			repl,
 			upto,
 		)
@@ -447,7 +449,12 @@ func strPack(state *lua.State) int {
 //
 // https://www.lua.org/manual/5.3/manual.html#pdf-string.rep
 func strRep(state *lua.State) int {
-	s, err := repeat(state.CheckString(1), state.OptString(3, ""), state.CheckInt(2))
+	str, sep, n := state.CheckString(1), state.OptString(3, ""), state.CheckInt(2)
+	if size := int64(len(str+sep)) * n; n > 0 && size/n == int64(len(str+sep)) {
+		state.ChargeBytes(size)
+		state.CheckMemory(size)
+	}
+	s, err := repeat(str, sep, n)
 	if err != nil {
 		state.Errorf("%v", err)
 	}
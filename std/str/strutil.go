@@ -4,7 +4,8 @@ import (
 	"fmt"
 	"strings"
 
-	strutil "github.com/Azure/golua/pkg/strings"
+	"github.com/Azure/golua/lua"
+	"github.com/Azure/golua/pkg/pattern"
 )
 
 func repeat(str, sep string, count int64) (string, error) {
@@ -78,11 +79,29 @@ func byteSlice(str string, beg, end int) (bytes []byte) {
 	return []byte(str[beg : end+1])
 }
 
-func find(s, p string, init int) (beg, end int, caps []string, ok bool) {
-	if loc := strutil.Find(s[init:], p); loc != nil {
+func find(s string, patt *pattern.Pattern, init int) (beg, end int, caps []string, ok bool) {
+	if loc := patt.MatchIndex(s[init:]); loc != nil {
 		beg = loc[0] + init + 1
 		end = loc[1] + init
 		ok = true
 	}
 	return
 }
+
+// stepsPerCharge is the number of steps of the pattern matcher charged at a
+// time, as many as the bytes scanned for the same cost (see ChargeBytes).
+const stepsPerCharge = 256
+
+// compile compiles the pattern p, charging the state for the steps of its
+// matcher: a pattern which backtracks costs more than the bytes it scans,
+// and is stopped when the instruction budget is exceeded.
+func compile(state *lua.State, p string) *pattern.Pattern {
+	patt, steps := pattern.MustCompile(p), 0
+	patt.OnStep(func() {
+		if steps++; steps == stepsPerCharge {
+			state.ChargeBytes(stepsPerCharge)
+			steps = 0
+		}
+	})
+	return patt
+}
@@ -2,6 +2,7 @@ package table
 
 import (
 	"fmt"
+	"math"
 	"strings"
 
 	"github.com/Azure/golua/lua"
@@ -48,25 +49,41 @@ func Open(state *lua.State) int {
 //
 // See https://www.lua.org/manual/5.3/manual.html#pdf-table.concat
 func tableConcat(state *lua.State) int {
-	len := length(state, 1, opRead)
+	n := length(state, 1, opRead)
 	sep := state.OptString(2, "")
 	i := state.OptInt(3, 1)
-	j := state.OptInt(4, len)
+	j := state.OptInt(4, n)
 
 	if i > j {
 		state.Push("")
 		return 1
 	}
-	buf := make([]string, j-i+1)
-	for k := i; k > 0 && k <= j; k++ {
+	// An instruction by element; j - i + 1 overflows for extreme i and j.
+	if n := j - i; n < math.MaxInt64 && n >= 0 {
+		state.Charge(n + 1)
+	} else {
+		state.Charge(math.MaxInt64)
+	}
+	var buf []string
+	for k := i; ; k++ {
 		state.GetIndex(1, k)
 		if !state.IsString(-1) {
-			state.Errorf("invalid value (%s) at index %d in table for 'concat'", state.TypeAt(-1).String(), i)
+			state.Errorf("invalid value (%s) at index %d in table for 'concat'", state.TypeAt(-1).String(), k)
 		}
-		buf[k-i] = state.ToString(-1)
+		buf = append(buf, state.ToString(-1))
 		state.Pop()
+		if k == j {
+			break
+		}
+	}
+	size := len(sep) * len(buf)
+	for _, s := range buf {
+		size += len(s)
 	}
-	state.Push(strings.Join(buf, sep))
+	state.CheckMemory(int64(size))
+	s := strings.Join(buf, sep)
+	state.ChargeBytes(int64(len(s)))
+	state.Push(s)
 	return 1
 }
 
@@ -138,6 +155,9 @@ func tableUnpack(state *lua.State) int {
 		n = int(j - i + 1)
 	)
 	const max = 1000000
+	if i > j {
+		return 0 // empty range
+	}
 	if n <= 0 || n >= max || !state.CheckStack(n) {
 		panic(fmt.Errorf("too many results to unpack"))
 	}
@@ -7,7 +7,10 @@ import (
 	"github.com/Azure/golua/lua"
 )
 
-const unicodeMax = 0x10FFFF
+const (
+	unicodeMax = 0x10FFFF
+	utf8Max    = 0x7FFFFFFF // largest code point of the original UTF-8 (Lua 5.4)
+)
 
 //
 // Lua Standard Library -- utf8
@@ -36,7 +39,10 @@ func Open(state *lua.State) int {
 	state.SetFuncs(utf8Funcs, 0)
 
 	// pattern to match a single UTF-8 character.
-	const pattern = `[\0-\x7F\xC2-\xF4][\x80-\xBF]*`
+	pattern := "[\x00-\x7F\xC2-\xF4][\x80-\xBF]*"
+	if state.LuaVersion() == lua.V54 {
+		pattern = "[\x00-\x7F\xC2-\xFD][\x80-\xBF]*"
+	}
 
 	// The pattern (a string, not a function) "[\0-\x7F\xC2-\xF4][\x80-\xBF]*" (see §6.4.1),
 	// which matches exactly one UTF-8 byte sequence, assuming that the subject is a valid
@@ -57,6 +63,16 @@ func Open(state *lua.State) int {
 //
 // See https://www.lua.org/manual/5.3/manual.html#pdf-utf8.char
 func utf8Char(state *lua.State) int {
+	if state.LuaVersion() == lua.V54 {
+		var str []byte
+		for i := 1; i <= state.Top(); i++ {
+			c := state.CheckInt(i)
+			state.ArgCheck(0 <= c && c <= utf8Max, i, "value out of range")
+			str = encodeRune(str, uint32(c))
+		}
+		state.Push(string(str))
+		return 1
+	}
 	runes := make([]rune, state.Top())
 	for i := 1; i <= state.Top(); i++ {
 		c := state.CheckInt(i)
@@ -84,26 +100,25 @@ func utf8Char(state *lua.State) int {
 // will iterate over all characters in string s, with p being the position (in bytes)
 // and c the code point of each character.
 //
-// It raises an error if it meets any invalid byte sequence.
+// It raises an error if it meets any invalid byte sequence, unless lax is
+// true (Lua 5.4 only).
 //
 // See https://www.lua.org/manual/5.3/manual.html#pdf-utf8.codes
 func utf8Codes(state *lua.State) int {
+	lax := isLax(state, 2)
 	iter := lua.Func(func(state *lua.State) int {
 		var (
 			s = state.CheckString(1)
 			n = state.ToInt(2)
 		)
-		if n > 0 && n < int64(len(s)) {
-			n++
-			for n < int64(len(s)) && isContByte(s[n]) {
-				n++
-			}
+		for n > 0 && n < int64(len(s)) && isContByte(s[n]) {
+			n++ // skip continuation bytes of the previous character
 		}
 		if n >= int64(len(s)) {
 			return 0
 		}
-		r, _ := utf8.DecodeRuneInString(s[n:])
-		if r == utf8.RuneError {
+		r, w := decodeRune(s[n:], !lax)
+		if w == 0 {
 			panic(fmt.Errorf("invalid UTF-8 code"))
 		}
 		state.Push(n + 1)
@@ -121,9 +136,9 @@ func utf8Codes(state *lua.State) int {
 //
 // Returns the codepoints (as integers) from all characters in s that start between byte position
 // i and j (both included). The default for i is 1 and for j is i. It raises an error if it meets
-// any invalid byte sequence.
+// any invalid byte sequence, unless lax is true (Lua 5.4 only).
 //
-// codepoint(s, [i, [j]]) => returns codepoints for all characters that start in the range [i,j].
+// codepoint(s, [i, [j [, lax]]]) => returns codepoints for all characters that start in the range [i,j].
 //
 // See https://www.lua.org/manual/5.3/manual.html#pdf-utf8.codepoint
 func utf8CodePoint(state *lua.State) int {
@@ -133,6 +148,7 @@ func utf8CodePoint(state *lua.State) int {
 		j = state.OptInt(3, i)
 		n = 0
 	)
+	lax := isLax(state, 4)
 	if i = int64(strPos(len(s), int(i))); i < 1 {
 		panic(fmt.Errorf("bad argument #2 to 'codepoint' (out of range)"))
 	}
@@ -140,8 +156,8 @@ func utf8CodePoint(state *lua.State) int {
 		panic(fmt.Errorf("bad argument #3 to 'codepoint' (out of range)"))
 	}
 	for s = s[i-1:]; i <= j; {
-		r, w := utf8.DecodeRuneInString(s)
-		if r == utf8.RuneError {
+		r, w := decodeRune(s, !lax)
+		if w == 0 {
 			panic(fmt.Errorf("invalid UTF-8 code"))
 		}
 		state.Push(int64(r))
@@ -157,9 +173,10 @@ func utf8CodePoint(state *lua.State) int {
 // Returns the number of UTF-8 characters in string s that start between positions
 // i and j (both inclusive). The default for i is 1 and for j is -1. If it finds
 // any invalid byte sequence, returns a false value plus the position of the first
-// invalid byte.
+// invalid byte. If lax is true (Lua 5.4 only), any byte sequence of the
+// original UTF-8 encoding is accepted.
 //
-// utf8.len(s [, i [, j]]) --> number of characters that start in the range [i,j],
+// utf8.len(s [, i [, j [, lax]]]) --> number of characters that start in the range [i,j],
 // or nil + current position if 's' is not well formed in that interval.
 //
 // See https://www.lua.org/manual/5.3/manual.html#pdf-utf8.len
@@ -170,6 +187,7 @@ func utf8Len(state *lua.State) int {
 		j = int64(strPos(len(s), int(state.OptInt(3, -1))))
 		n = int64(0)
 	)
+	lax := isLax(state, 4)
 	state.ArgCheck(1 <= i && i <= int64(len(s))+1, 2, "initial position out of string")
 	state.ArgCheck(j <= int64(len(s)), 3, "final position out of string")
 
@@ -181,9 +199,9 @@ func utf8Len(state *lua.State) int {
 	// }
 	if i <= j {
 		for s = s[i-1 : j]; len(s) > 0; {
-			r, w := utf8.DecodeRuneInString(s)
-			if r == utf8.RuneError {
-				state.Push(false)
+			_, w := decodeRune(s, !lax)
+			if w == 0 {
+				state.Push(nil)
 				state.Push(i)
 				return 2
 			}
@@ -266,6 +284,71 @@ func utf8Offset(state *lua.State) int {
 	return 1
 }
 
+// isLax reports whether the optional lax argument at index is true;
+// lax mode is only available in Lua 5.4.
+func isLax(state *lua.State, index int) bool {
+	return state.LuaVersion() == lua.V54 && state.ToBool(index)
+}
+
+// decodeRune decodes the UTF-8 sequence at the start of s, returning the
+// code point and its width in bytes; the width is 0 if the sequence is
+// invalid. Unless strict, the original UTF-8 encoding is accepted, with
+// sequences of up to 6 bytes and code points up to 2^31, surrogates
+// included (the lax mode of Lua 5.4).
+//
+// See lua-5.4/src/lutf8lib.c
+func decodeRune(s string, strict bool) (rune, int) {
+	limits := [...]uint32{^uint32(0), 0x80, 0x800, 0x10000, 0x200000, 0x4000000}
+	if len(s) == 0 {
+		return 0, 0
+	}
+	c := uint32(s[0])
+	if c < 0x80 {
+		return rune(c), 1
+	}
+	var res uint32
+	count := 0
+	for ; c&0x40 != 0; c <<= 1 {
+		if count++; count >= len(s) || !isContByte(s[count]) {
+			return 0, 0
+		}
+		res = res<<6 | uint32(s[count]&0x3F)
+	}
+	res |= (c & 0x7F) << uint(count*5)
+	if count > 5 || res > utf8Max || res < limits[count] {
+		return 0, 0
+	}
+	if strict && (res > unicodeMax || (0xD800 <= res && res <= 0xDFFF)) {
+		return 0, 0
+	}
+	return rune(res), count + 1
+}
+
+// encodeRune appends to buf the UTF-8 encoding of code point x, which
+// may use the original encoding of up to 6 bytes.
+func encodeRune(buf []byte, x uint32) []byte {
+	if x < 0x80 { // ascii?
+		return append(buf, byte(x))
+	}
+	var (
+		tmp [6]byte
+		n   = len(tmp)
+		mfb = uint32(0x3f) // maximum that fits in first byte
+	)
+	for { // add continuation bytes
+		n--
+		tmp[n] = byte(0x80 | (x & 0x3f))
+		x >>= 6   // remove added bits
+		mfb >>= 1 // one less bit available in first byte
+		if x <= mfb {
+			break
+		}
+	}
+	n--
+	tmp[n] = byte((^mfb << 1) | x) // add first byte
+	return append(buf, tmp[n:]...)
+}
+
 // isContByte reports whether b is a continuation byte.
 func isContByte(b byte) bool { return b&0xC0 == 0x80 }
 